@@ -0,0 +1,338 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/collectors"
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnstap-protobuf"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+// number of frames generated before the benchmark, sent in loop
+const benchFramesPool = 8192
+
+// top level domains of the generated qnames
+var benchTlds = []string{"com", "net", "org", "fr", "co.uk", "github.io"}
+
+type benchOptions struct {
+	duration   time.Duration
+	rate       int
+	qnames     int
+	zipf       float64
+	malformed  float64
+	seed       int64
+	cpuProfile string
+	memProfile string
+}
+
+// newBenchOptions registers the options of the benchmark mode
+func newBenchOptions(fs *flag.FlagSet) *benchOptions {
+	opts := &benchOptions{}
+	fs.DurationVar(&opts.duration, "bench-duration", 10*time.Second, "Duration of the benchmark")
+	fs.IntVar(&opts.rate, "bench-rate", 0, "Frames sent per second, 0 as fast as possible")
+	fs.IntVar(&opts.qnames, "bench-qnames", 10000, "Number of distinct qnames")
+	fs.Float64Var(&opts.zipf, "bench-zipf", 1.1, "Zipf exponent of the qnames popularity (> 1), 0 for a uniform distribution")
+	fs.Float64Var(&opts.malformed, "bench-malformed", 0, "Ratio of malformed dns payloads, between 0 and 1")
+	fs.Int64Var(&opts.seed, "bench-seed", 1, "Seed of the generator")
+	fs.StringVar(&opts.cpuProfile, "bench-cpuprofile", "", "Write a cpu profile to the file")
+	fs.StringVar(&opts.memProfile, "bench-memprofile", "", "Write a memory profile to the file at the end")
+	return opts
+}
+
+func (o *benchOptions) Check() error {
+	if o.duration <= 0 {
+		return fmt.Errorf("bench-duration must be positive")
+	}
+	if o.rate < 0 {
+		return fmt.Errorf("bench-rate must be positive or zero")
+	}
+	if o.qnames < 1 {
+		return fmt.Errorf("bench-qnames must be at least 1")
+	}
+	if o.zipf != 0 && o.zipf <= 1 {
+		return fmt.Errorf("bench-zipf must be greater than 1, or 0 for a uniform distribution")
+	}
+	if o.malformed < 0 || o.malformed > 1 {
+		return fmt.Errorf("bench-malformed must be between 0 and 1")
+	}
+	return nil
+}
+
+// benchGenerator builds synthetic dnstap frames, queries and replies of clients in 10.0.0.0/16
+type benchGenerator struct {
+	opts   *benchOptions
+	random *rand.Rand
+	zipf   *rand.Zipf
+}
+
+func newBenchGenerator(opts *benchOptions) *benchGenerator {
+	g := &benchGenerator{opts: opts, random: rand.New(rand.NewSource(opts.seed))}
+	if opts.zipf > 1 && opts.qnames > 1 {
+		g.zipf = rand.NewZipf(g.random, opts.zipf, 1, uint64(opts.qnames-1))
+	}
+	return g
+}
+
+// Qname returns a qname according to the distribution, the most popular first with zipf
+func (g *benchGenerator) Qname() string {
+	var i int
+	if g.zipf != nil {
+		i = int(g.zipf.Uint64())
+	} else {
+		i = g.random.Intn(g.opts.qnames)
+	}
+	return fmt.Sprintf("www.domain%d.%s.", i, benchTlds[i%len(benchTlds)])
+}
+
+// Frame returns a dnstap frame, the dns payload is truncated according to the malformed ratio
+func (g *benchGenerator) Frame() ([]byte, bool, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(g.Qname(), dns.TypeA)
+
+	mt := dnstap.Message_CLIENT_QUERY
+	msg := query
+	if g.random.Intn(2) == 1 {
+		mt = dnstap.Message_CLIENT_RESPONSE
+		msg = new(dns.Msg)
+		msg.SetReply(query)
+		rr, err := dns.NewRR(fmt.Sprintf("%s 300 IN A 192.0.2.%d", query.Question[0].Name, g.random.Intn(254)+1))
+		if err != nil {
+			return nil, false, err
+		}
+		msg.Answer = append(msg.Answer, rr)
+	}
+	payload, err := msg.Pack()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// a payload shorter than the dns header
+	malformed := g.random.Float64() < g.opts.malformed
+	if malformed {
+		payload = payload[:5]
+	}
+
+	dt := dnstap.Dnstap_MESSAGE
+	sf := dnstap.SocketFamily_INET
+	sp := dnstap.SocketProtocol_UDP
+	now := time.Now()
+	tsec, tnsec := uint64(now.Unix()), uint32(now.Nanosecond())
+	qport, rport := uint32(1024+g.random.Intn(64000)), uint32(53)
+
+	m := &dnstap.Message{Type: &mt, SocketFamily: &sf, SocketProtocol: &sp, QueryPort: &qport, ResponsePort: &rport}
+	m.QueryAddress = net.IPv4(10, 0, byte(g.random.Intn(256)), byte(g.random.Intn(256))).To4()
+	m.ResponseAddress = net.IPv4(10, 255, 0, 1).To4()
+	if mt == dnstap.Message_CLIENT_QUERY {
+		m.QueryMessage, m.QueryTimeSec, m.QueryTimeNsec = payload, &tsec, &tnsec
+	} else {
+		m.ResponseMessage, m.ResponseTimeSec, m.ResponseTimeNsec = payload, &tsec, &tnsec
+	}
+
+	frame, err := proto.Marshal(&dnstap.Dnstap{Identity: []byte("dnscollector-bench"), Type: &dt, Message: m})
+	return frame, malformed, err
+}
+
+// benchCollector returns the name and the config of the first dnstap collector, or of the first
+// collector if there is no dnstap collector, its transformers are applied on the frames
+func benchCollector(config *dnsutils.Config) (string, *dnsutils.Config, error) {
+	name, subcfg := "bench", config
+	for i, input := range config.Multiplexer.Collectors {
+		cfg, err := dnsutils.GetCollectorConfig(config, input)
+		if err != nil {
+			return "", nil, err
+		}
+		if i == 0 || cfg.Collectors.Dnstap.Enable {
+			name, subcfg = input.Name, cfg
+		}
+		if cfg.Collectors.Dnstap.Enable {
+			break
+		}
+	}
+	return name, subcfg, nil
+}
+
+// runBench sends synthetic dnstap frames to the dnstap processor with the transformers of the
+// configuration and a null output, then prints the throughput, the allocations and the time
+// spent in each stage
+func runBench(configPath string, opts *benchOptions, out io.Writer) int {
+	if err := opts.Check(); err != nil {
+		fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+		return 1
+	}
+
+	config, err := dnsutils.LoadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		return 1
+	}
+	if err := dnsutils.LoadPlugins(config.Global.Plugins); err != nil {
+		fmt.Fprintf(os.Stderr, "plugins error: %v\n", err)
+		return 1
+	}
+	if path := config.Global.PublicSuffix.File; len(path) > 0 {
+		if err := dnsutils.LoadPublicSuffixFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "public suffix list error: %v\n", err)
+			return 1
+		}
+	}
+	name, subcfg, err := benchCollector(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		return 1
+	}
+
+	// the frames are generated before to measure only the processing
+	generator := newBenchGenerator(opts)
+	frames := make([][]byte, benchFramesPool)
+	malformed := make([]bool, benchFramesPool)
+	for i := range frames {
+		if frames[i], malformed[i], err = generator.Frame(); err != nil {
+			fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+			return 1
+		}
+	}
+
+	// the logs of the workers are hidden, except the errors
+	lg := logger.New(false)
+	processor := collectors.NewDnstapProcessor(subcfg, lg, name)
+	processor.EnableTiming()
+
+	// null output, the messages are only counted
+	output := make(chan dnsutils.DnsMessage, 512)
+	received := make(chan [2]int)
+	go func() {
+		var n [2]int
+		for dm := range output {
+			n[0]++
+			if dm.DNS.MalformedPacket {
+				n[1]++
+			}
+		}
+		received <- n
+	}()
+
+	if len(opts.cpuProfile) > 0 {
+		fd, err := os.Create(opts.cpuProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+			return 1
+		}
+		defer fd.Close()
+		if err := pprof.StartCPUProfile(fd); err != nil {
+			fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+			return 1
+		}
+	}
+
+	runtime.GC()
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	start := time.Now()
+
+	go processor.Run([]chan dnsutils.DnsMessage{output})
+
+	// the frames are copied in the buffers of the pool, as read by the collector
+	sent, sentMalformed := 0, 0
+	send := func() {
+		i := sent % benchFramesPool
+		data := dnsutils.GetBuffer(len(frames[i]))
+		copy(data, frames[i])
+		processor.GetChannel() <- data
+		if malformed[i] {
+			sentMalformed++
+		}
+		sent++
+	}
+
+	deadline := start.Add(opts.duration)
+	if opts.rate > 0 {
+		// the frames are sent by bursts every 10ms to follow the rate
+		ticker := time.NewTicker(10 * time.Millisecond)
+		for now := range ticker.C {
+			if now.After(deadline) {
+				break
+			}
+			expected := int(float64(opts.rate) * now.Sub(start).Seconds())
+			for sent < expected {
+				send()
+			}
+		}
+		ticker.Stop()
+	} else {
+		for time.Now().Before(deadline) {
+			for i := 0; i < 512; i++ {
+				send()
+			}
+		}
+	}
+
+	// wait the end of the processing
+	processor.Stop()
+	close(output)
+	messages := <-received
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&memAfter)
+	if len(opts.cpuProfile) > 0 {
+		pprof.StopCPUProfile()
+	}
+	if len(opts.memProfile) > 0 {
+		fd, err := os.Create(opts.memProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+			return 1
+		}
+		defer fd.Close()
+		if err := pprof.WriteHeapProfile(fd); err != nil {
+			fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+			return 1
+		}
+	}
+
+	var total collectors.DnstapWorkerStats
+	workers := processor.Stats()
+	for _, stats := range workers {
+		total.Decoded += stats.Decoded
+		total.Dropped += stats.Dropped
+		total.Errors += stats.Errors
+		total.UnmarshalTime += stats.UnmarshalTime
+		total.DecodeTime += stats.DecodeTime
+		total.TransformTime += stats.TransformTime
+		total.DispatchTime += stats.DispatchTime
+	}
+
+	perFrame := func(value uint64) float64 {
+		if sent == 0 {
+			return 0
+		}
+		return float64(value) / float64(sent)
+	}
+	stage := func(ns uint64) time.Duration { return time.Duration(perFrame(ns)) }
+
+	fmt.Fprintf(out, "pipeline: collector [%s] with %d decoding worker(s)\n", name, len(workers))
+	fmt.Fprintf(out, "duration: %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(out, "frames: %d sent, %d malformed\n", sent, sentMalformed)
+	fmt.Fprintf(out, "messages: %d received by the output (%d malformed), %d dropped by the transformers, %d dnstap errors\n",
+		messages[0], messages[1], total.Dropped, total.Errors)
+	fmt.Fprintf(out, "throughput: %.0f msgs/sec\n", float64(messages[0])/elapsed.Seconds())
+	fmt.Fprintf(out, "allocations: %.0f bytes/frame, %.1f allocs/frame, %d gc cycles, %s gc pause\n",
+		perFrame(memAfter.TotalAlloc-memBefore.TotalAlloc), perFrame(memAfter.Mallocs-memBefore.Mallocs),
+		memAfter.NumGC-memBefore.NumGC, time.Duration(memAfter.PauseTotalNs-memBefore.PauseTotalNs))
+	fmt.Fprintf(out, "stages (average time per frame of the workers):\n")
+	fmt.Fprintf(out, "  unmarshal: %s\n", stage(total.UnmarshalTime))
+	fmt.Fprintf(out, "  decode:    %s\n", stage(total.DecodeTime))
+	fmt.Fprintf(out, "  transform: %s\n", stage(total.TransformTime))
+	fmt.Fprintf(out, "  dispatch:  %s\n", stage(total.DispatchTime))
+	return 0
+}
@@ -2,6 +2,7 @@ package collectors
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -17,21 +18,24 @@ func GetFakeDns() ([]byte, error) {
 }
 
 type DnsProcessor struct {
-	done     chan bool
-	recvFrom chan dnsutils.DnsMessage
-	logger   *logger.Logger
-	config   *dnsutils.Config
-	name     string
+	done       chan bool
+	configChan chan *dnsutils.Config
+	recvFrom   chan dnsutils.DnsMessage
+	logger     *logger.Logger
+	config     *dnsutils.Config
+	name       string
+	batchTo    []chan []dnsutils.DnsMessage
 }
 
 func NewDnsProcessor(config *dnsutils.Config, logger *logger.Logger, name string) DnsProcessor {
 	logger.Info("[%s] processor dns - initialization...", name)
 	d := DnsProcessor{
-		done:     make(chan bool),
-		recvFrom: make(chan dnsutils.DnsMessage, 512),
-		logger:   logger,
-		config:   config,
-		name:     name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		recvFrom:   make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		config:     config,
+		name:       name,
 	}
 
 	d.ReadConfig()
@@ -41,6 +45,11 @@ func NewDnsProcessor(config *dnsutils.Config, logger *logger.Logger, name string
 
 func (d *DnsProcessor) ReadConfig() {}
 
+// ReloadConfig reloads the transformers of the processor
+func (d *DnsProcessor) ReloadConfig(config *dnsutils.Config) {
+	dnsutils.SendConfig(d.configChan, config)
+}
+
 func (c *DnsProcessor) LogInfo(msg string, v ...interface{}) {
 	c.logger.Info("["+c.name+"] dns processor - "+msg, v...)
 }
@@ -67,71 +76,167 @@ func (d *DnsProcessor) Stop() {
 	close(d.done)
 }
 
+// SetBatchChannels sets the channels of the loggers receiving slices of messages
+func (d *DnsProcessor) SetBatchChannels(batchTo []chan []dnsutils.DnsMessage) {
+	d.batchTo = batchTo
+}
+
 func (d *DnsProcessor) Run(sendTo []chan dnsutils.DnsMessage) {
 
+	// prepare the dispatcher to loggers
+	dispatcher := dnsutils.NewDispatcher(sendTo, d.batchTo, d.config.Global.Batch.Size,
+		time.Duration(d.config.Global.Batch.FlushInterval)*time.Millisecond)
+
 	// prepare enabled transformers
-	subprocessors := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, sendTo)
+	subprocessors := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, dispatcher.Channels())
 
 	// read incoming dns message
 	d.LogInfo("running... waiting incoming dns message")
-	for dm := range d.recvFrom {
-		// init dns message with additionnals parts
-		subprocessors.InitDnsMessageFormat(&dm)
-
-		// compute timestamp
-		dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
-		ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
-		dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
-
-		// decode the dns payload
-		dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
-		if err != nil {
-			dm.DNS.MalformedPacket = true
-			d.LogError("dns parser malformed packet: %s - %v+", err, dm)
-		}
+LOOP:
+	for {
+		select {
+		case cfg := <-d.configChan:
+			// reload the transformers, the held messages are sent before and
+			// the messages in the channel are kept
+			subprocessors.Flush()
+			subprocessors.ReloadConfig(&cfg.IngoingTransformers)
+
+		case dm, opened := <-d.recvFrom:
+			if !opened {
+				break LOOP
+			}
+			// init dns message with additionnals parts
+			subprocessors.InitDnsMessageFormat(&dm)
+
+			// compute timestamp
+			dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
+			ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+			dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
+
+			// decode the dns payload
+			dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
+			if err != nil {
+				dm.DNS.MalformedPacket = true
+				d.LogError("dns parser malformed packet: %s - %v+", err, dm)
+				dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_DECODE_ERROR, Worker: d.name, Count: 1,
+					Message: "dns parser malformed packet: " + err.Error()})
+			}
 
-		// dns reply ?
-		if dnsHeader.Qr == 1 {
-			dm.DnsTap.Operation = "CLIENT_RESPONSE"
-			dm.DNS.Type = dnsutils.DnsReply
-			qip := dm.NetworkInfo.QueryIp
-			qport := dm.NetworkInfo.QueryPort
-			dm.NetworkInfo.QueryIp = dm.NetworkInfo.ResponseIp
-			dm.NetworkInfo.QueryPort = dm.NetworkInfo.ResponsePort
-			dm.NetworkInfo.ResponseIp = qip
-			dm.NetworkInfo.ResponsePort = qport
-		} else {
-			dm.DNS.Type = dnsutils.DnsQuery
-			dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_QUERY
-		}
+			// dns reply ? the operation provided by the collector is kept
+			operation := dm.DnsTap.Operation
+			if dnsHeader.Qr == 1 {
+				dm.DnsTap.Operation = "CLIENT_RESPONSE"
+				dm.DNS.Type = dnsutils.DnsReply
+				qip := dm.NetworkInfo.QueryIp
+				qport := dm.NetworkInfo.QueryPort
+				dm.NetworkInfo.QueryIp = dm.NetworkInfo.ResponseIp
+				dm.NetworkInfo.QueryPort = dm.NetworkInfo.ResponsePort
+				dm.NetworkInfo.ResponseIp = qip
+				dm.NetworkInfo.ResponsePort = qport
+			} else {
+				dm.DNS.Type = dnsutils.DnsQuery
+				dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_QUERY
+			}
+			if operation != "-" && len(operation) > 0 {
+				dm.DnsTap.Operation = operation
+			}
 
-		if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
-			d.LogError("%v - %v", err, dm)
-		}
+			if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
+				d.LogError("%v - %v", err, dm)
+			}
 
-		if dm.DNS.MalformedPacket {
-			if d.config.Global.Trace.LogMalformed {
-				d.LogInfo("payload: %v", dm.DNS.Payload)
+			// probe without reply
+			if dm.Probe != nil && len(dm.Probe.Error) > 0 {
+				dm.DNS.Rcode = dnsutils.DNS_RCODE_TIMEOUT
 			}
-		}
 
-		// apply all enabled transformers
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
-		}
+			if dm.DNS.MalformedPacket {
+				if d.config.Global.Trace.LogMalformed {
+					d.LogInfo("payload: %v", dm.DNS.Payload)
+				}
+			}
+
+			// the raw payload is not needed anymore
+			if d.config.Global.DropPayload {
+				dm.DNS.Payload = nil
+			}
 
-		// convert latency to human
-		dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+			// add the label of the collector
+			dm.SetListener(d.name, d.config.Collectors.Label)
+
+			// apply all enabled transformers
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
 
-		// dispatch dns message to all generators
-		for i := range sendTo {
-			sendTo[i] <- dm
+			// convert latency to human
+			dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+
+			// dispatch dns message to all generators
+			dispatcher.Dispatch(dm)
 		}
 	}
 
-	// cleanup transformers
+	// send the messages held by the transformers and cleanup them
+	subprocessors.Flush()
 	subprocessors.Reset()
 
+	// send pending batches
+	dispatcher.Stop()
+
 	// dnstap channel consumer closed
 	d.done <- true
 }
+
+// ConfigReloader is implemented by the processors which can reload their transformers
+type ConfigReloader interface {
+	ReloadConfig(config *dnsutils.Config)
+}
+
+// Processors keeps track of the running processors of a collector to forward them the new
+// config on reload, the processors started after a reload are created with the last config
+type Processors struct {
+	sync.Mutex
+	config  *dnsutils.Config
+	running map[ConfigReloader]bool
+}
+
+func NewProcessors(config *dnsutils.Config) *Processors {
+	return &Processors{config: config, running: make(map[ConfigReloader]bool)}
+}
+
+// Config returns the config to use for the new processors
+func (p *Processors) Config() *dnsutils.Config {
+	p.Lock()
+	defer p.Unlock()
+	return p.config
+}
+
+func (p *Processors) Add(processor ConfigReloader) {
+	p.Lock()
+	defer p.Unlock()
+	p.running[processor] = true
+}
+
+// Remove must be called before stopping the processor
+func (p *Processors) Remove(processor ConfigReloader) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.running, processor)
+}
+
+// ReloadConfig forwards the config to the running processors, without holding the lock
+// to not block the processors starting or stopping meanwhile
+func (p *Processors) ReloadConfig(config *dnsutils.Config) {
+	p.Lock()
+	p.config = config
+	running := make([]ConfigReloader, 0, len(p.running))
+	for processor := range p.running {
+		running = append(running, processor)
+	}
+	p.Unlock()
+
+	for _, processor := range running {
+		processor.ReloadConfig(config)
+	}
+}
@@ -1,17 +1,16 @@
 package collectors
 
 import (
-	"bufio"
 	"crypto/tls"
 	"io"
 	"net"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/netlib"
-	"github.com/dmachard/go-framestream"
 	"github.com/dmachard/go-logger"
 )
 
@@ -34,27 +33,33 @@ func Close(conn io.Closer) error {
 	return nil
 }
 
+// connDrainTimeout is the time given to the connections to send their last frames on stop
+const connDrainTimeout = 2 * time.Second
+
 type Dnstap struct {
-	done     chan bool
-	listen   net.Listener
-	conns    []net.Conn
-	sockPath string
-	loggers  []dnsutils.Worker
-	config   *dnsutils.Config
-	logger   *logger.Logger
-	name     string
-	connMode string
-	stopping bool
+	done       chan bool
+	listen     net.Listener
+	conns      []net.Conn
+	sockPath   string
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	connMode   string
+	stopping   bool
+	processors *Processors
+	handlers   sync.WaitGroup
 }
 
 func NewDnstap(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *Dnstap {
 	logger.Info("[%s] dnstap collector - enabled", name)
 	s := &Dnstap{
-		done:    make(chan bool),
-		config:  config,
-		loggers: loggers,
-		logger:  logger,
-		name:    name,
+		done:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		processors: NewProcessors(config),
 	}
 	s.ReadConfig()
 	return s
@@ -67,11 +72,11 @@ func (c *Dnstap) SetLoggers(loggers []dnsutils.Worker) {
 }
 
 func (c *Dnstap) Loggers() []chan dnsutils.DnsMessage {
-	channels := []chan dnsutils.DnsMessage{}
-	for _, p := range c.loggers {
-		channels = append(channels, p.Channel())
-	}
-	return channels
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *Dnstap) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
 }
 
 func (c *Dnstap) ReadConfig() {
@@ -79,6 +84,14 @@ func (c *Dnstap) ReadConfig() {
 		c.logger.Fatal("collector dnstap - invalid tls min version")
 	}
 
+	if c.config.Collectors.Dnstap.MaxFrameSize < 0 {
+		c.logger.Fatal("collector dnstap - invalid max frame size")
+	}
+
+	if mode := c.config.Collectors.Dnstap.TransferMode; mode != dnsutils.TRANSFER_RECORDS && mode != dnsutils.TRANSFER_SUMMARY {
+		c.logger.Fatal("collector dnstap - invalid transfer mode")
+	}
+
 	c.sockPath = c.config.Collectors.Dnstap.SockPath
 
 	if len(c.config.Collectors.Dnstap.SockPath) > 0 {
@@ -90,6 +103,11 @@ func (c *Dnstap) ReadConfig() {
 	}
 }
 
+func (c *Dnstap) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
+}
+
 func (c *Dnstap) LogInfo(msg string, v ...interface{}) {
 	c.logger.Info("["+c.name+"] dnstap collector - "+msg, v...)
 }
@@ -99,40 +117,48 @@ func (c *Dnstap) LogError(msg string, v ...interface{}) {
 }
 
 func (c *Dnstap) HandleConn(conn net.Conn) {
+	defer c.handlers.Done()
+
 	// close connection on function exit
 	defer conn.Close()
 
 	// get peer address
 	peer := conn.RemoteAddr().String()
 	c.LogInfo("new connection from %s\n", peer)
+	dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_CONNECT, Worker: c.name, Peer: peer})
 
 	// start dnstap subprocessor
-	dnstapProcessor := NewDnstapProcessor(c.config, c.logger, c.name)
+	dnstapProcessor := NewDnstapProcessor(c.processors.Config(), c.logger, c.name)
+	dnstapProcessor.SetBatchChannels(c.BatchLoggers())
 	go dnstapProcessor.Run(c.Loggers())
+	c.processors.Add(&dnstapProcessor)
 
-	// frame stream library
-	r := bufio.NewReader(conn)
-	w := bufio.NewWriter(conn)
-	fs := framestream.NewFstrm(r, w, conn, 5*time.Second, []byte("protobuf:dnstap.Dnstap"), true)
+	// frame stream receiver, bidirectional or unidirectional according to the sender
+	fs := NewFstrmReceiver(conn, DnstapContentType, c.config.Collectors.Dnstap.MaxFrameSize)
+	fs.OnOversized = func(size int) {
+		c.LogError("%s - frame of %d bytes larger than the max frame size, skipped", peer, size)
+	}
 
-	// init framestream receiver
-	if err := fs.InitReceiver(); err != nil {
+	if err := fs.Init(); err != nil {
 		c.LogError("error stream receiver initialization: %s", err)
-		return
 	} else {
-		c.LogInfo("receiver framestream initialized")
-	}
+		c.LogInfo("receiver framestream initialized (bidirectional: %t)", fs.Bidirectional())
 
-	// process incoming frame and send it to dnstap consumer channel
-	err := fs.ProcessFrame(dnstapProcessor.GetChannel())
-	if err != nil && !c.stopping {
-		c.LogError("transport error: %s", err)
+		// process incoming frame and send it to dnstap consumer channel
+		err := fs.ProcessFrames(dnstapProcessor.GetChannel())
+		if err != nil && err != io.EOF && !c.stopping {
+			c.LogError("transport error: %s", err)
+		}
 	}
 
 	// stop all subprocessors
+	c.processors.Remove(&dnstapProcessor)
 	dnstapProcessor.Stop()
 
-	c.LogInfo("%s - connection closed\n", peer)
+	stats := fs.Stats()
+	c.LogInfo("%s - connection closed, frames: %d, oversized frames skipped: %d (%d bytes)\n", peer,
+		stats.Frames, stats.Oversized, stats.OversizedBytes)
+	dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_DISCONNECT, Worker: c.name, Peer: peer, Count: int(stats.Frames)})
 }
 
 func (c *Dnstap) Channel() chan dnsutils.DnsMessage {
@@ -143,15 +169,17 @@ func (c *Dnstap) Stop() {
 	c.LogInfo("stopping...")
 	c.stopping = true
 
-	// closing properly current connections if exists
+	// stop accepting new connections
+	c.LogInfo("stop listening...")
+	c.listen.Close()
+
+	// the current connections are read until the peers close them or the drain timeout,
+	// the frames already received are decoded and sent to the loggers
 	for _, conn := range c.conns {
 		peer := conn.RemoteAddr().String()
-		c.LogInfo("%s - closing connection...", peer)
-		Close(conn)
+		c.LogInfo("%s - draining connection...", peer)
+		conn.SetReadDeadline(time.Now().Add(connDrainTimeout))
 	}
-	// Finally close the listener to unblock accept
-	c.LogInfo("stop listening...")
-	c.listen.Close()
 
 	// read done channel and block until run is terminated
 	<-c.done
@@ -163,7 +191,7 @@ func (c *Dnstap) Listen() error {
 
 	var err error
 	var listener net.Listener
-	addrlisten := c.config.Collectors.Dnstap.ListenIP + ":" + strconv.Itoa(c.config.Collectors.Dnstap.ListenPort)
+	addrlisten := net.JoinHostPort(c.config.Collectors.Dnstap.ListenIP, strconv.Itoa(c.config.Collectors.Dnstap.ListenPort))
 
 	if len(c.sockPath) > 0 {
 		_ = os.Remove(c.sockPath)
@@ -186,6 +214,20 @@ func (c *Dnstap) Listen() error {
 		// update tls min version according to the user config
 		tlsConfig.MinVersion = dnsutils.TLS_VERSION[c.config.Collectors.Dnstap.TlsMinVersion]
 
+		// verify the client certificates, with the server certificate if no ca is provided
+		if c.config.Collectors.Dnstap.TlsMutual {
+			caFile := c.config.Collectors.Dnstap.CaFile
+			if len(caFile) == 0 {
+				caFile = c.config.Collectors.Dnstap.CertFile
+			}
+			caCertPool, err := netlib.LoadCertPool(caFile)
+			if err != nil {
+				c.logger.Fatal("loading ca failed:", err)
+			}
+			tlsConfig.ClientCAs = caCertPool
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
 		if len(c.sockPath) > 0 {
 			listener, err = tls.Listen(dnsutils.SOCKET_UNIX, c.sockPath, tlsConfig)
 		} else {
@@ -241,9 +283,13 @@ func (c *Dnstap) Run() {
 		}
 
 		c.conns = append(c.conns, conn)
+		c.handlers.Add(1)
 		go c.HandleConn(conn)
 	}
 
+	// wait the connections, the messages received are sent to the loggers
+	c.handlers.Wait()
+
 	c.LogInfo("run terminated")
 	c.done <- true
 }
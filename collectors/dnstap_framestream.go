@@ -0,0 +1,248 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-framestream"
+)
+
+var DnstapContentType = []byte("protobuf:dnstap.Dnstap")
+
+var ErrFstrmContentType = errors.New("framestream content type not supported")
+
+// FstrmControl is a control frame of the frame streams protocol
+type FstrmControl struct {
+	Type         uint32
+	ContentTypes [][]byte
+}
+
+// HasContentType returns true if the control frame has no content type or the content type provided
+func (ctrl *FstrmControl) HasContentType(ctype []byte) bool {
+	if len(ctrl.ContentTypes) == 0 {
+		return true
+	}
+	for _, t := range ctrl.ContentTypes {
+		if bytes.Equal(t, ctype) {
+			return true
+		}
+	}
+	return false
+}
+
+// FstrmStats counts the data frames of a stream
+type FstrmStats struct {
+	Frames         uint64
+	Oversized      uint64
+	OversizedBytes uint64
+}
+
+// FstrmReceiver reads the frames of a bidirectional or unidirectional frame streams sender,
+// the mode is given by the first control frame: READY starts the handshake, START the stream
+type FstrmReceiver struct {
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	conn          net.Conn
+	readTimeout   time.Duration
+	contentType   []byte
+	maxFrameSize  int
+	bidirectional bool
+	stats         FstrmStats
+	// OnOversized is called when a data frame larger than the max size is skipped
+	OnOversized func(size int)
+}
+
+func NewFstrmReceiver(conn net.Conn, contentType []byte, maxFrameSize int) *FstrmReceiver {
+	return &FstrmReceiver{
+		reader:       bufio.NewReaderSize(conn, framestream.DATA_FRAME_LENGTH_MAX),
+		writer:       bufio.NewWriter(conn),
+		conn:         conn,
+		readTimeout:  5 * time.Second,
+		contentType:  contentType,
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// Bidirectional returns true if the sender has done the handshake
+func (fs *FstrmReceiver) Bidirectional() bool {
+	return fs.bidirectional
+}
+
+// Stats returns the counters of the data frames
+func (fs *FstrmReceiver) Stats() FstrmStats {
+	return fs.stats
+}
+
+// ReadControl reads a control frame, the escape sequence included
+func (fs *FstrmReceiver) ReadControl() (*FstrmControl, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(fs.reader, header[:]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[:4]) != 0 {
+		return nil, framestream.ErrControlFrameExpected
+	}
+	n := binary.BigEndian.Uint32(header[4:])
+	if n > framestream.CONTROL_FRAME_LENGTH_MAX {
+		return nil, framestream.ErrControlFrameTooLarge
+	}
+	if n < 4 {
+		return nil, framestream.ErrControlFrameMalformed
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(fs.reader, data); err != nil {
+		return nil, err
+	}
+
+	ctrl := &FstrmControl{Type: binary.BigEndian.Uint32(data[:4])}
+	if ctrl.Type < framestream.CONTROL_ACCEPT || ctrl.Type > framestream.CONTROL_FINISH {
+		return nil, framestream.ErrControlFrameUnsupported
+	}
+
+	// decode the content type fields
+	fields := data[4:]
+	for len(fields) > 0 {
+		if len(fields) < 8 || binary.BigEndian.Uint32(fields[:4]) != framestream.CONTROL_FIELD_CONTENT_TYPE {
+			return nil, framestream.ErrControlFrameMalformed
+		}
+		length := binary.BigEndian.Uint32(fields[4:8])
+		if uint32(len(fields)-8) < length {
+			return nil, framestream.ErrControlFrameMalformed
+		}
+		ctrl.ContentTypes = append(ctrl.ContentTypes, fields[8:8+length])
+		fields = fields[8+length:]
+	}
+	return ctrl, nil
+}
+
+// WriteControl sends a control frame
+func (fs *FstrmReceiver) WriteControl(ctrl *FstrmControl) error {
+	length := 4
+	for _, t := range ctrl.ContentTypes {
+		length += 8 + len(t)
+	}
+	data := make([]byte, 8+length)
+	binary.BigEndian.PutUint32(data[4:], uint32(length))
+	binary.BigEndian.PutUint32(data[8:], ctrl.Type)
+	offset := 12
+	for _, t := range ctrl.ContentTypes {
+		binary.BigEndian.PutUint32(data[offset:], framestream.CONTROL_FIELD_CONTENT_TYPE)
+		binary.BigEndian.PutUint32(data[offset+4:], uint32(len(t)))
+		offset += 8 + copy(data[offset+8:], t)
+	}
+	if _, err := fs.writer.Write(data); err != nil {
+		return err
+	}
+	return fs.writer.Flush()
+}
+
+// Init negotiates the content type with the sender and waits the start of the stream, an
+// accept frame without content type is sent to a bidirectional sender if the content type
+// is not supported
+func (fs *FstrmReceiver) Init() error {
+	if fs.readTimeout > 0 {
+		fs.conn.SetReadDeadline(time.Now().Add(fs.readTimeout))
+		defer fs.conn.SetReadDeadline(time.Time{})
+	}
+
+	ctrl, err := fs.ReadControl()
+	if err != nil {
+		return err
+	}
+
+	if ctrl.Type == framestream.CONTROL_READY {
+		fs.bidirectional = true
+		accept := &FstrmControl{Type: framestream.CONTROL_ACCEPT}
+		supported := len(ctrl.ContentTypes) > 0 && ctrl.HasContentType(fs.contentType)
+		if supported {
+			accept.ContentTypes = [][]byte{fs.contentType}
+		}
+		if err := fs.WriteControl(accept); err != nil {
+			return err
+		}
+		if !supported {
+			return ErrFstrmContentType
+		}
+
+		if ctrl, err = fs.ReadControl(); err != nil {
+			return err
+		}
+	}
+
+	if ctrl.Type != framestream.CONTROL_START {
+		return framestream.ErrControlFrameUnexpected
+	}
+	if len(ctrl.ContentTypes) > 1 {
+		return framestream.ErrControlFrameMalformed
+	}
+	if !ctrl.HasContentType(fs.contentType) {
+		return ErrFstrmContentType
+	}
+	return nil
+}
+
+// ProcessFrames reads the data frames in buffers from the pool and sends them to the channel,
+// the consumer gives back the buffers to the pool. The frames larger than the max size are
+// skipped without losing the synchronization of the stream, the stop frame ends the stream.
+func (fs *FstrmReceiver) ProcessFrames(ch chan []byte) error {
+	for {
+		header, err := fs.reader.Peek(4)
+		if err != nil {
+			return err
+		}
+
+		// control frame ?
+		n := binary.BigEndian.Uint32(header)
+		if n == 0 {
+			ctrl, err := fs.ReadControl()
+			if err != nil {
+				return err
+			}
+			if ctrl.Type != framestream.CONTROL_STOP {
+				return framestream.ErrControlFrameUnexpected
+			}
+			if fs.bidirectional {
+				if err := fs.WriteControl(&FstrmControl{Type: framestream.CONTROL_FINISH}); err != nil {
+					return err
+				}
+			}
+			return io.EOF
+		}
+
+		if _, err := fs.reader.Discard(4); err != nil {
+			return err
+		}
+
+		// without max size, the frames are limited to the default size of the frame streams
+		if fs.maxFrameSize == 0 && n > framestream.DATA_FRAME_LENGTH_MAX {
+			return framestream.ErrFrameTooLarge
+		}
+
+		if fs.maxFrameSize > 0 && n > uint32(fs.maxFrameSize) {
+			if _, err := fs.reader.Discard(int(n)); err != nil {
+				return err
+			}
+			fs.stats.Oversized++
+			fs.stats.OversizedBytes += uint64(n)
+			if fs.OnOversized != nil {
+				fs.OnOversized(int(n))
+			}
+			continue
+		}
+
+		data := dnsutils.GetBuffer(int(n))
+		if _, err := io.ReadFull(fs.reader, data); err != nil {
+			dnsutils.PutBuffer(data)
+			return err
+		}
+		fs.stats.Frames++
+		ch <- data
+	}
+}
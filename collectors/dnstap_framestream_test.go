@@ -0,0 +1,150 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-framestream"
+)
+
+func writeFstrmData(w io.Writer, data []byte) {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	w.Write(append(header, data...))
+}
+
+func Test_FstrmReceiverUnidirectional(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// start, a frame larger than the max size between two normal frames, stop
+	go func() {
+		sender := &FstrmReceiver{writer: bufio.NewWriter(client)}
+		sender.WriteControl(&FstrmControl{Type: framestream.CONTROL_START, ContentTypes: [][]byte{DnstapContentType}})
+		writeFstrmData(client, []byte("first"))
+		writeFstrmData(client, bytes.Repeat([]byte{'x'}, 100000))
+		writeFstrmData(client, []byte("second"))
+		sender.WriteControl(&FstrmControl{Type: framestream.CONTROL_STOP})
+	}()
+
+	fs := NewFstrmReceiver(server, DnstapContentType, 1024)
+	oversized := 0
+	fs.OnOversized = func(size int) { oversized = size }
+	if err := fs.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if fs.Bidirectional() {
+		t.Errorf("unidirectional stream expected")
+	}
+
+	ch := make(chan []byte, 10)
+	if err := fs.ProcessFrames(ch); err != io.EOF {
+		t.Fatalf("end of stream expected, got %v", err)
+	}
+	if len(ch) != 2 || string(<-ch) != "first" || string(<-ch) != "second" {
+		t.Errorf("the normal frames are expected")
+	}
+	if stats := fs.Stats(); stats.Frames != 2 || stats.Oversized != 1 || stats.OversizedBytes != 100000 || oversized != 100000 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func Test_FstrmReceiverBidirectional(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		fs := framestream.NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, DnstapContentType, true)
+		if err := fs.InitSender(); err != nil {
+			errs <- err
+			return
+		}
+		frame := &framestream.Frame{}
+		frame.Write([]byte("query"))
+		fs.SendFrame(frame)
+		errs <- fs.ResetSender()
+	}()
+
+	fs := NewFstrmReceiver(server, DnstapContentType, 1024)
+	if err := fs.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if !fs.Bidirectional() {
+		t.Errorf("bidirectional stream expected")
+	}
+
+	ch := make(chan []byte, 10)
+	if err := fs.ProcessFrames(ch); err != io.EOF {
+		t.Fatalf("end of stream expected, got %v", err)
+	}
+	if string(<-ch) != "query" {
+		t.Errorf("the frame is expected")
+	}
+
+	// the sender gets the finish frame
+	if err := <-errs; err != nil {
+		t.Errorf("sender error: %v", err)
+	}
+}
+
+func Test_FstrmReceiverContentType(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	accept := make(chan *FstrmControl, 1)
+	go func() {
+		sender := &FstrmReceiver{reader: bufio.NewReader(client), writer: bufio.NewWriter(client)}
+		sender.WriteControl(&FstrmControl{Type: framestream.CONTROL_READY, ContentTypes: [][]byte{[]byte("protobuf:other")}})
+		ctrl, _ := sender.ReadControl()
+		accept <- ctrl
+	}()
+
+	fs := NewFstrmReceiver(server, DnstapContentType, 1024)
+	if err := fs.Init(); err != ErrFstrmContentType {
+		t.Errorf("content type error expected, got %v", err)
+	}
+
+	// the accept frame has no content type
+	ctrl := <-accept
+	if ctrl == nil || ctrl.Type != framestream.CONTROL_ACCEPT || len(ctrl.ContentTypes) != 0 {
+		t.Errorf("accept frame without content type expected, got %+v", ctrl)
+	}
+}
+
+func Test_FstrmReceiverFrameTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// without max size, a frame larger than the frame streams limit closes the stream
+	go func() {
+		sender := &FstrmReceiver{writer: bufio.NewWriter(client)}
+		sender.WriteControl(&FstrmControl{Type: framestream.CONTROL_START, ContentTypes: [][]byte{DnstapContentType}})
+		writeFstrmData(client, []byte("first"))
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, 0xffffffff)
+		client.Write(header)
+	}()
+
+	fs := NewFstrmReceiver(server, DnstapContentType, 0)
+	if err := fs.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan []byte, 10)
+	if err := fs.ProcessFrames(ch); err != framestream.ErrFrameTooLarge {
+		t.Fatalf("frame too large expected, got %v", err)
+	}
+	if len(ch) != 1 || string(<-ch) != "first" {
+		t.Errorf("the first frame is expected")
+	}
+}
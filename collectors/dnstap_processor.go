@@ -2,14 +2,18 @@ package collectors
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-dnstap-protobuf"
 	"github.com/dmachard/go-logger"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -48,22 +52,114 @@ func GetFakeDnstap(dnsquery []byte) *dnstap.Dnstap {
 	return dt_query
 }
 
+// DecodePolicy decodes the policy metadata of the dnstap message, the value
+// is an ip address or a domain name according to the match
+func DecodePolicy(policy *dnstap.Policy, dm *dnsutils.DnsMessage) {
+	if policy == nil {
+		return
+	}
+
+	if len(policy.GetType()) > 0 {
+		dm.DnsTap.PolicyType = policy.GetType()
+	}
+	if len(policy.GetRule()) > 0 {
+		dm.DnsTap.PolicyRule = string(policy.GetRule())
+	}
+	if policy.Action != nil {
+		dm.DnsTap.PolicyAction = policy.GetAction().String()
+	}
+	if policy.Match != nil {
+		dm.DnsTap.PolicyMatch = policy.GetMatch().String()
+	}
+
+	value := policy.GetValue()
+	if len(value) == 0 {
+		return
+	}
+	switch policy.GetMatch() {
+	case dnstap.Policy_CLIENT_IP, dnstap.Policy_RESPONSE_IP, dnstap.Policy_NS_IP:
+		if len(value) == net.IPv4len || len(value) == net.IPv6len {
+			dm.DnsTap.PolicyValue = net.IP(value).String()
+			return
+		}
+	case dnstap.Policy_QNAME, dnstap.Policy_NS_NAME:
+		if name, _, err := dnsutils.ParseLabels(0, value); err == nil {
+			dm.DnsTap.PolicyValue = name
+			return
+		}
+	}
+	dm.DnsTap.PolicyValue = string(value)
+}
+
+// field numbers of the dnstap protobuf schema used to route the messages in ordered mode
+const (
+	dnstapMessageField      = 14
+	dnstapQueryAddressField = 4
+)
+
+// getProtoBytes returns the value of the first field with this number and the bytes type
+func getProtoBytes(b []byte, num protowire.Number) []byte {
+	for len(b) > 0 {
+		n, typ, size := protowire.ConsumeTag(b)
+		if size < 0 {
+			return nil
+		}
+		b = b[size:]
+		m := protowire.ConsumeFieldValue(n, typ, b)
+		if m < 0 {
+			return nil
+		}
+		if n == num && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(b)
+			return v
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+// GetDnstapQueryAddress returns the query address of the encoded dnstap message
+// without decoding the whole message
+func GetDnstapQueryAddress(data []byte) []byte {
+	return getProtoBytes(getProtoBytes(data, dnstapMessageField), dnstapQueryAddressField)
+}
+
+// DnstapWorkerStats counts the messages handled by a decoding worker
+type DnstapWorkerStats struct {
+	Decoded uint64
+	Dropped uint64
+	Errors  uint64
+
+	// time spent in each stage in nanoseconds, measured only with the timing enabled
+	UnmarshalTime uint64
+	DecodeTime    uint64
+	TransformTime uint64
+	DispatchTime  uint64
+}
+
 type DnstapProcessor struct {
-	done     chan bool
-	recvFrom chan []byte
-	logger   *logger.Logger
-	config   *dnsutils.Config
-	name     string
+	done       chan bool
+	configChan chan *dnsutils.Config
+	recvFrom   chan []byte
+	logger     *logger.Logger
+	config     *dnsutils.Config
+	name       string
+	batchTo    []chan []dnsutils.DnsMessage
+	workers    int
+	ordered    bool
+	stats      []DnstapWorkerStats
+	timing     bool
 }
 
 func NewDnstapProcessor(config *dnsutils.Config, logger *logger.Logger, name string) DnstapProcessor {
 	logger.Info("[%s] dnstap processor - initialization...", name)
 	d := DnstapProcessor{
-		done:     make(chan bool),
-		recvFrom: make(chan []byte, 512),
-		logger:   logger,
-		config:   config,
-		name:     name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		recvFrom:   make(chan []byte, 512),
+		logger:     logger,
+		config:     config,
+		name:       name,
 	}
 
 	d.ReadConfig()
@@ -72,7 +168,35 @@ func NewDnstapProcessor(config *dnsutils.Config, logger *logger.Logger, name str
 }
 
 func (d *DnstapProcessor) ReadConfig() {
-	// todo - checking settings
+	d.workers = d.config.Collectors.Dnstap.DecodingWorkers
+	if d.workers < 1 {
+		d.LogError("invalid number of decoding workers %d, only one is used", d.workers)
+		d.workers = 1
+	}
+	d.ordered = d.config.Collectors.Dnstap.OrderedDecoding
+	d.stats = make([]DnstapWorkerStats, d.workers)
+}
+
+// EnableTiming measures the time spent in each stage of the processing, to profile the
+// processor chain, it must be called before to run
+func (d *DnstapProcessor) EnableTiming() {
+	d.timing = true
+}
+
+// since adds the time elapsed from start to the counter when the timing is enabled and
+// returns the start of the next stage
+func (d *DnstapProcessor) since(counter *uint64, start time.Time) time.Time {
+	if !d.timing {
+		return start
+	}
+	now := time.Now()
+	atomic.AddUint64(counter, uint64(now.Sub(start)))
+	return now
+}
+
+// ReloadConfig reloads the transformers of the processor
+func (d *DnstapProcessor) ReloadConfig(config *dnsutils.Config) {
+	dnsutils.SendConfig(d.configChan, config)
 }
 
 func (c *DnstapProcessor) LogInfo(msg string, v ...interface{}) {
@@ -87,6 +211,21 @@ func (d *DnstapProcessor) GetChannel() chan []byte {
 	return d.recvFrom
 }
 
+// Stats returns the counters of each decoding worker
+func (d *DnstapProcessor) Stats() []DnstapWorkerStats {
+	stats := make([]DnstapWorkerStats, len(d.stats))
+	for i := range d.stats {
+		stats[i].Decoded = atomic.LoadUint64(&d.stats[i].Decoded)
+		stats[i].Dropped = atomic.LoadUint64(&d.stats[i].Dropped)
+		stats[i].Errors = atomic.LoadUint64(&d.stats[i].Errors)
+		stats[i].UnmarshalTime = atomic.LoadUint64(&d.stats[i].UnmarshalTime)
+		stats[i].DecodeTime = atomic.LoadUint64(&d.stats[i].DecodeTime)
+		stats[i].TransformTime = atomic.LoadUint64(&d.stats[i].TransformTime)
+		stats[i].DispatchTime = atomic.LoadUint64(&d.stats[i].DispatchTime)
+	}
+	return stats
+}
+
 func (d *DnstapProcessor) Stop() {
 	close(d.recvFrom)
 
@@ -95,123 +234,275 @@ func (d *DnstapProcessor) Stop() {
 	close(d.done)
 }
 
+// SetBatchChannels sets the channels of the loggers receiving slices of messages
+func (d *DnstapProcessor) SetBatchChannels(batchTo []chan []dnsutils.DnsMessage) {
+	d.batchTo = batchTo
+}
+
 func (d *DnstapProcessor) Run(sendTo []chan dnsutils.DnsMessage) {
-	dt := &dnstap.Dnstap{}
+	// prepare the dispatcher to loggers, shared by the decoding workers
+	dispatcher := dnsutils.NewDispatcher(sendTo, d.batchTo, d.config.Global.Batch.Size,
+		time.Duration(d.config.Global.Batch.FlushInterval)*time.Millisecond)
+
+	// start the decoding workers, they read directly the incoming channel
+	// or their own channel in ordered mode
+	var wg sync.WaitGroup
+	inputs := make([]chan []byte, d.workers)
+	reloads := make([]chan *dnsutils.Config, d.workers)
+	for i := range inputs {
+		inputs[i] = d.recvFrom
+		if d.ordered {
+			inputs[i] = make(chan []byte, 512)
+		}
+		reloads[i] = make(chan *dnsutils.Config, 1)
 
-	// prepare enabled transformers
-	subprocessors := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, sendTo)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d.RunWorker(i, inputs[i], reloads[i], dispatcher)
+		}(i)
+	}
 
-	// read incoming dns message
-	d.LogInfo("running... waiting incoming dns message")
-	for data := range d.recvFrom {
+	finished := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
 
-		err := proto.Unmarshal(data, dt)
-		if err != nil {
-			continue
-		}
+	// in ordered mode, the messages of a client are always decoded by the same worker
+	var recvFrom chan []byte
+	if d.ordered {
+		recvFrom = d.recvFrom
+	}
 
-		// init dns message
-		dm := dnsutils.DnsMessage{}
-		dm.Init()
+	d.LogInfo("running with %d decoding worker(s)... waiting incoming dns message", d.workers)
+LOOP:
+	for {
+		select {
+		case cfg := <-d.configChan:
+			// reload the transformers of each worker, the messages in the channel are kept
+			for i := range reloads {
+				dnsutils.SendConfig(reloads[i], cfg)
+			}
 
-		// init dns message with additionnals parts
-		subprocessors.InitDnsMessageFormat(&dm)
+		case data, opened := <-recvFrom:
+			if !opened {
+				for i := range inputs {
+					close(inputs[i])
+				}
+				recvFrom = nil
+				continue
+			}
+			h := fnv.New32a()
+			h.Write(GetDnstapQueryAddress(data))
+			inputs[h.Sum32()%uint32(d.workers)] <- data
 
-		identity := dt.GetIdentity()
-		if len(identity) > 0 {
-			dm.DnsTap.Identity = string(identity)
+		case <-finished:
+			break LOOP
 		}
-		version := dt.GetVersion()
-		if len(version) > 0 {
-			dm.DnsTap.Version = string(version)
-		}
-		dm.DnsTap.Operation = dt.GetMessage().GetType().String()
+	}
 
-		if ipVersion, valid := dnsutils.IP_VERSION[dt.GetMessage().GetSocketFamily().String()]; valid {
-			dm.NetworkInfo.Family = ipVersion
-		} else {
-			dm.NetworkInfo.Family = dnsutils.STR_UNKNOWN
-		}
+	// send pending batches
+	dispatcher.Stop()
 
-		dm.NetworkInfo.Protocol = dt.GetMessage().GetSocketProtocol().String()
+	for i, stats := range d.Stats() {
+		d.LogInfo("worker #%d - decoded: %d, dropped: %d, errors: %d", i, stats.Decoded, stats.Dropped, stats.Errors)
+	}
 
-		// decode query address and port
-		queryip := dt.GetMessage().GetQueryAddress()
-		if len(queryip) > 0 {
-			dm.NetworkInfo.QueryIp = net.IP(queryip).String()
-		}
-		queryport := dt.GetMessage().GetQueryPort()
-		if queryport > 0 {
-			dm.NetworkInfo.QueryPort = strconv.FormatUint(uint64(queryport), 10)
-		}
+	// dnstap channel closed
+	d.done <- true
+}
 
-		// decode response address and port
-		responseip := dt.GetMessage().GetResponseAddress()
-		if len(responseip) > 0 {
-			dm.NetworkInfo.ResponseIp = net.IP(responseip).String()
-		}
-		responseport := dt.GetMessage().GetResponsePort()
-		if responseport > 0 {
-			dm.NetworkInfo.ResponsePort = strconv.FormatUint(uint64(responseport), 10)
-		}
+// DecodeMessage decodes the dns payload of the message to get id, rcode, the questions
+// and the answers, the packet is marked as malformed on error
+func (d *DnstapProcessor) DecodeMessage(dm *dnsutils.DnsMessage) {
+	dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
+	if err != nil {
+		// parser error
+		dm.DNS.MalformedPacket = true
+		d.LogInfo("dns parser malformed packet: %s", err)
+		dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_DECODE_ERROR, Worker: d.name, Count: 1,
+			Message: "dns parser malformed packet: " + err.Error()})
+	}
 
-		// get dns payload and timestamp according to the type (query or response)
-		op := dnstap.Message_Type_value[dm.DnsTap.Operation]
-		if op%2 == 1 {
-			dns_payload := dt.GetMessage().GetQueryMessage()
-			dm.DNS.Payload = dns_payload
-			dm.DNS.Length = len(dns_payload)
-			dm.DNS.Type = dnsutils.DnsQuery
-			dm.DnsTap.TimeSec = int(dt.GetMessage().GetQueryTimeSec())
-			dm.DnsTap.TimeNsec = int(dt.GetMessage().GetQueryTimeNsec())
-		} else {
-			dns_payload := dt.GetMessage().GetResponseMessage()
-			dm.DNS.Payload = dns_payload
-			dm.DNS.Length = len(dns_payload)
-			dm.DNS.Type = dnsutils.DnsReply
-			dm.DnsTap.TimeSec = int(dt.GetMessage().GetResponseTimeSec())
-			dm.DnsTap.TimeNsec = int(dt.GetMessage().GetResponseTimeNsec())
+	if err = dnsutils.DecodePayload(dm, &dnsHeader, d.config); err != nil {
+		// decoding error
+		if d.config.Global.Trace.LogMalformed {
+			d.LogError("%v - %v", err, *dm)
+			d.LogError("dump invalid dns payload: %v", dm.DNS.Payload)
 		}
+	}
+}
 
-		// compute timestamp
-		dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
-		ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
-		dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
-
-		// decode the dns payload to get id, rcode and the number of question
-		// number of answer, ignore invalid packet
-		dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
-		if err != nil {
-			// parser error
-			dm.DNS.MalformedPacket = true
-			d.LogInfo("dns parser malformed packet: %s", err)
-		}
+// RunWorker decodes the dnstap messages and applies its own instance of the transformers
+func (d *DnstapProcessor) RunWorker(id int, input chan []byte, reload chan *dnsutils.Config, dispatcher *dnsutils.Dispatcher) {
+	dt := &dnstap.Dnstap{}
+	stats := &d.stats[id]
 
-		if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
-			// decoding error
-			if d.config.Global.Trace.LogMalformed {
-				d.LogError("%v - %v", err, dm)
-				d.LogError("dump invalid dns payload: %v", dm.DNS.Payload)
+	// prepare enabled transformers
+	subprocessors := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, dispatcher.Channels())
+
+LOOP:
+	for {
+		select {
+		case cfg := <-reload:
+			subprocessors.Flush()
+			subprocessors.ReloadConfig(&cfg.IngoingTransformers)
+
+		case data, opened := <-input:
+			if !opened {
+				break LOOP
 			}
-		}
 
-		// apply all enabled transformers
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
-		}
+			var start time.Time
+			if d.timing {
+				start = time.Now()
+			}
+
+			// the values are copied by the decoder, the buffer can be reused
+			err := proto.Unmarshal(data, dt)
+			dnsutils.PutBuffer(data)
+			start = d.since(&stats.UnmarshalTime, start)
+			if err != nil {
+				atomic.AddUint64(&stats.Errors, 1)
+				dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_DECODE_ERROR, Worker: d.name, Count: 1,
+					Message: "dnstap decoding: " + err.Error()})
+				continue
+			}
+			// init dns message
+			dm := dnsutils.DnsMessage{}
+			dm.Init()
 
-		// convert latency to human
-		dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+			// init dns message with additionnals parts
+			subprocessors.InitDnsMessageFormat(&dm)
 
-		// dispatch dns message to all generators
-		for i := range sendTo {
-			sendTo[i] <- dm
+			identity := dt.GetIdentity()
+			if len(identity) > 0 {
+				dm.DnsTap.Identity = string(identity)
+			}
+			version := dt.GetVersion()
+			if len(version) > 0 {
+				dm.DnsTap.Version = string(version)
+			}
+			dm.DnsTap.Operation = dt.GetMessage().GetType().String()
+
+			// extra field, opaque data added by the sender
+			extra := dt.GetExtra()
+			if len(extra) > 0 {
+				dm.DnsTap.Extra = string(extra)
+			}
+
+			// policy applied by the resolver (rpz, blocklist...)
+			DecodePolicy(dt.GetMessage().GetPolicy(), &dm)
+
+			if ipVersion, valid := dnsutils.IP_VERSION[dt.GetMessage().GetSocketFamily().String()]; valid {
+				dm.NetworkInfo.Family = ipVersion
+			} else {
+				dm.NetworkInfo.Family = dnsutils.STR_UNKNOWN
+			}
+
+			dm.NetworkInfo.Protocol = dt.GetMessage().GetSocketProtocol().String()
+
+			// decode query address and port
+			queryip := dt.GetMessage().GetQueryAddress()
+			if len(queryip) > 0 {
+				dm.NetworkInfo.QueryIp = net.IP(queryip).String()
+			}
+			queryport := dt.GetMessage().GetQueryPort()
+			if queryport > 0 {
+				dm.NetworkInfo.QueryPort = strconv.FormatUint(uint64(queryport), 10)
+			}
+
+			// decode response address and port
+			responseip := dt.GetMessage().GetResponseAddress()
+			if len(responseip) > 0 {
+				dm.NetworkInfo.ResponseIp = net.IP(responseip).String()
+			}
+			responseport := dt.GetMessage().GetResponsePort()
+			if responseport > 0 {
+				dm.NetworkInfo.ResponsePort = strconv.FormatUint(uint64(responseport), 10)
+			}
+
+			// get dns payload and timestamp according to the type (query or response)
+			op := dnstap.Message_Type_value[dm.DnsTap.Operation]
+			if op%2 == 1 {
+				dns_payload := dt.GetMessage().GetQueryMessage()
+				dm.DNS.Payload = dns_payload
+				dm.DNS.Length = len(dns_payload)
+				dm.DNS.Type = dnsutils.DnsQuery
+				dm.DnsTap.TimeSec = int(dt.GetMessage().GetQueryTimeSec())
+				dm.DnsTap.TimeNsec = int(dt.GetMessage().GetQueryTimeNsec())
+			} else {
+				dns_payload := dt.GetMessage().GetResponseMessage()
+				dm.DNS.Payload = dns_payload
+				dm.DNS.Length = len(dns_payload)
+				dm.DNS.Type = dnsutils.DnsReply
+				dm.DnsTap.TimeSec = int(dt.GetMessage().GetResponseTimeSec())
+				dm.DnsTap.TimeNsec = int(dt.GetMessage().GetResponseTimeNsec())
+			}
+
+			// compute timestamp
+			dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
+			ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+			dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
+
+			// a tcp payload can contain several messages, one record is sent by message
+			messages := []dnsutils.DnsMessage{dm}
+			if d.config.Collectors.Dnstap.MultiMessage {
+				if payloads := dnsutils.SplitTcpPayload(dm.DNS.Payload); payloads != nil {
+					messages = make([]dnsutils.DnsMessage, len(payloads))
+					for i := range payloads {
+						messages[i] = dm
+						messages[i].DNS.Payload = payloads[i]
+						messages[i].DNS.Length = len(payloads[i])
+						if i > 0 {
+							subprocessors.InitDnsMessageFormat(&messages[i])
+						}
+					}
+				}
+			}
+			for i := range messages {
+				d.DecodeMessage(&messages[i])
+			}
+
+			// or one record for the messages of a zone transfer
+			if len(messages) > 1 && d.config.Collectors.Dnstap.TransferMode == dnsutils.TRANSFER_SUMMARY &&
+				(messages[0].DNS.Qtype == "AXFR" || messages[0].DNS.Qtype == "IXFR") {
+				summary := dnsutils.SummarizeTransfer(messages)
+				summary.DNS.Payload = dm.DNS.Payload
+				messages = []dnsutils.DnsMessage{summary}
+			}
+			start = d.since(&stats.DecodeTime, start)
+
+			for _, dm := range messages {
+				// the raw payload is not needed anymore
+				if d.config.Global.DropPayload {
+					dm.DNS.Payload = nil
+				}
+
+				// add the label of the collector
+				dm.SetListener(d.name, d.config.Collectors.Label)
+
+				// apply all enabled transformers
+				ret := subprocessors.ProcessMessage(&dm)
+				start = d.since(&stats.TransformTime, start)
+				if ret == transformers.RETURN_DROP {
+					atomic.AddUint64(&stats.Dropped, 1)
+					continue
+				}
+
+				// convert latency to human
+				dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+
+				// dispatch dns message to all generators
+				dispatcher.Dispatch(dm)
+				start = d.since(&stats.DispatchTime, start)
+				atomic.AddUint64(&stats.Decoded, 1)
+			}
 		}
 	}
 
-	// cleanup transformers
+	// send the messages held by the transformers and cleanup them
+	subprocessors.Flush()
 	subprocessors.Reset()
-
-	// dnstap channel closed
-	d.done <- true
 }
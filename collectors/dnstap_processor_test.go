@@ -2,6 +2,7 @@ package collectors
 
 import (
 	"bytes"
+	"net"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -46,6 +47,33 @@ func Test_DnstapProcessor(t *testing.T) {
 	}
 }
 
+func Test_DnstapProcessor_Label(t *testing.T) {
+	// init the dnstap consumer of a collector with a label
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Label = "resolvers-dc1"
+	consumer := NewDnstapProcessor(config, logger.New(false), "tap-dc1")
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("www.google.fr.", dns.TypeA)
+	dnsquestion, _ := dnsmsg.Pack()
+
+	dt := &dnstap.Dnstap{}
+	dt.Type = dnstap.Dnstap_Type.Enum(1)
+	dt.Message = &dnstap.Message{}
+	dt.Message.Type = dnstap.Message_Type.Enum(5)
+	dt.Message.QueryMessage = dnsquestion
+	data, _ := proto.Marshal(dt)
+
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+	consumer.GetChannel() <- data
+
+	dm := <-chan_to
+	if dm.Listener == nil || dm.Listener.Collector != "tap-dc1" || dm.Listener.Label != "resolvers-dc1" {
+		t.Errorf("invalid listener in dns message: %+v", dm.Listener)
+	}
+}
+
 func Test_DnstapProcessor_MalformedDnsHeader(t *testing.T) {
 	logger := logger.New(true)
 	var o bytes.Buffer
@@ -149,3 +177,285 @@ func Test_DnstapProcessor_MalformedDnsAnswer(t *testing.T) {
 		t.Errorf("malformed packet not detected")
 	}
 }
+
+func Test_DnstapProcessor_ExtraAndPolicy(t *testing.T) {
+	logger := logger.New(true)
+	var o bytes.Buffer
+	logger.SetOutput(&o)
+
+	// init the dnstap consumer
+	consumer := NewDnstapProcessor(dnsutils.GetFakeConfig(), logger, "test")
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+
+	// prepare dns query
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("www.google.fr.", dns.TypeA)
+	dnsquestion, _ := dnsmsg.Pack()
+
+	// prepare dnstap with extra field and a rpz policy
+	dt := &dnstap.Dnstap{}
+	dt.Type = dnstap.Dnstap_Type.Enum(1)
+	dt.Extra = []byte("vm-resolver-01")
+
+	dt.Message = &dnstap.Message{}
+	dt.Message.Type = dnstap.Message_Type.Enum(5)
+	dt.Message.QueryMessage = dnsquestion
+	dt.Message.Policy = &dnstap.Policy{
+		Type:   proto.String("rpz"),
+		Rule:   []byte("rpz.blocklist"),
+		Action: dnstap.Policy_NXDOMAIN.Enum(),
+		Match:  dnstap.Policy_QNAME.Enum(),
+		Value:  []byte{0x03, 'w', 'w', 'w', 0x06, 'g', 'o', 'o', 'g', 'l', 'e', 0x02, 'f', 'r', 0x00},
+	}
+
+	data, _ := proto.Marshal(dt)
+
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+	// add packet to consumer
+	consumer.GetChannel() <- data
+
+	// read dns message from dnstap consumer
+	dm := <-chan_to
+	if dm.DnsTap.Extra != "vm-resolver-01" {
+		t.Errorf("invalid extra field: %s", dm.DnsTap.Extra)
+	}
+	if dm.DnsTap.PolicyType != "rpz" || dm.DnsTap.PolicyRule != "rpz.blocklist" {
+		t.Errorf("invalid policy type or rule: %s %s", dm.DnsTap.PolicyType, dm.DnsTap.PolicyRule)
+	}
+	if dm.DnsTap.PolicyAction != "NXDOMAIN" || dm.DnsTap.PolicyMatch != "QNAME" {
+		t.Errorf("invalid policy action or match: %s %s", dm.DnsTap.PolicyAction, dm.DnsTap.PolicyMatch)
+	}
+	if dm.DnsTap.PolicyValue != "www.google.fr" {
+		t.Errorf("invalid policy value: %s", dm.DnsTap.PolicyValue)
+	}
+}
+
+func Test_DnstapProcessor_PolicyIpValue(t *testing.T) {
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+
+	policy := &dnstap.Policy{
+		Action: dnstap.Policy_DROP.Enum(),
+		Match:  dnstap.Policy_CLIENT_IP.Enum(),
+		Value:  []byte{192, 168, 1, 1},
+	}
+	DecodePolicy(policy, &dm)
+
+	if dm.DnsTap.PolicyType != "-" || dm.DnsTap.PolicyRule != "-" {
+		t.Errorf("default policy type and rule expected: %s %s", dm.DnsTap.PolicyType, dm.DnsTap.PolicyRule)
+	}
+	if dm.DnsTap.PolicyAction != "DROP" || dm.DnsTap.PolicyValue != "192.168.1.1" {
+		t.Errorf("invalid policy action or value: %s %s", dm.DnsTap.PolicyAction, dm.DnsTap.PolicyValue)
+	}
+}
+
+func Test_DnstapProcessor_OrderedWorkers(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Dnstap.DecodingWorkers = 4
+	config.Collectors.Dnstap.OrderedDecoding = true
+
+	consumer := NewDnstapProcessor(config, logger.New(false), "test")
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+
+	// send queries from two clients, the id is used as sequence number
+	clients := []string{"10.0.0.1", "10.0.0.2"}
+	for i := 0; i < 100; i++ {
+		dnsmsg := new(dns.Msg)
+		dnsmsg.SetQuestion("www.google.fr.", dns.TypeA)
+		dnsmsg.Id = uint16(i)
+		dnsquestion, _ := dnsmsg.Pack()
+
+		dt := &dnstap.Dnstap{}
+		dt.Type = dnstap.Dnstap_Type.Enum(1)
+		dt.Message = &dnstap.Message{}
+		dt.Message.Type = dnstap.Message_Type.Enum(5)
+		dt.Message.QueryAddress = net.ParseIP(clients[i%2]).To4()
+		dt.Message.QueryMessage = dnsquestion
+
+		data, _ := proto.Marshal(dt)
+		consumer.GetChannel() <- data
+	}
+	consumer.GetChannel() <- []byte{0xff}
+
+	// the messages of each client are received in order
+	last := map[string]int{}
+	for i := 0; i < 100; i++ {
+		dm := <-chan_to
+		if prev, ok := last[dm.NetworkInfo.QueryIp]; ok && dm.DNS.Id <= prev {
+			t.Errorf("invalid order for %s: %d after %d", dm.NetworkInfo.QueryIp, dm.DNS.Id, prev)
+		}
+		last[dm.NetworkInfo.QueryIp] = dm.DNS.Id
+	}
+
+	consumer.Stop()
+
+	var decoded, errors uint64
+	stats := consumer.Stats()
+	for _, s := range stats {
+		decoded += s.Decoded
+		errors += s.Errors
+	}
+	if len(stats) != 4 || decoded != 100 || errors != 1 {
+		t.Errorf("invalid workers stats: %+v", stats)
+	}
+}
+
+func Test_GetDnstapQueryAddress(t *testing.T) {
+	dt := &dnstap.Dnstap{}
+	dt.Type = dnstap.Dnstap_Type.Enum(1)
+	dt.Identity = []byte("dnstap")
+	dt.Message = &dnstap.Message{}
+	dt.Message.Type = dnstap.Message_Type.Enum(5)
+	dt.Message.QueryAddress = net.ParseIP("10.0.0.1").To4()
+	dt.Message.ResponseAddress = net.ParseIP("10.0.0.2").To4()
+
+	data, _ := proto.Marshal(dt)
+	if addr := GetDnstapQueryAddress(data); net.IP(addr).String() != "10.0.0.1" {
+		t.Errorf("invalid query address: %v", addr)
+	}
+}
+
+func Test_DnstapProcessor_DropPayload(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Global.DropPayload = true
+
+	consumer := NewDnstapProcessor(config, logger.New(false), "test")
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("www.google.fr.", dns.TypeA)
+	dnsquestion, _ := dnsmsg.Pack()
+
+	dt := &dnstap.Dnstap{}
+	dt.Type = dnstap.Dnstap_Type.Enum(1)
+	dt.Message = &dnstap.Message{}
+	dt.Message.Type = dnstap.Message_Type.Enum(5)
+	dt.Message.QueryMessage = dnsquestion
+	data, _ := proto.Marshal(dt)
+
+	// the frame is read in a buffer from the pool
+	frame := dnsutils.GetBuffer(len(data))
+	copy(frame, data)
+	consumer.GetChannel() <- frame
+
+	dm := <-chan_to
+	if dm.DNS.Qname != "www.google.fr" || dm.DNS.Length != len(dnsquestion) {
+		t.Errorf("invalid dns message: %s %d", dm.DNS.Qname, dm.DNS.Length)
+	}
+	if dm.DNS.Payload != nil {
+		t.Errorf("payload not dropped")
+	}
+	consumer.Stop()
+}
+
+// getFakeZoneTransfer returns the dnstap message of an axfr response with three
+// messages in the dns over tcp format
+func getFakeZoneTransfer(t *testing.T) []byte {
+	soa, _ := dns.NewRR("example.com. 3600 IN SOA ns1.example.com. admin.example.com. 2024010101 7200 3600 1209600 3600")
+	rrs := [][]dns.RR{{soa}, {}, {soa}}
+	a, _ := dns.NewRR("www.example.com. 3600 IN A 192.0.2.1")
+	ns, _ := dns.NewRR("example.com. 3600 IN NS ns1.example.com.")
+	rrs[0] = append(rrs[0], a)
+	rrs[1] = append(rrs[1], ns)
+
+	payload := []byte{}
+	for i := range rrs {
+		m := new(dns.Msg)
+		if i == 0 {
+			m.SetQuestion("example.com.", dns.TypeAXFR)
+		}
+		m.Response = true
+		m.Answer = rrs[i]
+		data, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = append(payload, byte(len(data)>>8), byte(len(data)))
+		payload = append(payload, data...)
+	}
+
+	dt := &dnstap.Dnstap{}
+	dt.Type = dnstap.Dnstap_Type.Enum(1)
+	dt.Message = &dnstap.Message{}
+	dt.Message.Type = dnstap.Message_Type.Enum(6)
+	dt.Message.SocketProtocol = dnstap.SocketProtocol_TCP.Enum()
+	dt.Message.ResponseMessage = payload
+	data, _ := proto.Marshal(dt)
+	return data
+}
+
+func Test_DnstapProcessor_MultiMessage(t *testing.T) {
+	consumer := NewDnstapProcessor(dnsutils.GetFakeConfig(), logger.New(false), "test")
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+	consumer.GetChannel() <- getFakeZoneTransfer(t)
+
+	// one record by message
+	answers := []string{}
+	for i := 0; i < 3; i++ {
+		dm := <-chan_to
+		if dm.DNS.MalformedPacket {
+			t.Errorf("message %d malformed", i)
+		}
+		for _, rr := range dm.DNS.DnsRRs.Answers {
+			answers = append(answers, rr.Rdatatype)
+		}
+	}
+	if len(answers) != 4 || answers[0] != "SOA" || answers[1] != "A" || answers[2] != "NS" || answers[3] != "SOA" {
+		t.Errorf("invalid answers: %v", answers)
+	}
+}
+
+func Test_DnstapProcessor_TransferSummary(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Dnstap.TransferMode = dnsutils.TRANSFER_SUMMARY
+	consumer := NewDnstapProcessor(config, logger.New(false), "test")
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+	consumer.GetChannel() <- getFakeZoneTransfer(t)
+
+	// one record for the zone transfer
+	dm := <-chan_to
+	if dm.DNS.Qname != "example.com" || dm.DNS.Qtype != "AXFR" || len(dm.DNS.DnsRRs.Answers) != 0 {
+		t.Errorf("invalid summary: %s %s %d", dm.DNS.Qname, dm.DNS.Qtype, len(dm.DNS.DnsRRs.Answers))
+	}
+	if dm.Transfer == nil || dm.Transfer.Messages != 3 || dm.Transfer.Records != 4 || dm.Transfer.Types["SOA"] != 2 {
+		t.Fatalf("invalid transfer: %+v", dm.Transfer)
+	}
+	if len(dm.Transfer.Serials) != 1 || dm.Transfer.Serials[0] != 2024010101 {
+		t.Errorf("invalid serials: %v", dm.Transfer.Serials)
+	}
+	if len(chan_to) != 0 {
+		t.Errorf("no other record expected, got %d", len(chan_to))
+	}
+}
+
+func Test_DnstapProcessor_Timing(t *testing.T) {
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("www.google.fr.", dns.TypeA)
+	dnsquestion, _ := dnsmsg.Pack()
+	data, _ := proto.Marshal(GetFakeDnstap(dnsquestion))
+
+	for _, timing := range []bool{false, true} {
+		consumer := NewDnstapProcessor(dnsutils.GetFakeConfig(), logger.New(false), "test")
+		if timing {
+			consumer.EnableTiming()
+		}
+		chan_to := make(chan dnsutils.DnsMessage, 512)
+		go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+		consumer.GetChannel() <- data
+		<-chan_to
+		consumer.Stop()
+
+		stats := consumer.Stats()[0]
+		if stats.Decoded != 1 {
+			t.Errorf("one message decoded expected: %d", stats.Decoded)
+		}
+		measured := stats.UnmarshalTime > 0 && stats.DecodeTime > 0 && stats.TransformTime > 0
+		if measured != timing {
+			t.Errorf("timing %v: invalid stages time %+v", timing, stats)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-framestream"
 	"github.com/dmachard/go-logger"
 )
@@ -60,6 +61,10 @@ func (c *DnstapProxifier) ReadConfig() {
 	c.sockPath = c.config.Collectors.DnstapProxifier.SockPath
 }
 
+func (c *DnstapProxifier) ReloadConfig(config *dnsutils.Config) {
+	// the relay has no transformers, nothing to reload
+}
+
 func (c *DnstapProxifier) LogInfo(msg string, v ...interface{}) {
 	c.logger.Info("["+c.name+"] dnstap collector relay - "+msg, v...)
 }
@@ -147,7 +152,7 @@ func (c *DnstapProxifier) Listen() error {
 
 	var err error
 	var listener net.Listener
-	addrlisten := c.config.Collectors.DnstapProxifier.ListenIP + ":" + strconv.Itoa(c.config.Collectors.DnstapProxifier.ListenPort)
+	addrlisten := net.JoinHostPort(c.config.Collectors.DnstapProxifier.ListenIP, strconv.Itoa(c.config.Collectors.DnstapProxifier.ListenPort))
 
 	if len(c.sockPath) > 0 {
 		_ = os.Remove(c.sockPath)
@@ -171,6 +176,20 @@ func (c *DnstapProxifier) Listen() error {
 		// update tls min version according to the user config
 		tlsConfig.MinVersion = dnsutils.TLS_VERSION[c.config.Collectors.DnstapProxifier.TlsMinVersion]
 
+		// verify the client certificates, with the server certificate if no ca is provided
+		if c.config.Collectors.DnstapProxifier.TlsMutual {
+			caFile := c.config.Collectors.DnstapProxifier.CaFile
+			if len(caFile) == 0 {
+				caFile = c.config.Collectors.DnstapProxifier.CertFile
+			}
+			caCertPool, err := netlib.LoadCertPool(caFile)
+			if err != nil {
+				c.logger.Fatal("loading ca failed:", err)
+			}
+			tlsConfig.ClientCAs = caCertPool
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
 		if len(c.sockPath) > 0 {
 			listener, err = tls.Listen(dnsutils.SOCKET_UNIX, c.sockPath, tlsConfig)
 		} else {
@@ -2,6 +2,7 @@ package collectors
 
 import (
 	"bufio"
+	"crypto/tls"
 	"log"
 	"net"
 	"testing"
@@ -110,3 +111,117 @@ func Test_DnstapCollector(t *testing.T) {
 		})
 	}
 }
+
+func Test_DnstapCollector_TlsMutual(t *testing.T) {
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Dnstap.ListenPort = 7001
+	config.Collectors.Dnstap.TlsSupport = true
+	config.Collectors.Dnstap.TlsMutual = true
+	config.Collectors.Dnstap.CertFile = "./../testsdata/server.crt"
+	config.Collectors.Dnstap.KeyFile = "./../testsdata/server.key"
+
+	c := NewDnstap([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		log.Fatal("collector listening  error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	// client without certificate is rejected
+	conn, err := tls.Dial(dnsutils.SOCKET_TCP, "127.0.0.1:7001", &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil {
+		t.Errorf("client without certificate should be rejected")
+	}
+
+	// client with a certificate signed by the ca
+	cer, err := tls.LoadX509KeyPair(config.Collectors.Dnstap.CertFile, config.Collectors.Dnstap.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = tls.Dial(dnsutils.SOCKET_TCP, "127.0.0.1:7001", &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cer}})
+	if err != nil {
+		t.Fatal("could not connect: ", err)
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	fs := framestream.NewFstrm(r, w, conn, 5*time.Second, []byte("protobuf:dnstap.Dnstap"), true)
+	if err := fs.InitSender(); err != nil {
+		t.Fatalf("framestream init error: %s", err)
+	}
+
+	dnsquery, err := GetFakeDns()
+	if err != nil {
+		t.Fatalf("dns question pack error")
+	}
+	data, err := proto.Marshal(GetFakeDnstap(dnsquery))
+	if err != nil {
+		t.Fatalf("dnstap proto marshal error %s", err)
+	}
+	frame := &framestream.Frame{}
+	frame.Write(data)
+	if err := fs.SendFrame(frame); err != nil {
+		t.Fatalf("send frame error %s", err)
+	}
+
+	msg := <-g.Channel()
+	if msg.DnsTap.Operation != "CLIENT_QUERY" {
+		t.Errorf("want CLIENT_QUERY, got %s", msg.DnsTap.Operation)
+	}
+}
+
+func Test_DnstapCollector_DualStack(t *testing.T) {
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Dnstap.ListenIP = "::"
+	config.Collectors.Dnstap.ListenPort = 7002
+
+	c := NewDnstap([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Skip("ipv6 not supported: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	// ipv4 and ipv6 clients
+	for _, address := range []string{"127.0.0.1:7002", "[::1]:7002"} {
+		conn, err := net.Dial(dnsutils.SOCKET_TCP, address)
+		if err != nil {
+			t.Fatal("could not connect: ", err)
+		}
+		defer conn.Close()
+
+		fs := framestream.NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, 5*time.Second, []byte("protobuf:dnstap.Dnstap"), true)
+		if err := fs.InitSender(); err != nil {
+			t.Fatalf("framestream init error: %s", err)
+		}
+
+		dnsquery, err := GetFakeDns()
+		if err != nil {
+			t.Fatalf("dns question pack error")
+		}
+		data, err := proto.Marshal(GetFakeDnstap(dnsquery))
+		if err != nil {
+			t.Fatalf("dnstap proto marshal error %s", err)
+		}
+		frame := &framestream.Frame{}
+		frame.Write(data)
+		if err := fs.SendFrame(frame); err != nil {
+			t.Fatalf("send frame error %s", err)
+		}
+
+		msg := <-g.Channel()
+		if msg.DnsTap.Operation != "CLIENT_QUERY" {
+			t.Errorf("%s - want CLIENT_QUERY, got %s", address, msg.DnsTap.Operation)
+		}
+	}
+}
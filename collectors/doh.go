@@ -0,0 +1,284 @@
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+const dohMediaType = "application/dns-message"
+
+// DohServer is a DNS-over-HTTPS endpoint (RFC 8484) logging the queries received,
+// the queries are forwarded to the upstream resolver if provided, refused otherwise
+type DohServer struct {
+	done         chan bool
+	listen       net.Listener
+	server       *http.Server
+	client       *dns.Client
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	identity     string
+	processors   *Processors
+	dnsProcessor DnsProcessor
+}
+
+func NewDoh(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *DohServer {
+	logger.Info("[%s] doh collector - enabled", name)
+	s := &DohServer{
+		done:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		processors: NewProcessors(config),
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *DohServer) GetName() string { return c.name }
+
+func (c *DohServer) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *DohServer) Loggers() []chan dnsutils.DnsMessage {
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *DohServer) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *DohServer) ReadConfig() {
+	if !dnsutils.IsValidTLS(c.config.Collectors.Doh.TlsMinVersion) {
+		c.logger.Fatal("collector doh - invalid tls min version")
+	}
+	c.identity = c.config.GetServerIdentity()
+	c.client = &dns.Client{Net: dnsutils.SOCKET_UDP, Timeout: time.Duration(c.config.Collectors.Doh.Timeout) * time.Second}
+}
+
+func (c *DohServer) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
+}
+
+func (c *DohServer) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] doh collector - "+msg, v...)
+}
+
+func (c *DohServer) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] doh collector - "+msg, v...)
+}
+
+func (c *DohServer) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+// ReadQuery returns the dns query of the request, sent in the body of a POST
+// or encoded in base64url in the dns parameter of a GET
+func (c *DohServer) ReadQuery(r *http.Request) ([]byte, int) {
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if len(param) == 0 {
+			return nil, http.StatusBadRequest
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(param)
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		return payload, http.StatusOK
+
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMediaType {
+			return nil, http.StatusUnsupportedMediaType
+		}
+		payload, err := io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		return payload, http.StatusOK
+	}
+	return nil, http.StatusMethodNotAllowed
+}
+
+// Resolve returns the reply of the upstream resolver, or a REFUSED reply without upstream
+func (c *DohServer) Resolve(query []byte) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	if len(c.config.Collectors.Doh.Upstream) == 0 {
+		reply := new(dns.Msg)
+		reply.SetRcode(msg, dns.RcodeRefused)
+		return reply.Pack()
+	}
+
+	reply, _, err := c.client.Exchange(msg, c.config.Collectors.Doh.Upstream)
+	if err != nil {
+		c.LogError("upstream error: %v", err)
+		reply = new(dns.Msg)
+		reply.SetRcode(msg, dns.RcodeServerFailure)
+	}
+	return reply.Pack()
+}
+
+// newMessage returns the message of a payload sent from the source to the destination
+func (c *DohServer) newMessage(r *http.Request, payload []byte, src string, dst string) dnsutils.DnsMessage {
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+	dm.DnsTap.Identity = c.identity
+
+	now := time.Now()
+	dm.DnsTap.TimeSec = int(now.Unix())
+	dm.DnsTap.TimeNsec = now.Nanosecond()
+
+	dm.NetworkInfo.Protocol = dnsutils.PROTO_DOH
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	if ip, port, err := net.SplitHostPort(src); err == nil {
+		dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort = ip, port
+		if net.ParseIP(ip).To4() == nil {
+			dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+		}
+	}
+	if ip, port, err := net.SplitHostPort(dst); err == nil {
+		dm.NetworkInfo.ResponseIp, dm.NetworkInfo.ResponsePort = ip, port
+	}
+
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+	dm.Http = &dnsutils.DnsHttp{
+		Method:    r.Method,
+		Uri:       r.RequestURI,
+		Version:   r.Proto,
+		UserAgent: r.UserAgent(),
+	}
+	if r.TLS != nil {
+		dm.Tls = &dnsutils.DnsTls{ServerName: r.TLS.ServerName, Version: dnsutils.TlsVersionName(r.TLS.Version)}
+	}
+	return dm
+}
+
+func (c *DohServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query, status := c.ReadQuery(r)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if len(query) < dnsutils.DnsLen {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	client := r.RemoteAddr
+	server := ""
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		server = addr.String()
+	}
+	c.dnsProcessor.GetChannel() <- c.newMessage(r, query, client, server)
+
+	reply, err := c.Resolve(query)
+	if err != nil {
+		c.LogError("invalid query from %s: %v", client, err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	c.dnsProcessor.GetChannel() <- c.newMessage(r, reply, server, client)
+
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(reply)))
+	w.Write(reply)
+}
+
+func (c *DohServer) Listen() error {
+	c.LogInfo("running in background...")
+
+	var err error
+	var listener net.Listener
+	addrlisten := net.JoinHostPort(c.config.Collectors.Doh.ListenIP, strconv.Itoa(c.config.Collectors.Doh.ListenPort))
+
+	// listening with tls enabled ?
+	if c.config.Collectors.Doh.TlsSupport {
+		c.LogInfo("tls support enabled")
+		var cer tls.Certificate
+		cer, err = tls.LoadX509KeyPair(c.config.Collectors.Doh.CertFile, c.config.Collectors.Doh.KeyFile)
+		if err != nil {
+			c.logger.Fatal("loading certificate failed:", err)
+		}
+
+		// prepare tls configuration
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		// update tls min version according to the user config
+		tlsConfig.MinVersion = dnsutils.TLS_VERSION[c.config.Collectors.Doh.TlsMinVersion]
+
+		listener, err = tls.Listen(dnsutils.SOCKET_TCP, addrlisten, tlsConfig)
+	} else {
+		listener, err = net.Listen(dnsutils.SOCKET_TCP, addrlisten)
+	}
+	// something is wrong ?
+	if err != nil {
+		return err
+	}
+	c.LogInfo("is listening on %s", listener.Addr())
+	c.listen = listener
+
+	mux := http.NewServeMux()
+	mux.Handle(c.config.Collectors.Doh.Path, c)
+	c.server = &http.Server{Handler: mux}
+	return nil
+}
+
+func (c *DohServer) Stop() {
+	c.LogInfo("stopping...")
+
+	// stop listening and wait for the pending requests
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.server.Shutdown(ctx); err != nil {
+		c.server.Close()
+	}
+
+	// read done channel and block until run is terminated
+	<-c.done
+	close(c.done)
+}
+
+func (c *DohServer) Run() {
+	c.LogInfo("starting collector...")
+	if c.listen == nil {
+		if err := c.Listen(); err != nil {
+			c.logger.Fatal("collector doh listening failed: ", err)
+		}
+	}
+
+	c.dnsProcessor = NewDnsProcessor(c.processors.Config(), c.logger, c.name)
+	c.dnsProcessor.SetBatchChannels(c.BatchLoggers())
+	go c.dnsProcessor.Run(c.Loggers())
+	c.processors.Add(&c.dnsProcessor)
+
+	c.server.Serve(c.listen)
+
+	// stop dns processor
+	c.processors.Remove(&c.dnsProcessor)
+	c.dnsProcessor.Stop()
+
+	c.LogInfo("run terminated")
+	c.done <- true
+}
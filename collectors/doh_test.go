@@ -0,0 +1,116 @@
+package collectors
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func Test_DohCollector_Post(t *testing.T) {
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Doh.ListenIP = dnsutils.LOCALHOST_IP
+	config.Collectors.Doh.ListenPort = 8053
+
+	c := NewDoh([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		log.Fatal("collector listening  error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	query, _ := GetFakeDns()
+	req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:8053/dns-query", bytes.NewReader(query))
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("User-Agent", "doh-test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http request error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// refused without upstream
+	body, _ := io.ReadAll(resp.Body)
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil || reply.Rcode != dns.RcodeRefused {
+		t.Errorf("refused reply expected: %v %v", err, reply)
+	}
+
+	for _, operation := range []string{dnsutils.DNSTAP_CLIENT_QUERY, dnsutils.DNSTAP_CLIENT_RESPONSE} {
+		dm := <-g.Channel()
+		if dm.DnsTap.Operation != operation || dm.NetworkInfo.Protocol != dnsutils.PROTO_DOH || dm.NetworkInfo.QueryIp != "127.0.0.1" {
+			t.Errorf("invalid message: %s %s %s", dm.DnsTap.Operation, dm.NetworkInfo.Protocol, dm.NetworkInfo.QueryIp)
+		}
+		if dm.Http == nil || dm.Http.Method != http.MethodPost || dm.Http.Uri != "/dns-query" || dm.Http.UserAgent != "doh-test" {
+			t.Errorf("invalid http metadata: %+v", dm.Http)
+		}
+	}
+
+	// only dns messages are accepted
+	resp, err = http.Post("http://127.0.0.1:8053/dns-query", "text/plain", bytes.NewReader(query))
+	if err != nil {
+		t.Fatalf("http request error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("unsupported media type expected, got %d", resp.StatusCode)
+	}
+}
+
+func Test_DohCollector_GetUpstream(t *testing.T) {
+	// fake upstream resolver
+	upstream := &dns.Server{Addr: "127.0.0.1:5397", Net: "udp", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR("dns.collector. 60 IN A 127.0.0.1")
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	})}
+	started := make(chan bool)
+	upstream.NotifyStartedFunc = func() { close(started) }
+	go upstream.ListenAndServe()
+	defer upstream.Shutdown()
+	<-started
+
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Doh.ListenIP = dnsutils.LOCALHOST_IP
+	config.Collectors.Doh.ListenPort = 8054
+	config.Collectors.Doh.Upstream = "127.0.0.1:5397"
+
+	c := NewDoh([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		log.Fatal("collector listening  error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	query, _ := GetFakeDns()
+	resp, err := http.Get("http://127.0.0.1:8054/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(query))
+	if err != nil {
+		t.Fatalf("http request error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil || len(reply.Answer) != 1 {
+		t.Errorf("reply of the upstream expected: %v %v", err, reply)
+	}
+
+	<-g.Channel()
+	dm := <-g.Channel()
+	if dm.DNS.Type != dnsutils.DnsReply || dm.DNS.Rcode != dnsutils.DNS_RCODE_NOERROR || len(dm.DNS.DnsRRs.Answers) != 1 {
+		t.Errorf("invalid reply: %s %s %v", dm.DNS.Type, dm.DNS.Rcode, dm.DNS.DnsRRs.Answers)
+	}
+}
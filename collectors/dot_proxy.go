@@ -0,0 +1,416 @@
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// DotProxy terminates the DNS-over-TLS and DNS-over-QUIC connections of the clients and
+// forwards the queries to the upstream resolver, the queries and the replies are logged
+// with the server name and the version of the tls session
+type DotProxy struct {
+	sync.Mutex
+	done         chan bool
+	listen       net.Listener
+	quicListen   *quic.Listener
+	conns        map[net.Conn]bool
+	quicConns    map[quic.Connection]bool
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	identity     string
+	processors   *Processors
+	dnsProcessor DnsProcessor
+	handlers     sync.WaitGroup
+}
+
+func NewDotProxy(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *DotProxy {
+	logger.Info("[%s] dot proxy collector - enabled", name)
+	s := &DotProxy{
+		done:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		conns:      make(map[net.Conn]bool),
+		quicConns:  make(map[quic.Connection]bool),
+		processors: NewProcessors(config),
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *DotProxy) GetName() string { return c.name }
+
+func (c *DotProxy) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *DotProxy) Loggers() []chan dnsutils.DnsMessage {
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *DotProxy) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *DotProxy) ReadConfig() {
+	if !dnsutils.IsValidTLS(c.config.Collectors.DotProxy.TlsMinVersion) {
+		c.logger.Fatal("collector dot proxy - invalid tls min version")
+	}
+	c.identity = c.config.GetServerIdentity()
+}
+
+func (c *DotProxy) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
+}
+
+func (c *DotProxy) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] dot proxy collector - "+msg, v...)
+}
+
+func (c *DotProxy) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] dot proxy collector - "+msg, v...)
+}
+
+func (c *DotProxy) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+// dialUpstream opens a connection to the upstream resolver, with tls if enabled
+func (c *DotProxy) dialUpstream() (*dns.Conn, error) {
+	cfg := c.config.Collectors.DotProxy
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if cfg.UpstreamTls {
+		return dns.DialTimeoutWithTLS("tcp-tls", cfg.Upstream, &tls.Config{MinVersion: tls.VersionTLS12}, timeout)
+	}
+	return dns.DialTimeout(dnsutils.SOCKET_TCP, cfg.Upstream, timeout)
+}
+
+// forward sends the query to the upstream resolver and returns the reply, the upstream
+// connection is opened again after an error
+func (c *DotProxy) forward(upstream **dns.Conn, query []byte) ([]byte, error) {
+	var err error
+	if *upstream == nil {
+		if *upstream, err = c.dialUpstream(); err != nil {
+			return nil, err
+		}
+	}
+
+	conn := *upstream
+	conn.SetDeadline(time.Now().Add(time.Duration(c.config.Collectors.DotProxy.Timeout) * time.Second))
+	if _, err = conn.Write(query); err == nil {
+		reply := make([]byte, dns.MaxMsgSize)
+		var n int
+		if n, err = conn.Read(reply); err == nil {
+			return reply[:n], nil
+		}
+	}
+
+	conn.Close()
+	*upstream = nil
+	return nil, err
+}
+
+// newMessage returns the message of a payload sent from the source to the destination
+func (c *DotProxy) newMessage(payload []byte, src net.Addr, dst net.Addr, state tls.ConnectionState, protocol string) dnsutils.DnsMessage {
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+	dm.DnsTap.Identity = c.identity
+
+	now := time.Now()
+	dm.DnsTap.TimeSec = int(now.Unix())
+	dm.DnsTap.TimeNsec = now.Nanosecond()
+
+	dm.NetworkInfo.Protocol = protocol
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	if ip, port, err := net.SplitHostPort(src.String()); err == nil {
+		dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort = ip, port
+		if net.ParseIP(ip).To4() == nil {
+			dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+		}
+	}
+	if ip, port, err := net.SplitHostPort(dst.String()); err == nil {
+		dm.NetworkInfo.ResponseIp, dm.NetworkInfo.ResponsePort = ip, port
+	}
+
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+	dm.Tls = &dnsutils.DnsTls{ServerName: state.ServerName, Version: dnsutils.TlsVersionName(state.Version)}
+	return dm
+}
+
+func (c *DotProxy) HandleConn(conn net.Conn) {
+	// close connection on function exit
+	defer c.handlers.Done()
+	defer func() {
+		c.Lock()
+		delete(c.conns, conn)
+		c.Unlock()
+		conn.Close()
+	}()
+
+	peer := conn.RemoteAddr().String()
+	tlsConn := conn.(*tls.Conn)
+	if err := tlsConn.Handshake(); err != nil {
+		c.LogError("%s - tls handshake error: %s", peer, err)
+		return
+	}
+	state := tlsConn.ConnectionState()
+
+	var upstream *dns.Conn
+	defer func() {
+		if upstream != nil {
+			upstream.Close()
+		}
+	}()
+
+	// the queries are prefixed by their length
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			break
+		}
+		query := make([]byte, binary.BigEndian.Uint16(header))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			break
+		}
+		if len(query) < dnsutils.DnsLen {
+			c.LogError("%s - query too short", peer)
+			break
+		}
+
+		reply, err := c.exchange(&upstream, query, conn.RemoteAddr(), conn.LocalAddr(), state, dnsutils.PROTO_DOT)
+		if err != nil {
+			break
+		}
+
+		binary.BigEndian.PutUint16(header, uint16(len(reply)))
+		if _, err := conn.Write(append(header, reply...)); err != nil {
+			break
+		}
+	}
+}
+
+// exchange logs the query, forwards it to the upstream resolver and logs the reply,
+// a servfail is returned to the client when the upstream fails
+func (c *DotProxy) exchange(upstream **dns.Conn, query []byte, client net.Addr, local net.Addr, state tls.ConnectionState, protocol string) ([]byte, error) {
+	c.dnsProcessor.GetChannel() <- c.newMessage(query, client, local, state, protocol)
+
+	reply, err := c.forward(upstream, query)
+	if err != nil {
+		c.LogError("upstream error: %v", err)
+		msg := new(dns.Msg)
+		if err := msg.Unpack(query); err != nil {
+			return nil, err
+		}
+		failure := new(dns.Msg)
+		failure.SetRcode(msg, dns.RcodeServerFailure)
+		if reply, err = failure.Pack(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the id of the query is restored before the reply is logged, the message id is zero on quic
+	copy(reply[:2], query[:2])
+	c.dnsProcessor.GetChannel() <- c.newMessage(reply, local, client, state, protocol)
+	return reply, nil
+}
+
+// HandleQuicConn serves the streams of a DNS-over-QUIC connection (RFC 9250), each stream
+// carries one query prefixed by its length. The queries of the connection are forwarded
+// one at a time on the same upstream connection
+func (c *DotProxy) HandleQuicConn(conn quic.Connection) {
+	defer c.handlers.Done()
+	defer func() {
+		c.Lock()
+		delete(c.quicConns, conn)
+		c.Unlock()
+		conn.CloseWithError(0, "")
+	}()
+
+	peer := conn.RemoteAddr().String()
+	state := conn.ConnectionState().TLS
+
+	var mu sync.Mutex
+	var streams sync.WaitGroup
+	var upstream *dns.Conn
+	defer func() {
+		streams.Wait()
+		if upstream != nil {
+			upstream.Close()
+		}
+	}()
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			break
+		}
+
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+			defer stream.Close()
+
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(stream, header); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(header))
+			if _, err := io.ReadFull(stream, query); err != nil {
+				return
+			}
+			if len(query) < dnsutils.DnsLen {
+				c.LogError("%s - query too short", peer)
+				return
+			}
+
+			mu.Lock()
+			reply, err := c.exchange(&upstream, query, conn.RemoteAddr(), conn.LocalAddr(), state, dnsutils.PROTO_DOQ)
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+
+			binary.BigEndian.PutUint16(header, uint16(len(reply)))
+			stream.Write(append(header, reply...))
+		}()
+	}
+}
+
+// AcceptQuic accepts the DNS-over-QUIC connections until the listener is closed
+func (c *DotProxy) AcceptQuic() {
+	defer c.handlers.Done()
+	for {
+		conn, err := c.quicListen.Accept(context.Background())
+		if err != nil {
+			break
+		}
+
+		c.Lock()
+		c.quicConns[conn] = true
+		c.Unlock()
+		c.handlers.Add(1)
+		go c.HandleQuicConn(conn)
+	}
+}
+
+func (c *DotProxy) Listen() error {
+	c.LogInfo("running in background...")
+
+	cer, err := tls.LoadX509KeyPair(c.config.Collectors.DotProxy.CertFile, c.config.Collectors.DotProxy.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	// prepare tls configuration
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cer},
+		MinVersion:   dnsutils.TLS_VERSION[c.config.Collectors.DotProxy.TlsMinVersion],
+		NextProtos:   []string{"dot"},
+	}
+
+	addrlisten := net.JoinHostPort(c.config.Collectors.DotProxy.ListenIP, strconv.Itoa(c.config.Collectors.DotProxy.ListenPort))
+	listener, err := tls.Listen(dnsutils.SOCKET_TCP, addrlisten, tlsConfig)
+	if err != nil {
+		return err
+	}
+	c.LogInfo("is listening on %s", listener.Addr())
+	c.listen = listener
+
+	// the dns-over-quic listener shares the certificate
+	if c.config.Collectors.DotProxy.QuicPort > 0 {
+		quicConfig := tlsConfig.Clone()
+		quicConfig.MinVersion = tls.VersionTLS13
+		quicConfig.NextProtos = []string{"doq"}
+
+		addrquic := net.JoinHostPort(c.config.Collectors.DotProxy.ListenIP, strconv.Itoa(c.config.Collectors.DotProxy.QuicPort))
+		quicListener, err := quic.ListenAddr(addrquic, quicConfig, &quic.Config{})
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		c.LogInfo("is listening on %s/quic", quicListener.Addr())
+		c.quicListen = quicListener
+	}
+	return nil
+}
+
+func (c *DotProxy) Stop() {
+	c.LogInfo("stopping...")
+
+	// closing properly current connections if exists
+	c.Lock()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	for conn := range c.quicConns {
+		conn.CloseWithError(0, "")
+	}
+	c.Unlock()
+
+	// Finally close the listeners to unblock accept
+	if c.quicListen != nil {
+		c.quicListen.Close()
+	}
+	c.listen.Close()
+
+	// read done channel and block until run is terminated
+	<-c.done
+	close(c.done)
+}
+
+func (c *DotProxy) Run() {
+	c.LogInfo("starting collector...")
+	if c.listen == nil {
+		if err := c.Listen(); err != nil {
+			c.logger.Fatal("collector dot proxy listening failed: ", err)
+		}
+	}
+
+	c.dnsProcessor = NewDnsProcessor(c.processors.Config(), c.logger, c.name)
+	c.dnsProcessor.SetBatchChannels(c.BatchLoggers())
+	go c.dnsProcessor.Run(c.Loggers())
+	c.processors.Add(&c.dnsProcessor)
+
+	if c.quicListen != nil {
+		c.handlers.Add(1)
+		go c.AcceptQuic()
+	}
+
+	for {
+		// Accept() blocks waiting for new connection.
+		conn, err := c.listen.Accept()
+		if err != nil {
+			break
+		}
+
+		c.Lock()
+		c.conns[conn] = true
+		c.Unlock()
+		c.handlers.Add(1)
+		go c.HandleConn(conn)
+	}
+
+	// wait the connections before to stop the dns processor
+	c.handlers.Wait()
+	c.processors.Remove(&c.dnsProcessor)
+	c.dnsProcessor.Stop()
+
+	c.LogInfo("run terminated")
+	c.done <- true
+}
@@ -0,0 +1,111 @@
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+func Test_DotProxyCollector(t *testing.T) {
+	// fake upstream resolver
+	upstream := &dns.Server{Addr: "127.0.0.1:5396", Net: "tcp", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(m)
+	})}
+	started := make(chan bool)
+	upstream.NotifyStartedFunc = func() { close(started) }
+	go upstream.ListenAndServe()
+	defer upstream.Shutdown()
+	<-started
+
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.DotProxy.ListenIP = dnsutils.LOCALHOST_IP
+	config.Collectors.DotProxy.ListenPort = 8853
+	config.Collectors.DotProxy.QuicPort = 8853
+	config.Collectors.DotProxy.CertFile = "./../testsdata/server.crt"
+	config.Collectors.DotProxy.KeyFile = "./../testsdata/server.key"
+	config.Collectors.DotProxy.Upstream = "127.0.0.1:5396"
+
+	c := NewDotProxy([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		log.Fatal("collector listening  error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	client := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{InsecureSkipVerify: true, ServerName: "dns.collector", MaxVersion: tls.VersionTLS12}}
+	query := new(dns.Msg)
+	query.SetQuestion("dns.collector.", dns.TypeA)
+	reply, _, err := client.Exchange(query, "127.0.0.1:8853")
+	if err != nil {
+		t.Fatalf("dot exchange error: %s", err)
+	}
+	if reply.Rcode != dns.RcodeNameError {
+		t.Errorf("reply of the upstream expected: %v", reply)
+	}
+
+	for _, operation := range []string{dnsutils.DNSTAP_CLIENT_QUERY, dnsutils.DNSTAP_CLIENT_RESPONSE} {
+		dm := <-g.Channel()
+		if dm.DnsTap.Operation != operation || dm.NetworkInfo.Protocol != dnsutils.PROTO_DOT || dm.NetworkInfo.QueryIp != "127.0.0.1" {
+			t.Errorf("invalid message: %s %s %s", dm.DnsTap.Operation, dm.NetworkInfo.Protocol, dm.NetworkInfo.QueryIp)
+		}
+		if dm.Tls == nil || dm.Tls.ServerName != "dns.collector" || dm.Tls.Version != dnsutils.TLS_v12 {
+			t.Errorf("invalid tls metadata: %+v", dm.Tls)
+		}
+	}
+
+	// dns over quic, one query per stream with a zero message id
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, "127.0.0.1:8853", &tls.Config{InsecureSkipVerify: true, ServerName: "dns.collector", NextProtos: []string{"doq"}}, nil)
+	if err != nil {
+		t.Fatalf("doq dial error: %s", err)
+	}
+	defer conn.CloseWithError(0, "")
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("doq stream error: %s", err)
+	}
+	query.Id = 0
+	payload, _ := query.Pack()
+	header := make([]byte, 2)
+	binary.BigEndian.PutUint16(header, uint16(len(payload)))
+	stream.Write(append(header, payload...))
+	stream.Close()
+
+	if _, err := io.ReadFull(stream, header); err != nil {
+		t.Fatalf("doq read error: %s", err)
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(stream, answer); err != nil {
+		t.Fatalf("doq read error: %s", err)
+	}
+	reply = new(dns.Msg)
+	if err := reply.Unpack(answer); err != nil || reply.Id != 0 || reply.Rcode != dns.RcodeNameError {
+		t.Errorf("reply of the upstream expected over quic: %v %v", reply, err)
+	}
+
+	for _, operation := range []string{dnsutils.DNSTAP_CLIENT_QUERY, dnsutils.DNSTAP_CLIENT_RESPONSE} {
+		dm := <-g.Channel()
+		if dm.DnsTap.Operation != operation || dm.NetworkInfo.Protocol != dnsutils.PROTO_DOQ {
+			t.Errorf("invalid message: %s %s", dm.DnsTap.Operation, dm.NetworkInfo.Protocol)
+		}
+		if dm.Tls == nil || dm.Tls.ServerName != "dns.collector" || dm.Tls.Version != dnsutils.TLS_v13 {
+			t.Errorf("invalid tls metadata: %+v", dm.Tls)
+		}
+	}
+}
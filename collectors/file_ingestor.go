@@ -1,13 +1,14 @@
 package collectors
 
 import (
+	"compress/gzip"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
 )
 
 var waitFor = 10 * time.Second
@@ -33,6 +35,57 @@ func IsValidMode(mode string) bool {
 	return false
 }
 
+// IsIngestFile returns true if the file must be processed according to the mode
+func IsIngestFile(mode string, filePath string) bool {
+	switch mode {
+	case dnsutils.MODE_PCAP:
+		return strings.HasSuffix(filePath, ".pcap") || strings.HasSuffix(filePath, ".pcapng") ||
+			strings.HasSuffix(filePath, ".pcap.gz")
+	case dnsutils.MODE_DNSTAP:
+		return strings.HasSuffix(filePath, ".fstrm") || strings.HasSuffix(filePath, ".fstrm.gz") ||
+			strings.HasSuffix(filePath, ".fstrm.zst") || strings.HasSuffix(filePath, ".dnstap")
+	}
+	return false
+}
+
+// NewDnstapSource returns a reader for dnstap files, decompressed according to the extension
+func NewDnstapSource(f *os.File) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(f.Name(), ".gz"):
+		return gzip.NewReader(f)
+	case strings.HasSuffix(f.Name(), ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(f), nil
+}
+
+// PcapSource is implemented by the pcap and pcapng readers
+type PcapSource interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// NewPcapSource returns a reader for pcap (gzipped or not) and pcapng files
+func NewPcapSource(f *os.File) (PcapSource, error) {
+	pcapReader, err := pcapgo.NewReader(f)
+	if err == nil {
+		return pcapReader, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	ngReader, ngErr := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if ngErr != nil {
+		return nil, err
+	}
+	return ngReader, nil
+}
+
 type FileIngestor struct {
 	done            chan bool
 	exit            chan bool
@@ -46,6 +99,7 @@ type FileIngestor struct {
 	filterDnsPort   int
 	identity        string
 	name            string
+	processors      *Processors
 	mu              sync.Mutex
 }
 
@@ -58,6 +112,7 @@ func NewFileIngestor(loggers []dnsutils.Worker, config *dnsutils.Config, logger
 		loggers:       loggers,
 		logger:        logger,
 		name:          name,
+		processors:    NewProcessors(config),
 		watcherTimers: make(map[string]*time.Timer),
 	}
 	s.ReadConfig()
@@ -71,11 +126,11 @@ func (c *FileIngestor) SetLoggers(loggers []dnsutils.Worker) {
 }
 
 func (c *FileIngestor) Loggers() []chan dnsutils.DnsMessage {
-	channels := []chan dnsutils.DnsMessage{}
-	for _, p := range c.loggers {
-		channels = append(channels, p.Channel())
-	}
-	return channels
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *FileIngestor) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
 }
 
 func (c *FileIngestor) ReadConfig() {
@@ -83,12 +138,27 @@ func (c *FileIngestor) ReadConfig() {
 		c.logger.Fatal("collector file ingestor - invalid mode: ", c.config.Collectors.FileIngestor.WatchMode)
 	}
 
+	if len(c.config.Collectors.FileIngestor.WatchDir) == 0 && len(c.config.Collectors.FileIngestor.Files) == 0 {
+		c.logger.Fatal("collector file ingestor - watch-dir or files is required")
+	}
+
+	if c.config.Collectors.FileIngestor.PcapReplayRate < 0 {
+		c.logger.Fatal("collector file ingestor - invalid pcap-replay-rate: ", c.config.Collectors.FileIngestor.PcapReplayRate)
+	}
+
 	c.identity = c.config.GetServerIdentity()
 	c.filterDnsPort = c.config.Collectors.FileIngestor.PcapDnsPort
 
-	c.LogInfo("watching directory [%s] to find [%s] files",
-		c.config.Collectors.FileIngestor.WatchDir,
-		c.config.Collectors.FileIngestor.WatchMode)
+	if len(c.config.Collectors.FileIngestor.WatchDir) > 0 {
+		c.LogInfo("watching directory [%s] to find [%s] files",
+			c.config.Collectors.FileIngestor.WatchDir,
+			c.config.Collectors.FileIngestor.WatchMode)
+	}
+}
+
+func (c *FileIngestor) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
 }
 
 func (c *FileIngestor) LogInfo(msg string, v ...interface{}) {
@@ -107,7 +177,9 @@ func (c *FileIngestor) Stop() {
 	c.LogInfo("stopping...")
 
 	// stop watching
-	c.watcher.Close()
+	if c.watcher != nil {
+		c.watcher.Close()
+	}
 
 	// exit to close properly
 	c.exit <- true
@@ -118,19 +190,17 @@ func (c *FileIngestor) Stop() {
 }
 
 func (c *FileIngestor) ProcessFile(filePath string) {
+	// process file with the expected extension only
+	if !IsIngestFile(c.config.Collectors.FileIngestor.WatchMode, filePath) {
+		return
+	}
+
+	c.LogInfo("file ready to process %s", filePath)
 	switch c.config.Collectors.FileIngestor.WatchMode {
 	case dnsutils.MODE_PCAP:
-		// process file with pcap extension only
-		if filepath.Ext(filePath) == ".pcap" || filepath.Ext(filePath) == ".pcap.gz" {
-			c.LogInfo("file ready to process %s", filePath)
-			go c.ProcessPcap(filePath)
-		}
+		go c.ProcessPcap(filePath)
 	case dnsutils.MODE_DNSTAP:
-		// processs dnstap
-		if filepath.Ext(filePath) == ".fstrm" {
-			c.LogInfo("file ready to process %s", filePath)
-			go c.ProcessDnstap(filePath)
-		}
+		go c.ProcessDnstap(filePath)
 	}
 }
 
@@ -143,8 +213,8 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 	}
 	defer f.Close()
 
-	// it is a pcap file ?
-	pcapHandler, err := pcapgo.NewReader(f)
+	// it is a pcap or pcapng file ?
+	pcapHandler, err := NewPcapSource(f)
 	if err != nil {
 		c.LogError("unable to read pcap file: %s", err)
 		return
@@ -168,66 +238,71 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 	packetSource.DecodeOptions.Lazy = true
 	packetSource.NoCopy = true
 
-	// defrag ipv4
-	go netlib.IpDefragger(fragIp4Chan, udpChan, tcpChan)
-	// defrag ipv6
-	go netlib.IpDefragger(fragIp6Chan, udpChan, tcpChan)
-	// tcp assembly
-	go netlib.TcpAssembler(tcpChan, dnsChan, c.filterDnsPort)
-	// udp processor
-	go netlib.UdpProcessor(udpChan, dnsChan, c.filterDnsPort)
+	// defrag ipv4 and ipv6
+	var wgDefrag, wgProcess sync.WaitGroup
+	wgDefrag.Add(2)
+	go func() {
+		netlib.IpDefragger(fragIp4Chan, udpChan, tcpChan)
+		wgDefrag.Done()
+	}()
+	go func() {
+		netlib.IpDefragger(fragIp6Chan, udpChan, tcpChan)
+		wgDefrag.Done()
+	}()
+	// tcp assembly and udp processor
+	wgProcess.Add(2)
+	go func() {
+		netlib.TcpAssembler(tcpChan, dnsChan, c.filterDnsPort)
+		wgProcess.Done()
+	}()
+	go func() {
+		netlib.UdpProcessor(udpChan, dnsChan, c.filterDnsPort)
+		wgProcess.Done()
+	}()
 
+	dnsDone := make(chan bool)
 	go func() {
 		nbPackets := 0
-		lastReceivedTime := time.Now()
-		for {
-			select {
-			case dnsPacket, noMore := <-dnsChan:
-				if !noMore {
-					goto end
-				}
-
-				lastReceivedTime = time.Now()
-				// prepare dns message
-				dm := dnsutils.DnsMessage{}
-				dm.Init()
-
-				dm.NetworkInfo.Family = dnsPacket.IpLayer.EndpointType().String()
-				dm.NetworkInfo.QueryIp = dnsPacket.IpLayer.Src().String()
-				dm.NetworkInfo.ResponseIp = dnsPacket.IpLayer.Dst().String()
-				dm.NetworkInfo.QueryPort = dnsPacket.TransportLayer.Src().String()
-				dm.NetworkInfo.ResponsePort = dnsPacket.TransportLayer.Dst().String()
-				dm.NetworkInfo.Protocol = dnsPacket.TransportLayer.EndpointType().String()
-				dm.NetworkInfo.IpDefragmented = dnsPacket.IpDefragmented
-				dm.NetworkInfo.TcpReassembled = dnsPacket.TcpReassembled
-
-				dm.DNS.Payload = dnsPacket.Payload
-				dm.DNS.Length = len(dnsPacket.Payload)
-
-				dm.DnsTap.Identity = c.identity
-				dm.DnsTap.TimeSec = dnsPacket.Timestamp.Second()
-				dm.DnsTap.TimeNsec = int(dnsPacket.Timestamp.UnixNano())
-
-				// count it
-				nbPackets++
-
-				// send DNS message to DNS processor
-				c.dnsProcessor.GetChannel() <- dm
-			case <-time.After(10 * time.Second):
-				elapsed := time.Since(lastReceivedTime)
-				if elapsed >= 10*time.Second {
-					close(fragIp4Chan)
-					close(fragIp6Chan)
-					close(udpChan)
-					close(tcpChan)
-					close(dnsChan)
-				}
+		for dnsPacket := range dnsChan {
+			// prepare dns message
+			dm := dnsutils.DnsMessage{}
+			dm.Init()
+
+			dm.NetworkInfo.Family = dnsPacket.IpLayer.EndpointType().String()
+			dm.NetworkInfo.QueryIp = dnsPacket.IpLayer.Src().String()
+			dm.NetworkInfo.ResponseIp = dnsPacket.IpLayer.Dst().String()
+			dm.NetworkInfo.QueryPort = dnsPacket.TransportLayer.Src().String()
+			dm.NetworkInfo.ResponsePort = dnsPacket.TransportLayer.Dst().String()
+			dm.NetworkInfo.Protocol = dnsPacket.TransportLayer.EndpointType().String()
+			dm.NetworkInfo.IpDefragmented = dnsPacket.IpDefragmented
+			dm.NetworkInfo.TcpReassembled = dnsPacket.TcpReassembled
+
+			dm.DNS.Payload = dnsPacket.Payload
+			dm.DNS.Length = len(dnsPacket.Payload)
+
+			// original timestamp of the packet or time of the replay
+			ts := dnsPacket.Timestamp
+			if !c.config.Collectors.FileIngestor.PcapKeepTimestamps {
+				ts = time.Now()
 			}
+			dm.DnsTap.Identity = c.identity
+			dm.DnsTap.TimeSec = int(ts.Unix())
+			dm.DnsTap.TimeNsec = ts.Nanosecond()
+
+			// count it
+			nbPackets++
+
+			// send DNS message to DNS processor
+			c.dnsProcessor.GetChannel() <- dm
 		}
-	end:
 		c.LogInfo("pcap file [%s]: %d DNS packet(s) detected", fileName, nbPackets)
+		dnsDone <- true
 	}()
 
+	// replay at the original pace multiplied by the rate
+	replayRate := c.config.Collectors.FileIngestor.PcapReplayRate
+	var replayFirst, replayStart time.Time
+
 	nbPackets := 0
 	for {
 		packet, err := packetSource.NextPacket()
@@ -242,6 +317,18 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 
 		nbPackets++
 
+		// wait the time of the packet
+		if replayRate > 0 {
+			ts := packet.Metadata().Timestamp
+			if replayFirst.IsZero() {
+				replayFirst, replayStart = ts, time.Now()
+			}
+			offset := time.Duration(float64(ts.Sub(replayFirst)) / replayRate)
+			if wait := time.Until(replayStart.Add(offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
 		// some security checks
 		if packet.NetworkLayer() == nil {
 			continue
@@ -278,8 +365,16 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 
 	}
 
-	// remove it ?
-	//assembler.FlushAll()
+	// flush the pipeline, then wait the last dns messages
+	close(fragIp4Chan)
+	close(fragIp6Chan)
+	wgDefrag.Wait()
+	close(udpChan)
+	close(tcpChan)
+	wgProcess.Wait()
+	close(dnsChan)
+	<-dnsDone
+
 	c.LogInfo("pcap file [%s] processing terminated, %d packet(s) read", fileName, nbPackets)
 
 	// remove it ?
@@ -292,32 +387,45 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 	c.RemoveEvent(filePath)
 }
 
-func (c *FileIngestor) ProcessDnstap(filePath string) error {
+func (c *FileIngestor) ProcessDnstap(filePath string) {
 	// open the file
 	f, err := os.Open(filePath)
 	if err != nil {
-		return err
+		c.LogError("unable to read file: %s", err)
+		return
 	}
 	defer f.Close()
 
-	dnstapDecoder, err := framestream.NewDecoder(f, &framestream.DecoderOptions{
+	// compressed file ?
+	r, err := NewDnstapSource(f)
+	if err != nil {
+		c.LogError("unable to read dnstap file: %s", err)
+		return
+	}
+	defer r.Close()
+
+	dnstapDecoder, err := framestream.NewDecoder(r, &framestream.DecoderOptions{
 		ContentType:   []byte("protobuf:dnstap.Dnstap"),
 		Bidirectional: false,
 	})
-
 	if err != nil {
-		return fmt.Errorf("failed to create framestream Decoder: %w", err)
+		c.LogError("failed to create framestream decoder: %s", err)
+		return
 	}
 
 	fileName := filepath.Base(filePath)
 	c.LogInfo("processing dnstap file [%s]", fileName)
 	for {
 		buf, err := dnstapDecoder.Decode()
-		if errors.Is(err, io.EOF) {
+		if err != nil {
+			// truncated or corrupted file, stop here
+			if !errors.Is(err, io.EOF) {
+				c.LogError("unable to decode dnstap file [%s]: %s", fileName, err)
+			}
 			break
 		}
 
-		newbuf := make([]byte, len(buf))
+		newbuf := dnsutils.GetBuffer(len(buf))
 		copy(newbuf, buf)
 
 		c.dnstapProcessor.GetChannel() <- newbuf
@@ -332,8 +440,6 @@ func (c *FileIngestor) ProcessDnstap(filePath string) error {
 
 	// remove event timer for this file
 	c.RemoveEvent(filePath)
-
-	return nil
 }
 
 func (c *FileIngestor) RegisterEvent(filePath string) {
@@ -362,16 +468,7 @@ func (c *FileIngestor) RemoveEvent(filePath string) {
 	c.mu.Unlock()
 }
 
-func (c *FileIngestor) Run() {
-	c.LogInfo("starting collector...")
-
-	c.dnsProcessor = NewDnsProcessor(c.config, c.logger, c.name)
-	go c.dnsProcessor.Run(c.Loggers())
-
-	// start dnstap subprocessor
-	c.dnstapProcessor = NewDnstapProcessor(c.config, c.logger, c.name)
-	go c.dnstapProcessor.Run(c.Loggers())
-
+func (c *FileIngestor) WatchDir() {
 	// read current folder content
 	entries, err := os.ReadDir(c.config.Collectors.FileIngestor.WatchDir)
 	if err != nil {
@@ -386,19 +483,7 @@ func (c *FileIngestor) Run() {
 
 		// prepare filepath
 		fn := filepath.Join(c.config.Collectors.FileIngestor.WatchDir, entry.Name())
-
-		switch c.config.Collectors.FileIngestor.WatchMode {
-		case dnsutils.MODE_PCAP:
-			// process file with pcap extension
-			if filepath.Ext(fn) == ".pcap" || filepath.Ext(fn) == ".pcap.gz" {
-				go c.ProcessPcap(fn)
-			}
-		case dnsutils.MODE_DNSTAP:
-			// processs dnstap
-			if filepath.Ext(fn) == ".fstrm" {
-				go c.ProcessDnstap(fn)
-			}
-		}
+		c.ProcessFile(fn)
 	}
 
 	// then watch for new one
@@ -436,11 +521,37 @@ func (c *FileIngestor) Run() {
 			}
 		}
 	}()
+}
+
+func (c *FileIngestor) Run() {
+	c.LogInfo("starting collector...")
+
+	c.dnsProcessor = NewDnsProcessor(c.processors.Config(), c.logger, c.name)
+	c.dnsProcessor.SetBatchChannels(c.BatchLoggers())
+	go c.dnsProcessor.Run(c.Loggers())
+	c.processors.Add(&c.dnsProcessor)
+
+	// start dnstap subprocessor
+	c.dnstapProcessor = NewDnstapProcessor(c.processors.Config(), c.logger, c.name)
+	c.dnstapProcessor.SetBatchChannels(c.BatchLoggers())
+	go c.dnstapProcessor.Run(c.Loggers())
+	c.processors.Add(&c.dnstapProcessor)
+
+	// process the files provided
+	for _, fn := range c.config.Collectors.FileIngestor.Files {
+		c.ProcessFile(fn)
+	}
+
+	if len(c.config.Collectors.FileIngestor.WatchDir) > 0 {
+		c.WatchDir()
+	}
 
 	<-c.exit
 
 	// stop dns processor
+	c.processors.Remove(&c.dnsProcessor)
 	c.dnsProcessor.Stop()
+	c.processors.Remove(&c.dnstapProcessor)
 	c.dnstapProcessor.Stop()
 
 	c.LogInfo("run terminated")
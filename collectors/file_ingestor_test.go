@@ -1,11 +1,17 @@
 package collectors
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/loggers"
 	"github.com/dmachard/go-logger"
+	framestream "github.com/farsightsec/golang-framestream"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
+	"github.com/miekg/dns"
 )
 
 func Test_FileIngestor_Pcap(t *testing.T) {
@@ -30,3 +36,94 @@ func Test_FileIngestor_Pcap(t *testing.T) {
 		}
 	}
 }
+
+func Test_FileIngestor_PcapngFiles(t *testing.T) {
+	// convert a pcap to pcapng
+	in, err := os.Open("./../testsdata/pcap/dnsdump_udp.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	reader, err := pcapgo.NewReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pcapngFile := filepath.Join(t.TempDir(), "dnsdump_udp.pcapng")
+	out, err := os.Create(pcapngFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := pcapgo.NewNgWriter(out, reader.LinkType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ci, err := reader.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstTs := ci.Timestamp
+	for err == nil {
+		writer.WritePacket(ci, data)
+		data, ci, err = reader.ReadPacketData()
+	}
+	writer.Flush()
+	out.Close()
+
+	// ingest the file provided
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.FileIngestor.Files = []string{pcapngFile}
+
+	c := NewFileIngestor([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+
+	// the original timestamp is kept
+	msg := <-g.Channel()
+	if msg.DnsTap.TimeSec != int(firstTs.Unix()) || msg.DnsTap.TimeNsec != firstTs.Nanosecond() {
+		t.Errorf("invalid timestamp, want %v, got %d.%d", firstTs, msg.DnsTap.TimeSec, msg.DnsTap.TimeNsec)
+	}
+}
+
+func Test_FileIngestor_DnstapCompressed(t *testing.T) {
+	// write a dnstap file compressed with zstd, as the file logger does
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	dm.NetworkInfo.Protocol = dnsutils.PROTO_UDP
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("dns.collector.", dns.TypeA)
+	dm.DNS.Payload, _ = dnsmsg.Pack()
+	frame, err := dm.ToDnstap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnstapFile := filepath.Join(t.TempDir(), "capture.fstrm.zst")
+	out, err := os.Create(dnstapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw, _ := zstd.NewWriter(out)
+	enc, err := framestream.NewEncoder(zw, &framestream.EncoderOptions{ContentType: []byte("protobuf:dnstap.Dnstap")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write(frame)
+	enc.Close()
+	zw.Close()
+	out.Close()
+
+	// ingest the file provided
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.FileIngestor.WatchMode = dnsutils.MODE_DNSTAP
+	config.Collectors.FileIngestor.Files = []string{dnstapFile}
+
+	c := NewFileIngestor([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+
+	msg := <-g.Channel()
+	if msg.DNS.Qname != "dns.collector" || msg.NetworkInfo.QueryIp != "1.2.3.4" {
+		t.Errorf("invalid dns message: %s %s", msg.DNS.Qname, msg.NetworkInfo.QueryIp)
+	}
+}
@@ -3,6 +3,7 @@ package collectors
 import (
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"regexp"
 	"strconv"
@@ -16,22 +17,24 @@ import (
 )
 
 type Tail struct {
-	done    chan bool
-	tailf   *tail.Tail
-	loggers []dnsutils.Worker
-	config  *dnsutils.Config
-	logger  *logger.Logger
-	name    string
+	done       chan bool
+	configChan chan *dnsutils.Config
+	tailf      *tail.Tail
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
 }
 
 func NewTail(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *Tail {
 	logger.Info("[%s] tail collector - enabled", name)
 	s := &Tail{
-		done:    make(chan bool),
-		config:  config,
-		loggers: loggers,
-		logger:  logger,
-		name:    name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
 	}
 	s.ReadConfig()
 	return s
@@ -55,6 +58,11 @@ func (c *Tail) ReadConfig() {
 	//tbc
 }
 
+func (c *Tail) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	dnsutils.SendConfig(c.configChan, config)
+}
+
 func (c *Tail) LogInfo(msg string, v ...interface{}) {
 	c.logger.Info("["+c.name+"] tail collector - "+msg, v...)
 }
@@ -114,160 +122,180 @@ func (c *Tail) Run() {
 		dm.DnsTap.Identity = "undefined"
 	}
 
-	for line := range c.tailf.Lines {
-		var matches []string
-		var re *regexp.Regexp
-
-		if len(c.config.Collectors.Tail.PatternQuery) > 0 {
-			re = regexp.MustCompile(c.config.Collectors.Tail.PatternQuery)
-			matches = re.FindStringSubmatch(line.Text)
-			dm.DNS.Type = dnsutils.DnsQuery
-			dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_QUERY
-		}
-
-		if len(c.config.Collectors.Tail.PatternReply) > 0 && len(matches) == 0 {
-			re = regexp.MustCompile(c.config.Collectors.Tail.PatternReply)
-			matches = re.FindStringSubmatch(line.Text)
-			dm.DNS.Type = dnsutils.DnsReply
-			dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_REPLY
-		}
-
-		if len(matches) == 0 {
-			continue
-		}
+LOOP:
+	for {
+		select {
+		case cfg := <-c.configChan:
+			// reload the transformers, the held messages are sent before and
+			// the messages in the channel are kept
+			subprocessors.Flush()
+			subprocessors.ReloadConfig(&cfg.IngoingTransformers)
+
+		case line, opened := <-c.tailf.Lines:
+			if !opened {
+				break LOOP
+			}
+			var matches []string
+			var re *regexp.Regexp
+
+			if len(c.config.Collectors.Tail.PatternQuery) > 0 {
+				re = regexp.MustCompile(c.config.Collectors.Tail.PatternQuery)
+				matches = re.FindStringSubmatch(line.Text)
+				dm.DNS.Type = dnsutils.DnsQuery
+				dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_QUERY
+			}
 
-		qrIndex := re.SubexpIndex("qr")
-		if qrIndex != -1 {
-			dm.DnsTap.Operation = matches[qrIndex]
-		}
+			if len(c.config.Collectors.Tail.PatternReply) > 0 && len(matches) == 0 {
+				re = regexp.MustCompile(c.config.Collectors.Tail.PatternReply)
+				matches = re.FindStringSubmatch(line.Text)
+				dm.DNS.Type = dnsutils.DnsReply
+				dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_REPLY
+			}
 
-		var t time.Time
-		timestampIndex := re.SubexpIndex("timestamp")
-		if timestampIndex != -1 {
-			t, err = time.Parse(c.config.Collectors.Tail.TimeLayout, matches[timestampIndex])
-			if err != nil {
+			if len(matches) == 0 {
 				continue
 			}
-		} else {
-			t = time.Now()
-		}
-		dm.DnsTap.TimeSec = int(t.Unix())
-		dm.DnsTap.TimeNsec = int(t.UnixNano() - t.Unix()*1e9)
 
-		identityIndex := re.SubexpIndex("identity")
-		if identityIndex != -1 {
-			dm.DnsTap.Identity = matches[identityIndex]
-		}
+			qrIndex := re.SubexpIndex("qr")
+			if qrIndex != -1 {
+				dm.DnsTap.Operation = matches[qrIndex]
+			}
 
-		rcodeIndex := re.SubexpIndex("rcode")
-		if rcodeIndex != -1 {
-			dm.DNS.Rcode = matches[rcodeIndex]
-		}
+			var t time.Time
+			timestampIndex := re.SubexpIndex("timestamp")
+			if timestampIndex != -1 {
+				t, err = time.Parse(c.config.Collectors.Tail.TimeLayout, matches[timestampIndex])
+				if err != nil {
+					continue
+				}
+			} else {
+				t = time.Now()
+			}
+			dm.DnsTap.TimeSec = int(t.Unix())
+			dm.DnsTap.TimeNsec = int(t.UnixNano() - t.Unix()*1e9)
 
-		queryipIndex := re.SubexpIndex("queryip")
-		if queryipIndex != -1 {
-			dm.NetworkInfo.QueryIp = matches[queryipIndex]
-		}
+			identityIndex := re.SubexpIndex("identity")
+			if identityIndex != -1 {
+				dm.DnsTap.Identity = matches[identityIndex]
+			}
 
-		queryportIndex := re.SubexpIndex("queryport")
-		if queryportIndex != -1 {
-			dm.NetworkInfo.QueryPort = matches[queryportIndex]
-		} else {
-			dm.NetworkInfo.ResponsePort = "0"
-		}
+			rcodeIndex := re.SubexpIndex("rcode")
+			if rcodeIndex != -1 {
+				dm.DNS.Rcode = matches[rcodeIndex]
+			}
 
-		responseipIndex := re.SubexpIndex("responseip")
-		if responseipIndex != -1 {
-			dm.NetworkInfo.ResponseIp = matches[responseipIndex]
-		}
+			queryipIndex := re.SubexpIndex("queryip")
+			if queryipIndex != -1 {
+				dm.NetworkInfo.QueryIp = matches[queryipIndex]
+			}
 
-		responseportIndex := re.SubexpIndex("responseport")
-		if responseportIndex != -1 {
-			dm.NetworkInfo.ResponsePort = matches[responseportIndex]
-		} else {
-			dm.NetworkInfo.ResponsePort = "0"
-		}
+			queryportIndex := re.SubexpIndex("queryport")
+			if queryportIndex != -1 {
+				dm.NetworkInfo.QueryPort = matches[queryportIndex]
+			} else {
+				dm.NetworkInfo.ResponsePort = "0"
+			}
 
-		familyIndex := re.SubexpIndex("family")
-		if familyIndex != -1 {
-			dm.NetworkInfo.Family = matches[familyIndex]
-		} else {
-			dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
-		}
+			responseipIndex := re.SubexpIndex("responseip")
+			if responseipIndex != -1 {
+				dm.NetworkInfo.ResponseIp = matches[responseipIndex]
+			}
 
-		protocolIndex := re.SubexpIndex("protocol")
-		if protocolIndex != -1 {
-			dm.NetworkInfo.Protocol = matches[protocolIndex]
-		} else {
-			dm.NetworkInfo.Protocol = dnsutils.PROTO_UDP
-		}
+			responseportIndex := re.SubexpIndex("responseport")
+			if responseportIndex != -1 {
+				dm.NetworkInfo.ResponsePort = matches[responseportIndex]
+			} else {
+				dm.NetworkInfo.ResponsePort = "0"
+			}
 
-		lengthIndex := re.SubexpIndex("length")
-		if lengthIndex != -1 {
-			length, err := strconv.Atoi(matches[lengthIndex])
-			if err == nil {
-				dm.DNS.Length = length
+			// the family is deduced from the query ip if not in the pattern
+			familyIndex := re.SubexpIndex("family")
+			if familyIndex != -1 {
+				dm.NetworkInfo.Family = matches[familyIndex]
+			} else if ip := net.ParseIP(dm.NetworkInfo.QueryIp); ip != nil && ip.To4() == nil {
+				dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+			} else {
+				dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
 			}
-		}
 
-		domainIndex := re.SubexpIndex("domain")
-		if domainIndex != -1 {
-			dm.DNS.Qname = matches[domainIndex]
-		}
+			protocolIndex := re.SubexpIndex("protocol")
+			if protocolIndex != -1 {
+				dm.NetworkInfo.Protocol = matches[protocolIndex]
+			} else {
+				dm.NetworkInfo.Protocol = dnsutils.PROTO_UDP
+			}
 
-		qtypeIndex := re.SubexpIndex("qtype")
-		if qtypeIndex != -1 {
-			dm.DNS.Qtype = matches[qtypeIndex]
-		}
+			lengthIndex := re.SubexpIndex("length")
+			if lengthIndex != -1 {
+				length, err := strconv.Atoi(matches[lengthIndex])
+				if err == nil {
+					dm.DNS.Length = length
+				}
+			}
 
-		latencyIndex := re.SubexpIndex("latency")
-		if latencyIndex != -1 {
-			dm.DnsTap.LatencySec = matches[latencyIndex]
-		}
+			domainIndex := re.SubexpIndex("domain")
+			if domainIndex != -1 {
+				dm.DNS.Qname = matches[domainIndex]
+			}
 
-		// compute timestamp
-		dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
-		ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
-		dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
-
-		// fake dns packet
-		dnspkt := new(dns.Msg)
-		var dnstype uint16
-		dnstype = dns.TypeA
-		if dm.DNS.Qtype == "AAAA" {
-			dnstype = dns.TypeAAAA
-		}
-		dnspkt.SetQuestion(dm.DNS.Qname, dnstype)
+			qtypeIndex := re.SubexpIndex("qtype")
+			if qtypeIndex != -1 {
+				dm.DNS.Qtype = matches[qtypeIndex]
+			}
 
-		if dm.DNS.Type == dnsutils.DnsReply {
-			rr, _ := dns.NewRR(fmt.Sprintf("%s %s 0.0.0.0", dm.DNS.Qname, dm.DNS.Qtype))
-			if err == nil {
-				dnspkt.Answer = append(dnspkt.Answer, rr)
+			latencyIndex := re.SubexpIndex("latency")
+			if latencyIndex != -1 {
+				dm.DnsTap.LatencySec = matches[latencyIndex]
 			}
-			var rcode int
-			rcode = 0
-			if dm.DNS.Rcode == "NXDOMAIN" {
-				rcode = 3
+
+			// compute timestamp
+			dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
+			ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+			dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
+
+			// fake dns packet
+			dnspkt := new(dns.Msg)
+			var dnstype uint16
+			dnstype = dns.TypeA
+			if dm.DNS.Qtype == "AAAA" {
+				dnstype = dns.TypeAAAA
+			}
+			dnspkt.SetQuestion(dm.DNS.Qname, dnstype)
+
+			if dm.DNS.Type == dnsutils.DnsReply {
+				rr, _ := dns.NewRR(fmt.Sprintf("%s %s 0.0.0.0", dm.DNS.Qname, dm.DNS.Qtype))
+				if err == nil {
+					dnspkt.Answer = append(dnspkt.Answer, rr)
+				}
+				var rcode int
+				rcode = 0
+				if dm.DNS.Rcode == "NXDOMAIN" {
+					rcode = 3
+				}
+				dnspkt.Rcode = rcode
 			}
-			dnspkt.Rcode = rcode
-		}
 
-		dm.DNS.Payload, _ = dnspkt.Pack()
-		dm.DNS.Length = len(dm.DNS.Payload)
+			dm.DNS.Payload, _ = dnspkt.Pack()
+			dm.DNS.Length = len(dm.DNS.Payload)
 
-		// apply all enabled transformers
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
-		}
+			// add the label of the collector
+			dm.SetListener(c.name, c.config.Collectors.Label)
 
-		// send to loggers
-		chanLoggers := c.Loggers()
-		for i := range chanLoggers {
-			chanLoggers[i] <- dm
+			// apply all enabled transformers
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// send to loggers
+			chanLoggers := c.Loggers()
+			for i := range chanLoggers {
+				chanLoggers[i] <- dm
+			}
 		}
 	}
 
-	// cleanup transformers
+	// send the messages held by the transformers and cleanup them
+	subprocessors.Flush()
 	subprocessors.Reset()
 
 	c.LogInfo("run terminated")
@@ -0,0 +1,198 @@
+package collectors
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// Monitoring logs the internal events of the workers (connections, decoding errors, dropped
+// messages, configuration reloads) as records with the event operation, the decoding errors
+// and the dropped messages are counted and sent at each interval
+type Monitoring struct {
+	done       chan bool
+	exit       chan bool
+	configChan chan *dnsutils.Config
+	events     chan dnsutils.DnsEvent
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	identity   string
+	errors     map[string]*dnsutils.DnsEvent
+	dropped    map[string]uint64
+}
+
+func NewMonitoring(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *Monitoring {
+	logger.Info("[%s] monitoring collector - enabled", name)
+	s := &Monitoring{
+		done:       make(chan bool),
+		exit:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		errors:     make(map[string]*dnsutils.DnsEvent),
+		dropped:    dnsutils.GetOutputsDropped(),
+	}
+	s.ReadConfig()
+
+	// subscribe now to get the events of the other workers at startup
+	s.events = dnsutils.SubscribeEvents(config.Collectors.Monitoring.BufferSize)
+	return s
+}
+
+func (c *Monitoring) GetName() string { return c.name }
+
+func (c *Monitoring) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *Monitoring) Loggers() []chan dnsutils.DnsMessage {
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *Monitoring) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *Monitoring) ReadConfig() {
+	c.identity = c.config.GetServerIdentity()
+}
+
+func (c *Monitoring) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	dnsutils.SendConfig(c.configChan, config)
+}
+
+func (c *Monitoring) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] monitoring collector - "+msg, v...)
+}
+
+func (c *Monitoring) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] monitoring collector - "+msg, v...)
+}
+
+func (c *Monitoring) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+// newMessage returns the record of the event
+func (c *Monitoring) newMessage(event dnsutils.DnsEvent) dnsutils.DnsMessage {
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+	dm.DnsTap.Identity = c.identity
+	dm.DnsTap.Operation = dnsutils.OPERATION_EVENT
+
+	now := time.Now()
+	dm.DnsTap.TimeSec = int(now.Unix())
+	dm.DnsTap.TimeNsec = now.Nanosecond()
+	dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
+	dm.DnsTap.TimestampRFC3339 = now.UTC().Format(time.RFC3339Nano)
+
+	dm.SetListener(c.name, c.config.Collectors.Label)
+	dm.Event = &event
+	return dm
+}
+
+// Record handles an event, the decoding errors are counted by worker until the next interval
+func (c *Monitoring) Record(event dnsutils.DnsEvent) []dnsutils.DnsMessage {
+	if event.Type != dnsutils.EVENT_DECODE_ERROR {
+		return []dnsutils.DnsMessage{c.newMessage(event)}
+	}
+
+	counted, ok := c.errors[event.Worker]
+	if !ok {
+		counted = &dnsutils.DnsEvent{Type: event.Type, Worker: event.Worker}
+		c.errors[event.Worker] = counted
+	}
+	counted.Count += event.Count
+	counted.Peer, counted.Message = event.Peer, event.Message
+	return nil
+}
+
+// Flush returns the events of the interval: the decoding errors of each worker with the
+// last error and the number of messages dropped by each output since the previous interval
+func (c *Monitoring) Flush() []dnsutils.DnsMessage {
+	messages := []dnsutils.DnsMessage{}
+	for _, event := range c.errors {
+		messages = append(messages, c.newMessage(*event))
+	}
+	c.errors = make(map[string]*dnsutils.DnsEvent)
+
+	for name, dropped := range dnsutils.GetOutputsDropped() {
+		if count := dropped - c.dropped[name]; count > 0 {
+			messages = append(messages, c.newMessage(dnsutils.DnsEvent{
+				Type:    dnsutils.EVENT_DROPPED,
+				Worker:  name,
+				Count:   int(count),
+				Message: fmt.Sprintf("%d messages dropped in %ds", count, c.config.Collectors.Monitoring.Interval),
+			}))
+		}
+		c.dropped[name] = dropped
+	}
+	return messages
+}
+
+func (c *Monitoring) Stop() {
+	c.LogInfo("stopping...")
+
+	// exit to close properly
+	c.exit <- true
+
+	// read done channel and block until run is terminated
+	<-c.done
+	close(c.done)
+}
+
+func (c *Monitoring) Run() {
+	c.LogInfo("starting collector...")
+
+	// the records are sent directly to the loggers, without ingoing transformers
+	dispatcher := dnsutils.NewDispatcher(c.Loggers(), c.BatchLoggers(), c.config.Global.Batch.Size,
+		time.Duration(c.config.Global.Batch.FlushInterval)*time.Millisecond)
+
+	ticker := time.NewTicker(time.Duration(c.config.Collectors.Monitoring.Interval) * time.Second)
+
+LOOP:
+	for {
+		select {
+		case <-c.exit:
+			break LOOP
+
+		case cfg := <-c.configChan:
+			c.config.Collectors.Monitoring.Interval = cfg.Collectors.Monitoring.Interval
+			ticker.Reset(time.Duration(c.config.Collectors.Monitoring.Interval) * time.Second)
+
+		case event := <-c.events:
+			for _, dm := range c.Record(event) {
+				dispatcher.Dispatch(dm)
+			}
+
+		case <-ticker.C:
+			for _, dm := range c.Flush() {
+				dispatcher.Dispatch(dm)
+			}
+		}
+	}
+	ticker.Stop()
+
+	// the pending events and counters are sent before to stop
+	dnsutils.UnsubscribeEvents(c.events)
+	close(c.events)
+	for event := range c.events {
+		for _, dm := range c.Record(event) {
+			dispatcher.Dispatch(dm)
+		}
+	}
+	for _, dm := range c.Flush() {
+		dispatcher.Dispatch(dm)
+	}
+	dispatcher.Stop()
+
+	c.LogInfo("run terminated")
+	c.done <- true
+}
@@ -0,0 +1,43 @@
+package collectors
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_MonitoringCollector(t *testing.T) {
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Monitoring.Interval = 60
+
+	c := NewMonitoring([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+
+	// the connections are logged immediately
+	dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_CONNECT, Worker: "tap", Peer: "127.0.0.1:4000"})
+	dm := <-g.Channel()
+	if dm.DnsTap.Operation != dnsutils.OPERATION_EVENT || dm.DnsTap.TimeSec == 0 {
+		t.Errorf("invalid event record: %s %d", dm.DnsTap.Operation, dm.DnsTap.TimeSec)
+	}
+	if dm.Event == nil || dm.Event.Type != dnsutils.EVENT_CONNECT || dm.Event.Worker != "tap" || dm.Event.Peer != "127.0.0.1:4000" {
+		t.Errorf("invalid event: %+v", dm.Event)
+	}
+
+	// the decoding errors are counted until the interval or the stop
+	for i := 0; i < 3; i++ {
+		dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_DECODE_ERROR, Worker: "tap", Count: 1, Message: "malformed"})
+	}
+	c.Stop()
+
+	if len(g.Channel()) != 1 {
+		t.Fatalf("one event record expected, got %d", len(g.Channel()))
+	}
+	dm = <-g.Channel()
+	if dm.Event == nil || dm.Event.Type != dnsutils.EVENT_DECODE_ERROR || dm.Event.Count != 3 || dm.Event.Message != "malformed" {
+		t.Errorf("invalid decoding errors event: %+v", dm.Event)
+	}
+}
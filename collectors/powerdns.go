@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -13,23 +14,26 @@ import (
 )
 
 type ProtobufPowerDNS struct {
-	done    chan bool
-	listen  net.Listener
-	conns   []net.Conn
-	loggers []dnsutils.Worker
-	config  *dnsutils.Config
-	logger  *logger.Logger
-	name    string
+	done       chan bool
+	listen     net.Listener
+	conns      []net.Conn
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	processors *Processors
+	handlers   sync.WaitGroup
 }
 
 func NewProtobufPowerDNS(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *ProtobufPowerDNS {
 	logger.Info("[%s] pdns collector - enabled", name)
 	s := &ProtobufPowerDNS{
-		done:    make(chan bool),
-		config:  config,
-		loggers: loggers,
-		logger:  logger,
-		name:    name,
+		done:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		processors: NewProcessors(config),
 	}
 	s.ReadConfig()
 	return s
@@ -42,11 +46,11 @@ func (c *ProtobufPowerDNS) SetLoggers(loggers []dnsutils.Worker) {
 }
 
 func (c *ProtobufPowerDNS) Loggers() []chan dnsutils.DnsMessage {
-	channels := []chan dnsutils.DnsMessage{}
-	for _, p := range c.loggers {
-		channels = append(channels, p.Channel())
-	}
-	return channels
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *ProtobufPowerDNS) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
 }
 
 func (c *ProtobufPowerDNS) ReadConfig() {
@@ -55,6 +59,11 @@ func (c *ProtobufPowerDNS) ReadConfig() {
 	}
 }
 
+func (c *ProtobufPowerDNS) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
+}
+
 func (c *ProtobufPowerDNS) LogInfo(msg string, v ...interface{}) {
 	c.logger.Info("["+c.name+"] pdns collector - "+msg, v...)
 }
@@ -64,16 +73,21 @@ func (c *ProtobufPowerDNS) LogError(msg string, v ...interface{}) {
 }
 
 func (c *ProtobufPowerDNS) HandleConn(conn net.Conn) {
+	defer c.handlers.Done()
+
 	// close connection on function exit
 	defer conn.Close()
 
 	// get peer address
 	peer := conn.RemoteAddr().String()
 	c.LogInfo("%s - new connection\n", peer)
+	dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_CONNECT, Worker: c.name, Peer: peer})
 
 	// start protobuf subprocessor
-	pdns_subprocessor := NewPdnsProcessor(c.config, c.logger, c.name)
+	pdns_subprocessor := NewPdnsProcessor(c.processors.Config(), c.logger, c.name)
+	pdns_subprocessor.SetBatchChannels(c.BatchLoggers())
 	go pdns_subprocessor.Run(c.Loggers())
+	c.processors.Add(&pdns_subprocessor)
 
 	r := bufio.NewReader(conn)
 	pbs := powerdns_protobuf.NewProtobufStream(r, conn, 5*time.Second)
@@ -83,7 +97,12 @@ func (c *ProtobufPowerDNS) HandleConn(conn net.Conn) {
 		c.LogError("transport error: %s", err)
 	}
 
+	// stop the subprocessor
+	c.processors.Remove(&pdns_subprocessor)
+	pdns_subprocessor.Stop()
+
 	c.LogInfo("%s - connection closed\n", peer)
+	dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_DISCONNECT, Worker: c.name, Peer: peer})
 }
 
 func (c *ProtobufPowerDNS) Channel() chan dnsutils.DnsMessage {
@@ -93,15 +112,16 @@ func (c *ProtobufPowerDNS) Channel() chan dnsutils.DnsMessage {
 func (c *ProtobufPowerDNS) Stop() {
 	c.LogInfo("stopping...")
 
+	// stop accepting new connections
+	c.LogInfo("stop listening...")
+	c.listen.Close()
+
 	// closing properly current connections if exists
 	for _, conn := range c.conns {
 		peer := conn.RemoteAddr().String()
 		c.LogInfo("%s - closing connection...", peer)
 		conn.Close()
 	}
-	// Finally close the listener to unblock accept
-	c.LogInfo("stop listening...")
-	c.listen.Close()
 
 	// read done channel and block until run is terminated
 	<-c.done
@@ -113,7 +133,7 @@ func (c *ProtobufPowerDNS) Listen() error {
 
 	var err error
 	var listener net.Listener
-	addrlisten := c.config.Collectors.PowerDNS.ListenIP + ":" + strconv.Itoa(c.config.Collectors.PowerDNS.ListenPort)
+	addrlisten := net.JoinHostPort(c.config.Collectors.PowerDNS.ListenIP, strconv.Itoa(c.config.Collectors.PowerDNS.ListenPort))
 
 	// listening with tls enabled ?
 	if c.config.Collectors.PowerDNS.TlsSupport {
@@ -161,10 +181,14 @@ func (c *ProtobufPowerDNS) Run() {
 		}
 
 		c.conns = append(c.conns, conn)
+		c.handlers.Add(1)
 		go c.HandleConn(conn)
 
 	}
 
+	// wait the connections, the messages received are sent to the loggers
+	c.handlers.Wait()
+
 	c.LogInfo("run terminated")
 	c.done <- true
 }
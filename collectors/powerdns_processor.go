@@ -25,21 +25,24 @@ var (
 )
 
 type PdnsProcessor struct {
-	done     chan bool
-	recvFrom chan []byte
-	logger   *logger.Logger
-	config   *dnsutils.Config
-	name     string
+	done       chan bool
+	configChan chan *dnsutils.Config
+	recvFrom   chan []byte
+	logger     *logger.Logger
+	config     *dnsutils.Config
+	name       string
+	batchTo    []chan []dnsutils.DnsMessage
 }
 
 func NewPdnsProcessor(config *dnsutils.Config, logger *logger.Logger, name string) PdnsProcessor {
 	logger.Info("[%s] powerdns processor - initialization...", name)
 	d := PdnsProcessor{
-		done:     make(chan bool),
-		recvFrom: make(chan []byte, 512),
-		logger:   logger,
-		config:   config,
-		name:     name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		recvFrom:   make(chan []byte, 512),
+		logger:     logger,
+		config:     config,
+		name:       name,
 	}
 
 	d.ReadConfig()
@@ -51,6 +54,11 @@ func (c *PdnsProcessor) ReadConfig() {
 	c.logger.Info("[" + c.name + "] processor powerdns parser - config")
 }
 
+// ReloadConfig reloads the transformers of the processor
+func (d *PdnsProcessor) ReloadConfig(config *dnsutils.Config) {
+	dnsutils.SendConfig(d.configChan, config)
+}
+
 func (c *PdnsProcessor) LogInfo(msg string, v ...interface{}) {
 	c.logger.Info("["+c.name+"] processor powerdns parser - "+msg, v...)
 }
@@ -71,224 +79,256 @@ func (d *PdnsProcessor) Stop() {
 	close(d.done)
 }
 
+// SetBatchChannels sets the channels of the loggers receiving slices of messages
+func (d *PdnsProcessor) SetBatchChannels(batchTo []chan []dnsutils.DnsMessage) {
+	d.batchTo = batchTo
+}
+
 func (d *PdnsProcessor) Run(sendTo []chan dnsutils.DnsMessage) {
 
 	pbdm := &powerdns_protobuf.PBDNSMessage{}
 
+	// prepare the dispatcher to loggers
+	dispatcher := dnsutils.NewDispatcher(sendTo, d.batchTo, d.config.Global.Batch.Size,
+		time.Duration(d.config.Global.Batch.FlushInterval)*time.Millisecond)
+
 	// prepare enabled transformers
-	subprocessors := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, sendTo)
+	subprocessors := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, dispatcher.Channels())
 
 	// read incoming dns message
 	d.LogInfo("running... waiting incoming dns message")
-	for data := range d.recvFrom {
-		err := proto.Unmarshal(data, pbdm)
-		if err != nil {
-			d.LogError("pbdm decoding, %s", err)
-			continue
-		}
-
-		// init dns message
-		dm := dnsutils.DnsMessage{}
-		dm.Init()
-
-		// init dns message with additionnals parts
-		subprocessors.InitDnsMessageFormat(&dm)
-
-		// init powerdns with default values
-		dm.PowerDns = &dnsutils.PowerDns{
-			Tags:                  []string{},
-			OriginalRequestSubnet: "",
-			AppliedPolicy:         "",
-			Metadata:              map[string]string{},
-		}
-
-		dm.DnsTap.Identity = string(pbdm.GetServerIdentity())
-		dm.DnsTap.Operation = PROTOBUF_PDNS_TO_DNSTAP[pbdm.GetType().String()]
-
-		if ipVersion, valid := dnsutils.IP_VERSION[pbdm.GetSocketFamily().String()]; valid {
-			dm.NetworkInfo.Family = ipVersion
-		} else {
-			dm.NetworkInfo.Family = dnsutils.STR_UNKNOWN
-		}
-		dm.NetworkInfo.Protocol = pbdm.GetSocketProtocol().String()
+LOOP:
+	for {
+		select {
+		case cfg := <-d.configChan:
+			// reload the transformers, the held messages are sent before and
+			// the messages in the channel are kept
+			subprocessors.Flush()
+			subprocessors.ReloadConfig(&cfg.IngoingTransformers)
+
+		case data, opened := <-d.recvFrom:
+			if !opened {
+				break LOOP
+			}
+			err := proto.Unmarshal(data, pbdm)
+			if err != nil {
+				d.LogError("pbdm decoding, %s", err)
+				dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_DECODE_ERROR, Worker: d.name, Count: 1,
+					Message: "pbdm decoding: " + err.Error()})
+				continue
+			}
 
-		if pbdm.From != nil {
-			dm.NetworkInfo.QueryIp = net.IP(pbdm.From).String()
-		}
-		dm.NetworkInfo.QueryPort = strconv.FormatUint(uint64(pbdm.GetFromPort()), 10)
-		dm.NetworkInfo.ResponseIp = net.IP(pbdm.To).String()
-		dm.NetworkInfo.ResponsePort = strconv.FormatUint(uint64(pbdm.GetToPort()), 10)
-
-		dm.DNS.Id = int(pbdm.GetId())
-		dm.DNS.Length = int(pbdm.GetInBytes())
-		dm.DnsTap.TimeSec = int(pbdm.GetTimeSec())
-		dm.DnsTap.TimeNsec = int(pbdm.GetTimeUsec())
-
-		if int(pbdm.Type.Number())%2 == 1 {
-			dm.DNS.Type = dnsutils.DnsQuery
-		} else {
-			dm.DNS.Type = dnsutils.DnsReply
-
-			tsQuery := float64(pbdm.Response.GetQueryTimeSec()) + float64(pbdm.Response.GetQueryTimeUsec())/1e6
-			tsReply := float64(pbdm.GetTimeSec()) + float64(pbdm.GetTimeUsec())/1e6
-
-			// convert latency to human
-			dm.DnsTap.Latency = tsReply - tsQuery
-			dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
-			dm.DNS.Rcode = dnsutils.RcodeToString(int(pbdm.Response.GetRcode()))
-		}
+			// init dns message
+			dm := dnsutils.DnsMessage{}
+			dm.Init()
 
-		// compute timestamp
-		dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
-		ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
-		dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
+			// init dns message with additionnals parts
+			subprocessors.InitDnsMessageFormat(&dm)
 
-		dm.DNS.Qname = pbdm.Question.GetQName()
-		// remove ending dot ?
-		dm.DNS.Qname = strings.TrimSuffix(dm.DNS.Qname, ".")
+			// init powerdns with default values
+			dm.PowerDns = &dnsutils.PowerDns{
+				Tags:                  []string{},
+				OriginalRequestSubnet: "",
+				AppliedPolicy:         "",
+				Metadata:              map[string]string{},
+			}
 
-		// get query type
-		dm.DNS.Qtype = dnsutils.RdatatypeToString(int(pbdm.Question.GetQType()))
+			dm.DnsTap.Identity = string(pbdm.GetServerIdentity())
+			dm.DnsTap.Operation = PROTOBUF_PDNS_TO_DNSTAP[pbdm.GetType().String()]
 
-		// get specific powerdns params
-		pdns := dnsutils.PowerDns{}
+			if ipVersion, valid := dnsutils.IP_VERSION[pbdm.GetSocketFamily().String()]; valid {
+				dm.NetworkInfo.Family = ipVersion
+			} else {
+				dm.NetworkInfo.Family = dnsutils.STR_UNKNOWN
+			}
+			dm.NetworkInfo.Protocol = pbdm.GetSocketProtocol().String()
 
-		// get PowerDNS OriginalRequestSubnet
-		ip := pbdm.GetOriginalRequestorSubnet()
-		if len(ip) == 4 {
-			addr := make(net.IP, net.IPv4len)
-			copy(addr, ip)
-			pdns.OriginalRequestSubnet = addr.String()
-		}
-		if len(ip) == 16 {
-			addr := make(net.IP, net.IPv6len)
-			copy(addr, ip)
-			pdns.OriginalRequestSubnet = addr.String()
-		}
+			if pbdm.From != nil {
+				dm.NetworkInfo.QueryIp = net.IP(pbdm.From).String()
+			}
+			dm.NetworkInfo.QueryPort = strconv.FormatUint(uint64(pbdm.GetFromPort()), 10)
+			dm.NetworkInfo.ResponseIp = net.IP(pbdm.To).String()
+			dm.NetworkInfo.ResponsePort = strconv.FormatUint(uint64(pbdm.GetToPort()), 10)
+
+			dm.DNS.Id = int(pbdm.GetId())
+			dm.DNS.Length = int(pbdm.GetInBytes())
+			dm.DnsTap.TimeSec = int(pbdm.GetTimeSec())
+			dm.DnsTap.TimeNsec = int(pbdm.GetTimeUsec()) * 1e3
+
+			if int(pbdm.Type.Number())%2 == 1 {
+				dm.DNS.Type = dnsutils.DnsQuery
+			} else {
+				dm.DNS.Type = dnsutils.DnsReply
+
+				tsQuery := float64(pbdm.Response.GetQueryTimeSec()) + float64(pbdm.Response.GetQueryTimeUsec())/1e6
+				tsReply := float64(pbdm.GetTimeSec()) + float64(pbdm.GetTimeUsec())/1e6
+
+				// convert latency to human
+				dm.DnsTap.Latency = tsReply - tsQuery
+				dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+				dm.DNS.Rcode = dnsutils.RcodeToString(int(pbdm.Response.GetRcode()))
+			}
 
-		// get PowerDNS tags
-		tags := pbdm.GetResponse().GetTags()
-		if tags == nil {
-			tags = []string{}
-		}
-		pdns.Tags = tags
+			// compute timestamp
+			dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
+			ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+			dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
 
-		// get PowerDNS policy applied
-		pdns.AppliedPolicy = pbdm.GetResponse().GetAppliedPolicy()
+			dm.DNS.Qname = pbdm.Question.GetQName()
+			// remove ending dot ?
+			dm.DNS.Qname = strings.TrimSuffix(dm.DNS.Qname, ".")
 
-		// get PowerDNS metadata
-		metas := make(map[string]string)
-		for _, e := range pbdm.GetMeta() {
-			metas[e.GetKey()] = strings.Join(e.Value.StringVal, " ")
-		}
-		pdns.Metadata = metas
+			// get query type
+			dm.DNS.Qtype = dnsutils.RdatatypeToString(int(pbdm.Question.GetQType()))
 
-		// finally set pdns to dns message
-		dm.PowerDns = &pdns
+			// get specific powerdns params
+			pdns := dnsutils.PowerDns{}
 
-		// decode answers
-		answers := []dnsutils.DnsAnswer{}
-		RRs := pbdm.GetResponse().GetRrs()
-		for j := range RRs {
-			rdata := string(RRs[j].GetRdata())
-			if RRs[j].GetType() == 1 {
+			// get PowerDNS OriginalRequestSubnet
+			ip := pbdm.GetOriginalRequestorSubnet()
+			if len(ip) == 4 {
 				addr := make(net.IP, net.IPv4len)
-				copy(addr, rdata[:net.IPv4len])
-				rdata = addr.String()
+				copy(addr, ip)
+				pdns.OriginalRequestSubnet = addr.String()
 			}
-			if RRs[j].GetType() == 28 {
+			if len(ip) == 16 {
 				addr := make(net.IP, net.IPv6len)
-				copy(addr, rdata[:net.IPv6len])
-				rdata = addr.String()
+				copy(addr, ip)
+				pdns.OriginalRequestSubnet = addr.String()
 			}
 
-			rr := dnsutils.DnsAnswer{
-				Name:      RRs[j].GetName(),
-				Rdatatype: dnsutils.RdatatypeToString(int(RRs[j].GetType())),
-				Class:     int(RRs[j].GetClass()),
-				Ttl:       int(RRs[j].GetTtl()),
-				Rdata:     rdata,
+			// get PowerDNS tags
+			tags := pbdm.GetResponse().GetTags()
+			if tags == nil {
+				tags = []string{}
 			}
-			answers = append(answers, rr)
-		}
-		dm.DNS.DnsRRs.Answers = answers
-
-		// prepare a fake DNS payload
-		dns.Id = func() uint16 { return uint16(pbdm.GetId()) }
-		fakePkt := new(dns.Msg)
-		fakePkt.SetQuestion(pbdm.Question.GetQName(), uint16(pbdm.Question.GetQType()))
-
-		// add reply
-		if int(pbdm.Type.Number())%2 != 1 {
-			// is a reply
-			fakePkt.Response = true
-
-			// set reply code
-			fakePkt.Rcode = int(pbdm.Response.GetRcode())
-
-			// add RR only A, AAAA and CNAME are exported by PowerDNS
-			rrs := pbdm.GetResponse().GetRrs()
-			for j := range rrs {
-				// prepare header
-				RR_Header := dns.RR_Header{
-					Name:     rrs[j].GetName(),
-					Rrtype:   uint16(rrs[j].GetType()),
-					Class:    uint16(rrs[j].GetClass()),
-					Ttl:      rrs[j].GetTtl(),
-					Rdlength: uint16(len(rrs[j].GetRdata())),
+			pdns.Tags = tags
+
+			// get PowerDNS policy applied
+			pdns.AppliedPolicy = pbdm.GetResponse().GetAppliedPolicy()
+
+			// get PowerDNS metadata
+			metas := make(map[string]string)
+			for _, e := range pbdm.GetMeta() {
+				metas[e.GetKey()] = strings.Join(e.Value.StringVal, " ")
+			}
+			pdns.Metadata = metas
+
+			// finally set pdns to dns message
+			dm.PowerDns = &pdns
+
+			// decode answers
+			answers := []dnsutils.DnsAnswer{}
+			RRs := pbdm.GetResponse().GetRrs()
+			for j := range RRs {
+				rdata := string(RRs[j].GetRdata())
+				if RRs[j].GetType() == 1 {
+					addr := make(net.IP, net.IPv4len)
+					copy(addr, rdata[:net.IPv4len])
+					rdata = addr.String()
 				}
-				// init rr if valid
-				newFn, ok := dns.TypeToRR[RR_Header.Rrtype]
-				if !ok {
-					continue
+				if RRs[j].GetType() == 28 {
+					addr := make(net.IP, net.IPv6len)
+					copy(addr, rdata[:net.IPv6len])
+					rdata = addr.String()
+				}
+
+				rr := dnsutils.DnsAnswer{
+					Name:      RRs[j].GetName(),
+					Rdatatype: dnsutils.RdatatypeToString(int(RRs[j].GetType())),
+					Class:     int(RRs[j].GetClass()),
+					Ttl:       int(RRs[j].GetTtl()),
+					Rdata:     rdata,
 				}
-				RR := newFn()
-				*RR.Header() = RR_Header
-
-				switch {
-				// A or AAAA
-				case RRs[j].GetType() == 1 || RRs[j].GetType() == 28:
-					RR, _, err := dns.UnpackRRWithHeader(RR_Header, rrs[j].GetRdata(), 0)
-					if err == nil {
-						fakePkt.Answer = append(fakePkt.Answer, RR)
+				answers = append(answers, rr)
+			}
+			dm.DNS.DnsRRs.Answers = answers
+
+			// prepare a fake DNS payload
+			dns.Id = func() uint16 { return uint16(pbdm.GetId()) }
+			fakePkt := new(dns.Msg)
+			fakePkt.SetQuestion(pbdm.Question.GetQName(), uint16(pbdm.Question.GetQType()))
+
+			// add reply
+			if int(pbdm.Type.Number())%2 != 1 {
+				// is a reply
+				fakePkt.Response = true
+
+				// set reply code
+				fakePkt.Rcode = int(pbdm.Response.GetRcode())
+
+				// add RR only A, AAAA and CNAME are exported by PowerDNS
+				rrs := pbdm.GetResponse().GetRrs()
+				for j := range rrs {
+					// prepare header
+					RR_Header := dns.RR_Header{
+						Name:     rrs[j].GetName(),
+						Rrtype:   uint16(rrs[j].GetType()),
+						Class:    uint16(rrs[j].GetClass()),
+						Ttl:      rrs[j].GetTtl(),
+						Rdlength: uint16(len(rrs[j].GetRdata())),
 					}
-				// CNAME
-				case RRs[j].GetType() == 5:
-					RR_Target := make([]byte, 255)
-					off, err := dns.PackDomainName(string(rrs[j].GetRdata()), RR_Target, 0, map[string]int{}, false)
-					if err != nil {
+					// init rr if valid
+					newFn, ok := dns.TypeToRR[RR_Header.Rrtype]
+					if !ok {
 						continue
 					}
-					RR_Target = RR_Target[:off]
-					RR_Header.Header().Rdlength = uint16(len(RR_Target))
-					RR, _, err := dns.UnpackRRWithHeader(RR_Header, RR_Target, 0)
-					if err == nil {
-						fakePkt.Answer = append(fakePkt.Answer, RR)
+					RR := newFn()
+					*RR.Header() = RR_Header
+
+					switch {
+					// A or AAAA
+					case RRs[j].GetType() == 1 || RRs[j].GetType() == 28:
+						RR, _, err := dns.UnpackRRWithHeader(RR_Header, rrs[j].GetRdata(), 0)
+						if err == nil {
+							fakePkt.Answer = append(fakePkt.Answer, RR)
+						}
+					// CNAME
+					case RRs[j].GetType() == 5:
+						RR_Target := make([]byte, 255)
+						off, err := dns.PackDomainName(string(rrs[j].GetRdata()), RR_Target, 0, map[string]int{}, false)
+						if err != nil {
+							continue
+						}
+						RR_Target = RR_Target[:off]
+						RR_Header.Header().Rdlength = uint16(len(RR_Target))
+						RR, _, err := dns.UnpackRRWithHeader(RR_Header, RR_Target, 0)
+						if err == nil {
+							fakePkt.Answer = append(fakePkt.Answer, RR)
+						}
 					}
 				}
 			}
-		}
-		wirePkt, err := fakePkt.Pack()
-		if err != nil {
-			d.LogError("dns encoding failed, %s", err)
-			continue
-		}
-		dm.DNS.Payload = wirePkt
+			// the payload is not encoded if not kept
+			if !d.config.Global.DropPayload {
+				wirePkt, err := fakePkt.Pack()
+				if err != nil {
+					d.LogError("dns encoding failed, %s", err)
+					continue
+				}
+				dm.DNS.Payload = wirePkt
+			}
 
-		// apply all enabled transformers
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
-		}
+			// add the label of the collector
+			dm.SetListener(d.name, d.config.Collectors.Label)
 
-		// dispatch dns message to all generators
-		for i := range sendTo {
-			sendTo[i] <- dm
+			// apply all enabled transformers
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// dispatch dns message to all generators
+			dispatcher.Dispatch(dm)
 		}
 	}
 
-	// cleanup transformers
+	// send the messages held by the transformers and cleanup them
+	subprocessors.Flush()
 	subprocessors.Reset()
 
+	// send pending batches
+	dispatcher.Stop()
+
 	// dnstap channel closed
 	d.done <- true
 }
@@ -38,3 +38,31 @@ func Test_PdnsProcessor(t *testing.T) {
 		t.Errorf("invalid identity in dns message: %s", msg.DnsTap.Identity)
 	}
 }
+
+func Test_PdnsProcessor_Timestamp(t *testing.T) {
+	// init the powerdns consumer
+	consumer := NewPdnsProcessor(dnsutils.GetFakeConfig(), logger.New(false), "test")
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+
+	// prepare powerdns message, timestamp in microseconds
+	dm := &powerdns_protobuf.PBDNSMessage{}
+	dm.ServerIdentity = []byte("powerdnspb")
+	dm.Type = powerdns_protobuf.PBDNSMessage_DNSQueryType.Enum()
+	dm.SocketProtocol = powerdns_protobuf.PBDNSMessage_UDP.Enum()
+	dm.SocketFamily = powerdns_protobuf.PBDNSMessage_INET.Enum()
+	dm.TimeSec = proto.Uint32(1682035200)
+	dm.TimeUsec = proto.Uint32(500000)
+
+	data, _ := proto.Marshal(dm)
+
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to})
+	consumer.GetChannel() <- data
+
+	msg := <-chan_to
+	if msg.DnsTap.TimeNsec != 500000000 {
+		t.Errorf("invalid time nsec in dns message: %d", msg.DnsTap.TimeNsec)
+	}
+	if msg.DnsTap.TimestampRFC3339 != "2023-04-21T00:00:00.5Z" {
+		t.Errorf("invalid timestamp in dns message: %s", msg.DnsTap.TimestampRFC3339)
+	}
+}
@@ -0,0 +1,209 @@
+package collectors
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+// Prober sends periodically the configured queries to the resolvers, the probe queries
+// and their replies are logged like the passive traffic with the probe operations
+type Prober struct {
+	done         chan bool
+	exit         chan bool
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	identity     string
+	processors   *Processors
+	dnsProcessor DnsProcessor
+	probes       sync.WaitGroup
+}
+
+func NewProber(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *Prober {
+	logger.Info("[%s] prober collector - enabled", name)
+	s := &Prober{
+		done:       make(chan bool),
+		exit:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		processors: NewProcessors(config),
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *Prober) GetName() string { return c.name }
+
+func (c *Prober) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *Prober) Loggers() []chan dnsutils.DnsMessage {
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *Prober) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *Prober) ReadConfig() {
+	c.identity = c.config.GetServerIdentity()
+}
+
+func (c *Prober) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
+}
+
+func (c *Prober) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] prober collector - "+msg, v...)
+}
+
+func (c *Prober) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] prober collector - "+msg, v...)
+}
+
+func (c *Prober) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+// newMessage returns the probe message of a payload sent from the source to the destination
+func (c *Prober) newMessage(payload []byte, src string, dst string, target string, operation string) dnsutils.DnsMessage {
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+	dm.DnsTap.Identity = c.identity
+	dm.DnsTap.Operation = operation
+
+	now := time.Now()
+	dm.DnsTap.TimeSec = int(now.Unix())
+	dm.DnsTap.TimeNsec = now.Nanosecond()
+
+	dm.NetworkInfo.Protocol = strings.ToUpper(c.config.Collectors.Prober.Protocol)
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	if ip, port, err := net.SplitHostPort(src); err == nil {
+		dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort = ip, port
+		if net.ParseIP(ip).To4() == nil {
+			dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+		}
+	}
+	if ip, port, err := net.SplitHostPort(dst); err == nil {
+		dm.NetworkInfo.ResponseIp, dm.NetworkInfo.ResponsePort = ip, port
+	}
+
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+	dm.Probe = &dnsutils.DnsProbe{Target: target}
+	return dm
+}
+
+// Probe sends the query to the target and logs the query and the reply, a reply
+// with the TIMEOUT rcode is logged when the target doesn't answer
+func (c *Prober) Probe(target string, query dnsutils.ConfigProbeQuery) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(query.Qname), dns.StringToType[strings.ToUpper(query.Qtype)])
+	payload, err := msg.Pack()
+	if err != nil {
+		c.LogError("invalid probe query %s: %v", query.Qname, err)
+		return
+	}
+
+	client := &dns.Client{
+		Net:     c.config.Collectors.Prober.Protocol,
+		Timeout: time.Duration(c.config.Collectors.Prober.Timeout) * time.Second,
+	}
+	local := ""
+	conn, err := client.Dial(target)
+	if err == nil {
+		defer conn.Close()
+		local = conn.LocalAddr().String()
+	}
+	c.dnsProcessor.GetChannel() <- c.newMessage(payload, local, target, target, dnsutils.OPERATION_PROBE_QUERY)
+
+	var reply *dns.Msg
+	var rtt time.Duration
+	if err == nil {
+		reply, rtt, err = client.ExchangeWithConn(msg, conn)
+	}
+	var answer []byte
+	if err == nil {
+		answer, err = reply.Pack()
+	}
+
+	// no reply, the query is logged as a reply with the error
+	if err != nil {
+		c.LogError("probe %s to %s failed: %v", query.Qname, target, err)
+		// the query payload is already sent to the processor, the flag is set on a copy
+		answer = append([]byte(nil), payload...)
+		answer[2] |= 0x80
+	}
+	dm := c.newMessage(answer, target, local, target, dnsutils.OPERATION_PROBE_RESPONSE)
+	dm.DnsTap.Latency = rtt.Seconds()
+	if err != nil {
+		dm.Probe.Error = err.Error()
+	}
+	c.dnsProcessor.GetChannel() <- dm
+}
+
+// ProbeAll sends all the queries to all the targets
+func (c *Prober) ProbeAll() {
+	for _, target := range c.config.Collectors.Prober.Targets {
+		for _, query := range c.config.Collectors.Prober.Queries {
+			c.probes.Add(1)
+			go func(target string, query dnsutils.ConfigProbeQuery) {
+				defer c.probes.Done()
+				c.Probe(target, query)
+			}(target, query)
+		}
+	}
+}
+
+func (c *Prober) Stop() {
+	c.LogInfo("stopping...")
+
+	// exit to close properly
+	c.exit <- true
+
+	// read done channel and block until run is terminated
+	<-c.done
+	close(c.done)
+}
+
+func (c *Prober) Run() {
+	c.LogInfo("starting collector...")
+
+	c.dnsProcessor = NewDnsProcessor(c.processors.Config(), c.logger, c.name)
+	c.dnsProcessor.SetBatchChannels(c.BatchLoggers())
+	go c.dnsProcessor.Run(c.Loggers())
+	c.processors.Add(&c.dnsProcessor)
+
+	ticker := time.NewTicker(time.Duration(c.config.Collectors.Prober.Interval) * time.Second)
+	c.ProbeAll()
+
+LOOP:
+	for {
+		select {
+		case <-c.exit:
+			break LOOP
+		case <-ticker.C:
+			c.ProbeAll()
+		}
+	}
+	ticker.Stop()
+
+	// wait the probes before to stop the dns processor
+	c.probes.Wait()
+	c.processors.Remove(&c.dnsProcessor)
+	c.dnsProcessor.Stop()
+
+	c.LogInfo("run terminated")
+	c.done <- true
+}
@@ -0,0 +1,80 @@
+package collectors
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func Test_ProberCollector(t *testing.T) {
+	// fake resolver
+	resolver := &dns.Server{Addr: "127.0.0.1:5395", Net: "udp", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(m)
+	})}
+	started := make(chan bool)
+	resolver.NotifyStartedFunc = func() { close(started) }
+	go resolver.ListenAndServe()
+	defer resolver.Shutdown()
+	<-started
+
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Prober.Targets = []string{"127.0.0.1:5395"}
+	config.Collectors.Prober.Queries = []dnsutils.ConfigProbeQuery{{Qname: "dns.collector", Qtype: "AAAA"}}
+	config.Collectors.Prober.Interval = 60
+
+	c := NewProber([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+	defer c.Stop()
+
+	dm := <-g.Channel()
+	if dm.DnsTap.Operation != dnsutils.OPERATION_PROBE_QUERY || dm.DNS.Qname != "dns.collector" || dm.DNS.Qtype != "AAAA" {
+		t.Errorf("invalid probe query: %s %s %s", dm.DnsTap.Operation, dm.DNS.Qname, dm.DNS.Qtype)
+	}
+	if dm.Probe == nil || dm.Probe.Target != "127.0.0.1:5395" || dm.NetworkInfo.ResponsePort != "5395" {
+		t.Errorf("invalid probe target: %+v", dm.Probe)
+	}
+
+	dm = <-g.Channel()
+	if dm.DnsTap.Operation != dnsutils.OPERATION_PROBE_RESPONSE || dm.DNS.Rcode != dnsutils.DNS_RCODE_NXDOMAIN {
+		t.Errorf("invalid probe response: %s %s", dm.DnsTap.Operation, dm.DNS.Rcode)
+	}
+	if dm.NetworkInfo.QueryIp != "127.0.0.1" || dm.NetworkInfo.ResponsePort != "5395" || dm.DnsTap.Latency <= 0 {
+		t.Errorf("invalid probe response: %+v %f", dm.NetworkInfo, dm.DnsTap.Latency)
+	}
+}
+
+func Test_ProberCollector_Timeout(t *testing.T) {
+	g := loggers.NewFakeLogger()
+
+	// no resolver on the target
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Prober.Targets = []string{"127.0.0.1:5394"}
+	config.Collectors.Prober.Queries = []dnsutils.ConfigProbeQuery{{Qname: "dns.collector", Qtype: "A"}}
+	config.Collectors.Prober.Interval = 60
+	config.Collectors.Prober.Timeout = 1
+
+	c := NewProber([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+	defer c.Stop()
+
+	query := <-g.Channel()
+	dm := <-g.Channel()
+
+	// the query is not modified by the error response
+	if len(query.DNS.Payload) > 2 && query.DNS.Payload[2]&0x80 != 0 {
+		t.Errorf("query flagged as a response")
+	}
+	if dm.DnsTap.Operation != dnsutils.OPERATION_PROBE_RESPONSE || dm.DNS.Rcode != dnsutils.DNS_RCODE_TIMEOUT {
+		t.Errorf("timeout expected: %s %s", dm.DnsTap.Operation, dm.DNS.Rcode)
+	}
+	if dm.Probe == nil || len(dm.Probe.Error) == 0 || dm.DNS.Qname != "dns.collector" {
+		t.Errorf("probe error expected: %+v", dm.Probe)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	"unsafe"
@@ -128,6 +130,41 @@ func GetBpfFilter(port int) []bpf.Instruction {
 	return filter
 }
 
+// ParseBpfFilter decodes a filter compiled with tcpdump -ddd, the number of
+// instructions followed by the code, jt, jf and k values of each instruction
+func ParseBpfFilter(expr string) ([]bpf.Instruction, error) {
+	fields := strings.Fields(strings.ReplaceAll(expr, ",", " "))
+	if len(fields) == 0 {
+		return nil, errors.New("empty bpf filter")
+	}
+
+	values := make([]uint32, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseUint(field, 0, 32)
+		if err != nil {
+			return nil, errors.New("invalid bpf filter value: " + field)
+		}
+		values[i] = uint32(v)
+	}
+
+	count := int(values[0])
+	if count == 0 || len(values) != 1+4*count {
+		return nil, errors.New("invalid bpf filter length")
+	}
+
+	raw := make([]bpf.RawInstruction, count)
+	for i := range raw {
+		v := values[1+4*i:]
+		raw[i] = bpf.RawInstruction{Op: uint16(v[0]), Jt: uint8(v[1]), Jf: uint8(v[2]), K: v[3]}
+	}
+
+	filter, ok := bpf.Disassemble(raw)
+	if !ok {
+		return nil, errors.New("unsupported bpf instructions")
+	}
+	return filter, nil
+}
+
 func ApplyBpfFilter(filter []bpf.Instruction, fd int) (err error) {
 	var assembled []bpf.RawInstruction
 	if assembled, err = bpf.Assemble(filter); err != nil {
@@ -147,27 +184,30 @@ func RemoveBpfFilter(fd int) (err error) {
 }
 
 type AfpacketSniffer struct {
-	done     chan bool
-	exit     chan bool
-	fd       int
-	port     int
-	device   string
-	identity string
-	loggers  []dnsutils.Worker
-	config   *dnsutils.Config
-	logger   *logger.Logger
-	name     string
+	done       chan bool
+	exit       chan bool
+	fd         int
+	port       int
+	device     string
+	filter     []bpf.Instruction
+	identity   string
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	processors *Processors
 }
 
 func NewAfpacketSniffer(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *AfpacketSniffer {
 	logger.Info("[%s] AFPACKET collector - enabled", name)
 	s := &AfpacketSniffer{
-		done:    make(chan bool),
-		exit:    make(chan bool),
-		config:  config,
-		loggers: loggers,
-		logger:  logger,
-		name:    name,
+		done:       make(chan bool),
+		exit:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		processors: NewProcessors(config),
 	}
 	s.ReadConfig()
 	return s
@@ -188,17 +228,37 @@ func (c *AfpacketSniffer) SetLoggers(loggers []dnsutils.Worker) {
 }
 
 func (c *AfpacketSniffer) Loggers() []chan dnsutils.DnsMessage {
-	channels := []chan dnsutils.DnsMessage{}
-	for _, p := range c.loggers {
-		channels = append(channels, p.Channel())
-	}
-	return channels
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *AfpacketSniffer) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
 }
 
 func (c *AfpacketSniffer) ReadConfig() {
 	c.port = c.config.Collectors.AfpacketLiveCapture.Port
 	c.identity = c.config.GetServerIdentity()
 	c.device = c.config.Collectors.AfpacketLiveCapture.Device
+
+	if c.config.Collectors.AfpacketLiveCapture.Promiscuous && c.device == "" {
+		c.logger.Fatal("collector afpacket - promiscuous mode requires a device")
+	}
+
+	// custom filter or default one on the port
+	if len(c.config.Collectors.AfpacketLiveCapture.BpfFilter) > 0 {
+		filter, err := ParseBpfFilter(c.config.Collectors.AfpacketLiveCapture.BpfFilter)
+		if err != nil {
+			c.logger.Fatal("collector afpacket - ", err)
+		}
+		c.filter = filter
+	} else {
+		c.filter = GetBpfFilter(c.port)
+	}
+}
+
+func (c *AfpacketSniffer) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
 }
 
 func (c *AfpacketSniffer) Channel() chan dnsutils.DnsMessage {
@@ -239,6 +299,18 @@ func (c *AfpacketSniffer) Listen() error {
 		}
 
 		c.LogInfo("Binding with success to iface %q (index %d)", iface.Name, iface.Index)
+
+		// enable promiscuous mode on the device
+		if c.config.Collectors.AfpacketLiveCapture.Promiscuous {
+			mreq := &unix.PacketMreq{
+				Ifindex: int32(iface.Index),
+				Type:    unix.PACKET_MR_PROMISC,
+			}
+			if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq); err != nil {
+				return err
+			}
+			c.LogInfo("promiscuous mode enabled on iface %q", iface.Name)
+		}
 	}
 
 	// set nano timestamp
@@ -247,9 +319,8 @@ func (c *AfpacketSniffer) Listen() error {
 		return err
 	}
 
-	filter := GetBpfFilter(c.port)
 	//filter := GetBpfFilter_Ingress(c.port)
-	err = ApplyBpfFilter(filter, fd)
+	err = ApplyBpfFilter(c.filter, fd)
 	if err != nil {
 		return err
 	}
@@ -272,8 +343,10 @@ func (c *AfpacketSniffer) Run() {
 		}
 	}
 
-	dnsProcessor := NewDnsProcessor(c.config, c.logger, c.name)
+	dnsProcessor := NewDnsProcessor(c.processors.Config(), c.logger, c.name)
+	dnsProcessor.SetBatchChannels(c.BatchLoggers())
 	go dnsProcessor.Run(c.Loggers())
+	c.processors.Add(&dnsProcessor)
 
 	dnsChan := make(chan netlib.DnsPacket)
 	udpChan := make(chan gopacket.Packet)
@@ -313,8 +386,8 @@ func (c *AfpacketSniffer) Run() {
 			dm.DNS.Length = len(dnsPacket.Payload)
 
 			dm.DnsTap.Identity = c.identity
-			dm.DnsTap.TimeSec = dnsPacket.Timestamp.Second()
-			dm.DnsTap.TimeNsec = int(dnsPacket.Timestamp.UnixNano())
+			dm.DnsTap.TimeSec = int(dnsPacket.Timestamp.Unix())
+			dm.DnsTap.TimeNsec = dnsPacket.Timestamp.Nanosecond()
 
 			// send DNS message to DNS processor
 			dnsProcessor.GetChannel() <- dm
@@ -412,6 +485,7 @@ func (c *AfpacketSniffer) Run() {
 	close(dnsChan)
 
 	// stop dns processor
+	c.processors.Remove(&dnsProcessor)
 	dnsProcessor.Stop()
 
 	c.LogInfo("run terminated")
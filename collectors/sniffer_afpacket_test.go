@@ -4,13 +4,17 @@
 package collectors
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/loggers"
 	"github.com/dmachard/go-logger"
+	"golang.org/x/net/bpf"
 )
 
 func TestAfpacketSnifferRun(t *testing.T) {
@@ -32,3 +36,34 @@ func TestAfpacketSnifferRun(t *testing.T) {
 		}
 	}
 }
+
+func TestAfpacketSnifferParseBpfFilter(t *testing.T) {
+	// format the default filter like tcpdump -ddd
+	raw, err := bpf.Assemble(GetBpfFilter(53))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{fmt.Sprintf("%d", len(raw))}
+	for _, ins := range raw {
+		lines = append(lines, fmt.Sprintf("%d %d %d %d", ins.Op, ins.Jt, ins.Jf, ins.K))
+	}
+
+	filter, err := ParseBpfFilter(strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := bpf.Assemble(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, raw) {
+		t.Errorf("invalid filter: %v", filter)
+	}
+
+	// invalid filters
+	for _, expr := range []string{"", "2 40 0 0 12", "1 40 0 0 x"} {
+		if _, err := ParseBpfFilter(expr); err == nil {
+			t.Errorf("filter %q should be invalid", expr)
+		}
+	}
+}
@@ -39,24 +39,26 @@ func ConvertIp6(ip [4]uint32) net.IP {
 }
 
 type XdpSniffer struct {
-	done     chan bool
-	exit     chan bool
-	identity string
-	loggers  []dnsutils.Worker
-	config   *dnsutils.Config
-	logger   *logger.Logger
-	name     string
+	done       chan bool
+	exit       chan bool
+	identity   string
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	processors *Processors
 }
 
 func NewXdpSniffer(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *XdpSniffer {
 	logger.Info("[%s] XDP collector - enabled", name)
 	s := &XdpSniffer{
-		done:    make(chan bool),
-		exit:    make(chan bool),
-		config:  config,
-		loggers: loggers,
-		logger:  logger,
-		name:    name,
+		done:       make(chan bool),
+		exit:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		processors: NewProcessors(config),
 	}
 	s.ReadConfig()
 	return s
@@ -77,17 +79,22 @@ func (c *XdpSniffer) SetLoggers(loggers []dnsutils.Worker) {
 }
 
 func (c *XdpSniffer) Loggers() []chan dnsutils.DnsMessage {
-	channels := []chan dnsutils.DnsMessage{}
-	for _, p := range c.loggers {
-		channels = append(channels, p.Channel())
-	}
-	return channels
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *XdpSniffer) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
 }
 
 func (c *XdpSniffer) ReadConfig() {
 	c.identity = c.config.GetServerIdentity()
 }
 
+func (c *XdpSniffer) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
+}
+
 func (c *XdpSniffer) Channel() chan dnsutils.DnsMessage {
 	return nil
 }
@@ -106,8 +113,10 @@ func (c *XdpSniffer) Stop() {
 func (c *XdpSniffer) Run() {
 	c.LogInfo("starting collector...")
 
-	dnsProcessor := NewDnsProcessor(c.config, c.logger, c.name)
+	dnsProcessor := NewDnsProcessor(c.processors.Config(), c.logger, c.name)
+	dnsProcessor.SetBatchChannels(c.BatchLoggers())
 	go dnsProcessor.Run(c.Loggers())
+	c.processors.Add(&dnsProcessor)
 
 	iface, err := net.InterfaceByName("wlp2s0")
 	if err != nil {
@@ -222,6 +231,7 @@ func (c *XdpSniffer) Run() {
 	<-c.exit
 
 	// stop dns processor
+	c.processors.Remove(&dnsProcessor)
 	dnsProcessor.Stop()
 
 	c.LogInfo("run terminated")
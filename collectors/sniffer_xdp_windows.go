@@ -58,6 +58,9 @@ func (c *XdpSniffer) ReadConfig() {
 	c.identity = c.config.GetServerIdentity()
 }
 
+func (c *XdpSniffer) ReloadConfig(config *dnsutils.Config) {
+}
+
 func (c *XdpSniffer) Channel() chan dnsutils.DnsMessage {
 	return nil
 }
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"strconv"
 	"syscall"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -19,27 +20,29 @@ import (
 )
 
 type TzspSniffer struct {
-	done     chan bool
-	exit     chan bool
-	listen   net.UDPConn
-	loggers  []dnsutils.Worker
-	config   *dnsutils.Config
-	logger   *logger.Logger
-	name     string
-	identity string
-	port     int
-	ip       string
+	done       chan bool
+	exit       chan bool
+	listen     net.UDPConn
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	processors *Processors
+	identity   string
+	port       int
+	ip         string
 }
 
 func NewTzsp(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *TzspSniffer {
 	logger.Info("[%s] tzsp collector - enabled", name)
 	s := &TzspSniffer{
-		done:    make(chan bool),
-		exit:    make(chan bool),
-		config:  config,
-		loggers: loggers,
-		logger:  logger,
-		name:    name,
+		done:       make(chan bool),
+		exit:       make(chan bool),
+		config:     config,
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+		processors: NewProcessors(config),
 	}
 	s.ReadConfig()
 	return s
@@ -52,11 +55,11 @@ func (c *TzspSniffer) SetLoggers(loggers []dnsutils.Worker) {
 }
 
 func (c *TzspSniffer) Loggers() []chan dnsutils.DnsMessage {
-	channels := []chan dnsutils.DnsMessage{}
-	for _, p := range c.loggers {
-		channels = append(channels, p.Channel())
-	}
-	return channels
+	return dnsutils.GetChannels(c.loggers, c.config.Global.Batch.Size)
+}
+
+func (c *TzspSniffer) BatchLoggers() []chan []dnsutils.DnsMessage {
+	return dnsutils.GetBatchChannels(c.loggers, c.config.Global.Batch.Size)
 }
 
 func (c *TzspSniffer) LogInfo(msg string, v ...interface{}) {
@@ -75,10 +78,15 @@ func (c *TzspSniffer) ReadConfig() {
 	// TODO: Implement
 }
 
+func (c *TzspSniffer) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.processors.ReloadConfig(config)
+}
+
 func (c *TzspSniffer) Listen() error {
 	c.logger.Info("running in background...")
 
-	ServerAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", c.ip, c.port))
+	ServerAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(c.ip, strconv.Itoa(c.port)))
 	if err != nil {
 		return err
 	}
@@ -125,9 +133,11 @@ func (c *TzspSniffer) Run() {
 		c.logger.Fatal("collector tzsp listening failed: ", err)
 	}
 
-	dnsProcessor := NewDnsProcessor(c.config, c.logger, c.name)
+	dnsProcessor := NewDnsProcessor(c.processors.Config(), c.logger, c.name)
 
+	dnsProcessor.SetBatchChannels(c.BatchLoggers())
 	go dnsProcessor.Run(c.Loggers())
+	c.processors.Add(&dnsProcessor)
 
 	go func() {
 		buf := make([]byte, 65536)
@@ -254,6 +264,7 @@ func (c *TzspSniffer) Run() {
 	<-c.exit
 
 	// stop dns processor
+	c.processors.Remove(&dnsProcessor)
 	dnsProcessor.Stop()
 
 	c.LogInfo("run terminated")
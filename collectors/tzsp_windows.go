@@ -57,6 +57,9 @@ func (c *TzspSniffer) Loggers() []chan dnsutils.DnsMessage {
 func (c *TzspSniffer) ReadConfig() {
 }
 
+func (c *TzspSniffer) ReloadConfig(config *dnsutils.Config) {
+}
+
 func (c *TzspSniffer) Channel() chan dnsutils.DnsMessage {
 	return nil
 }
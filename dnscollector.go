@@ -5,15 +5,16 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/collectors"
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/loggers"
 	"github.com/dmachard/go-logger"
 	"github.com/natefinch/lumberjack"
-	"gopkg.in/yaml.v2"
 )
 
 // Version is the package version, value is set during build phase
@@ -45,6 +46,15 @@ func IsCollectorRouted(config *dnsutils.Config, name string) bool {
 	return false
 }
 
+func IsWorkerIn(workers []dnsutils.Worker, w dnsutils.Worker) bool {
+	for _, worker := range workers {
+		if worker == w {
+			return true
+		}
+	}
+	return false
+}
+
 func AreRoutesValid(config *dnsutils.Config) (ret error) {
 	for _, route := range config.Multiplexer.Routes {
 		if len(route.Src) == 0 || len(route.Dst) == 0 {
@@ -54,12 +64,72 @@ func AreRoutesValid(config *dnsutils.Config) (ret error) {
 	return
 }
 
+// RefreshPublicSuffix loads again the public suffix list when the file is updated
+func RefreshPublicSuffix(logger *logger.Logger, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reloaded, err := dnsutils.RefreshPublicSuffixFile(path)
+		if err != nil {
+			logger.Error("main - public suffix list error: %v", err)
+		} else if reloaded {
+			logger.Info("main - public suffix list reloaded from %s", path)
+		}
+	}
+}
+
+// ReloadWorkers compares the new config of the workers with the running one, only the
+// transformers are reloaded, the other changes require a restart
+func ReloadWorkers(logger *logger.Logger, items []dnsutils.MultiplexInOut, workers map[string]dnsutils.Worker,
+	running map[string]*dnsutils.Config, getConfig func(*dnsutils.Config, dnsutils.MultiplexInOut) (*dnsutils.Config, error)) {
+	for _, item := range items {
+		worker, ok := workers[item.Name]
+		if !ok {
+			logger.Error("main - new worker [%s], restart required to start it", item.Name)
+			continue
+		}
+
+		subcfg, err := getConfig(running[item.Name], item)
+		if err != nil {
+			logger.Error("main - reload config error for [%s]: %v", item.Name, err)
+			continue
+		}
+
+		current := running[item.Name]
+		if !reflect.DeepEqual(subcfg.Collectors, current.Collectors) || !reflect.DeepEqual(subcfg.Loggers, current.Loggers) {
+			logger.Error("main - settings updated for [%s], restart required to apply them", item.Name)
+		}
+		if reflect.DeepEqual(subcfg.IngoingTransformers, current.IngoingTransformers) &&
+			reflect.DeepEqual(subcfg.OutgoingTransformers, current.OutgoingTransformers) {
+			continue
+		}
+
+		// only the transformers are updated in the running config
+		newcfg := *current
+		newcfg.IngoingTransformers = subcfg.IngoingTransformers
+		newcfg.OutgoingTransformers = subcfg.OutgoingTransformers
+		running[item.Name] = &newcfg
+		worker.ReloadConfig(&newcfg)
+	}
+}
+
 func main() {
 	var verFlag bool
+	var testFlag bool
+	var benchFlag bool
 	var configPath string
 
+	// tail subcommand, to follow the messages of a running instance
+	if len(os.Args) > 1 && os.Args[1] == "tail" {
+		os.Exit(runTail(os.Args[2:]))
+	}
+
 	flag.BoolVar(&verFlag, "version", false, "Show version")
+	flag.BoolVar(&testFlag, "test-config", false, "Check the config file and exit")
 	flag.StringVar(&configPath, "config", "./config.yml", "path to config file")
+	flag.BoolVar(&benchFlag, "bench", false, "Send synthetic dnstap frames through the configured pipeline and report the performances")
+	benchOpts := newBenchOptions(flag.CommandLine)
 	flag.Parse()
 
 	if verFlag {
@@ -67,6 +137,19 @@ func main() {
 		os.Exit(0)
 	}
 
+	if testFlag {
+		if err := dnsutils.CheckConfig(configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "config error:\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("config OK")
+		os.Exit(0)
+	}
+
+	if benchFlag {
+		os.Exit(runBench(configPath, benchOpts, os.Stdout))
+	}
+
 	done := make(chan bool)
 
 	// create logger
@@ -90,38 +173,40 @@ func main() {
 	// enable the verbose mode ?
 	logger.SetVerbose(config.Global.Trace.Verbose)
 
+	if config.Global.ShutdownTimeout <= 0 {
+		panic(fmt.Sprintf("main - config error: invalid shutdown-timeout %d", config.Global.ShutdownTimeout))
+	}
+
 	logger.Info("main - version %s", Version)
 	logger.Info("main - starting dns-collector...")
 
+	// load the plugins, they register their collectors, loggers and subprocessors
+	if err := dnsutils.LoadPlugins(config.Global.Plugins); err != nil {
+		panic(fmt.Sprintf("main - plugins error: %v", err))
+	}
+
+	// load the public suffix list, the list embedded at build time is used without file
+	if path := config.Global.PublicSuffix.File; len(path) > 0 {
+		if err := dnsutils.LoadPublicSuffixFile(path); err != nil {
+			panic(fmt.Sprintf("main - public suffix list error: %v", err))
+		}
+		logger.Info("main - public suffix list loaded from %s", path)
+		if config.Global.PublicSuffix.Refresh > 0 {
+			go RefreshPublicSuffix(logger, path, time.Duration(config.Global.PublicSuffix.Refresh)*time.Second)
+		}
+	}
+
 	// load loggers
 	logger.Info("main - loading loggers...")
 	mapLoggers := make(map[string]dnsutils.Worker)
+	loggersConfig := make(map[string]*dnsutils.Config)
 	for _, output := range config.Multiplexer.Loggers {
 		// load config
-		cfg := make(map[string]interface{})
-		cfg["loggers"] = output.Params
-		cfg["outgoing-transformers"] = make(map[string]interface{})
-		for _, p := range output.Params {
-			p.(map[string]interface{})["enable"] = true
-		}
-
-		// get config with default values
-		subcfg := &dnsutils.Config{}
-		subcfg.SetDefault()
-
-		// add transformer
-		for k, v := range output.Transforms {
-			v.(map[string]interface{})["enable"] = true
-			cfg["outgoing-transformers"].(map[string]interface{})[k] = v
-		}
-
-		// copy global config
-		subcfg.Global = config.Global
-
-		yamlcfg, _ := yaml.Marshal(cfg)
-		if err := yaml.Unmarshal(yamlcfg, subcfg); err != nil {
+		subcfg, err := dnsutils.GetLoggerConfig(config, output)
+		if err != nil {
 			panic(fmt.Sprintf("main - yaml logger config error: %v", err))
 		}
+		loggersConfig[output.Name] = subcfg
 
 		if subcfg.Loggers.RestAPI.Enable && IsLoggerRouted(config, output.Name) {
 			mapLoggers[output.Name] = loggers.NewRestAPI(subcfg, logger, Version, output.Name)
@@ -162,37 +247,73 @@ func main() {
 		if subcfg.Loggers.ScalyrClient.Enable && IsLoggerRouted(config, output.Name) {
 			mapLoggers[output.Name] = loggers.NewScalyrClient(subcfg, logger, output.Name)
 		}
+		if subcfg.Loggers.Rpz.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewRpzWriter(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.Accounting.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewAccounting(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.Reporter.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewReporter(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.KafkaProducer.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewKafkaProducer(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.RedisPub.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewRedisPub(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.GrpcServer.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewGrpcServer(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.Alerter.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewAlerter(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.ObjectStorage.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewObjectStorage(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.ParquetFile.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewParquetFile(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.Replay.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewReplay(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.Quarantine.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewQuarantine(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.UnixSocket.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewUnixSocket(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.Plugin.Enable && IsLoggerRouted(config, output.Name) {
+			factory, ok := dnsutils.GetLoggerPlugin(subcfg.Loggers.Plugin.Name)
+			if !ok {
+				panic(fmt.Sprintf("main - logger [%s] unknown plugin %s", output.Name, subcfg.Loggers.Plugin.Name))
+			}
+			mapLoggers[output.Name], err = factory(subcfg, logger, output.Name, subcfg.Loggers.Plugin.Params)
+			if err != nil {
+				panic(fmt.Sprintf("main - logger [%s] plugin error: %v", output.Name, err))
+			}
+		}
+
+		// apply the overflow policy on the channel of the logger
+		if w, ok := mapLoggers[output.Name]; ok {
+			mapLoggers[output.Name], err = dnsutils.NewOutput(w, output.ChannelSize, output.OverflowPolicy)
+			if err != nil {
+				panic(fmt.Sprintf("main - logger [%s] config error: %v", output.Name, err))
+			}
+		}
 	}
 
 	// load collectors
 	logger.Info("main - loading collectors...")
 	mapCollectors := make(map[string]dnsutils.Worker)
+	collectorsConfig := make(map[string]*dnsutils.Config)
 	for _, input := range config.Multiplexer.Collectors {
 		// load config
-		cfg := make(map[string]interface{})
-		cfg["collectors"] = input.Params
-		cfg["ingoing-transformers"] = make(map[string]interface{})
-		for _, p := range input.Params {
-			p.(map[string]interface{})["enable"] = true
-		}
-
-		// get config with default values
-		subcfg := &dnsutils.Config{}
-		subcfg.SetDefault()
-
-		// add transformer
-		for k, v := range input.Transforms {
-			v.(map[string]interface{})["enable"] = true
-			cfg["ingoing-transformers"].(map[string]interface{})[k] = v
-		}
-
-		// copy global config
-		subcfg.Global = config.Global
-
-		yamlcfg, _ := yaml.Marshal(cfg)
-		if err := yaml.Unmarshal(yamlcfg, subcfg); err != nil {
+		subcfg, err := dnsutils.GetCollectorConfig(config, input)
+		if err != nil {
 			panic(fmt.Sprintf("main - yaml collector config error: %v", err))
 		}
+		collectorsConfig[input.Name] = subcfg
 
 		if err := AreRoutesValid(config); err != nil {
 			panic(fmt.Sprintf("main - configuration error: %e", err))
@@ -219,13 +340,38 @@ func main() {
 		if subcfg.Collectors.FileIngestor.Enable && IsCollectorRouted(config, input.Name) {
 			mapCollectors[input.Name] = collectors.NewFileIngestor(nil, subcfg, logger, input.Name)
 		}
-		if subcfg.Collectors.Tzsp.Enable {
+		if subcfg.Collectors.Tzsp.Enable && IsCollectorRouted(config, input.Name) {
 			mapCollectors[input.Name] = collectors.NewTzsp(nil, subcfg, logger, input.Name)
 		}
+		if subcfg.Collectors.DotProxy.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewDotProxy(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.Prober.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewProber(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.Monitoring.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewMonitoring(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.Doh.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewDoh(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.Plugin.Enable && IsCollectorRouted(config, input.Name) {
+			factory, ok := dnsutils.GetCollectorPlugin(subcfg.Collectors.Plugin.Name)
+			if !ok {
+				panic(fmt.Sprintf("main - collector [%s] unknown plugin %s", input.Name, subcfg.Collectors.Plugin.Name))
+			}
+			mapCollectors[input.Name], err = factory(subcfg, logger, input.Name, subcfg.Collectors.Plugin.Params)
+			if err != nil {
+				panic(fmt.Sprintf("main - collector [%s] plugin error: %v", input.Name, err))
+			}
+		}
 	}
 
 	// here the multiplexer logic
-	// connect collectors between loggers
+	// connect collectors between loggers, a collector can be used in several routes
+	// so the loggers of all routes are merged before to connect them
+	routedLoggers := make(map[string][]dnsutils.Worker)
+	routedOrder := []string{}
 	for _, routes := range config.Multiplexer.Routes {
 		var logwrks []dnsutils.Worker
 		for _, dst := range routes.Dst {
@@ -236,16 +382,25 @@ func main() {
 			}
 		}
 		for _, src := range routes.Src {
-			if _, ok := mapCollectors[src]; ok {
-				mapCollectors[src].SetLoggers(logwrks)
-			} else {
+			if _, ok := mapCollectors[src]; !ok {
 				panic(fmt.Sprintf("main - routing error: collector [%v] doest not exist", src))
 			}
+			if _, ok := routedLoggers[src]; !ok {
+				routedOrder = append(routedOrder, src)
+			}
 			for _, l := range logwrks {
-				logger.Info("main - routing: collector[%s] send to logger[%s]", src, l.GetName())
+				if !IsWorkerIn(routedLoggers[src], l) {
+					routedLoggers[src] = append(routedLoggers[src], l)
+				}
 			}
 		}
 	}
+	for _, src := range routedOrder {
+		mapCollectors[src].SetLoggers(routedLoggers[src])
+		for _, l := range routedLoggers[src] {
+			logger.Info("main - routing: collector[%s] send to logger[%s]", src, l.GetName())
+		}
+	}
 
 	// Handle Ctrl-C
 	sigTerm := make(chan os.Signal, 1)
@@ -254,41 +409,65 @@ func main() {
 	sigHUP := make(chan os.Signal, 1)
 	signal.Notify(sigHUP, syscall.SIGHUP)
 
+	// the reload and the shutdown are handled separately, a reload blocked by a worker
+	// does not prevent the shutdown
 	go func() {
-		for {
-			select {
-			case <-sigHUP:
-				logger.Info("main - reloading config...")
-
-				// read config
-				err := dnsutils.ReloadConfig(configPath, config)
-				if err != nil {
-					panic(fmt.Sprintf("main - reload config error:  %v", err))
-				}
-
-				// enable the verbose mode ?
-				logger.SetVerbose(config.Global.Trace.Verbose)
-
-			case <-sigTerm:
-				logger.Info("main - exiting...")
+		for range sigHUP {
+			logger.Info("main - reloading config...")
+
+			// read config, the running one is kept on error
+			newConfig, err := dnsutils.LoadConfig(configPath)
+			if err != nil {
+				logger.Error("main - reload config error: %v", err)
+				dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_RELOAD, Worker: "main", Message: err.Error()})
+				continue
+			}
 
-				// stop all workers
-				logger.Info("main - stopping...")
+			// enable the verbose mode ?
+			logger.SetVerbose(newConfig.Global.Trace.Verbose)
 
-				for _, c := range mapCollectors {
-					c.Stop()
+			// the public suffix list is loaded again, the running one is kept on error
+			if path := newConfig.Global.PublicSuffix.File; len(path) > 0 {
+				if err := dnsutils.LoadPublicSuffixFile(path); err != nil {
+					logger.Error("main - public suffix list error: %v", err)
 				}
+			}
 
-				for _, l := range mapLoggers {
-					l.Stop()
-				}
+			// apply the changes on the running workers
+			ReloadWorkers(logger, newConfig.Multiplexer.Loggers, mapLoggers, loggersConfig, dnsutils.GetLoggerConfig)
+			ReloadWorkers(logger, newConfig.Multiplexer.Collectors, mapCollectors, collectorsConfig, dnsutils.GetCollectorConfig)
+			if !reflect.DeepEqual(newConfig.Multiplexer.Routes, config.Multiplexer.Routes) {
+				logger.Error("main - routes updated, restart required to apply them")
+			}
+			dnsutils.EmitEvent(dnsutils.DnsEvent{Type: dnsutils.EVENT_RELOAD, Worker: "main", Message: "configuration reloaded"})
+		}
+	}()
 
-				// unblock main function
-				done <- true
+	go func() {
+		<-sigTerm
+		logger.Info("main - exiting...")
 
-				os.Exit(0)
-			}
+		// the collectors stop accepting new messages and send the pending ones to the
+		// loggers, then the loggers drain their channel and flush their buffers
+		deadline := time.Now().Add(time.Duration(config.Global.ShutdownTimeout) * time.Second)
+		logger.Info("main - stopping collectors...")
+		if err := dnsutils.StopWorkers(mapCollectors, deadline); err != nil {
+			// the collectors still running can send to the loggers, their
+			// channels are not closed to not panic on send
+			logger.Error("main - collectors: %v", err)
+			logger.Error("main - loggers not stopped, the buffered messages are lost")
+			os.Exit(1)
+		}
+		logger.Info("main - stopping loggers...")
+		if err := dnsutils.StopWorkers(mapLoggers, deadline); err != nil {
+			logger.Error("main - loggers: %v", err)
+			os.Exit(1)
 		}
+
+		// unblock main function
+		done <- true
+
+		os.Exit(0)
 	}()
 
 	// run all workers in background
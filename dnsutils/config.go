@@ -1,8 +1,18 @@
 package dnsutils
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"reflect"
+	"regexp"
+	"strings"
 
+	"github.com/miekg/dns"
+	"github.com/yuin/gopher-lua/parse"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,6 +27,16 @@ func IsValidMode(mode string) bool {
 	return false
 }
 
+// TlsVersionName returns the name of the tls version as in the config, the number is returned for the unknown versions
+func TlsVersionName(version uint16) string {
+	for name, v := range TLS_VERSION {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
 func IsValidTLS(mode string) bool {
 	switch mode {
 	case
@@ -30,9 +50,12 @@ func IsValidTLS(mode string) bool {
 }
 
 type MultiplexInOut struct {
-	Name       string                 `yaml:"name"`
-	Transforms map[string]interface{} `yaml:"transforms"`
-	Params     map[string]interface{} `yaml:",inline"`
+	Name           string                 `yaml:"name"`
+	Transforms     map[string]interface{} `yaml:"transforms"`
+	ChannelSize    int                    `yaml:"channel-size"`
+	OverflowPolicy string                 `yaml:"overflow-policy"`
+	Label          string                 `yaml:"label"`
+	Params         map[string]interface{} `yaml:",inline"`
 }
 
 type MultiplexRoutes struct {
@@ -40,12 +63,39 @@ type MultiplexRoutes struct {
 	Dst []string `yaml:"to,flow"`
 }
 
+type ConfigThreatFeed struct {
+	Name     string `yaml:"name"`
+	Category string `yaml:"category"`
+	Source   string `yaml:"source"`
+	Format   string `yaml:"format"`
+}
+
+// ConfigPlugin is the config section of a plugin, the other keys are the parameters of the plugin
+type ConfigPlugin struct {
+	Enable bool                   `yaml:"enable"`
+	Name   string                 `yaml:"name"`
+	Params map[string]interface{} `yaml:",inline"`
+}
+
+type ConfigProbeQuery struct {
+	Qname string `yaml:"qname"`
+	Qtype string `yaml:"qtype"`
+}
+
 type ConfigTransformers struct {
 	UserPrivacy struct {
-		Enable        bool `yaml:"enable"`
-		AnonymizeIP   bool `yaml:"anonymize-ip"`
-		MinimazeQname bool `yaml:"minimaze-qname"`
-		HashIP        bool `yaml:"hash-ip"`
+		Enable          bool     `yaml:"enable"`
+		AnonymizeIP     bool     `yaml:"anonymize-ip"`
+		AnonymizeV4Bits int      `yaml:"anonymize-v4bits"`
+		AnonymizeV6Bits int      `yaml:"anonymize-v6bits"`
+		MinimazeQname   bool     `yaml:"minimaze-qname"`
+		HashIP          bool     `yaml:"hash-ip"`
+		PseudonymizeIP  bool     `yaml:"pseudonymize-ip"`
+		PseudonymizeKey string   `yaml:"pseudonymize-key"`
+		HashQname       bool     `yaml:"hash-qname"`
+		HashQnameDepth  int      `yaml:"hash-qname-depth"`
+		HashQnameSalt   string   `yaml:"hash-qname-salt"`
+		ExemptDomains   []string `yaml:"exempt-domains,flow"`
 	} `yaml:"user-privacy"`
 	Normalize struct {
 		Enable         bool `yaml:"enable"`
@@ -53,12 +103,15 @@ type ConfigTransformers struct {
 		QuietText      bool `yaml:"quiet-text"`
 		AddTld         bool `yaml:"add-tld"`
 		AddTldPlusOne  bool `yaml:"add-tld-plus-one"`
+
+		AddSubdomainDepth bool `yaml:"add-subdomain-depth"`
 	} `yaml:"normalize"`
 	Latency struct {
 		Enable            bool `yaml:"enable"`
 		MeasureLatency    bool `yaml:"measure-latency"`
 		UnansweredQueries bool `yaml:"unanswered-queries"`
 		QueriesTimeout    int  `yaml:"queries-timeout"`
+		CacheSize         int  `yaml:"cache-size"`
 	}
 	Filtering struct {
 		Enable          bool     `yaml:"enable"`
@@ -69,25 +122,158 @@ type ConfigTransformers struct {
 		DropQueryIpFile string   `yaml:"drop-queryip-file"`
 		KeepQueryIpFile string   `yaml:"keep-queryip-file"`
 		DropRcodes      []string `yaml:"drop-rcodes,flow"`
+		KeepRcodes      []string `yaml:"keep-rcodes,flow"`
 		LogQueries      bool     `yaml:"log-queries"`
 		LogReplies      bool     `yaml:"log-replies"`
 		Downsample      int      `yaml:"downsample"`
+		DropAsns        []string `yaml:"drop-asns,flow"`
+		KeepAsns        []string `yaml:"keep-asns,flow"`
+		AsnMatchOn      []string `yaml:"asn-match-on,flow"`
+		DropExpression  string   `yaml:"drop-expression"`
+		KeepExpression  string   `yaml:"keep-expression"`
+		ReloadInterval  int      `yaml:"reload-interval"`
 	} `yaml:"filtering"`
 	GeoIP struct {
-		Enable        bool   `yaml:"enable"`
-		DbCountryFile string `yaml:"mmdb-country-file"`
-		DbCityFile    string `yaml:"mmdb-city-file"`
-		DbAsnFile     string `yaml:"mmdb-asn-file"`
+		Enable               bool   `yaml:"enable"`
+		DbCountryFile        string `yaml:"mmdb-country-file"`
+		DbCityFile           string `yaml:"mmdb-city-file"`
+		DbAsnFile            string `yaml:"mmdb-asn-file"`
+		DbIspFile            string `yaml:"mmdb-isp-file"`
+		DbConnectionTypeFile string `yaml:"mmdb-connection-type-file"`
+		LookupResponseIp     bool   `yaml:"lookup-response-ip"`
+		LookupAnswerIps      bool   `yaml:"lookup-answer-ips"`
+		ReloadInterval       int    `yaml:"reload-interval"`
 	} `yaml:"geoip"`
 	Suspicious struct {
-		Enable             bool     `yaml:"enable"`
-		ThresholdQnameLen  int      `yaml:"threshold-qname-len"`
-		ThresholdPacketLen int      `yaml:"threshold-packet-len"`
-		ThresholdSlow      float64  `yaml:"threshold-slow"`
-		CommonQtypes       []string `yaml:"common-qtypes,flow"`
-		UnallowedChars     []string `yaml:"unallowed-chars,flow"`
-		ThresholdMaxLabels int      `yaml:"threshold-max-labels"`
+		Enable                  bool     `yaml:"enable"`
+		ThresholdQnameLen       int      `yaml:"threshold-qname-len"`
+		ThresholdPacketLen      int      `yaml:"threshold-packet-len"`
+		ThresholdSlow           float64  `yaml:"threshold-slow"`
+		CommonQtypes            []string `yaml:"common-qtypes,flow"`
+		UnallowedChars          []string `yaml:"unallowed-chars,flow"`
+		ThresholdMaxLabels      int      `yaml:"threshold-max-labels"`
+		ThresholdEntropy        float64  `yaml:"threshold-entropy"`
+		ThresholdConsonantRatio float64  `yaml:"threshold-consonant-ratio"`
+		ThresholdDigitRatio     float64  `yaml:"threshold-digit-ratio"`
 	} `yaml:"suspicious"`
+	Reducer struct {
+		Enable                    bool `yaml:"enable"`
+		RepetitiveTrafficDetector bool `yaml:"repetitive-traffic-detector"`
+		Coalesce                  bool `yaml:"coalesce"`
+		WatchInterval             int  `yaml:"watch-interval"`
+	} `yaml:"reducer"`
+	Quota struct {
+		Enable            bool   `yaml:"enable"`
+		TenantBy          string `yaml:"tenant-by"`
+		MaxMessagesPerSec int    `yaml:"max-messages-per-sec"`
+		MaxBytesPerSec    int    `yaml:"max-bytes-per-sec"`
+		ReportInterval    int    `yaml:"report-interval"`
+	} `yaml:"quota"`
+	Sampling struct {
+		Enable         bool `yaml:"enable"`
+		Rate           int  `yaml:"rate"`
+		MaxPerClient   int  `yaml:"max-per-client"`
+		ReportInterval int  `yaml:"report-interval"`
+	} `yaml:"sampling"`
+	DnssecCheck struct {
+		Enable     bool    `yaml:"enable"`
+		Resolver   string  `yaml:"resolver"`
+		SampleRate float64 `yaml:"sample-rate"`
+		Timeout    int     `yaml:"timeout"`
+		CacheSize  int     `yaml:"cache-size"`
+		CacheTtl   int     `yaml:"cache-ttl"`
+		Workers    int     `yaml:"workers"`
+	} `yaml:"dnssec-check"`
+	TunnelingDetector struct {
+		Enable                  bool    `yaml:"enable"`
+		WatchInterval           int     `yaml:"watch-interval"`
+		MinMessages             int     `yaml:"min-messages"`
+		ThresholdUniqSubdomains int     `yaml:"threshold-uniq-subdomains"`
+		ThresholdTxtNullRatio   float64 `yaml:"threshold-txt-null-ratio"`
+		ThresholdAvgLength      int     `yaml:"threshold-avg-length"`
+		AlertsOnly              bool    `yaml:"alerts-only"`
+	} `yaml:"tunneling-detector"`
+	ThreatIntel struct {
+		Enable          bool               `yaml:"enable"`
+		Feeds           []ConfigThreatFeed `yaml:"feeds"`
+		RefreshInterval int                `yaml:"refresh-interval"`
+		DropUnmatched   bool               `yaml:"drop-unmatched"`
+	} `yaml:"threat-intel"`
+	FieldSelection struct {
+		Enable     bool     `yaml:"enable"`
+		KeepFields []string `yaml:"keep-fields,flow"`
+		DropFields []string `yaml:"drop-fields,flow"`
+	} `yaml:"field-selection"`
+	Join struct {
+		Enable         bool `yaml:"enable"`
+		QueriesTimeout int  `yaml:"queries-timeout"`
+		CacheSize      int  `yaml:"cache-size"`
+		MergeRecords   bool `yaml:"merge-records"`
+	} `yaml:"join"`
+	Enrichment struct {
+		Enable      bool              `yaml:"enable"`
+		ReverseDns  bool              `yaml:"reverse-dns"`
+		Resolver    string            `yaml:"resolver"`
+		Timeout     int               `yaml:"timeout"`
+		CacheSize   int               `yaml:"cache-size"`
+		CacheTtl    int               `yaml:"cache-ttl"`
+		NegativeTtl int               `yaml:"negative-ttl"`
+		MaxLookups  int               `yaml:"max-lookups"`
+		Workers     int               `yaml:"workers"`
+		Networks    map[string]string `yaml:"networks"`
+	} `yaml:"enrichment"`
+	Hook struct {
+		Enable         bool     `yaml:"enable"`
+		Url            string   `yaml:"url"`
+		Command        []string `yaml:"command,flow"`
+		Key            string   `yaml:"key"`
+		Timeout        int      `yaml:"timeout"`
+		BatchSize      int      `yaml:"batch-size"`
+		FlushInterval  int      `yaml:"flush-interval"`
+		MaxConcurrency int      `yaml:"max-concurrency"`
+		MaxWait        int      `yaml:"max-wait"`
+		CacheSize      int      `yaml:"cache-size"`
+		CacheTtl       int      `yaml:"cache-ttl"`
+	} `yaml:"hook"`
+	Script struct {
+		Enable     bool   `yaml:"enable"`
+		ScriptFile string `yaml:"script-file"`
+		Code       string `yaml:"code"`
+	} `yaml:"script"`
+	Identity struct {
+		Enable   bool              `yaml:"enable"`
+		Mapping  map[string]string `yaml:"mapping"`
+		Regex    string            `yaml:"regex"`
+		Fallback string            `yaml:"fallback"`
+	} `yaml:"identity"`
+	Statistics struct {
+		Enable         bool `yaml:"enable"`
+		TopK           int  `yaml:"top-k"`
+		Capacity       int  `yaml:"capacity"`
+		Window         int  `yaml:"window"`
+		ReportInterval int  `yaml:"report-interval"`
+	} `yaml:"statistics"`
+	Alerting struct {
+		Enable              bool     `yaml:"enable"`
+		Window              int      `yaml:"window"`
+		UnansweredThreshold int      `yaml:"unanswered-threshold"`
+		ServfailThreshold   int      `yaml:"servfail-threshold"`
+		GroupBy             []string `yaml:"group-by,flow"`
+	} `yaml:"alerting"`
+	Baseline struct {
+		Enable     bool     `yaml:"enable"`
+		Interval   int      `yaml:"interval"`
+		Horizon    int      `yaml:"horizon"`
+		Threshold  float64  `yaml:"threshold"`
+		MinSamples int      `yaml:"min-samples"`
+		MinQps     float64  `yaml:"min-qps"`
+		Capacity   int      `yaml:"capacity"`
+		GroupBy    []string `yaml:"group-by,flow"`
+		Alerts     bool     `yaml:"alerts"`
+		Annotate   bool     `yaml:"annotate"`
+		StateFile  string   `yaml:"state-file"`
+	} `yaml:"baseline"`
+	Plugins []ConfigPlugin `yaml:"plugins"`
 }
 
 func (c *ConfigTransformers) SetDefault() {
@@ -99,22 +285,139 @@ func (c *ConfigTransformers) SetDefault() {
 		"NAPTR", "DNSKEY", "SRV", "SOA", "NS", "MX", "DS", "HTTPS"}
 	c.Suspicious.UnallowedChars = []string{"\"", "==", "/", ":"}
 	c.Suspicious.ThresholdMaxLabels = 10
+	c.Suspicious.ThresholdEntropy = 3.5
+	c.Suspicious.ThresholdConsonantRatio = 0.7
+	c.Suspicious.ThresholdDigitRatio = 0.3
 
 	c.UserPrivacy.Enable = false
 	c.UserPrivacy.AnonymizeIP = false
+	c.UserPrivacy.AnonymizeV4Bits = 16
+	c.UserPrivacy.AnonymizeV6Bits = 64
 	c.UserPrivacy.MinimazeQname = false
 	c.UserPrivacy.HashIP = false
+	c.UserPrivacy.PseudonymizeIP = false
+	c.UserPrivacy.PseudonymizeKey = ""
+	c.UserPrivacy.HashQname = false
+	c.UserPrivacy.HashQnameDepth = 1
+	c.UserPrivacy.HashQnameSalt = ""
+	c.UserPrivacy.ExemptDomains = []string{}
 
 	c.Normalize.Enable = false
 	c.Normalize.QnameLowerCase = false
 	c.Normalize.QuietText = false
 	c.Normalize.AddTld = false
 	c.Normalize.AddTldPlusOne = false
+	c.Normalize.AddSubdomainDepth = false
 
 	c.Latency.Enable = false
 	c.Latency.MeasureLatency = false
 	c.Latency.UnansweredQueries = false
 	c.Latency.QueriesTimeout = 2
+	c.Latency.CacheSize = 100000
+
+	c.Reducer.Enable = false
+	c.Reducer.RepetitiveTrafficDetector = false
+	c.Reducer.Coalesce = false
+	c.Reducer.WatchInterval = 60
+
+	c.Quota.Enable = false
+	c.Quota.TenantBy = TENANT_BY_IDENTITY
+	c.Quota.MaxMessagesPerSec = 0
+	c.Quota.MaxBytesPerSec = 0
+	c.Quota.ReportInterval = 60
+
+	c.Sampling.Enable = false
+	c.Sampling.Rate = 0
+	c.Sampling.MaxPerClient = 0
+	c.Sampling.ReportInterval = 60
+
+	c.DnssecCheck.Enable = false
+	c.DnssecCheck.Resolver = "127.0.0.1:53"
+	c.DnssecCheck.SampleRate = 0.01
+	c.DnssecCheck.Timeout = 2
+	c.DnssecCheck.CacheSize = 10000
+	c.DnssecCheck.CacheTtl = 300
+	c.DnssecCheck.Workers = 4
+
+	c.TunnelingDetector.Enable = false
+	c.TunnelingDetector.WatchInterval = 60
+	c.TunnelingDetector.MinMessages = 50
+	c.TunnelingDetector.ThresholdUniqSubdomains = 100
+	c.TunnelingDetector.ThresholdTxtNullRatio = 0.5
+	c.TunnelingDetector.ThresholdAvgLength = 200
+	c.TunnelingDetector.AlertsOnly = false
+
+	c.ThreatIntel.Enable = false
+	c.ThreatIntel.Feeds = []ConfigThreatFeed{}
+	c.ThreatIntel.RefreshInterval = 3600
+	c.ThreatIntel.DropUnmatched = false
+
+	c.FieldSelection.Enable = false
+	c.FieldSelection.KeepFields = []string{}
+	c.FieldSelection.DropFields = []string{}
+
+	c.Join.Enable = false
+	c.Join.QueriesTimeout = 2
+	c.Join.CacheSize = 100000
+	c.Join.MergeRecords = false
+
+	c.Enrichment.Enable = false
+	c.Enrichment.ReverseDns = false
+	c.Enrichment.Resolver = "127.0.0.1:53"
+	c.Enrichment.Timeout = 2
+	c.Enrichment.CacheSize = 10000
+	c.Enrichment.CacheTtl = 3600
+	c.Enrichment.NegativeTtl = 300
+	c.Enrichment.MaxLookups = 100
+	c.Enrichment.Workers = 4
+	c.Enrichment.Networks = map[string]string{}
+
+	c.Hook.Enable = false
+	c.Hook.Url = ""
+	c.Hook.Command = []string{}
+	c.Hook.Key = "query-ip"
+	c.Hook.Timeout = 2
+	c.Hook.BatchSize = 100
+	c.Hook.FlushInterval = 100
+	c.Hook.MaxConcurrency = 4
+	c.Hook.MaxWait = 0
+	c.Hook.CacheSize = 10000
+	c.Hook.CacheTtl = 3600
+
+	c.Script.Enable = false
+	c.Script.ScriptFile = ""
+	c.Script.Code = ""
+
+	c.Identity.Enable = false
+	c.Identity.Mapping = map[string]string{}
+	c.Identity.Regex = ""
+	c.Identity.Fallback = ""
+
+	c.Statistics.Enable = false
+	c.Statistics.TopK = 10
+	c.Statistics.Capacity = 1000
+	c.Statistics.Window = 300
+	c.Statistics.ReportInterval = 60
+
+	c.Alerting.Enable = false
+	c.Alerting.Window = 60
+	c.Alerting.UnansweredThreshold = 100
+	c.Alerting.ServfailThreshold = 100
+	c.Alerting.GroupBy = []string{ALERT_GROUP_BY_DOMAIN, ALERT_GROUP_BY_CLIENT}
+
+	c.Baseline.Enable = false
+	c.Baseline.Interval = 60
+	c.Baseline.Horizon = 86400
+	c.Baseline.Threshold = 5
+	c.Baseline.MinSamples = 30
+	c.Baseline.MinQps = 1
+	c.Baseline.Capacity = 10000
+	c.Baseline.GroupBy = []string{ALERT_GROUP_BY_DOMAIN, ALERT_GROUP_BY_CLIENT}
+	c.Baseline.Alerts = true
+	c.Baseline.Annotate = false
+	c.Baseline.StateFile = ""
+
+	c.Plugins = []ConfigPlugin{}
 
 	c.Filtering.Enable = false
 	c.Filtering.DropFqdnFile = ""
@@ -123,14 +426,26 @@ func (c *ConfigTransformers) SetDefault() {
 	c.Filtering.KeepDomainFile = ""
 	c.Filtering.DropQueryIpFile = ""
 	c.Filtering.DropRcodes = []string{}
+	c.Filtering.KeepRcodes = []string{}
 	c.Filtering.LogQueries = true
 	c.Filtering.LogReplies = true
 	c.Filtering.Downsample = 0
+	c.Filtering.DropExpression = ""
+	c.Filtering.KeepExpression = ""
+	c.Filtering.ReloadInterval = 0
+	c.Filtering.DropAsns = []string{}
+	c.Filtering.KeepAsns = []string{}
+	c.Filtering.AsnMatchOn = []string{ASN_MATCH_CLIENT, ASN_MATCH_SERVER, ASN_MATCH_ANSWER}
 
 	c.GeoIP.Enable = false
 	c.GeoIP.DbCountryFile = ""
 	c.GeoIP.DbCityFile = ""
 	c.GeoIP.DbAsnFile = ""
+	c.GeoIP.DbIspFile = ""
+	c.GeoIP.DbConnectionTypeFile = ""
+	c.GeoIP.LookupResponseIp = false
+	c.GeoIP.LookupAnswerIps = false
+	c.GeoIP.ReloadInterval = 0
 }
 
 /* main configuration */
@@ -147,9 +462,24 @@ type Config struct {
 			MaxBackups   int    `yaml:"max-backups"`
 		} `yaml:"trace"`
 		ServerIdentity string `yaml:"server-identity"`
+		DropPayload    bool   `yaml:"drop-payload"`
+		Batch          struct {
+			Size          int `yaml:"size"`
+			FlushInterval int `yaml:"flush-interval"`
+		} `yaml:"batch"`
+		Plugins []string `yaml:"plugins,flow"`
+
+		ShutdownTimeout int `yaml:"shutdown-timeout"`
+		PublicSuffix    struct {
+			File    string `yaml:"file"`
+			Refresh int    `yaml:"refresh"`
+		} `yaml:"public-suffix"`
 	} `yaml:"global"`
 
 	Collectors struct {
+		// label of the collector added to the messages, set from the multiplexer
+		Label string `yaml:"-"`
+
 		Tail struct {
 			Enable       bool   `yaml:"enable"`
 			TimeLayout   string `yaml:"time-layout"`
@@ -158,15 +488,22 @@ type Config struct {
 			FilePath     string `yaml:"file-path"`
 		} `yaml:"tail"`
 		Dnstap struct {
-			Enable        bool   `yaml:"enable"`
-			ListenIP      string `yaml:"listen-ip"`
-			ListenPort    int    `yaml:"listen-port"`
-			SockPath      string `yaml:"sock-path"`
-			TlsSupport    bool   `yaml:"tls-support"`
-			TlsMinVersion string `yaml:"tls-min-version"`
-			CertFile      string `yaml:"cert-file"`
-			KeyFile       string `yaml:"key-file"`
-			RcvBufSize    int    `yaml:"sock-rcvbuf"`
+			Enable          bool   `yaml:"enable"`
+			ListenIP        string `yaml:"listen-ip"`
+			ListenPort      int    `yaml:"listen-port"`
+			SockPath        string `yaml:"sock-path"`
+			TlsSupport      bool   `yaml:"tls-support"`
+			TlsMinVersion   string `yaml:"tls-min-version"`
+			CertFile        string `yaml:"cert-file"`
+			KeyFile         string `yaml:"key-file"`
+			TlsMutual       bool   `yaml:"tls-mutual"`
+			CaFile          string `yaml:"ca-file"`
+			RcvBufSize      int    `yaml:"sock-rcvbuf"`
+			DecodingWorkers int    `yaml:"decoding-workers"`
+			OrderedDecoding bool   `yaml:"ordered-decoding"`
+			MaxFrameSize    int    `yaml:"max-frame-size"`
+			MultiMessage    bool   `yaml:"multi-message"`
+			TransferMode    string `yaml:"transfer-mode"`
 		} `yaml:"dnstap"`
 		DnstapProxifier struct {
 			Enable        bool   `yaml:"enable"`
@@ -177,11 +514,15 @@ type Config struct {
 			TlsMinVersion string `yaml:"tls-min-version"`
 			CertFile      string `yaml:"cert-file"`
 			KeyFile       string `yaml:"key-file"`
+			TlsMutual     bool   `yaml:"tls-mutual"`
+			CaFile        string `yaml:"ca-file"`
 		} `yaml:"dnstap-proxifier"`
 		AfpacketLiveCapture struct {
-			Enable bool   `yaml:"enable"`
-			Port   int    `yaml:"port"`
-			Device string `yaml:"device"`
+			Enable      bool   `yaml:"enable"`
+			Port        int    `yaml:"port"`
+			Device      string `yaml:"device"`
+			Promiscuous bool   `yaml:"promiscuous"`
+			BpfFilter   string `yaml:"bpf-filter"`
 		} `yaml:"afpacket-sniffer"`
 		XdpLiveCapture struct {
 			Enable bool   `yaml:"enable"`
@@ -198,17 +539,58 @@ type Config struct {
 			KeyFile       string `yaml:"key-file"`
 		} `yaml:"powerdns"`
 		FileIngestor struct {
-			Enable      bool   `yaml:"enable"`
-			WatchDir    string `yaml:"watch-dir"`
-			WatchMode   string `yaml:"watch-mode"`
-			PcapDnsPort int    `yaml:"pcap-dns-port"`
-			DeleteAfter bool   `yaml:"delete-after"`
+			Enable             bool     `yaml:"enable"`
+			WatchDir           string   `yaml:"watch-dir"`
+			WatchMode          string   `yaml:"watch-mode"`
+			Files              []string `yaml:"files"`
+			PcapDnsPort        int      `yaml:"pcap-dns-port"`
+			PcapReplayRate     float64  `yaml:"pcap-replay-rate"`
+			PcapKeepTimestamps bool     `yaml:"pcap-keep-timestamps"`
+			DeleteAfter        bool     `yaml:"delete-after"`
 		} `yaml:"file-ingestor"`
 		Tzsp struct {
 			Enable     bool   `yaml:"enable"`
 			ListenIp   string `yaml:"listen-ip"`
 			ListenPort int    `yaml:"listen-port"`
 		}
+		DotProxy struct {
+			Enable        bool   `yaml:"enable"`
+			ListenIP      string `yaml:"listen-ip"`
+			ListenPort    int    `yaml:"listen-port"`
+			QuicPort      int    `yaml:"quic-port"`
+			TlsMinVersion string `yaml:"tls-min-version"`
+			CertFile      string `yaml:"cert-file"`
+			KeyFile       string `yaml:"key-file"`
+			Upstream      string `yaml:"upstream"`
+			UpstreamTls   bool   `yaml:"upstream-tls"`
+			Timeout       int    `yaml:"timeout"`
+		} `yaml:"dot-proxy"`
+		Prober struct {
+			Enable   bool               `yaml:"enable"`
+			Targets  []string           `yaml:"targets,flow"`
+			Queries  []ConfigProbeQuery `yaml:"queries"`
+			Interval int                `yaml:"interval"`
+			Timeout  int                `yaml:"timeout"`
+			Protocol string             `yaml:"protocol"`
+		} `yaml:"prober"`
+		Monitoring struct {
+			Enable     bool `yaml:"enable"`
+			Interval   int  `yaml:"interval"`
+			BufferSize int  `yaml:"buffer-size"`
+		} `yaml:"monitoring"`
+		Plugin ConfigPlugin `yaml:"plugin"`
+		Doh    struct {
+			Enable        bool   `yaml:"enable"`
+			ListenIP      string `yaml:"listen-ip"`
+			ListenPort    int    `yaml:"listen-port"`
+			Path          string `yaml:"path"`
+			TlsSupport    bool   `yaml:"tls-support"`
+			TlsMinVersion string `yaml:"tls-min-version"`
+			CertFile      string `yaml:"cert-file"`
+			KeyFile       string `yaml:"key-file"`
+			Upstream      string `yaml:"upstream"`
+			Timeout       int    `yaml:"timeout"`
+		} `yaml:"doh"`
 	} `yaml:"collectors"`
 
 	IngoingTransformers ConfigTransformers `yaml:"ingoing-transformers"`
@@ -245,14 +627,22 @@ type Config struct {
 			CertFile       string `yaml:"cert-file"`
 			KeyFile        string `yaml:"key-file"`
 			TopN           int    `yaml:"top-n"`
+			EventsMaxSize  int    `yaml:"events-max-size"`
+			EventsMaxAge   int    `yaml:"events-max-age"`
+			QpsWindow      int    `yaml:"qps-window"`
+			StatsRetention int    `yaml:"stats-retention"`
+			Dashboard      bool   `yaml:"dashboard"`
 		} `yaml:"restapi"`
 		LogFile struct {
 			Enable              bool   `yaml:"enable"`
 			FilePath            string `yaml:"file-path"`
 			MaxSize             int    `yaml:"max-size"`
 			MaxFiles            int    `yaml:"max-files"`
+			MaxAge              int    `yaml:"max-age"`
+			RotationInterval    int    `yaml:"rotation-interval"`
 			FlushInterval       int    `yaml:"flush-interval"`
 			Compress            bool   `yaml:"compress"`
+			CompressMode        string `yaml:"compress-mode"`
 			CompressInterval    int    `yaml:"compress-interval"`
 			CompressPostCommand string `yaml:"compress-postcommand"`
 			Mode                string `yaml:"mode"`
@@ -271,6 +661,9 @@ type Config struct {
 			TlsSupport        bool   `yaml:"tls-support"`
 			TlsInsecure       bool   `yaml:"tls-insecure"`
 			TlsMinVersion     string `yaml:"tls-min-version"`
+			CaFile            string `yaml:"ca-file"`
+			CertFile          string `yaml:"cert-file"`
+			KeyFile           string `yaml:"key-file"`
 			ServerId          string `yaml:"server-id"`
 			OverwriteIdentity bool   `yaml:"overwrite-identity"`
 			BufferSize        int    `yaml:"buffer-size"`
@@ -281,6 +674,8 @@ type Config struct {
 			RemotePort       int    `yaml:"remote-port"`
 			SockPath         string `yaml:"sock-path"`
 			RetryInterval    int    `yaml:"retry-interval"`
+			MaxRetryInterval int    `yaml:"max-retry-interval"`
+			RetryBufferSize  int    `yaml:"retry-buffer-size"`
 			Transport        string `yaml:"transport"`
 			TlsSupport       bool   `yaml:"tls-support"`
 			TlsInsecure      bool   `yaml:"tls-insecure"`
@@ -288,22 +683,27 @@ type Config struct {
 			Mode             string `yaml:"mode"`
 			TextFormat       string `yaml:"text-format"`
 			PayloadDelimiter string `yaml:"delimiter"`
+			Framing          string `yaml:"framing"`
 			BufferSize       int    `yaml:"buffer-size"`
 			FlushInterval    int    `yaml:"flush-interval"`
 			ConnectTimeout   int    `yaml:"connect-timeout"`
+			AckMode          bool   `yaml:"ack-mode"`
+			AckTimeout       int    `yaml:"ack-timeout"`
+			SpoolDir         string `yaml:"spool-dir"`
 		} `yaml:"tcpclient"`
 		Syslog struct {
-			Enable        bool   `yaml:"enable"`
-			Severity      string `yaml:"severity"`
-			Facility      string `yaml:"facility"`
-			Transport     string `yaml:"transport"`
-			RemoteAddress string `yaml:"remote-address"`
-			TextFormat    string `yaml:"text-format"`
-			Mode          string `yaml:"mode"`
-			TlsSupport    bool   `yaml:"tls-support"`
-			TlsInsecure   bool   `yaml:"tls-insecure"`
-			TlsMinVersion string `yaml:"tls-min-version"`
-			Format        string `yaml:"format"`
+			Enable         bool   `yaml:"enable"`
+			Severity       string `yaml:"severity"`
+			Facility       string `yaml:"facility"`
+			Transport      string `yaml:"transport"`
+			RemoteAddress  string `yaml:"remote-address"`
+			TextFormat     string `yaml:"text-format"`
+			Mode           string `yaml:"mode"`
+			TlsSupport     bool   `yaml:"tls-support"`
+			TlsInsecure    bool   `yaml:"tls-insecure"`
+			TlsMinVersion  string `yaml:"tls-min-version"`
+			Format         string `yaml:"format"`
+			StructuredData bool   `yaml:"structured-data"`
 		} `yaml:"syslog"`
 		Fluentd struct {
 			Enable         bool   `yaml:"enable"`
@@ -319,6 +719,7 @@ type Config struct {
 			TlsMinVersion  string `yaml:"tls-min-version"`
 			Tag            string `yaml:"tag"`
 			BufferSize     int    `yaml:"buffer-size"`
+			SharedKey      string `yaml:"shared-key"`
 		} `yaml:"fluentd"`
 		InfluxDB struct {
 			Enable        bool   `yaml:"enable"`
@@ -331,20 +732,21 @@ type Config struct {
 			Organization  string `yaml:"organization"`
 		} `yaml:"influxdb"`
 		LokiClient struct {
-			Enable         bool   `yaml:"enable"`
-			ServerURL      string `yaml:"server-url"`
-			JobName        string `yaml:"job-name"`
-			Mode           string `yaml:"mode"`
-			FlushInterval  int    `yaml:"flush-interval"`
-			BatchSize      int    `yaml:"batch-size"`
-			RetryInterval  int    `yaml:"retry-interval"`
-			TextFormat     string `yaml:"text-format"`
-			ProxyURL       string `yaml:"proxy-url"`
-			TlsInsecure    bool   `yaml:"tls-insecure"`
-			TlsMinVersion  string `yaml:"tls-min-version"`
-			BasicAuthLogin string `yaml:"basic-auth-login"`
-			BasicAuthPwd   string `yaml:"basic-auth-pwd"`
-			TenantId       string `yaml:"tenant-id"`
+			Enable         bool     `yaml:"enable"`
+			ServerURL      string   `yaml:"server-url"`
+			JobName        string   `yaml:"job-name"`
+			Mode           string   `yaml:"mode"`
+			FlushInterval  int      `yaml:"flush-interval"`
+			BatchSize      int      `yaml:"batch-size"`
+			RetryInterval  int      `yaml:"retry-interval"`
+			TextFormat     string   `yaml:"text-format"`
+			ProxyURL       string   `yaml:"proxy-url"`
+			TlsInsecure    bool     `yaml:"tls-insecure"`
+			TlsMinVersion  string   `yaml:"tls-min-version"`
+			BasicAuthLogin string   `yaml:"basic-auth-login"`
+			BasicAuthPwd   string   `yaml:"basic-auth-pwd"`
+			TenantId       string   `yaml:"tenant-id"`
+			Labels         []string `yaml:"labels,flow"`
 		} `yaml:"lokiclient"`
 		Statsd struct {
 			Enable        bool   `yaml:"enable"`
@@ -358,8 +760,16 @@ type Config struct {
 			TlsMinVersion string `yaml:"tls-min-version"`
 		} `yaml:"statsd"`
 		ElasticSearchClient struct {
-			Enable bool   `yaml:"enable"`
-			URL    string `yaml:"url"`
+			Enable        bool   `yaml:"enable"`
+			Server        string `yaml:"server"`
+			Index         string `yaml:"index"`
+			URL           string `yaml:"url"` // deprecated, replaced by server and index
+			BulkSize      int    `yaml:"bulk-size"`
+			FlushInterval int    `yaml:"flush-interval"`
+			BufferSize    int    `yaml:"buffer-size"`
+			MaxRetries    int    `yaml:"max-retries"`
+			SpoolDir      string `yaml:"spool-dir"`
+			SpoolMaxSize  int    `yaml:"spool-max-size"`
 		} `yaml:"elasticsearch"`
 		ScalyrClient struct {
 			Enable        bool                   `yaml:"enable"`
@@ -375,6 +785,162 @@ type Config struct {
 			TlsInsecure   bool                   `yaml:"tls-insecure"`
 			TlsMinVersion string                 `yaml:"tls-min-version"`
 		} `yaml:"scalyrclient"`
+		Rpz struct {
+			Enable         bool     `yaml:"enable"`
+			FilePath       string   `yaml:"file-path"`
+			ZoneName       string   `yaml:"zone-name"`
+			PrimaryNs      string   `yaml:"primary-ns"`
+			Hostmaster     string   `yaml:"hostmaster"`
+			Ttl            int      `yaml:"ttl"`
+			Action         string   `yaml:"action"`
+			Wildcard       bool     `yaml:"wildcard"`
+			ThresholdScore float64  `yaml:"threshold-score"`
+			FlushInterval  int      `yaml:"flush-interval"`
+			Notify         []string `yaml:"notify,flow"`
+		} `yaml:"rpz"`
+		Accounting struct {
+			Enable    bool   `yaml:"enable"`
+			ReportDir string `yaml:"report-dir"`
+			Period    int    `yaml:"period"`
+			GroupBy   string `yaml:"group-by"`
+			Mode      string `yaml:"mode"`
+		} `yaml:"accounting"`
+		Reporter struct {
+			Enable       bool     `yaml:"enable"`
+			ReportDir    string   `yaml:"report-dir"`
+			Period       string   `yaml:"period"`
+			TopN         int      `yaml:"top-n"`
+			Capacity     int      `yaml:"capacity"`
+			KnownDomains int      `yaml:"known-domains"`
+			Formats      []string `yaml:"formats,flow"`
+			SmtpServer   string   `yaml:"smtp-server"`
+			SmtpLogin    string   `yaml:"smtp-login"`
+			SmtpPwd      string   `yaml:"smtp-pwd"`
+			MailFrom     string   `yaml:"mail-from"`
+			MailTo       []string `yaml:"mail-to,flow"`
+		} `yaml:"reporter"`
+		KafkaProducer struct {
+			Enable            bool   `yaml:"enable"`
+			RemoteAddress     string `yaml:"remote-address"`
+			RemotePort        int    `yaml:"remote-port"`
+			ConnectTimeout    int    `yaml:"connect-timeout"`
+			TlsSupport        bool   `yaml:"tls-support"`
+			TlsInsecure       bool   `yaml:"tls-insecure"`
+			TlsMinVersion     string `yaml:"tls-min-version"`
+			SaslSupport       bool   `yaml:"sasl-support"`
+			SaslMechanism     string `yaml:"sasl-mechanism"`
+			SaslUsername      string `yaml:"sasl-username"`
+			SaslPassword      string `yaml:"sasl-password"`
+			Mode              string `yaml:"mode"`
+			TextFormat        string `yaml:"text-format"`
+			BufferSize        int    `yaml:"buffer-size"`
+			FlushInterval     int    `yaml:"flush-interval"`
+			Topic             string `yaml:"topic"`
+			PartitionKey      string `yaml:"partition-key"`
+			SchemaRegistryUrl string `yaml:"schema-registry-url"`
+			SpoolDir          string `yaml:"spool-dir"`
+			SpoolMaxSize      int    `yaml:"spool-max-size"`
+		} `yaml:"kafkaproducer"`
+		RedisPub struct {
+			Enable         bool   `yaml:"enable"`
+			RemoteAddress  string `yaml:"remote-address"`
+			RemotePort     int    `yaml:"remote-port"`
+			SockPath       string `yaml:"sock-path"`
+			ConnectTimeout int    `yaml:"connect-timeout"`
+			RetryInterval  int    `yaml:"retry-interval"`
+			TlsSupport     bool   `yaml:"tls-support"`
+			TlsInsecure    bool   `yaml:"tls-insecure"`
+			TlsMinVersion  string `yaml:"tls-min-version"`
+			Username       string `yaml:"username"`
+			Password       string `yaml:"password"`
+			Db             int    `yaml:"db"`
+			Mode           string `yaml:"mode"`
+			TextFormat     string `yaml:"text-format"`
+			BufferSize     int    `yaml:"buffer-size"`
+			FlushInterval  int    `yaml:"flush-interval"`
+			RedisMode      string `yaml:"redis-mode"`
+			RedisChannel   string `yaml:"redis-channel"`
+			StreamMaxLen   int    `yaml:"stream-maxlen"`
+		} `yaml:"redispub"`
+		GrpcServer struct {
+			Enable        bool   `yaml:"enable"`
+			ListenIP      string `yaml:"listen-ip"`
+			ListenPort    int    `yaml:"listen-port"`
+			TlsSupport    bool   `yaml:"tls-support"`
+			TlsMinVersion string `yaml:"tls-min-version"`
+			CertFile      string `yaml:"cert-file"`
+			KeyFile       string `yaml:"key-file"`
+			AuthToken     string `yaml:"auth-token"`
+			BufferSize    int    `yaml:"buffer-size"`
+		} `yaml:"grpc-server"`
+		Alerter struct {
+			Enable          bool   `yaml:"enable"`
+			Condition       string `yaml:"condition"`
+			Throttle        int    `yaml:"throttle"`
+			WebhookUrl      string `yaml:"webhook-url"`
+			WebhookFormat   string `yaml:"webhook-format"`
+			WebhookTemplate string `yaml:"webhook-template"`
+			RoutingKey      string `yaml:"routing-key"`
+			Timeout         int    `yaml:"timeout"`
+			SnmpTarget      string `yaml:"snmp-target"`
+			SnmpCommunity   string `yaml:"snmp-community"`
+			SnmpTrapOid     string `yaml:"snmp-trap-oid"`
+		} `yaml:"alerter"`
+		ObjectStorage struct {
+			Enable        bool   `yaml:"enable"`
+			Endpoint      string `yaml:"endpoint"`
+			Region        string `yaml:"region"`
+			Bucket        string `yaml:"bucket"`
+			AccessKey     string `yaml:"access-key"`
+			SecretKey     string `yaml:"secret-key"`
+			PathStyle     bool   `yaml:"path-style"`
+			Mode          string `yaml:"mode"`
+			Compress      bool   `yaml:"compress"`
+			KeyTemplate   string `yaml:"key-template"`
+			MaxSize       int    `yaml:"max-size"`
+			FlushInterval int    `yaml:"flush-interval"`
+			MaxRetries    int    `yaml:"max-retries"`
+			Timeout       int    `yaml:"timeout"`
+		} `yaml:"objectstorage"`
+		ParquetFile struct {
+			Enable        bool   `yaml:"enable"`
+			FilePath      string `yaml:"file-path"`
+			MaxRows       int    `yaml:"max-rows"`
+			FlushInterval int    `yaml:"flush-interval"`
+			MaxFiles      int    `yaml:"max-files"`
+			Compress      bool   `yaml:"compress"`
+		} `yaml:"parquetfile"`
+		Replay struct {
+			Enable        bool    `yaml:"enable"`
+			Target        string  `yaml:"target"`
+			Protocol      string  `yaml:"protocol"`
+			Timeout       int     `yaml:"timeout"`
+			Speed         float64 `yaml:"speed"`
+			MaxInflight   int     `yaml:"max-inflight"`
+			ReplayReplies bool    `yaml:"replay-replies"`
+			ResultsFile   string  `yaml:"results-file"`
+		} `yaml:"replay"`
+		Quarantine struct {
+			Enable       bool   `yaml:"enable"`
+			FilePath     string `yaml:"file-path"`
+			Encoding     string `yaml:"encoding"`
+			PcapFile     string `yaml:"pcap-file"`
+			MaxPerSecond int    `yaml:"max-per-second"`
+		} `yaml:"quarantine"`
+		UnixSocket struct {
+			Enable           bool   `yaml:"enable"`
+			SockPath         string `yaml:"sock-path"`
+			Mode             string `yaml:"mode"`
+			TextFormat       string `yaml:"text-format"`
+			PayloadDelimiter string `yaml:"delimiter"`
+			Framing          string `yaml:"framing"`
+			BufferSize       int    `yaml:"buffer-size"`
+			FlushInterval    int    `yaml:"flush-interval"`
+			ConnectTimeout   int    `yaml:"connect-timeout"`
+			RetryInterval    int    `yaml:"retry-interval"`
+			MaxRetryInterval int    `yaml:"max-retry-interval"`
+		} `yaml:"unixsocket"`
+		Plugin ConfigPlugin `yaml:"plugin"`
 	} `yaml:"loggers"`
 
 	OutgoingTransformers ConfigTransformers `yaml:"outgoing-transformers"`
@@ -398,6 +964,13 @@ func (c *Config) SetDefault() {
 	c.Global.Trace.MaxSize = 10
 	c.Global.Trace.MaxBackups = 10
 	c.Global.ServerIdentity = ""
+	c.Global.DropPayload = false
+	c.Global.Batch.Size = 0
+	c.Global.Batch.FlushInterval = 100
+	c.Global.Plugins = []string{}
+	c.Global.ShutdownTimeout = 30
+	c.Global.PublicSuffix.File = ""
+	c.Global.PublicSuffix.Refresh = 3600
 
 	// multiplexer
 	c.Multiplexer.Collectors = []MultiplexInOut{}
@@ -419,7 +992,14 @@ func (c *Config) SetDefault() {
 	c.Collectors.Dnstap.TlsMinVersion = TLS_v12
 	c.Collectors.Dnstap.CertFile = ""
 	c.Collectors.Dnstap.KeyFile = ""
+	c.Collectors.Dnstap.TlsMutual = false
+	c.Collectors.Dnstap.CaFile = ""
 	c.Collectors.Dnstap.RcvBufSize = 0
+	c.Collectors.Dnstap.DecodingWorkers = 1
+	c.Collectors.Dnstap.OrderedDecoding = false
+	c.Collectors.Dnstap.MaxFrameSize = 262144
+	c.Collectors.Dnstap.MultiMessage = true
+	c.Collectors.Dnstap.TransferMode = TRANSFER_RECORDS
 
 	c.Collectors.DnstapProxifier.Enable = false
 	c.Collectors.DnstapProxifier.ListenIP = ANY_IP
@@ -429,6 +1009,8 @@ func (c *Config) SetDefault() {
 	c.Collectors.DnstapProxifier.TlsMinVersion = TLS_v12
 	c.Collectors.DnstapProxifier.CertFile = ""
 	c.Collectors.DnstapProxifier.KeyFile = ""
+	c.Collectors.DnstapProxifier.TlsMutual = false
+	c.Collectors.DnstapProxifier.CaFile = ""
 
 	c.Collectors.XdpLiveCapture.Enable = false
 	c.Collectors.XdpLiveCapture.Device = ""
@@ -436,6 +1018,8 @@ func (c *Config) SetDefault() {
 	c.Collectors.AfpacketLiveCapture.Enable = false
 	c.Collectors.AfpacketLiveCapture.Port = 53
 	c.Collectors.AfpacketLiveCapture.Device = ""
+	c.Collectors.AfpacketLiveCapture.Promiscuous = false
+	c.Collectors.AfpacketLiveCapture.BpfFilter = ""
 
 	c.Collectors.PowerDNS.Enable = false
 	c.Collectors.PowerDNS.ListenIP = ANY_IP
@@ -449,12 +1033,48 @@ func (c *Config) SetDefault() {
 	c.Collectors.FileIngestor.WatchDir = ""
 	c.Collectors.FileIngestor.PcapDnsPort = 53
 	c.Collectors.FileIngestor.WatchMode = MODE_PCAP
+	c.Collectors.FileIngestor.Files = []string{}
+	c.Collectors.FileIngestor.PcapReplayRate = 0
+	c.Collectors.FileIngestor.PcapKeepTimestamps = true
 	c.Collectors.FileIngestor.DeleteAfter = false
 
 	c.Collectors.Tzsp.Enable = false
 	c.Collectors.Tzsp.ListenIp = ANY_IP
 	c.Collectors.Tzsp.ListenPort = 10000
 
+	c.Collectors.DotProxy.Enable = false
+	c.Collectors.DotProxy.ListenIP = ANY_IP
+	c.Collectors.DotProxy.ListenPort = 853
+	c.Collectors.DotProxy.QuicPort = 0
+	c.Collectors.DotProxy.TlsMinVersion = TLS_v12
+	c.Collectors.DotProxy.CertFile = ""
+	c.Collectors.DotProxy.KeyFile = ""
+	c.Collectors.DotProxy.Upstream = "127.0.0.1:53"
+	c.Collectors.DotProxy.UpstreamTls = false
+	c.Collectors.DotProxy.Timeout = 2
+
+	c.Collectors.Prober.Enable = false
+	c.Collectors.Prober.Targets = []string{}
+	c.Collectors.Prober.Queries = []ConfigProbeQuery{}
+	c.Collectors.Prober.Interval = 10
+	c.Collectors.Prober.Timeout = 2
+	c.Collectors.Prober.Protocol = SOCKET_UDP
+
+	c.Collectors.Monitoring.Enable = false
+	c.Collectors.Monitoring.Interval = 60
+	c.Collectors.Monitoring.BufferSize = 1024
+
+	c.Collectors.Doh.Enable = false
+	c.Collectors.Doh.ListenIP = ANY_IP
+	c.Collectors.Doh.ListenPort = 8443
+	c.Collectors.Doh.Path = "/dns-query"
+	c.Collectors.Doh.TlsSupport = false
+	c.Collectors.Doh.TlsMinVersion = TLS_v12
+	c.Collectors.Doh.CertFile = ""
+	c.Collectors.Doh.KeyFile = ""
+	c.Collectors.Doh.Upstream = ""
+	c.Collectors.Doh.Timeout = 2
+
 	// Transformers for collectors
 	c.IngoingTransformers.SetDefault()
 
@@ -473,6 +1093,9 @@ func (c *Config) SetDefault() {
 	c.Loggers.Dnstap.TlsSupport = false
 	c.Loggers.Dnstap.TlsInsecure = false
 	c.Loggers.Dnstap.TlsMinVersion = TLS_v12
+	c.Loggers.Dnstap.CaFile = ""
+	c.Loggers.Dnstap.CertFile = ""
+	c.Loggers.Dnstap.KeyFile = ""
 	c.Loggers.Dnstap.ServerId = ""
 	c.Loggers.Dnstap.OverwriteIdentity = false
 	c.Loggers.Dnstap.BufferSize = 100
@@ -482,7 +1105,10 @@ func (c *Config) SetDefault() {
 	c.Loggers.LogFile.FlushInterval = 10
 	c.Loggers.LogFile.MaxSize = 100
 	c.Loggers.LogFile.MaxFiles = 10
+	c.Loggers.LogFile.MaxAge = 0
+	c.Loggers.LogFile.RotationInterval = 0
 	c.Loggers.LogFile.Compress = false
+	c.Loggers.LogFile.CompressMode = COMPRESS_GZIP
 	c.Loggers.LogFile.CompressInterval = 60
 	c.Loggers.LogFile.CompressPostCommand = ""
 	c.Loggers.LogFile.Mode = MODE_TEXT
@@ -514,12 +1140,19 @@ func (c *Config) SetDefault() {
 	c.Loggers.RestAPI.CertFile = ""
 	c.Loggers.RestAPI.KeyFile = ""
 	c.Loggers.RestAPI.TopN = 100
+	c.Loggers.RestAPI.EventsMaxSize = 0
+	c.Loggers.RestAPI.EventsMaxAge = 3600
+	c.Loggers.RestAPI.QpsWindow = 60
+	c.Loggers.RestAPI.StatsRetention = 0
+	c.Loggers.RestAPI.Dashboard = false
 
 	c.Loggers.TcpClient.Enable = false
 	c.Loggers.TcpClient.RemoteAddress = LOCALHOST_IP
 	c.Loggers.TcpClient.RemotePort = 9999
 	c.Loggers.TcpClient.SockPath = ""
 	c.Loggers.TcpClient.RetryInterval = 10
+	c.Loggers.TcpClient.MaxRetryInterval = 300
+	c.Loggers.TcpClient.RetryBufferSize = 1000
 	c.Loggers.TcpClient.Transport = "tcp"
 	c.Loggers.TcpClient.TlsSupport = false
 	c.Loggers.TcpClient.TlsInsecure = false
@@ -527,9 +1160,13 @@ func (c *Config) SetDefault() {
 	c.Loggers.TcpClient.Mode = MODE_JSON
 	c.Loggers.TcpClient.TextFormat = ""
 	c.Loggers.TcpClient.PayloadDelimiter = "\n"
+	c.Loggers.TcpClient.Framing = FRAMING_DELIMITER
 	c.Loggers.TcpClient.BufferSize = 100
 	c.Loggers.TcpClient.ConnectTimeout = 5
 	c.Loggers.TcpClient.FlushInterval = 30
+	c.Loggers.TcpClient.AckMode = false
+	c.Loggers.TcpClient.AckTimeout = 5
+	c.Loggers.TcpClient.SpoolDir = ""
 
 	c.Loggers.Syslog.Enable = false
 	c.Loggers.Syslog.Severity = "INFO"
@@ -541,6 +1178,7 @@ func (c *Config) SetDefault() {
 	c.Loggers.Syslog.TlsSupport = false
 	c.Loggers.Syslog.TlsInsecure = false
 	c.Loggers.Syslog.TlsMinVersion = TLS_v12
+	c.Loggers.Syslog.StructuredData = false
 
 	c.Loggers.Fluentd.Enable = false
 	c.Loggers.Fluentd.RemoteAddress = LOCALHOST_IP
@@ -555,6 +1193,7 @@ func (c *Config) SetDefault() {
 	c.Loggers.Fluentd.TlsMinVersion = TLS_v12
 	c.Loggers.Fluentd.Tag = "dns.collector"
 	c.Loggers.Fluentd.BufferSize = 100
+	c.Loggers.Fluentd.SharedKey = ""
 
 	c.Loggers.InfluxDB.Enable = false
 	c.Loggers.InfluxDB.ServerURL = "http://localhost:8086"
@@ -579,6 +1218,7 @@ func (c *Config) SetDefault() {
 	c.Loggers.LokiClient.BasicAuthLogin = ""
 	c.Loggers.LokiClient.BasicAuthPwd = ""
 	c.Loggers.LokiClient.TenantId = ""
+	c.Loggers.LokiClient.Labels = []string{"identity"}
 
 	c.Loggers.Statsd.Enable = false
 	c.Loggers.Statsd.Prefix = PROG_NAME
@@ -591,7 +1231,158 @@ func (c *Config) SetDefault() {
 	c.Loggers.Statsd.TlsMinVersion = TLS_v12
 
 	c.Loggers.ElasticSearchClient.Enable = false
-	c.Loggers.ElasticSearchClient.URL = "http://127.0.0.1:9200/indexname/_doc"
+	c.Loggers.ElasticSearchClient.Server = "http://127.0.0.1:9200/"
+	c.Loggers.ElasticSearchClient.Index = "dnscollector-%Y.%m.%d"
+	c.Loggers.ElasticSearchClient.URL = ""
+	c.Loggers.ElasticSearchClient.BulkSize = 100
+	c.Loggers.ElasticSearchClient.FlushInterval = 10
+	c.Loggers.ElasticSearchClient.BufferSize = 10000
+	c.Loggers.ElasticSearchClient.MaxRetries = 10
+	c.Loggers.ElasticSearchClient.SpoolDir = ""
+	c.Loggers.ElasticSearchClient.SpoolMaxSize = 100
+
+	c.Loggers.Rpz.Enable = false
+	c.Loggers.Rpz.FilePath = ""
+	c.Loggers.Rpz.ZoneName = "rpz.local"
+	c.Loggers.Rpz.PrimaryNs = "localhost"
+	c.Loggers.Rpz.Hostmaster = "hostmaster.localhost"
+	c.Loggers.Rpz.Ttl = 300
+	c.Loggers.Rpz.Action = RPZ_ACTION_NXDOMAIN
+	c.Loggers.Rpz.Wildcard = true
+	c.Loggers.Rpz.ThresholdScore = 1.0
+	c.Loggers.Rpz.FlushInterval = 10
+	c.Loggers.Rpz.Notify = []string{}
+
+	c.Loggers.Accounting.Enable = false
+	c.Loggers.Accounting.ReportDir = ""
+	c.Loggers.Accounting.Period = 86400
+	c.Loggers.Accounting.GroupBy = ACCOUNTING_BY_CLIENT
+	c.Loggers.Accounting.Mode = MODE_CSV
+
+	c.Loggers.Reporter.Enable = false
+	c.Loggers.Reporter.ReportDir = ""
+	c.Loggers.Reporter.Period = REPORT_DAILY
+	c.Loggers.Reporter.TopN = 10
+	c.Loggers.Reporter.Capacity = 10000
+	c.Loggers.Reporter.KnownDomains = 100000
+	c.Loggers.Reporter.Formats = []string{MODE_HTML, MODE_CSV}
+	c.Loggers.Reporter.SmtpServer = ""
+	c.Loggers.Reporter.SmtpLogin = ""
+	c.Loggers.Reporter.SmtpPwd = ""
+	c.Loggers.Reporter.MailFrom = ""
+	c.Loggers.Reporter.MailTo = []string{}
+
+	c.Loggers.KafkaProducer.Enable = false
+	c.Loggers.KafkaProducer.RemoteAddress = LOCALHOST_IP
+	c.Loggers.KafkaProducer.RemotePort = 9092
+	c.Loggers.KafkaProducer.ConnectTimeout = 5
+	c.Loggers.KafkaProducer.TlsSupport = false
+	c.Loggers.KafkaProducer.TlsInsecure = false
+	c.Loggers.KafkaProducer.TlsMinVersion = TLS_v12
+	c.Loggers.KafkaProducer.SaslSupport = false
+	c.Loggers.KafkaProducer.SaslMechanism = SASL_MECHANISM_PLAIN
+	c.Loggers.KafkaProducer.SaslUsername = ""
+	c.Loggers.KafkaProducer.SaslPassword = ""
+	c.Loggers.KafkaProducer.Mode = MODE_FLATJSON
+	c.Loggers.KafkaProducer.TextFormat = ""
+	c.Loggers.KafkaProducer.BufferSize = 100
+	c.Loggers.KafkaProducer.FlushInterval = 10
+	c.Loggers.KafkaProducer.Topic = "dnscollector"
+	c.Loggers.KafkaProducer.PartitionKey = ""
+	c.Loggers.KafkaProducer.SchemaRegistryUrl = ""
+	c.Loggers.KafkaProducer.SpoolDir = ""
+	c.Loggers.KafkaProducer.SpoolMaxSize = 100
+
+	c.Loggers.RedisPub.Enable = false
+	c.Loggers.RedisPub.RemoteAddress = LOCALHOST_IP
+	c.Loggers.RedisPub.RemotePort = 6379
+	c.Loggers.RedisPub.SockPath = ""
+	c.Loggers.RedisPub.ConnectTimeout = 5
+	c.Loggers.RedisPub.RetryInterval = 10
+	c.Loggers.RedisPub.TlsSupport = false
+	c.Loggers.RedisPub.TlsInsecure = false
+	c.Loggers.RedisPub.TlsMinVersion = TLS_v12
+	c.Loggers.RedisPub.Username = ""
+	c.Loggers.RedisPub.Password = ""
+	c.Loggers.RedisPub.Db = 0
+	c.Loggers.RedisPub.Mode = MODE_FLATJSON
+	c.Loggers.RedisPub.TextFormat = ""
+	c.Loggers.RedisPub.BufferSize = 100
+	c.Loggers.RedisPub.FlushInterval = 1
+	c.Loggers.RedisPub.RedisMode = REDIS_MODE_PUBSUB
+	c.Loggers.RedisPub.RedisChannel = "dnscollector"
+	c.Loggers.RedisPub.StreamMaxLen = 0
+
+	c.Loggers.GrpcServer.Enable = false
+	c.Loggers.GrpcServer.ListenIP = LOCALHOST_IP
+	c.Loggers.GrpcServer.ListenPort = 50051
+	c.Loggers.GrpcServer.TlsSupport = false
+	c.Loggers.GrpcServer.TlsMinVersion = TLS_v12
+	c.Loggers.GrpcServer.CertFile = ""
+	c.Loggers.GrpcServer.KeyFile = ""
+	c.Loggers.GrpcServer.AuthToken = ""
+	c.Loggers.GrpcServer.BufferSize = 512
+
+	c.Loggers.Alerter.Enable = false
+	c.Loggers.Alerter.Condition = ""
+	c.Loggers.Alerter.Throttle = 300
+	c.Loggers.Alerter.WebhookUrl = ""
+	c.Loggers.Alerter.WebhookFormat = WEBHOOK_FORMAT_GENERIC
+	c.Loggers.Alerter.WebhookTemplate = ""
+	c.Loggers.Alerter.RoutingKey = ""
+	c.Loggers.Alerter.Timeout = 5
+	c.Loggers.Alerter.SnmpTarget = ""
+	c.Loggers.Alerter.SnmpCommunity = "public"
+	c.Loggers.Alerter.SnmpTrapOid = "1.3.6.1.4.1.8072.9999.9999"
+
+	c.Loggers.ObjectStorage.Enable = false
+	c.Loggers.ObjectStorage.Endpoint = ""
+	c.Loggers.ObjectStorage.Region = "us-east-1"
+	c.Loggers.ObjectStorage.Bucket = ""
+	c.Loggers.ObjectStorage.AccessKey = ""
+	c.Loggers.ObjectStorage.SecretKey = ""
+	c.Loggers.ObjectStorage.PathStyle = false
+	c.Loggers.ObjectStorage.Mode = MODE_JSON
+	c.Loggers.ObjectStorage.Compress = true
+	c.Loggers.ObjectStorage.KeyTemplate = "{{.Year}}/{{.Month}}/{{.Day}}/{{.Identity}}/{{.Name}}-{{.Timestamp}}{{.Ext}}"
+	c.Loggers.ObjectStorage.MaxSize = 64
+	c.Loggers.ObjectStorage.FlushInterval = 300
+	c.Loggers.ObjectStorage.MaxRetries = 3
+	c.Loggers.ObjectStorage.Timeout = 60
+
+	c.Loggers.ParquetFile.Enable = false
+	c.Loggers.ParquetFile.FilePath = ""
+	c.Loggers.ParquetFile.MaxRows = 1000000
+	c.Loggers.ParquetFile.FlushInterval = 300
+	c.Loggers.ParquetFile.MaxFiles = 100
+	c.Loggers.ParquetFile.Compress = true
+
+	c.Loggers.Replay.Enable = false
+	c.Loggers.Replay.Target = "127.0.0.1:53"
+	c.Loggers.Replay.Protocol = SOCKET_UDP
+	c.Loggers.Replay.Timeout = 2
+	c.Loggers.Replay.Speed = 1
+	c.Loggers.Replay.MaxInflight = 100
+	c.Loggers.Replay.ReplayReplies = false
+	c.Loggers.Replay.ResultsFile = ""
+
+	c.Loggers.Quarantine.Enable = false
+	c.Loggers.Quarantine.FilePath = ""
+	c.Loggers.Quarantine.Encoding = ENCODING_HEX
+	c.Loggers.Quarantine.PcapFile = ""
+	c.Loggers.Quarantine.MaxPerSecond = 10
+
+	c.Loggers.UnixSocket.Enable = false
+	c.Loggers.UnixSocket.SockPath = ""
+	c.Loggers.UnixSocket.Mode = MODE_JSON
+	c.Loggers.UnixSocket.TextFormat = ""
+	c.Loggers.UnixSocket.PayloadDelimiter = "\n"
+	c.Loggers.UnixSocket.Framing = FRAMING_DELIMITER
+	c.Loggers.UnixSocket.BufferSize = 100
+	c.Loggers.UnixSocket.FlushInterval = 1
+	c.Loggers.UnixSocket.ConnectTimeout = 5
+	c.Loggers.UnixSocket.RetryInterval = 1
+	c.Loggers.UnixSocket.MaxRetryInterval = 30
 
 	// Transformers for loggers
 	c.OutgoingTransformers.SetDefault()
@@ -611,11 +1402,14 @@ func (c *Config) GetServerIdentity() string {
 	}
 }
 
-func ReloadConfig(configPath string, config *Config) error {
+func LoadConfig(configPath string) (*Config, error) {
+	config := &Config{}
+	config.SetDefault()
+
 	// Open config file
 	file, err := os.Open(configPath)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	defer file.Close()
 
@@ -624,31 +1418,337 @@ func ReloadConfig(configPath string, config *Config) error {
 
 	// Start YAML decoding from file
 	if err := d.Decode(&config); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+
+	return config, nil
 }
 
-func LoadConfig(configPath string) (*Config, error) {
+// GetLoggerConfig returns the config of the logger with default values and its transformers
+func GetLoggerConfig(config *Config, output MultiplexInOut) (*Config, error) {
+	return getWorkerConfig(config, output, "loggers", "outgoing-transformers", false)
+}
+
+// GetCollectorConfig returns the config of the collector with default values and its transformers
+func GetCollectorConfig(config *Config, input MultiplexInOut) (*Config, error) {
+	return getWorkerConfig(config, input, "collectors", "ingoing-transformers", false)
+}
+
+func getWorkerConfig(config *Config, item MultiplexInOut, section string, transforms string, strict bool) (*Config, error) {
+	cfg := make(map[string]interface{})
+	cfg[section] = item.Params
+	cfg[transforms] = make(map[string]interface{})
+	for _, p := range item.Params {
+		if params, ok := p.(map[string]interface{}); ok {
+			params["enable"] = true
+		}
+	}
+
+	// get config with default values
+	subcfg := &Config{}
+	subcfg.SetDefault()
+
+	// add transformer
+	for k, v := range item.Transforms {
+		if params, ok := v.(map[string]interface{}); ok {
+			params["enable"] = true
+		}
+		cfg[transforms].(map[string]interface{})[k] = v
+	}
+
+	// copy global config
+	subcfg.Global = config.Global
+
+	yamlcfg, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	d := yaml.NewDecoder(bytes.NewReader(yamlcfg))
+	d.KnownFields(strict)
+	if err := d.Decode(subcfg); err != nil {
+		// the config sections are anonymous structs, remove their definition from the errors
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for i, e := range typeErr.Errors {
+				typeErr.Errors[i], _, _ = strings.Cut(e, " in type struct")
+			}
+		}
+		return nil, err
+	}
+	if section == "collectors" {
+		subcfg.Collectors.Label = item.Label
+	}
+	return subcfg, nil
+}
+
+// CheckConfig loads the config file in strict mode, the unknown keys are reported, then checks the
+// routes and the files referenced by the collectors, the loggers and their transformers
+func CheckConfig(configPath string) error {
 	config := &Config{}
 	config.SetDefault()
 
-	// Open config file
 	file, err := os.Open(configPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 
-	// Init new YAML decode
 	d := yaml.NewDecoder(file)
+	d.KnownFields(true)
+	if err := d.Decode(config); err != nil {
+		return err
+	}
 
-	// Start YAML decoding from file
-	if err := d.Decode(&config); err != nil {
-		return nil, err
+	// the plugins register their collectors, loggers and subprocessors
+	if err := LoadPlugins(config.Global.Plugins); err != nil {
+		return err
 	}
 
-	return config, nil
+	var errs []error
+	names := make(map[string]bool)
+	check := func(kind string, items []MultiplexInOut, section string, transforms string) {
+		for _, item := range items {
+			if len(item.Name) == 0 {
+				errs = append(errs, fmt.Errorf("%s without name", kind))
+				continue
+			}
+			if names[item.Name] {
+				errs = append(errs, fmt.Errorf("%s [%s] - duplicate name", kind, item.Name))
+			}
+			names[item.Name] = true
+
+			if section == "collectors" && (item.ChannelSize != 0 || len(item.OverflowPolicy) > 0) {
+				errs = append(errs, fmt.Errorf("%s [%s] - channel-size and overflow-policy are only supported by the loggers", kind, item.Name))
+			}
+			if section == "loggers" && len(item.Label) > 0 {
+				errs = append(errs, fmt.Errorf("%s [%s] - label is only supported by the collectors", kind, item.Name))
+			}
+			if item.ChannelSize < 0 {
+				errs = append(errs, fmt.Errorf("%s [%s] - invalid channel size: %d", kind, item.Name, item.ChannelSize))
+			}
+			if len(item.OverflowPolicy) > 0 && !IsValidOverflowPolicy(item.OverflowPolicy) {
+				errs = append(errs, fmt.Errorf("%s [%s] - invalid overflow policy: %s", kind, item.Name, item.OverflowPolicy))
+			}
+
+			if len(item.Params) != 1 {
+				errs = append(errs, fmt.Errorf("%s [%s] - one %s type expected, got %d", kind, item.Name, kind, len(item.Params)))
+			}
+
+			subcfg, err := getWorkerConfig(config, item, section, transforms, true)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s [%s] - %w", kind, item.Name, err))
+				continue
+			}
+			for _, err := range CheckConfigFiles(subcfg) {
+				errs = append(errs, fmt.Errorf("%s [%s] - %w", kind, item.Name, err))
+			}
+			for _, tr := range []ConfigTransformers{subcfg.IngoingTransformers, subcfg.OutgoingTransformers} {
+				for cidr := range tr.Enrichment.Networks {
+					if _, _, err := net.ParseCIDR(cidr); err != nil {
+						errs = append(errs, fmt.Errorf("%s [%s] - enrichment: %w", kind, item.Name, err))
+					}
+				}
+				if tr.Enrichment.Enable && tr.Enrichment.ReverseDns && tr.Enrichment.Workers <= 0 {
+					errs = append(errs, fmt.Errorf("%s [%s] - enrichment: workers must be positive", kind, item.Name))
+				}
+				if tr.DnssecCheck.Enable && tr.DnssecCheck.Workers <= 0 {
+					errs = append(errs, fmt.Errorf("%s [%s] - dnssec-check: workers must be positive", kind, item.Name))
+				}
+				for _, expr := range []string{tr.Filtering.DropExpression, tr.Filtering.KeepExpression} {
+					if len(expr) == 0 {
+						continue
+					}
+					if _, err := ParseExpression(expr); err != nil {
+						errs = append(errs, fmt.Errorf("%s [%s] - filtering: %w", kind, item.Name, err))
+					}
+				}
+				if bits := tr.UserPrivacy.AnonymizeV4Bits; bits < 0 || bits > 32 {
+					errs = append(errs, fmt.Errorf("%s [%s] - user-privacy: invalid anonymize-v4bits %d", kind, item.Name, bits))
+				}
+				if bits := tr.UserPrivacy.AnonymizeV6Bits; bits < 0 || bits > 128 {
+					errs = append(errs, fmt.Errorf("%s [%s] - user-privacy: invalid anonymize-v6bits %d", kind, item.Name, bits))
+				}
+				if tr.UserPrivacy.PseudonymizeIP && len(tr.UserPrivacy.PseudonymizeKey) == 0 {
+					errs = append(errs, fmt.Errorf("%s [%s] - user-privacy: pseudonymize-key is required", kind, item.Name))
+				}
+				if tr.UserPrivacy.HashQname && len(tr.UserPrivacy.HashQnameSalt) == 0 {
+					errs = append(errs, fmt.Errorf("%s [%s] - user-privacy: hash-qname-salt is required", kind, item.Name))
+				}
+				if len(tr.Identity.Regex) > 0 {
+					if _, err := regexp.Compile(tr.Identity.Regex); err != nil {
+						errs = append(errs, fmt.Errorf("%s [%s] - identity: %w", kind, item.Name, err))
+					}
+				}
+				if tr.Hook.Enable {
+					if len(tr.Hook.Url) == 0 && len(tr.Hook.Command) == 0 {
+						errs = append(errs, fmt.Errorf("%s [%s] - hook: url or command is required", kind, item.Name))
+					}
+					if key := tr.Hook.Key; key != "query-ip" && key != "qname" && key != "identity" {
+						errs = append(errs, fmt.Errorf("%s [%s] - hook: invalid key %s", kind, item.Name, key))
+					}
+					if tr.Hook.BatchSize <= 0 || tr.Hook.FlushInterval <= 0 || tr.Hook.MaxConcurrency <= 0 {
+						errs = append(errs, fmt.Errorf("%s [%s] - hook: batch-size, flush-interval and max-concurrency must be positive", kind, item.Name))
+					}
+				}
+				if tr.Script.Enable {
+					if err := CheckScript(tr.Script.ScriptFile, tr.Script.Code); err != nil {
+						errs = append(errs, fmt.Errorf("%s [%s] - script: %w", kind, item.Name, err))
+					}
+				}
+				for _, plugin := range tr.Plugins {
+					if _, ok := GetSubprocessorPlugin(plugin.Name); !ok {
+						errs = append(errs, fmt.Errorf("%s [%s] - unknown plugin %s", kind, item.Name, plugin.Name))
+					}
+				}
+				for _, groupBy := range tr.Alerting.GroupBy {
+					if groupBy != ALERT_GROUP_BY_DOMAIN && groupBy != ALERT_GROUP_BY_CLIENT {
+						errs = append(errs, fmt.Errorf("%s [%s] - alerting: invalid group-by %s", kind, item.Name, groupBy))
+					}
+				}
+				if tr.Baseline.Enable {
+					for _, groupBy := range tr.Baseline.GroupBy {
+						if groupBy != ALERT_GROUP_BY_DOMAIN && groupBy != ALERT_GROUP_BY_CLIENT {
+							errs = append(errs, fmt.Errorf("%s [%s] - baseline: invalid group-by %s", kind, item.Name, groupBy))
+						}
+					}
+					if tr.Baseline.Interval <= 0 || tr.Baseline.Horizon < tr.Baseline.Interval {
+						errs = append(errs, fmt.Errorf("%s [%s] - baseline: interval must be positive and lower than the horizon", kind, item.Name))
+					}
+					if tr.Baseline.Threshold <= 1 {
+						errs = append(errs, fmt.Errorf("%s [%s] - baseline: threshold must be greater than 1", kind, item.Name))
+					}
+				}
+			}
+			for _, query := range subcfg.Collectors.Prober.Queries {
+				if _, ok := dns.StringToType[strings.ToUpper(query.Qtype)]; !ok {
+					errs = append(errs, fmt.Errorf("%s [%s] - prober: invalid qtype %s", kind, item.Name, query.Qtype))
+				}
+			}
+			if proto := subcfg.Collectors.Prober.Protocol; proto != SOCKET_UDP && proto != SOCKET_TCP {
+				errs = append(errs, fmt.Errorf("%s [%s] - prober: invalid protocol %s", kind, item.Name, proto))
+			}
+			if subcfg.Collectors.Plugin.Enable {
+				if _, ok := GetCollectorPlugin(subcfg.Collectors.Plugin.Name); !ok {
+					errs = append(errs, fmt.Errorf("%s [%s] - unknown plugin %s", kind, item.Name, subcfg.Collectors.Plugin.Name))
+				}
+			}
+			if subcfg.Loggers.Alerter.Enable && len(subcfg.Loggers.Alerter.Condition) > 0 {
+				if _, err := ParseExpression(subcfg.Loggers.Alerter.Condition); err != nil {
+					errs = append(errs, fmt.Errorf("%s [%s] - alerter: %w", kind, item.Name, err))
+				}
+			}
+			if subcfg.Loggers.Plugin.Enable {
+				if _, ok := GetLoggerPlugin(subcfg.Loggers.Plugin.Name); !ok {
+					errs = append(errs, fmt.Errorf("%s [%s] - unknown plugin %s", kind, item.Name, subcfg.Loggers.Plugin.Name))
+				}
+			}
+			if subcfg.Collectors.Prober.Enable && subcfg.Collectors.Prober.Interval <= 0 {
+				errs = append(errs, fmt.Errorf("%s [%s] - prober: interval must be positive", kind, item.Name))
+			}
+			if mode := subcfg.Collectors.Dnstap.TransferMode; mode != TRANSFER_RECORDS && mode != TRANSFER_SUMMARY {
+				errs = append(errs, fmt.Errorf("%s [%s] - dnstap: invalid transfer mode %s", kind, item.Name, mode))
+			}
+			if subcfg.Collectors.Monitoring.Enable && (subcfg.Collectors.Monitoring.Interval <= 0 || subcfg.Collectors.Monitoring.BufferSize <= 0) {
+				errs = append(errs, fmt.Errorf("%s [%s] - monitoring: interval and buffer-size must be positive", kind, item.Name))
+			}
+		}
+	}
+	if config.Global.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("global - shutdown-timeout must be positive"))
+	}
+
+	check("collector", config.Multiplexer.Collectors, "collectors", "ingoing-transformers")
+	collectors := names
+	names = make(map[string]bool)
+	check("logger", config.Multiplexer.Loggers, "loggers", "outgoing-transformers")
+	loggers := names
+
+	for _, route := range config.Multiplexer.Routes {
+		if len(route.Src) == 0 || len(route.Dst) == 0 {
+			errs = append(errs, fmt.Errorf("incomplete route, from: %s, to: %s", strings.Join(route.Src, ", "), strings.Join(route.Dst, ", ")))
+		}
+		for _, src := range route.Src {
+			if !collectors[src] {
+				errs = append(errs, fmt.Errorf("route - collector [%s] does not exist", src))
+			}
+		}
+		for _, dst := range route.Dst {
+			if !loggers[dst] {
+				errs = append(errs, fmt.Errorf("route - logger [%s] does not exist", dst))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckScript checks the syntax of the lua script, from the file if provided
+func CheckScript(scriptFile string, code string) error {
+	var reader io.Reader = strings.NewReader(code)
+	if len(scriptFile) > 0 {
+		fd, err := os.Open(scriptFile)
+		if err != nil {
+			// the file is reported by the files checks
+			return nil
+		}
+		defer fd.Close()
+		reader = fd
+	}
+	_, err := parse.Parse(reader, "script")
+	return err
+}
+
+// CheckConfigFiles checks that the input files of the config can be read: the files with
+// a key ending with -file (lists, tls certificates, geoip databases...), the threat intel
+// feeds which are not urls and the files read by the collectors
+func CheckConfigFiles(config *Config) []error {
+	files := []string{}
+	collectConfigFiles(reflect.ValueOf(config).Elem(), &files)
+
+	for _, feed := range config.IngoingTransformers.ThreatIntel.Feeds {
+		files = append(files, feed.Source)
+	}
+	for _, feed := range config.OutgoingTransformers.ThreatIntel.Feeds {
+		files = append(files, feed.Source)
+	}
+	if config.Collectors.Tail.Enable {
+		files = append(files, config.Collectors.Tail.FilePath)
+	}
+	if config.Collectors.FileIngestor.Enable {
+		files = append(files, config.Collectors.FileIngestor.Files...)
+		if len(config.Collectors.FileIngestor.WatchDir) > 0 {
+			files = append(files, config.Collectors.FileIngestor.WatchDir)
+		}
+	}
+
+	var errs []error
+	for _, f := range files {
+		if len(f) == 0 || strings.HasPrefix(f, "http://") || strings.HasPrefix(f, "https://") {
+			continue
+		}
+		fd, err := os.Open(f)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fd.Close()
+	}
+	return errs
+}
+
+func collectConfigFiles(v reflect.Value, files *[]string) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		tag := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		switch field.Kind() {
+		case reflect.Struct:
+			collectConfigFiles(field, files)
+		case reflect.String:
+			if strings.HasSuffix(tag, "-file") {
+				*files = append(*files, field.String())
+			}
+		}
+	}
 }
 
 func GetFakeConfig() *Config {
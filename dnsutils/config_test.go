@@ -0,0 +1,193 @@
+package dnsutils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfig_CheckValid(t *testing.T) {
+	path := writeTestConfig(t, `
+global:
+  trace:
+    verbose: true
+multiplexer:
+  collectors:
+    - name: tap
+      dnstap:
+        listen-port: 6000
+      transforms:
+        normalize:
+          qname-lowercase: true
+  loggers:
+    - name: console
+      stdout:
+        mode: text
+  routes:
+    - from: [ tap ]
+      to: [ console ]
+`)
+	if err := CheckConfig(path); err != nil {
+		t.Errorf("valid config expected: %v", err)
+	}
+}
+
+func TestConfig_CheckErrors(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "unknown global key",
+			content: `
+global:
+  trace:
+    verbosee: true
+`,
+			want: "verbosee",
+		},
+		{
+			name: "unknown logger key",
+			content: `
+multiplexer:
+  collectors:
+    - name: tap
+      dnstap:
+        listen-port: 6000
+  loggers:
+    - name: console
+      stdout:
+        modee: text
+  routes:
+    - from: [ tap ]
+      to: [ console ]
+`,
+			want: "modee",
+		},
+		{
+			name: "missing list file",
+			content: `
+multiplexer:
+  collectors:
+    - name: tap
+      dnstap:
+        listen-port: 6000
+      transforms:
+        filtering:
+          drop-fqdn-file: /nonexistent/drop.txt
+  loggers:
+    - name: console
+      stdout:
+        mode: text
+  routes:
+    - from: [ tap ]
+      to: [ console ]
+`,
+			want: "/nonexistent/drop.txt",
+		},
+		{
+			name: "hash qname without salt",
+			content: `
+multiplexer:
+  collectors:
+    - name: tap
+      dnstap:
+        listen-port: 6000
+      transforms:
+        user-privacy:
+          hash-qname: true
+  loggers:
+    - name: console
+      stdout:
+        mode: text
+  routes:
+    - from: [ tap ]
+      to: [ console ]
+`,
+			want: "hash-qname-salt is required",
+		},
+		{
+			name: "unknown route",
+			content: `
+multiplexer:
+  collectors:
+    - name: tap
+      dnstap:
+        listen-port: 6000
+  loggers:
+    - name: console
+      stdout:
+        mode: text
+  routes:
+    - from: [ tap ]
+      to: [ file ]
+`,
+			want: "logger [file] does not exist",
+		},
+		{
+			name: "label on logger",
+			content: `
+multiplexer:
+  collectors:
+    - name: tap
+      dnstap:
+        listen-port: 6000
+  loggers:
+    - name: console
+      stdout:
+        mode: text
+      label: console
+  routes:
+    - from: [ tap ]
+      to: [ console ]
+`,
+			want: "label is only supported by the collectors",
+		},
+		{
+			name: "invalid shutdown timeout",
+			content: `
+global:
+  shutdown-timeout: 0
+`,
+			want: "shutdown-timeout must be positive",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckConfig(writeTestConfig(t, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error with %s expected, got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestConfig_CollectorLabel(t *testing.T) {
+	config := &Config{}
+	config.SetDefault()
+
+	// two dnstap collectors with their own port and label
+	for _, item := range []MultiplexInOut{
+		{Name: "tap-a", Label: "dc1", Params: map[string]interface{}{"dnstap": map[string]interface{}{"listen-port": 6000}}},
+		{Name: "tap-b", Label: "dc2", Params: map[string]interface{}{"dnstap": map[string]interface{}{"listen-port": 6001}}},
+	} {
+		subcfg, err := GetCollectorConfig(config, item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subcfg.Collectors.Label != item.Label || !subcfg.Collectors.Dnstap.Enable {
+			t.Errorf("%s - unexpected label %s", item.Name, subcfg.Collectors.Label)
+		}
+	}
+}
@@ -10,22 +10,65 @@ const (
 	ANY_IP       = "0.0.0.0"
 	HTTP_OK      = "HTTP/1.1 200 OK\r\n\r\n"
 
+	// version of the json schema of the messages, increased when a field is renamed,
+	// moved or removed, the new fields are added without change of version
+	SCHEMA_VERSION = 1
+
 	MODE_TEXT     = "text"
 	MODE_JSON     = "json"
 	MODE_FLATJSON = "flat-json"
 	MODE_PCAP     = "pcap"
 	MODE_DNSTAP   = "dnstap"
+	MODE_MSGPACK  = "msgpack"
+	MODE_CBOR     = "cbor"
+	MODE_PROTOBUF = "protobuf"
+	MODE_AVRO     = "avro"
+	MODE_CSV      = "csv"
+	MODE_HTML     = "html"
+	MODE_PARQUET  = "parquet"
+
+	FRAMING_DELIMITER = "delimiter"
+	FRAMING_LENGTH    = "length-prefixed"
+
+	COMPRESS_GZIP = "gzip"
+	COMPRESS_ZSTD = "zstd"
 
+	OVERFLOW_BLOCK       = "block"
+	OVERFLOW_DROP_NEWEST = "drop-newest"
+	OVERFLOW_DROP_OLDEST = "drop-oldest"
+
+	DNS_RCODE_NOERROR  = "NOERROR"
 	DNS_RCODE_NXDOMAIN = "NXDOMAIN"
 	DNS_RCODE_SERVFAIL = "SERVFAIL"
 	DNS_RCODE_TIMEOUT  = "TIMEOUT"
 
+	ENCODING_HEX    = "hex"
+	ENCODING_BASE64 = "base64"
+
+	TRANSFER_RECORDS = "records"
+	TRANSFER_SUMMARY = "summary"
+
 	DNSTAP_OPERATION_QUERY = "QUERY"
 	DNSTAP_OPERATION_REPLY = "REPLY"
 
 	DNSTAP_CLIENT_RESPONSE = "CLIENT_RESPONSE"
 	DNSTAP_CLIENT_QUERY    = "CLIENT_QUERY"
 
+	// operation of the records sent by the statistics transformer
+	OPERATION_STATISTICS = "STATISTICS"
+	// operations of the synthetic records sent by the prober collector
+	OPERATION_PROBE_QUERY    = "PROBE_QUERY"
+	OPERATION_PROBE_RESPONSE = "PROBE_RESPONSE"
+	// operation of the records sent by the alerting transformer
+	OPERATION_ALERT = "ALERT"
+	// operation of the records emitted by the scripts
+	OPERATION_SCRIPT = "SCRIPT"
+	// operation of the internal events sent by the monitoring collector
+	OPERATION_EVENT = "EVENT"
+
+	ALERT_GROUP_BY_DOMAIN = "domain"
+	ALERT_GROUP_BY_CLIENT = "client"
+
 	PROTO_INET  = "INET"
 	PROTO_INET6 = "INET6"
 	PROTO_IPV6  = "IPv6"
@@ -35,6 +78,7 @@ const (
 	PROTO_TCP = "TCP"
 	PROTO_DOT = "DOT"
 	PROTO_DOH = "DOH"
+	PROTO_DOQ = "DOQ"
 
 	SOCKET_TCP  = "tcp"
 	SOCKET_UDP  = "udp"
@@ -44,6 +88,51 @@ const (
 	TLS_v11 = "1.1"
 	TLS_v12 = "1.2"
 	TLS_v13 = "1.3"
+
+	RPZ_ACTION_NXDOMAIN = "nxdomain"
+	RPZ_ACTION_NODATA   = "nodata"
+	RPZ_ACTION_PASSTHRU = "passthru"
+	RPZ_ACTION_DROP     = "drop"
+
+	ACCOUNTING_BY_CLIENT   = "client"
+	ACCOUNTING_BY_IDENTITY = "identity"
+	ACCOUNTING_BY_TAG      = "tag"
+
+	TENANT_BY_CLIENT   = "client"
+	TENANT_BY_IDENTITY = "identity"
+	TENANT_BY_TAG      = "tag"
+
+	IDENTITY_FALLBACK_COLLECTOR   = "collector"
+	IDENTITY_FALLBACK_RESPONSE_IP = "response-ip"
+
+	ASN_MATCH_CLIENT = "client"
+	ASN_MATCH_SERVER = "server"
+	ASN_MATCH_ANSWER = "answer"
+
+	DNSSEC_MATCH         = "match"
+	DNSSEC_MISMATCH      = "mismatch"
+	DNSSEC_BOGUS         = "bogus"
+	DNSSEC_INDETERMINATE = "indeterminate"
+	DNSSEC_ERROR         = "error"
+
+	THREAT_FEED_DOMAINS = "domains"
+	THREAT_FEED_HOSTS   = "hosts"
+
+	SASL_MECHANISM_PLAIN = "PLAIN"
+	SASL_MECHANISM_SCRAM = "SCRAM-SHA-512"
+
+	PARTITION_KEY_QNAME   = "qname"
+	PARTITION_KEY_QUERYIP = "queryip"
+
+	REDIS_MODE_PUBSUB = "pubsub"
+	REDIS_MODE_STREAM = "stream"
+
+	REPORT_DAILY  = "daily"
+	REPORT_WEEKLY = "weekly"
+
+	WEBHOOK_FORMAT_GENERIC   = "generic"
+	WEBHOOK_FORMAT_SLACK     = "slack"
+	WEBHOOK_FORMAT_PAGERDUTY = "pagerduty"
 )
 
 var (
@@ -54,6 +143,13 @@ var (
 		TLS_v13: tls.VersionTLS13,
 	}
 
+	RPZ_ACTIONS = map[string]string{
+		RPZ_ACTION_NXDOMAIN: ".",
+		RPZ_ACTION_NODATA:   "*.",
+		RPZ_ACTION_PASSTHRU: "rpz-passthru.",
+		RPZ_ACTION_DROP:     "rpz-drop.",
+	}
+
 	IP_VERSION = map[string]string{
 		PROTO_INET:  PROTO_IPV4,
 		PROTO_INET6: PROTO_IPV6,
@@ -0,0 +1,154 @@
+package dnsutils
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchWorker is implemented by the workers able to receive slices of messages
+type BatchWorker interface {
+	BatchChannel() chan []DnsMessage
+}
+
+// IsBatchWorker returns true if the worker receives slices of messages when batching is enabled
+func IsBatchWorker(w Worker, batchSize int) bool {
+	if batchSize <= 1 {
+		return false
+	}
+	_, ok := w.(BatchWorker)
+	return ok
+}
+
+// GetChannels returns the message channels of the workers without batch support
+func GetChannels(workers []Worker, batchSize int) []chan DnsMessage {
+	channels := []chan DnsMessage{}
+	for _, w := range workers {
+		if !IsBatchWorker(w, batchSize) {
+			channels = append(channels, w.Channel())
+		}
+	}
+	return channels
+}
+
+// GetBatchChannels returns the batch channels of the workers with batch support
+func GetBatchChannels(workers []Worker, batchSize int) []chan []DnsMessage {
+	channels := []chan []DnsMessage{}
+	for _, w := range workers {
+		if IsBatchWorker(w, batchSize) {
+			channels = append(channels, w.(BatchWorker).BatchChannel())
+		}
+	}
+	return channels
+}
+
+// Dispatcher sends messages one by one to the channels and by slices to the batch channels,
+// a batch is sent when full or when the flush interval is reached
+type Dispatcher struct {
+	sync.Mutex
+	channels      []chan DnsMessage
+	batchChannels []chan []DnsMessage
+	batch         []DnsMessage
+	batchSize     int
+	flushInterval time.Duration
+	input         chan DnsMessage
+	stop          chan bool
+	done          chan bool
+}
+
+func NewDispatcher(channels []chan DnsMessage, batchChannels []chan []DnsMessage, batchSize int, flushInterval time.Duration) *Dispatcher {
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	d := &Dispatcher{
+		channels:      channels,
+		batchChannels: batchChannels,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		batch:         make([]DnsMessage, 0, batchSize),
+		input:         make(chan DnsMessage, 512),
+		stop:          make(chan bool),
+		done:          make(chan bool),
+	}
+	if len(batchChannels) > 0 {
+		go d.Run()
+	}
+	return d
+}
+
+// Channels returns the channels to use for the messages generated outside of the
+// processing loop (by the transformers for example)
+func (d *Dispatcher) Channels() []chan DnsMessage {
+	if len(d.batchChannels) == 0 {
+		return d.channels
+	}
+	return []chan DnsMessage{d.input}
+}
+
+func (d *Dispatcher) Dispatch(dm DnsMessage) {
+	for i := range d.channels {
+		d.channels[i] <- dm
+	}
+
+	if len(d.batchChannels) == 0 {
+		return
+	}
+
+	d.Lock()
+	d.batch = append(d.batch, dm)
+	full := len(d.batch) >= d.batchSize
+	d.Unlock()
+
+	if full {
+		d.Flush()
+	}
+}
+
+// Flush sends the pending messages to the batch channels
+func (d *Dispatcher) Flush() {
+	d.Lock()
+	if len(d.batch) == 0 {
+		d.Unlock()
+		return
+	}
+	batch := d.batch
+	d.batch = make([]DnsMessage, 0, d.batchSize)
+	d.Unlock()
+
+	// each worker gets its own copy, messages are updated by the outgoing transformers
+	for i := range d.batchChannels {
+		if i == 0 {
+			d.batchChannels[i] <- batch
+			continue
+		}
+		copied := make([]DnsMessage, len(batch))
+		copy(copied, batch)
+		d.batchChannels[i] <- copied
+	}
+}
+
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			d.Flush()
+			d.done <- true
+			return
+		case dm := <-d.input:
+			d.Dispatch(dm)
+		case <-ticker.C:
+			d.Flush()
+		}
+	}
+}
+
+// Stop sends the pending messages and stops the flush routine
+func (d *Dispatcher) Stop() {
+	if len(d.batchChannels) == 0 {
+		return
+	}
+	d.stop <- true
+	<-d.done
+}
@@ -0,0 +1,55 @@
+package dnsutils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDispatcher_Batch(t *testing.T) {
+	single := make(chan DnsMessage, 10)
+	batch := make(chan []DnsMessage, 10)
+
+	d := NewDispatcher([]chan DnsMessage{single}, []chan []DnsMessage{batch}, 3, time.Hour)
+
+	for i := 0; i < 4; i++ {
+		d.Dispatch(GetFakeDnsMessage())
+	}
+
+	// messages are sent one by one to the channels
+	if len(single) != 4 {
+		t.Errorf("4 messages expected, got %d", len(single))
+	}
+
+	// and by slices to the batch channels
+	if len(batch) != 1 {
+		t.Fatalf("one batch expected, got %d", len(batch))
+	}
+	if b := <-batch; len(b) != 3 {
+		t.Errorf("batch of 3 messages expected, got %d", len(b))
+	}
+
+	// the pending messages are sent on stop
+	d.Stop()
+	if b := <-batch; len(b) != 1 {
+		t.Errorf("batch of 1 message expected, got %d", len(b))
+	}
+}
+
+func TestDispatcher_FlushInterval(t *testing.T) {
+	batch := make(chan []DnsMessage, 10)
+
+	d := NewDispatcher([]chan DnsMessage{}, []chan []DnsMessage{batch}, 100, 100*time.Millisecond)
+	defer d.Stop()
+
+	// messages generated outside of the processing loop are dispatched too
+	d.Channels()[0] <- GetFakeDnsMessage()
+
+	select {
+	case b := <-batch:
+		if len(b) != 1 {
+			t.Errorf("batch of 1 message expected, got %d", len(b))
+		}
+	case <-time.After(time.Second):
+		t.Errorf("batch not flushed")
+	}
+}
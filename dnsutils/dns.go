@@ -1,11 +1,16 @@
 package dnsutils
 
 import (
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const DnsLen = 12
@@ -61,7 +66,7 @@ var (
 		49:    "DHCID",
 		50:    "NSEC3",
 		51:    "NSEC3PARAM",
-		52:    "TSLA",
+		52:    "TLSA",
 		53:    "SMIMEA",
 		55:    "HIP",
 		56:    "NINFO",
@@ -260,8 +265,12 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 	if header.Ad == 1 {
 		dm.DNS.Flags.AD = true
 	}
+	if header.Cd == 1 {
+		dm.DNS.Flags.CD = true
+	}
 
-	var payload_offset int
+	// the answers follow the header in the messages without question (zone transfers)
+	payload_offset := DnsLen
 	// decode DNS question
 	if header.Qdcount > 0 {
 		dns_qname, dns_rrtype, offsetrr, err := DecodeQuestion(header.Qdcount, dm.DNS.Payload)
@@ -333,9 +342,125 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 			return &decodingError{part: "edns options", err: err}
 		}
 	}
+
+	dm.DNS.Dnssec = GetDnssecSummary(dm)
 	return nil
 }
 
+// GetDnssecSummary returns the dnssec records found in the answer and authority sections,
+// nil if the message has no dnssec record and the DO bit is not set
+func GetDnssecSummary(dm *DnsMessage) *DnssecSummary {
+	summary := &DnssecSummary{Algorithms: []int{}, Denial: "-"}
+	found := false
+
+	rrs := append(append([]DnsAnswer{}, dm.DNS.DnsRRs.Answers...), dm.DNS.DnsRRs.Nameservers...)
+	for _, rr := range rrs {
+		switch rr.Rdatatype {
+		case "RRSIG":
+			summary.Signed = true
+			summary.Rrsigs++
+			// the algorithm is the second field of the rrsig
+			fields := strings.Fields(rr.Rdata)
+			if len(fields) < 2 {
+				continue
+			}
+			if alg, err := strconv.Atoi(fields[1]); err == nil {
+				known := false
+				for _, a := range summary.Algorithms {
+					known = known || a == alg
+				}
+				if !known {
+					summary.Algorithms = append(summary.Algorithms, alg)
+				}
+			}
+		case "NSEC":
+			summary.Denial = "nsec"
+		case "NSEC3":
+			summary.Denial = "nsec3"
+		case "DNSKEY", "DS":
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if !found && dm.EDNS.Do == 0 {
+		return nil
+	}
+	return summary
+}
+
+// SplitTcpPayload returns the messages of a payload with the dns over tcp format, each message
+// is prefixed by its length (zone transfers for example). Nil is returned for a single message
+// without prefix.
+func SplitTcpPayload(payload []byte) [][]byte {
+	messages := [][]byte{}
+	for offset := 0; offset < len(payload); {
+		if offset+2 > len(payload) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(payload[offset : offset+2]))
+		offset += 2
+		if size < DnsLen || offset+size > len(payload) {
+			return nil
+		}
+		messages = append(messages, payload[offset:offset+size])
+		offset += size
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	// the id of a message without prefix can match its length, the payload is
+	// split only if it's not a valid message
+	dm := DnsMessage{}
+	dm.DNS.Payload = payload
+	if header, err := DecodeDns(payload); err == nil && DecodePayload(&dm, &header, nil) == nil {
+		return nil
+	}
+	return messages
+}
+
+// SummarizeTransfer returns one message for the messages of a zone transfer, the records
+// are replaced by their number by type and the serials of the SOA records
+func SummarizeTransfer(messages []DnsMessage) DnsMessage {
+	summary := messages[0]
+	summary.DNS.Length = 0
+	transfer := &DnsTransfer{Messages: len(messages), Serials: []int{}, Types: make(map[string]int)}
+
+	for _, dm := range messages {
+		summary.DNS.Length += dm.DNS.Length
+		if dm.DNS.MalformedPacket {
+			summary.DNS.MalformedPacket = true
+		}
+		for _, rr := range dm.DNS.DnsRRs.Answers {
+			transfer.Records++
+			transfer.Types[rr.Rdatatype]++
+			if rr.Rdatatype != "SOA" {
+				continue
+			}
+			// the serial is the third field of the soa, the repeated serials are ignored
+			// (the soa at the start and at the end of an axfr)
+			fields := strings.Fields(rr.Rdata)
+			if len(fields) < 3 {
+				continue
+			}
+			serial, err := strconv.Atoi(fields[2])
+			if err != nil {
+				continue
+			}
+			if n := len(transfer.Serials); n == 0 || transfer.Serials[n-1] != serial {
+				transfer.Serials = append(transfer.Serials, serial)
+			}
+		}
+	}
+
+	summary.DNS.DnsRRs = DnsRRs{Answers: []DnsAnswer{}, Nameservers: []DnsAnswer{}, Records: []DnsAnswer{}}
+	summary.DNS.Dnssec = nil
+	summary.Transfer = transfer
+	return summary
+}
+
 /*
 DNS QUESTION
 +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
@@ -556,6 +681,24 @@ func ParseRdata(rdatatype string, rdata []byte, payload []byte, rdata_offset int
 		ret, err = ParsePTR(rdata_offset, payload)
 	case "SOA":
 		ret, err = ParseSOA(rdata_offset, payload)
+	case "RRSIG":
+		ret, err = ParseRRSIG(rdata_offset, payload)
+	case "DNSKEY":
+		ret, err = ParseDNSKEY(rdata)
+	case "DS":
+		ret, err = ParseDS(rdata)
+	case "NSEC":
+		ret, err = ParseNSEC(rdata_offset, payload)
+	case "NSEC3":
+		ret, err = ParseNSEC3(rdata)
+	case "SVCB", "HTTPS":
+		ret, err = ParseSVCB(rdata_offset, payload)
+	case "CAA":
+		ret, err = ParseCAA(rdata)
+	case "TLSA":
+		ret, err = ParseTLSA(rdata)
+	case "NAPTR":
+		ret, err = ParseNAPTR(rdata_offset, payload)
 	default:
 		ret = "-"
 		err = nil
@@ -770,3 +913,391 @@ func ParsePTR(rdata_offset int, payload []byte) (string, error) {
 	}
 	return ptr, err
 }
+
+/*
+RRSIG, the signature is not decoded
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|         TYPE COVERED  | ALGORITHM |  LABELS   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                 ORIGINAL TTL                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             SIGNATURE EXPIRATION              |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             SIGNATURE INCEPTION               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|            KEY TAG    /     SIGNER NAME       /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   SIGNATURE                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseRRSIG(rdata_offset int, payload []byte) (string, error) {
+	if len(payload) < rdata_offset+18 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	rdata := payload[rdata_offset : rdata_offset+18]
+
+	typeCovered := RdatatypeToString(int(binary.BigEndian.Uint16(rdata[0:2])))
+	algorithm := rdata[2]
+	labels := rdata[3]
+	originalTtl := binary.BigEndian.Uint32(rdata[4:8])
+	expiration := binary.BigEndian.Uint32(rdata[8:12])
+	inception := binary.BigEndian.Uint32(rdata[12:16])
+	keyTag := binary.BigEndian.Uint16(rdata[16:18])
+
+	signer, _, err := ParseLabels(rdata_offset+18, payload)
+	if err != nil {
+		return "", err
+	}
+
+	rrsig := fmt.Sprintf("%s %d %d %d %s %s %d %s", typeCovered, algorithm, labels, originalTtl,
+		formatDnssecTime(expiration), formatDnssecTime(inception), keyTag, signer)
+	return rrsig, nil
+}
+
+// formatDnssecTime returns the time in the YYYYMMDDHHmmSS format of the rrsig presentation
+func formatDnssecTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
+/*
+DNSKEY, the public key is not decoded but replaced by the key tag
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|              FLAGS            |  PROTOCOL |  ALGORITHM |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  PUBLIC KEY                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNSKEY(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	flags := binary.BigEndian.Uint16(rdata[0:2])
+	protocol := rdata[2]
+	algorithm := rdata[3]
+
+	dnskey := fmt.Sprintf("%d %d %d %d", flags, protocol, algorithm, KeyTag(rdata))
+	return dnskey, nil
+}
+
+// KeyTag computes the key tag of a dnskey rdata, see RFC 4034 appendix B
+func KeyTag(rdata []byte) uint16 {
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac & 0xFFFF)
+}
+
+/*
+DS
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|           KEY TAG     | ALGORITHM | DIGEST TYPE |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    DIGEST                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDS(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	keyTag := binary.BigEndian.Uint16(rdata[0:2])
+	algorithm := rdata[2]
+	digestType := rdata[3]
+	digest := strings.ToUpper(hex.EncodeToString(rdata[4:]))
+
+	ds := fmt.Sprintf("%d %d %d %s", keyTag, algorithm, digestType, digest)
+	return ds, nil
+}
+
+/*
+NSEC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/               NEXT DOMAIN NAME                /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                TYPE BIT MAPS                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC(rdata_offset int, payload []byte) (string, error) {
+	next, offset, err := ParseLabels(rdata_offset, payload)
+	if err != nil {
+		return "", err
+	}
+	types, err := ParseTypeBitMaps(payload[offset:])
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(next + " " + types), nil
+}
+
+/*
+NSEC3
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   HASH ALG    |   FLAGS   |      ITERATIONS   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  SALT LENGTH  /          SALT                 /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  HASH LENGTH  /     NEXT HASHED OWNER NAME    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                TYPE BIT MAPS                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	hashAlg := rdata[0]
+	flags := rdata[1]
+	iterations := binary.BigEndian.Uint16(rdata[2:4])
+
+	saltLength := int(rdata[4])
+	if len(rdata) < 5+saltLength+1 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	salt := "-"
+	if saltLength > 0 {
+		salt = strings.ToUpper(hex.EncodeToString(rdata[5 : 5+saltLength]))
+	}
+
+	offset := 5 + saltLength
+	hashLength := int(rdata[offset])
+	if len(rdata) < offset+1+hashLength {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	next := base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(rdata[offset+1 : offset+1+hashLength])
+
+	types, err := ParseTypeBitMaps(rdata[offset+1+hashLength:])
+	if err != nil {
+		return "", err
+	}
+
+	nsec3 := fmt.Sprintf("%d %d %d %s %s %s", hashAlg, flags, iterations, salt, next, types)
+	return strings.TrimSpace(nsec3), nil
+}
+
+// ParseTypeBitMaps returns the types of the nsec and nsec3 bitmaps, each window
+// starts with its number and the length of its bitmap
+func ParseTypeBitMaps(bitmaps []byte) (string, error) {
+	types := []string{}
+	for len(bitmaps) > 0 {
+		if len(bitmaps) < 2 {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		window := int(bitmaps[0])
+		length := int(bitmaps[1])
+		if length == 0 || length > 32 || len(bitmaps) < 2+length {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		for i, b := range bitmaps[2 : 2+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, RdatatypeToString(window*256+i*8+bit))
+				}
+			}
+		}
+		bitmaps = bitmaps[2+length:]
+	}
+	return strings.Join(types, " "), nil
+}
+
+/*
+SVCB and HTTPS, the target name is never compressed
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                  SVCPRIORITY                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  TARGETNAME                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  SVCPARAMS                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseSVCB(rdata_offset int, payload []byte) (string, error) {
+	if len(payload) < rdata_offset+3 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	priority := binary.BigEndian.Uint16(payload[rdata_offset : rdata_offset+2])
+
+	target, offset, err := ParseLabels(rdata_offset+2, payload)
+	if err != nil {
+		return "", err
+	}
+	if len(target) == 0 {
+		target = "."
+	}
+
+	svcb := []string{strconv.Itoa(int(priority)), target}
+	params := payload[offset:]
+	for len(params) > 0 {
+		if len(params) < 4 {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		key := binary.BigEndian.Uint16(params[0:2])
+		length := int(binary.BigEndian.Uint16(params[2:4]))
+		if len(params) < 4+length {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		param, err := ParseSvcParam(key, params[4:4+length])
+		if err != nil {
+			return "", err
+		}
+		svcb = append(svcb, param)
+		params = params[4+length:]
+	}
+	return strings.Join(svcb, " "), nil
+}
+
+// ParseSvcParam returns the key=value presentation of a svcb parameter, see RFC 9460
+func ParseSvcParam(key uint16, value []byte) (string, error) {
+	switch key {
+	case 0:
+		if len(value)%2 != 0 {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		keys := []string{}
+		for i := 0; i < len(value); i += 2 {
+			keys = append(keys, SvcParamKeyToString(binary.BigEndian.Uint16(value[i:i+2])))
+		}
+		return "mandatory=" + strings.Join(keys, ","), nil
+	case 1:
+		alpn := []string{}
+		for len(value) > 0 {
+			length := int(value[0])
+			if len(value) < 1+length {
+				return "", ErrDecodeDnsAnswerRdataTooShort
+			}
+			alpn = append(alpn, string(value[1:1+length]))
+			value = value[1+length:]
+		}
+		return "alpn=" + strings.Join(alpn, ","), nil
+	case 2:
+		return "no-default-alpn", nil
+	case 3:
+		if len(value) != 2 {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		return fmt.Sprintf("port=%d", binary.BigEndian.Uint16(value)), nil
+	case 4, 6:
+		size := net.IPv4len
+		if key == 6 {
+			size = net.IPv6len
+		}
+		if len(value)%size != 0 {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		hints := []string{}
+		for i := 0; i < len(value); i += size {
+			hints = append(hints, net.IP(value[i:i+size]).String())
+		}
+		return SvcParamKeyToString(key) + "=" + strings.Join(hints, ","), nil
+	case 5:
+		return "ech=" + base64.StdEncoding.EncodeToString(value), nil
+	default:
+		return SvcParamKeyToString(key) + "=" + hex.EncodeToString(value), nil
+	}
+}
+
+func SvcParamKeyToString(key uint16) string {
+	keys := []string{"mandatory", "alpn", "no-default-alpn", "port", "ipv4hint", "ech", "ipv6hint"}
+	if int(key) < len(keys) {
+		return keys[key]
+	}
+	return fmt.Sprintf("key%d", key)
+}
+
+/*
+CAA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     FLAGS     |   TAG LENGTH  |     TAG       /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    VALUE                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseCAA(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	flags := rdata[0]
+	length := int(rdata[1])
+	if len(rdata) < 2+length {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	tag := string(rdata[2 : 2+length])
+	value := string(rdata[2+length:])
+
+	caa := fmt.Sprintf("%d %s %q", flags, tag, value)
+	return caa, nil
+}
+
+/*
+TLSA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  CERT USAGE   |   SELECTOR    | MATCHING TYPE |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/         CERTIFICATE ASSOCIATION DATA          /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseTLSA(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	usage := rdata[0]
+	selector := rdata[1]
+	matchingType := rdata[2]
+	data := hex.EncodeToString(rdata[3:])
+
+	tlsa := fmt.Sprintf("%d %d %d %s", usage, selector, matchingType, data)
+	return tlsa, nil
+}
+
+/*
+NAPTR
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                     ORDER                     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   PREFERENCE                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     FLAGS                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   SERVICES                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    REGEXP                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  REPLACEMENT                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNAPTR(rdata_offset int, payload []byte) (string, error) {
+	if len(payload) < rdata_offset+4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	order := binary.BigEndian.Uint16(payload[rdata_offset : rdata_offset+2])
+	preference := binary.BigEndian.Uint16(payload[rdata_offset+2 : rdata_offset+4])
+
+	// flags, services and regexp are character strings
+	offset := rdata_offset + 4
+	fields := []string{}
+	for i := 0; i < 3; i++ {
+		if len(payload) < offset+1 {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		length := int(payload[offset])
+		if len(payload) < offset+1+length {
+			return "", ErrDecodeDnsAnswerRdataTooShort
+		}
+		fields = append(fields, strconv.Quote(string(payload[offset+1:offset+1+length])))
+		offset += 1 + length
+	}
+
+	replacement, _, err := ParseLabels(offset, payload)
+	if err != nil {
+		return "", err
+	}
+	if len(replacement) == 0 {
+		replacement = "."
+	}
+
+	naptr := fmt.Sprintf("%d %d %s %s", order, preference, strings.Join(fields, " "), replacement)
+	return naptr, nil
+}
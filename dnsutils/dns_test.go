@@ -714,6 +714,235 @@ func TestDecodeRdataSOA(t *testing.T) {
 	}
 }
 
+func TestDecodeRdataDnssec(t *testing.T) {
+	fqdn := TEST_QNAME
+
+	testcases := []struct {
+		rdatatype string
+		rr        string
+		rdata     string
+	}{
+		{
+			rdatatype: "RRSIG",
+			rr:        "A 13 2 300 20231120000000 20231030000000 12345 dnscollector.dev. c2lnbmF0dXJl",
+			rdata:     "A 13 2 300 20231120000000 20231030000000 12345 dnscollector.dev",
+		},
+		{
+			rdatatype: "DNSKEY",
+			rr:        "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+			rdata:     "257 3 13 2371",
+		},
+		{
+			rdatatype: "DS",
+			rr:        "20326 8 2 e06d44b80b8f1d39a95c0b0d7c65d08458e880409bbc683457104237c7f8ec8d",
+			rdata:     "20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+		},
+		{
+			rdatatype: "NSEC",
+			rr:        "www.dnscollector.dev. A NS SOA RRSIG NSEC DNSKEY",
+			rdata:     "www.dnscollector.dev A NS SOA RRSIG NSEC DNSKEY",
+		},
+		{
+			rdatatype: "NSEC3",
+			rr:        "1 1 12 aabbccdd 2t7b4g4vsa5smi47k61mv5bv1a22bojr A RRSIG",
+			rdata:     "1 1 12 AABBCCDD 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR A RRSIG",
+		},
+		{
+			rdatatype: "NSEC3",
+			rr:        "1 0 0 - 2t7b4g4vsa5smi47k61mv5bv1a22bojr",
+			rdata:     "1 0 0 - 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.rdatatype, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion(fqdn, dns.TypeA)
+
+			rr1, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rdatatype, tc.rr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dm.Answer = append(dm.Answer, rr1)
+
+			payload, _ := dm.Pack()
+
+			_, _, offset_rr, _ := DecodeQuestion(1, payload)
+			answer, _, err := DecodeAnswer(len(dm.Answer), offset_rr, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if answer[0].Rdata != tc.rdata {
+				t.Errorf("invalid decode for rdata %s, want %s, got: %s", tc.rdatatype, tc.rdata, answer[0].Rdata)
+			}
+		})
+	}
+}
+
+func TestDecodeRdataModernTypes(t *testing.T) {
+	fqdn := TEST_QNAME
+
+	testcases := []struct {
+		name      string
+		rdatatype string
+		rr        string
+		rdata     string
+	}{
+		{
+			name:      "https",
+			rdatatype: "HTTPS",
+			rr:        `1 . alpn="h3,h2" port=8443 ipv4hint=192.0.2.1,192.0.2.2 ipv6hint=2001:db8::1`,
+			rdata:     "1 . alpn=h3,h2 port=8443 ipv4hint=192.0.2.1,192.0.2.2 ipv6hint=2001:db8::1",
+		},
+		{
+			name:      "svcb",
+			rdatatype: "SVCB",
+			rr:        `16 svc.dnscollector.dev. mandatory=alpn alpn=dot no-default-alpn ech=AEP+DQA=`,
+			rdata:     "16 svc.dnscollector.dev mandatory=alpn alpn=dot no-default-alpn ech=AEP+DQA=",
+		},
+		{
+			name:      "svcb-alias",
+			rdatatype: "SVCB",
+			rr:        `0 svc.dnscollector.dev.`,
+			rdata:     "0 svc.dnscollector.dev",
+		},
+		{
+			name:      "caa",
+			rdatatype: "CAA",
+			rr:        `0 issue "letsencrypt.org"`,
+			rdata:     `0 issue "letsencrypt.org"`,
+		},
+		{
+			name:      "tlsa",
+			rdatatype: "TLSA",
+			rr:        `3 1 1 0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6`,
+			rdata:     "3 1 1 0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6",
+		},
+		{
+			name:      "naptr",
+			rdatatype: "NAPTR",
+			rr:        `100 10 "S" "SIP+D2U" "" _sip._udp.dnscollector.dev.`,
+			rdata:     `100 10 "S" "SIP+D2U" "" _sip._udp.dnscollector.dev`,
+		},
+		{
+			name:      "naptr-regexp",
+			rdatatype: "NAPTR",
+			rr:        `100 50 "u" "E2U+sip" "!^.*$!sip:info@dnscollector.dev!" .`,
+			rdata:     `100 50 "u" "E2U+sip" "!^.*$!sip:info@dnscollector.dev!" .`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion(fqdn, dns.TypeA)
+
+			rr1, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rdatatype, tc.rr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dm.Answer = append(dm.Answer, rr1)
+
+			payload, _ := dm.Pack()
+
+			_, _, offset_rr, _ := DecodeQuestion(1, payload)
+			answer, _, err := DecodeAnswer(len(dm.Answer), offset_rr, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if answer[0].Rdata != tc.rdata {
+				t.Errorf("invalid decode for rdata %s, want %s, got: %s", tc.rdatatype, tc.rdata, answer[0].Rdata)
+			}
+		})
+	}
+}
+
+func TestDecodeRdataSVCB_Short(t *testing.T) {
+	// priority 1, root target, alpn param with a length larger than the rdata
+	rdata := []byte{0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x08, 0x02, 'h', '2'}
+	if _, err := ParseSVCB(0, rdata); !errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) {
+		t.Errorf("bad error returned: %v", err)
+	}
+}
+
+func TestDecodeRdataDNSKEY_KeyTag(t *testing.T) {
+	rr, _ := dns.NewRR("dnscollector.dev. DNSKEY 257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU=")
+	dnskey := rr.(*dns.DNSKEY)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("dnscollector.dev.", dns.TypeDNSKEY)
+	msg.Answer = append(msg.Answer, rr)
+	payload, _ := msg.Pack()
+
+	_, _, offset_rr, _ := DecodeQuestion(1, payload)
+	answer, _, _ := DecodeAnswer(len(msg.Answer), offset_rr, payload)
+
+	rdata := fmt.Sprintf("257 3 8 %d", dnskey.KeyTag())
+	if answer[0].Rdata != rdata {
+		t.Errorf("invalid key tag, want %s, got: %s", rdata, answer[0].Rdata)
+	}
+}
+
+func TestDecodePayload_DnssecSummary(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(TEST_QNAME, dns.TypeA)
+	msg.Response = true
+	msg.CheckingDisabled = true
+	msg.SetEdns0(4096, true)
+
+	rr1, _ := dns.NewRR(fmt.Sprintf("%s A 127.0.0.1", TEST_QNAME))
+	rr2, _ := dns.NewRR(fmt.Sprintf("%s RRSIG A 13 2 300 20231120000000 20231030000000 12345 dnscollector.dev. c2lnbmF0dXJl", TEST_QNAME))
+	rr3, _ := dns.NewRR(fmt.Sprintf("%s RRSIG A 8 2 300 20231120000000 20231030000000 54321 dnscollector.dev. c2lnbmF0dXJl", TEST_QNAME))
+	msg.Answer = append(msg.Answer, rr1, rr2, rr3)
+	rr4, _ := dns.NewRR("dnscollector.dev. NSEC3 1 0 0 - 2t7b4g4vsa5smi47k61mv5bv1a22bojr A RRSIG")
+	msg.Ns = append(msg.Ns, rr4)
+
+	payload, _ := msg.Pack()
+
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+	header, _ := DecodeDns(payload)
+	if err := DecodePayload(&dm, &header, GetFakeConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !dm.DNS.Flags.CD {
+		t.Errorf("cd flag expected")
+	}
+	if dm.DNS.Dnssec == nil {
+		t.Fatalf("dnssec summary expected")
+	}
+	if !dm.DNS.Dnssec.Signed || dm.DNS.Dnssec.Rrsigs != 2 || dm.DNS.Dnssec.Denial != "nsec3" {
+		t.Errorf("invalid dnssec summary: %+v", dm.DNS.Dnssec)
+	}
+	if len(dm.DNS.Dnssec.Algorithms) != 2 || dm.DNS.Dnssec.Algorithms[0] != 13 || dm.DNS.Dnssec.Algorithms[1] != 8 {
+		t.Errorf("invalid dnssec algorithms: %v", dm.DNS.Dnssec.Algorithms)
+	}
+}
+
+func TestDecodePayload_NoDnssec(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(TEST_QNAME, dns.TypeA)
+	payload, _ := msg.Pack()
+
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+	header, _ := DecodeDns(payload)
+	if err := DecodePayload(&dm, &header, GetFakeConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	if dm.DNS.Dnssec != nil {
+		t.Errorf("no dnssec summary expected: %+v", dm.DNS.Dnssec)
+	}
+}
+
 func TestDecodeRdataSOA_Short(t *testing.T) {
 	payload := []byte{
 		// header
@@ -2270,3 +2499,51 @@ func TestDecodePayload_Truncated(t *testing.T) {
 	}
 
 }
+
+func TestSplitTcpPayload(t *testing.T) {
+	dm := new(dns.Msg)
+	dm.SetQuestion(TEST_QNAME, dns.TypeA)
+	payload, _ := dm.Pack()
+
+	// a single message without prefix, even with an id matching its length
+	dm.Id = uint16(len(payload) - 2)
+	payload, _ = dm.Pack()
+	if messages := SplitTcpPayload(payload); messages != nil {
+		t.Errorf("message without prefix split: %v", messages)
+	}
+
+	// two messages prefixed by their length
+	stacked := []byte{}
+	for i := 0; i < 2; i++ {
+		stacked = append(stacked, byte(len(payload)>>8), byte(len(payload)))
+		stacked = append(stacked, payload...)
+	}
+	messages := SplitTcpPayload(stacked)
+	if len(messages) != 2 || len(messages[1]) != len(payload) {
+		t.Fatalf("two messages expected, got %d", len(messages))
+	}
+
+	// truncated
+	if messages := SplitTcpPayload(stacked[:len(stacked)-1]); messages != nil {
+		t.Errorf("truncated payload split: %v", messages)
+	}
+}
+
+func TestDecodePayload_AnswerWithoutQuestion(t *testing.T) {
+	// the next messages of a zone transfer have no question
+	dm := new(dns.Msg)
+	dm.Response = true
+	rr, _ := dns.NewRR(fmt.Sprintf("%s 3600 IN A 127.0.0.1", TEST_QNAME))
+	dm.Answer = append(dm.Answer, rr)
+	payload, _ := dm.Pack()
+
+	m := DnsMessage{}
+	m.DNS.Payload = payload
+	header, _ := DecodeDns(payload)
+	if err := DecodePayload(&m, &header, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.DNS.DnsRRs.Answers) != 1 || m.DNS.DnsRRs.Answers[0].Rdatatype != "A" || m.DNS.DnsRRs.Answers[0].Rdata != "127.0.0.1" {
+		t.Errorf("invalid answers: %+v", m.DNS.DnsRRs.Answers)
+	}
+}
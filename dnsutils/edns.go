@@ -2,9 +2,11 @@ package dnsutils
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 )
 
 var ErrDecodeEdnsBadRootDomain = errors.New("edns, name MUST be 0 (root domain)")
@@ -142,7 +144,7 @@ func DecodeEDNS(arcount int, start_offset int, payload []byte) (DnsExtended, int
 				}
 
 				optName := OptCodeToString(optCode)
-				optString, err := ParseOption(optName, payload[offset_next+4:offset_next+4+optLength])
+				optString, err := edns.DecodeOption(optName, payload[offset_next+4:offset_next+4+optLength])
 				if err != nil {
 					return edns, offset, err
 				}
@@ -175,18 +177,50 @@ func DecodeEDNS(arcount int, start_offset int, payload []byte) (DnsExtended, int
 }
 
 func ParseOption(optName string, optData []byte) (string, error) {
-	var ret string
-	var err error
+	edns := DnsExtended{}
+	return edns.DecodeOption(optName, optData)
+}
+
+// DecodeOption decodes the option into the structured fields of the edns section
+// and returns its text representation
+func (edns *DnsExtended) DecodeOption(optName string, optData []byte) (string, error) {
 	switch optName {
 	case "ERRORS":
-		ret, err = ParseErrors(optData)
+		ede, err := DecodeExtendedError(optData)
+		if err != nil {
+			return "", err
+		}
+		edns.ExtendedErrors = append(edns.ExtendedErrors, ede)
+		return ede.String(), nil
 	case "CSUBNET":
-		ret, err = ParseCsubnet(optData)
+		ecs, err := DecodeCsubnet(optData)
+		if err != nil {
+			return "-", err
+		}
+		edns.ClientSubnet = &ecs
+		return ecs.String(), nil
+	case "COOKIE":
+		cookie := DecodeCookie(optData)
+		edns.Cookie = &cookie
+		return cookie.String(), nil
+	case "PADDING":
+		edns.Padding = len(optData)
+		return strconv.Itoa(edns.Padding), nil
+	case "KEEPALIVE":
+		// the timeout is only present in the responses
+		if len(optData) >= 2 {
+			edns.Keepalive = int(binary.BigEndian.Uint16(optData[:2]))
+			return strconv.Itoa(edns.Keepalive), nil
+		}
+		return "-", nil
+	case "NSID":
+		if len(optData) > 0 {
+			return hex.EncodeToString(optData), nil
+		}
+		return "-", nil
 	default:
-		ret = "-"
-		err = nil
+		return "-", nil
 	}
-	return ret, err
 }
 
 /*
@@ -199,25 +233,34 @@ Extended Error EDNS0 option format
 / EXTRA-TEXT ...                                                /
 +---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
 */
-func ParseErrors(d []byte) (string, error) {
+func DecodeExtendedError(d []byte) (DnsExtendedError, error) {
 	if len(d) < 2 {
-		return "", ErrDecodeEdnsOptionTooShort
+		return DnsExtendedError{}, ErrDecodeEdnsOptionTooShort
 	}
-	code := int(binary.BigEndian.Uint16(d[:2]))
-	infoCode := ""
-	if s, ok := ErrorCodeToString[code]; ok {
-		infoCode = fmt.Sprintf("%d %s", code, s)
-	} else {
-		infoCode = fmt.Sprintf("%d -", code)
+	ede := DnsExtendedError{
+		InfoCode:  int(binary.BigEndian.Uint16(d[:2])),
+		Info:      "-",
+		ExtraText: "-",
+	}
+	if s, ok := ErrorCodeToString[ede.InfoCode]; ok {
+		ede.Info = s
 	}
-
-	extraText := "-"
 	if len(d[2:]) > 0 {
-		extraText = string(d[2:])
+		ede.ExtraText = string(d[2:])
 	}
+	return ede, nil
+}
 
-	opt := fmt.Sprintf("%s %s", infoCode, extraText)
-	return opt, nil
+func (ede DnsExtendedError) String() string {
+	return fmt.Sprintf("%d %s %s", ede.InfoCode, ede.Info, ede.ExtraText)
+}
+
+func ParseErrors(d []byte) (string, error) {
+	ede, err := DecodeExtendedError(d)
+	if err != nil {
+		return "", err
+	}
+	return ede.String(), nil
 }
 
 /*
@@ -238,24 +281,75 @@ Extended Csubnet EDNS0 option format
 
 +---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
 */
-func ParseCsubnet(d []byte) (string, error) {
+func DecodeCsubnet(d []byte) (DnsClientSubnet, error) {
 	if len(d) < 4 {
-		return "", ErrDecodeEdnsOptionTooShort
+		return DnsClientSubnet{}, ErrDecodeEdnsOptionTooShort
+	}
+	ecs := DnsClientSubnet{
+		Family:       int(binary.BigEndian.Uint16(d[:2])),
+		SourcePrefix: int(d[2]),
+		ScopePrefix:  int(d[3]),
 	}
-	family := int(binary.BigEndian.Uint16(d[:2]))
-	srcMask := d[2]
-	switch family {
+
+	var addr net.IP
+	switch ecs.Family {
 	case 1:
-		addr := make(net.IP, net.IPv4len)
-		copy(addr, d[4:])
-		ecs := fmt.Sprintf("%s/%d", addr.String(), srcMask)
-		return ecs, nil
+		addr = make(net.IP, net.IPv4len)
 	case 2:
-		addr := make(net.IP, net.IPv6len)
-		copy(addr, d[4:])
-		ecs := fmt.Sprintf("[%s]/%d", addr.String(), srcMask)
-		return ecs, nil
+		addr = make(net.IP, net.IPv6len)
 	default:
-		return "-", ErrDecodeEdnsOptionCsubnetBadFamily
+		return DnsClientSubnet{}, ErrDecodeEdnsOptionCsubnetBadFamily
 	}
+	copy(addr, d[4:])
+	ecs.Address = addr.String()
+	return ecs, nil
+}
+
+func (ecs DnsClientSubnet) String() string {
+	if ecs.Family == 2 {
+		return fmt.Sprintf("[%s]/%d", ecs.Address, ecs.SourcePrefix)
+	}
+	return fmt.Sprintf("%s/%d", ecs.Address, ecs.SourcePrefix)
+}
+
+func ParseCsubnet(d []byte) (string, error) {
+	ecs, err := DecodeCsubnet(d)
+	if err != nil {
+		return "-", err
+	}
+	return ecs.String(), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7873
+
+Cookie EDNS0 option format, the client cookie has a fixed size of 8 bytes
+and the server cookie, only present in the responses, a size of 8 to 32 bytes
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                                                               |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+  Client Cookie  +-+-+-+-+-+-+-+-+-+
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                        Server Cookie                          /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func DecodeCookie(d []byte) DnsCookie {
+	cookie := DnsCookie{Client: "-", Server: "-"}
+	if len(d) == 0 {
+		return cookie
+	}
+
+	size := 8
+	if len(d) < size {
+		size = len(d)
+	}
+	cookie.Client = hex.EncodeToString(d[:size])
+	if len(d) > size {
+		cookie.Server = hex.EncodeToString(d[size:])
+	}
+	return cookie
+}
+
+func (cookie DnsCookie) String() string {
+	return fmt.Sprintf("%s %s", cookie.Client, cookie.Server)
 }
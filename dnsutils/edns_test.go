@@ -3,6 +3,7 @@ package dnsutils
 import (
 	"errors"
 	"fmt"
+	"net"
 	"testing"
 
 	"github.com/miekg/dns"
@@ -863,3 +864,57 @@ func TestDecodeEdns_invalidRRFollows(t *testing.T) {
 		t.Errorf("bad error received: %v", err)
 	}
 }
+
+func TestDecodeEdns_StructuredOptions(t *testing.T) {
+	dm := new(dns.Msg)
+	dm.SetQuestion("dnstapcollector.test.", dns.TypeA)
+
+	e := &dns.OPT{}
+	e.Hdr.Name = "."
+	e.Hdr.Rrtype = dns.TypeOPT
+	e.SetUDPSize(1232)
+	e.Option = append(e.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 2, SourceNetmask: 56, SourceScope: 48, Address: net.ParseIP("2001:db8::")},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708aabbccddeeff0011"},
+		&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeStaleAnswer, ExtraText: "stale"},
+		&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeNetworkError},
+		&dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Length: 2, Timeout: 300},
+		&dns.EDNS0_PADDING{Padding: make([]byte, 16)},
+	)
+	dm.Extra = append(dm.Extra, e)
+
+	payload, _ := dm.Pack()
+	_, _, offset_rr, _ := DecodeQuestion(1, payload)
+	edns, _, err := DecodeEDNS(len(dm.Extra), offset_rr, payload)
+	if err != nil {
+		t.Fatalf("edns error returned: %v", err)
+	}
+
+	expectedEcs := DnsClientSubnet{Family: 2, SourcePrefix: 56, ScopePrefix: 48, Address: "2001:db8::"}
+	if edns.ClientSubnet == nil || *edns.ClientSubnet != expectedEcs {
+		t.Errorf("bad client subnet, expected %v, got %v", expectedEcs, edns.ClientSubnet)
+	}
+
+	expectedCookie := DnsCookie{Client: "0102030405060708", Server: "aabbccddeeff0011"}
+	if edns.Cookie == nil || *edns.Cookie != expectedCookie {
+		t.Errorf("bad cookie, expected %v, got %v", expectedCookie, edns.Cookie)
+	}
+
+	expectedErrors := []DnsExtendedError{
+		{InfoCode: 3, Info: "Stale Answer", ExtraText: "stale"},
+		{InfoCode: 23, Info: "Network Error", ExtraText: "-"},
+	}
+	if len(edns.ExtendedErrors) != 2 || edns.ExtendedErrors[0] != expectedErrors[0] || edns.ExtendedErrors[1] != expectedErrors[1] {
+		t.Errorf("bad extended errors, expected %v, got %v", expectedErrors, edns.ExtendedErrors)
+	}
+
+	if edns.Keepalive != 300 || edns.Padding != 16 {
+		t.Errorf("bad keepalive or padding, got %d %d", edns.Keepalive, edns.Padding)
+	}
+
+	// the text representation of the options is kept
+	if len(edns.Options) != 6 || edns.Options[0].Data != "[2001:db8::]/56" ||
+		edns.Options[1].Data != "0102030405060708 aabbccddeeff0011" || edns.Options[4].Data != "300" {
+		t.Errorf("bad options: %v", edns.Options)
+	}
+}
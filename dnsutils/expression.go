@@ -0,0 +1,428 @@
+package dnsutils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fields of the dns message available by their short name in the expressions,
+// the other fields are available by their flat json key (geoip.country-isocode, dns.flags.tc...)
+var expressionFields = map[string]func(dm *DnsMessage) string{
+	"identity":     func(dm *DnsMessage) string { return dm.DnsTap.Identity },
+	"operation":    func(dm *DnsMessage) string { return dm.DnsTap.Operation },
+	"latency":      func(dm *DnsMessage) string { return dm.DnsTap.LatencySec },
+	"type":         func(dm *DnsMessage) string { return dm.DNS.Type },
+	"qname":        func(dm *DnsMessage) string { return dm.DNS.Qname },
+	"qtype":        func(dm *DnsMessage) string { return dm.DNS.Qtype },
+	"rcode":        func(dm *DnsMessage) string { return dm.DNS.Rcode },
+	"opcode":       func(dm *DnsMessage) string { return strconv.Itoa(dm.DNS.Opcode) },
+	"id":           func(dm *DnsMessage) string { return strconv.Itoa(dm.DNS.Id) },
+	"length":       func(dm *DnsMessage) string { return strconv.Itoa(dm.DNS.Length) },
+	"malformed":    func(dm *DnsMessage) string { return strconv.FormatBool(dm.DNS.MalformedPacket) },
+	"answercount":  func(dm *DnsMessage) string { return strconv.Itoa(len(dm.DNS.DnsRRs.Answers)) },
+	"queryip":      func(dm *DnsMessage) string { return dm.NetworkInfo.QueryIp },
+	"queryport":    func(dm *DnsMessage) string { return dm.NetworkInfo.QueryPort },
+	"responseip":   func(dm *DnsMessage) string { return dm.NetworkInfo.ResponseIp },
+	"responseport": func(dm *DnsMessage) string { return dm.NetworkInfo.ResponsePort },
+	"family":       func(dm *DnsMessage) string { return dm.NetworkInfo.Family },
+	"protocol":     func(dm *DnsMessage) string { return dm.NetworkInfo.Protocol },
+	"listener": func(dm *DnsMessage) string {
+		if dm.Listener == nil {
+			return ""
+		}
+		return dm.Listener.Label
+	},
+	"etld": func(dm *DnsMessage) string {
+		suffix, _ := GetPublicSuffix(exprDomain(dm))
+		return suffix
+	},
+	"etld+1": func(dm *DnsMessage) string {
+		domain, _ := GetEffectiveTLDPlusOne(exprDomain(dm))
+		return domain
+	},
+	"subdomaindepth": func(dm *DnsMessage) string { return strconv.Itoa(GetSubdomainDepth(exprDomain(dm))) },
+}
+
+// exprDomain returns the qname in lowercase without ending dot for the public suffix fields
+func exprDomain(dm *DnsMessage) string {
+	return strings.ToLower(strings.TrimSuffix(dm.DNS.Qname, "."))
+}
+
+// Expression is a boolean expression evaluated on the fields of the dns messages, for example
+// rcode == "NXDOMAIN" && qtype == "TXT" && !qname endswith ".corp.example"
+type Expression struct {
+	source string
+	root   exprNode
+}
+
+// ParseExpression compiles the expression, the fields and the regular expressions are checked
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("expression: unexpected %q at position %d", p.tokens[p.pos].text, p.tokens[p.pos].pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Match returns true if the message matches the expression
+func (e *Expression) Match(dm *DnsMessage) bool {
+	return e.root.eval(&exprContext{dm: dm})
+}
+
+// values of the message, the flat json is computed on the first access to a json key
+type exprContext struct {
+	dm   *DnsMessage
+	flat map[string]interface{}
+}
+
+func (c *exprContext) get(field string) string {
+	if fn, ok := expressionFields[field]; ok {
+		return fn(c.dm)
+	}
+
+	if c.flat == nil {
+		flat, err := c.dm.Flatten()
+		if err != nil || flat == nil {
+			flat = map[string]interface{}{}
+		}
+		c.flat = flat
+	}
+	switch v := c.flat[field].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type exprNode interface {
+	eval(c *exprContext) bool
+}
+
+type exprAnd struct {
+	left, right exprNode
+}
+
+func (n *exprAnd) eval(c *exprContext) bool {
+	return n.left.eval(c) && n.right.eval(c)
+}
+
+type exprOr struct {
+	left, right exprNode
+}
+
+func (n *exprOr) eval(c *exprContext) bool {
+	return n.left.eval(c) || n.right.eval(c)
+}
+
+type exprNot struct {
+	node exprNode
+}
+
+func (n *exprNot) eval(c *exprContext) bool {
+	return !n.node.eval(c)
+}
+
+// operand of a comparison, a field of the message or a literal value
+type exprOperand struct {
+	field string
+	value string
+}
+
+func (o exprOperand) get(c *exprContext) string {
+	if len(o.field) > 0 {
+		return c.get(o.field)
+	}
+	return o.value
+}
+
+type exprCompare struct {
+	left  exprOperand
+	op    string
+	right exprOperand
+	regex *regexp.Regexp
+}
+
+func (n *exprCompare) eval(c *exprContext) bool {
+	left := n.left.get(c)
+	switch n.op {
+	case "=~":
+		return n.regex.MatchString(left)
+	case "!~":
+		return !n.regex.MatchString(left)
+	}
+
+	right := n.right.get(c)
+	switch n.op {
+	case "contains":
+		return strings.Contains(left, right)
+	case "startswith":
+		return strings.HasPrefix(left, right)
+	case "endswith":
+		return strings.HasSuffix(left, right)
+	}
+
+	// numbers are compared by their value, the ordering is only defined for numbers
+	l, errl := strconv.ParseFloat(left, 64)
+	r, errr := strconv.ParseFloat(right, 64)
+	numbers := errl == nil && errr == nil
+	switch n.op {
+	case "==":
+		if numbers {
+			return l == r
+		}
+		return left == right
+	case "!=":
+		if numbers {
+			return l != r
+		}
+		return left != right
+	case "<":
+		return numbers && l < r
+	case "<=":
+		return numbers && l <= r
+	case ">":
+		return numbers && l > r
+	case ">=":
+		return numbers && l >= r
+	}
+	return false
+}
+
+const (
+	tokenIdent = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// operators sorted by length, the longest one is matched first
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "!", "<", ">", "(", ")"}
+
+// operators written as words
+var exprWordOperators = map[string]string{
+	"and": "&&", "or": "||", "not": "!",
+	"contains": "contains", "startswith": "startswith", "endswith": "endswith", "matches": "=~",
+}
+
+func tokenizeExpression(source string) ([]exprToken, error) {
+	tokens := []exprToken{}
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		ch := runes[i]
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+
+		case ch == '"' || ch == '\'':
+			start := i
+			var value strings.Builder
+			i++
+			for i < len(runes) && runes[i] != ch {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("expression: unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: tokenString, text: value.String(), pos: start})
+
+		case unicode.IsDigit(ch) || (ch == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+
+		case unicode.IsLetter(ch):
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune(".-_+", runes[i])) {
+				i++
+			}
+			word := string(runes[start:i])
+			if op, ok := exprWordOperators[strings.ToLower(word)]; ok {
+				tokens = append(tokens, exprToken{kind: tokenOperator, text: op, pos: start})
+			} else {
+				tokens = append(tokens, exprToken{kind: tokenIdent, text: word, pos: start})
+			}
+
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, exprToken{kind: tokenOperator, text: op, pos: i})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("expression: unexpected character %q at position %d", ch, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// recursive descent parser, by precedence: or, and, not, comparison
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator && p.tokens[p.pos].text == op
+}
+
+func (p *exprParser) errorf(expected string) error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("expression: %s expected at the end", expected)
+	}
+	return fmt.Errorf("expression: %s expected, got %q at position %d", expected, p.tokens[p.pos].text, p.tokens[p.pos].pos)
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.peek("!") {
+		p.pos++
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNot{node: node}, nil
+	}
+
+	if p.peek("(") {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, p.errorf("')'")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseOperand() (exprOperand, error) {
+	if p.pos >= len(p.tokens) {
+		return exprOperand{}, p.errorf("field or value")
+	}
+	token := p.tokens[p.pos]
+	switch token.kind {
+	case tokenString, tokenNumber:
+		p.pos++
+		return exprOperand{value: token.text}, nil
+	case tokenIdent:
+		p.pos++
+		// booleans are compared with their json value
+		if token.text == "true" || token.text == "false" {
+			return exprOperand{value: token.text}, nil
+		}
+		if _, ok := expressionFields[token.text]; !ok && !strings.Contains(token.text, ".") {
+			return exprOperand{}, fmt.Errorf("expression: unknown field %q at position %d", token.text, token.pos)
+		}
+		return exprOperand{field: token.text}, nil
+	}
+	return exprOperand{}, p.errorf("field or value")
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOperator {
+		return nil, p.errorf("operator")
+	}
+	op := p.tokens[p.pos].text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~", "contains", "startswith", "endswith":
+	default:
+		return nil, p.errorf("operator")
+	}
+	p.pos++
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &exprCompare{left: left, op: op, right: right}
+	if op == "=~" || op == "!~" {
+		if len(right.field) > 0 {
+			return nil, fmt.Errorf("expression: the regular expression of %s must be a string", op)
+		}
+		if node.regex, err = regexp.Compile(right.value); err != nil {
+			return nil, fmt.Errorf("expression: %w", err)
+		}
+	}
+	return node, nil
+}
@@ -0,0 +1,81 @@
+package dnsutils
+
+import (
+	"testing"
+)
+
+func TestExpression_Match(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DNS.Rcode = "NXDOMAIN"
+	dm.DNS.Qtype = "TXT"
+	dm.DNS.Qname = "www.corp.example"
+	dm.DNS.Length = 120
+	dm.DnsTap.LatencySec = "0.150000"
+	dm.Geo = &DnsGeo{CountryIsoCode: "FR"}
+
+	testcases := []struct {
+		expression string
+		want       bool
+	}{
+		{expression: `rcode == "NXDOMAIN"`, want: true},
+		{expression: `rcode != "NXDOMAIN"`, want: false},
+		{expression: `rcode == "NXDOMAIN" && qtype == "TXT" && !qname endswith ".corp.example"`, want: false},
+		{expression: `rcode == "NXDOMAIN" && (qtype == "A" || qtype == "TXT")`, want: true},
+		{expression: `rcode == "NOERROR" || qtype == "A" || qtype == "AAAA"`, want: false},
+		{expression: `!(rcode == "NOERROR")`, want: true},
+		{expression: `not rcode == "NOERROR" and qname startswith "www."`, want: true},
+		{expression: `qname contains "corp"`, want: true},
+		{expression: `qname =~ "^www\\.[a-z]+\\.example$"`, want: true},
+		{expression: `qname !~ '^www\.'`, want: false},
+		{expression: `qname matches "corp"`, want: true},
+		{expression: `length > 100 && length <= 120`, want: true},
+		{expression: `length >= 121`, want: false},
+		{expression: `latency > 0.1`, want: true},
+		{expression: `latency == 0.15`, want: true},
+		{expression: `qname > 10`, want: false},
+		{expression: `queryip == "1.2.3.4"`, want: true},
+		{expression: `geoip.country-isocode == "FR"`, want: true},
+		{expression: `dns.length == 120`, want: true},
+		{expression: `dns.flags.tc == false`, want: true},
+		{expression: `unknown.field == ""`, want: true},
+		{expression: `etld == "example" && etld+1 == "corp.example"`, want: true},
+		{expression: `subdomaindepth > 1`, want: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.expression, func(t *testing.T) {
+			expr, err := ParseExpression(tc.expression)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if got := expr.Match(&dm); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExpression_Errors(t *testing.T) {
+	testcases := []string{
+		``,
+		`rcode`,
+		`rcode ==`,
+		`rcode == "NXDOMAIN" &&`,
+		`(rcode == "NXDOMAIN"`,
+		`rcode == "NXDOMAIN")`,
+		`rcode = "NXDOMAIN"`,
+		`unknown == "NXDOMAIN"`,
+		`qname == "unterminated`,
+		`qname =~ "[a-z"`,
+		`qname =~ qtype`,
+		`qname == "a" $ qtype == "A"`,
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc, func(t *testing.T) {
+			if _, err := ParseExpression(tc); err == nil {
+				t.Errorf("error expected")
+			}
+		})
+	}
+}
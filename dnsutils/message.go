@@ -14,9 +14,12 @@ import (
 	"time"
 
 	"github.com/dmachard/go-dnstap-protobuf"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
 	"github.com/nqd/flat"
+	"github.com/vmihailenco/msgpack"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -24,9 +27,23 @@ var (
 	DnsQuery               = "QUERY"
 	DnsReply               = "REPLY"
 	PdnsDirectives         = regexp.MustCompile(`^powerdns-*`)
+	HttpDirectives         = regexp.MustCompile(`^http-*`)
+	TlsDirectives          = regexp.MustCompile(`^tls-*`)
+	ProbeDirectives        = regexp.MustCompile(`^probe-*`)
+	EventDirectives        = regexp.MustCompile(`^event-*`)
+	TransferDirectives     = regexp.MustCompile(`^transfer-*`)
+	ListenerDirectives     = regexp.MustCompile(`^listener-*`)
 	GeoIPDirectives        = regexp.MustCompile(`^geoip-*`)
 	SuspiciousDirectives   = regexp.MustCompile(`^suspicious-*`)
 	PublicSuffixDirectives = regexp.MustCompile(`^publixsuffix-*`)
+	ReducerDirectives      = regexp.MustCompile(`^reducer-*`)
+	DnssecDirectives       = regexp.MustCompile(`^dnssec-*`)
+	ThreatIntelDirectives  = regexp.MustCompile(`^threatintel-*`)
+	TunnelingDirectives    = regexp.MustCompile(`^tunneling-*`)
+	JoinDirectives         = regexp.MustCompile(`^join-*`)
+	StatisticsDirectives   = regexp.MustCompile(`^statistics-*`)
+	AlertDirectives        = regexp.MustCompile(`^alert-*`)
+	BaselineDirectives     = regexp.MustCompile(`^baseline-*`)
 )
 
 func GetIpPort(dm *DnsMessage) (string, int, string, int) {
@@ -68,14 +85,22 @@ type DnsFlags struct {
 	AA bool `json:"aa" msgpack:"aa"`
 	RA bool `json:"ra" msgpack:"ra"`
 	AD bool `json:"ad" msgpack:"ad"`
+	CD bool `json:"cd" msgpack:"cd"`
 }
 
 type DnsGeo struct {
-	City                   string `json:"city" msgpack:"city"`
-	Continent              string `json:"continent" msgpack:"continent"`
-	CountryIsoCode         string `json:"country-isocode" msgpack:"country-isocode"`
-	AutonomousSystemNumber string `json:"as-number" msgpack:"as-number"`
-	AutonomousSystemOrg    string `json:"as-owner" msgpack:"as-owner"`
+	City                   string   `json:"city" msgpack:"city"`
+	Continent              string   `json:"continent" msgpack:"continent"`
+	CountryIsoCode         string   `json:"country-isocode" msgpack:"country-isocode"`
+	AutonomousSystemNumber string   `json:"as-number" msgpack:"as-number"`
+	AutonomousSystemOrg    string   `json:"as-owner" msgpack:"as-owner"`
+	ServerCountryIsoCode   string   `json:"server-country-isocode,omitempty" msgpack:"server-country-isocode"`
+	ServerAsNumber         string   `json:"server-as-number,omitempty" msgpack:"server-as-number"`
+	ServerAsOrg            string   `json:"server-as-owner,omitempty" msgpack:"server-as-owner"`
+	AnswerAsNumbers        []string `json:"answer-as-numbers,omitempty" msgpack:"answer-as-numbers"`
+	AnswerAsOrgs           []string `json:"answer-as-owners,omitempty" msgpack:"answer-as-owners"`
+	Isp                    string   `json:"isp,omitempty" msgpack:"isp"`
+	ConnectionType         string   `json:"connection-type,omitempty" msgpack:"connection-type"`
 }
 
 type DnsNetInfo struct {
@@ -87,6 +112,8 @@ type DnsNetInfo struct {
 	ResponsePort   string `json:"response-port" msgpack:"response-port"`
 	IpDefragmented bool   `json:"ip-defragmented" msgpack:"ip-defragmented"`
 	TcpReassembled bool   `json:"tcp-reassembled" msgpack:"tcp-reassembled"`
+	QueryPtr       string `json:"query-ptr,omitempty" msgpack:"query-ptr"`
+	QueryLabel     string `json:"query-label,omitempty" msgpack:"query-label"`
 }
 
 type DnsRRs struct {
@@ -104,10 +131,18 @@ type Dns struct {
 	Rcode   string `json:"rcode" msgpack:"rcode"`
 	Qname   string `json:"qname" msgpack:"qname"`
 
-	Qtype           string   `json:"qtype" msgpack:"qtype"`
-	Flags           DnsFlags `json:"flags" msgpack:"flags"`
-	DnsRRs          DnsRRs   `json:"resource-records" msgpack:"resource-records"`
-	MalformedPacket bool     `json:"malformed-packet" msgpack:"malformed-packet"`
+	Qtype           string         `json:"qtype" msgpack:"qtype"`
+	Flags           DnsFlags       `json:"flags" msgpack:"flags"`
+	DnsRRs          DnsRRs         `json:"resource-records" msgpack:"resource-records"`
+	MalformedPacket bool           `json:"malformed-packet" msgpack:"malformed-packet"`
+	Dnssec          *DnssecSummary `json:"dnssec,omitempty" msgpack:"dnssec"`
+}
+
+type DnssecSummary struct {
+	Signed     bool   `json:"signed" msgpack:"signed"`
+	Rrsigs     int    `json:"rrsigs" msgpack:"rrsigs"`
+	Algorithms []int  `json:"algorithms" msgpack:"algorithms"`
+	Denial     string `json:"denial" msgpack:"denial"`
 }
 
 type DnsOption struct {
@@ -116,13 +151,36 @@ type DnsOption struct {
 	Data string `json:"data" msgpack:"data"`
 }
 
+type DnsClientSubnet struct {
+	Family       int    `json:"family" msgpack:"family"`
+	SourcePrefix int    `json:"source-prefix" msgpack:"source-prefix"`
+	ScopePrefix  int    `json:"scope-prefix" msgpack:"scope-prefix"`
+	Address      string `json:"address" msgpack:"address"`
+}
+
+type DnsCookie struct {
+	Client string `json:"client" msgpack:"client"`
+	Server string `json:"server" msgpack:"server"`
+}
+
+type DnsExtendedError struct {
+	InfoCode  int    `json:"info-code" msgpack:"info-code"`
+	Info      string `json:"info" msgpack:"info"`
+	ExtraText string `json:"extra-text" msgpack:"extra-text"`
+}
+
 type DnsExtended struct {
-	UdpSize       int         `json:"udp-size" msgpack:"udp-size"`
-	ExtendedRcode int         `json:"rcode" msgpack:"rcode"`
-	Version       int         `json:"version" msgpack:"version"`
-	Do            int         `json:"dnssec-ok" msgpack:"dnssec-ok"`
-	Z             int         `json:"-" msgpack:"-"`
-	Options       []DnsOption `json:"options" msgpack:"options"`
+	UdpSize        int                `json:"udp-size" msgpack:"udp-size"`
+	ExtendedRcode  int                `json:"rcode" msgpack:"rcode"`
+	Version        int                `json:"version" msgpack:"version"`
+	Do             int                `json:"dnssec-ok" msgpack:"dnssec-ok"`
+	Z              int                `json:"-" msgpack:"-"`
+	Options        []DnsOption        `json:"options" msgpack:"options"`
+	ClientSubnet   *DnsClientSubnet   `json:"csubnet,omitempty" msgpack:"csubnet"`
+	Cookie         *DnsCookie         `json:"cookie,omitempty" msgpack:"cookie"`
+	ExtendedErrors []DnsExtendedError `json:"extended-errors,omitempty" msgpack:"extended-errors"`
+	Padding        int                `json:"padding,omitempty" msgpack:"padding"`
+	Keepalive      int                `json:"keepalive,omitempty" msgpack:"keepalive"`
 }
 
 type DnsTap struct {
@@ -136,6 +194,12 @@ type DnsTap struct {
 	Latency          float64 `json:"-" msgpack:"-"`
 	LatencySec       string  `json:"latency" msgpack:"latency"`
 	Payload          []byte  `json:"-" msgpack:"-"`
+	Extra            string  `json:"extra" msgpack:"extra"`
+	PolicyType       string  `json:"policy-type" msgpack:"policy-type"`
+	PolicyRule       string  `json:"policy-rule" msgpack:"policy-rule"`
+	PolicyAction     string  `json:"policy-action" msgpack:"policy-action"`
+	PolicyMatch      string  `json:"policy-match" msgpack:"policy-match"`
+	PolicyValue      string  `json:"policy-value" msgpack:"policy-value"`
 }
 
 type PowerDns struct {
@@ -145,6 +209,37 @@ type PowerDns struct {
 	Metadata              map[string]string `json:"metadata" msgpack:"metadata"`
 }
 
+type DnsHttp struct {
+	Method    string `json:"method" msgpack:"method"`
+	Uri       string `json:"uri" msgpack:"uri"`
+	Version   string `json:"version" msgpack:"version"`
+	UserAgent string `json:"user-agent" msgpack:"user-agent"`
+}
+
+type DnsTls struct {
+	ServerName string `json:"server-name" msgpack:"server-name"`
+	Version    string `json:"version" msgpack:"version"`
+}
+
+// DnsTransfer summarizes the messages of a zone transfer
+type DnsTransfer struct {
+	Messages int            `json:"messages" msgpack:"messages"`
+	Records  int            `json:"records" msgpack:"records"`
+	Serials  []int          `json:"serials" msgpack:"serials"`
+	Types    map[string]int `json:"types" msgpack:"types"`
+}
+
+type DnsProbe struct {
+	Target string `json:"target" msgpack:"target"`
+	Error  string `json:"error" msgpack:"error"`
+}
+
+// DnsListener is the collector having received the message and its label
+type DnsListener struct {
+	Collector string `json:"collector" msgpack:"collector"`
+	Label     string `json:"label" msgpack:"label"`
+}
+
 type Suspicious struct {
 	Score                 float64 `json:"score" msgpack:"score"`
 	MalformedPacket       bool    `json:"malformed-pkt" msgpack:"malformed-pkt"`
@@ -154,25 +249,147 @@ type Suspicious struct {
 	UnallowedChars        bool    `json:"unallowed-chars" msgpack:"unallowed-chars"`
 	UncommonQtypes        bool    `json:"uncommon-qtypes" msgpack:"uncommon-qtypes"`
 	ExcessiveNumberLabels bool    `json:"excessive-number-labels" msgpack:"excessive-number-labels"`
+	HighEntropy           bool    `json:"high-entropy" msgpack:"high-entropy"`
+	Dga                   bool    `json:"dga" msgpack:"dga"`
+	Tunneling             bool    `json:"tunneling" msgpack:"tunneling"`
+	Entropy               float64 `json:"entropy" msgpack:"entropy"`
+	ConsonantRatio        float64 `json:"consonant-ratio" msgpack:"consonant-ratio"`
+	DigitRatio            float64 `json:"digit-ratio" msgpack:"digit-ratio"`
 }
 
 type PublicSuffix struct {
 	QnamePublicSuffix        string `json:"tld" msgpack:"qname-public-suffix"`
 	QnameEffectiveTLDPlusOne string `json:"etld+1" msgpack:"qname-effective-tld-plus-one"`
+	QnameSubdomainDepth      int    `json:"subdomain-depth" msgpack:"qname-subdomain-depth"`
+}
+
+type TransformReducer struct {
+	Occurences int `json:"occurences" msgpack:"occurences"`
+	Interval   int `json:"interval" msgpack:"interval"`
+}
+
+type TransformDnssec struct {
+	Status        string `json:"status" msgpack:"status"`
+	Authenticated bool   `json:"authenticated" msgpack:"authenticated"`
+}
+
+type TransformThreatIntel struct {
+	Feed     string `json:"feed" msgpack:"feed"`
+	Category string `json:"category" msgpack:"category"`
+}
+
+type TransformTunneling struct {
+	Domain         string  `json:"domain" msgpack:"domain"`
+	Messages       int     `json:"messages" msgpack:"messages"`
+	UniqSubdomains int     `json:"uniq-subdomains" msgpack:"uniq-subdomains"`
+	TxtNullRatio   float64 `json:"txt-null-ratio" msgpack:"txt-null-ratio"`
+	AvgLength      int     `json:"avg-length" msgpack:"avg-length"`
+	Interval       int     `json:"interval" msgpack:"interval"`
+}
+
+type TransformStatistics struct {
+	Window     int        `json:"window" msgpack:"window"`
+	Total      int        `json:"total" msgpack:"total"`
+	TopQnames  []TopKItem `json:"top-qnames" msgpack:"top-qnames"`
+	TopClients []TopKItem `json:"top-clients" msgpack:"top-clients"`
+	TopTlds    []TopKItem `json:"top-tlds" msgpack:"top-tlds"`
+	TopDomains []TopKItem `json:"top-domains" msgpack:"top-domains"`
+	TopRcodes  []TopKItem `json:"top-rcodes" msgpack:"top-rcodes"`
+}
+
+type TransformAlert struct {
+	Name      string `json:"name" msgpack:"name"`
+	GroupBy   string `json:"group-by" msgpack:"group-by"`
+	Key       string `json:"key" msgpack:"key"`
+	Threshold int    `json:"threshold" msgpack:"threshold"`
+	Window    int    `json:"window" msgpack:"window"`
+}
+
+// TransformBaseline is the rate of the client and of the domain of the message during
+// the last interval, compared to their learned baseline
+type TransformBaseline struct {
+	ClientQps      float64 `json:"client-qps" msgpack:"client-qps"`
+	ClientBaseline float64 `json:"client-baseline" msgpack:"client-baseline"`
+	ClientRatio    float64 `json:"client-ratio" msgpack:"client-ratio"`
+	DomainQps      float64 `json:"domain-qps" msgpack:"domain-qps"`
+	DomainBaseline float64 `json:"domain-baseline" msgpack:"domain-baseline"`
+	DomainRatio    float64 `json:"domain-ratio" msgpack:"domain-ratio"`
+	Anomaly        bool    `json:"anomaly" msgpack:"anomaly"`
+}
+
+type TransformJoin struct {
+	Matched        bool   `json:"matched" msgpack:"matched"`
+	Restored       bool   `json:"restored" msgpack:"restored"`
+	QueryTimestamp string `json:"query-timestamp-rfc3339ns" msgpack:"query-timestamp-rfc3339ns"`
+	QueryLength    int    `json:"query-length" msgpack:"query-length"`
+}
+
+// FieldSelection restricts the keys of the flattened message, a field also
+// selects all the keys below it, "dns" matches "dns.qname" for example
+type FieldSelection struct {
+	Keep []string
+	Drop []string
+}
+
+func (fs *FieldSelection) match(fields []string, key string) bool {
+	for _, f := range fields {
+		if key == f || strings.HasPrefix(key, f+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply removes from the flattened message the keys not kept or dropped,
+// the schema version is always kept
+func (fs *FieldSelection) Apply(flat map[string]interface{}) {
+	for key := range flat {
+		if key == "schema-version" {
+			continue
+		}
+		if len(fs.Keep) > 0 && !fs.match(fs.Keep, key) {
+			delete(flat, key)
+			continue
+		}
+		if fs.match(fs.Drop, key) {
+			delete(flat, key)
+		}
+	}
 }
 
 type DnsMessage struct {
-	NetworkInfo  DnsNetInfo    `json:"network" msgpack:"network"`
-	DNS          Dns           `json:"dns" msgpack:"dns"`
-	EDNS         DnsExtended   `json:"edns" msgpack:"edns"`
-	DnsTap       DnsTap        `json:"dnstap" msgpack:"dnstap"`
-	Geo          *DnsGeo       `json:"geoip,omitempty" msgpack:"geo"`
-	PowerDns     *PowerDns     `json:"powerdns,omitempty" msgpack:"powerdns"`
-	Suspicious   *Suspicious   `json:"suspicious,omitempty" msgpack:"suspicious"`
-	PublicSuffix *PublicSuffix `json:"publicsuffix,omitempty" msgpack:"publicsuffix"`
+	// version of the json schema, the fields are not moved or removed without a new version
+	SchemaVersion int `json:"schema-version" msgpack:"schema-version"`
+
+	NetworkInfo  DnsNetInfo             `json:"network" msgpack:"network"`
+	DNS          Dns                    `json:"dns" msgpack:"dns"`
+	EDNS         DnsExtended            `json:"edns" msgpack:"edns"`
+	DnsTap       DnsTap                 `json:"dnstap" msgpack:"dnstap"`
+	Geo          *DnsGeo                `json:"geoip,omitempty" msgpack:"geo"`
+	PowerDns     *PowerDns              `json:"powerdns,omitempty" msgpack:"powerdns"`
+	Http         *DnsHttp               `json:"http,omitempty" msgpack:"http"`
+	Tls          *DnsTls                `json:"tls,omitempty" msgpack:"tls"`
+	Probe        *DnsProbe              `json:"probe,omitempty" msgpack:"probe"`
+	Event        *DnsEvent              `json:"event,omitempty" msgpack:"event"`
+	Transfer     *DnsTransfer           `json:"transfer,omitempty" msgpack:"transfer"`
+	Listener     *DnsListener           `json:"listener,omitempty" msgpack:"listener"`
+	Suspicious   *Suspicious            `json:"suspicious,omitempty" msgpack:"suspicious"`
+	PublicSuffix *PublicSuffix          `json:"publicsuffix,omitempty" msgpack:"publicsuffix"`
+	Reducer      *TransformReducer      `json:"reducer,omitempty" msgpack:"reducer"`
+	Dnssec       *TransformDnssec       `json:"dnssec,omitempty" msgpack:"dnssec"`
+	ThreatIntel  *TransformThreatIntel  `json:"threatintel,omitempty" msgpack:"threatintel"`
+	Tunneling    *TransformTunneling    `json:"tunneling,omitempty" msgpack:"tunneling"`
+	Join         *TransformJoin         `json:"join,omitempty" msgpack:"join"`
+	Statistics   *TransformStatistics   `json:"statistics,omitempty" msgpack:"statistics"`
+	Alert        *TransformAlert        `json:"alert,omitempty" msgpack:"alert"`
+	Baseline     *TransformBaseline     `json:"baseline,omitempty" msgpack:"baseline"`
+	Extra        map[string]interface{} `json:"extra,omitempty" msgpack:"extra"`
+	Fields       *FieldSelection        `json:"-" msgpack:"-"`
 }
 
 func (dm *DnsMessage) Init() {
+	dm.SchemaVersion = SCHEMA_VERSION
+
 	dm.NetworkInfo = DnsNetInfo{
 		Family:         "-",
 		Protocol:       "-",
@@ -190,6 +407,12 @@ func (dm *DnsMessage) Init() {
 		Version:          "-",
 		TimestampRFC3339: "-",
 		LatencySec:       "-",
+		Extra:            "-",
+		PolicyType:       "-",
+		PolicyRule:       "-",
+		PolicyAction:     "-",
+		PolicyMatch:      "-",
+		PolicyValue:      "-",
 	}
 
 	dm.DNS = Dns{
@@ -227,10 +450,147 @@ func (dm *DnsMessage) handleGeoIPDirectives(directives []string, s *bytes.Buffer
 			s.WriteString(dm.Geo.AutonomousSystemNumber)
 		case directive == "geoip-as-owner":
 			s.WriteString(dm.Geo.AutonomousSystemOrg)
+		case directive == "geoip-server-country":
+			s.WriteString(dm.Geo.ServerCountryIsoCode)
+		case directive == "geoip-server-as-number":
+			s.WriteString(dm.Geo.ServerAsNumber)
+		case directive == "geoip-server-as-owner":
+			s.WriteString(dm.Geo.ServerAsOrg)
+		case directive == "geoip-isp":
+			s.WriteString(dm.Geo.Isp)
+		case directive == "geoip-connection-type":
+			s.WriteString(dm.Geo.ConnectionType)
+		}
+	}
+}
+
+func (dm *DnsMessage) handleHttpDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Http == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "http-method":
+			s.WriteString(dm.Http.Method)
+		case directive == "http-uri":
+			s.WriteString(dm.Http.Uri)
+		case directive == "http-version":
+			s.WriteString(dm.Http.Version)
+		case directive == "http-user-agent":
+			s.WriteString(dm.Http.UserAgent)
+		}
+	}
+}
+
+func (dm *DnsMessage) handleTlsDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Tls == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "tls-server-name":
+			s.WriteString(dm.Tls.ServerName)
+		case directive == "tls-version":
+			s.WriteString(dm.Tls.Version)
+		}
+	}
+}
+
+func (dm *DnsMessage) handleProbeDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Probe == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "probe-target":
+			s.WriteString(dm.Probe.Target)
+		case directive == "probe-error":
+			if len(dm.Probe.Error) == 0 {
+				s.WriteString("-")
+			} else {
+				s.WriteString(dm.Probe.Error)
+			}
+		}
+	}
+}
+
+func (dm *DnsMessage) handleEventDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Event == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "event-type":
+			s.WriteString(dm.Event.Type)
+		case directive == "event-worker":
+			s.WriteString(dm.Event.Worker)
+		case directive == "event-peer":
+			if len(dm.Event.Peer) == 0 {
+				s.WriteString("-")
+			} else {
+				s.WriteString(dm.Event.Peer)
+			}
+		case directive == "event-count":
+			s.WriteString(strconv.Itoa(dm.Event.Count))
+		case directive == "event-message":
+			if len(dm.Event.Message) == 0 {
+				s.WriteString("-")
+			} else {
+				s.WriteString(dm.Event.Message)
+			}
 		}
 	}
 }
 
+func (dm *DnsMessage) handleTransferDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Transfer == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "transfer-messages":
+			s.WriteString(strconv.Itoa(dm.Transfer.Messages))
+		case directive == "transfer-records":
+			s.WriteString(strconv.Itoa(dm.Transfer.Records))
+		case directive == "transfer-serials":
+			if len(dm.Transfer.Serials) == 0 {
+				s.WriteString("-")
+			} else {
+				serials := make([]string, len(dm.Transfer.Serials))
+				for i, serial := range dm.Transfer.Serials {
+					serials[i] = strconv.Itoa(serial)
+				}
+				s.WriteString(strings.Join(serials, ","))
+			}
+		}
+	}
+}
+
+func (dm *DnsMessage) handleListenerDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Listener == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "listener-collector":
+			s.WriteString(dm.Listener.Collector)
+		case directive == "listener-label":
+			s.WriteString(dm.Listener.Label)
+		}
+	}
+}
+
+// SetListener adds the collector and its label to the message, the message is unchanged without label
+func (dm *DnsMessage) SetListener(collector string, label string) {
+	if len(label) == 0 {
+		return
+	}
+	dm.Listener = &DnsListener{Collector: collector, Label: label}
+}
+
+func (dm *DnsMessage) handleExtraDirectives(directives []string, s *bytes.Buffer) {
+	value, ok := dm.Extra[directives[1]]
+	if !ok || value == nil {
+		s.WriteString("-")
+		return
+	}
+	s.WriteString(strings.Replace(fmt.Sprint(value), " ", "_", -1))
+}
+
 func (dm *DnsMessage) handlePdnsDirectives(directives []string, s *bytes.Buffer) {
 	if dm.PowerDns == nil {
 		s.WriteString("-")
@@ -318,14 +678,148 @@ func (dm *DnsMessage) handlePublicSuffixDirectives(directives []string, s *bytes
 			s.WriteString(dm.PublicSuffix.QnamePublicSuffix)
 		case directive == "publixsuffix-etld+1":
 			s.WriteString(dm.PublicSuffix.QnameEffectiveTLDPlusOne)
+		case directive == "publixsuffix-subdomain-depth":
+			s.WriteString(strconv.Itoa(dm.PublicSuffix.QnameSubdomainDepth))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleReducerDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Reducer == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "reducer-occurences":
+			s.WriteString(strconv.Itoa(dm.Reducer.Occurences))
+		case directive == "reducer-interval":
+			s.WriteString(strconv.Itoa(dm.Reducer.Interval))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleDnssecDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Dnssec == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "dnssec-status":
+			s.WriteString(dm.Dnssec.Status)
+		case directive == "dnssec-authenticated":
+			if dm.Dnssec.Authenticated {
+				s.WriteString("1")
+			} else {
+				s.WriteString("0")
+			}
+		}
+	}
+}
+
+func (dm *DnsMessage) handleThreatIntelDirectives(directives []string, s *bytes.Buffer) {
+	if dm.ThreatIntel == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "threatintel-feed":
+			s.WriteString(dm.ThreatIntel.Feed)
+		case directive == "threatintel-category":
+			s.WriteString(dm.ThreatIntel.Category)
+		}
+	}
+}
+
+func (dm *DnsMessage) handleTunnelingDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Tunneling == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "tunneling-domain":
+			s.WriteString(dm.Tunneling.Domain)
+		case directive == "tunneling-messages":
+			s.WriteString(strconv.Itoa(dm.Tunneling.Messages))
+		case directive == "tunneling-uniq-subdomains":
+			s.WriteString(strconv.Itoa(dm.Tunneling.UniqSubdomains))
+		case directive == "tunneling-txt-null-ratio":
+			s.WriteString(strconv.FormatFloat(dm.Tunneling.TxtNullRatio, 'f', -1, 64))
+		case directive == "tunneling-avg-length":
+			s.WriteString(strconv.Itoa(dm.Tunneling.AvgLength))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleJoinDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Join == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "join-matched":
+			s.WriteString(strconv.FormatBool(dm.Join.Matched))
+		case directive == "join-restored":
+			s.WriteString(strconv.FormatBool(dm.Join.Restored))
+		case directive == "join-query-timestamp":
+			s.WriteString(dm.Join.QueryTimestamp)
+		case directive == "join-query-length":
+			s.WriteString(strconv.Itoa(dm.Join.QueryLength))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleStatisticsDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Statistics == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "statistics-window":
+			s.WriteString(strconv.Itoa(dm.Statistics.Window))
+		case directive == "statistics-total":
+			s.WriteString(strconv.Itoa(dm.Statistics.Total))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleAlertDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Alert == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "alert-name":
+			s.WriteString(dm.Alert.Name)
+		case directive == "alert-key":
+			s.WriteString(dm.Alert.Key)
+		case directive == "alert-threshold":
+			s.WriteString(strconv.Itoa(dm.Alert.Threshold))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleBaselineDirectives(directives []string, s *bytes.Buffer) {
+	if dm.Baseline == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "baseline-client-ratio":
+			s.WriteString(strconv.FormatFloat(dm.Baseline.ClientRatio, 'f', -1, 64))
+		case directive == "baseline-domain-ratio":
+			s.WriteString(strconv.FormatFloat(dm.Baseline.DomainRatio, 'f', -1, 64))
+		case directive == "baseline-anomaly":
+			s.WriteString(strconv.FormatBool(dm.Baseline.Anomaly))
 		}
 	}
 }
 
+// additional directives of the text format, registered by name
+var textDirectives = map[string]func(dm *DnsMessage, directives []string) string{}
+
+// RegisterTextDirective adds a directive to the text format, the handler receives
+// the directive and its optional parameter after the colon
+func RegisterTextDirective(name string, handler func(dm *DnsMessage, directives []string) string) {
+	textDirectives[name] = handler
+}
+
 func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundary string) []byte {
 	var s bytes.Buffer
 
 	for i, word := range format {
+		start := s.Len()
 		directives := strings.SplitN(word, ":", 2)
 		switch directive := directives[0]; {
 		case directive == "ttl":
@@ -341,13 +835,8 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 				s.WriteString("-")
 			}
 		case directive == "edns-csubnet":
-			if len(dm.EDNS.Options) > 0 {
-				for _, opt := range dm.EDNS.Options {
-					if opt.Name == "CSUBNET" {
-						s.WriteString(opt.Data)
-						break
-					}
-				}
+			if dm.EDNS.ClientSubnet != nil {
+				s.WriteString(dm.EDNS.ClientSubnet.String())
 			} else {
 				s.WriteString("-")
 			}
@@ -374,6 +863,23 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			s.WriteString(dm.DnsTap.Version)
 		case directive == "operation":
 			s.WriteString(dm.DnsTap.Operation)
+		case directive == "extra":
+			// with a key, the field of the extra map
+			if len(directives) == 2 {
+				dm.handleExtraDirectives(directives, &s)
+			} else {
+				s.WriteString(dm.DnsTap.Extra)
+			}
+		case directive == "policy-type":
+			s.WriteString(dm.DnsTap.PolicyType)
+		case directive == "policy-rule":
+			s.WriteString(dm.DnsTap.PolicyRule)
+		case directive == "policy-action":
+			s.WriteString(dm.DnsTap.PolicyAction)
+		case directive == "policy-match":
+			s.WriteString(dm.DnsTap.PolicyMatch)
+		case directive == "policy-value":
+			s.WriteString(dm.DnsTap.PolicyValue)
 		case directive == "rcode":
 			s.WriteString(dm.DNS.Rcode)
 		case directive == "queryip":
@@ -384,6 +890,18 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			s.WriteString(dm.NetworkInfo.ResponseIp)
 		case directive == "responseport":
 			s.WriteString(dm.NetworkInfo.ResponsePort)
+		case directive == "queryptr":
+			if len(dm.NetworkInfo.QueryPtr) > 0 {
+				s.WriteString(dm.NetworkInfo.QueryPtr)
+			} else {
+				s.WriteString("-")
+			}
+		case directive == "querylabel":
+			if len(dm.NetworkInfo.QueryLabel) > 0 {
+				s.WriteString(dm.NetworkInfo.QueryLabel)
+			} else {
+				s.WriteString("-")
+			}
 		case directive == "family":
 			s.WriteString(dm.NetworkInfo.Family)
 		case directive == "protocol":
@@ -391,15 +909,7 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 		case directive == "length":
 			s.WriteString(strconv.Itoa(dm.DNS.Length) + "b")
 		case directive == "qname":
-			if strings.Contains(dm.DNS.Qname, fieldDelimiter) {
-				qname := dm.DNS.Qname
-				if strings.Contains(qname, fieldBoundary) {
-					qname = strings.ReplaceAll(qname, fieldBoundary, "\\"+fieldBoundary)
-				}
-				s.WriteString(fmt.Sprintf(fieldBoundary+"%s"+fieldBoundary, qname))
-			} else {
-				s.WriteString(dm.DNS.Qname)
-			}
+			s.WriteString(dm.DNS.Qname)
 		case directive == "qtype":
 			s.WriteString(dm.DNS.Qtype)
 		case directive == "latency":
@@ -451,16 +961,64 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			} else {
 				s.WriteString("-")
 			}
+		case directive == "cd":
+			if dm.DNS.Flags.CD {
+				s.WriteString("CD")
+			} else {
+				s.WriteString("-")
+			}
 		case PdnsDirectives.MatchString(directive):
 			dm.handlePdnsDirectives(directives, &s)
+		case HttpDirectives.MatchString(directive):
+			dm.handleHttpDirectives(directives, &s)
+		case TlsDirectives.MatchString(directive):
+			dm.handleTlsDirectives(directives, &s)
+		case ProbeDirectives.MatchString(directive):
+			dm.handleProbeDirectives(directives, &s)
+		case EventDirectives.MatchString(directive):
+			dm.handleEventDirectives(directives, &s)
+		case TransferDirectives.MatchString(directive):
+			dm.handleTransferDirectives(directives, &s)
+		case ListenerDirectives.MatchString(directive):
+			dm.handleListenerDirectives(directives, &s)
 		case GeoIPDirectives.MatchString(directive):
 			dm.handleGeoIPDirectives(directives, &s)
 		case SuspiciousDirectives.MatchString(directive):
 			dm.handleSuspiciousDirectives(directives, &s)
 		case PublicSuffixDirectives.MatchString(directive):
 			dm.handlePublicSuffixDirectives(directives, &s)
+		case ReducerDirectives.MatchString(directive):
+			dm.handleReducerDirectives(directives, &s)
+		case DnssecDirectives.MatchString(directive):
+			dm.handleDnssecDirectives(directives, &s)
+		case ThreatIntelDirectives.MatchString(directive):
+			dm.handleThreatIntelDirectives(directives, &s)
+		case TunnelingDirectives.MatchString(directive):
+			dm.handleTunnelingDirectives(directives, &s)
+		case JoinDirectives.MatchString(directive):
+			dm.handleJoinDirectives(directives, &s)
+		case StatisticsDirectives.MatchString(directive):
+			dm.handleStatisticsDirectives(directives, &s)
+		case AlertDirectives.MatchString(directive):
+			dm.handleAlertDirectives(directives, &s)
+		case BaselineDirectives.MatchString(directive):
+			dm.handleBaselineDirectives(directives, &s)
 		default:
-			log.Fatalf("unsupport directive for text format: %s", word)
+			handler, ok := textDirectives[directive]
+			if !ok {
+				log.Fatalf("unsupport directive for text format: %s", word)
+			}
+			s.WriteString(handler(dm, directives))
+		}
+
+		// the value is enclosed by the boundary if it contains the delimiter
+		if len(fieldBoundary) > 0 && len(fieldDelimiter) > 0 {
+			value := s.Bytes()[start:]
+			if bytes.Contains(value, []byte(fieldDelimiter)) {
+				quoted := strings.ReplaceAll(string(value), fieldBoundary, "\\"+fieldBoundary)
+				s.Truncate(start)
+				s.WriteString(fieldBoundary + quoted + fieldBoundary)
+			}
 		}
 
 		if i < len(format)-1 {
@@ -475,6 +1033,16 @@ func (dm *DnsMessage) String(format []string, fieldDelimiter string, fieldBounda
 	return string(dm.Bytes(format, fieldDelimiter, fieldBoundary))
 }
 
+// ToMsgpack encodes the dns message in the msgpack binary format
+func (dm *DnsMessage) ToMsgpack() ([]byte, error) {
+	return msgpack.Marshal(dm)
+}
+
+// ToCbor encodes the dns message in the cbor binary format, with the keys of the json format
+func (dm *DnsMessage) ToCbor() ([]byte, error) {
+	return cbor.Marshal(dm)
+}
+
 func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 	if len(dm.DnsTap.Payload) > 0 {
 		return dm.DnsTap.Payload, nil
@@ -484,6 +1052,9 @@ func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 	t := dnstap.Dnstap_MESSAGE
 	dt.Identity = []byte(dm.DnsTap.Identity)
 	dt.Version = []byte("-")
+	if len(dm.DnsTap.Version) > 0 && dm.DnsTap.Version != "-" {
+		dt.Version = []byte(dm.DnsTap.Version)
+	}
 	dt.Type = &t
 
 	mt := dnstap.Message_Type(dnstap.Message_Type_value[dm.DnsTap.Operation])
@@ -532,13 +1103,13 @@ func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 	msg.ResponsePort = &rport
 
 	if dm.DNS.Type == DnsQuery {
-		msg.QueryMessage = dm.DNS.Payload
+		msg.QueryMessage = dm.EncodeDnsPayload()
 		msg.QueryTimeSec = &tsec
 		msg.QueryTimeNsec = &tnsec
 	} else {
 		msg.ResponseTimeSec = &tsec
 		msg.ResponseTimeNsec = &tnsec
-		msg.ResponseMessage = dm.DNS.Payload
+		msg.ResponseMessage = dm.EncodeDnsPayload()
 	}
 
 	dt.Message = msg
@@ -550,6 +1121,69 @@ func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 	return data, nil
 }
 
+// EncodeDnsPayload returns the dns payload, the question and the matching records
+// are renamed if the qname has been updated by the transformers
+func (dm *DnsMessage) EncodeDnsPayload() []byte {
+	if len(dm.DNS.Payload) == 0 || dm.DNS.MalformedPacket {
+		return dm.DNS.Payload
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(dm.DNS.Payload); err != nil || len(msg.Question) == 0 {
+		return dm.DNS.Payload
+	}
+
+	qname := msg.Question[0].Name
+	if strings.TrimSuffix(qname, ".") == dm.DNS.Qname {
+		return dm.DNS.Payload
+	}
+
+	newQname := dns.Fqdn(dm.DNS.Qname)
+	msg.Question[0].Name = newQname
+	for _, rrs := range [][]dns.RR{msg.Answer, msg.Ns} {
+		for _, rr := range rrs {
+			if strings.EqualFold(rr.Header().Name, qname) {
+				rr.Header().Name = newQname
+			}
+		}
+	}
+
+	payload, err := msg.Pack()
+	if err != nil {
+		return dm.DNS.Payload
+	}
+	return payload
+}
+
+// BuildDnsPayload returns a minimal dns message with the question section only,
+// used when the original payload is not available (no payload from the collector)
+func (dm *DnsMessage) BuildDnsPayload() ([]byte, error) {
+	qtype, ok := dns.StringToType[dm.DNS.Qtype]
+	if !ok {
+		return nil, errors.New("unable to build dns payload, unknown qtype " + dm.DNS.Qtype)
+	}
+
+	msg := new(dns.Msg)
+	msg.Id = uint16(dm.DNS.Id)
+	msg.Opcode = dm.DNS.Opcode
+	msg.Question = []dns.Question{{Name: dns.Fqdn(dm.DNS.Qname), Qtype: qtype, Qclass: dns.ClassINET}}
+	if dm.DNS.Type == DnsReply {
+		msg.Response = true
+		if rcode, ok := dns.StringToRcode[dm.DNS.Rcode]; ok {
+			msg.Rcode = rcode
+		}
+	}
+	msg.Authoritative = dm.DNS.Flags.AA
+	msg.Truncated = dm.DNS.Flags.TC
+	msg.RecursionAvailable = dm.DNS.Flags.RA
+	msg.AuthenticatedData = dm.DNS.Flags.AD
+	msg.CheckingDisabled = dm.DNS.Flags.CD
+
+	return msg.Pack()
+}
+
+// ToPacketLayer rebuilds the packet layers from the dns payload and the network
+// metadata, the layers are returned in reverse order as expected by gopacket
 func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 	eth := &layers.Ethernet{
 		SrcMAC: net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
@@ -562,6 +1196,15 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 	// prepare ip
 	srcIp, srcPort, dstIp, dstPort := GetIpPort(dm)
 
+	// prepare dns payload, rebuilt from the question if missing
+	payload := dm.EncodeDnsPayload()
+	if len(payload) == 0 {
+		var err error
+		if payload, err = dm.BuildDnsPayload(); err != nil {
+			return nil, err
+		}
+	}
+
 	// packet layer array
 	pkt := []gopacket.SerializableLayer{}
 
@@ -592,11 +1235,11 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 		case PROTO_IPV4:
 			ip4.Protocol = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip4)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip4)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip4)
 		case PROTO_IPV6:
 			ip6.NextHeader = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip6)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip6)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip6)
 		}
 
 	// DNS over TCP
@@ -604,27 +1247,28 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 		tcp.SrcPort = layers.TCPPort(srcPort)
 		tcp.DstPort = layers.TCPPort(dstPort)
 		tcp.PSH = true
+		tcp.ACK = true
 		tcp.Window = 65535
 
 		// dns length
 		dnsLengthField := make([]byte, 2)
-		binary.BigEndian.PutUint16(dnsLengthField[0:], uint16(dm.DNS.Length))
+		binary.BigEndian.PutUint16(dnsLengthField[0:], uint16(len(payload)))
 
 		// update iplayer
 		switch dm.NetworkInfo.Family {
 		case PROTO_IPV4:
 			ip4.Protocol = layers.IPProtocolTCP
 			tcp.SetNetworkLayerForChecksum(ip4)
-			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, dm.DNS.Payload...)), tcp, ip4)
+			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, payload...)), tcp, ip4)
 		case PROTO_IPV6:
 			ip6.NextHeader = layers.IPProtocolTCP
 			tcp.SetNetworkLayerForChecksum(ip6)
-			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, dm.DNS.Payload...)), tcp, ip6)
+			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, payload...)), tcp, ip6)
 		}
 
-	// DNS over HTTPS and DNS over TLS
+	// DNS over HTTPS, DNS over TLS and DNS over QUIC
 	// These protocols are translated to DNS over UDP
-	case PROTO_DOH, PROTO_DOT:
+	case PROTO_DOH, PROTO_DOT, PROTO_DOQ:
 		udp.SrcPort = layers.UDPPort(srcPort)
 		udp.DstPort = layers.UDPPort(dstPort)
 
@@ -633,11 +1277,11 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 		case PROTO_IPV4:
 			ip4.Protocol = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip4)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip4)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip4)
 		case PROTO_IPV6:
 			ip6.NextHeader = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip6)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip6)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip6)
 		}
 
 	default:
@@ -656,7 +1300,12 @@ func (dm *DnsMessage) Flatten() (ret map[string]interface{}, err error) {
 		return
 	}
 	json.Unmarshal(tmp, &ret)
-	ret, err = flat.Flatten(ret, nil)
+	if ret, err = flat.Flatten(ret, nil); err != nil {
+		return
+	}
+	if dm.Fields != nil {
+		dm.Fields.Apply(ret)
+	}
 	return
 }
 
@@ -1,8 +1,17 @@
 package dnsutils
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/dmachard/go-dnstap-protobuf"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestDnsMessage_ToString(t *testing.T) {
@@ -51,3 +60,188 @@ func TestDnsMessage_TextBoundary(t *testing.T) {
 		t.Errorf("text dns message invalid; %s", line)
 	}
 }
+
+func TestDnsMessage_TextBoundary_AllFields(t *testing.T) {
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Rdata: "0 issue \"letsencrypt.org\""})
+
+	// the fields with the delimiter are enclosed by the boundary
+	line := dm.String([]string{"qname", "answer"}, " ", "\"")
+	if line != "- \"0 issue \\\"letsencrypt.org\\\"\"" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+
+	// boundary disabled
+	line = dm.String([]string{"qname", "answer"}, " ", "")
+	if line != "- 0 issue \"letsencrypt.org\"" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+}
+
+func TestDnsMessage_TextCustomDirective(t *testing.T) {
+	RegisterTextDirective("qname-length", func(dm *DnsMessage, directives []string) string {
+		return strconv.Itoa(len(dm.DNS.Qname))
+	})
+
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Qname = "dns.collector"
+
+	line := dm.String([]string{"qname", "qname-length"}, ";", "\"")
+	if line != "dns.collector;13" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+}
+
+func TestDnsMessage_TextExtraDirective(t *testing.T) {
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Qname = "dns.collector"
+	dm.Extra = map[string]interface{}{"owner": "it team", "rack": float64(12)}
+
+	line := dm.String([]string{"qname", "extra:owner", "extra:rack", "extra:unknown"}, " ", "\"")
+	if line != "dns.collector it_team 12 -" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+}
+
+func TestDnsMessage_TextListenerDirective(t *testing.T) {
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Qname = "dns.collector"
+
+	// no label, no listener
+	dm.SetListener("tap", "")
+	line := dm.String([]string{"qname", "listener-label"}, " ", "\"")
+	if line != "dns.collector -" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+
+	dm.SetListener("tap", "dc1")
+	line = dm.String([]string{"qname", "listener-collector", "listener-label"}, " ", "\"")
+	if line != "dns.collector tap dc1" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+}
+
+func TestDnsMessage_TextEventDirective(t *testing.T) {
+	dm := DnsMessage{}
+	dm.Init()
+
+	line := dm.String([]string{"operation", "event-type"}, " ", "\"")
+	if line != "- -" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+
+	dm.DnsTap.Operation = OPERATION_EVENT
+	dm.Event = &DnsEvent{Type: EVENT_CONNECT, Worker: "tap", Peer: "127.0.0.1:4000", Count: 1}
+	line = dm.String([]string{"operation", "event-type", "event-worker", "event-peer", "event-count", "event-message"}, " ", "\"")
+	if line != "EVENT connect tap 127.0.0.1:4000 1 -" {
+		t.Errorf("text dns message invalid; %s", line)
+	}
+}
+
+func TestDnsMessage_SchemaVersion(t *testing.T) {
+	dm := GetFakeDnsMessage()
+
+	data, err := json.Marshal(dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"schema-version":1,`) {
+		t.Errorf("schema version expected in json: %s", data)
+	}
+
+	// the version is kept by the field selection
+	flat, err := dm.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &FieldSelection{Keep: []string{"dns.qname"}}
+	fs.Apply(flat)
+	if _, ok := flat["schema-version"]; !ok || len(flat) != 2 {
+		t.Errorf("schema version expected in flat json: %v", flat)
+	}
+}
+
+func TestDnsMessage_ToDnstap(t *testing.T) {
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("www.DNS.collector.", dns.TypeA)
+	payload, _ := dnsmsg.Pack()
+
+	dm := GetFakeDnsMessage()
+	dm.DnsTap.Version = "1.0"
+	dm.DNS.Payload = payload
+	// qname updated by the transformers
+	dm.DNS.Qname = "dns.collector"
+
+	data, err := dm.ToDnstap()
+	if err != nil {
+		t.Fatalf("encode dnstap error: %s", err)
+	}
+
+	dt := &dnstap.Dnstap{}
+	if err := proto.Unmarshal(data, dt); err != nil {
+		t.Fatalf("decode dnstap error: %s", err)
+	}
+	if string(dt.GetIdentity()) != "collector" || string(dt.GetVersion()) != "1.0" {
+		t.Errorf("invalid identity or version: %s %s", dt.GetIdentity(), dt.GetVersion())
+	}
+	if dt.GetMessage().GetType().String() != "CLIENT_QUERY" {
+		t.Errorf("invalid operation: %s", dt.GetMessage().GetType())
+	}
+
+	query := new(dns.Msg)
+	if err := query.Unpack(dt.GetMessage().GetQueryMessage()); err != nil {
+		t.Fatalf("decode dns query error: %s", err)
+	}
+	if query.Question[0].Name != "dns.collector." {
+		t.Errorf("qname not rewritten in payload: %s", query.Question[0].Name)
+	}
+}
+
+func TestDnsMessage_ToPacketLayer(t *testing.T) {
+	for _, proto := range []string{PROTO_UDP, PROTO_TCP} {
+		t.Run(proto, func(t *testing.T) {
+			dm := GetFakeDnsMessage()
+			dm.NetworkInfo.Family = PROTO_IPV4
+			dm.NetworkInfo.Protocol = proto
+			dm.DNS.Id = 42
+
+			// no payload, the question is rebuilt from the message
+			pkt, err := dm.ToPacketLayer()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf := gopacket.NewSerializeBuffer()
+			opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+			for _, l := range pkt {
+				l.SerializeTo(buf, opts)
+			}
+
+			packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+			ip4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+			if !ok || ip4.SrcIP.String() != "1.2.3.4" || ip4.DstIP.String() != "4.3.2.1" {
+				t.Fatalf("invalid ip layer: %v", packet)
+			}
+
+			payload := packet.ApplicationLayer().Payload()
+			if proto == PROTO_TCP {
+				if int(binary.BigEndian.Uint16(payload[:2])) != len(payload)-2 {
+					t.Errorf("invalid dns length prefix")
+				}
+				payload = payload[2:]
+			}
+
+			msg := new(dns.Msg)
+			if err := msg.Unpack(payload); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Id != 42 || msg.Question[0].Name != "dns.collector." || msg.Question[0].Qtype != dns.TypeA {
+				t.Errorf("invalid dns payload: %s", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package dnsutils
+
+import (
+	"sync"
+)
+
+// types of the internal events logged by the monitoring collectors
+const (
+	EVENT_CONNECT      = "connect"
+	EVENT_DISCONNECT   = "disconnect"
+	EVENT_DECODE_ERROR = "decode-error"
+	EVENT_DROPPED      = "dropped"
+	EVENT_RELOAD       = "reload"
+)
+
+// DnsEvent is an internal event of a worker (connection, decoding error, dropped messages,
+// configuration reload), logged as a record by the monitoring collectors
+type DnsEvent struct {
+	Type    string `json:"type" msgpack:"type"`
+	Worker  string `json:"worker" msgpack:"worker"`
+	Peer    string `json:"peer" msgpack:"peer"`
+	Count   int    `json:"count" msgpack:"count"`
+	Message string `json:"message" msgpack:"message"`
+}
+
+var (
+	eventsLock        sync.RWMutex
+	eventsSubscribers []chan DnsEvent
+)
+
+// SubscribeEvents returns a new channel receiving the events emitted by the workers
+func SubscribeEvents(size int) chan DnsEvent {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+
+	ch := make(chan DnsEvent, size)
+	eventsSubscribers = append(eventsSubscribers, ch)
+	return ch
+}
+
+// UnsubscribeEvents stops sending the events to the channel, the channel can be closed after
+func UnsubscribeEvents(ch chan DnsEvent) {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+
+	for i := range eventsSubscribers {
+		if eventsSubscribers[i] == ch {
+			eventsSubscribers = append(eventsSubscribers[:i], eventsSubscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// EmitEvent sends the event to the subscribers without waiting, the event is lost if
+// a channel is full so the processing of the dns messages is never slowed down
+func EmitEvent(event DnsEvent) {
+	eventsLock.RLock()
+	defer eventsLock.RUnlock()
+
+	for _, ch := range eventsSubscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package dnsutils
+
+import (
+	"testing"
+)
+
+func TestEmitEvent(t *testing.T) {
+	// no subscriber, the event is lost
+	EmitEvent(DnsEvent{Type: EVENT_RELOAD, Worker: "main"})
+
+	ch := SubscribeEvents(1)
+	EmitEvent(DnsEvent{Type: EVENT_CONNECT, Worker: "tap"})
+
+	// the channel is full, the event is dropped without blocking
+	EmitEvent(DnsEvent{Type: EVENT_DISCONNECT, Worker: "tap"})
+	if len(ch) != 1 {
+		t.Fatalf("one event expected, got %d", len(ch))
+	}
+	if event := <-ch; event.Type != EVENT_CONNECT || event.Worker != "tap" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	UnsubscribeEvents(ch)
+	EmitEvent(DnsEvent{Type: EVENT_CONNECT, Worker: "tap"})
+	if len(ch) != 0 {
+		t.Errorf("no event expected after unsubscribe")
+	}
+}
@@ -0,0 +1,193 @@
+package dnsutils
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	outputsLock sync.Mutex
+	outputs     = make(map[string]*Output)
+)
+
+// GetOutputsDropped returns the number of messages dropped by each output with an overflow policy
+func GetOutputsDropped() map[string]uint64 {
+	outputsLock.Lock()
+	defer outputsLock.Unlock()
+
+	dropped := make(map[string]uint64)
+	for name, o := range outputs {
+		dropped[name] = o.Dropped()
+	}
+	return dropped
+}
+
+func IsValidOverflowPolicy(policy string) bool {
+	return policy == OVERFLOW_BLOCK || policy == OVERFLOW_DROP_NEWEST || policy == OVERFLOW_DROP_OLDEST
+}
+
+// overflowQueue forwards the values of its input channel to the output one, the values are
+// buffered when the output is full and dropped according to the policy when the buffer is full
+type overflowQueue[T any] struct {
+	input   chan T
+	output  chan T
+	policy  string
+	count   func(T) int
+	buffer  []T
+	head    int
+	length  int
+	dropped uint64
+	done    chan bool
+}
+
+func newOverflowQueue[T any](output chan T, size int, policy string, count func(T) int) *overflowQueue[T] {
+	q := &overflowQueue[T]{
+		input:  make(chan T, 512),
+		output: output,
+		policy: policy,
+		count:  count,
+		buffer: make([]T, size),
+		done:   make(chan bool),
+	}
+	go q.run()
+	return q
+}
+
+func (q *overflowQueue[T]) push(v T) {
+	q.buffer[(q.head+q.length)%len(q.buffer)] = v
+	q.length++
+}
+
+func (q *overflowQueue[T]) pop() {
+	var zero T
+	q.buffer[q.head] = zero
+	q.head = (q.head + 1) % len(q.buffer)
+	q.length--
+}
+
+func (q *overflowQueue[T]) run() {
+	for {
+		// stop reading the input when the buffer is full in block mode
+		input := q.input
+		if q.length == len(q.buffer) && q.policy == OVERFLOW_BLOCK {
+			input = nil
+		}
+		var output chan T
+		var next T
+		if q.length > 0 {
+			output = q.output
+			next = q.buffer[q.head]
+		}
+
+		select {
+		case v, opened := <-input:
+			if !opened {
+				// send the pending values before to exit
+				for q.length > 0 {
+					q.output <- q.buffer[q.head]
+					q.pop()
+				}
+				q.done <- true
+				return
+			}
+			if q.length == len(q.buffer) {
+				if q.policy == OVERFLOW_DROP_NEWEST {
+					atomic.AddUint64(&q.dropped, uint64(q.count(v)))
+					continue
+				}
+				atomic.AddUint64(&q.dropped, uint64(q.count(q.buffer[q.head])))
+				q.pop()
+			}
+			q.push(v)
+
+		case output <- next:
+			q.pop()
+		}
+	}
+}
+
+func (q *overflowQueue[T]) stop() {
+	close(q.input)
+	<-q.done
+}
+
+// Output wraps a logger to apply an overflow policy on its channel, the messages are buffered
+// when the logger is too slow and dropped according to the policy, so a slow logger does not
+// block the collectors and the other loggers
+type Output struct {
+	Worker
+	queue      *overflowQueue[DnsMessage]
+	batchQueue *overflowQueue[[]DnsMessage]
+}
+
+// BatchOutput is the output of a logger receiving slices of messages
+type BatchOutput struct {
+	*Output
+}
+
+func (o *BatchOutput) BatchChannel() chan []DnsMessage {
+	return o.batchQueue.input
+}
+
+// NewOutput returns the logger wrapped with the overflow policy, the logger is returned
+// as is with the default settings
+func NewOutput(w Worker, size int, policy string) (Worker, error) {
+	if len(policy) == 0 {
+		policy = OVERFLOW_BLOCK
+	}
+	if !IsValidOverflowPolicy(policy) {
+		return nil, fmt.Errorf("invalid overflow policy: %s", policy)
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid channel size: %d", size)
+	}
+	if size == 0 && policy == OVERFLOW_BLOCK {
+		return w, nil
+	}
+	if size == 0 {
+		size = 512
+	}
+
+	o := &Output{
+		Worker: w,
+		queue:  newOverflowQueue(w.Channel(), size, policy, func(DnsMessage) int { return 1 }),
+	}
+
+	outputsLock.Lock()
+	outputs[w.GetName()] = o
+	outputsLock.Unlock()
+
+	if bw, ok := w.(BatchWorker); ok {
+		o.batchQueue = newOverflowQueue(bw.BatchChannel(), size, policy, func(batch []DnsMessage) int { return len(batch) })
+		return &BatchOutput{Output: o}, nil
+	}
+	return o, nil
+}
+
+func (o *Output) Channel() chan DnsMessage {
+	return o.queue.input
+}
+
+// Dropped returns the number of messages dropped because the logger was full
+func (o *Output) Dropped() uint64 {
+	dropped := atomic.LoadUint64(&o.queue.dropped)
+	if o.batchQueue != nil {
+		dropped += atomic.LoadUint64(&o.batchQueue.dropped)
+	}
+	return dropped
+}
+
+// Stop sends the pending messages to the logger then stops it
+func (o *Output) Stop() {
+	o.queue.stop()
+	if o.batchQueue != nil {
+		o.batchQueue.stop()
+	}
+
+	outputsLock.Lock()
+	delete(outputs, o.GetName())
+	outputsLock.Unlock()
+
+	o.Worker.Stop()
+}
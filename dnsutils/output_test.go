@@ -0,0 +1,79 @@
+package dnsutils
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeWorker struct {
+	name    string
+	channel chan DnsMessage
+}
+
+func (w *fakeWorker) SetLoggers(loggers []Worker) {}
+func (w *fakeWorker) GetName() string             { return w.name }
+func (w *fakeWorker) Stop()                       {}
+func (w *fakeWorker) Run()                        {}
+func (w *fakeWorker) Channel() chan DnsMessage    { return w.channel }
+func (w *fakeWorker) ReadConfig()                 {}
+func (w *fakeWorker) ReloadConfig(config *Config) {}
+
+func TestOutput_Default(t *testing.T) {
+	w := &fakeWorker{name: "test", channel: make(chan DnsMessage, 1)}
+	o, err := NewOutput(w, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != w {
+		t.Errorf("the worker should not be wrapped with the default settings")
+	}
+
+	if _, err := NewOutput(w, 0, "drop-all"); err == nil {
+		t.Errorf("invalid policy not detected")
+	}
+}
+
+func TestOutput_OverflowPolicy(t *testing.T) {
+	testcases := []struct {
+		policy string
+		want   []int
+	}{
+		{policy: OVERFLOW_DROP_NEWEST, want: []int{0, 1}},
+		{policy: OVERFLOW_DROP_OLDEST, want: []int{8, 9}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.policy, func(t *testing.T) {
+			// the logger is blocked
+			w := &fakeWorker{name: tc.policy, channel: make(chan DnsMessage)}
+			o, err := NewOutput(w, 2, tc.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the collector is never blocked
+			for i := 0; i < 10; i++ {
+				dm := GetFakeDnsMessage()
+				dm.DNS.Id = i
+				o.Channel() <- dm
+			}
+
+			// wait until all messages are handled
+			for GetOutputsDropped()[tc.policy] != 8 {
+				time.Sleep(time.Millisecond)
+			}
+
+			// unblock the logger, only the buffered messages are received
+			ids := []int{}
+			for i := 0; i < 2; i++ {
+				dm := <-w.channel
+				ids = append(ids, dm.DNS.Id)
+			}
+			o.Stop()
+
+			if ids[0] != tc.want[0] || ids[1] != tc.want[1] {
+				t.Errorf("invalid messages received, want %v, got %v", tc.want, ids)
+			}
+		})
+	}
+}
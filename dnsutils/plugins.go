@@ -0,0 +1,123 @@
+package dnsutils
+
+import (
+	"bytes"
+	"fmt"
+	"plugin"
+	"sync"
+
+	"github.com/dmachard/go-logger"
+	"gopkg.in/yaml.v3"
+)
+
+// WorkerFactory creates the collector or the logger of a plugin, the parameters are the
+// keys of its config section. The loggers of a collector are provided later with SetLoggers.
+type WorkerFactory func(config *Config, logger *logger.Logger, name string, params map[string]interface{}) (Worker, error)
+
+// Subprocessor is a transformer provided by a plugin, Process returns one of the return codes
+// of the transformers package and Stop is called when the transformers are reset
+type Subprocessor interface {
+	Process(dm *DnsMessage) int
+	Stop()
+}
+
+// SubprocessorFactory creates the subprocessor of a plugin, the parameters are the keys of its config
+// section. The messages generated by the subprocessor are sent to the output channels.
+type SubprocessorFactory func(params map[string]interface{}, logger *logger.Logger, name string, outChannels []chan DnsMessage) (Subprocessor, error)
+
+// collectors, loggers and subprocessors registered by name, by the plugins or by the packages of the repository
+var (
+	pluginsMutex        sync.Mutex
+	collectorPlugins    = make(map[string]WorkerFactory)
+	loggerPlugins       = make(map[string]WorkerFactory)
+	subprocessorPlugins = make(map[string]SubprocessorFactory)
+)
+
+// RegisterCollector makes a collector available with the plugin section of the collectors
+func RegisterCollector(name string, factory WorkerFactory) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	collectorPlugins[name] = factory
+}
+
+// RegisterLogger makes a logger available with the plugin section of the loggers
+func RegisterLogger(name string, factory WorkerFactory) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	loggerPlugins[name] = factory
+}
+
+// RegisterSubprocessor makes a subprocessor available with the plugins list of the transformers
+func RegisterSubprocessor(name string, factory SubprocessorFactory) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	subprocessorPlugins[name] = factory
+}
+
+func GetCollectorPlugin(name string) (WorkerFactory, bool) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	factory, ok := collectorPlugins[name]
+	return factory, ok
+}
+
+func GetLoggerPlugin(name string) (WorkerFactory, bool) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	factory, ok := loggerPlugins[name]
+	return factory, ok
+}
+
+func GetSubprocessorPlugin(name string) (SubprocessorFactory, bool) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	factory, ok := subprocessorPlugins[name]
+	return factory, ok
+}
+
+// plugins files already loaded, a go plugin can't be loaded twice
+var loadedPlugins = make(map[string]bool)
+
+// LoadPlugins opens the go plugins and calls their Register function, the plugins
+// register their collectors, loggers and subprocessors with it
+func LoadPlugins(paths []string) error {
+	for _, path := range paths {
+		pluginsMutex.Lock()
+		loaded := loadedPlugins[path]
+		pluginsMutex.Unlock()
+		if loaded {
+			continue
+		}
+
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+		symbol, err := p.Lookup("Register")
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+		register, ok := symbol.(func())
+		if !ok {
+			return fmt.Errorf("plugin %s: Register must be a func()", path)
+		}
+		register()
+
+		pluginsMutex.Lock()
+		loadedPlugins[path] = true
+		pluginsMutex.Unlock()
+	}
+	return nil
+}
+
+// DecodePluginParams decodes the parameters of a plugin into the struct provided,
+// with the yaml tags of the struct, the unknown parameters are reported
+func DecodePluginParams(params map[string]interface{}, out interface{}) error {
+	data, err := yaml.Marshal(params)
+	if err != nil {
+		return err
+	}
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.KnownFields(true)
+	return d.Decode(out)
+}
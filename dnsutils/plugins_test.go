@@ -0,0 +1,52 @@
+package dnsutils
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-logger"
+)
+
+type fakeSubprocessor struct{}
+
+func (s *fakeSubprocessor) Process(dm *DnsMessage) int { return 1 }
+func (s *fakeSubprocessor) Stop()                      {}
+
+func TestPlugins_Register(t *testing.T) {
+	RegisterSubprocessor("test", func(params map[string]interface{}, logger *logger.Logger, name string,
+		outChannels []chan DnsMessage) (Subprocessor, error) {
+		return &fakeSubprocessor{}, nil
+	})
+
+	if _, ok := GetSubprocessorPlugin("test"); !ok {
+		t.Errorf("subprocessor not registered")
+	}
+	if _, ok := GetCollectorPlugin("test"); ok {
+		t.Errorf("no collector expected")
+	}
+}
+
+func TestPlugins_DecodeParams(t *testing.T) {
+	params := struct {
+		Command []string `yaml:"command"`
+		Mode    string   `yaml:"mode"`
+	}{}
+
+	err := DecodePluginParams(map[string]interface{}{"command": []string{"cat"}, "mode": "json"}, &params)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if len(params.Command) != 1 || params.Command[0] != "cat" || params.Mode != "json" {
+		t.Errorf("invalid params: %v", params)
+	}
+
+	// unknown parameter
+	if err := DecodePluginParams(map[string]interface{}{"unknown": 1}, &params); err == nil {
+		t.Errorf("error expected for an unknown parameter")
+	}
+}
+
+func TestPlugins_LoadError(t *testing.T) {
+	if err := LoadPlugins([]string{"/tmp/notfound.so"}); err == nil {
+		t.Errorf("error expected for a missing plugin")
+	}
+}
@@ -0,0 +1,34 @@
+package dnsutils
+
+import (
+	"sync"
+)
+
+// size of the buffers allocated by the pool, enough for most of the dnstap frames
+const poolBufferSize = 4096
+
+var buffersPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, poolBufferSize)
+		return &b
+	},
+}
+
+// GetBuffer returns a buffer of this size from the pool, a new one is allocated
+// if the size is greater than the size of the buffers of the pool
+func GetBuffer(size int) []byte {
+	if size > poolBufferSize {
+		return make([]byte, size)
+	}
+	b := buffersPool.Get().(*[]byte)
+	return (*b)[:size]
+}
+
+// PutBuffer gives back the buffer to the pool, the buffer must not be used anymore
+func PutBuffer(b []byte) {
+	if cap(b) != poolBufferSize {
+		return
+	}
+	b = b[:poolBufferSize]
+	buffersPool.Put(&b)
+}
@@ -0,0 +1,27 @@
+package dnsutils
+
+import (
+	"testing"
+)
+
+func TestPool_GetBuffer(t *testing.T) {
+	b := GetBuffer(100)
+	if len(b) != 100 || cap(b) != poolBufferSize {
+		t.Errorf("invalid buffer from the pool: %d %d", len(b), cap(b))
+	}
+	PutBuffer(b)
+
+	// too large for the pool
+	b = GetBuffer(poolBufferSize + 1)
+	if len(b) != poolBufferSize+1 {
+		t.Errorf("invalid buffer size: %d", len(b))
+	}
+	PutBuffer(b)
+}
+
+func BenchmarkPool_GetBuffer(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		buf := GetBuffer(512)
+		PutBuffer(buf)
+	}
+}
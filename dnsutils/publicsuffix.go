@@ -0,0 +1,196 @@
+package dnsutils
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	pslRuleNormal = iota
+	pslRuleWildcard
+	pslRuleException
+)
+
+type pslRule struct {
+	kind  int
+	icann bool
+}
+
+// PublicSuffixList is a public suffix list loaded from a file in the format of publicsuffix.org
+type PublicSuffixList struct {
+	rules map[string]pslRule
+}
+
+var (
+	pslLock    sync.RWMutex
+	pslList    *PublicSuffixList
+	pslModTime time.Time
+)
+
+// ParsePublicSuffixList reads the rules of the list, the rules after the private domains
+// marker are not managed by the ICANN
+func ParsePublicSuffixList(fd *os.File) (*PublicSuffixList, error) {
+	l := &PublicSuffixList{rules: make(map[string]pslRule)}
+	icann := true
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "//") {
+			if strings.Contains(line, "===BEGIN ICANN DOMAINS===") {
+				icann = true
+			} else if strings.Contains(line, "===BEGIN PRIVATE DOMAINS===") {
+				icann = false
+			}
+			continue
+		}
+		// the rule is the first field of the line
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rule := strings.ToLower(fields[0])
+
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			l.rules[rule[1:]] = pslRule{kind: pslRuleException, icann: icann}
+		case strings.HasPrefix(rule, "*."):
+			l.rules[rule[2:]] = pslRule{kind: pslRuleWildcard, icann: icann}
+		default:
+			// a wildcard rule is kept if the domain is also a suffix
+			if _, exists := l.rules[rule]; !exists {
+				l.rules[rule] = pslRule{kind: pslRuleNormal, icann: icann}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(l.rules) == 0 {
+		return nil, errors.New("no rule found in the public suffix list")
+	}
+	return l, nil
+}
+
+// PublicSuffix returns the public suffix of the domain and true if it is managed by the ICANN,
+// the last label is the public suffix if no rule matches
+func (l *PublicSuffixList) PublicSuffix(domain string) (string, bool) {
+	labels := strings.Split(domain, ".")
+
+	// the longest suffix matching a rule is searched first
+	for i := range labels {
+		suffix := strings.Join(labels[i:], ".")
+		if rule, ok := l.rules[suffix]; ok {
+			if rule.kind == pslRuleException {
+				return strings.Join(labels[i+1:], "."), rule.icann
+			}
+			if rule.kind == pslRuleNormal {
+				return suffix, rule.icann
+			}
+		}
+		if i+1 < len(labels) {
+			if rule, ok := l.rules[strings.Join(labels[i+1:], ".")]; ok && rule.kind == pslRuleWildcard {
+				return suffix, rule.icann
+			}
+		}
+		// a wildcard rule is also a suffix for the domain itself
+		if rule, ok := l.rules[suffix]; ok && rule.kind == pslRuleWildcard {
+			return suffix, rule.icann
+		}
+	}
+	return labels[len(labels)-1], false
+}
+
+// LoadPublicSuffixFile loads the public suffix list used by the transformers instead of the
+// list embedded at build time
+func LoadPublicSuffixFile(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	fileinfo, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	l, err := ParsePublicSuffixList(fd)
+	if err != nil {
+		return err
+	}
+
+	pslLock.Lock()
+	defer pslLock.Unlock()
+	pslList, pslModTime = l, fileinfo.ModTime()
+	return nil
+}
+
+// RefreshPublicSuffixFile loads the public suffix list again if the file has been modified,
+// true is returned if reloaded
+func RefreshPublicSuffixFile(path string) (bool, error) {
+	fileinfo, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	pslLock.RLock()
+	modified := !fileinfo.ModTime().Equal(pslModTime)
+	pslLock.RUnlock()
+	if !modified {
+		return false, nil
+	}
+	return true, LoadPublicSuffixFile(path)
+}
+
+// ResetPublicSuffixList uses again the list embedded at build time
+func ResetPublicSuffixList() {
+	pslLock.Lock()
+	defer pslLock.Unlock()
+	pslList, pslModTime = nil, time.Time{}
+}
+
+// GetPublicSuffix returns the public suffix (effective tld) of the domain and true if it is managed
+// by the ICANN, the domain must be in lowercase without ending dot
+func GetPublicSuffix(domain string) (string, bool) {
+	pslLock.RLock()
+	l := pslList
+	pslLock.RUnlock()
+
+	if l == nil {
+		return publicsuffix.PublicSuffix(domain)
+	}
+	return l.PublicSuffix(domain)
+}
+
+// GetEffectiveTLDPlusOne returns the registrable domain, the public suffix and one more label
+func GetEffectiveTLDPlusOne(domain string) (string, error) {
+	if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return "", errors.New("empty label in domain " + domain)
+	}
+
+	suffix, _ := GetPublicSuffix(domain)
+	if len(domain) <= len(suffix) {
+		return "", errors.New("cannot derive eTLD+1 for domain " + domain)
+	}
+	i := len(domain) - len(suffix) - 1
+	if domain[i] != '.' {
+		return "", errors.New("invalid public suffix " + suffix + " for domain " + domain)
+	}
+	return domain[1+strings.LastIndex(domain[:i], "."):], nil
+}
+
+// GetSubdomainDepth returns the number of labels before the registrable domain, zero if the
+// domain is a public suffix or is registrable
+func GetSubdomainDepth(domain string) int {
+	registrable, err := GetEffectiveTLDPlusOne(domain)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(domain, ".") - strings.Count(registrable, ".")
+}
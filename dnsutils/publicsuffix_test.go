@@ -0,0 +1,81 @@
+package dnsutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPublicSuffixList = `// ===BEGIN ICANN DOMAINS===
+com
+uk
+co.uk
+*.ck
+!www.ck
+// ===END ICANN DOMAINS===
+// ===BEGIN PRIVATE DOMAINS===
+github.io
+// ===END PRIVATE DOMAINS===
+`
+
+func TestPublicSuffixList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "public_suffix_list.dat")
+	if err := os.WriteFile(path, []byte(testPublicSuffixList), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPublicSuffixFile(path); err != nil {
+		t.Fatal(err)
+	}
+	defer ResetPublicSuffixList()
+
+	testcases := []struct {
+		domain      string
+		suffix      string
+		icann       bool
+		registrable string
+		depth       int
+	}{
+		{domain: "www.example.com", suffix: "com", icann: true, registrable: "example.com", depth: 1},
+		{domain: "a.b.amazon.co.uk", suffix: "co.uk", icann: true, registrable: "amazon.co.uk", depth: 2},
+		{domain: "www.foo.ck", suffix: "foo.ck", icann: true, registrable: "www.foo.ck", depth: 0},
+		{domain: "www.ck", suffix: "ck", icann: true, registrable: "www.ck", depth: 0},
+		{domain: "user.github.io", suffix: "github.io", icann: false, registrable: "user.github.io", depth: 0},
+		{domain: "www.example.local", suffix: "local", icann: false, registrable: "example.local", depth: 1},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.domain, func(t *testing.T) {
+			if suffix, icann := GetPublicSuffix(tc.domain); suffix != tc.suffix || icann != tc.icann {
+				t.Errorf("invalid public suffix: %s %v", suffix, icann)
+			}
+			if registrable, _ := GetEffectiveTLDPlusOne(tc.domain); registrable != tc.registrable {
+				t.Errorf("invalid registrable domain: %s", registrable)
+			}
+			if depth := GetSubdomainDepth(tc.domain); depth != tc.depth {
+				t.Errorf("invalid subdomain depth: %d", depth)
+			}
+		})
+	}
+
+	// the list is loaded again only when the file is updated
+	if reloaded, err := RefreshPublicSuffixFile(path); err != nil || reloaded {
+		t.Errorf("no reload expected: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("com\nexample.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if reloaded, err := RefreshPublicSuffixFile(path); err != nil || !reloaded {
+		t.Errorf("reload expected: %v", err)
+	}
+	if registrable, _ := GetEffectiveTLDPlusOne("www.example.com"); registrable != "www.example.com" {
+		t.Errorf("invalid registrable domain after reload: %s", registrable)
+	}
+
+	// the embedded list is used again after a reset
+	ResetPublicSuffixList()
+	if suffix, _ := GetPublicSuffix("books.amazon.co.uk"); suffix != "co.uk" {
+		t.Errorf("invalid public suffix with the embedded list: %s", suffix)
+	}
+}
@@ -0,0 +1,20 @@
+package dnsutils
+
+// SendConfig hands the new config over to a worker without waiting, the channel must be
+// buffered. A config not yet read by the worker is replaced, only the last one is applied.
+// The reload and the shutdown are not blocked by a worker stuck on a full channel
+func SendConfig(ch chan *Config, config *Config) {
+	for {
+		select {
+		case ch <- config:
+			return
+		default:
+		}
+
+		// drop the pending config
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
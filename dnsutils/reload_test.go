@@ -0,0 +1,18 @@
+package dnsutils
+
+import (
+	"testing"
+)
+
+func TestSendConfig_ReplacePending(t *testing.T) {
+	ch := make(chan *Config, 1)
+
+	// the worker doesn't read the channel, the last config is kept
+	first, last := GetFakeConfig(), GetFakeConfig()
+	SendConfig(ch, first)
+	SendConfig(ch, last)
+
+	if len(ch) != 1 || <-ch != last {
+		t.Errorf("the last config is expected")
+	}
+}
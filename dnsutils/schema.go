@@ -0,0 +1,589 @@
+package dnsutils
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hamba/avro"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// stable schemas of the dns messages for the protobuf and avro modes,
+// only the core fields are part of the schemas, not the transformers ones
+var (
+	//go:embed schema/dnsmessage.proto
+	ProtobufSchemaDefinition string
+	//go:embed schema/dnsmessage.avsc
+	AvroSchemaDefinition string
+
+	AvroSchema = avro.MustParse(AvroSchemaDefinition)
+)
+
+type RecordDnstap struct {
+	Operation    string  `avro:"operation"`
+	Identity     string  `avro:"identity"`
+	Version      string  `avro:"version"`
+	TimeSec      int64   `avro:"time_sec"`
+	TimeNsec     int64   `avro:"time_nsec"`
+	Latency      float64 `avro:"latency"`
+	Extra        string  `avro:"extra"`
+	PolicyType   string  `avro:"policy_type"`
+	PolicyRule   string  `avro:"policy_rule"`
+	PolicyAction string  `avro:"policy_action"`
+	PolicyMatch  string  `avro:"policy_match"`
+	PolicyValue  string  `avro:"policy_value"`
+}
+
+type RecordNetwork struct {
+	Family         string `avro:"family"`
+	Protocol       string `avro:"protocol"`
+	QueryIp        string `avro:"query_ip"`
+	QueryPort      int    `avro:"query_port"`
+	ResponseIp     string `avro:"response_ip"`
+	ResponsePort   int    `avro:"response_port"`
+	IpDefragmented bool   `avro:"ip_defragmented"`
+	TcpReassembled bool   `avro:"tcp_reassembled"`
+}
+
+type RecordFlags struct {
+	QR bool `avro:"qr"`
+	TC bool `avro:"tc"`
+	AA bool `avro:"aa"`
+	RA bool `avro:"ra"`
+	AD bool `avro:"ad"`
+	CD bool `avro:"cd"`
+}
+
+type RecordRR struct {
+	Name      string `avro:"name"`
+	Rdatatype string `avro:"rdatatype"`
+	Ttl       int    `avro:"ttl"`
+	Rdata     string `avro:"rdata"`
+}
+
+type RecordDns struct {
+	Type            string      `avro:"type"`
+	Length          int         `avro:"length"`
+	Id              int         `avro:"id"`
+	Opcode          int         `avro:"opcode"`
+	Rcode           string      `avro:"rcode"`
+	Qname           string      `avro:"qname"`
+	Qtype           string      `avro:"qtype"`
+	Flags           RecordFlags `avro:"flags"`
+	Answers         []RecordRR  `avro:"answers"`
+	Nameservers     []RecordRR  `avro:"nameservers"`
+	Records         []RecordRR  `avro:"records"`
+	MalformedPacket bool        `avro:"malformed_packet"`
+}
+
+type RecordEdnsOption struct {
+	Code int    `avro:"code"`
+	Name string `avro:"name"`
+	Data string `avro:"data"`
+}
+
+type RecordEdns struct {
+	UdpSize  int                `avro:"udp_size"`
+	Rcode    int                `avro:"rcode"`
+	Version  int                `avro:"version"`
+	DnssecOk bool               `avro:"dnssec_ok"`
+	Options  []RecordEdnsOption `avro:"options"`
+}
+
+// DnsRecord is the dns message according to the protobuf and avro schemas
+type DnsRecord struct {
+	Dnstap  RecordDnstap  `avro:"dnstap"`
+	Network RecordNetwork `avro:"network"`
+	Dns     RecordDns     `avro:"dns"`
+	Edns    RecordEdns    `avro:"edns"`
+}
+
+func toRecordRRs(rrs []DnsAnswer) []RecordRR {
+	records := make([]RecordRR, 0, len(rrs))
+	for _, rr := range rrs {
+		records = append(records, RecordRR{Name: rr.Name, Rdatatype: rr.Rdatatype, Ttl: rr.Ttl, Rdata: rr.Rdata})
+	}
+	return records
+}
+
+// ToRecord converts the dns message to the record of the protobuf and avro schemas
+func (dm *DnsMessage) ToRecord() DnsRecord {
+	queryPort, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
+	responsePort, _ := strconv.Atoi(dm.NetworkInfo.ResponsePort)
+
+	options := make([]RecordEdnsOption, 0, len(dm.EDNS.Options))
+	for _, opt := range dm.EDNS.Options {
+		options = append(options, RecordEdnsOption{Code: opt.Code, Name: opt.Name, Data: opt.Data})
+	}
+
+	return DnsRecord{
+		Dnstap: RecordDnstap{
+			Operation:    dm.DnsTap.Operation,
+			Identity:     dm.DnsTap.Identity,
+			Version:      dm.DnsTap.Version,
+			TimeSec:      int64(dm.DnsTap.TimeSec),
+			TimeNsec:     int64(dm.DnsTap.TimeNsec),
+			Latency:      dm.DnsTap.Latency,
+			Extra:        dm.DnsTap.Extra,
+			PolicyType:   dm.DnsTap.PolicyType,
+			PolicyRule:   dm.DnsTap.PolicyRule,
+			PolicyAction: dm.DnsTap.PolicyAction,
+			PolicyMatch:  dm.DnsTap.PolicyMatch,
+			PolicyValue:  dm.DnsTap.PolicyValue,
+		},
+		Network: RecordNetwork{
+			Family:         dm.NetworkInfo.Family,
+			Protocol:       dm.NetworkInfo.Protocol,
+			QueryIp:        dm.NetworkInfo.QueryIp,
+			QueryPort:      queryPort,
+			ResponseIp:     dm.NetworkInfo.ResponseIp,
+			ResponsePort:   responsePort,
+			IpDefragmented: dm.NetworkInfo.IpDefragmented,
+			TcpReassembled: dm.NetworkInfo.TcpReassembled,
+		},
+		Dns: RecordDns{
+			Type:   dm.DNS.Type,
+			Length: dm.DNS.Length,
+			Id:     dm.DNS.Id,
+			Opcode: dm.DNS.Opcode,
+			Rcode:  dm.DNS.Rcode,
+			Qname:  dm.DNS.Qname,
+			Qtype:  dm.DNS.Qtype,
+			Flags: RecordFlags{
+				QR: dm.DNS.Flags.QR,
+				TC: dm.DNS.Flags.TC,
+				AA: dm.DNS.Flags.AA,
+				RA: dm.DNS.Flags.RA,
+				AD: dm.DNS.Flags.AD,
+				CD: dm.DNS.Flags.CD,
+			},
+			Answers:         toRecordRRs(dm.DNS.DnsRRs.Answers),
+			Nameservers:     toRecordRRs(dm.DNS.DnsRRs.Nameservers),
+			Records:         toRecordRRs(dm.DNS.DnsRRs.Records),
+			MalformedPacket: dm.DNS.MalformedPacket,
+		},
+		Edns: RecordEdns{
+			UdpSize:  dm.EDNS.UdpSize,
+			Rcode:    dm.EDNS.ExtendedRcode,
+			Version:  dm.EDNS.Version,
+			DnssecOk: dm.EDNS.Do == 1,
+			Options:  options,
+		},
+	}
+}
+
+// ToAvro encodes the dns message in the avro binary format, without the schema
+func (dm *DnsMessage) ToAvro() ([]byte, error) {
+	return avro.Marshal(AvroSchema, dm.ToRecord())
+}
+
+// ToProtobuf encodes the dns message according to the protobuf schema
+func (dm *DnsMessage) ToProtobuf() ([]byte, error) {
+	return dm.ToRecord().MarshalProto(), nil
+}
+
+// protobuf helpers, the default values are not encoded as in proto3
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendProtoVarint(b, num, 1)
+}
+
+func appendProtoDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func (r RecordRR) MarshalProto() []byte {
+	var b []byte
+	b = appendProtoString(b, 1, r.Name)
+	b = appendProtoString(b, 2, r.Rdatatype)
+	b = appendProtoVarint(b, 3, uint64(uint32(r.Ttl)))
+	b = appendProtoString(b, 4, r.Rdata)
+	return b
+}
+
+func (r DnsRecord) MarshalProto() []byte {
+	var dnstap []byte
+	dnstap = appendProtoString(dnstap, 1, r.Dnstap.Operation)
+	dnstap = appendProtoString(dnstap, 2, r.Dnstap.Identity)
+	dnstap = appendProtoString(dnstap, 3, r.Dnstap.Version)
+	dnstap = appendProtoVarint(dnstap, 4, uint64(r.Dnstap.TimeSec))
+	dnstap = appendProtoVarint(dnstap, 5, uint64(r.Dnstap.TimeNsec))
+	dnstap = appendProtoDouble(dnstap, 6, r.Dnstap.Latency)
+	dnstap = appendProtoString(dnstap, 7, r.Dnstap.Extra)
+	dnstap = appendProtoString(dnstap, 8, r.Dnstap.PolicyType)
+	dnstap = appendProtoString(dnstap, 9, r.Dnstap.PolicyRule)
+	dnstap = appendProtoString(dnstap, 10, r.Dnstap.PolicyAction)
+	dnstap = appendProtoString(dnstap, 11, r.Dnstap.PolicyMatch)
+	dnstap = appendProtoString(dnstap, 12, r.Dnstap.PolicyValue)
+
+	var network []byte
+	network = appendProtoString(network, 1, r.Network.Family)
+	network = appendProtoString(network, 2, r.Network.Protocol)
+	network = appendProtoString(network, 3, r.Network.QueryIp)
+	network = appendProtoVarint(network, 4, uint64(r.Network.QueryPort))
+	network = appendProtoString(network, 5, r.Network.ResponseIp)
+	network = appendProtoVarint(network, 6, uint64(r.Network.ResponsePort))
+	network = appendProtoBool(network, 7, r.Network.IpDefragmented)
+	network = appendProtoBool(network, 8, r.Network.TcpReassembled)
+
+	var flags []byte
+	flags = appendProtoBool(flags, 1, r.Dns.Flags.QR)
+	flags = appendProtoBool(flags, 2, r.Dns.Flags.TC)
+	flags = appendProtoBool(flags, 3, r.Dns.Flags.AA)
+	flags = appendProtoBool(flags, 4, r.Dns.Flags.RA)
+	flags = appendProtoBool(flags, 5, r.Dns.Flags.AD)
+	flags = appendProtoBool(flags, 6, r.Dns.Flags.CD)
+
+	var dns []byte
+	dns = appendProtoString(dns, 1, r.Dns.Type)
+	dns = appendProtoVarint(dns, 2, uint64(r.Dns.Length))
+	dns = appendProtoVarint(dns, 3, uint64(r.Dns.Id))
+	dns = appendProtoVarint(dns, 4, uint64(r.Dns.Opcode))
+	dns = appendProtoString(dns, 5, r.Dns.Rcode)
+	dns = appendProtoString(dns, 6, r.Dns.Qname)
+	dns = appendProtoString(dns, 7, r.Dns.Qtype)
+	dns = appendProtoMessage(dns, 8, flags)
+	for _, rr := range r.Dns.Answers {
+		dns = appendProtoMessage(dns, 9, rr.MarshalProto())
+	}
+	for _, rr := range r.Dns.Nameservers {
+		dns = appendProtoMessage(dns, 10, rr.MarshalProto())
+	}
+	for _, rr := range r.Dns.Records {
+		dns = appendProtoMessage(dns, 11, rr.MarshalProto())
+	}
+	dns = appendProtoBool(dns, 12, r.Dns.MalformedPacket)
+
+	var edns []byte
+	edns = appendProtoVarint(edns, 1, uint64(r.Edns.UdpSize))
+	edns = appendProtoVarint(edns, 2, uint64(r.Edns.Rcode))
+	edns = appendProtoVarint(edns, 3, uint64(r.Edns.Version))
+	edns = appendProtoBool(edns, 4, r.Edns.DnssecOk)
+	for _, opt := range r.Edns.Options {
+		var option []byte
+		option = appendProtoVarint(option, 1, uint64(opt.Code))
+		option = appendProtoString(option, 2, opt.Name)
+		option = appendProtoString(option, 3, opt.Data)
+		edns = appendProtoMessage(edns, 5, option)
+	}
+
+	var b []byte
+	b = appendProtoMessage(b, 1, dnstap)
+	b = appendProtoMessage(b, 2, network)
+	b = appendProtoMessage(b, 3, dns)
+	b = appendProtoMessage(b, 4, edns)
+	return b
+}
+
+// rangeProtoFields calls the function with each field of the message, with the value
+// of the bytes fields or the value of the integer fields
+func rangeProtoFields(b []byte, fn func(num protowire.Number, v []byte, x uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		fn(num, v, x)
+	}
+	return nil
+}
+
+func (r *RecordRR) UnmarshalProto(b []byte) error {
+	return rangeProtoFields(b, func(num protowire.Number, v []byte, x uint64) {
+		switch num {
+		case 1:
+			r.Name = string(v)
+		case 2:
+			r.Rdatatype = string(v)
+		case 3:
+			r.Ttl = int(uint32(x))
+		case 4:
+			r.Rdata = string(v)
+		}
+	})
+}
+
+func (r *DnsRecord) UnmarshalProto(b []byte) error {
+	var errs []error
+	sub := func(b []byte, fn func(num protowire.Number, v []byte, x uint64)) {
+		if err := rangeProtoFields(b, fn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	rr := func(v []byte) RecordRR {
+		var record RecordRR
+		if err := record.UnmarshalProto(v); err != nil {
+			errs = append(errs, err)
+		}
+		return record
+	}
+
+	err := rangeProtoFields(b, func(num protowire.Number, v []byte, x uint64) {
+		switch num {
+		case 1:
+			sub(v, func(num protowire.Number, v []byte, x uint64) {
+				switch num {
+				case 1:
+					r.Dnstap.Operation = string(v)
+				case 2:
+					r.Dnstap.Identity = string(v)
+				case 3:
+					r.Dnstap.Version = string(v)
+				case 4:
+					r.Dnstap.TimeSec = int64(x)
+				case 5:
+					r.Dnstap.TimeNsec = int64(x)
+				case 6:
+					r.Dnstap.Latency = math.Float64frombits(x)
+				case 7:
+					r.Dnstap.Extra = string(v)
+				case 8:
+					r.Dnstap.PolicyType = string(v)
+				case 9:
+					r.Dnstap.PolicyRule = string(v)
+				case 10:
+					r.Dnstap.PolicyAction = string(v)
+				case 11:
+					r.Dnstap.PolicyMatch = string(v)
+				case 12:
+					r.Dnstap.PolicyValue = string(v)
+				}
+			})
+		case 2:
+			sub(v, func(num protowire.Number, v []byte, x uint64) {
+				switch num {
+				case 1:
+					r.Network.Family = string(v)
+				case 2:
+					r.Network.Protocol = string(v)
+				case 3:
+					r.Network.QueryIp = string(v)
+				case 4:
+					r.Network.QueryPort = int(x)
+				case 5:
+					r.Network.ResponseIp = string(v)
+				case 6:
+					r.Network.ResponsePort = int(x)
+				case 7:
+					r.Network.IpDefragmented = x != 0
+				case 8:
+					r.Network.TcpReassembled = x != 0
+				}
+			})
+		case 3:
+			sub(v, func(num protowire.Number, v []byte, x uint64) {
+				switch num {
+				case 1:
+					r.Dns.Type = string(v)
+				case 2:
+					r.Dns.Length = int(x)
+				case 3:
+					r.Dns.Id = int(x)
+				case 4:
+					r.Dns.Opcode = int(x)
+				case 5:
+					r.Dns.Rcode = string(v)
+				case 6:
+					r.Dns.Qname = string(v)
+				case 7:
+					r.Dns.Qtype = string(v)
+				case 8:
+					sub(v, func(num protowire.Number, v []byte, x uint64) {
+						switch num {
+						case 1:
+							r.Dns.Flags.QR = x != 0
+						case 2:
+							r.Dns.Flags.TC = x != 0
+						case 3:
+							r.Dns.Flags.AA = x != 0
+						case 4:
+							r.Dns.Flags.RA = x != 0
+						case 5:
+							r.Dns.Flags.AD = x != 0
+						case 6:
+							r.Dns.Flags.CD = x != 0
+						}
+					})
+				case 9:
+					r.Dns.Answers = append(r.Dns.Answers, rr(v))
+				case 10:
+					r.Dns.Nameservers = append(r.Dns.Nameservers, rr(v))
+				case 11:
+					r.Dns.Records = append(r.Dns.Records, rr(v))
+				case 12:
+					r.Dns.MalformedPacket = x != 0
+				}
+			})
+		case 4:
+			sub(v, func(num protowire.Number, v []byte, x uint64) {
+				switch num {
+				case 1:
+					r.Edns.UdpSize = int(x)
+				case 2:
+					r.Edns.Rcode = int(x)
+				case 3:
+					r.Edns.Version = int(x)
+				case 4:
+					r.Edns.DnssecOk = x != 0
+				case 5:
+					var option RecordEdnsOption
+					sub(v, func(num protowire.Number, v []byte, x uint64) {
+						switch num {
+						case 1:
+							option.Code = int(x)
+						case 2:
+							option.Name = string(v)
+						case 3:
+							option.Data = string(v)
+						}
+					})
+					r.Edns.Options = append(r.Edns.Options, option)
+				}
+			})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+func fromRecordRRs(records []RecordRR) []DnsAnswer {
+	rrs := make([]DnsAnswer, 0, len(records))
+	for _, rr := range records {
+		rrs = append(rrs, DnsAnswer{Name: rr.Name, Rdatatype: rr.Rdatatype, Ttl: rr.Ttl, Rdata: rr.Rdata})
+	}
+	return rrs
+}
+
+// ToDnsMessage converts the record to a dns message, the fields not part
+// of the schemas keep their default values
+func (r DnsRecord) ToDnsMessage() DnsMessage {
+	dm := DnsMessage{}
+	dm.Init()
+
+	str := func(dst *string, v string) {
+		if len(v) > 0 {
+			*dst = v
+		}
+	}
+	port := func(dst *string, v int) {
+		if v > 0 {
+			*dst = strconv.Itoa(v)
+		}
+	}
+
+	str(&dm.DnsTap.Operation, r.Dnstap.Operation)
+	str(&dm.DnsTap.Identity, r.Dnstap.Identity)
+	str(&dm.DnsTap.Version, r.Dnstap.Version)
+	dm.DnsTap.TimeSec = int(r.Dnstap.TimeSec)
+	dm.DnsTap.TimeNsec = int(r.Dnstap.TimeNsec)
+	dm.DnsTap.Timestamp = float64(dm.DnsTap.TimeSec) + float64(dm.DnsTap.TimeNsec)/1e9
+	dm.DnsTap.TimestampRFC3339 = time.Unix(r.Dnstap.TimeSec, r.Dnstap.TimeNsec).UTC().Format(time.RFC3339Nano)
+	dm.DnsTap.Latency = r.Dnstap.Latency
+	dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", r.Dnstap.Latency)
+	str(&dm.DnsTap.Extra, r.Dnstap.Extra)
+	str(&dm.DnsTap.PolicyType, r.Dnstap.PolicyType)
+	str(&dm.DnsTap.PolicyRule, r.Dnstap.PolicyRule)
+	str(&dm.DnsTap.PolicyAction, r.Dnstap.PolicyAction)
+	str(&dm.DnsTap.PolicyMatch, r.Dnstap.PolicyMatch)
+	str(&dm.DnsTap.PolicyValue, r.Dnstap.PolicyValue)
+
+	str(&dm.NetworkInfo.Family, r.Network.Family)
+	str(&dm.NetworkInfo.Protocol, r.Network.Protocol)
+	str(&dm.NetworkInfo.QueryIp, r.Network.QueryIp)
+	port(&dm.NetworkInfo.QueryPort, r.Network.QueryPort)
+	str(&dm.NetworkInfo.ResponseIp, r.Network.ResponseIp)
+	port(&dm.NetworkInfo.ResponsePort, r.Network.ResponsePort)
+	dm.NetworkInfo.IpDefragmented = r.Network.IpDefragmented
+	dm.NetworkInfo.TcpReassembled = r.Network.TcpReassembled
+
+	str(&dm.DNS.Type, r.Dns.Type)
+	dm.DNS.Length = r.Dns.Length
+	dm.DNS.Id = r.Dns.Id
+	dm.DNS.Opcode = r.Dns.Opcode
+	str(&dm.DNS.Rcode, r.Dns.Rcode)
+	str(&dm.DNS.Qname, r.Dns.Qname)
+	str(&dm.DNS.Qtype, r.Dns.Qtype)
+	dm.DNS.Flags = DnsFlags{
+		QR: r.Dns.Flags.QR,
+		TC: r.Dns.Flags.TC,
+		AA: r.Dns.Flags.AA,
+		RA: r.Dns.Flags.RA,
+		AD: r.Dns.Flags.AD,
+		CD: r.Dns.Flags.CD,
+	}
+	dm.DNS.DnsRRs.Answers = fromRecordRRs(r.Dns.Answers)
+	dm.DNS.DnsRRs.Nameservers = fromRecordRRs(r.Dns.Nameservers)
+	dm.DNS.DnsRRs.Records = fromRecordRRs(r.Dns.Records)
+	dm.DNS.MalformedPacket = r.Dns.MalformedPacket
+
+	dm.EDNS.UdpSize = r.Edns.UdpSize
+	dm.EDNS.ExtendedRcode = r.Edns.Rcode
+	dm.EDNS.Version = r.Edns.Version
+	if r.Edns.DnssecOk {
+		dm.EDNS.Do = 1
+	}
+	for _, opt := range r.Edns.Options {
+		dm.EDNS.Options = append(dm.EDNS.Options, DnsOption{Code: opt.Code, Name: opt.Name, Data: opt.Data})
+	}
+	return dm
+}
+
+// FromProtobuf decodes the dns message encoded according to the protobuf schema
+func FromProtobuf(data []byte) (DnsMessage, error) {
+	record := DnsRecord{}
+	if err := record.UnmarshalProto(data); err != nil {
+		return DnsMessage{}, err
+	}
+	return record.ToDnsMessage(), nil
+}
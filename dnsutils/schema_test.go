@@ -0,0 +1,119 @@
+package dnsutils
+
+import (
+	"testing"
+
+	"github.com/hamba/avro"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// getProtoField returns the value of the first field with this number, only for the bytes type
+func getProtoField(b []byte, num protowire.Number) []byte {
+	for len(b) > 0 {
+		n, typ, size := protowire.ConsumeTag(b)
+		if size < 0 {
+			return nil
+		}
+		b = b[size:]
+		if typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(b)
+			if n == num {
+				return v
+			}
+			b = b[m:]
+			continue
+		}
+		m := protowire.ConsumeFieldValue(n, typ, b)
+		if m < 0 {
+			return nil
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+func TestDnsMessage_ToProtobuf(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "127.0.0.1"})
+
+	data, err := dm.ToProtobuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dns := getProtoField(data, 3)
+	if qname := string(getProtoField(dns, 6)); qname != "dns.collector" {
+		t.Errorf("invalid qname: %s", qname)
+	}
+	answer := getProtoField(dns, 9)
+	if rdata := string(getProtoField(answer, 4)); rdata != "127.0.0.1" {
+		t.Errorf("invalid rdata: %s", rdata)
+	}
+	network := getProtoField(data, 2)
+	if queryIp := string(getProtoField(network, 3)); queryIp != "1.2.3.4" {
+		t.Errorf("invalid query ip: %s", queryIp)
+	}
+}
+
+func TestDnsMessage_ToAvro(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "127.0.0.1"})
+	dm.EDNS.Options = append(dm.EDNS.Options, DnsOption{Code: 10, Name: "COOKIE", Data: "8a3b5c6d7e8f9a0b -"})
+
+	data, err := dm.ToAvro()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := DnsRecord{}
+	if err := avro.Unmarshal(AvroSchema, data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Dns.Qname != "dns.collector" || record.Network.QueryPort != 1234 {
+		t.Errorf("invalid record: %+v", record)
+	}
+	if len(record.Dns.Answers) != 1 || record.Dns.Answers[0].Ttl != 300 {
+		t.Errorf("invalid answers: %+v", record.Dns.Answers)
+	}
+	if len(record.Edns.Options) != 1 || record.Edns.Options[0].Name != "COOKIE" {
+		t.Errorf("invalid edns options: %+v", record.Edns.Options)
+	}
+}
+
+func TestDnsMessage_FromProtobuf(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DnsTap.TimeSec = 1681286400
+	dm.DnsTap.Latency = 0.25
+	dm.DNS.Flags.QR = true
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "127.0.0.1"})
+	dm.EDNS.Options = append(dm.EDNS.Options, DnsOption{Code: 10, Name: "COOKIE", Data: "8a3b5c6d7e8f9a0b -"})
+
+	data, err := dm.ToProtobuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := FromProtobuf(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.DNS.Qname != "dns.collector" || decoded.NetworkInfo.QueryPort != "1234" || !decoded.DNS.Flags.QR {
+		t.Errorf("invalid message: %+v", decoded)
+	}
+	if decoded.DnsTap.Latency != 0.25 || decoded.DnsTap.TimestampRFC3339 != "2023-04-12T08:00:00Z" {
+		t.Errorf("invalid dnstap: %+v", decoded.DnsTap)
+	}
+	if len(decoded.DNS.DnsRRs.Answers) != 1 || decoded.DNS.DnsRRs.Answers[0].Ttl != 300 {
+		t.Errorf("invalid answers: %+v", decoded.DNS.DnsRRs.Answers)
+	}
+	if len(decoded.EDNS.Options) != 1 || decoded.EDNS.Options[0].Name != "COOKIE" {
+		t.Errorf("invalid edns options: %+v", decoded.EDNS.Options)
+	}
+	if decoded.DnsTap.Extra != "-" || decoded.DnsTap.PolicyRule != "-" {
+		t.Errorf("default values expected: %+v", decoded.DnsTap)
+	}
+
+	if _, err := FromProtobuf([]byte{0x0a, 0xff}); err == nil {
+		t.Errorf("error expected on truncated message")
+	}
+}
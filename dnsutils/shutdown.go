@@ -0,0 +1,66 @@
+package dnsutils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StopWorkers stops the workers in parallel and waits them until the deadline, the names
+// of the workers not stopped in time are returned in the error
+func StopWorkers(workers map[string]Worker, deadline time.Time) error {
+	var mu sync.Mutex
+	pending := make(map[string]bool)
+	for name := range workers {
+		pending[name] = true
+	}
+
+	var wg sync.WaitGroup
+	for name, w := range workers {
+		wg.Add(1)
+		go func(name string, w Worker) {
+			defer wg.Done()
+			w.Stop()
+			mu.Lock()
+			delete(pending, name)
+			mu.Unlock()
+		}(name, w)
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(time.Until(deadline)):
+		mu.Lock()
+		defer mu.Unlock()
+		names := []string{}
+		for name := range pending {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("shutdown deadline exceeded, not stopped: %v", names)
+	}
+}
+
+// DrainChannel calls the function for each message remaining in the channel, without waiting
+// new ones. The loggers stopped with an exit signal drain their channel before to flush.
+func DrainChannel(ch chan DnsMessage, fn func(dm DnsMessage)) {
+	for {
+		select {
+		case dm, opened := <-ch:
+			if !opened {
+				return
+			}
+			fn(dm)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package dnsutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// worker stopped after a delay
+type slowWorker struct {
+	name  string
+	delay time.Duration
+}
+
+func (w *slowWorker) SetLoggers(loggers []Worker) {}
+func (w *slowWorker) GetName() string             { return w.name }
+func (w *slowWorker) Stop()                       { time.Sleep(w.delay) }
+func (w *slowWorker) Run()                        {}
+func (w *slowWorker) Channel() chan DnsMessage    { return nil }
+func (w *slowWorker) ReadConfig()                 {}
+func (w *slowWorker) ReloadConfig(config *Config) {}
+
+func TestStopWorkers(t *testing.T) {
+	workers := map[string]Worker{
+		"fast": &slowWorker{name: "fast"},
+		"slow": &slowWorker{name: "slow", delay: time.Second},
+	}
+
+	// the workers are stopped in parallel
+	start := time.Now()
+	if err := StopWorkers(workers, time.Now().Add(5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("workers not stopped in parallel: %v", elapsed)
+	}
+
+	// the slow worker is reported after the deadline
+	err := StopWorkers(workers, time.Now().Add(100*time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "slow") || strings.Contains(err.Error(), "fast") {
+		t.Errorf("deadline error expected with the slow worker, got %v", err)
+	}
+}
+
+func TestDrainChannel(t *testing.T) {
+	ch := make(chan DnsMessage, 10)
+	for i := 0; i < 3; i++ {
+		ch <- GetFakeDnsMessage()
+	}
+
+	// the messages remaining are read without waiting new ones
+	n := 0
+	DrainChannel(ch, func(dm DnsMessage) { n++ })
+	if n != 3 || len(ch) != 0 {
+		t.Errorf("3 messages expected, got %d", n)
+	}
+}
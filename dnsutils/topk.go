@@ -0,0 +1,189 @@
+package dnsutils
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// TopKItem is an item of a top, the hit can be overestimated by the error at most
+type TopKItem struct {
+	Name  string `json:"key" msgpack:"key"`
+	Hit   int    `json:"hit" msgpack:"hit"`
+	Error int    `json:"error" msgpack:"error"`
+}
+
+type topkCounter struct {
+	TopKItem
+	index int
+}
+
+// min heap of the counters by hit
+type topkHeap []*topkCounter
+
+func (h topkHeap) Len() int           { return len(h) }
+func (h topkHeap) Less(i, j int) bool { return h[i].Hit < h[j].Hit }
+func (h topkHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *topkHeap) Push(x interface{}) {
+	c := x.(*topkCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *topkHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// TopK counts the most frequent items with the space-saving algorithm, the memory is bounded
+// by the capacity: when all the counters are used, the least frequent item is replaced by the
+// new one which inherits its hits as error. An item more frequent than total/capacity is always kept.
+type TopK struct {
+	capacity int
+	items    map[string]*topkCounter
+	heap     topkHeap
+}
+
+func NewTopK(capacity int) *TopK {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TopK{
+		capacity: capacity,
+		items:    make(map[string]*topkCounter),
+	}
+}
+
+// Add counts n hits for the item
+func (t *TopK) Add(name string, n int) {
+	if c, ok := t.items[name]; ok {
+		c.Hit += n
+		heap.Fix(&t.heap, c.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		c := &topkCounter{TopKItem: TopKItem{Name: name, Hit: n}}
+		t.items[name] = c
+		heap.Push(&t.heap, c)
+		return
+	}
+
+	// replace the least frequent item
+	c := t.heap[0]
+	delete(t.items, c.Name)
+	c.Name = name
+	c.Error = c.Hit
+	c.Hit += n
+	t.items[name] = c
+	heap.Fix(&t.heap, 0)
+}
+
+// Len returns the number of items counted
+func (t *TopK) Len() int {
+	return len(t.heap)
+}
+
+// Top returns the k most frequent items
+func (t *TopK) Top(k int) []TopKItem {
+	items := make([]TopKItem, 0, len(t.heap))
+	for _, c := range t.heap {
+		items = append(items, c.TopKItem)
+	}
+	return sortTopK(items, k)
+}
+
+// Reset removes all the items
+func (t *TopK) Reset() {
+	t.items = make(map[string]*topkCounter)
+	t.heap = t.heap[:0]
+}
+
+// sortTopK sorts the items by hits then by name and keeps the first k ones
+func sortTopK(items []TopKItem, k int) []TopKItem {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Hit != items[j].Hit {
+			return items[i].Hit > items[j].Hit
+		}
+		return items[i].Name < items[j].Name
+	})
+	if k >= 0 && len(items) > k {
+		items = items[:k]
+	}
+	return items
+}
+
+// SlidingTopK is a top over a sliding window, the window is divided in slots
+// with their own top, the oldest slot is reset when the window moves
+type SlidingTopK struct {
+	slots     []*TopK
+	duration  time.Duration
+	current   int
+	slotStart time.Time
+}
+
+func NewSlidingTopK(capacity int, window time.Duration, slots int) *SlidingTopK {
+	if slots < 1 {
+		slots = 1
+	}
+	s := &SlidingTopK{
+		slots:     make([]*TopK, slots),
+		duration:  window / time.Duration(slots),
+		slotStart: time.Now(),
+	}
+	if s.duration <= 0 {
+		s.duration = time.Second
+	}
+	for i := range s.slots {
+		s.slots[i] = NewTopK(capacity)
+	}
+	return s
+}
+
+// rotate moves the window to the time provided
+func (s *SlidingTopK) rotate(now time.Time) {
+	for i := 0; i < len(s.slots) && now.Sub(s.slotStart) >= s.duration; i++ {
+		s.current = (s.current + 1) % len(s.slots)
+		s.slots[s.current].Reset()
+		s.slotStart = s.slotStart.Add(s.duration)
+	}
+	// the window is empty after a long inactivity
+	if now.Sub(s.slotStart) >= s.duration {
+		s.slotStart = now
+	}
+}
+
+// Add counts n hits for the item at the time provided
+func (s *SlidingTopK) Add(name string, n int, now time.Time) {
+	s.rotate(now)
+	s.slots[s.current].Add(name, n)
+}
+
+// Top returns the k most frequent items of the window, the hits and
+// the errors of the slots are summed
+func (s *SlidingTopK) Top(k int, now time.Time) []TopKItem {
+	s.rotate(now)
+	merged := make(map[string]*TopKItem)
+	for _, slot := range s.slots {
+		for _, c := range slot.heap {
+			if m, ok := merged[c.Name]; ok {
+				m.Hit += c.Hit
+				m.Error += c.Error
+			} else {
+				item := c.TopKItem
+				merged[c.Name] = &item
+			}
+		}
+	}
+
+	items := make([]TopKItem, 0, len(merged))
+	for _, m := range merged {
+		items = append(items, *m)
+	}
+	return sortTopK(items, k)
+}
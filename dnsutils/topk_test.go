@@ -0,0 +1,87 @@
+package dnsutils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopK_SpaceSaving(t *testing.T) {
+	topk := NewTopK(3)
+
+	for i := 0; i < 10; i++ {
+		topk.Add("a", 1)
+	}
+	for i := 0; i < 5; i++ {
+		topk.Add("b", 1)
+	}
+	topk.Add("c", 2)
+
+	// the least frequent item is replaced
+	topk.Add("d", 1)
+	if topk.Len() != 3 {
+		t.Fatalf("capacity not respected: %d", topk.Len())
+	}
+
+	top := topk.Top(2)
+	if len(top) != 2 || top[0].Name != "a" || top[0].Hit != 10 || top[1].Name != "b" || top[1].Hit != 5 {
+		t.Errorf("invalid top: %+v", top)
+	}
+
+	// d inherits the hits of c as error
+	top = topk.Top(-1)
+	if top[2].Name != "d" || top[2].Hit != 3 || top[2].Error != 2 {
+		t.Errorf("invalid replaced item: %+v", top[2])
+	}
+
+	topk.Reset()
+	if topk.Len() != 0 || len(topk.Top(10)) != 0 {
+		t.Errorf("top not reset")
+	}
+}
+
+func TestTopK_HeavyHitters(t *testing.T) {
+	// an item more frequent than total/capacity is always kept
+	topk := NewTopK(10)
+	for i := 0; i < 10000; i++ {
+		if i%4 == 0 {
+			topk.Add("heavy", 1)
+		} else {
+			topk.Add(string(rune('a'+i%26))+string(rune('a'+i%17)), 1)
+		}
+	}
+
+	top := topk.Top(1)
+	if top[0].Name != "heavy" || top[0].Hit-top[0].Error > 2500 || top[0].Hit < 2500 {
+		t.Errorf("heavy hitter not found: %+v", top)
+	}
+}
+
+func TestTopK_SlidingWindow(t *testing.T) {
+	now := time.Now()
+	window := NewSlidingTopK(100, 10*time.Second, 10)
+
+	window.Add("a", 3, now)
+	window.Add("b", 1, now.Add(5*time.Second))
+	window.Add("a", 1, now.Add(5*time.Second))
+
+	top := window.Top(10, now.Add(5*time.Second))
+	if len(top) != 2 || top[0].Name != "a" || top[0].Hit != 4 || top[1].Hit != 1 {
+		t.Errorf("invalid top: %+v", top)
+	}
+
+	// the first slot leaves the window
+	top = window.Top(10, now.Add(11*time.Second))
+	if len(top) != 2 || top[0].Hit != 1 || top[1].Hit != 1 {
+		t.Errorf("invalid top after sliding: %+v", top)
+	}
+
+	// empty window after inactivity
+	top = window.Top(10, now.Add(time.Hour))
+	if len(top) != 0 {
+		t.Errorf("window should be empty: %+v", top)
+	}
+	window.Add("c", 1, now.Add(time.Hour))
+	if top = window.Top(10, now.Add(time.Hour)); len(top) != 1 {
+		t.Errorf("invalid top after inactivity: %+v", top)
+	}
+}
@@ -0,0 +1,265 @@
+package loggers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+type AccountingRecord struct {
+	Key           string `json:"key"`
+	Queries       int    `json:"queries"`
+	Replies       int    `json:"replies"`
+	BytesIn       int    `json:"bytes-in"`
+	BytesOut      int    `json:"bytes-out"`
+	UniqueDomains int    `json:"unique-domains"`
+	domains       map[string]bool
+}
+
+type AccountingReport struct {
+	Start   string              `json:"start"`
+	End     string              `json:"end"`
+	GroupBy string              `json:"group-by"`
+	Records []*AccountingRecord `json:"records"`
+}
+
+type Accounting struct {
+	done        chan bool
+	configChan  chan *dnsutils.Config
+	channel     chan dnsutils.DnsMessage
+	config      *dnsutils.Config
+	logger      *logger.Logger
+	name        string
+	periodStart time.Time
+	records     map[string]*AccountingRecord
+}
+
+func NewAccounting(config *dnsutils.Config, logger *logger.Logger, name string) *Accounting {
+	logger.Info("[%s] logger accounting - enabled", name)
+	o := &Accounting{
+		done:        make(chan bool),
+		configChan:  make(chan *dnsutils.Config, 1),
+		channel:     make(chan dnsutils.DnsMessage, 512),
+		logger:      logger,
+		config:      config,
+		name:        name,
+		periodStart: time.Now(),
+		records:     make(map[string]*AccountingRecord),
+	}
+	o.ReadConfig()
+	return o
+}
+
+func (c *Accounting) GetName() string { return c.name }
+
+func (c *Accounting) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *Accounting) ReadConfig() {
+	if len(o.config.Loggers.Accounting.ReportDir) == 0 {
+		o.logger.Fatal("logger accounting - report directory is required")
+	}
+
+	switch o.config.Loggers.Accounting.Mode {
+	case dnsutils.MODE_CSV, dnsutils.MODE_JSON:
+	default:
+		o.logger.Fatal("logger accounting - invalid mode: ", o.config.Loggers.Accounting.Mode)
+	}
+
+	switch o.config.Loggers.Accounting.GroupBy {
+	case dnsutils.ACCOUNTING_BY_CLIENT, dnsutils.ACCOUNTING_BY_IDENTITY, dnsutils.ACCOUNTING_BY_TAG:
+	default:
+		o.logger.Fatal("logger accounting - invalid group-by: ", o.config.Loggers.Accounting.GroupBy)
+	}
+}
+
+func (o *Accounting) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (o *Accounting) LogInfo(msg string, v ...interface{}) {
+	o.logger.Info("["+o.name+"] logger accounting - "+msg, v...)
+}
+
+func (o *Accounting) LogError(msg string, v ...interface{}) {
+	o.logger.Error("["+o.name+"] logger accounting - "+msg, v...)
+}
+
+func (o *Accounting) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *Accounting) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// GetKey returns the accounting key of the dns message according to the group-by setting
+func (o *Accounting) GetKey(dm *dnsutils.DnsMessage) string {
+	switch o.config.Loggers.Accounting.GroupBy {
+	case dnsutils.ACCOUNTING_BY_IDENTITY:
+		return dm.DnsTap.Identity
+	case dnsutils.ACCOUNTING_BY_TAG:
+		if dm.PowerDns != nil && len(dm.PowerDns.Tags) > 0 {
+			return dm.PowerDns.Tags[0]
+		}
+		return "-"
+	default:
+		return dm.NetworkInfo.QueryIp
+	}
+}
+
+func (o *Accounting) Record(dm *dnsutils.DnsMessage) {
+	key := o.GetKey(dm)
+	rec, exists := o.records[key]
+	if !exists {
+		rec = &AccountingRecord{Key: key, domains: make(map[string]bool)}
+		o.records[key] = rec
+	}
+
+	if dm.DNS.Type == dnsutils.DnsQuery {
+		rec.Queries++
+		rec.BytesIn += dm.DNS.Length
+	} else {
+		rec.Replies++
+		rec.BytesOut += dm.DNS.Length
+	}
+
+	if _, ok := rec.domains[dm.DNS.Qname]; !ok {
+		rec.domains[dm.DNS.Qname] = true
+		rec.UniqueDomains++
+	}
+}
+
+// GetReport returns the records of the current period sorted by key
+func (o *Accounting) GetReport(end time.Time) AccountingReport {
+	report := AccountingReport{
+		Start:   o.periodStart.UTC().Format(time.RFC3339),
+		End:     end.UTC().Format(time.RFC3339),
+		GroupBy: o.config.Loggers.Accounting.GroupBy,
+		Records: []*AccountingRecord{},
+	}
+	for _, rec := range o.records {
+		report.Records = append(report.Records, rec)
+	}
+	sort.Slice(report.Records, func(i, j int) bool {
+		return report.Records[i].Key < report.Records[j].Key
+	})
+	return report
+}
+
+// WriteReport exports the current period to the report directory and starts a new one
+func (o *Accounting) WriteReport() (string, error) {
+	now := time.Now()
+	report := o.GetReport(now)
+
+	filename := fmt.Sprintf("accounting-%s.%s", o.periodStart.UTC().Format("20060102-150405"),
+		o.config.Loggers.Accounting.Mode)
+	filePath := filepath.Join(o.config.Loggers.Accounting.ReportDir, filename)
+
+	fd, err := os.Create(filePath)
+	if err != nil {
+		return filePath, err
+	}
+	defer fd.Close()
+
+	switch o.config.Loggers.Accounting.Mode {
+	case dnsutils.MODE_JSON:
+		enc := json.NewEncoder(fd)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+
+	case dnsutils.MODE_CSV:
+		w := csv.NewWriter(fd)
+		w.Write([]string{"start", "end", o.config.Loggers.Accounting.GroupBy, "queries", "replies",
+			"bytes-in", "bytes-out", "unique-domains"})
+		for _, rec := range report.Records {
+			w.Write([]string{report.Start, report.End, rec.Key, strconv.Itoa(rec.Queries),
+				strconv.Itoa(rec.Replies), strconv.Itoa(rec.BytesIn), strconv.Itoa(rec.BytesOut),
+				strconv.Itoa(rec.UniqueDomains)})
+		}
+		w.Flush()
+		err = w.Error()
+	}
+
+	// start a new period
+	o.periodStart = now
+	o.records = make(map[string]*AccountingRecord)
+
+	return filePath, err
+}
+
+func (o *Accounting) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// prepare period timer
+	period := time.Duration(o.config.Loggers.Accounting.Period) * time.Second
+	periodTimer := time.NewTimer(period)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			o.Record(&dm)
+
+		case <-periodTimer.C:
+			if filePath, err := o.WriteReport(); err != nil {
+				o.LogError("unable to write report %s: %s", filePath, err)
+			} else {
+				o.LogInfo("report written to %s", filePath)
+			}
+			periodTimer.Reset(period)
+		}
+	}
+
+	// stop timer and export the current period
+	periodTimer.Stop()
+	if len(o.records) > 0 {
+		if filePath, err := o.WriteReport(); err != nil {
+			o.LogError("unable to write report %s: %s", filePath, err)
+		}
+	}
+
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
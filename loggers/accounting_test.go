@@ -0,0 +1,83 @@
+package loggers
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_AccountingReport(t *testing.T) {
+	testcases := []struct {
+		mode    string
+		groupBy string
+		pattern string
+	}{
+		{
+			mode:    dnsutils.MODE_CSV,
+			groupBy: dnsutils.ACCOUNTING_BY_CLIENT,
+			pattern: ",1.2.3.4,2,1,20,50,2",
+		},
+		{
+			mode:    dnsutils.MODE_CSV,
+			groupBy: dnsutils.ACCOUNTING_BY_IDENTITY,
+			pattern: ",collector,2,1,20,50,2",
+		},
+		{
+			mode:    dnsutils.MODE_JSON,
+			groupBy: dnsutils.ACCOUNTING_BY_CLIENT,
+			pattern: "\"unique-domains\": 2",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.mode+"-"+tc.groupBy, func(t *testing.T) {
+			cfg := dnsutils.GetFakeConfig()
+			cfg.Loggers.Accounting.ReportDir = t.TempDir()
+			cfg.Loggers.Accounting.Mode = tc.mode
+			cfg.Loggers.Accounting.GroupBy = tc.groupBy
+
+			g := NewAccounting(cfg, logger.New(false), "test")
+
+			// two queries and one reply
+			for _, qname := range []string{"dns.collector", "www.collector"} {
+				dm := dnsutils.GetFakeDnsMessage()
+				dm.DNS.Qname = qname
+				dm.DNS.Length = 10
+				g.Record(&dm)
+			}
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Type = dnsutils.DnsReply
+			dm.DNS.Length = 50
+			g.Record(&dm)
+
+			filePath, err := g.WriteReport()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tc.pattern) {
+				t.Errorf("report should contains %s, got: %s", tc.pattern, string(data))
+			}
+
+			if tc.mode == dnsutils.MODE_JSON {
+				var report AccountingReport
+				if err := json.Unmarshal(data, &report); err != nil {
+					t.Errorf("invalid json report: %s", err)
+				}
+			}
+
+			// a new period is started after the export
+			if len(g.records) != 0 {
+				t.Errorf("records should be reset after the report")
+			}
+		})
+	}
+}
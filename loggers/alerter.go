@@ -0,0 +1,368 @@
+package loggers
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+// json templates of the webhooks, the values are encoded with the json function
+var webhookTemplates = map[string]string{
+	dnsutils.WEBHOOK_FORMAT_GENERIC: `{"summary": {{json .Summary}}, "reason": {{json .Reason}}, "message": {{json .Message}}}`,
+	dnsutils.WEBHOOK_FORMAT_SLACK:   `{"text": {{json .Summary}}}`,
+	dnsutils.WEBHOOK_FORMAT_PAGERDUTY: `{"routing_key": {{json .RoutingKey}}, "event_action": "trigger", "dedup_key": {{json .Key}}, ` +
+		`"payload": {"summary": {{json .Summary}}, "source": {{json .Source}}, "severity": "warning", "custom_details": {{json .Message}}}}`,
+}
+
+// oids of the varbinds of the snmp v2c traps
+var (
+	snmpSysUpTimeOid = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	snmpTrapOidOid   = asn1.ObjectIdentifier{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+)
+
+type snmpVarBind struct {
+	Name  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type snmpTrapPdu struct {
+	RequestId   int
+	ErrorStatus int
+	ErrorIndex  int
+	VarBinds    []snmpVarBind
+}
+
+type snmpMessage struct {
+	Version   int
+	Community []byte
+	Pdu       snmpTrapPdu `asn1:"tag:7"`
+}
+
+// AlertEvent is the data of the webhook templates
+type AlertEvent struct {
+	Summary    string
+	Reason     string
+	Key        string
+	Source     string
+	RoutingKey string
+	Message    *dnsutils.DnsMessage
+}
+
+// ParseOid converts a dotted oid
+func ParseOid(s string) (asn1.ObjectIdentifier, error) {
+	oid := asn1.ObjectIdentifier{}
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid oid %s", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid oid %s", s)
+	}
+	return oid, nil
+}
+
+// snmpValue encodes a value with the tag provided, the tag of the universal type is replaced
+func snmpValue(v interface{}, tag byte) asn1.RawValue {
+	data, _ := asn1.Marshal(v)
+	if tag != 0 {
+		data[0] = tag
+	}
+	return asn1.RawValue{FullBytes: data}
+}
+
+// Alerter turns the messages matching a condition into webhooks or snmp traps,
+// the same alert is sent once per throttle interval
+type Alerter struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	condition  *dnsutils.Expression
+	template   *template.Template
+	trapOid    asn1.ObjectIdentifier
+	httpclient *http.Client
+	lastSent   map[string]time.Time
+	startTime  time.Time
+	requestId  int
+}
+
+func NewAlerter(config *dnsutils.Config, logger *logger.Logger, name string) *Alerter {
+	logger.Info("[%s] logger alerter - enabled", name)
+	o := &Alerter{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		config:     config,
+		logger:     logger,
+		name:       name,
+		lastSent:   make(map[string]time.Time),
+		startTime:  time.Now(),
+	}
+	o.ReadConfig()
+	return o
+}
+
+func (c *Alerter) GetName() string { return c.name }
+
+func (c *Alerter) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *Alerter) ReadConfig() {
+	cfg := o.config.Loggers.Alerter
+	if len(cfg.WebhookUrl) == 0 && len(cfg.SnmpTarget) == 0 {
+		o.logger.Fatal("logger alerter - webhook-url or snmp-target is required")
+	}
+
+	o.condition = nil
+	if len(cfg.Condition) > 0 {
+		expr, err := dnsutils.ParseExpression(cfg.Condition)
+		if err != nil {
+			o.logger.Fatal("logger alerter - invalid condition: ", err)
+		}
+		o.condition = expr
+	}
+
+	text := cfg.WebhookTemplate
+	if len(text) == 0 {
+		var ok bool
+		if text, ok = webhookTemplates[cfg.WebhookFormat]; !ok {
+			o.logger.Fatal("logger alerter - invalid webhook format: ", cfg.WebhookFormat)
+		}
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		o.logger.Fatal("logger alerter - invalid webhook template: ", err)
+	}
+	o.template = tmpl
+
+	if len(cfg.SnmpTarget) > 0 {
+		oid, err := ParseOid(cfg.SnmpTrapOid)
+		if err != nil {
+			o.logger.Fatal("logger alerter - ", err)
+		}
+		o.trapOid = oid
+	}
+
+	o.httpclient = &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}
+}
+
+func (o *Alerter) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (c *Alerter) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] logger alerter - "+msg, v...)
+}
+
+func (c *Alerter) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] logger alerter - "+msg, v...)
+}
+
+func (o *Alerter) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *Alerter) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// Reason returns the cause of the alert and the key used to throttle it, empty if
+// the message is not an alert. Without condition, the alerts are the records of the
+// alerting transformer, the threat intel hits and the tunneling detections.
+func (o *Alerter) Reason(dm *dnsutils.DnsMessage) (string, string) {
+	switch {
+	case dm.Alert != nil:
+		return fmt.Sprintf("%s of %s %s", dm.Alert.Name, dm.Alert.GroupBy, dm.Alert.Key),
+			dm.Alert.Name + "+" + dm.Alert.GroupBy + "+" + dm.Alert.Key
+	case dm.ThreatIntel != nil && dm.ThreatIntel.Feed != "-":
+		return fmt.Sprintf("threat intel feed %s (%s)", dm.ThreatIntel.Feed, dm.ThreatIntel.Category),
+			"threatintel+" + dm.ThreatIntel.Feed + "+" + dm.NetworkInfo.QueryIp + "+" + dm.DNS.Qname
+	case dm.Tunneling != nil:
+		return fmt.Sprintf("tunneling on %s", dm.Tunneling.Domain),
+			"tunneling+" + dm.NetworkInfo.QueryIp + "+" + dm.Tunneling.Domain
+	case o.condition != nil:
+		return "condition " + o.condition.String(),
+			"condition+" + dm.NetworkInfo.QueryIp + "+" + dm.DNS.Qname
+	}
+	return "", ""
+}
+
+// Event returns the alert of the message, false if the message does not match or if the
+// same alert has been sent during the throttle interval
+func (o *Alerter) Event(dm *dnsutils.DnsMessage) (AlertEvent, bool) {
+	if o.condition != nil && !o.condition.Match(dm) {
+		return AlertEvent{}, false
+	}
+	reason, key := o.Reason(dm)
+	if len(reason) == 0 {
+		return AlertEvent{}, false
+	}
+
+	now := time.Now()
+	throttle := time.Duration(o.config.Loggers.Alerter.Throttle) * time.Second
+	if last, ok := o.lastSent[key]; ok && now.Sub(last) < throttle {
+		return AlertEvent{}, false
+	}
+	o.lastSent[key] = now
+
+	// remove the expired keys to keep the map small
+	if len(o.lastSent) > 10000 {
+		for k, last := range o.lastSent {
+			if now.Sub(last) >= throttle {
+				delete(o.lastSent, k)
+			}
+		}
+	}
+
+	return AlertEvent{
+		Summary:    fmt.Sprintf("%s: %s %s from %s", reason, dm.DNS.Qname, dm.DNS.Qtype, dm.NetworkInfo.QueryIp),
+		Reason:     reason,
+		Key:        key,
+		Source:     dm.DnsTap.Identity,
+		RoutingKey: o.config.Loggers.Alerter.RoutingKey,
+		Message:    dm,
+	}, true
+}
+
+// SendWebhook posts the alert to the webhook url
+func (o *Alerter) SendWebhook(event AlertEvent) error {
+	var body bytes.Buffer
+	if err := o.template.Execute(&body, event); err != nil {
+		return err
+	}
+
+	resp, err := o.httpclient.Post(o.config.Loggers.Alerter.WebhookUrl, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// SnmpTrap encodes the alert in a snmp v2c trap, the summary, the reason, the qname,
+// the query ip and the identity are sent under the trap oid
+func (o *Alerter) SnmpTrap(event AlertEvent) ([]byte, error) {
+	o.requestId++
+	uptime := int64(time.Since(o.startTime) / (10 * time.Millisecond))
+
+	varbinds := []snmpVarBind{
+		{Name: snmpSysUpTimeOid, Value: snmpValue(uptime, 0x43)},
+		{Name: snmpTrapOidOid, Value: snmpValue(o.trapOid, 0)},
+	}
+	for i, value := range []string{event.Summary, event.Reason, event.Message.DNS.Qname,
+		event.Message.NetworkInfo.QueryIp, event.Message.DnsTap.Identity} {
+		oid := append(append(asn1.ObjectIdentifier{}, o.trapOid...), i+1)
+		varbinds = append(varbinds, snmpVarBind{Name: oid, Value: snmpValue([]byte(value), 0)})
+	}
+
+	return asn1.Marshal(snmpMessage{
+		Version:   1,
+		Community: []byte(o.config.Loggers.Alerter.SnmpCommunity),
+		Pdu:       snmpTrapPdu{RequestId: o.requestId, VarBinds: varbinds},
+	})
+}
+
+// SendTrap sends the alert to the snmp manager, the port 162 is used by default
+func (o *Alerter) SendTrap(event AlertEvent) error {
+	data, err := o.SnmpTrap(event)
+	if err != nil {
+		return err
+	}
+
+	target := o.config.Loggers.Alerter.SnmpTarget
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "162")
+	}
+	conn, err := net.DialTimeout(dnsutils.SOCKET_UDP, target, time.Duration(o.config.Loggers.Alerter.Timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(data)
+	return err
+}
+
+func (o *Alerter) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			event, ok := o.Event(&dm)
+			if !ok {
+				continue
+			}
+			o.LogInfo("%s", event.Summary)
+
+			if len(o.config.Loggers.Alerter.WebhookUrl) > 0 {
+				if err := o.SendWebhook(event); err != nil {
+					o.LogError("webhook error: %v", err)
+				}
+			}
+			if len(o.config.Loggers.Alerter.SnmpTarget) > 0 {
+				if err := o.SendTrap(event); err != nil {
+					o.LogError("snmp trap error: %v", err)
+				}
+			}
+		}
+	}
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
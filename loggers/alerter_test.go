@@ -0,0 +1,135 @@
+package loggers
+
+import (
+	"encoding/asn1"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_AlerterWebhook(t *testing.T) {
+	// fake slack webhook
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Alerter.WebhookUrl = server.URL
+	cfg.Loggers.Alerter.WebhookFormat = dnsutils.WEBHOOK_FORMAT_SLACK
+	cfg.Loggers.Alerter.Condition = `rcode == "NXDOMAIN"`
+
+	g := NewAlerter(cfg, logger.New(false), "test")
+	go g.Run()
+
+	// the first message does not match the condition, the last one is throttled
+	dm := dnsutils.GetFakeDnsMessage()
+	g.Channel() <- dm
+	dm.DNS.Rcode = "NXDOMAIN"
+	g.Channel() <- dm
+	g.Channel() <- dm
+	g.Stop()
+
+	if len(bodies) != 1 {
+		t.Fatalf("one webhook expected, got %d", len(bodies))
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(<-bodies, &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := `condition rcode == "NXDOMAIN": dns.collector A from 1.2.3.4`
+	if payload["text"] != want {
+		t.Errorf("want %s, got %v", want, payload["text"])
+	}
+}
+
+func Test_AlerterReason(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Alerter.WebhookUrl = "http://127.0.0.1:1"
+	g := NewAlerter(cfg, logger.New(false), "test")
+
+	// without condition, the messages are alerts if tagged by a transformer
+	dm := dnsutils.GetFakeDnsMessage()
+	if _, ok := g.Event(&dm); ok {
+		t.Errorf("no alert expected")
+	}
+
+	dm.ThreatIntel = &dnsutils.TransformThreatIntel{Feed: "-", Category: "-"}
+	if _, ok := g.Event(&dm); ok {
+		t.Errorf("no alert expected without threat intel hit")
+	}
+
+	dm.ThreatIntel = &dnsutils.TransformThreatIntel{Feed: "malware", Category: "c2"}
+	event, ok := g.Event(&dm)
+	if !ok {
+		t.Fatalf("alert expected")
+	}
+	if event.Reason != "threat intel feed malware (c2)" {
+		t.Errorf("unexpected reason: %s", event.Reason)
+	}
+}
+
+func Test_AlerterSnmpTrap(t *testing.T) {
+	// fake snmp manager
+	manager, err := net.ListenPacket(dnsutils.SOCKET_UDP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Alerter.SnmpTarget = manager.LocalAddr().String()
+	cfg.Loggers.Alerter.SnmpCommunity = "private"
+
+	g := NewAlerter(cfg, logger.New(false), "test")
+	go g.Run()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.Tunneling = &dnsutils.TransformTunneling{Domain: "collector"}
+	g.Channel() <- dm
+
+	buf := make([]byte, 65535)
+	manager.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := manager.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Stop()
+
+	// decode the trap
+	trap := snmpMessage{}
+	if _, err := asn1.Unmarshal(buf[:n], &trap); err != nil {
+		t.Fatal(err)
+	}
+	if trap.Version != 1 || string(trap.Community) != "private" {
+		t.Errorf("unexpected version %d or community %s", trap.Version, trap.Community)
+	}
+	if len(trap.Pdu.VarBinds) != 7 {
+		t.Fatalf("unexpected varbinds: %d", len(trap.Pdu.VarBinds))
+	}
+
+	var trapOid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(trap.Pdu.VarBinds[1].Value.FullBytes, &trapOid); err != nil {
+		t.Fatal(err)
+	}
+	if trapOid.String() != cfg.Loggers.Alerter.SnmpTrapOid {
+		t.Errorf("unexpected trap oid: %s", trapOid)
+	}
+
+	var reason []byte
+	if _, err := asn1.Unmarshal(trap.Pdu.VarBinds[3].Value.FullBytes, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if string(reason) != "tunneling on collector" {
+		t.Errorf("unexpected reason: %s", reason)
+	}
+}
@@ -0,0 +1,32 @@
+package loggers
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// DashboardHandler serves the embedded web dashboard, the data are
+// loaded by the browser from the endpoints of the rest api
+func (s *RestAPI) DashboardHandler() http.Handler {
+	assets, err := fs.Sub(dashboardAssets, "dashboard")
+	if err != nil {
+		s.logger.Fatal("loading dashboard assets failed:", err)
+	}
+	fileServer := http.StripPrefix("/dashboard/", http.FileServer(http.FS(assets)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.BasicAuth(w, r) {
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
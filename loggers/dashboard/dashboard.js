@@ -0,0 +1,161 @@
+// live view of the traffic, refreshed from the rest api
+const REFRESH_INTERVAL = 2000;
+const COLORS = ["#2e86de", "#ee5253", "#10ac84", "#ff9f43", "#8395a7", "#5f27cd", "#01a3a4", "#222f3e"];
+
+function getJSON(url) {
+  return fetch(url, { credentials: "same-origin" }).then((resp) => {
+    if (!resp.ok) {
+      throw new Error(resp.status + " " + resp.statusText);
+    }
+    return resp.json();
+  });
+}
+
+function clearCanvas(id) {
+  const canvas = document.getElementById(id);
+  const ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  ctx.font = "11px sans-serif";
+  return [canvas, ctx];
+}
+
+function drawQps(series) {
+  const [canvas, ctx] = clearCanvas("qps-chart");
+  const max = Math.max(1, ...series);
+  const step = canvas.width / Math.max(1, series.length - 1);
+  const height = canvas.height - 20;
+
+  ctx.fillStyle = "#888";
+  ctx.fillText(max, 2, 10);
+  ctx.strokeStyle = COLORS[0];
+  ctx.lineWidth = 2;
+  ctx.beginPath();
+  series.forEach((value, i) => {
+    const x = i * step;
+    const y = 10 + height - (value / max) * height;
+    if (i === 0) {
+      ctx.moveTo(x, y);
+    } else {
+      ctx.lineTo(x, y);
+    }
+  });
+  ctx.stroke();
+}
+
+function drawRcodes(rcodes) {
+  const [canvas, ctx] = clearCanvas("rcodes-chart");
+  const entries = Object.entries(rcodes).sort((a, b) => b[1] - a[1]);
+  const total = entries.reduce((sum, e) => sum + e[1], 0);
+  const legend = document.getElementById("rcodes-legend");
+  legend.innerHTML = "";
+
+  let angle = -Math.PI / 2;
+  const cx = canvas.width / 2;
+  const cy = canvas.height / 2;
+  const radius = Math.min(cx, cy) - 4;
+  entries.forEach(([rcode, hit], i) => {
+    const slice = total > 0 ? (hit / total) * 2 * Math.PI : 0;
+    ctx.fillStyle = COLORS[i % COLORS.length];
+    ctx.beginPath();
+    ctx.moveTo(cx, cy);
+    ctx.arc(cx, cy, radius, angle, angle + slice);
+    ctx.fill();
+    angle += slice;
+
+    const item = document.createElement("li");
+    const color = document.createElement("i");
+    color.style.background = COLORS[i % COLORS.length];
+    item.appendChild(color);
+    item.appendChild(document.createTextNode(rcode + " " + hit));
+    legend.appendChild(item);
+  });
+}
+
+function drawLatency(buckets, counts) {
+  const [canvas, ctx] = clearCanvas("latency-chart");
+  const labels = buckets.map((b) => "<" + b * 1000 + "ms");
+  labels.push(">=" + buckets[buckets.length - 1] * 1000 + "ms");
+  const max = Math.max(1, ...counts);
+  const width = canvas.width / counts.length;
+  const height = canvas.height - 30;
+
+  counts.forEach((value, i) => {
+    const h = (value / max) * height;
+    ctx.fillStyle = COLORS[0];
+    ctx.fillRect(i * width + 4, 10 + height - h, width - 8, h);
+    ctx.fillStyle = "#444";
+    ctx.fillText(labels[i], i * width + 4, canvas.height - 6);
+    ctx.fillText(value, i * width + 4, 10 + height - h - 2);
+  });
+}
+
+function fillTable(id, header, rows) {
+  const table = document.getElementById(id);
+  table.innerHTML = "";
+  const tr = table.insertRow();
+  header.forEach((name) => {
+    const th = document.createElement("th");
+    th.textContent = name;
+    tr.appendChild(th);
+  });
+  rows.forEach((row) => {
+    const tr = table.insertRow();
+    row.forEach((value) => {
+      tr.insertCell().textContent = value;
+    });
+  });
+}
+
+function refreshStats() {
+  getJSON("/stats").then((stats) => {
+    document.getElementById("total").textContent = stats.total;
+    document.getElementById("qps").textContent = stats.qps.toFixed(1);
+    document.getElementById("since").textContent = new Date(stats.since).toLocaleString();
+    drawQps(stats["qps-series"]);
+    drawRcodes(stats.rcodes);
+    drawLatency(stats["latency-buckets"], stats.latencies);
+  }).catch((err) => console.error("stats", err));
+
+  const tops = [["/domains/top", "top-domains", "domain"], ["/clients/top", "top-clients", "client"],
+    ["/domains/nx/top", "top-nxdomains", "domain"]];
+  tops.forEach(([url, id, name]) => {
+    getJSON(url).then((items) => {
+      fillTable(id, [name, "hits"], (items || []).slice(0, 10).map((item) => [item.key, item.hit]));
+    }).catch((err) => console.error(url, err));
+  });
+}
+
+function refreshEvents() {
+  const params = new URLSearchParams({ limit: 50 });
+  const qname = document.getElementById("filter-qname").value.trim();
+  const client = document.getElementById("filter-client").value.trim();
+  if (qname) {
+    params.set("query_name", qname);
+  }
+  if (client) {
+    params.set("query_ip", client);
+  }
+
+  const error = document.getElementById("events-error");
+  getJSON("/events?" + params).then((events) => {
+    error.textContent = "";
+    fillTable("events", ["time", "identity", "operation", "client", "qname", "qtype", "rcode", "latency"],
+      (events || []).map((dm) => [dm.dnstap["timestamp-rfc3339ns"], dm.dnstap.identity, dm.dnstap.operation,
+        dm.network["query-ip"], dm.dns.qname, dm.dns.qtype, dm.dns.rcode, dm.dnstap.latency]));
+  }).catch((err) => {
+    error.textContent = "recent queries unavailable (" + err.message + "), check the events-max-size option";
+  });
+}
+
+document.getElementById("filter").addEventListener("submit", (e) => {
+  e.preventDefault();
+  refreshEvents();
+});
+
+function refresh() {
+  refreshStats();
+  refreshEvents();
+}
+
+refresh();
+setInterval(refresh, REFRESH_INTERVAL);
@@ -0,0 +1,237 @@
+package loggers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+// DiskQueue keeps batches of dns messages on disk until they are released,
+// one file per batch, named with the prefix and a sequence number to preserve the order.
+// The prefix is the name of the logger, so the loggers can share a directory.
+// The oldest batches are removed when the max size is reached, if any
+type DiskQueue struct {
+	sync.Mutex
+	dir     string
+	prefix  string
+	seq     uint64
+	maxSize int64
+	sizes   map[string]int64
+	size    int64
+	evicted int
+}
+
+// NewDiskQueue opens the queue in the directory, the batches of a previous run are kept.
+// The size of the queue is not limited if the max size is zero
+func NewDiskQueue(dir string, prefix string, maxSize int64) (*DiskQueue, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("invalid disk queue max size: %d", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &DiskQueue{dir: dir, prefix: prefix, maxSize: maxSize, sizes: make(map[string]int64)}
+
+	// continue the sequence of the pending batches
+	pending, err := q.Pending()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range pending {
+		if n, ok := q.sequence(path); ok && n > q.seq {
+			q.seq = n
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		q.sizes[path] = info.Size()
+		q.size += info.Size()
+	}
+	return q, nil
+}
+
+// Push writes the batch to disk and returns the path of the file
+func (q *DiskQueue) Push(batch []dnsutils.DnsMessage) (string, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.seq++
+	path := filepath.Join(q.dir, fmt.Sprintf("%s-%020d.json", q.prefix, q.seq))
+
+	// write to a temp file first, a pending batch is always complete
+	fd, err := os.Create(path + ".tmp")
+	if err != nil {
+		return path, err
+	}
+	enc := json.NewEncoder(fd)
+	for i := range batch {
+		if err := enc.Encode(batch[i]); err != nil {
+			fd.Close()
+			return path, err
+		}
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return path, err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return path, err
+	}
+	if err := fd.Close(); err != nil {
+		return path, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return path, err
+	}
+	q.sizes[path] = info.Size()
+	q.size += info.Size()
+
+	return path, q.evict(path)
+}
+
+// evict removes the oldest batches until the max size, the last batch pushed is kept
+func (q *DiskQueue) evict(last string) error {
+	if q.maxSize == 0 || q.size <= q.maxSize {
+		return nil
+	}
+
+	pending, err := q.Pending()
+	if err != nil {
+		return err
+	}
+	for _, path := range pending {
+		if q.size <= q.maxSize || path == last {
+			break
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		q.size -= q.sizes[path]
+		delete(q.sizes, path)
+		q.evicted++
+	}
+	return nil
+}
+
+// sequence returns the sequence number of the batch file, false if the file is not
+// a batch of the queue, the prefix of another queue can start with this prefix
+func (q *DiskQueue) sequence(path string) (uint64, bool) {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, q.prefix+"-") || !strings.HasSuffix(name, ".json") {
+		return 0, false
+	}
+	seq := strings.TrimSuffix(strings.TrimPrefix(name, q.prefix+"-"), ".json")
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+// Pending returns the files of the batches not yet released, from the oldest
+func (q *DiskQueue) Pending() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(q.dir, q.prefix+"-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	pending := files[:0]
+	for _, path := range files {
+		if _, ok := q.sequence(path); ok {
+			pending = append(pending, path)
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+// IsEmpty returns true if there is no batch to release
+func (q *DiskQueue) IsEmpty() bool {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.sizes) == 0
+}
+
+// Size returns the size in bytes of the pending batches
+func (q *DiskQueue) Size() int64 {
+	q.Lock()
+	defer q.Unlock()
+	return q.size
+}
+
+// Evicted returns the number of batches removed because the max size was reached
+func (q *DiskQueue) Evicted() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.evicted
+}
+
+// Load reads the batch from disk
+func (q *DiskQueue) Load(path string) ([]dnsutils.DnsMessage, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	batch := []dnsutils.DnsMessage{}
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var dm dnsutils.DnsMessage
+		if err := json.Unmarshal(scanner.Bytes(), &dm); err != nil {
+			return nil, err
+		}
+		batch = append(batch, dm)
+	}
+	return batch, scanner.Err()
+}
+
+// Release removes the batch from disk once delivered
+func (q *DiskQueue) Release(path string) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.size -= q.sizes[path]
+	delete(q.sizes, path)
+	return nil
+}
+
+// Replay sends the pending batches from the oldest one, until an error is returned by the
+// send function. The batches sent are released, the invalid ones are removed
+func (q *DiskQueue) Replay(send func([]dnsutils.DnsMessage) error) error {
+	pending, err := q.Pending()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range pending {
+		batch, err := q.Load(path)
+		if os.IsNotExist(err) {
+			// evicted in the meantime
+			continue
+		}
+		if err != nil {
+			q.Release(path)
+			return fmt.Errorf("invalid batch %s removed: %w", path, err)
+		}
+
+		if err := send(batch); err != nil {
+			return err
+		}
+		if err := q.Release(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
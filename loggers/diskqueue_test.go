@@ -0,0 +1,105 @@
+package loggers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+func Test_DiskQueueReplay(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, "test", 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.QueryPort = string(rune('0' + i))
+		if _, err := q.Push([]dnsutils.DnsMessage{dm, dm}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the batches are kept after a restart
+	q, err = NewDiskQueue(dir, "test", 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.IsEmpty() || q.Size() == 0 {
+		t.Fatalf("queue should not be empty")
+	}
+
+	// the sink fails after the first batch
+	received := []dnsutils.DnsMessage{}
+	err = q.Replay(func(batch []dnsutils.DnsMessage) error {
+		if len(received) > 0 {
+			return errors.New("sink unavailable")
+		}
+		received = append(received, batch...)
+		return nil
+	})
+	if err == nil || len(received) != 2 {
+		t.Fatalf("replay should be stopped on error: %v %d", err, len(received))
+	}
+
+	// the replay continues with the remaining batches, in order
+	err = q.Replay(func(batch []dnsutils.DnsMessage) error {
+		received = append(received, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 6 || received[5].DNS.Qname != "dns.collector" || received[5].NetworkInfo.QueryPort != "2" {
+		t.Errorf("invalid messages replayed: %d", len(received))
+	}
+	if !q.IsEmpty() || q.Size() != 0 {
+		t.Errorf("queue should be empty")
+	}
+}
+
+func Test_DiskQueueMaxSize(t *testing.T) {
+	q, err := NewDiskQueue(t.TempDir(), "test", 10*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dm := dnsutils.GetFakeDnsMessage()
+	for i := 0; i < 100; i++ {
+		if _, err := q.Push([]dnsutils.DnsMessage{dm}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Size() > 10*1024 {
+		t.Errorf("max size exceeded: %d", q.Size())
+	}
+	if q.Evicted() == 0 {
+		t.Errorf("the oldest batches should be removed")
+	}
+}
+
+func Test_DiskQueueSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	q1, err := NewDiskQueue(dir, "tcp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q2, err := NewDiskQueue(dir, "tcp-backup", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q2.Push([]dnsutils.DnsMessage{dnsutils.GetFakeDnsMessage()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batches of the other logger are not replayed
+	if pending, _ := q1.Pending(); len(pending) != 0 {
+		t.Errorf("the batches of the other queue should be ignored: %v", pending)
+	}
+	if pending, _ := q2.Pending(); len(pending) != 1 {
+		t.Errorf("one batch expected: %v", pending)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-framestream"
 	"github.com/dmachard/go-logger"
@@ -15,6 +16,7 @@ import (
 
 type DnstapSender struct {
 	done               chan bool
+	configChan         chan *dnsutils.Config
 	channel            chan dnsutils.DnsMessage
 	config             *dnsutils.Config
 	logger             *logger.Logger
@@ -24,6 +26,7 @@ type DnstapSender struct {
 	transportConn      net.Conn
 	transportReady     chan bool
 	transportReconnect chan bool
+	serverId           string
 	name               string
 }
 
@@ -31,6 +34,7 @@ func NewDnstapSender(config *dnsutils.Config, logger *logger.Logger, name string
 	logger.Info("logger dnstap [%s] sender - enabled", name)
 	s := &DnstapSender{
 		done:               make(chan bool),
+		configChan:         make(chan *dnsutils.Config, 1),
 		exit:               make(chan bool),
 		channel:            make(chan dnsutils.DnsMessage, 512),
 		transportReady:     make(chan bool),
@@ -51,8 +55,9 @@ func (c *DnstapSender) SetLoggers(loggers []dnsutils.Worker) {}
 
 func (o *DnstapSender) ReadConfig() {
 	// get hostname or global one
-	if o.config.Loggers.Dnstap.ServerId == "" {
-		o.config.Loggers.Dnstap.ServerId = o.config.GetServerIdentity()
+	o.serverId = o.config.Loggers.Dnstap.ServerId
+	if o.serverId == "" {
+		o.serverId = o.config.GetServerIdentity()
 	}
 
 	if !dnsutils.IsValidTLS(o.config.Loggers.Dnstap.TlsMinVersion) {
@@ -60,6 +65,11 @@ func (o *DnstapSender) ReadConfig() {
 	}
 }
 
+func (o *DnstapSender) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
 func (o *DnstapSender) LogInfo(msg string, v ...interface{}) {
 	o.logger.Info("["+o.name+"] logger dnstap - "+msg, v...)
 }
@@ -132,6 +142,24 @@ func (o *DnstapSender) ConnectToRemote() {
 			tlsConfig.InsecureSkipVerify = o.config.Loggers.Dnstap.TlsInsecure
 			tlsConfig.MinVersion = dnsutils.TLS_VERSION[o.config.Loggers.Dnstap.TlsMinVersion]
 
+			// verify the server with the provided ca
+			if len(o.config.Loggers.Dnstap.CaFile) > 0 {
+				caCertPool, err := netlib.LoadCertPool(o.config.Loggers.Dnstap.CaFile)
+				if err != nil {
+					o.logger.Fatal("logger dnstap - loading ca failed: ", err)
+				}
+				tlsConfig.RootCAs = caCertPool
+			}
+
+			// client certificate
+			if len(o.config.Loggers.Dnstap.CertFile) > 0 {
+				cer, err := tls.LoadX509KeyPair(o.config.Loggers.Dnstap.CertFile, o.config.Loggers.Dnstap.KeyFile)
+				if err != nil {
+					o.logger.Fatal("logger dnstap - loading certificate failed: ", err)
+				}
+				tlsConfig.Certificates = []tls.Certificate{cer}
+			}
+
 			dialer := &net.Dialer{Timeout: connTimeout}
 			conn, err = tls.DialWithDialer(dialer, transport, address, tlsConfig)
 		} else {
@@ -166,7 +194,7 @@ func (o *DnstapSender) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 	for _, dm := range *buf {
 		// update identity ?
 		if o.config.Loggers.Dnstap.OverwriteIdentity {
-			dm.DnsTap.Identity = o.config.Loggers.Dnstap.ServerId
+			dm.DnsTap.Identity = o.serverId
 		}
 
 		// encode dns message to dnstap protobuf binary
@@ -211,6 +239,9 @@ func (o *DnstapSender) Run() {
 LOOP:
 	for {
 		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
 
 		// init framestream
 		case <-o.transportReady:
@@ -268,6 +299,18 @@ LOOP:
 		}
 	}
 
+	// the messages received before the stop are sent
+	if o.fsReady {
+		dnsutils.DrainChannel(o.channel, func(dm dnsutils.DnsMessage) {
+			if subprocessors.ProcessMessage(&dm) != transformers.RETURN_DROP {
+				bufferDm = append(bufferDm, dm)
+			}
+		})
+		if len(bufferDm) > 0 {
+			o.FlushBuffer(&bufferDm)
+		}
+	}
+
 	o.LogInfo("run terminated")
 
 	// cleanup transformers
@@ -1,34 +1,50 @@
 package loggers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
+	"github.com/grafana/dskit/backoff"
 
 	"net/http"
+	"net/url"
 )
 
 type ElasticSearchClient struct {
-	done    chan bool
-	channel chan dnsutils.DnsMessage
-	config  *dnsutils.Config
-	logger  *logger.Logger
-	name    string
-	url     string
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	index      string
+	bulkUrl    string
+	bulkQueue  chan []dnsutils.DnsMessage
+	sendDone   chan bool
+	httpclient *http.Client
+	spool      *DiskQueue
 }
 
 func NewElasticSearchClient(config *dnsutils.Config, console *logger.Logger, name string) *ElasticSearchClient {
 	console.Info("[%s] logger elasticsearch - enabled", name)
 	o := &ElasticSearchClient{
-		done:    make(chan bool),
-		channel: make(chan dnsutils.DnsMessage, 512),
-		logger:  console,
-		config:  config,
-		name:    name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		sendDone:   make(chan bool),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     console,
+		config:     config,
+		name:       name,
 	}
 	o.ReadConfig()
 	return o
@@ -39,7 +55,51 @@ func (c *ElasticSearchClient) GetName() string { return c.name }
 func (c *ElasticSearchClient) SetLoggers(loggers []dnsutils.Worker) {}
 
 func (c *ElasticSearchClient) ReadConfig() {
-	c.url = c.config.Loggers.ElasticSearchClient.URL
+	server := c.config.Loggers.ElasticSearchClient.Server
+	c.index = c.config.Loggers.ElasticSearchClient.Index
+
+	// the _doc url of the previous versions is still supported, the server and
+	// the index are extracted from it
+	if len(c.config.Loggers.ElasticSearchClient.URL) > 0 {
+		var err error
+		server, c.index, err = ParseElasticDocUrl(c.config.Loggers.ElasticSearchClient.URL)
+		if err != nil {
+			c.logger.Fatal("logger elasticsearch - invalid url: ", err)
+		}
+		c.LogInfo("the url option is deprecated, use server and index")
+	}
+
+	if len(c.index) == 0 {
+		c.logger.Fatal("logger elasticsearch - index is required")
+	}
+	if c.config.Loggers.ElasticSearchClient.BulkSize <= 0 {
+		c.logger.Fatal("logger elasticsearch - invalid bulk-size: ", c.config.Loggers.ElasticSearchClient.BulkSize)
+	}
+
+	c.bulkUrl = strings.TrimSuffix(server, "/") + "/_bulk"
+
+	// the bounded buffer holds the bulks waiting to be sent
+	queueSize := c.config.Loggers.ElasticSearchClient.BufferSize / c.config.Loggers.ElasticSearchClient.BulkSize
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	c.bulkQueue = make(chan []dnsutils.DnsMessage, queueSize)
+
+	c.httpclient = &http.Client{Timeout: 5 * time.Second}
+
+	// disk spool for the messages not sent
+	if len(c.config.Loggers.ElasticSearchClient.SpoolDir) > 0 {
+		spool, err := NewDiskQueue(c.config.Loggers.ElasticSearchClient.SpoolDir, c.name, int64(c.config.Loggers.ElasticSearchClient.SpoolMaxSize)*1024*1024)
+		if err != nil {
+			c.logger.Fatal("logger elasticsearch - spool error: ", err)
+		}
+		c.spool = spool
+	}
+}
+
+func (c *ElasticSearchClient) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	dnsutils.SendConfig(c.configChan, config)
 }
 
 func (o *ElasticSearchClient) Channel() chan dnsutils.DnsMessage {
@@ -66,41 +126,251 @@ func (o *ElasticSearchClient) Stop() {
 	close(o.done)
 }
 
-func (o *ElasticSearchClient) Run() {
-	o.LogInfo("running in background...")
+// ParseElasticDocUrl returns the server and the index of a _doc url
+// like http://127.0.0.1:9200/indexname/_doc
+func ParseElasticDocUrl(docUrl string) (string, string, error) {
+	u, err := url.Parse(docUrl)
+	if err != nil {
+		return "", "", err
+	}
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return "", "", fmt.Errorf("server missing in %s", docUrl)
+	}
+	index, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(index) == 0 {
+		return "", "", fmt.Errorf("index missing in %s", docUrl)
+	}
+	return u.Scheme + "://" + u.Host + "/", index, nil
+}
 
-	// prepare transforms
-	listChannel := []chan dnsutils.DnsMessage{}
-	listChannel = append(listChannel, o.channel)
-	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+// GetIndex returns the index name of the message, the %Y, %m and %d
+// patterns are replaced by the date of the message
+func (o *ElasticSearchClient) GetIndex(dm *dnsutils.DnsMessage) string {
+	index := o.index
+	if !strings.Contains(index, "%") {
+		return index
+	}
+	ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec)).UTC()
+	replacer := strings.NewReplacer("%Y", ts.Format("2006"), "%m", ts.Format("01"), "%d", ts.Format("02"))
+	return replacer.Replace(index)
+}
 
-	for dm := range o.channel {
-		// apply tranforms
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+// EncodeBulk returns the body of the bulk request, one action and one document per message
+func (o *ElasticSearchClient) EncodeBulk(bulk []dnsutils.DnsMessage) *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	enc := json.NewEncoder(buffer)
+	for i := range bulk {
+		flat, err := bulk[i].Flatten()
+		if err != nil {
+			o.LogError("flattening DNS message failed: %e", err)
 			continue
 		}
+		action := map[string]map[string]string{"index": {"_index": o.GetIndex(&bulk[i])}}
+		enc.Encode(action)
+		enc.Encode(flat)
+	}
+	return buffer
+}
+
+// Enqueue hands the bulk to the sender, the bulk is spooled or dropped if the buffer is full
+func (o *ElasticSearchClient) Enqueue(bulk []dnsutils.DnsMessage) {
+	select {
+	case o.bulkQueue <- bulk:
+	default:
+		o.SpoolBulk(bulk, errors.New("buffer is full"))
+	}
+}
+
+// SpoolBulk stores the bulk not sent in the spool, the bulk is dropped without spool
+func (o *ElasticSearchClient) SpoolBulk(bulk []dnsutils.DnsMessage, reason error) {
+	if o.spool == nil {
+		o.LogError("%s, %d messages dropped", reason, len(bulk))
+		return
+	}
+	if _, err := o.spool.Push(bulk); err != nil {
+		o.LogError("%s, unable to spool %d messages: %s", reason, len(bulk), err)
+		return
+	}
+	o.LogError("%s, %d messages stored in the spool", reason, len(bulk))
+}
+
+// ReplaySpool sends the messages stored on disk, until the first error
+func (o *ElasticSearchClient) ReplaySpool() {
+	if o.spool == nil || o.spool.IsEmpty() {
+		return
+	}
+	if err := o.spool.Replay(o.SendBulk); err != nil {
+		o.LogError("unable to replay the spool: %s", err)
+		return
+	}
+	o.LogInfo("spool replayed")
+}
+
+// SendBulk sends the bulk with retries, an error is returned if the server is unavailable
+// after the retries, the messages rejected by the server are not retried
+func (o *ElasticSearchClient) SendBulk(bulk []dnsutils.DnsMessage) error {
+	body := o.EncodeBulk(bulk).Bytes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backoff := backoff.New(ctx, backoff.Config{
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 1 * time.Minute,
+		MaxRetries: o.config.Loggers.ElasticSearchClient.MaxRetries,
+	})
+
+	for {
+		req, err := http.NewRequest("POST", o.bulkUrl, bytes.NewReader(body))
+		if err != nil {
+			o.LogError("new http error: %s", err)
+			return nil
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
 
-		buffer := new(bytes.Buffer)
-		flat, err := dm.Flatten()
+		resp, err := o.httpclient.Do(req)
 		if err != nil {
-			o.LogError("flattening DNS message failed: %e", err)
+			o.LogError("do http error: %s", err)
+		} else {
+			// success or not retryable
+			if resp.StatusCode != 429 && resp.StatusCode/100 != 5 {
+				o.ReadBulkResponse(resp, len(bulk))
+				return nil
+			}
+			resp.Body.Close()
+			o.LogError("server returned HTTP status %s", resp.Status)
 		}
-		json.NewEncoder(buffer).Encode(flat)
 
-		req, _ := http.NewRequest("POST", o.url, buffer)
-		req.Header.Set("Content-Type", "application/json")
-		client := &http.Client{
-			Timeout: 5 * time.Second,
+		// wait before retry
+		backoff.Wait()
+		if !backoff.Ongoing() {
+			return errors.New("max retries reached")
 		}
-		_, err = client.Do(req)
-		if err != nil {
-			o.LogError(err.Error())
+	}
+}
+
+// ReadBulkResponse logs the documents rejected by the server
+func (o *ElasticSearchClient) ReadBulkResponse(resp *http.Response, total int) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1024))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		o.LogError("server returned HTTP status %s, %d messages dropped: %s", resp.Status, total, line)
+		return
+	}
+
+	result := struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+		} `json:"items"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Errors {
+		return
+	}
+
+	rejected := 0
+	for _, item := range result.Items {
+		for _, action := range item {
+			if action.Status/100 != 2 {
+				rejected++
+			}
+		}
+	}
+	o.LogError("%d/%d messages rejected by the server", rejected, total)
+}
+
+func (o *ElasticSearchClient) SendLoop() {
+	// replay the spool periodically when no bulk is sent
+	replayInterval := time.Duration(o.config.Loggers.ElasticSearchClient.FlushInterval) * time.Second
+	replayTimer := time.NewTimer(replayInterval)
+
+LOOP:
+	for {
+		select {
+		case bulk, opened := <-o.bulkQueue:
+			if !opened {
+				break LOOP
+			}
+			if err := o.SendBulk(bulk); err != nil {
+				o.SpoolBulk(bulk, err)
+			} else {
+				// the server is available, send the stored messages
+				o.ReplaySpool()
+			}
+
+		case <-replayTimer.C:
+			o.ReplaySpool()
+			replayTimer.Reset(replayInterval)
 		}
+	}
+
+	o.sendDone <- true
+}
+
+func (o *ElasticSearchClient) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// start the sender
+	go o.SendLoop()
+
+	// init bulk
+	bulk := []dnsutils.DnsMessage{}
+
+	// init flush timer for bulk
+	flushInterval := time.Duration(o.config.Loggers.ElasticSearchClient.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				break LOOP
+			}
 
-		//
-		buffer.Reset()
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// bulk is full ?
+			bulk = append(bulk, dm)
+			if len(bulk) >= o.config.Loggers.ElasticSearchClient.BulkSize {
+				o.Enqueue(bulk)
+				bulk = []dnsutils.DnsMessage{}
+			}
+
+		case <-flushTimer.C:
+			if len(bulk) > 0 {
+				o.Enqueue(bulk)
+				bulk = []dnsutils.DnsMessage{}
+			}
+
+			// restart timer
+			flushTimer.Reset(flushInterval)
+		}
+	}
 
+	// send the last bulk and wait the sender
+	if len(bulk) > 0 {
+		o.Enqueue(bulk)
 	}
+	close(o.bulkQueue)
+	<-o.sendDone
 
 	o.LogInfo("run terminated")
 
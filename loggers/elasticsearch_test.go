@@ -5,7 +5,10 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -33,6 +36,7 @@ func Test_ElasticSearchClient(t *testing.T) {
 	for _, tc := range testcases {
 		t.Run(tc.mode, func(t *testing.T) {
 			conf := dnsutils.GetFakeConfig()
+			conf.Loggers.ElasticSearchClient.BulkSize = 1
 			g := NewElasticSearchClient(conf, logger.New(false), "test")
 
 			go g.Run()
@@ -54,16 +58,104 @@ func Test_ElasticSearchClient(t *testing.T) {
 			}
 			conn.Write([]byte(dnsutils.HTTP_OK))
 
+			if request.URL.Path != "/_bulk" {
+				t.Errorf("invalid bulk path: %s", request.URL.Path)
+			}
+
 			// read payload from request body
 			payload, err := io.ReadAll(request.Body)
 			if err != nil {
 				t.Fatal(err)
 			}
 
+			// one action line and one document line
+			lines := strings.Split(strings.TrimSpace(string(payload)), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("invalid bulk payload: %s", payload)
+			}
+			if !strings.Contains(lines[0], `{"index":{"_index":"dnscollector-`) {
+				t.Errorf("invalid bulk action: %s", lines[0])
+			}
+
 			pattern := regexp.MustCompile(tc.pattern)
-			if !pattern.MatchString(string(payload)) {
-				t.Errorf("loki test error want %s, got: %s", tc.pattern, string(payload))
+			if !pattern.MatchString(lines[1]) {
+				t.Errorf("loki test error want %s, got: %s", tc.pattern, lines[1])
 			}
 		})
 	}
 }
+
+func Test_ElasticSearchClientIndex(t *testing.T) {
+	conf := dnsutils.GetFakeConfig()
+	conf.Loggers.ElasticSearchClient.Index = "dns-%Y.%m.%d"
+	g := NewElasticSearchClient(conf, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DnsTap.TimeSec = 1682035200 // 2023-04-21 00:00:00 UTC
+
+	if index := g.GetIndex(&dm); index != "dns-2023.04.21" {
+		t.Errorf("invalid index name: %s", index)
+	}
+}
+
+func Test_ElasticSearchClientSpool(t *testing.T) {
+	// the server is unavailable then returns the bulks received
+	var available atomic.Bool
+	bulks := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		payload, _ := io.ReadAll(r.Body)
+		bulks <- string(payload)
+		w.Write([]byte(`{"errors":false}`))
+	}))
+	defer server.Close()
+
+	conf := dnsutils.GetFakeConfig()
+	conf.Loggers.ElasticSearchClient.Server = server.URL
+	conf.Loggers.ElasticSearchClient.MaxRetries = 1
+	conf.Loggers.ElasticSearchClient.SpoolDir = t.TempDir()
+	g := NewElasticSearchClient(conf, logger.New(false), "test")
+
+	bulk := []dnsutils.DnsMessage{dnsutils.GetFakeDnsMessage(), dnsutils.GetFakeDnsMessage()}
+	err := g.SendBulk(bulk)
+	if err == nil {
+		t.Fatal("error expected with the server unavailable")
+	}
+	g.SpoolBulk(bulk, err)
+	if g.spool.IsEmpty() {
+		t.Fatal("bulk not stored in the spool")
+	}
+
+	// the stored messages are sent when the server is back
+	available.Store(true)
+	g.ReplaySpool()
+	payload := <-bulks
+	if lines := strings.Split(strings.TrimSpace(payload), "\n"); len(lines) != 4 || !strings.Contains(lines[1], "dns.collector") {
+		t.Errorf("invalid bulk replayed: %s", payload)
+	}
+	if !g.spool.IsEmpty() {
+		t.Errorf("spool should be empty after the replay")
+	}
+}
+
+func Test_ElasticSearchClientDeprecatedUrl(t *testing.T) {
+	conf := dnsutils.GetFakeConfig()
+	conf.Loggers.ElasticSearchClient.URL = "http://127.0.0.1:9200/indexname/_doc"
+	g := NewElasticSearchClient(conf, logger.New(false), "test")
+
+	if g.bulkUrl != "http://127.0.0.1:9200/_bulk" {
+		t.Errorf("invalid bulk url: %s", g.bulkUrl)
+	}
+	dm := dnsutils.GetFakeDnsMessage()
+	if index := g.GetIndex(&dm); index != "indexname" {
+		t.Errorf("invalid index name: %s", index)
+	}
+
+	// the index is required
+	if _, _, err := ParseElasticDocUrl("http://127.0.0.1:9200/"); err == nil {
+		t.Errorf("error expected without index")
+	}
+}
@@ -0,0 +1,161 @@
+package loggers
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+var ErrEventFilterInvalidIp = errors.New("invalid query ip or subnet")
+
+// EventFilter describes the criteria used to search in the event store,
+// empty fields are ignored
+type EventFilter struct {
+	Qname   string
+	QueryIp string
+	Rcode   string
+	From    time.Time
+	To      time.Time
+
+	subnet *net.IPNet
+	ip     net.IP
+}
+
+func (f *EventFilter) Prepare() error {
+	f.Qname = strings.ToLower(strings.TrimSuffix(f.Qname, "."))
+	if len(f.QueryIp) > 0 {
+		if _, subnet, err := net.ParseCIDR(f.QueryIp); err == nil {
+			f.subnet = subnet
+		} else if ip := net.ParseIP(f.QueryIp); ip != nil {
+			f.ip = ip
+		} else {
+			return ErrEventFilterInvalidIp
+		}
+	}
+	return nil
+}
+
+// Match returns true if the dns message matches all criteria of the filter
+// the qname can start with a wildcard, *.example.com matches all subdomains of example.com
+func (f *EventFilter) Match(dm *dnsutils.DnsMessage) bool {
+	if len(f.Qname) > 0 {
+		qname := strings.ToLower(strings.TrimSuffix(dm.DNS.Qname, "."))
+		if strings.HasPrefix(f.Qname, "*.") {
+			if !strings.HasSuffix(qname, f.Qname[1:]) {
+				return false
+			}
+		} else if qname != f.Qname {
+			return false
+		}
+	}
+
+	if f.subnet != nil || f.ip != nil {
+		ip := net.ParseIP(dm.NetworkInfo.QueryIp)
+		if ip == nil {
+			return false
+		}
+		if f.subnet != nil && !f.subnet.Contains(ip) {
+			return false
+		}
+		if f.ip != nil && !f.ip.Equal(ip) {
+			return false
+		}
+	}
+
+	if len(f.Rcode) > 0 && !strings.EqualFold(f.Rcode, dm.DNS.Rcode) {
+		return false
+	}
+
+	if !f.From.IsZero() || !f.To.IsZero() {
+		ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+		if !f.From.IsZero() && ts.Before(f.From) {
+			return false
+		}
+		if !f.To.IsZero() && ts.After(f.To) {
+			return false
+		}
+	}
+	return true
+}
+
+// EventStore keeps the most recent dns messages in a bounded ring buffer
+type EventStore struct {
+	sync.RWMutex
+	maxSize int
+	maxAge  time.Duration
+	events  []dnsutils.DnsMessage
+	next    int
+	full    bool
+}
+
+func NewEventStore(maxSize int, maxAge time.Duration) *EventStore {
+	return &EventStore{
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		events:  make([]dnsutils.DnsMessage, 0, maxSize),
+	}
+}
+
+func (s *EventStore) Add(dm dnsutils.DnsMessage) {
+	if s.maxSize <= 0 {
+		return
+	}
+
+	// raw payloads are not exported, no need to keep them in memory
+	dm.DNS.Payload = nil
+	dm.DnsTap.Payload = nil
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.full {
+		s.events = append(s.events, dm)
+	} else {
+		s.events[s.next] = dm
+	}
+	s.next++
+	if s.next == s.maxSize {
+		s.next = 0
+		s.full = true
+	}
+}
+
+func (s *EventStore) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.events)
+}
+
+// Search returns the matching events, from the newest to the oldest
+func (s *EventStore) Search(filter EventFilter, limit int) []dnsutils.DnsMessage {
+	s.RLock()
+	defer s.RUnlock()
+
+	var oldest time.Time
+	if s.maxAge > 0 {
+		oldest = time.Now().Add(-s.maxAge)
+	}
+
+	ret := []dnsutils.DnsMessage{}
+	for i := 1; i <= len(s.events); i++ {
+		idx := (s.next - i + len(s.events)) % len(s.events)
+		dm := &s.events[idx]
+
+		// expired events
+		if !oldest.IsZero() && time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec)).Before(oldest) {
+			continue
+		}
+		if !filter.Match(dm) {
+			continue
+		}
+		ret = append(ret, *dm)
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+	}
+	return ret
+}
@@ -0,0 +1,198 @@
+package loggers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+func init() {
+	dnsutils.RegisterLogger("exec", func(config *dnsutils.Config, logger *logger.Logger, name string,
+		params map[string]interface{}) (dnsutils.Worker, error) {
+		return NewExec(config, logger, name, params)
+	})
+}
+
+type ExecConfig struct {
+	Command    []string `yaml:"command"`
+	Mode       string   `yaml:"mode"`
+	TextFormat string   `yaml:"text-format"`
+}
+
+// Exec writes the messages to the stdin of an external process, one message per line
+type Exec struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	textFormat []string
+	params     ExecConfig
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+}
+
+func NewExec(config *dnsutils.Config, logger *logger.Logger, name string, params map[string]interface{}) (*Exec, error) {
+	logger.Info("[%s] logger exec - enabled", name)
+	o := &Exec{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		params:     ExecConfig{Mode: dnsutils.MODE_JSON},
+		config:     config,
+		logger:     logger,
+		name:       name,
+	}
+	if err := dnsutils.DecodePluginParams(params, &o.params); err != nil {
+		return nil, err
+	}
+	if len(o.params.Command) == 0 {
+		return nil, errors.New("exec: command is required")
+	}
+	switch o.params.Mode {
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON:
+	default:
+		return nil, errors.New("exec: invalid mode " + o.params.Mode)
+	}
+	o.ReadConfig()
+	return o, nil
+}
+
+func (c *Exec) GetName() string { return c.name }
+
+func (c *Exec) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (c *Exec) ReadConfig() {
+	if len(c.params.TextFormat) > 0 {
+		c.textFormat = strings.Fields(c.params.TextFormat)
+	} else {
+		c.textFormat = strings.Fields(c.config.Global.TextFormat)
+	}
+}
+
+func (c *Exec) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	dnsutils.SendConfig(c.configChan, config)
+}
+
+func (c *Exec) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] logger exec - "+msg, v...)
+}
+
+func (c *Exec) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] logger exec - "+msg, v...)
+}
+
+func (o *Exec) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+// Start starts the external process
+func (o *Exec) Start() error {
+	cmd := exec.Command(o.params.Command[0], o.params.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	o.cmd = cmd
+	o.stdin = stdin
+	o.LogInfo("process %s started", o.params.Command[0])
+	return nil
+}
+
+func (o *Exec) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+func (o *Exec) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// start the process
+	if o.cmd == nil {
+		if err := o.Start(); err != nil {
+			o.logger.Fatal("process failed: ", err)
+		}
+	}
+	writer := bufio.NewWriter(o.stdin)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			switch o.params.Mode {
+			case dnsutils.MODE_TEXT:
+				writer.Write(dm.Bytes(o.textFormat,
+					o.config.Global.TextFormatDelimiter,
+					o.config.Global.TextFormatBoundary))
+				writer.WriteString("\n")
+
+			case dnsutils.MODE_JSON:
+				json.NewEncoder(writer).Encode(dm)
+
+			case dnsutils.MODE_FLATJSON:
+				flat, err := dm.Flatten()
+				if err != nil {
+					o.LogError("flattening DNS message failed: %e", err)
+					continue
+				}
+				json.NewEncoder(writer).Encode(flat)
+			}
+
+			// the messages are sent without delay to the process
+			if err := writer.Flush(); err != nil {
+				o.LogError("write error: %v", err)
+			}
+		}
+	}
+
+	// close the stdin of the process and wait for its end
+	o.stdin.Close()
+	if err := o.cmd.Wait(); err != nil {
+		o.LogError("process terminated: %v", err)
+	}
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
@@ -0,0 +1,49 @@
+package loggers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_ExecRun(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output.log")
+
+	factory, ok := dnsutils.GetLoggerPlugin("exec")
+	if !ok {
+		t.Fatalf("exec logger not registered")
+	}
+	params := map[string]interface{}{
+		"command":     []string{"sh", "-c", "cat > " + output},
+		"mode":        "text",
+		"text-format": "qname",
+	}
+	g, err := factory(dnsutils.GetFakeConfig(), logger.New(false), "test", params)
+	if err != nil {
+		t.Fatalf("exec logger error: %s", err)
+	}
+	go g.Run()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	g.Channel() <- dm
+	g.Stop()
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+	if strings.TrimSpace(string(data)) != "dns.collector" {
+		t.Errorf("invalid output: %s", data)
+	}
+}
+
+func Test_ExecInvalidMode(t *testing.T) {
+	params := map[string]interface{}{"command": []string{"cat"}, "mode": "invalid"}
+	if _, err := NewExec(dnsutils.GetFakeConfig(), logger.New(false), "test", params); err == nil {
+		t.Errorf("error expected for an invalid mode")
+	}
+}
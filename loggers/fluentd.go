@@ -1,7 +1,11 @@
 package loggers
 
 import (
+	"crypto/rand"
+	"crypto/sha512"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -15,6 +19,7 @@ import (
 
 type FluentdClient struct {
 	done               chan bool
+	configChan         chan *dnsutils.Config
 	channel            chan dnsutils.DnsMessage
 	config             *dnsutils.Config
 	logger             *logger.Logger
@@ -30,6 +35,7 @@ func NewFluentdClient(config *dnsutils.Config, logger *logger.Logger, name strin
 	logger.Info("[%s] logger to fluentd - enabled", name)
 	s := &FluentdClient{
 		done:               make(chan bool),
+		configChan:         make(chan *dnsutils.Config, 1),
 		exit:               make(chan bool),
 		channel:            make(chan dnsutils.DnsMessage, 512),
 		transportReady:     make(chan bool),
@@ -54,6 +60,11 @@ func (o *FluentdClient) ReadConfig() {
 	}
 }
 
+func (o *FluentdClient) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
 func (o *FluentdClient) LogInfo(msg string, v ...interface{}) {
 	o.logger.Info("["+o.name+"] logger to fluentd - "+msg, v...)
 }
@@ -90,10 +101,10 @@ func (o *FluentdClient) ConnectToRemote() {
 	if len(o.config.Loggers.Fluentd.SockPath) > 0 {
 		address = o.config.Loggers.Fluentd.SockPath
 	} else {
-		address = o.config.Loggers.Fluentd.RemoteAddress + ":" + strconv.Itoa(o.config.Loggers.Fluentd.RemotePort)
+		address = net.JoinHostPort(o.config.Loggers.Fluentd.RemoteAddress, strconv.Itoa(o.config.Loggers.Fluentd.RemotePort))
 	}
 
-	connTimeout := time.Duration(o.config.Loggers.Dnstap.ConnectTimeout) * time.Second
+	connTimeout := time.Duration(o.config.Loggers.Fluentd.ConnectTimeout) * time.Second
 
 	// make the connection
 	for {
@@ -121,6 +132,13 @@ func (o *FluentdClient) ConnectToRemote() {
 			o.transportConn, err = net.DialTimeout(o.config.Loggers.Fluentd.Transport, address, connTimeout)
 		}
 
+		// authenticate with the shared key
+		if err == nil && len(o.config.Loggers.Fluentd.SharedKey) > 0 {
+			o.transportConn.SetDeadline(time.Now().Add(connTimeout))
+			err = o.Handshake(o.transportConn)
+			o.transportConn.SetDeadline(time.Time{})
+		}
+
 		// something is wrong during connection ?
 		if err != nil {
 			o.LogError("connect error: %s", err)
@@ -137,6 +155,83 @@ func (o *FluentdClient) ConnectToRemote() {
 	}
 }
 
+// FluentdDigest returns the hex sha512 digest of the values, as expected by the forward protocol
+func FluentdDigest(values ...[]byte) string {
+	h := sha512.New()
+	for _, v := range values {
+		h.Write(v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func fluentdBytes(v interface{}) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	return nil
+}
+
+// Handshake authenticates the client with the shared key, see the forward protocol
+// HELO ["HELO", {"nonce": ..., "auth": ..., "keepalive": ...}]
+// PING ["PING", hostname, salt, sha512(salt + hostname + nonce + key), username, password digest]
+// PONG ["PONG", result, reason, hostname, sha512(salt + hostname + nonce + key)]
+func (o *FluentdClient) Handshake(conn net.Conn) error {
+	dec := msgpack.NewDecoder(conn)
+
+	// wait the HELO from the server
+	var helo []interface{}
+	if err := dec.Decode(&helo); err != nil {
+		return fmt.Errorf("handshake read HELO: %w", err)
+	}
+	if len(helo) < 2 || helo[0] != "HELO" {
+		return errors.New("handshake unexpected message, HELO expected")
+	}
+	options, ok := helo[1].(map[string]interface{})
+	if !ok {
+		return errors.New("handshake invalid HELO options")
+	}
+	nonce := fluentdBytes(options["nonce"])
+	if len(fluentdBytes(options["auth"])) > 0 {
+		return errors.New("handshake user authentication is not supported")
+	}
+
+	// send the PING with the shared key digest
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	hostname := o.config.GetServerIdentity()
+	sharedKey := []byte(o.config.Loggers.Fluentd.SharedKey)
+	ping, err := msgpack.Marshal([]interface{}{"PING", hostname, salt,
+		FluentdDigest(salt, []byte(hostname), nonce, sharedKey), "", ""})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(ping); err != nil {
+		return fmt.Errorf("handshake write PING: %w", err)
+	}
+
+	// read the PONG and verify the server knows the shared key too
+	var pong []interface{}
+	if err := dec.Decode(&pong); err != nil {
+		return fmt.Errorf("handshake read PONG: %w", err)
+	}
+	if len(pong) < 5 || pong[0] != "PONG" {
+		return errors.New("handshake unexpected message, PONG expected")
+	}
+	if result, _ := pong[1].(bool); !result {
+		return fmt.Errorf("handshake authentication failed: %v", pong[2])
+	}
+	serverHostname := fluentdBytes(pong[3])
+	if string(fluentdBytes(pong[4])) != FluentdDigest(salt, serverHostname, nonce, sharedKey) {
+		return errors.New("handshake shared key mismatch")
+	}
+	return nil
+}
+
 func (o *FluentdClient) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 
 	tag, _ := msgpack.Marshal(o.config.Loggers.Fluentd.Tag)
@@ -170,6 +265,9 @@ func (o *FluentdClient) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 			break
 		}
 	}
+
+	// reset buffer
+	*buf = nil
 }
 
 func (o *FluentdClient) Run() {
@@ -184,7 +282,7 @@ func (o *FluentdClient) Run() {
 	bufferDm := []dnsutils.DnsMessage{}
 
 	// init flust timer for buffer
-	flushInterval := time.Duration(o.config.Loggers.TcpClient.FlushInterval) * time.Second
+	flushInterval := time.Duration(o.config.Loggers.Fluentd.FlushInterval) * time.Second
 	flushTimer := time.NewTimer(flushInterval)
 
 	// init remote conn
@@ -193,6 +291,10 @@ func (o *FluentdClient) Run() {
 LOOP:
 	for {
 		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
 		case <-o.transportReady:
 			o.LogInfo("connected")
 			o.writerReady = true
@@ -213,7 +315,7 @@ LOOP:
 			bufferDm = append(bufferDm, dm)
 
 			// buffer is full ?
-			if len(bufferDm) >= o.config.Loggers.TcpClient.BufferSize {
+			if len(bufferDm) >= o.config.Loggers.Fluentd.BufferSize {
 				o.FlushBuffer(&bufferDm)
 			}
 
@@ -239,6 +341,18 @@ LOOP:
 		}
 	}
 
+	// the messages received before the stop are sent
+	if o.writerReady {
+		dnsutils.DrainChannel(o.channel, func(dm dnsutils.DnsMessage) {
+			if subprocessors.ProcessMessage(&dm) != transformers.RETURN_DROP {
+				bufferDm = append(bufferDm, dm)
+			}
+		})
+		if len(bufferDm) > 0 {
+			o.FlushBuffer(&bufferDm)
+		}
+	}
+
 	o.LogInfo("run terminated")
 
 	// cleanup transformers
@@ -69,3 +69,65 @@ func Test_FluentdClient(t *testing.T) {
 		})
 	}
 }
+
+func Test_FluentdClientSharedKey(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Fluentd.RemotePort = 24225
+	cfg.Loggers.Fluentd.FlushInterval = 1
+	cfg.Loggers.Fluentd.BufferSize = 0
+	cfg.Loggers.Fluentd.SharedKey = "secret"
+	g := NewFluentdClient(cfg, logger.New(false), "test")
+
+	// fake fluentd server
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, ":24225")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// start the logger
+	go g.Run()
+
+	// accept conn from logger
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// send HELO
+	nonce := []byte("nonce")
+	helo, _ := msgpack.Marshal([]interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}})
+	conn.Write(helo)
+
+	// read PING and verify the digest
+	dec := msgpack.NewDecoder(conn)
+	var ping []interface{}
+	if err := dec.Decode(&ping); err != nil {
+		t.Fatal(err)
+	}
+	hostname := []byte(ping[1].(string))
+	salt := ping[2].([]byte)
+	if ping[3] != FluentdDigest(salt, hostname, nonce, []byte("secret")) {
+		t.Fatalf("invalid shared key digest in PING")
+	}
+
+	// send PONG
+	pong, _ := msgpack.Marshal([]interface{}{"PONG", true, "", "server",
+		FluentdDigest(salt, []byte("server"), nonce, []byte("secret"))})
+	conn.Write(pong)
+
+	// send fake dns message to logger
+	time.Sleep(time.Second)
+	dm := dnsutils.GetFakeDnsMessage()
+	g.channel <- dm
+
+	// Message ::= [ Tag, Time, Record ]
+	var event []interface{}
+	if err := dec.Decode(&event); err != nil {
+		t.Fatalf("error to read event: %s", err)
+	}
+	if len(event) != 3 || event[0] != cfg.Loggers.Fluentd.Tag {
+		t.Errorf("invalid event: %v", event)
+	}
+}
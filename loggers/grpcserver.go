@@ -0,0 +1,360 @@
+package loggers
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SubscribeRequest is the request of the Subscribe rpc, see dnsutils/schema/subscribe.proto
+type SubscribeRequest struct {
+	Filter string
+}
+
+func (r *SubscribeRequest) MarshalProto() []byte {
+	var b []byte
+	if len(r.Filter) > 0 {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, r.Filter)
+	}
+	return b
+}
+
+func (r *SubscribeRequest) UnmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			r.Filter = v
+			b = b[n:]
+			continue
+		}
+
+		// unknown fields are ignored
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// GrpcCodec encodes the messages of the service, the dns messages are already
+// encoded according to the protobuf schema
+type GrpcCodec struct{}
+
+func (GrpcCodec) Name() string { return "proto" }
+
+func (GrpcCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case []byte:
+		return m, nil
+	case *SubscribeRequest:
+		return m.MarshalProto(), nil
+	}
+	return nil, fmt.Errorf("grpc codec: unsupported type %T", v)
+}
+
+func (GrpcCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *[]byte:
+		*m = append((*m)[:0], data...)
+		return nil
+	case *SubscribeRequest:
+		return m.UnmarshalProto(data)
+	}
+	return fmt.Errorf("grpc codec: unsupported type %T", v)
+}
+
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dnscollector.DnsCollector",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       grpcSubscribeHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "subscribe.proto",
+}
+
+// GrpcSubscribe subscribes to the messages matching the filter, the connection must use the GrpcCodec
+func GrpcSubscribe(ctx context.Context, conn *grpc.ClientConn, filter string) (grpc.ClientStream, error) {
+	stream, err := conn.NewStream(ctx, &grpcServiceDesc.Streams[0], "/"+grpcServiceDesc.ServiceName+"/Subscribe")
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(&SubscribeRequest{Filter: filter}); err != nil {
+		return nil, err
+	}
+	return stream, stream.CloseSend()
+}
+
+func grpcSubscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(SubscribeRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(*GrpcServer).Subscribe(req, stream)
+}
+
+// subscription to the live messages, the messages are dropped when the client is too slow
+type grpcSubscriber struct {
+	filter  *dnsutils.Expression
+	channel chan []byte
+	dropped int
+}
+
+type GrpcServer struct {
+	sync.Mutex
+	done        chan bool
+	configChan  chan *dnsutils.Config
+	channel     chan dnsutils.DnsMessage
+	listen      net.Listener
+	server      *grpc.Server
+	subscribers map[*grpcSubscriber]bool
+	config      *dnsutils.Config
+	logger      *logger.Logger
+	name        string
+}
+
+func NewGrpcServer(config *dnsutils.Config, logger *logger.Logger, name string) *GrpcServer {
+	logger.Info("[%s] logger grpc server - enabled", name)
+	o := &GrpcServer{
+		done:        make(chan bool),
+		configChan:  make(chan *dnsutils.Config, 1),
+		channel:     make(chan dnsutils.DnsMessage, 512),
+		subscribers: make(map[*grpcSubscriber]bool),
+		config:      config,
+		logger:      logger,
+		name:        name,
+	}
+	o.ReadConfig()
+	return o
+}
+
+func (c *GrpcServer) GetName() string { return c.name }
+
+func (c *GrpcServer) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *GrpcServer) ReadConfig() {
+	if !dnsutils.IsValidTLS(o.config.Loggers.GrpcServer.TlsMinVersion) {
+		o.logger.Fatal("logger grpc server - invalid tls min version")
+	}
+}
+
+func (o *GrpcServer) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (o *GrpcServer) LogInfo(msg string, v ...interface{}) {
+	o.logger.Info("["+o.name+"] logger grpc server - "+msg, v...)
+}
+
+func (o *GrpcServer) LogError(msg string, v ...interface{}) {
+	o.logger.Error("["+o.name+"] logger grpc server - "+msg, v...)
+}
+
+func (o *GrpcServer) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+// Authenticate checks the bearer token of the rpc, if a token is configured
+func (o *GrpcServer) Authenticate(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	token := o.config.Loggers.GrpcServer.AuthToken
+	if len(token) > 0 {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		values := md.Get("authorization")
+		if len(values) == 0 || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(values[0], "Bearer ")), []byte(token)) != 1 {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+	}
+	return handler(srv, stream)
+}
+
+// Subscribe streams the messages matching the filter of the request until the client leaves
+func (o *GrpcServer) Subscribe(req *SubscribeRequest, stream grpc.ServerStream) error {
+	sub := &grpcSubscriber{channel: make(chan []byte, o.config.Loggers.GrpcServer.BufferSize)}
+	if len(req.Filter) > 0 {
+		expr, err := dnsutils.ParseExpression(req.Filter)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+		}
+		sub.filter = expr
+	}
+
+	client := "-"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		client = p.Addr.String()
+	}
+	o.LogInfo("%s - new subscription, filter: %s", client, req.Filter)
+
+	o.Lock()
+	o.subscribers[sub] = true
+	o.Unlock()
+	defer func() {
+		o.Lock()
+		delete(o.subscribers, sub)
+		dropped := sub.dropped
+		o.Unlock()
+		o.LogInfo("%s - subscription terminated, %d messages dropped", client, dropped)
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case payload := <-sub.channel:
+			if err := stream.SendMsg(payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Dispatch sends the message to the subscribers, the message is encoded only once
+func (o *GrpcServer) Dispatch(dm *dnsutils.DnsMessage) {
+	o.Lock()
+	defer o.Unlock()
+
+	var payload []byte
+	for sub := range o.subscribers {
+		if sub.filter != nil && !sub.filter.Match(dm) {
+			continue
+		}
+		if payload == nil {
+			payload, _ = dm.ToProtobuf()
+		}
+		select {
+		case sub.channel <- payload:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+func (o *GrpcServer) Listen() error {
+	o.LogInfo("starting server...")
+
+	addrlisten := net.JoinHostPort(o.config.Loggers.GrpcServer.ListenIP, strconv.Itoa(o.config.Loggers.GrpcServer.ListenPort))
+	listener, err := net.Listen(dnsutils.SOCKET_TCP, addrlisten)
+	if err != nil {
+		return err
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ForceServerCodec(GrpcCodec{}),
+		grpc.StreamInterceptor(o.Authenticate),
+	}
+
+	// listening with tls enabled ?
+	if o.config.Loggers.GrpcServer.TlsSupport {
+		o.LogInfo("tls support enabled")
+		cer, err := tls.LoadX509KeyPair(o.config.Loggers.GrpcServer.CertFile, o.config.Loggers.GrpcServer.KeyFile)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+
+		// prepare tls configuration
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   dnsutils.TLS_VERSION[o.config.Loggers.GrpcServer.TlsMinVersion],
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	o.server = grpc.NewServer(opts...)
+	o.server.RegisterService(&grpcServiceDesc, o)
+	o.listen = listener
+	o.LogInfo("is listening on %s", listener.Addr())
+	return nil
+}
+
+func (o *GrpcServer) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+func (o *GrpcServer) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// start grpc server
+	if o.listen == nil {
+		if err := o.Listen(); err != nil {
+			o.logger.Fatal("listening failed: ", err)
+		}
+	}
+	go o.server.Serve(o.listen)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			o.Dispatch(&dm)
+		}
+	}
+
+	// close the subscriptions and the listener
+	o.server.Stop()
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
@@ -0,0 +1,99 @@
+package loggers
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func grpcSubscribe(t *testing.T, ctx context.Context, filter string) grpc.ClientStream {
+	conn, err := grpc.Dial("127.0.0.1:50055",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(GrpcCodec{})))
+	if err != nil {
+		t.Fatalf("grpc dial error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := GrpcSubscribe(ctx, conn, filter)
+	if err != nil {
+		t.Fatalf("grpc subscribe error: %s", err)
+	}
+	return stream
+}
+
+func Test_GrpcServer_SubscribeRequest(t *testing.T) {
+	req := SubscribeRequest{Filter: `qname == "dns.collector"`}
+
+	decoded := SubscribeRequest{}
+	if err := decoded.UnmarshalProto(req.MarshalProto()); err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+	if decoded.Filter != req.Filter {
+		t.Errorf("invalid filter: %s", decoded.Filter)
+	}
+}
+
+func Test_GrpcServer(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.GrpcServer.ListenPort = 50055
+	config.Loggers.GrpcServer.AuthToken = "changeme"
+
+	g := NewGrpcServer(config, logger.New(false), "test")
+	if err := g.Listen(); err != nil {
+		t.Fatalf("listening error: %s", err)
+	}
+	go g.Run()
+	defer g.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// without token
+	stream := grpcSubscribe(t, ctx, "")
+	var payload []byte
+	if err := stream.RecvMsg(&payload); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("unauthenticated error expected: %v", err)
+	}
+
+	// invalid filter
+	authCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer changeme")
+	stream = grpcSubscribe(t, authCtx, `qname ==`)
+	if err := stream.RecvMsg(&payload); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid argument error expected: %v", err)
+	}
+
+	// subscribe to the nxdomain messages
+	stream = grpcSubscribe(t, authCtx, `rcode == "NXDOMAIN"`)
+	for {
+		g.Lock()
+		n := len(g.subscribers)
+		g.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dm := dnsutils.GetFakeDnsMessage()
+	g.Channel() <- dm
+	dm.DNS.Rcode = dnsutils.DNS_RCODE_NXDOMAIN
+	g.Channel() <- dm
+
+	if err := stream.RecvMsg(&payload); err != nil {
+		t.Fatalf("grpc receive error: %s", err)
+	}
+	expected, _ := dm.ToProtobuf()
+	if !bytes.Equal(payload, expected) {
+		t.Errorf("nxdomain message expected")
+	}
+}
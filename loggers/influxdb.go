@@ -14,6 +14,7 @@ import (
 
 type InfluxDBClient struct {
 	done         chan bool
+	configChan   chan *dnsutils.Config
 	channel      chan dnsutils.DnsMessage
 	config       *dnsutils.Config
 	logger       *logger.Logger
@@ -27,12 +28,13 @@ func NewInfluxDBClient(config *dnsutils.Config, logger *logger.Logger, name stri
 	logger.Info("[%s] logger to influxdb - enabled", name)
 
 	s := &InfluxDBClient{
-		done:    make(chan bool),
-		exit:    make(chan bool),
-		channel: make(chan dnsutils.DnsMessage, 512),
-		logger:  logger,
-		config:  config,
-		name:    name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		exit:       make(chan bool),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		config:     config,
+		name:       name,
 	}
 
 	s.ReadConfig()
@@ -50,6 +52,11 @@ func (o *InfluxDBClient) ReadConfig() {
 	}
 }
 
+func (o *InfluxDBClient) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
 func (o *InfluxDBClient) LogInfo(msg string, v ...interface{}) {
 	o.logger.Info("["+o.name+"] logger to influxdb - "+msg, v...)
 }
@@ -115,26 +122,37 @@ func (o *InfluxDBClient) Run() {
 
 	o.influxdbConn = influxClient
 	o.writeAPI = writeAPI
-	for dm := range o.channel {
-
-		// apply tranforms
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			p := influxdb2.NewPointWithMeasurement("dns").
+				AddTag("Identity", dm.DnsTap.Identity).
+				AddTag("QueryIP", dm.NetworkInfo.QueryIp).
+				AddTag("Qname", dm.DNS.Qname).
+				AddField("Operation", dm.DnsTap.Operation).
+				AddField("Family", dm.NetworkInfo.Family).
+				AddField("Protocol", dm.NetworkInfo.Protocol).
+				AddField("Qtype", dm.DNS.Qtype).
+				AddField("Rcode", dm.DNS.Rcode).
+				SetTime(time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec)))
+
+			// write asynchronously
+			o.writeAPI.WritePoint(p)
 		}
-
-		p := influxdb2.NewPointWithMeasurement("dns").
-			AddTag("Identity", dm.DnsTap.Identity).
-			AddTag("QueryIP", dm.NetworkInfo.QueryIp).
-			AddTag("Qname", dm.DNS.Qname).
-			AddField("Operation", dm.DnsTap.Operation).
-			AddField("Family", dm.NetworkInfo.Family).
-			AddField("Protocol", dm.NetworkInfo.Protocol).
-			AddField("Qtype", dm.DNS.Qtype).
-			AddField("Rcode", dm.DNS.Rcode).
-			SetTime(time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec)))
-
-		// write asynchronously
-		o.writeAPI.WritePoint(p)
 	}
 
 	o.LogInfo("run terminated")
@@ -0,0 +1,403 @@
+package loggers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+type KafkaProducer struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	exit       chan bool
+	textFormat []string
+	name       string
+	writer     *kafka.Writer
+	schemaId   int
+	spool      *DiskQueue
+}
+
+func NewKafkaProducer(config *dnsutils.Config, logger *logger.Logger, name string) *KafkaProducer {
+	logger.Info("[%s] logger to kafka - enabled", name)
+	s := &KafkaProducer{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		exit:       make(chan bool),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		config:     config,
+		name:       name,
+	}
+
+	s.ReadConfig()
+
+	return s
+}
+
+func (c *KafkaProducer) GetName() string { return c.name }
+
+func (c *KafkaProducer) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *KafkaProducer) ReadConfig() {
+	if !dnsutils.IsValidTLS(o.config.Loggers.KafkaProducer.TlsMinVersion) {
+		o.logger.Fatal("logger kafka - invalid tls min version")
+	}
+
+	if len(o.config.Loggers.KafkaProducer.Topic) == 0 {
+		o.logger.Fatal("logger kafka - topic is required")
+	}
+
+	switch o.config.Loggers.KafkaProducer.Mode {
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON, dnsutils.MODE_PROTOBUF, dnsutils.MODE_AVRO:
+	default:
+		o.logger.Fatal("logger kafka - invalid mode: ", o.config.Loggers.KafkaProducer.Mode)
+	}
+
+	switch o.config.Loggers.KafkaProducer.PartitionKey {
+	case "", dnsutils.PARTITION_KEY_QNAME, dnsutils.PARTITION_KEY_QUERYIP:
+	default:
+		o.logger.Fatal("logger kafka - invalid partition-key: ", o.config.Loggers.KafkaProducer.PartitionKey)
+	}
+
+	if len(o.config.Loggers.KafkaProducer.TextFormat) > 0 {
+		o.textFormat = strings.Fields(o.config.Loggers.KafkaProducer.TextFormat)
+	} else {
+		o.textFormat = strings.Fields(o.config.Global.TextFormat)
+	}
+
+	transport, err := o.NewTransport()
+	if err != nil {
+		o.logger.Fatal("logger kafka - ", err)
+	}
+
+	address := net.JoinHostPort(o.config.Loggers.KafkaProducer.RemoteAddress, strconv.Itoa(o.config.Loggers.KafkaProducer.RemotePort))
+	o.writer = &kafka.Writer{
+		Addr:      kafka.TCP(address),
+		Topic:     o.config.Loggers.KafkaProducer.Topic,
+		Balancer:  &kafka.Hash{},
+		Transport: transport,
+		BatchSize: o.config.Loggers.KafkaProducer.BufferSize,
+	}
+
+	// disk spool for the messages not published
+	if len(o.config.Loggers.KafkaProducer.SpoolDir) > 0 {
+		spool, err := NewDiskQueue(o.config.Loggers.KafkaProducer.SpoolDir, o.name, int64(o.config.Loggers.KafkaProducer.SpoolMaxSize)*1024*1024)
+		if err != nil {
+			o.logger.Fatal("logger kafka - spool error: ", err)
+		}
+		o.spool = spool
+	}
+}
+
+func (o *KafkaProducer) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+// NewTransport returns the kafka transport with the tls and sasl settings
+func (o *KafkaProducer) NewTransport() (*kafka.Transport, error) {
+	cfg := o.config.Loggers.KafkaProducer
+	transport := &kafka.Transport{
+		Dial:        (&net.Dialer{Timeout: time.Duration(cfg.ConnectTimeout) * time.Second}).DialContext,
+		DialTimeout: time.Duration(cfg.ConnectTimeout) * time.Second,
+		ClientID:    o.name,
+	}
+
+	if cfg.TlsSupport {
+		transport.TLS = &tls.Config{
+			MinVersion:         dnsutils.TLS_VERSION[cfg.TlsMinVersion],
+			InsecureSkipVerify: cfg.TlsInsecure,
+		}
+	}
+
+	if cfg.SaslSupport {
+		switch cfg.SaslMechanism {
+		case dnsutils.SASL_MECHANISM_PLAIN:
+			transport.SASL = plain.Mechanism{Username: cfg.SaslUsername, Password: cfg.SaslPassword}
+		case dnsutils.SASL_MECHANISM_SCRAM:
+			mechanism, err := scram.Mechanism(scram.SHA512, cfg.SaslUsername, cfg.SaslPassword)
+			if err != nil {
+				return nil, err
+			}
+			transport.SASL = mechanism
+		default:
+			return nil, errors.New("invalid sasl mechanism " + cfg.SaslMechanism)
+		}
+	}
+	return transport, nil
+}
+
+func (o *KafkaProducer) LogInfo(msg string, v ...interface{}) {
+	o.logger.Info("["+o.name+"] logger to kafka - "+msg, v...)
+}
+
+func (o *KafkaProducer) LogError(msg string, v ...interface{}) {
+	o.logger.Error("["+o.name+"] logger to kafka - "+msg, v...)
+}
+
+func (o *KafkaProducer) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *KafkaProducer) Stop() {
+	o.LogInfo("stopping...")
+
+	// exit to close properly
+	o.exit <- true
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// GetPartitionKey returns the key used to select the partition,
+// messages without key are balanced between the partitions
+func (o *KafkaProducer) GetPartitionKey(dm *dnsutils.DnsMessage) []byte {
+	switch o.config.Loggers.KafkaProducer.PartitionKey {
+	case dnsutils.PARTITION_KEY_QNAME:
+		return []byte(dm.DNS.Qname)
+	case dnsutils.PARTITION_KEY_QUERYIP:
+		return []byte(dm.NetworkInfo.QueryIp)
+	}
+	return nil
+}
+
+// RegisterSchema registers the avro schema in the schema registry, under the
+// subject of the topic values, and keeps its id for the messages
+func (o *KafkaProducer) RegisterSchema() error {
+	cfg := o.config.Loggers.KafkaProducer
+
+	body, err := json.Marshal(map[string]string{"schema": dnsutils.AvroSchemaDefinition})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ConnectTimeout)*time.Second)
+	defer cancel()
+
+	url := strings.TrimSuffix(cfg.SchemaRegistryUrl, "/") + "/subjects/" + cfg.Topic + "-value/versions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("schema registry error: %s", resp.Status)
+	}
+
+	var schema struct {
+		Id int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&schema); err != nil {
+		return err
+	}
+	o.schemaId = schema.Id
+	o.LogInfo("avro schema registered with id %d", o.schemaId)
+	return nil
+}
+
+func (o *KafkaProducer) BuildMessage(dm *dnsutils.DnsMessage) (kafka.Message, error) {
+	msg := kafka.Message{Key: o.GetPartitionKey(dm)}
+
+	switch o.config.Loggers.KafkaProducer.Mode {
+	case dnsutils.MODE_TEXT:
+		msg.Value = dm.Bytes(o.textFormat,
+			o.config.Global.TextFormatDelimiter,
+			o.config.Global.TextFormatBoundary)
+	case dnsutils.MODE_JSON:
+		value, err := json.Marshal(dm)
+		if err != nil {
+			return msg, err
+		}
+		msg.Value = value
+	case dnsutils.MODE_FLATJSON:
+		flat, err := dm.Flatten()
+		if err != nil {
+			return msg, err
+		}
+		value, err := json.Marshal(flat)
+		if err != nil {
+			return msg, err
+		}
+		msg.Value = value
+	case dnsutils.MODE_PROTOBUF:
+		value, err := dm.ToProtobuf()
+		if err != nil {
+			return msg, err
+		}
+		msg.Value = value
+	case dnsutils.MODE_AVRO:
+		value, err := dm.ToAvro()
+		if err != nil {
+			return msg, err
+		}
+		// wire format of the schema registry: magic byte and schema id
+		if o.schemaId > 0 {
+			header := make([]byte, 5)
+			binary.BigEndian.PutUint32(header[1:], uint32(o.schemaId))
+			value = append(header, value...)
+		}
+		msg.Value = value
+	}
+	return msg, nil
+}
+
+// WriteMessages publishes the dns messages, the messages which can not be encoded are ignored
+func (o *KafkaProducer) WriteMessages(dms []dnsutils.DnsMessage) error {
+	msgs := make([]kafka.Message, 0, len(dms))
+	for i := range dms {
+		msg, err := o.BuildMessage(&dms[i])
+		if err != nil {
+			o.LogError("encoding DNS message failed: %s", err)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	timeout := time.Duration(o.config.Loggers.KafkaProducer.ConnectTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return o.writer.WriteMessages(ctx, msgs...)
+}
+
+// ReplaySpool publishes the messages stored on disk, until the first error
+func (o *KafkaProducer) ReplaySpool() {
+	if o.spool == nil || o.spool.IsEmpty() {
+		return
+	}
+	if err := o.spool.Replay(o.WriteMessages); err != nil {
+		o.LogError("unable to replay the spool: %s", err)
+		return
+	}
+	o.LogInfo("spool replayed")
+}
+
+func (o *KafkaProducer) FlushBuffer(buf *[]dnsutils.DnsMessage) {
+	cfg := o.config.Loggers.KafkaProducer
+	if cfg.Mode == dnsutils.MODE_AVRO && len(cfg.SchemaRegistryUrl) > 0 && o.schemaId == 0 {
+		if err := o.RegisterSchema(); err != nil {
+			o.LogError("unable to register the avro schema, %d messages dropped: %s", len(*buf), err)
+			*buf = nil
+			return
+		}
+	}
+
+	if err := o.WriteMessages(*buf); err != nil {
+		if o.spool == nil {
+			o.LogError("unable to publish %d messages: %s", len(*buf), err)
+		} else if _, err := o.spool.Push(*buf); err != nil {
+			o.LogError("unable to publish and to spool %d messages: %s", len(*buf), err)
+		} else {
+			o.LogError("unable to publish %d messages, stored in the spool: %s", len(*buf), err)
+		}
+	} else {
+		// the server is available, send the stored messages
+		o.ReplaySpool()
+	}
+
+	// reset buffer
+	*buf = nil
+}
+
+func (o *KafkaProducer) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// init buffer
+	bufferDm := []dnsutils.DnsMessage{}
+
+	// init flust timer for buffer
+	flushInterval := time.Duration(o.config.Loggers.KafkaProducer.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case <-o.exit:
+			o.logger.Info("closing loop...")
+			break LOOP
+
+		case dm := <-o.channel:
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// append dns message to buffer
+			bufferDm = append(bufferDm, dm)
+
+			// buffer is full ?
+			if len(bufferDm) >= o.config.Loggers.KafkaProducer.BufferSize {
+				o.FlushBuffer(&bufferDm)
+			}
+
+		// flush the buffer
+		case <-flushTimer.C:
+			if len(bufferDm) > 0 {
+				o.FlushBuffer(&bufferDm)
+			} else {
+				o.ReplaySpool()
+			}
+
+			// restart timer
+			flushTimer.Reset(flushInterval)
+		}
+	}
+
+	// the messages received before the stop are sent
+	dnsutils.DrainChannel(o.channel, func(dm dnsutils.DnsMessage) {
+		if subprocessors.ProcessMessage(&dm) != transformers.RETURN_DROP {
+			bufferDm = append(bufferDm, dm)
+		}
+	})
+	if len(bufferDm) > 0 {
+		o.FlushBuffer(&bufferDm)
+	}
+
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// closing the writer
+	if err := o.writer.Close(); err != nil {
+		o.LogError("closing writer: %s", err)
+	}
+
+	o.done <- true
+}
@@ -0,0 +1,127 @@
+package loggers
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/hamba/avro"
+)
+
+func Test_KafkaProducerBuildMessage(t *testing.T) {
+	testcases := []struct {
+		mode         string
+		partitionKey string
+		key          string
+		pattern      string
+	}{
+		{
+			mode:         dnsutils.MODE_TEXT,
+			partitionKey: "",
+			key:          "",
+			pattern:      " dns.collector ",
+		},
+		{
+			mode:         dnsutils.MODE_JSON,
+			partitionKey: dnsutils.PARTITION_KEY_QNAME,
+			key:          "dns.collector",
+			pattern:      "\"qname\":\"dns.collector\"",
+		},
+		{
+			mode:         dnsutils.MODE_FLATJSON,
+			partitionKey: dnsutils.PARTITION_KEY_QUERYIP,
+			key:          "1.2.3.4",
+			pattern:      "\"dns.qname\":\"dns.collector\"",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.mode, func(t *testing.T) {
+			// init logger
+			cfg := dnsutils.GetFakeConfig()
+			cfg.Loggers.KafkaProducer.Mode = tc.mode
+			cfg.Loggers.KafkaProducer.PartitionKey = tc.partitionKey
+
+			g := NewKafkaProducer(cfg, logger.New(false), "test")
+
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.NetworkInfo.QueryIp = "1.2.3.4"
+
+			msg, err := g.BuildMessage(&dm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(msg.Key) != tc.key {
+				t.Errorf("invalid partition key, want %s, got: %s", tc.key, msg.Key)
+			}
+
+			pattern := regexp.MustCompile(tc.pattern)
+			if !pattern.Match(msg.Value) {
+				t.Errorf("kafka error want %s, got: %s", tc.pattern, msg.Value)
+			}
+		})
+	}
+}
+
+func Test_KafkaProducerSasl(t *testing.T) {
+	for _, mechanism := range []string{dnsutils.SASL_MECHANISM_PLAIN, dnsutils.SASL_MECHANISM_SCRAM} {
+		cfg := dnsutils.GetFakeConfig()
+		cfg.Loggers.KafkaProducer.SaslSupport = true
+		cfg.Loggers.KafkaProducer.SaslMechanism = mechanism
+		cfg.Loggers.KafkaProducer.SaslUsername = "user"
+		cfg.Loggers.KafkaProducer.SaslPassword = "secret"
+
+		g := NewKafkaProducer(cfg, logger.New(false), "test")
+		transport, err := g.NewTransport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transport.SASL == nil || transport.SASL.Name() != mechanism {
+			t.Errorf("invalid sasl mechanism, want %s", mechanism)
+		}
+	}
+}
+
+func Test_KafkaProducerAvroSchemaRegistry(t *testing.T) {
+	// fake schema registry
+	var subject string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.URL.Path
+		w.Write([]byte(`{"id":42}`))
+	}))
+	defer registry.Close()
+
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.KafkaProducer.Mode = dnsutils.MODE_AVRO
+	cfg.Loggers.KafkaProducer.SchemaRegistryUrl = registry.URL
+
+	g := NewKafkaProducer(cfg, logger.New(false), "test")
+	if err := g.RegisterSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if subject != "/subjects/dnscollector-value/versions" {
+		t.Errorf("invalid subject: %s", subject)
+	}
+
+	dm := dnsutils.GetFakeDnsMessage()
+	msg, err := g.BuildMessage(&dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// magic byte, schema id and the avro record
+	if msg.Value[0] != 0 || binary.BigEndian.Uint32(msg.Value[1:5]) != 42 {
+		t.Errorf("invalid schema registry header: %v", msg.Value[:5])
+	}
+	record := dnsutils.DnsRecord{}
+	if err := avro.Unmarshal(dnsutils.AvroSchema, msg.Value[5:], &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Dns.Qname != "dns.collector" {
+		t.Errorf("invalid avro record: %+v", record)
+	}
+}
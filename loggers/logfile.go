@@ -22,12 +22,14 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
 
 	framestream "github.com/farsightsec/golang-framestream"
 )
 
 const (
-	compressSuffix = ".gz"
+	gzipSuffix = ".gz"
+	zstdSuffix = ".zst"
 )
 
 func IsValidMode(mode string) bool {
@@ -37,7 +39,17 @@ func IsValidMode(mode string) bool {
 		dnsutils.MODE_JSON,
 		dnsutils.MODE_FLATJSON,
 		dnsutils.MODE_PCAP,
-		dnsutils.MODE_DNSTAP:
+		dnsutils.MODE_DNSTAP,
+		dnsutils.MODE_MSGPACK,
+		dnsutils.MODE_CBOR:
+		return true
+	}
+	return false
+}
+
+func IsValidCompressMode(mode string) bool {
+	switch mode {
+	case dnsutils.COMPRESS_GZIP, dnsutils.COMPRESS_ZSTD:
 		return true
 	}
 	return false
@@ -45,7 +57,9 @@ func IsValidMode(mode string) bool {
 
 type LogFile struct {
 	done           chan bool
+	configChan     chan *dnsutils.Config
 	channel        chan dnsutils.DnsMessage
+	batchChannel   chan []dnsutils.DnsMessage
 	writerPlain    *bufio.Writer
 	writerPcap     *pcapgo.Writer
 	writerDnstap   *framestream.Encoder
@@ -65,11 +79,13 @@ type LogFile struct {
 func NewLogFile(config *dnsutils.Config, logger *logger.Logger, name string) *LogFile {
 	logger.Info("[%s] logger file - enabled", name)
 	l := &LogFile{
-		done:    make(chan bool),
-		channel: make(chan dnsutils.DnsMessage, 512),
-		config:  config,
-		logger:  logger,
-		name:    name,
+		done:         make(chan bool),
+		configChan:   make(chan *dnsutils.Config, 1),
+		channel:      make(chan dnsutils.DnsMessage, 512),
+		batchChannel: make(chan []dnsutils.DnsMessage, 16),
+		config:       config,
+		logger:       logger,
+		name:         name,
 	}
 
 	l.ReadConfig()
@@ -89,10 +105,17 @@ func (l *LogFile) Channel() chan dnsutils.DnsMessage {
 	return l.channel
 }
 
+func (l *LogFile) BatchChannel() chan []dnsutils.DnsMessage {
+	return l.batchChannel
+}
+
 func (l *LogFile) ReadConfig() {
 	if !IsValidMode(l.config.Loggers.LogFile.Mode) {
 		l.logger.Fatal("logger file - invalid mode: ", l.config.Loggers.LogFile.Mode)
 	}
+	if !IsValidCompressMode(l.config.Loggers.LogFile.CompressMode) {
+		l.logger.Fatal("logger file - invalid compress mode: ", l.config.Loggers.LogFile.CompressMode)
+	}
 	l.fileDir = filepath.Dir(l.config.Loggers.LogFile.FilePath)
 	l.fileName = filepath.Base(l.config.Loggers.LogFile.FilePath)
 	l.fileExt = filepath.Ext(l.fileName)
@@ -107,6 +130,11 @@ func (l *LogFile) ReadConfig() {
 	l.LogInfo("running in mode: %s", l.config.Loggers.LogFile.Mode)
 }
 
+func (l *LogFile) ReloadConfig(config *dnsutils.Config) {
+	l.LogInfo("reload configuration...")
+	dnsutils.SendConfig(l.configChan, config)
+}
+
 func (l *LogFile) LogInfo(msg string, v ...interface{}) {
 	l.logger.Info("["+l.name+"] logger file - "+msg, v...)
 }
@@ -118,8 +146,9 @@ func (l *LogFile) LogError(msg string, v ...interface{}) {
 func (l *LogFile) Stop() {
 	l.LogInfo("stopping...")
 
-	// close output channel
+	// close output channels
 	l.LogInfo("closing dns message channel")
+	close(l.batchChannel)
 	close(l.channel)
 
 	// closing file
@@ -135,58 +164,73 @@ func (l *LogFile) Stop() {
 }
 
 func (l *LogFile) Cleanup() error {
-	if l.config.Loggers.LogFile.MaxFiles == 0 {
+	if l.config.Loggers.LogFile.MaxFiles == 0 && l.config.Loggers.LogFile.MaxAge == 0 {
 		return nil
 	}
 
-	// remove old files ? keep only max files number
+	// remove old files ? keep only max files number and files younger than max age
 	entries, err := os.ReadDir(l.fileDir)
 	if err != nil {
 		return err
 	}
 
+	// rotated files, compressed or not, by timestamp
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(l.filePrefix) + `-(?P<ts>\d+)` + regexp.QuoteMeta(l.fileExt) +
+		`(` + regexp.QuoteMeta(gzipSuffix) + `|` + regexp.QuoteMeta(zstdSuffix) + `)?$`)
+	tsIndex := re.SubexpIndex("ts")
+
 	logFiles := []int{}
+	logNames := make(map[int][]string)
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
 
 		// extract timestamp from filename
-		re := regexp.MustCompile(`^` + l.filePrefix + `-(?P<ts>\d+)` + l.fileExt)
 		matches := re.FindStringSubmatch(entry.Name())
-
 		if len(matches) == 0 {
 			continue
 		}
 
 		// convert timestamp to int
-		tsIndex := re.SubexpIndex("ts")
 		i, err := strconv.Atoi(matches[tsIndex])
 		if err != nil {
 			continue
 		}
-		logFiles = append(logFiles, i)
+		if _, ok := logNames[i]; !ok {
+			logFiles = append(logFiles, i)
+		}
+		logNames[i] = append(logNames[i], entry.Name())
 	}
 	sort.Ints(logFiles)
 
+	// too old log files ? the timestamp is the rotation time
+	if l.config.Loggers.LogFile.MaxAge > 0 {
+		maxAge := time.Duration(l.config.Loggers.LogFile.MaxAge) * time.Second
+		for len(logFiles) > 0 && time.Since(time.Unix(0, int64(logFiles[0]))) > maxAge {
+			l.removeFiles(logNames[logFiles[0]])
+			logFiles = logFiles[1:]
+		}
+	}
+
 	// too much log files ?
-	diff_nb := len(logFiles) - l.config.Loggers.LogFile.MaxFiles
-	if diff_nb > 0 {
+	if l.config.Loggers.LogFile.MaxFiles > 0 {
+		diff_nb := len(logFiles) - l.config.Loggers.LogFile.MaxFiles
 		for i := 0; i < diff_nb; i++ {
-			filename := fmt.Sprintf("%s-%d%s", l.filePrefix, logFiles[i], l.fileExt)
-			f := filepath.Join(l.fileDir, filename)
-			if _, err := os.Stat(f); os.IsNotExist(err) {
-				f = filepath.Join(l.fileDir, filename+compressSuffix)
-			}
-
-			// ignore errors on deletion
-			os.Remove(f)
+			l.removeFiles(logNames[logFiles[i]])
 		}
 	}
 
 	return nil
 }
 
+func (l *LogFile) removeFiles(filenames []string) {
+	for _, filename := range filenames {
+		// ignore errors on deletion
+		os.Remove(filepath.Join(l.fileDir, filename))
+	}
+}
+
 func (l *LogFile) OpenFile() error {
 
 	fd, err := os.OpenFile(l.config.Loggers.LogFile.FilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
@@ -203,7 +247,7 @@ func (l *LogFile) OpenFile() error {
 	l.fileSize = fileinfo.Size()
 
 	switch l.config.Loggers.LogFile.Mode {
-	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON:
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON, dnsutils.MODE_MSGPACK, dnsutils.MODE_CBOR:
 		l.writerPlain = bufio.NewWriter(fd)
 
 	case dnsutils.MODE_PCAP:
@@ -231,6 +275,13 @@ func (o *LogFile) GetMaxSize() int64 {
 	return int64(1024*1024) * int64(o.config.Loggers.LogFile.MaxSize)
 }
 
+func (l *LogFile) CompressSuffix() string {
+	if l.config.Loggers.LogFile.CompressMode == dnsutils.COMPRESS_ZSTD {
+		return zstdSuffix
+	}
+	return gzipSuffix
+}
+
 func (l *LogFile) CompressFile() {
 	entries, err := os.ReadDir(l.fileDir)
 	if err != nil {
@@ -247,7 +298,7 @@ func (l *LogFile) CompressFile() {
 		matched, _ := regexp.MatchString(`^`+l.filePrefix+`-\d+`+l.fileExt+`$`, entry.Name())
 		if matched {
 			src := filepath.Join(l.fileDir, entry.Name())
-			dst := filepath.Join(l.fileDir, entry.Name()+compressSuffix)
+			dst := filepath.Join(l.fileDir, entry.Name()+l.CompressSuffix())
 
 			fl, err := os.Open(src)
 			if err != nil {
@@ -262,27 +313,39 @@ func (l *LogFile) CompressFile() {
 				continue
 			}
 
-			gzf, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+			zf, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
 			if err != nil {
 				l.LogError("compress - failed to open compressed file: ", err)
 				continue
 			}
-			defer gzf.Close()
+			defer zf.Close()
 
-			gz := gzip.NewWriter(gzf)
+			var zw io.WriteCloser
+			switch l.config.Loggers.LogFile.CompressMode {
+			case dnsutils.COMPRESS_ZSTD:
+				zw, err = zstd.NewWriter(zf)
+				if err != nil {
+					l.LogError("compress - failed to create zstd writer: ", err)
+					os.Remove(dst)
+					continue
+				}
+			default:
+				zw = gzip.NewWriter(zf)
+			}
 
-			if _, err := io.Copy(gz, fl); err != nil {
+			if _, err := io.Copy(zw, fl); err != nil {
 				l.LogError("compress - failed to compress file: ", err)
+				zw.Close()
 				os.Remove(dst)
 				continue
 			}
-			if err := gz.Close(); err != nil {
-				l.LogError("compress - failed to close gz writer: ", err)
+			if err := zw.Close(); err != nil {
+				l.LogError("compress - failed to close compress writer: ", err)
 				os.Remove(dst)
 				continue
 			}
-			if err := gzf.Close(); err != nil {
-				l.LogError("compress - failed to close gz file: ", err)
+			if err := zf.Close(); err != nil {
+				l.LogError("compress - failed to close compressed file: ", err)
 				os.Remove(dst)
 				continue
 			}
@@ -335,7 +398,7 @@ func (l *LogFile) CompressPostRotateCommand(filename string) {
 
 func (l *LogFile) FlushWriters() {
 	switch l.config.Loggers.LogFile.Mode {
-	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON:
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON, dnsutils.MODE_MSGPACK, dnsutils.MODE_CBOR:
 		l.writerPlain.Flush()
 	case dnsutils.MODE_DNSTAP:
 		l.writerDnstap.Flush()
@@ -449,6 +512,75 @@ func (l *LogFile) WriteToDnstap(data []byte) {
 	l.fileSize += int64(n)
 }
 
+// WriteMessage writes the dns message to the file according to the mode
+func (l *LogFile) WriteMessage(dm *dnsutils.DnsMessage, buffer *bytes.Buffer) {
+	switch l.config.Loggers.LogFile.Mode {
+
+	// with basic text mode
+	case dnsutils.MODE_TEXT:
+		l.WriteToPlain(dm.Bytes(l.textFormat,
+			l.config.Global.TextFormatDelimiter,
+			l.config.Global.TextFormatBoundary))
+
+		var delimiter bytes.Buffer
+		delimiter.WriteString("\n")
+		l.WriteToPlain(delimiter.Bytes())
+
+	// with json mode
+	case dnsutils.MODE_FLATJSON:
+		flat, err := dm.Flatten()
+		if err != nil {
+			l.LogError("flattening DNS message failed: %e", err)
+		}
+		json.NewEncoder(buffer).Encode(flat)
+		l.WriteToPlain(buffer.Bytes())
+		buffer.Reset()
+
+	// with json mode
+	case dnsutils.MODE_JSON:
+		json.NewEncoder(buffer).Encode(dm)
+		l.WriteToPlain(buffer.Bytes())
+		buffer.Reset()
+
+	// with binary modes, the messages are written one after the other without delimiter
+	case dnsutils.MODE_MSGPACK:
+		data, err := dm.ToMsgpack()
+		if err != nil {
+			l.LogError("failed to encode to msgpack: %s", err)
+			return
+		}
+		l.WriteToPlain(data)
+
+	case dnsutils.MODE_CBOR:
+		data, err := dm.ToCbor()
+		if err != nil {
+			l.LogError("failed to encode to cbor: %s", err)
+			return
+		}
+		l.WriteToPlain(data)
+
+	// with dnstap mode
+	case dnsutils.MODE_DNSTAP:
+		data, err := dm.ToDnstap()
+		if err != nil {
+			l.LogError("failed to encode to DNStap protobuf: %s", err)
+			return
+		}
+		l.WriteToDnstap(data)
+
+	// with pcap mode
+	case dnsutils.MODE_PCAP:
+		pkt, err := dm.ToPacketLayer()
+		if err != nil {
+			l.LogError("failed to encode to packet layer: %s", err)
+			return
+		}
+
+		// write the packet
+		l.WriteToPcap(*dm, pkt)
+	}
+}
+
 func (l *LogFile) Run() {
 	l.LogInfo("running in background...")
 
@@ -462,12 +594,23 @@ func (l *LogFile) Run() {
 	flushTimer := time.NewTimer(flushInterval)
 	l.commpressTimer = time.NewTimer(time.Duration(l.config.Loggers.LogFile.CompressInterval) * time.Second)
 
+	// time based rotation, disabled with zero
+	var rotateTimer *time.Timer
+	var rotateChan <-chan time.Time
+	rotateInterval := time.Duration(l.config.Loggers.LogFile.RotationInterval) * time.Second
+	if rotateInterval > 0 {
+		rotateTimer = time.NewTimer(rotateInterval)
+		rotateChan = rotateTimer.C
+	}
+
 	buffer := new(bytes.Buffer)
-	var data []byte
-	var err error
 LOOP:
 	for {
 		select {
+		case cfg := <-l.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
 		case dm, opened := <-l.channel:
 			if !opened {
 				l.LogInfo("channel closed")
@@ -480,53 +623,20 @@ LOOP:
 			}
 
 			// write to file
-			switch l.config.Loggers.LogFile.Mode {
-
-			// with basic text mode
-			case dnsutils.MODE_TEXT:
-				l.WriteToPlain(dm.Bytes(l.textFormat,
-					l.config.Global.TextFormatDelimiter,
-					l.config.Global.TextFormatBoundary))
+			l.WriteMessage(&dm, buffer)
 
-				var delimiter bytes.Buffer
-				delimiter.WriteString("\n")
-				l.WriteToPlain(delimiter.Bytes())
-
-			// with json mode
-			case dnsutils.MODE_FLATJSON:
-				flat, err := dm.Flatten()
-				if err != nil {
-					l.LogError("flattening DNS message failed: %e", err)
-				}
-				json.NewEncoder(buffer).Encode(flat)
-				l.WriteToPlain(buffer.Bytes())
-				buffer.Reset()
-
-			// with json mode
-			case dnsutils.MODE_JSON:
-				json.NewEncoder(buffer).Encode(dm)
-				l.WriteToPlain(buffer.Bytes())
-				buffer.Reset()
-
-			// with dnstap mode
-			case dnsutils.MODE_DNSTAP:
-				data, err = dm.ToDnstap()
-				if err != nil {
-					l.LogError("failed to encode to DNStap protobuf: %s", err)
-					continue
-				}
-				l.WriteToDnstap(data)
+		case batch, opened := <-l.batchChannel:
+			if !opened {
+				l.batchChannel = nil
+				continue
+			}
 
-			// with pcap mode
-			case dnsutils.MODE_PCAP:
-				pkt, err := dm.ToPacketLayer()
-				if err != nil {
-					l.LogError("failed to encode to packet layer: %s", err)
+			for i := range batch {
+				// apply tranforms
+				if subprocessors.ProcessMessage(&batch[i]) == transformers.RETURN_DROP {
 					continue
 				}
-
-				// write the packet
-				l.WriteToPcap(dm, pkt)
+				l.WriteMessage(&batch[i], buffer)
 			}
 
 		case <-flushTimer.C:
@@ -542,12 +652,26 @@ LOOP:
 				l.CompressFile()
 			}
 
+		case <-rotateChan:
+			// rotate only if something has been written, the cleanup is done anyway for the max age
+			if l.fileSize > 0 {
+				if err := l.RotateFile(); err != nil {
+					l.LogError("failed to rotate file: %s", err)
+				}
+			} else if err := l.Cleanup(); err != nil {
+				l.LogError("unable to cleanup log files: %s", err)
+			}
+			rotateTimer.Reset(rotateInterval)
+
 		}
 	}
 
 	// stop timer
 	flushTimer.Stop()
 	l.commpressTimer.Stop()
+	if rotateTimer != nil {
+		rotateTimer.Stop()
+	}
 
 	// flush writer
 	l.FlushWriters()
@@ -1,18 +1,24 @@
 package loggers
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack"
 )
 
 func Test_LogFileText(t *testing.T) {
@@ -79,6 +85,142 @@ func Test_LogFileText(t *testing.T) {
 	}
 }
 
+func Test_LogFileBatch(t *testing.T) {
+	// create a temp file
+	f, err := os.CreateTemp("", "temp_logfile_batch")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(f.Name()) // clean up
+
+	// config
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.LogFile.FilePath = f.Name()
+	config.Loggers.LogFile.Mode = dnsutils.MODE_TEXT
+	config.Loggers.LogFile.FlushInterval = 0
+
+	// init generator in testing mode
+	g := NewLogFile(config, logger.New(false), "test")
+	if !dnsutils.IsBatchWorker(g, 2) {
+		t.Fatalf("logfile should support batches")
+	}
+
+	// start the logger
+	go g.Run()
+
+	// send a batch of fake dns messages to logger
+	batch := []dnsutils.DnsMessage{}
+	for _, qname := range []string{"www.collector", "dns.collector"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		batch = append(batch, dm)
+	}
+	g.BatchChannel() <- batch
+
+	time.Sleep(time.Second)
+	g.Stop()
+
+	// read temp file and check content
+	data := make([]byte, 1024)
+	count, err := f.Read(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pattern := regexp.MustCompile("www.collector A.*\n.*dns.collector A")
+	if !pattern.MatchString(string(data[:count])) {
+		t.Errorf("logfile batch error, got: %s", string(data[:count]))
+	}
+}
+
+func Test_LogFileBinary(t *testing.T) {
+	testcases := []struct {
+		mode   string
+		decode func(data []byte) ([]dnsutils.DnsMessage, error)
+	}{
+		{
+			mode: dnsutils.MODE_MSGPACK,
+			decode: func(data []byte) ([]dnsutils.DnsMessage, error) {
+				dms := []dnsutils.DnsMessage{}
+				dec := msgpack.NewDecoder(bytes.NewReader(data))
+				for {
+					dm := dnsutils.DnsMessage{}
+					if err := dec.Decode(&dm); err != nil {
+						if err == io.EOF {
+							return dms, nil
+						}
+						return dms, err
+					}
+					dms = append(dms, dm)
+				}
+			},
+		},
+		{
+			mode: dnsutils.MODE_CBOR,
+			decode: func(data []byte) ([]dnsutils.DnsMessage, error) {
+				dms := []dnsutils.DnsMessage{}
+				dec := cbor.NewDecoder(bytes.NewReader(data))
+				for {
+					dm := dnsutils.DnsMessage{}
+					if err := dec.Decode(&dm); err != nil {
+						if err == io.EOF {
+							return dms, nil
+						}
+						return dms, err
+					}
+					dms = append(dms, dm)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.mode, func(t *testing.T) {
+			// create a temp file
+			f, err := os.CreateTemp("", "temp_logfile_"+tc.mode)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer os.Remove(f.Name()) // clean up
+
+			// config
+			config := dnsutils.GetFakeConfig()
+			config.Loggers.LogFile.FilePath = f.Name()
+			config.Loggers.LogFile.Mode = tc.mode
+			config.Loggers.LogFile.FlushInterval = 0
+
+			// init generator in testing mode
+			g := NewLogFile(config, logger.New(false), "test")
+
+			// start the logger
+			go g.Run()
+
+			// send two fake dns messages to logger
+			for _, qname := range []string{"www.collector", "dns.collector"} {
+				dm := dnsutils.GetFakeDnsMessage()
+				dm.DNS.Qname = qname
+				g.channel <- dm
+			}
+
+			time.Sleep(time.Second)
+			g.Stop()
+
+			// read temp file and decode the messages one after the other
+			data, err := os.ReadFile(f.Name())
+			if err != nil {
+				log.Fatal(err)
+			}
+			dms, err := tc.decode(data)
+			if err != nil {
+				t.Fatalf("unable to decode %s: %s", tc.mode, err)
+			}
+			if len(dms) != 2 || dms[0].DNS.Qname != "www.collector" || dms[1].DNS.Qname != "dns.collector" {
+				t.Errorf("invalid messages decoded: %v", dms)
+			}
+		})
+	}
+}
+
 func Test_LogFileWrite_PcapMode(t *testing.T) {
 	// create a temp file
 	f, err := os.CreateTemp("", "temp_pcapfile")
@@ -131,3 +273,76 @@ func Test_LogFileWrite_PcapMode(t *testing.T) {
 		t.Errorf("no data in pcap file")
 	}
 }
+
+func Test_LogFileCompressZstd(t *testing.T) {
+	dir := t.TempDir()
+
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.LogFile.FilePath = filepath.Join(dir, "dnscollector.log")
+	config.Loggers.LogFile.CompressMode = dnsutils.COMPRESS_ZSTD
+
+	g := NewLogFile(config, logger.New(false), "test")
+	g.commpressTimer = time.NewTimer(time.Minute)
+	defer g.commpressTimer.Stop()
+
+	// write a message and rotate the file
+	g.WriteToPlain([]byte("dns.collector\n"))
+	if err := g.RotateFile(); err != nil {
+		t.Fatal(err)
+	}
+	g.CompressFile()
+
+	// only the new log file and the compressed one are expected
+	files, _ := filepath.Glob(filepath.Join(dir, "dnscollector-*.log"+zstdSuffix))
+	if len(files) != 1 {
+		t.Fatalf("one zstd file expected, got %v", files)
+	}
+
+	fd, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	zr, err := zstd.NewReader(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "dns.collector\n" {
+		t.Errorf("invalid decompressed content: %q", data)
+	}
+}
+
+func Test_LogFileCleanupMaxAge(t *testing.T) {
+	dir := t.TempDir()
+
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.LogFile.FilePath = filepath.Join(dir, "dnscollector.log")
+	config.Loggers.LogFile.MaxFiles = 0
+	config.Loggers.LogFile.MaxAge = 3600
+
+	g := NewLogFile(config, logger.New(false), "test")
+
+	// rotated files, one is older than the max age
+	old := fmt.Sprintf("dnscollector-%d.log.gz", time.Now().Add(-2*time.Hour).UnixNano())
+	recent := fmt.Sprintf("dnscollector-%d.log.zst", time.Now().UnixNano())
+	for _, name := range []string{old, recent} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := g.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, old)); !os.IsNotExist(err) {
+		t.Errorf("old file should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, recent)); err != nil {
+		t.Errorf("recent file should be kept: %s", err)
+	}
+}
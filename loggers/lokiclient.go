@@ -10,6 +10,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,8 +33,18 @@ import (
 	"github.com/grafana/loki/pkg/logproto"
 )
 
+// LokiLabels are the fields of the dns message which can be used as stream labels
+var LokiLabels = map[string]func(dm *dnsutils.DnsMessage) string{
+	"identity":  func(dm *dnsutils.DnsMessage) string { return dm.DnsTap.Identity },
+	"operation": func(dm *dnsutils.DnsMessage) string { return dm.DnsTap.Operation },
+	"rcode":     func(dm *dnsutils.DnsMessage) string { return dm.DNS.Rcode },
+	"qtype":     func(dm *dnsutils.DnsMessage) string { return dm.DNS.Qtype },
+	"family":    func(dm *dnsutils.DnsMessage) string { return dm.NetworkInfo.Family },
+	"protocol":  func(dm *dnsutils.DnsMessage) string { return dm.NetworkInfo.Protocol },
+}
+
 type LokiStream struct {
-	name        string
+	labels      string
 	config      *dnsutils.Config
 	logger      *logger.Logger
 	stream      *logproto.Stream
@@ -44,7 +55,7 @@ type LokiStream struct {
 func (o *LokiStream) Init() {
 	// prepare stream with label name
 	o.stream = &logproto.Stream{}
-	o.stream.Labels = "{job=\"" + o.config.Loggers.LokiClient.JobName + "\", identity=\"" + o.name + "\"}"
+	o.stream.Labels = o.labels
 
 	// creates push request
 	o.pushrequest = &logproto.PushRequest{
@@ -71,10 +82,10 @@ func (o *LokiStream) Encode2Proto() ([]byte, error) {
 
 type LokiClient struct {
 	done       chan bool
+	configChan chan *dnsutils.Config
 	channel    chan dnsutils.DnsMessage
 	config     *dnsutils.Config
 	logger     *logger.Logger
-	exit       chan bool
 	httpclient *http.Client
 	textFormat []string
 	streams    map[string]*LokiStream
@@ -85,13 +96,13 @@ func NewLokiClient(config *dnsutils.Config, logger *logger.Logger, name string)
 	logger.Info("[%s] logger loki - enabled", name)
 
 	s := &LokiClient{
-		done:    make(chan bool),
-		exit:    make(chan bool),
-		channel: make(chan dnsutils.DnsMessage, 512),
-		logger:  logger,
-		config:  config,
-		streams: make(map[string]*LokiStream),
-		name:    name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		config:     config,
+		streams:    make(map[string]*LokiStream),
+		name:       name,
 	}
 
 	s.ReadConfig()
@@ -108,6 +119,12 @@ func (o *LokiClient) ReadConfig() {
 		o.logger.Fatal("logger loki - invalid tls min version")
 	}
 
+	for _, label := range o.config.Loggers.LokiClient.Labels {
+		if _, ok := LokiLabels[label]; !ok {
+			o.logger.Fatal("logger loki - invalid label: ", label)
+		}
+	}
+
 	if len(o.config.Loggers.LokiClient.TextFormat) > 0 {
 		o.textFormat = strings.Fields(o.config.Loggers.LokiClient.TextFormat)
 	} else {
@@ -142,6 +159,11 @@ func (o *LokiClient) ReadConfig() {
 	o.httpclient = &http.Client{Transport: tr}
 }
 
+func (o *LokiClient) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
 func (o *LokiClient) LogInfo(msg string, v ...interface{}) {
 	o.logger.Info("["+o.name+"] logger loki - "+msg, v...)
 }
@@ -157,18 +179,44 @@ func (o *LokiClient) Channel() chan dnsutils.DnsMessage {
 func (o *LokiClient) Stop() {
 	o.LogInfo("stopping...")
 
-	// close output channel
+	// close output channel, the messages in the channel and the buffered
+	// entries are sent before to terminate the run
 	o.LogInfo("closing channel")
 	close(o.channel)
 
-	// exit to close properly
-	o.exit <- true
-
 	// read done channel and block until run is terminated
 	<-o.done
 	close(o.done)
 }
 
+// GetLabels returns the labels of the stream of the dns message
+func (o *LokiClient) GetLabels(dm *dnsutils.DnsMessage) string {
+	labels := []string{"job=" + strconv.Quote(o.config.Loggers.LokiClient.JobName)}
+	for _, label := range o.config.Loggers.LokiClient.Labels {
+		labels = append(labels, label+"="+strconv.Quote(LokiLabels[label](dm)))
+	}
+	return "{" + strings.Join(labels, ", ") + "}"
+}
+
+// FlushStream sends the buffered entries of the stream
+func (o *LokiClient) FlushStream(s *LokiStream) {
+	if len(s.stream.Entries) == 0 {
+		return
+	}
+
+	// encode log entries
+	buf, err := s.Encode2Proto()
+	if err != nil {
+		o.LogError("error encoding log entries - %v", err)
+	} else {
+		// send all entries
+		o.SendEntries(buf)
+	}
+
+	// reset entries and push request
+	s.ResetEntries()
+}
+
 func (o *LokiClient) Run() {
 	o.LogInfo("running in background...")
 
@@ -187,15 +235,25 @@ func (o *LokiClient) Run() {
 LOOP:
 	for {
 		select {
-		case dm := <-o.channel:
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
 			// apply tranforms
 			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
-			if _, ok := o.streams[dm.DnsTap.Identity]; !ok {
-				o.streams[dm.DnsTap.Identity] = &LokiStream{config: o.config, logger: o.logger, name: dm.DnsTap.Identity}
-				o.streams[dm.DnsTap.Identity].Init()
+			labels := o.GetLabels(&dm)
+			if _, ok := o.streams[labels]; !ok {
+				o.streams[labels] = &LokiStream{config: o.config, logger: o.logger, labels: labels}
+				o.streams[labels].Init()
 			}
 
 			// prepare entry
@@ -220,62 +278,33 @@ LOOP:
 				entry.Line = buffer.String()
 				buffer.Reset()
 			}
-			o.streams[dm.DnsTap.Identity].sizeentries += len(entry.Line)
+			o.streams[labels].sizeentries += len(entry.Line)
 
 			// append entry to the stream
-			o.streams[dm.DnsTap.Identity].stream.Entries = append(o.streams[dm.DnsTap.Identity].stream.Entries, entry)
+			o.streams[labels].stream.Entries = append(o.streams[labels].stream.Entries, entry)
 
 			// flush ?
-			if o.streams[dm.DnsTap.Identity].sizeentries >= o.config.Loggers.LokiClient.BatchSize {
-				// encode log entries
-				buf, err := o.streams[dm.DnsTap.Identity].Encode2Proto()
-				if err != nil {
-					o.LogError("error encoding log entries - %v", err)
-					// reset push request and entries
-					o.streams[dm.DnsTap.Identity].ResetEntries()
-					return
-				}
-
-				// send all entries
-				o.SendEntries(buf)
-
-				// reset entries and push request
-				o.streams[dm.DnsTap.Identity].ResetEntries()
+			if o.streams[labels].sizeentries >= o.config.Loggers.LokiClient.BatchSize {
+				o.FlushStream(o.streams[labels])
 			}
 
 		case <-tflush.C:
+			// timeout
 			for _, s := range o.streams {
-				if len(s.stream.Entries) > 0 {
-					// timeout
-					// encode log entries
-					buf, err := s.Encode2Proto()
-					if err != nil {
-						o.LogError("error encoding log entries - %v", err)
-						// reset push request and entries
-						s.ResetEntries()
-						// restart timer
-						tflush.Reset(tflush_interval)
-						return
-					}
-
-					// send all entries
-					o.SendEntries(buf)
-
-					// reset entries and push request
-					s.ResetEntries()
-				}
+				o.FlushStream(s)
 			}
 
 			// restart timer
 			tflush.Reset(tflush_interval)
-		case <-o.exit:
-			o.logger.Info("closing loop...")
-			break LOOP
 		}
 
 	}
+	tflush.Stop()
 
-	// if buffer is not empty, we accept to lose log entries
+	// the buffered entries are sent before to stop
+	for _, s := range o.streams {
+		o.FlushStream(s)
+	}
 	o.LogInfo("run terminated")
 
 	// cleanup transformers
@@ -87,3 +87,17 @@ func Test_LokiClientRun(t *testing.T) {
 		})
 	}
 }
+
+func Test_LokiClientLabels(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.LokiClient.Labels = []string{"identity", "operation", "rcode"}
+	g := NewLokiClient(cfg, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DnsTap.Identity = "ns\"1"
+
+	want := `{job="dnscollector", identity="ns\"1", operation="CLIENT_QUERY", rcode="NOERROR"}`
+	if labels := g.GetLabels(&dm); labels != want {
+		t.Errorf("invalid labels, want %s, got: %s", want, labels)
+	}
+}
@@ -0,0 +1,356 @@
+package loggers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+// ObjectKey is the data of the key template, the date is the start of the batch in UTC
+type ObjectKey struct {
+	Year      string
+	Month     string
+	Day       string
+	Hour      string
+	Identity  string
+	Name      string
+	Timestamp int64
+	Ext       string
+}
+
+// ArchiveObject is a batch encoded and waiting for the upload
+type ArchiveObject struct {
+	Key         string
+	ContentType string
+	Data        []byte
+}
+
+// archiveBatch holds the messages of an identity until the flush
+type archiveBatch struct {
+	identity string
+	start    time.Time
+	size     int
+	lines    bytes.Buffer
+	rows     []map[string]interface{}
+}
+
+// ObjectStorage archives the messages in S3 compatible buckets, the messages are
+// batched per identity and uploaded in ndjson or parquet objects on a size and time schedule
+type ObjectStorage struct {
+	done        chan bool
+	configChan  chan *dnsutils.Config
+	channel     chan dnsutils.DnsMessage
+	uploads     chan ArchiveObject
+	uploadsDone chan bool
+	config      *dnsutils.Config
+	logger      *logger.Logger
+	name        string
+	keyTemplate *template.Template
+	batches     map[string]*archiveBatch
+	client      *s3.S3
+}
+
+func NewObjectStorage(config *dnsutils.Config, logger *logger.Logger, name string) *ObjectStorage {
+	logger.Info("[%s] logger objectstorage - enabled", name)
+	o := &ObjectStorage{
+		done:        make(chan bool),
+		configChan:  make(chan *dnsutils.Config, 1),
+		channel:     make(chan dnsutils.DnsMessage, 512),
+		uploads:     make(chan ArchiveObject, 16),
+		uploadsDone: make(chan bool),
+		config:      config,
+		logger:      logger,
+		name:        name,
+		batches:     make(map[string]*archiveBatch),
+	}
+	o.ReadConfig()
+	return o
+}
+
+func (c *ObjectStorage) GetName() string { return c.name }
+
+func (c *ObjectStorage) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *ObjectStorage) ReadConfig() {
+	cfg := o.config.Loggers.ObjectStorage
+	if len(cfg.Bucket) == 0 {
+		o.logger.Fatal("logger objectstorage - bucket is required")
+	}
+
+	switch cfg.Mode {
+	case dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON, dnsutils.MODE_PARQUET:
+	default:
+		o.logger.Fatal("logger objectstorage - invalid mode: ", cfg.Mode)
+	}
+
+	tmpl, err := template.New("key").Parse(cfg.KeyTemplate)
+	if err != nil {
+		o.logger.Fatal("logger objectstorage - invalid key template: ", err)
+	}
+	o.keyTemplate = tmpl
+
+	// the credentials are taken from the environment if not provided
+	awsConfig := &aws.Config{
+		Region:           aws.String(cfg.Region),
+		S3ForcePathStyle: aws.Bool(cfg.PathStyle),
+		HTTPClient:       &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+	if len(cfg.Endpoint) > 0 {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+	if len(cfg.AccessKey) > 0 {
+		awsConfig.Credentials = credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, "")
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		o.logger.Fatal("logger objectstorage - session error: ", err)
+	}
+	o.client = s3.New(sess)
+}
+
+func (o *ObjectStorage) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (c *ObjectStorage) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] logger objectstorage - "+msg, v...)
+}
+
+func (c *ObjectStorage) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] logger objectstorage - "+msg, v...)
+}
+
+func (o *ObjectStorage) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *ObjectStorage) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// Add appends the message to the batch of its identity, the batch is flushed when
+// the max size is reached
+func (o *ObjectStorage) Add(dm *dnsutils.DnsMessage) error {
+	identity := strings.ReplaceAll(dm.DnsTap.Identity, "/", "_")
+	batch, ok := o.batches[identity]
+	if !ok {
+		batch = &archiveBatch{identity: identity, start: time.Now()}
+		o.batches[identity] = batch
+	}
+
+	switch o.config.Loggers.ObjectStorage.Mode {
+	case dnsutils.MODE_JSON:
+		data, err := json.Marshal(dm)
+		if err != nil {
+			return err
+		}
+		batch.lines.Write(data)
+		batch.lines.WriteByte('\n')
+		batch.size += len(data) + 1
+
+	case dnsutils.MODE_FLATJSON:
+		flat, err := dm.Flatten()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(flat)
+		if err != nil {
+			return err
+		}
+		batch.lines.Write(data)
+		batch.lines.WriteByte('\n')
+		batch.size += len(data) + 1
+
+	case dnsutils.MODE_PARQUET:
+		flat, err := dm.Flatten()
+		if err != nil {
+			return err
+		}
+		batch.rows = append(batch.rows, flat)
+		for k, v := range flat {
+			batch.size += len(k)
+			if s, ok := v.(string); ok {
+				batch.size += len(s)
+			} else {
+				batch.size += 8
+			}
+		}
+	}
+
+	if batch.size >= o.config.Loggers.ObjectStorage.MaxSize*1024*1024 {
+		o.Flush(batch)
+	}
+	return nil
+}
+
+// Encode returns the object of the batch
+func (o *ObjectStorage) Encode(batch *archiveBatch) (ArchiveObject, error) {
+	cfg := o.config.Loggers.ObjectStorage
+	obj := ArchiveObject{}
+
+	var data bytes.Buffer
+	var ext string
+	if cfg.Mode == dnsutils.MODE_PARQUET {
+		ext, obj.ContentType = ".parquet", "application/vnd.apache.parquet"
+		if err := WriteParquet(&data, batch.rows, cfg.Compress); err != nil {
+			return obj, err
+		}
+	} else {
+		ext, obj.ContentType = ".json", "application/x-ndjson"
+		if cfg.Compress {
+			ext, obj.ContentType = ".json.gz", "application/gzip"
+			gz := gzip.NewWriter(&data)
+			if _, err := gz.Write(batch.lines.Bytes()); err != nil {
+				return obj, err
+			}
+			if err := gz.Close(); err != nil {
+				return obj, err
+			}
+		} else {
+			data.Write(batch.lines.Bytes())
+		}
+	}
+	obj.Data = data.Bytes()
+
+	start := batch.start.UTC()
+	var key strings.Builder
+	err := o.keyTemplate.Execute(&key, ObjectKey{
+		Year:      strconv.Itoa(start.Year()),
+		Month:     start.Format("01"),
+		Day:       start.Format("02"),
+		Hour:      start.Format("15"),
+		Identity:  batch.identity,
+		Name:      o.name,
+		Timestamp: start.UnixNano(),
+		Ext:       ext,
+	})
+	obj.Key = key.String()
+	return obj, err
+}
+
+// Flush encodes the batch and queues it for the upload
+func (o *ObjectStorage) Flush(batch *archiveBatch) {
+	delete(o.batches, batch.identity)
+
+	obj, err := o.Encode(batch)
+	if err != nil {
+		o.LogError("encoding error: %v", err)
+		return
+	}
+	o.uploads <- obj
+}
+
+// PutObject uploads the object in the bucket
+func (o *ObjectStorage) PutObject(obj ArchiveObject) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(o.config.Loggers.ObjectStorage.Timeout)*time.Second)
+	defer cancel()
+	_, err := o.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(o.config.Loggers.ObjectStorage.Bucket),
+		Key:         aws.String(obj.Key),
+		Body:        bytes.NewReader(obj.Data),
+		ContentType: aws.String(obj.ContentType),
+	})
+	return err
+}
+
+// Uploader sends the objects in background, the uploads are retried with a delay
+func (o *ObjectStorage) Uploader() {
+	for obj := range o.uploads {
+		var err error
+		for attempt := 0; attempt <= o.config.Loggers.ObjectStorage.MaxRetries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+			if err = o.PutObject(obj); err == nil {
+				break
+			}
+			o.LogError("upload of %s failed: %v", obj.Key, err)
+		}
+		if err == nil {
+			o.LogInfo("object %s uploaded (%d bytes)", obj.Key, len(obj.Data))
+		}
+	}
+	o.uploadsDone <- true
+}
+
+func (o *ObjectStorage) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	go o.Uploader()
+
+	flushInterval := time.Duration(o.config.Loggers.ObjectStorage.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			if err := o.Add(&dm); err != nil {
+				o.LogError("encoding DNS message failed: %v", err)
+			}
+
+		case <-flushTimer.C:
+			for _, batch := range o.batches {
+				o.Flush(batch)
+			}
+			flushTimer.Reset(flushInterval)
+		}
+	}
+
+	// the batches in progress are uploaded before to stop
+	flushTimer.Stop()
+	for _, batch := range o.batches {
+		o.Flush(batch)
+	}
+	close(o.uploads)
+	<-o.uploadsDone
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
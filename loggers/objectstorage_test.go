@@ -0,0 +1,72 @@
+package loggers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_ObjectStorageUpload(t *testing.T) {
+	// fake s3 endpoint
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		objects[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.ObjectStorage.Endpoint = server.URL
+	cfg.Loggers.ObjectStorage.PathStyle = true
+	cfg.Loggers.ObjectStorage.Bucket = "archives"
+	cfg.Loggers.ObjectStorage.AccessKey = "key"
+	cfg.Loggers.ObjectStorage.SecretKey = "secret"
+	cfg.Loggers.ObjectStorage.KeyTemplate = "{{.Identity}}/{{.Year}}{{.Ext}}"
+
+	g := NewObjectStorage(cfg, logger.New(false), "test")
+	go g.Run()
+
+	// one object per identity, uploaded on stop
+	dm := dnsutils.GetFakeDnsMessage()
+	g.Channel() <- dm
+	g.Channel() <- dm
+	dm.DnsTap.Identity = "other"
+	g.Channel() <- dm
+	g.Stop()
+
+	if len(objects) != 2 {
+		t.Fatalf("two objects expected, got %d", len(objects))
+	}
+	for path, body := range objects {
+		if !strings.HasPrefix(path, "/archives/") || !strings.HasSuffix(path, ".json.gz") {
+			t.Errorf("unexpected object: %s", path)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines, _ := io.ReadAll(gz)
+		want := 1
+		if strings.HasPrefix(path, "/archives/collector/") {
+			want = 2
+		}
+		if n := bytes.Count(lines, []byte("\n")); n != want {
+			t.Errorf("%s: want %d messages, got %d", path, want, n)
+		}
+	}
+}
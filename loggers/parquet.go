@@ -0,0 +1,472 @@
+package loggers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// parquet types, encodings and codecs used by the writer
+const (
+	ParquetBoolean   = 0
+	ParquetInt32     = 1
+	ParquetInt64     = 2
+	ParquetDouble    = 5
+	ParquetByteArray = 6
+
+	// converted types
+	ParquetNone            = -1
+	ParquetUtf8            = 0
+	ParquetTimestampMicros = 10
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRle             = 3
+
+	parquetUncompressed = 0
+	parquetGzip         = 2
+
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+)
+
+// thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the parquet metadata with the thrift compact protocol
+type thriftWriter struct {
+	buf    bytes.Buffer
+	fields []int16
+	last   int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.zigzag(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) begin() {
+	t.fields = append(t.fields, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.last = t.fields[len(t.fields)-1]
+	t.fields = t.fields[:len(t.fields)-1]
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) binary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+func (t *thriftWriter) str(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.binary(v)
+}
+
+func (t *thriftWriter) list(id int16, kind byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// ParquetColumn is a column of a parquet file, the values are nil when missing and must match
+// the physical type: bool, int32, int64, float64 or string
+type ParquetColumn struct {
+	Name       string
+	Type       int32
+	Converted  int32
+	Optional   bool
+	Dictionary bool
+	Values     []interface{}
+}
+
+// hybrid encodes the values with the rle/bit-packed hybrid encoding, bit-packed by groups of 8 values
+func hybrid(values []int, width int) []byte {
+	groups := (len(values) + 7) / 8
+	out := binary.AppendUvarint(nil, uint64(groups<<1|1))
+	packed := make([]byte, groups*width)
+	for i, v := range values {
+		for b := 0; b < width; b++ {
+			if v&(1<<b) != 0 {
+				pos := i*width + b
+				packed[pos/8] |= 1 << (pos % 8)
+			}
+		}
+	}
+	return append(out, packed...)
+}
+
+// plain returns the values with the plain encoding, the missing values are skipped
+func (c *ParquetColumn) plain(values []interface{}) []byte {
+	var out bytes.Buffer
+	var booleans []int
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		switch c.Type {
+		case ParquetBoolean:
+			b := 0
+			if v.(bool) {
+				b = 1
+			}
+			booleans = append(booleans, b)
+		case ParquetInt32:
+			out.Write(binary.LittleEndian.AppendUint32(nil, uint32(v.(int32))))
+		case ParquetInt64:
+			out.Write(binary.LittleEndian.AppendUint64(nil, uint64(v.(int64))))
+		case ParquetDouble:
+			out.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v.(float64))))
+		default:
+			s := v.(string)
+			out.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+			out.WriteString(s)
+		}
+	}
+	if len(booleans) > 0 {
+		// the booleans are bit-packed without header
+		packed := hybrid(booleans, 1)
+		_, n := binary.Uvarint(packed)
+		out.Write(packed[n:])
+	}
+	return out.Bytes()
+}
+
+// pages returns the dictionary page, empty without dictionary, and the data page of the column
+func (c *ParquetColumn) pages() ([]byte, []byte, int) {
+	var data []byte
+
+	// definition levels of the optional columns, prefixed by their size
+	if c.Optional {
+		levels := make([]int, len(c.Values))
+		for i, v := range c.Values {
+			if v != nil {
+				levels[i] = 1
+			}
+		}
+		encoded := hybrid(levels, 1)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(encoded)))
+		data = append(data, encoded...)
+	}
+
+	if !c.Dictionary {
+		return nil, append(data, c.plain(c.Values)...), 0
+	}
+
+	// the values are replaced by their index in the dictionary
+	index := make(map[interface{}]int)
+	dictionary := []interface{}{}
+	indexes := []int{}
+	for _, v := range c.Values {
+		if v == nil {
+			continue
+		}
+		i, ok := index[v]
+		if !ok {
+			i = len(dictionary)
+			index[v] = i
+			dictionary = append(dictionary, v)
+		}
+		indexes = append(indexes, i)
+	}
+	if len(dictionary) == 0 {
+		return nil, append(data, c.plain(c.Values)...), 0
+	}
+
+	width := bits.Len(uint(len(dictionary) - 1))
+	if width == 0 {
+		width = 1
+	}
+	data = append(data, byte(width))
+	data = append(data, hybrid(indexes, width)...)
+	return c.plain(dictionary), data, len(dictionary)
+}
+
+func parquetCompress(data []byte, compress bool) ([]byte, error) {
+	if !compress {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteParquetColumns writes the columns in a parquet file with one row group and one data page
+// per column, preceded by a dictionary page for the dictionary encoded columns
+func WriteParquetColumns(w io.Writer, columns []*ParquetColumn, rows int, compress bool) error {
+	codec := int32(parquetUncompressed)
+	if compress {
+		codec = parquetGzip
+	}
+
+	var out bytes.Buffer
+	out.WriteString("PAR1")
+
+	type chunk struct {
+		dictionaryOffset int64
+		dataOffset       int64
+		uncompressed     int64
+		compressed       int64
+	}
+	chunks := make([]chunk, len(columns))
+	var totalSize int64
+	for i, c := range columns {
+		dictionary, data, dictionarySize := c.pages()
+		chunks[i].dictionaryOffset = -1
+		start := out.Len()
+
+		if len(dictionary) > 0 {
+			page, err := parquetCompress(dictionary, compress)
+			if err != nil {
+				return err
+			}
+			header := thriftWriter{}
+			header.i32(1, parquetDictionaryPage)
+			header.i32(2, int32(len(dictionary)))
+			header.i32(3, int32(len(page)))
+			header.structField(7)
+			header.i32(1, int32(dictionarySize))
+			header.i32(2, parquetPlainDictionary)
+			header.end()
+			header.buf.WriteByte(0)
+
+			chunks[i].dictionaryOffset = int64(out.Len())
+			chunks[i].uncompressed += int64(header.buf.Len() + len(dictionary))
+			out.Write(header.buf.Bytes())
+			out.Write(page)
+		}
+
+		page, err := parquetCompress(data, compress)
+		if err != nil {
+			return err
+		}
+		encoding := int32(parquetPlain)
+		if len(dictionary) > 0 {
+			encoding = parquetPlainDictionary
+		}
+		header := thriftWriter{}
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(rows))
+		header.i32(2, encoding)
+		header.i32(3, parquetRle)
+		header.i32(4, parquetRle)
+		header.end()
+		header.buf.WriteByte(0)
+
+		chunks[i].dataOffset = int64(out.Len())
+		chunks[i].uncompressed += int64(header.buf.Len() + len(data))
+		out.Write(header.buf.Bytes())
+		out.Write(page)
+		chunks[i].compressed = int64(out.Len() - start)
+		totalSize += chunks[i].uncompressed
+	}
+
+	// file metadata
+	meta := thriftWriter{}
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.begin()
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for _, c := range columns {
+		meta.begin()
+		meta.i32(1, c.Type)
+		if c.Optional {
+			meta.i32(3, parquetOptional)
+		} else {
+			meta.i32(3, parquetRequired)
+		}
+		meta.str(4, c.Name)
+		if c.Converted != ParquetNone {
+			meta.i32(6, c.Converted)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(rows))
+	meta.list(4, thriftStruct, 1)
+	meta.begin()
+	meta.list(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		first := chunks[i].dataOffset
+		encodings := []int64{parquetPlain, parquetRle}
+		if chunks[i].dictionaryOffset >= 0 {
+			first = chunks[i].dictionaryOffset
+			encodings = []int64{parquetPlainDictionary, parquetRle}
+		}
+
+		meta.begin()
+		meta.i64(2, first)
+		meta.structField(3)
+		meta.i32(1, c.Type)
+		meta.list(2, thriftI32, len(encodings))
+		for _, encoding := range encodings {
+			meta.zigzag(encoding)
+		}
+		meta.list(3, thriftBinary, 1)
+		meta.binary(c.Name)
+		meta.i32(4, codec)
+		meta.i64(5, int64(rows))
+		meta.i64(6, chunks[i].uncompressed)
+		meta.i64(7, chunks[i].compressed)
+		meta.i64(9, chunks[i].dataOffset)
+		if chunks[i].dictionaryOffset >= 0 {
+			meta.i64(11, chunks[i].dictionaryOffset)
+		}
+		meta.end()
+		meta.end()
+	}
+	meta.i64(2, totalSize)
+	meta.i64(3, int64(rows))
+	meta.end()
+	meta.str(6, "go-dnscollector")
+	meta.buf.WriteByte(0)
+
+	out.Write(meta.buf.Bytes())
+	out.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len())))
+	out.WriteString("PAR1")
+
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return fmt.Errorf("parquet: %w", err)
+	}
+	return nil
+}
+
+// parquetType returns the type of the values decoded from json, the numbers are integers
+// if all integral and the mixed types or the other values are written as json strings
+func parquetType(values []interface{}) int32 {
+	kind := int32(-1)
+	for _, v := range values {
+		var k int32
+		switch value := v.(type) {
+		case nil:
+			continue
+		case bool:
+			k = ParquetBoolean
+		case float64:
+			k = ParquetInt64
+			if value != math.Trunc(value) || math.Abs(value) > 1<<53 {
+				k = ParquetDouble
+			}
+		default:
+			k = ParquetByteArray
+		}
+		switch {
+		case kind == -1 || kind == k:
+			kind = k
+		case (kind == ParquetInt64 && k == ParquetDouble) || (kind == ParquetDouble && k == ParquetInt64):
+			kind = ParquetDouble
+		default:
+			kind = ParquetByteArray
+		}
+	}
+	if kind == -1 {
+		return ParquetByteArray
+	}
+	return kind
+}
+
+// WriteParquet writes the rows decoded from json in a parquet file, the columns are
+// the keys of the rows sorted by name and all optional
+func WriteParquet(w io.Writer, rows []map[string]interface{}, compress bool) error {
+	keys := make(map[string]bool)
+	for _, row := range rows {
+		for k := range row {
+			keys[k] = true
+		}
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	columns := make([]*ParquetColumn, len(names))
+	for i, name := range names {
+		c := &ParquetColumn{Name: name, Converted: ParquetNone, Optional: true, Values: make([]interface{}, len(rows))}
+		for j, row := range rows {
+			c.Values[j] = row[name]
+		}
+		c.Type = parquetType(c.Values)
+
+		// the values are converted to the type of the column
+		for j, v := range c.Values {
+			if v == nil {
+				continue
+			}
+			switch c.Type {
+			case ParquetInt64:
+				c.Values[j] = int64(v.(float64))
+			case ParquetByteArray:
+				if _, ok := v.(string); !ok {
+					data, _ := json.Marshal(v)
+					c.Values[j] = string(data)
+				}
+			}
+		}
+		if c.Type == ParquetByteArray {
+			c.Converted = ParquetUtf8
+		}
+		columns[i] = c
+	}
+	return WriteParquetColumns(w, columns, len(rows), compress)
+}
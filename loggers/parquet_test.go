@@ -0,0 +1,242 @@
+package loggers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// thriftReader decodes the thrift compact structs in maps indexed by field id
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	u := r.uvarint()
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 4, 5, 6:
+		return r.zigzag()
+	case 8:
+		n := int(r.uvarint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9:
+		header := r.data[r.pos]
+		r.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := []interface{}{}
+		for i := 0; i < size; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic("unsupported thrift type")
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	last := int16(0)
+	for {
+		header := r.data[r.pos]
+		r.pos++
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		last = id
+		fields[id] = r.value(header & 0x0f)
+	}
+}
+
+func Test_WriteParquet(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"qname": "dns.collector", "length": float64(42), "latency": 0.5, "tc": true},
+		{"qname": "dns.example", "tc": false},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, rows, true); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("invalid magic")
+	}
+
+	// file metadata
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := thriftReader{data: data[len(data)-8-size : len(data)-8]}
+	meta := footer.structure()
+	if meta[3].(int64) != 2 {
+		t.Errorf("invalid number of rows: %v", meta[3])
+	}
+
+	types := map[string]int64{}
+	for _, item := range meta[2].([]interface{})[1:] {
+		element := item.(map[int16]interface{})
+		types[element[4].(string)] = element[1].(int64)
+	}
+	want := map[string]int64{"latency": ParquetDouble, "length": ParquetInt64, "qname": ParquetByteArray, "tc": ParquetBoolean}
+	for name, kind := range want {
+		if types[name] != kind {
+			t.Errorf("column %s: want type %d, got %d", name, kind, types[name])
+		}
+	}
+
+	// pages of the columns
+	pages := map[string][]byte{}
+	rowGroup := meta[4].([]interface{})[0].(map[int16]interface{})
+	for _, item := range rowGroup[1].([]interface{}) {
+		chunk := item.(map[int16]interface{})[3].(map[int16]interface{})
+		name := chunk[3].([]interface{})[0].(string)
+		reader := thriftReader{data: data, pos: int(chunk[9].(int64))}
+		header := reader.structure()
+		page := data[reader.pos : reader.pos+int(header[3].(int64))]
+
+		gz, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages[name], _ = io.ReadAll(gz)
+	}
+
+	// definition levels then plain values
+	qname := pages["qname"]
+	levelsLen := int(binary.LittleEndian.Uint32(qname))
+	if qname[4+levelsLen-1] != 0x03 {
+		t.Errorf("invalid definition levels of qname: %x", qname[4:4+levelsLen])
+	}
+	values := qname[4+levelsLen:]
+	if n := binary.LittleEndian.Uint32(values); string(values[4:4+n]) != "dns.collector" {
+		t.Errorf("invalid first qname: %s", values[4:4+n])
+	}
+
+	length := pages["length"]
+	levelsLen = int(binary.LittleEndian.Uint32(length))
+	if length[4+levelsLen-1] != 0x01 {
+		t.Errorf("invalid definition levels of length: %x", length[4:4+levelsLen])
+	}
+	if v := binary.LittleEndian.Uint64(length[4+levelsLen:]); v != 42 {
+		t.Errorf("invalid length: %d", v)
+	}
+
+	latency := pages["latency"]
+	levelsLen = int(binary.LittleEndian.Uint32(latency))
+	if v := math.Float64frombits(binary.LittleEndian.Uint64(latency[4+levelsLen:])); v != 0.5 {
+		t.Errorf("invalid latency: %f", v)
+	}
+
+	tc := pages["tc"]
+	levelsLen = int(binary.LittleEndian.Uint32(tc))
+	if tc[4+levelsLen] != 0x01 {
+		t.Errorf("invalid booleans: %x", tc[4+levelsLen:])
+	}
+}
+
+// parquetRow is a row of the columns written in Test_ReadParquetColumns, decoded by a parquet reader
+type parquetRow struct {
+	Timestamp int64   `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Qname     string  `parquet:"name=qname, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Port      *int32  `parquet:"name=port, type=INT32, repetitiontype=OPTIONAL"`
+	Latency   float64 `parquet:"name=latency, type=DOUBLE"`
+	Tc        bool    `parquet:"name=tc, type=BOOLEAN"`
+	Rcode     *string `parquet:"name=rcode, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+func Test_ReadParquetColumns(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			// enough rows for several groups of bit-packed values and a dictionary index on 2 bits
+			want := []parquetRow{}
+			columns := []*ParquetColumn{
+				{Name: "timestamp", Type: ParquetInt64, Converted: ParquetTimestampMicros},
+				{Name: "qname", Type: ParquetByteArray, Converted: ParquetUtf8, Dictionary: true},
+				{Name: "port", Type: ParquetInt32, Converted: ParquetNone, Optional: true},
+				{Name: "latency", Type: ParquetDouble, Converted: ParquetNone},
+				{Name: "tc", Type: ParquetBoolean, Converted: ParquetNone},
+				{Name: "rcode", Type: ParquetByteArray, Converted: ParquetUtf8, Optional: true, Dictionary: true},
+			}
+			for i := 0; i < 20; i++ {
+				row := parquetRow{
+					Timestamp: 1700000000000000 + int64(i),
+					Qname:     fmt.Sprintf("%d.collector", i%3),
+					Latency:   float64(i) / 4,
+					Tc:        i%3 == 0,
+				}
+				columns[0].Values = append(columns[0].Values, row.Timestamp)
+				columns[1].Values = append(columns[1].Values, row.Qname)
+				columns[3].Values = append(columns[3].Values, row.Latency)
+				columns[4].Values = append(columns[4].Values, row.Tc)
+
+				var port, rcode interface{}
+				if i%2 == 0 {
+					p := int32(53000 + i)
+					row.Port, port = &p, p
+				}
+				if i%5 == 0 {
+					r := "NXDOMAIN"
+					row.Rcode, rcode = &r, r
+				}
+				columns[2].Values = append(columns[2].Values, port)
+				columns[5].Values = append(columns[5].Values, rcode)
+				want = append(want, row)
+			}
+
+			var buf bytes.Buffer
+			if err := WriteParquetColumns(&buf, columns, len(want), compress); err != nil {
+				t.Fatal(err)
+			}
+
+			// the file is decoded by a parquet reader
+			pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(buf.Bytes()), new(parquetRow), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pr.ReadStop()
+			if pr.GetNumRows() != int64(len(want)) {
+				t.Fatalf("want %d rows, got %d", len(want), pr.GetNumRows())
+			}
+			got := make([]parquetRow, len(want))
+			if err := pr.Read(&got); err != nil {
+				t.Fatal(err)
+			}
+			for i := range want {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Errorf("row %d: want %+v, got %+v", i, want[i], got[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package loggers
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+// ParquetField is a column of the dns schema of the parquet files
+type ParquetField struct {
+	Name       string
+	Type       int32
+	Converted  int32
+	Optional   bool
+	Dictionary bool
+	Value      func(dm *dnsutils.DnsMessage) interface{}
+}
+
+// parquetPort returns the port as integer, nil if unknown
+func parquetPort(port string) interface{} {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+	return int32(n)
+}
+
+// ParquetDnsSchema is the schema of the parquet files, the columns with few distinct
+// values are dictionary encoded and the timestamps are in microseconds
+var ParquetDnsSchema = []ParquetField{
+	{"timestamp", ParquetInt64, ParquetTimestampMicros, false, false, func(dm *dnsutils.DnsMessage) interface{} {
+		return int64(dm.DnsTap.TimeSec)*1000000 + int64(dm.DnsTap.TimeNsec)/1000
+	}},
+	{"identity", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.DnsTap.Identity }},
+	{"operation", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.DnsTap.Operation }},
+	{"family", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.NetworkInfo.Family }},
+	{"protocol", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.NetworkInfo.Protocol }},
+	{"query_ip", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.NetworkInfo.QueryIp }},
+	{"query_port", ParquetInt32, ParquetNone, true, false, func(dm *dnsutils.DnsMessage) interface{} { return parquetPort(dm.NetworkInfo.QueryPort) }},
+	{"response_ip", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.NetworkInfo.ResponseIp }},
+	{"response_port", ParquetInt32, ParquetNone, true, false, func(dm *dnsutils.DnsMessage) interface{} { return parquetPort(dm.NetworkInfo.ResponsePort) }},
+	{"type", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Type }},
+	{"id", ParquetInt32, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return int32(dm.DNS.Id) }},
+	{"opcode", ParquetInt32, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return int32(dm.DNS.Opcode) }},
+	{"qname", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Qname }},
+	{"qtype", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Qtype }},
+	{"rcode", ParquetByteArray, ParquetUtf8, false, true, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Rcode }},
+	{"length", ParquetInt32, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return int32(dm.DNS.Length) }},
+	{"latency", ParquetDouble, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DnsTap.Latency }},
+	{"answer_count", ParquetInt32, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return int32(len(dm.DNS.DnsRRs.Answers)) }},
+	{"malformed", ParquetBoolean, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.MalformedPacket }},
+	{"flag_qr", ParquetBoolean, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Flags.QR }},
+	{"flag_tc", ParquetBoolean, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Flags.TC }},
+	{"flag_aa", ParquetBoolean, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Flags.AA }},
+	{"flag_ra", ParquetBoolean, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Flags.RA }},
+	{"flag_ad", ParquetBoolean, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Flags.AD }},
+	{"flag_cd", ParquetBoolean, ParquetNone, false, false, func(dm *dnsutils.DnsMessage) interface{} { return dm.DNS.Flags.CD }},
+}
+
+// ParquetFile writes the messages in local parquet files with a typed schema, a new file
+// is written when the max number of rows is reached or at each flush interval
+type ParquetFile struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	columns    []*ParquetColumn
+	rows       int
+}
+
+func NewParquetFile(config *dnsutils.Config, logger *logger.Logger, name string) *ParquetFile {
+	logger.Info("[%s] logger parquetfile - enabled", name)
+	o := &ParquetFile{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		config:     config,
+		logger:     logger,
+		name:       name,
+	}
+	o.ReadConfig()
+	o.Reset()
+	return o
+}
+
+func (c *ParquetFile) GetName() string { return c.name }
+
+func (c *ParquetFile) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *ParquetFile) ReadConfig() {
+	if len(o.config.Loggers.ParquetFile.FilePath) == 0 {
+		o.logger.Fatal("logger parquetfile - file path is required")
+	}
+	if o.config.Loggers.ParquetFile.MaxRows <= 0 {
+		o.logger.Fatal("logger parquetfile - max-rows must be positive")
+	}
+}
+
+func (o *ParquetFile) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (c *ParquetFile) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] logger parquetfile - "+msg, v...)
+}
+
+func (c *ParquetFile) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] logger parquetfile - "+msg, v...)
+}
+
+func (o *ParquetFile) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *ParquetFile) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// Reset starts a new file
+func (o *ParquetFile) Reset() {
+	o.rows = 0
+	o.columns = make([]*ParquetColumn, len(ParquetDnsSchema))
+	for i, field := range ParquetDnsSchema {
+		o.columns[i] = &ParquetColumn{
+			Name:       field.Name,
+			Type:       field.Type,
+			Converted:  field.Converted,
+			Optional:   field.Optional,
+			Dictionary: field.Dictionary,
+		}
+	}
+}
+
+// Add appends the message to the columns, the file is written when full
+func (o *ParquetFile) Add(dm *dnsutils.DnsMessage) {
+	for i, field := range ParquetDnsSchema {
+		o.columns[i].Values = append(o.columns[i].Values, field.Value(dm))
+	}
+	o.rows++
+
+	if o.rows >= o.config.Loggers.ParquetFile.MaxRows {
+		o.Roll()
+	}
+}
+
+// FileName returns the name of the next file, the base name of the file path followed by the time
+func (o *ParquetFile) FileName(t time.Time) string {
+	path := o.config.Loggers.ParquetFile.FilePath
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	return base + "-" + t.UTC().Format("20060102T150405.000000Z") + ".parquet"
+}
+
+// Roll writes the rows in a new file, the file is renamed when complete and the
+// oldest files are removed
+func (o *ParquetFile) Roll() {
+	if o.rows == 0 {
+		return
+	}
+	defer o.Reset()
+
+	filename := o.FileName(time.Now())
+	fd, err := os.Create(filename + ".tmp")
+	if err != nil {
+		o.LogError("unable to create file: %v", err)
+		return
+	}
+	err = WriteParquetColumns(fd, o.columns, o.rows, o.config.Loggers.ParquetFile.Compress)
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		o.LogError("unable to write file: %v", err)
+		os.Remove(filename + ".tmp")
+		return
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		o.LogError("unable to rename file: %v", err)
+		return
+	}
+	o.LogInfo("file %s written with %d rows", filename, o.rows)
+
+	// keep the most recent files
+	if o.config.Loggers.ParquetFile.MaxFiles <= 0 {
+		return
+	}
+	path := o.config.Loggers.ParquetFile.FilePath
+	files, err := filepath.Glob(strings.TrimSuffix(path, filepath.Ext(path)) + "-*.parquet")
+	if err != nil {
+		return
+	}
+	sort.Strings(files)
+	for len(files) > o.config.Loggers.ParquetFile.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			o.LogError("unable to remove file: %v", err)
+		}
+		files = files[1:]
+	}
+}
+
+func (o *ParquetFile) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	flushInterval := time.Duration(o.config.Loggers.ParquetFile.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+			o.Add(&dm)
+
+		case <-flushTimer.C:
+			o.Roll()
+			flushTimer.Reset(flushInterval)
+		}
+	}
+
+	// the rows in progress are written before to stop
+	flushTimer.Stop()
+	o.Roll()
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
@@ -0,0 +1,100 @@
+package loggers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_ParquetFileRoll(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.ParquetFile.FilePath = filepath.Join(t.TempDir(), "dns.parquet")
+	cfg.Loggers.ParquetFile.MaxRows = 2
+	cfg.Loggers.ParquetFile.MaxFiles = 1
+
+	g := NewParquetFile(cfg, logger.New(false), "test")
+	go g.Run()
+
+	// a file is written after 2 messages, the last one on stop
+	for _, qname := range []string{"a.collector", "a.collector", "b.collector"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		dm.DnsTap.TimeSec = 1700000000
+		dm.DnsTap.TimeNsec = 123456789
+		g.Channel() <- dm
+	}
+	g.Stop()
+
+	// only the most recent file is kept
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(cfg.Loggers.ParquetFile.FilePath), "dns-*.parquet"))
+	if len(files) != 1 {
+		t.Fatalf("one file expected, got %v", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := thriftReader{data: data[len(data)-8-size : len(data)-8]}
+	meta := footer.structure()
+	if meta[3].(int64) != 1 {
+		t.Errorf("invalid number of rows: %v", meta[3])
+	}
+
+	// typed schema
+	schema := meta[2].([]interface{})
+	if len(schema) != len(ParquetDnsSchema)+1 {
+		t.Fatalf("invalid schema: %d elements", len(schema))
+	}
+	timestamp := schema[1].(map[int16]interface{})
+	if timestamp[4] != "timestamp" || timestamp[1].(int64) != ParquetInt64 || timestamp[6].(int64) != ParquetTimestampMicros {
+		t.Errorf("invalid timestamp column: %v", timestamp)
+	}
+
+	// the qname is dictionary encoded
+	rowGroup := meta[4].([]interface{})[0].(map[int16]interface{})
+	for _, item := range rowGroup[1].([]interface{}) {
+		chunk := item.(map[int16]interface{})[3].(map[int16]interface{})
+		name := chunk[3].([]interface{})[0].(string)
+		switch name {
+		case "qname":
+			offset, ok := chunk[11].(int64)
+			if !ok {
+				t.Fatalf("qname without dictionary page")
+			}
+			reader := thriftReader{data: data, pos: int(offset)}
+			header := reader.structure()
+			if header[1].(int64) != parquetDictionaryPage {
+				t.Fatalf("invalid page type: %v", header[1])
+			}
+			gz, err := gzip.NewReader(bytes.NewReader(data[reader.pos : reader.pos+int(header[3].(int64))]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, _ := io.ReadAll(gz)
+			if n := binary.LittleEndian.Uint32(page); string(page[4:4+n]) != "b.collector" {
+				t.Errorf("invalid dictionary: %s", page)
+			}
+
+		case "timestamp":
+			reader := thriftReader{data: data, pos: int(chunk[9].(int64))}
+			header := reader.structure()
+			gz, err := gzip.NewReader(bytes.NewReader(data[reader.pos : reader.pos+int(header[3].(int64))]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, _ := io.ReadAll(gz)
+			if v := binary.LittleEndian.Uint64(page); v != 1700000000123456 {
+				t.Errorf("invalid timestamp: %d", v)
+			}
+		}
+	}
+}
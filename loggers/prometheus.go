@@ -30,6 +30,97 @@ func SanitizeMetricName(metricName string) string {
 	return metricNameRegex.ReplaceAllString(metricName, "_")
 }
 
+// OutputsCollector exports the number of messages dropped by the outputs with an overflow policy
+type OutputsCollector struct {
+	desc *prometheus.Desc
+}
+
+func NewOutputsCollector(promPrefix string) *OutputsCollector {
+	return &OutputsCollector{
+		desc: prometheus.NewDesc(fmt.Sprintf("%s_output_dropped_total", promPrefix),
+			"Number of messages dropped because the logger was full", []string{"output"}, nil),
+	}
+}
+
+func (c *OutputsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *OutputsCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, dropped := range dnsutils.GetOutputsDropped() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(dropped), name)
+	}
+}
+
+// LatencyCollector exports the counters of the queries caches of the latency transformers
+type LatencyCollector struct {
+	entries   *prometheus.Desc
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	evictions *prometheus.Desc
+	expired   *prometheus.Desc
+}
+
+func NewLatencyCollector(promPrefix string) *LatencyCollector {
+	labels := []string{"name", "cache"}
+	return &LatencyCollector{
+		entries: prometheus.NewDesc(fmt.Sprintf("%s_latency_cache_entries", promPrefix),
+			"Number of queries waiting for a reply", labels, nil),
+		hits: prometheus.NewDesc(fmt.Sprintf("%s_latency_cache_hits_total", promPrefix),
+			"Number of replies matched with their query", labels, nil),
+		misses: prometheus.NewDesc(fmt.Sprintf("%s_latency_cache_misses_total", promPrefix),
+			"Number of replies without query", labels, nil),
+		evictions: prometheus.NewDesc(fmt.Sprintf("%s_latency_cache_evictions_total", promPrefix),
+			"Number of queries evicted because the cache was full", labels, nil),
+		expired: prometheus.NewDesc(fmt.Sprintf("%s_latency_cache_expired_total", promPrefix),
+			"Number of queries expired without reply", labels, nil),
+	}
+}
+
+func (c *LatencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entries
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expired
+}
+
+func (c *LatencyCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, caches := range transformers.GetLatencyStats() {
+		for cache, stats := range caches {
+			ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(stats.Entries), name, cache)
+			ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits), name, cache)
+			ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses), name, cache)
+			ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions), name, cache)
+			ch <- prometheus.MustNewConstMetric(c.expired, prometheus.CounterValue, float64(stats.Expired), name, cache)
+		}
+	}
+}
+
+// TransformsCollector exports the number of messages dropped by the transformers
+type TransformsCollector struct {
+	dropped *prometheus.Desc
+}
+
+func NewTransformsCollector(promPrefix string) *TransformsCollector {
+	return &TransformsCollector{
+		dropped: prometheus.NewDesc(fmt.Sprintf("%s_transform_dropped_total", promPrefix),
+			"Number of messages dropped by the transformers", []string{"name", "transform"}, nil),
+	}
+}
+
+func (c *TransformsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.dropped
+}
+
+func (c *TransformsCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, counters := range transformers.GetDroppedStats() {
+		for transform, dropped := range counters {
+			ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(dropped), name, transform)
+		}
+	}
+}
+
 type EpsCounters struct {
 	Eps             uint64
 	EpsMax          uint64
@@ -39,6 +130,7 @@ type EpsCounters struct {
 
 type Prometheus struct {
 	done         chan bool
+	configChan   chan *dnsutils.Config
 	done_api     chan bool
 	httpServer   *http.Server
 	netListener  net.Listener
@@ -87,6 +179,7 @@ type Prometheus struct {
 	gaugeEpsMax *prometheus.GaugeVec
 
 	counterPackets     *prometheus.CounterVec
+	counterMalformed   *prometheus.CounterVec
 	totalReceivedBytes *prometheus.CounterVec
 	totalSentBytes     *prometheus.CounterVec
 
@@ -106,10 +199,13 @@ type Prometheus struct {
 	counterSuspiciousUniq *prometheus.CounterVec
 	counterEvictedUniq    *prometheus.CounterVec
 
+	counterSuspiciousDetections *prometheus.CounterVec
+
 	histogramQueriesLength *prometheus.HistogramVec
 	histogramRepliesLength *prometheus.HistogramVec
 	histogramQnamesLength  *prometheus.HistogramVec
 	histogramLatencies     *prometheus.HistogramVec
+	summaryLatencies       *prometheus.SummaryVec
 
 	name string
 }
@@ -118,6 +214,7 @@ func NewPrometheus(config *dnsutils.Config, logger *logger.Logger, version strin
 	logger.Info("[%s] logger to prometheus - enabled", name)
 	o := &Prometheus{
 		done:         make(chan bool),
+		configChan:   make(chan *dnsutils.Config, 1),
 		done_api:     make(chan bool),
 		config:       config,
 		channel:      make(chan dnsutils.DnsMessage, 512),
@@ -214,6 +311,10 @@ func (o *Prometheus) InitProm() {
 	)
 	o.promRegistry.MustRegister(o.gaugeBuildInfo)
 
+	o.promRegistry.MustRegister(NewOutputsCollector(prom_prefix))
+	o.promRegistry.MustRegister(NewLatencyCollector(prom_prefix))
+	o.promRegistry.MustRegister(NewTransformsCollector(prom_prefix))
+
 	o.gaugeTopTlds = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: fmt.Sprintf("%s_top_tlds", prom_prefix),
@@ -320,6 +421,15 @@ func (o *Prometheus) InitProm() {
 	)
 	o.promRegistry.MustRegister(o.counterPackets)
 
+	o.counterMalformed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_malformed_total", prom_prefix),
+			Help: "The total number of malformed packets per stream identity",
+		},
+		[]string{"stream_id"},
+	)
+	o.promRegistry.MustRegister(o.counterMalformed)
+
 	o.histogramQueriesLength = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    fmt.Sprintf("%s_queries_size_bytes", prom_prefix),
@@ -360,6 +470,16 @@ func (o *Prometheus) InitProm() {
 	)
 	o.promRegistry.MustRegister(o.histogramLatencies)
 
+	o.summaryLatencies = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       fmt.Sprintf("%s_latencies_quantiles", prom_prefix),
+			Help:       "Quantiles of the latency between query and reply",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		[]string{"stream_id"},
+	)
+	o.promRegistry.MustRegister(o.summaryLatencies)
+
 	o.totalReceivedBytes = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: fmt.Sprintf("%s_received_bytes_total", prom_prefix),
@@ -414,6 +534,15 @@ func (o *Prometheus) InitProm() {
 	)
 	o.promRegistry.MustRegister(o.counterSuspicious)
 
+	o.counterSuspiciousDetections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_suspicious_detections_total", prom_prefix),
+			Help: "The total number of dga and tunneling detections per stream identity",
+		},
+		[]string{"stream_id", "detection"},
+	)
+	o.promRegistry.MustRegister(o.counterSuspiciousDetections)
+
 	o.counterDomainsNx = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: fmt.Sprintf("%s_nxdomains_total", prom_prefix),
@@ -511,6 +640,11 @@ func (o *Prometheus) ReadConfig() {
 	}
 }
 
+func (o *Prometheus) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
 func (o *Prometheus) LogInfo(msg string, v ...interface{}) {
 	o.logger.Info("["+o.name+"] prometheus - "+msg, v...)
 }
@@ -573,6 +707,11 @@ func (o *Prometheus) Record(dm dnsutils.DnsMessage) {
 		strconv.FormatBool(dm.NetworkInfo.TcpReassembled),
 	).Inc()
 
+	// count malformed packets
+	if dm.DNS.MalformedPacket {
+		o.counterMalformed.WithLabelValues(dm.DnsTap.Identity).Inc()
+	}
+
 	// count the number of queries and replies
 	// count the total bytes for queries and replies
 	// and then make a histogram for queries and replies packet length observed
@@ -590,6 +729,7 @@ func (o *Prometheus) Record(dm dnsutils.DnsMessage) {
 	// make histogram for latencies observed
 	if dm.DnsTap.Latency > 0.0 {
 		o.histogramLatencies.WithLabelValues(dm.DnsTap.Identity).Observe(dm.DnsTap.Latency)
+		o.summaryLatencies.WithLabelValues(dm.DnsTap.Identity).Observe(dm.DnsTap.Latency)
 	}
 
 	/* count all domains name and top domains */
@@ -754,6 +894,13 @@ func (o *Prometheus) Record(dm dnsutils.DnsMessage) {
 
 	// suspicious domains
 	if dm.Suspicious != nil {
+		if dm.Suspicious.Dga {
+			o.counterSuspiciousDetections.WithLabelValues(dm.DnsTap.Identity, "dga").Inc()
+		}
+		if dm.Suspicious.Tunneling {
+			o.counterSuspiciousDetections.WithLabelValues(dm.DnsTap.Identity, "tunneling").Inc()
+		}
+
 		if dm.Suspicious.Score > 0.0 {
 			if _, exists := o.suspiciousUniq[dm.DNS.Qname]; !exists {
 				o.suspiciousUniq[dm.DNS.Qname] = 1
@@ -842,7 +989,7 @@ func (s *Prometheus) ListenAndServe() {
 
 	var err error
 	var listener net.Listener
-	addrlisten := s.config.Loggers.Prometheus.ListenIP + ":" + strconv.Itoa(s.config.Loggers.Prometheus.ListenPort)
+	addrlisten := net.JoinHostPort(s.config.Loggers.Prometheus.ListenIP, strconv.Itoa(s.config.Loggers.Prometheus.ListenPort))
 	// listening with tls enabled ?
 	if s.config.Loggers.Prometheus.TlsSupport {
 		s.LogInfo("tls support enabled")
@@ -915,6 +1062,10 @@ func (s *Prometheus) Run() {
 LOOP:
 	for {
 		select {
+		case cfg := <-s.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
 		case dm, opened := <-s.channel:
 			if !opened {
 				s.LogInfo("channel closed")
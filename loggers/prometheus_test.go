@@ -59,8 +59,13 @@ func TestPrometheus_GetMetrics(t *testing.T) {
 	noerror_record := dnsutils.GetFakeDnsMessage()
 	nx_record := dnsutils.GetFakeDnsMessage()
 	nx_record.DNS.Rcode = dnsutils.DNS_RCODE_NXDOMAIN
+	nx_record.DNS.MalformedPacket = true
+	nx_record.DnsTap.Latency = 0.05
+	dga_record := dnsutils.GetFakeDnsMessage()
+	dga_record.Suspicious = &dnsutils.Suspicious{Score: 2.0, HighEntropy: true, Dga: true}
 	g.Record(noerror_record)
 	g.Record(nx_record)
+	g.Record(dga_record)
 
 	tt := []struct {
 		name       string
@@ -90,6 +95,27 @@ func TestPrometheus_GetMetrics(t *testing.T) {
 			want:       config.Loggers.Prometheus.PromPrefix + `_nxdomains_total{stream_id="collector"} 1`,
 			statusCode: http.StatusOK,
 		},
+		{
+			name:       "total malformed",
+			method:     http.MethodGet,
+			handler:    g.httpServer.Handler.ServeHTTP,
+			want:       config.Loggers.Prometheus.PromPrefix + `_malformed_total{stream_id="collector"} 1`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "total dga detections",
+			method:     http.MethodGet,
+			handler:    g.httpServer.Handler.ServeHTTP,
+			want:       config.Loggers.Prometheus.PromPrefix + `_suspicious_detections_total{detection="dga",stream_id="collector"} 1`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "latency quantiles",
+			method:     http.MethodGet,
+			handler:    g.httpServer.Handler.ServeHTTP,
+			want:       config.Loggers.Prometheus.PromPrefix + `_latencies_quantiles{stream_id="collector",quantile="0.99"} 0.05`,
+			statusCode: http.StatusOK,
+		},
 	}
 
 	for _, tc := range tt {
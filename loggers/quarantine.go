@@ -0,0 +1,280 @@
+package loggers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// QuarantineRecord is a malformed packet written in the quarantine file
+type QuarantineRecord struct {
+	Timestamp    string `json:"timestamp"`
+	Identity     string `json:"identity"`
+	Operation    string `json:"operation"`
+	Family       string `json:"family"`
+	Protocol     string `json:"protocol"`
+	QueryIp      string `json:"query-ip"`
+	QueryPort    string `json:"query-port"`
+	ResponseIp   string `json:"response-ip"`
+	ResponsePort string `json:"response-port"`
+	Qname        string `json:"qname"`
+	Length       int    `json:"length"`
+	Encoding     string `json:"encoding"`
+	Payload      string `json:"payload"`
+}
+
+// QuarantineStats counts the malformed packets
+type QuarantineStats struct {
+	Captured int
+	Dropped  int
+}
+
+// Quarantine writes the raw payload of the malformed packets in a file and optionally in a pcap
+// file to debug the parser or analyze the malformed traffic, the number of packets is limited
+// by second
+type Quarantine struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	fileFd     *os.File
+	pcapFd     *os.File
+	writerPcap *pcapgo.Writer
+	stats      QuarantineStats
+	second     int64
+	count      int
+}
+
+func NewQuarantine(config *dnsutils.Config, logger *logger.Logger, name string) *Quarantine {
+	logger.Info("[%s] logger quarantine - enabled", name)
+	o := &Quarantine{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		config:     config,
+		logger:     logger,
+		name:       name,
+	}
+	o.ReadConfig()
+	if err := o.OpenFiles(); err != nil {
+		o.logger.Fatal("logger quarantine - unable to open the files: ", err)
+	}
+	return o
+}
+
+func (c *Quarantine) GetName() string { return c.name }
+
+func (c *Quarantine) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *Quarantine) ReadConfig() {
+	cfg := o.config.Loggers.Quarantine
+	if len(cfg.FilePath) == 0 {
+		o.logger.Fatal("logger quarantine - file-path is required")
+	}
+	if cfg.Encoding != dnsutils.ENCODING_HEX && cfg.Encoding != dnsutils.ENCODING_BASE64 {
+		o.logger.Fatal("logger quarantine - invalid encoding: ", cfg.Encoding)
+	}
+	if cfg.MaxPerSecond < 0 {
+		o.logger.Fatal("logger quarantine - max-per-second must be positive or zero")
+	}
+	if o.config.Global.DropPayload {
+		o.LogError("the payloads are dropped by the collectors, disable drop-payload to capture them")
+	}
+}
+
+func (o *Quarantine) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (c *Quarantine) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] logger quarantine - "+msg, v...)
+}
+
+func (c *Quarantine) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] logger quarantine - "+msg, v...)
+}
+
+func (o *Quarantine) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *Quarantine) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// Stats returns the counters of the malformed packets
+func (o *Quarantine) Stats() QuarantineStats {
+	return o.stats
+}
+
+// OpenFiles opens the quarantine file and the pcap file in append mode
+func (o *Quarantine) OpenFiles() error {
+	var err error
+	o.fileFd, err = os.OpenFile(o.config.Loggers.Quarantine.FilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	if len(o.config.Loggers.Quarantine.PcapFile) == 0 {
+		return nil
+	}
+	o.pcapFd, err = os.OpenFile(o.config.Loggers.Quarantine.PcapFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fileinfo, err := o.pcapFd.Stat()
+	if err != nil {
+		return err
+	}
+	o.writerPcap = pcapgo.NewWriter(o.pcapFd)
+	if fileinfo.Size() == 0 {
+		if err := o.writerPcap.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Allow returns false when the number of packets of the current second is reached
+func (o *Quarantine) Allow() bool {
+	if o.config.Loggers.Quarantine.MaxPerSecond == 0 {
+		return true
+	}
+	if now := time.Now().Unix(); now != o.second {
+		o.second, o.count = now, 0
+	}
+	if o.count >= o.config.Loggers.Quarantine.MaxPerSecond {
+		return false
+	}
+	o.count++
+	return true
+}
+
+// Capture writes the payload of the malformed packet in the quarantine file and in the pcap file
+func (o *Quarantine) Capture(dm *dnsutils.DnsMessage) {
+	record := QuarantineRecord{
+		Timestamp:    time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec)).UTC().Format(time.RFC3339Nano),
+		Identity:     dm.DnsTap.Identity,
+		Operation:    dm.DnsTap.Operation,
+		Family:       dm.NetworkInfo.Family,
+		Protocol:     dm.NetworkInfo.Protocol,
+		QueryIp:      dm.NetworkInfo.QueryIp,
+		QueryPort:    dm.NetworkInfo.QueryPort,
+		ResponseIp:   dm.NetworkInfo.ResponseIp,
+		ResponsePort: dm.NetworkInfo.ResponsePort,
+		Qname:        dm.DNS.Qname,
+		Length:       len(dm.DNS.Payload),
+		Encoding:     o.config.Loggers.Quarantine.Encoding,
+	}
+	if record.Encoding == dnsutils.ENCODING_BASE64 {
+		record.Payload = base64.StdEncoding.EncodeToString(dm.DNS.Payload)
+	} else {
+		record.Payload = hex.EncodeToString(dm.DNS.Payload)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		o.LogError("encoding record failed: %v", err)
+		return
+	}
+	if _, err := o.fileFd.Write(append(data, '\n')); err != nil {
+		o.LogError("unable to write the quarantine file: %v", err)
+	}
+
+	if o.writerPcap == nil {
+		return
+	}
+	pkt, err := dm.ToPacketLayer()
+	if err != nil {
+		o.LogError("failed to encode to packet layer: %s", err)
+		return
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	for _, l := range pkt {
+		l.SerializeTo(buf, opts)
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec)),
+		CaptureLength: len(buf.Bytes()),
+		Length:        len(buf.Bytes()),
+	}
+	if err := o.writerPcap.WritePacket(ci, buf.Bytes()); err != nil {
+		o.LogError("unable to write the pcap file: %v", err)
+	}
+}
+
+func (o *Quarantine) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers and the rate, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+			o.config.Loggers.Quarantine.MaxPerSecond = cfg.Loggers.Quarantine.MaxPerSecond
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// only the malformed packets with their payload are captured
+			if !dm.DNS.MalformedPacket || len(dm.DNS.Payload) == 0 {
+				continue
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			if !o.Allow() {
+				o.stats.Dropped++
+				continue
+			}
+			o.stats.Captured++
+			o.Capture(&dm)
+		}
+	}
+
+	o.LogInfo("%d malformed packets captured, %d dropped by the rate limit", o.stats.Captured, o.stats.Dropped)
+	o.fileFd.Close()
+	if o.pcapFd != nil {
+		o.pcapFd.Close()
+	}
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
@@ -0,0 +1,93 @@
+package loggers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func Test_QuarantineCapture(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Quarantine.FilePath = filepath.Join(t.TempDir(), "malformed.json")
+	cfg.Loggers.Quarantine.PcapFile = filepath.Join(t.TempDir(), "malformed.pcap")
+	cfg.Loggers.Quarantine.MaxPerSecond = 2
+
+	g := NewQuarantine(cfg, logger.New(false), "test")
+	go g.Run()
+
+	// a valid message is ignored
+	g.Channel() <- dnsutils.GetFakeDnsMessage()
+
+	// malformed packets, only two are captured in the same second
+	payload := []byte{0xde, 0xad, 0xbe, 0xef, 0x01}
+	for i := 0; i < 5; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+		dm.NetworkInfo.Protocol = dnsutils.PROTO_UDP
+		dm.NetworkInfo.QueryPort, dm.NetworkInfo.ResponsePort = "53000", "53"
+		dm.DNS.Payload = payload
+		dm.DNS.MalformedPacket = true
+		g.Channel() <- dm
+	}
+	g.Stop()
+
+	if stats := g.Stats(); stats.Captured != 2 || stats.Dropped != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// the payload is encoded in the quarantine file
+	fd, err := os.Open(cfg.Loggers.Quarantine.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	records := []QuarantineRecord{}
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		record := QuarantineRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("two records expected, got %d", len(records))
+	}
+	if records[0].Payload != hex.EncodeToString(payload) || records[0].Length != len(payload) || records[0].QueryIp != "1.2.3.4" {
+		t.Errorf("unexpected record: %+v", records[0])
+	}
+
+	// and written as is in the pcap file
+	pcapFd, err := os.Open(cfg.Loggers.Quarantine.PcapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pcapFd.Close()
+	reader, err := pcapgo.NewReader(pcapFd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := 0
+	for {
+		data, _, err := reader.ReadPacketData()
+		if err != nil {
+			break
+		}
+		// the frame is padded to the ethernet min size
+		if !bytes.Contains(data, payload) {
+			t.Errorf("payload not found in the packet: %x", data)
+		}
+		packets++
+	}
+	if packets != 2 {
+		t.Errorf("two packets expected, got %d", packets)
+	}
+}
@@ -0,0 +1,269 @@
+package loggers
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisPub struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	exit       chan bool
+	textFormat []string
+	name       string
+	client     *redis.Client
+}
+
+func NewRedisPub(config *dnsutils.Config, logger *logger.Logger, name string) *RedisPub {
+	logger.Info("[%s] logger to redis - enabled", name)
+	s := &RedisPub{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		exit:       make(chan bool),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		config:     config,
+		name:       name,
+	}
+
+	s.ReadConfig()
+
+	return s
+}
+
+func (c *RedisPub) GetName() string { return c.name }
+
+func (c *RedisPub) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *RedisPub) ReadConfig() {
+	if !dnsutils.IsValidTLS(o.config.Loggers.RedisPub.TlsMinVersion) {
+		o.logger.Fatal("logger redis - invalid tls min version")
+	}
+
+	if len(o.config.Loggers.RedisPub.RedisChannel) == 0 {
+		o.logger.Fatal("logger redis - redis-channel is required")
+	}
+
+	switch o.config.Loggers.RedisPub.Mode {
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON:
+	default:
+		o.logger.Fatal("logger redis - invalid mode: ", o.config.Loggers.RedisPub.Mode)
+	}
+
+	switch o.config.Loggers.RedisPub.RedisMode {
+	case dnsutils.REDIS_MODE_PUBSUB, dnsutils.REDIS_MODE_STREAM:
+	default:
+		o.logger.Fatal("logger redis - invalid redis-mode: ", o.config.Loggers.RedisPub.RedisMode)
+	}
+
+	if len(o.config.Loggers.RedisPub.TextFormat) > 0 {
+		o.textFormat = strings.Fields(o.config.Loggers.RedisPub.TextFormat)
+	} else {
+		o.textFormat = strings.Fields(o.config.Global.TextFormat)
+	}
+
+	o.client = redis.NewClient(o.NewOptions())
+}
+
+func (o *RedisPub) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+// NewOptions returns the options of the redis client, the connections are
+// managed by the pool of the client which reconnects on error
+func (o *RedisPub) NewOptions() *redis.Options {
+	cfg := o.config.Loggers.RedisPub
+	timeout := time.Duration(cfg.ConnectTimeout) * time.Second
+
+	opts := &redis.Options{
+		Network:         dnsutils.SOCKET_TCP,
+		Addr:            net.JoinHostPort(cfg.RemoteAddress, strconv.Itoa(cfg.RemotePort)),
+		Username:        cfg.Username,
+		Password:        cfg.Password,
+		DB:              cfg.Db,
+		DialTimeout:     timeout,
+		ReadTimeout:     timeout,
+		WriteTimeout:    timeout,
+		MaxRetries:      3,
+		MinRetryBackoff: 500 * time.Millisecond,
+		MaxRetryBackoff: time.Duration(cfg.RetryInterval) * time.Second,
+		PoolSize:        1,
+	}
+
+	if len(cfg.SockPath) > 0 {
+		opts.Network = "unix"
+		opts.Addr = cfg.SockPath
+	}
+
+	if cfg.TlsSupport {
+		opts.TLSConfig = &tls.Config{
+			MinVersion:         dnsutils.TLS_VERSION[cfg.TlsMinVersion],
+			InsecureSkipVerify: cfg.TlsInsecure,
+		}
+	}
+	return opts
+}
+
+func (o *RedisPub) LogInfo(msg string, v ...interface{}) {
+	o.logger.Info("["+o.name+"] logger to redis - "+msg, v...)
+}
+
+func (o *RedisPub) LogError(msg string, v ...interface{}) {
+	o.logger.Error("["+o.name+"] logger to redis - "+msg, v...)
+}
+
+func (o *RedisPub) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *RedisPub) Stop() {
+	o.LogInfo("stopping...")
+
+	// exit to close properly
+	o.exit <- true
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+func (o *RedisPub) Encode(dm *dnsutils.DnsMessage) ([]byte, error) {
+	switch o.config.Loggers.RedisPub.Mode {
+	case dnsutils.MODE_TEXT:
+		return dm.Bytes(o.textFormat,
+			o.config.Global.TextFormatDelimiter,
+			o.config.Global.TextFormatBoundary), nil
+	case dnsutils.MODE_JSON:
+		return json.Marshal(dm)
+	default:
+		flat, err := dm.Flatten()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(flat)
+	}
+}
+
+// FlushBuffer sends the buffer in one pipeline, with PUBLISH or XADD commands
+func (o *RedisPub) FlushBuffer(buf *[]dnsutils.DnsMessage) {
+	cfg := o.config.Loggers.RedisPub
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ConnectTimeout)*time.Second)
+	defer cancel()
+
+	pipe := o.client.Pipeline()
+	for i := range *buf {
+		payload, err := o.Encode(&(*buf)[i])
+		if err != nil {
+			o.LogError("encoding DNS message failed: %s", err)
+			continue
+		}
+
+		switch cfg.RedisMode {
+		case dnsutils.REDIS_MODE_PUBSUB:
+			pipe.Publish(ctx, cfg.RedisChannel, payload)
+		case dnsutils.REDIS_MODE_STREAM:
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: cfg.RedisChannel,
+				MaxLen: int64(cfg.StreamMaxLen),
+				Approx: cfg.StreamMaxLen > 0,
+				Values: []interface{}{"message", payload},
+			})
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		o.LogError("unable to send %d messages: %s", len(*buf), err)
+	}
+
+	// reset buffer
+	*buf = nil
+}
+
+func (o *RedisPub) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// init buffer
+	bufferDm := []dnsutils.DnsMessage{}
+
+	// init flust timer for buffer
+	flushInterval := time.Duration(o.config.Loggers.RedisPub.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case <-o.exit:
+			o.logger.Info("closing loop...")
+			break LOOP
+
+		case dm := <-o.channel:
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// append dns message to buffer
+			bufferDm = append(bufferDm, dm)
+
+			// buffer is full ?
+			if len(bufferDm) >= o.config.Loggers.RedisPub.BufferSize {
+				o.FlushBuffer(&bufferDm)
+			}
+
+		// flush the buffer
+		case <-flushTimer.C:
+			if len(bufferDm) > 0 {
+				o.FlushBuffer(&bufferDm)
+			}
+
+			// restart timer
+			flushTimer.Reset(flushInterval)
+		}
+	}
+
+	// the messages received before the stop are sent
+	dnsutils.DrainChannel(o.channel, func(dm dnsutils.DnsMessage) {
+		if subprocessors.ProcessMessage(&dm) != transformers.RETURN_DROP {
+			bufferDm = append(bufferDm, dm)
+		}
+	})
+	if len(bufferDm) > 0 {
+		o.FlushBuffer(&bufferDm)
+	}
+
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// closing the connections
+	if err := o.client.Close(); err != nil {
+		o.LogError("closing client: %s", err)
+	}
+
+	o.done <- true
+}
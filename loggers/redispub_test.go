@@ -0,0 +1,114 @@
+package loggers
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// readRespCommand reads one command sent by the client, an array of bulk strings
+func readRespCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+	args := []string{}
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return args, nil
+}
+
+func Test_RedisPubRun(t *testing.T) {
+	testcases := []struct {
+		redisMode string
+		command   string
+		reply     string
+		pattern   string
+	}{
+		{
+			redisMode: dnsutils.REDIS_MODE_PUBSUB,
+			command:   "publish",
+			reply:     ":1\r\n",
+			pattern:   "\"dns.qname\":\"dns.collector\"",
+		},
+		{
+			redisMode: dnsutils.REDIS_MODE_STREAM,
+			command:   "xadd",
+			reply:     "$15\r\n1526919030474-0\r\n",
+			pattern:   "\"dns.qname\":\"dns.collector\"",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.redisMode, func(t *testing.T) {
+			// init logger
+			cfg := dnsutils.GetFakeConfig()
+			cfg.Loggers.RedisPub.RemotePort = 6380
+			cfg.Loggers.RedisPub.BufferSize = 0
+			cfg.Loggers.RedisPub.RedisMode = tc.redisMode
+			g := NewRedisPub(cfg, logger.New(false), "test")
+
+			// fake redis server
+			fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, ":6380")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fakeRcvr.Close()
+
+			// start the logger and send fake dns message
+			go g.Run()
+			g.channel <- dnsutils.GetFakeDnsMessage()
+
+			// accept conn from logger
+			conn, err := fakeRcvr.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+
+			// HELLO is not supported, fallback to RESP2
+			args, err := readRespCommand(reader)
+			if err != nil || strings.ToLower(args[0]) != "hello" {
+				t.Fatalf("hello command expected: %v %v", args, err)
+			}
+			conn.Write([]byte("-ERR unknown command 'HELLO'\r\n"))
+
+			// read the command with the dns message
+			args, err = readRespCommand(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Write([]byte(tc.reply))
+
+			if strings.ToLower(args[0]) != tc.command || args[1] != cfg.Loggers.RedisPub.RedisChannel {
+				t.Errorf("invalid command, want %s %s, got: %v", tc.command, cfg.Loggers.RedisPub.RedisChannel, args)
+			}
+			pattern := regexp.MustCompile(tc.pattern)
+			if !pattern.MatchString(args[len(args)-1]) {
+				t.Errorf("redis error want %s, got: %s", tc.pattern, args[len(args)-1])
+			}
+
+			g.Stop()
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package loggers
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+// ReplayResult is the new response of a replayed query, written in the results file
+type ReplayResult struct {
+	Timestamp       string  `json:"timestamp"`
+	Qname           string  `json:"qname"`
+	Qtype           string  `json:"qtype"`
+	Rcode           string  `json:"rcode"`
+	Answers         int     `json:"answers"`
+	Latency         float64 `json:"latency"`
+	OriginalRcode   string  `json:"original-rcode,omitempty"`
+	OriginalLatency float64 `json:"original-latency,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// ReplayStats counts the replayed queries
+type ReplayStats struct {
+	Sent     int
+	Answered int
+	Errors   int
+	Changed  int
+}
+
+// Replay sends again the queries of the messages to a resolver at the original rate or
+// at a scaled rate, the new responses are written in a results file
+type Replay struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	results    chan ReplayResult
+	resultsEnd chan bool
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	inflight   chan bool
+	queries    sync.WaitGroup
+	stats      ReplayStats
+	firstTime  time.Time
+	startTime  time.Time
+}
+
+func NewReplay(config *dnsutils.Config, logger *logger.Logger, name string) *Replay {
+	logger.Info("[%s] logger replay - enabled", name)
+	o := &Replay{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		results:    make(chan ReplayResult, 512),
+		resultsEnd: make(chan bool),
+		config:     config,
+		logger:     logger,
+		name:       name,
+	}
+	o.ReadConfig()
+	return o
+}
+
+func (c *Replay) GetName() string { return c.name }
+
+func (c *Replay) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *Replay) ReadConfig() {
+	cfg := o.config.Loggers.Replay
+	if len(cfg.Target) == 0 {
+		o.logger.Fatal("logger replay - target is required")
+	}
+	if cfg.Protocol != dnsutils.SOCKET_UDP && cfg.Protocol != dnsutils.SOCKET_TCP {
+		o.logger.Fatal("logger replay - invalid protocol: ", cfg.Protocol)
+	}
+	if cfg.Speed < 0 {
+		o.logger.Fatal("logger replay - speed must be positive or zero")
+	}
+	if cfg.MaxInflight <= 0 {
+		o.logger.Fatal("logger replay - max-inflight must be positive")
+	}
+	o.inflight = make(chan bool, cfg.MaxInflight)
+}
+
+func (o *Replay) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (c *Replay) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] logger replay - "+msg, v...)
+}
+
+func (c *Replay) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] logger replay - "+msg, v...)
+}
+
+func (o *Replay) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *Replay) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// Stats returns the counters of the replayed queries
+func (o *Replay) Stats() ReplayStats {
+	return o.stats
+}
+
+// Query returns the query to send again, the original payload is used if available
+// to keep the flags and the edns options
+func (o *Replay) Query(dm *dnsutils.DnsMessage) *dns.Msg {
+	msg := new(dns.Msg)
+	if len(dm.DNS.Payload) > 0 {
+		if err := msg.Unpack(dm.DNS.Payload); err == nil && len(msg.Question) > 0 {
+			// the replies are replayed as queries, only the edns record is kept
+			msg.Response = false
+			msg.Rcode = dns.RcodeSuccess
+			msg.Answer, msg.Ns = nil, nil
+			extra := []dns.RR{}
+			for _, rr := range msg.Extra {
+				if opt, ok := rr.(*dns.OPT); ok {
+					extra = append(extra, opt)
+				}
+			}
+			msg.Extra = extra
+			return msg
+		}
+	}
+	qtype, ok := dns.StringToType[dm.DNS.Qtype]
+	if !ok {
+		qtype = dns.TypeA
+	}
+	msg.SetQuestion(dns.Fqdn(dm.DNS.Qname), qtype)
+	return msg
+}
+
+// Wait sleeps until the time of the message on the replay clock, the original
+// intervals are divided by the speed and there is no wait if the speed is zero
+func (o *Replay) Wait(dm *dnsutils.DnsMessage) {
+	speed := o.config.Loggers.Replay.Speed
+	if speed == 0 || dm.DnsTap.TimeSec == 0 {
+		return
+	}
+
+	t := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+	if o.firstTime.IsZero() {
+		o.firstTime, o.startTime = t, time.Now()
+		return
+	}
+
+	at := o.startTime.Add(time.Duration(float64(t.Sub(o.firstTime)) / speed))
+	if wait := time.Until(at); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Send replays the query of the message in background, the number of queries
+// waiting for a response is limited
+func (o *Replay) Send(dm dnsutils.DnsMessage) {
+	query := o.Query(&dm)
+
+	o.inflight <- true
+	o.queries.Add(1)
+	go func() {
+		defer func() {
+			<-o.inflight
+			o.queries.Done()
+		}()
+
+		client := &dns.Client{
+			Net:     o.config.Loggers.Replay.Protocol,
+			Timeout: time.Duration(o.config.Loggers.Replay.Timeout) * time.Second,
+		}
+		result := ReplayResult{
+			Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+			Qname:     strings.TrimSuffix(query.Question[0].Name, "."),
+			Qtype:     dns.TypeToString[query.Question[0].Qtype],
+		}
+		if dm.DNS.Type == dnsutils.DnsReply {
+			result.OriginalRcode = dm.DNS.Rcode
+			result.OriginalLatency = dm.DnsTap.Latency
+		}
+
+		reply, rtt, err := client.Exchange(query, o.config.Loggers.Replay.Target)
+		result.Latency = rtt.Seconds()
+		if err != nil {
+			result.Rcode = dnsutils.DNS_RCODE_TIMEOUT
+			result.Error = err.Error()
+		} else {
+			result.Rcode = dns.RcodeToString[reply.Rcode]
+			result.Answers = len(reply.Answer)
+		}
+		o.results <- result
+	}()
+}
+
+// WriteResults counts the results and writes them in the results file if enabled
+func (o *Replay) WriteResults() {
+	var fd *os.File
+	var writer *bufio.Writer
+	if path := o.config.Loggers.Replay.ResultsFile; len(path) > 0 {
+		var err error
+		if fd, err = os.Create(path); err != nil {
+			o.LogError("unable to create the results file: %v", err)
+		} else {
+			writer = bufio.NewWriter(fd)
+		}
+	}
+
+	for result := range o.results {
+		o.stats.Sent++
+		if len(result.Error) > 0 {
+			o.stats.Errors++
+		} else {
+			o.stats.Answered++
+		}
+		if len(result.OriginalRcode) > 0 && result.OriginalRcode != result.Rcode {
+			o.stats.Changed++
+		}
+
+		if writer == nil {
+			continue
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			o.LogError("encoding result failed: %v", err)
+			continue
+		}
+		writer.Write(data)
+		writer.WriteByte('\n')
+	}
+
+	if writer != nil {
+		if err := writer.Flush(); err != nil {
+			o.LogError("unable to write the results file: %v", err)
+		}
+		fd.Close()
+	}
+	o.resultsEnd <- true
+}
+
+func (o *Replay) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	go o.WriteResults()
+
+	replayType := dnsutils.DnsQuery
+	if o.config.Loggers.Replay.ReplayReplies {
+		replayType = dnsutils.DnsReply
+	}
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// only the queries are replayed, or the replies to compare the rcodes
+			if dm.DNS.Type != replayType || len(dm.DNS.Qname) == 0 || dm.DNS.Qname == "-" {
+				continue
+			}
+			o.Wait(&dm)
+			o.Send(dm)
+		}
+	}
+
+	// the queries in progress are completed before to stop
+	o.queries.Wait()
+	close(o.results)
+	<-o.resultsEnd
+	o.LogInfo("%d queries replayed, %d answered, %d errors, %d rcodes changed",
+		o.stats.Sent, o.stats.Answered, o.stats.Errors, o.stats.Changed)
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
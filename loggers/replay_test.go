@@ -0,0 +1,107 @@
+package loggers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func Test_ReplayReplies(t *testing.T) {
+	// fake resolver, NXDOMAIN for all names
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Replay.Target = pc.LocalAddr().String()
+	cfg.Loggers.Replay.Speed = 4
+	cfg.Loggers.Replay.ReplayReplies = true
+	cfg.Loggers.Replay.ResultsFile = filepath.Join(t.TempDir(), "results.json")
+
+	g := NewReplay(cfg, logger.New(false), "test")
+	go g.Run()
+
+	// two replies one second apart, the query is ignored
+	start := time.Now()
+	for i, qtype := range []string{"A", "AAAA"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Type = dnsutils.DnsReply
+		dm.DNS.Qtype = qtype
+		dm.DNS.Rcode = dnsutils.DNS_RCODE_NOERROR
+		dm.DnsTap.TimeSec = 1700000000 + i
+		g.Channel() <- dm
+	}
+	g.Channel() <- dnsutils.GetFakeDnsMessage()
+	g.Stop()
+
+	// the original rate is divided by the speed
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("replay too fast: %v", elapsed)
+	}
+	if stats := g.Stats(); stats.Sent != 2 || stats.Answered != 2 || stats.Changed != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	fd, err := os.Open(cfg.Loggers.Replay.ResultsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	results := []ReplayResult{}
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		result := ReplayResult{}
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	if len(results) != 2 {
+		t.Fatalf("two results expected, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.Qname != "dns.collector" || result.Rcode != "NXDOMAIN" || result.OriginalRcode != "NOERROR" {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	}
+}
+
+func Test_ReplayQuery(t *testing.T) {
+	g := &Replay{}
+
+	// the query is built from the qname and qtype without payload
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qtype = "MX"
+	query := g.Query(&dm)
+	if query.Question[0].Name != "dns.collector." || query.Question[0].Qtype != dns.TypeMX {
+		t.Errorf("invalid query: %v", query.Question)
+	}
+
+	// the reply is replayed as a query with the edns record
+	reply := new(dns.Msg)
+	reply.SetQuestion("dns.collector.", dns.TypeTXT)
+	reply.SetEdns0(1232, true)
+	reply.Response = true
+	reply.Answer = append(reply.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: "dns.collector.", Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{"x"}})
+	dm.DNS.Payload, _ = reply.Pack()
+	query = g.Query(&dm)
+	if query.Response || len(query.Answer) != 0 || query.IsEdns0() == nil || query.Question[0].Qtype != dns.TypeTXT {
+		t.Errorf("invalid query: %v", query)
+	}
+}
@@ -0,0 +1,494 @@
+package loggers
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"math/rand"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+const (
+	// maximum number of latencies kept to compute the percentiles
+	reporterMaxLatencies = 100000
+	// max length of the base64 lines of the attachments (RFC 2045)
+	reporterBase64LineLen = 76
+)
+
+var reportHtmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>DNS report {{.Start}} - {{.End}}</title></head>
+<body>
+<h1>DNS report</h1>
+<p>From {{.Start}} to {{.End}}</p>
+<h2>Summary</h2>
+<table>
+<tr><td>Queries</td><td>{{.Queries}}</td></tr>
+<tr><td>Replies</td><td>{{.Replies}}</td></tr>
+<tr><td>Clients</td><td>{{.Clients}}</td></tr>
+<tr><td>Domains</td><td>{{.Domains}}</td></tr>
+</table>
+<h2>Top domains</h2>
+<table>{{range .TopDomains}}<tr><td>{{.Name}}</td><td>{{.Hit}}</td></tr>{{end}}</table>
+<h2>Top clients</h2>
+<table>{{range .TopClients}}<tr><td>{{.Name}}</td><td>{{.Hit}}</td></tr>{{end}}</table>
+<h2>Errors per hour</h2>
+<table>{{range .Errors}}<tr><td>{{.Hour}}</td><td>{{.Rcode}}</td><td>{{.Hit}}</td></tr>{{end}}</table>
+<h2>Latency percentiles (seconds)</h2>
+<table>{{range .Latencies}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>{{end}}</table>
+<h2>New domains</h2>
+<table>{{range .NewDomains}}<tr><td>{{.}}</td></tr>{{end}}</table>
+</body>
+</html>
+`))
+
+type ReportError struct {
+	Hour  string
+	Rcode string
+	Hit   int
+}
+
+type ReportLatency struct {
+	Name  string
+	Value string
+}
+
+type Report struct {
+	Start      string
+	End        string
+	Queries    int
+	Replies    int
+	Clients    int
+	Domains    int
+	TopDomains []dnsutils.TopKItem
+	TopClients []dnsutils.TopKItem
+	Errors     []ReportError
+	Latencies  []ReportLatency
+	NewDomains []string
+}
+
+// Reporter counts the clients and the domains of the period with the bounded tops of the
+// statistics transformer, the known domains are kept in a lru list of a limited size
+type Reporter struct {
+	done         chan bool
+	configChan   chan *dnsutils.Config
+	channel      chan dnsutils.DnsMessage
+	config       *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	periodStart  time.Time
+	queries      int
+	replies      int
+	clients      *dnsutils.TopK
+	domains      *dnsutils.TopK
+	errors       map[string]map[string]int
+	latencies    []float64
+	latencyCount int
+	knownDomains map[string]*list.Element
+	knownLru     *list.List
+	newDomains   []string
+}
+
+func NewReporter(config *dnsutils.Config, logger *logger.Logger, name string) *Reporter {
+	logger.Info("[%s] logger reporter - enabled", name)
+	o := &Reporter{
+		done:         make(chan bool),
+		configChan:   make(chan *dnsutils.Config, 1),
+		channel:      make(chan dnsutils.DnsMessage, 512),
+		logger:       logger,
+		config:       config,
+		name:         name,
+		knownDomains: make(map[string]*list.Element),
+		knownLru:     list.New(),
+	}
+	o.ReadConfig()
+	o.ResetStats(time.Now())
+	return o
+}
+
+func (c *Reporter) GetName() string { return c.name }
+
+func (c *Reporter) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *Reporter) ReadConfig() {
+	if len(o.config.Loggers.Reporter.ReportDir) == 0 {
+		o.logger.Fatal("logger reporter - report directory is required")
+	}
+
+	switch o.config.Loggers.Reporter.Period {
+	case dnsutils.REPORT_DAILY, dnsutils.REPORT_WEEKLY:
+	default:
+		o.logger.Fatal("logger reporter - invalid period: ", o.config.Loggers.Reporter.Period)
+	}
+
+	if o.config.Loggers.Reporter.Capacity <= 0 || o.config.Loggers.Reporter.KnownDomains <= 0 {
+		o.logger.Fatal("logger reporter - capacity and known domains must be positive")
+	}
+
+	for _, format := range o.config.Loggers.Reporter.Formats {
+		if format != dnsutils.MODE_HTML && format != dnsutils.MODE_CSV {
+			o.logger.Fatal("logger reporter - invalid format: ", format)
+		}
+	}
+}
+
+func (o *Reporter) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (o *Reporter) LogInfo(msg string, v ...interface{}) {
+	o.logger.Info("["+o.name+"] logger reporter - "+msg, v...)
+}
+
+func (o *Reporter) LogError(msg string, v ...interface{}) {
+	o.logger.Error("["+o.name+"] logger reporter - "+msg, v...)
+}
+
+func (o *Reporter) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *Reporter) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// NextReportTime returns the next midnight for daily reports or the next monday for weekly reports
+func (o *Reporter) NextReportTime(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+	if o.config.Loggers.Reporter.Period == dnsutils.REPORT_WEEKLY {
+		for next.Weekday() != time.Monday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+func (o *Reporter) ResetStats(now time.Time) {
+	o.periodStart = now
+	o.queries = 0
+	o.replies = 0
+	o.clients = dnsutils.NewTopK(o.config.Loggers.Reporter.Capacity)
+	o.domains = dnsutils.NewTopK(o.config.Loggers.Reporter.Capacity)
+	o.errors = make(map[string]map[string]int)
+	o.latencies = []float64{}
+	o.latencyCount = 0
+	o.newDomains = []string{}
+}
+
+func (o *Reporter) Record(dm *dnsutils.DnsMessage) {
+	if dm.DNS.Type == dnsutils.DnsQuery {
+		o.queries++
+	} else {
+		o.replies++
+	}
+
+	o.clients.Add(dm.NetworkInfo.QueryIp, 1)
+	o.domains.Add(dm.DNS.Qname, 1)
+
+	// domains never seen in the previous periods
+	o.RecordDomain(dm.DNS.Qname)
+
+	// errors per hour
+	if dm.DNS.Type == dnsutils.DnsReply && dm.DNS.Rcode != "NOERROR" {
+		ts := time.Unix(int64(dm.DnsTap.TimeSec), 0).UTC().Format("2006-01-02 15:00")
+		if _, ok := o.errors[ts]; !ok {
+			o.errors[ts] = make(map[string]int)
+		}
+		o.errors[ts][dm.DNS.Rcode]++
+	}
+
+	// keep a uniform sample of latencies (reservoir sampling)
+	if dm.DnsTap.Latency > 0 {
+		o.latencyCount++
+		if len(o.latencies) < reporterMaxLatencies {
+			o.latencies = append(o.latencies, dm.DnsTap.Latency)
+		} else if i := rand.Intn(o.latencyCount); i < reporterMaxLatencies {
+			o.latencies[i] = dm.DnsTap.Latency
+		}
+	}
+}
+
+// RecordDomain adds the domain to the known domains and to the new domains of the period if
+// not known, the domains seen least recently are forgotten when the list is full
+func (o *Reporter) RecordDomain(qname string) {
+	if elem, known := o.knownDomains[qname]; known {
+		o.knownLru.MoveToFront(elem)
+		return
+	}
+
+	if o.knownLru.Len() >= o.config.Loggers.Reporter.KnownDomains {
+		oldest := o.knownLru.Back()
+		o.knownLru.Remove(oldest)
+		delete(o.knownDomains, oldest.Value.(string))
+	}
+	o.knownDomains[qname] = o.knownLru.PushFront(qname)
+
+	if len(o.newDomains) < o.config.Loggers.Reporter.Capacity {
+		o.newDomains = append(o.newDomains, qname)
+	}
+}
+
+func (o *Reporter) GetReport(end time.Time) Report {
+	report := Report{
+		Start:      o.periodStart.UTC().Format(time.RFC3339),
+		End:        end.UTC().Format(time.RFC3339),
+		Queries:    o.queries,
+		Replies:    o.replies,
+		Clients:    o.clients.Len(),
+		Domains:    o.domains.Len(),
+		TopDomains: o.domains.Top(o.config.Loggers.Reporter.TopN),
+		TopClients: o.clients.Top(o.config.Loggers.Reporter.TopN),
+		NewDomains: o.newDomains,
+	}
+
+	hours := make([]string, 0, len(o.errors))
+	for hour := range o.errors {
+		hours = append(hours, hour)
+	}
+	sort.Strings(hours)
+	for _, hour := range hours {
+		rcodes := make([]string, 0, len(o.errors[hour]))
+		for rcode := range o.errors[hour] {
+			rcodes = append(rcodes, rcode)
+		}
+		sort.Strings(rcodes)
+		for _, rcode := range rcodes {
+			report.Errors = append(report.Errors, ReportError{Hour: hour, Rcode: rcode, Hit: o.errors[hour][rcode]})
+		}
+	}
+
+	if len(o.latencies) > 0 {
+		sorted := append([]float64{}, o.latencies...)
+		sort.Float64s(sorted)
+		for _, p := range []int{50, 90, 99} {
+			idx := (len(sorted)*p+99)/100 - 1
+			report.Latencies = append(report.Latencies, ReportLatency{
+				Name:  fmt.Sprintf("p%d", p),
+				Value: fmt.Sprintf("%.6f", sorted[idx]),
+			})
+		}
+	}
+	return report
+}
+
+func (o *Reporter) WriteCsv(report Report, filePath string) error {
+	fd, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	w := csv.NewWriter(fd)
+	w.Write([]string{"section", "name", "value"})
+	w.Write([]string{"summary", "start", report.Start})
+	w.Write([]string{"summary", "end", report.End})
+	w.Write([]string{"summary", "queries", strconv.Itoa(report.Queries)})
+	w.Write([]string{"summary", "replies", strconv.Itoa(report.Replies)})
+	w.Write([]string{"summary", "clients", strconv.Itoa(report.Clients)})
+	w.Write([]string{"summary", "domains", strconv.Itoa(report.Domains)})
+	for _, v := range report.TopDomains {
+		w.Write([]string{"top-domains", v.Name, strconv.Itoa(v.Hit)})
+	}
+	for _, v := range report.TopClients {
+		w.Write([]string{"top-clients", v.Name, strconv.Itoa(v.Hit)})
+	}
+	for _, v := range report.Errors {
+		w.Write([]string{"errors", v.Hour + " " + v.Rcode, strconv.Itoa(v.Hit)})
+	}
+	for _, v := range report.Latencies {
+		w.Write([]string{"latency", v.Name, v.Value})
+	}
+	for _, v := range report.NewDomains {
+		w.Write([]string{"new-domains", v, "1"})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func (o *Reporter) WriteHtml(report Report, filePath string) error {
+	fd, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return reportHtmlTemplate.Execute(fd, report)
+}
+
+// WriteReport renders the current period in all formats and returns the list of files
+func (o *Reporter) WriteReport(end time.Time) ([]string, error) {
+	report := o.GetReport(end)
+	files := []string{}
+
+	prefix := filepath.Join(o.config.Loggers.Reporter.ReportDir,
+		fmt.Sprintf("report-%s-%s", o.config.Loggers.Reporter.Period, o.periodStart.UTC().Format("20060102-150405")))
+	for _, format := range o.config.Loggers.Reporter.Formats {
+		filePath := prefix + "." + format
+		var err error
+		switch format {
+		case dnsutils.MODE_HTML:
+			err = o.WriteHtml(report, filePath)
+		case dnsutils.MODE_CSV:
+			err = o.WriteCsv(report, filePath)
+		}
+		if err != nil {
+			return files, err
+		}
+		files = append(files, filePath)
+	}
+	return files, nil
+}
+
+// BuildMail creates a multipart message with all reports as attachments
+func (o *Reporter) BuildMail(subject string, files []string) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + o.config.Loggers.Reporter.MailFrom + "\r\n")
+	msg.WriteString("To: " + strings.Join(o.config.Loggers.Reporter.MailTo, ", ") + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: multipart/mixed; boundary=" + w.Boundary() + "\r\n\r\n")
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(subject + "\r\n"))
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/octet-stream"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", filepath.Base(file))},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(data)
+		for len(encoded) > reporterBase64LineLen {
+			part.Write([]byte(encoded[:reporterBase64LineLen] + "\r\n"))
+			encoded = encoded[reporterBase64LineLen:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	w.Close()
+
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func (o *Reporter) SendMail(files []string) error {
+	subject := fmt.Sprintf("DNS %s report - %s", o.config.Loggers.Reporter.Period, o.periodStart.Format("2006-01-02"))
+	msg, err := o.BuildMail(subject, files)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if len(o.config.Loggers.Reporter.SmtpLogin) > 0 {
+		host := strings.Split(o.config.Loggers.Reporter.SmtpServer, ":")[0]
+		auth = smtp.PlainAuth("", o.config.Loggers.Reporter.SmtpLogin, o.config.Loggers.Reporter.SmtpPwd, host)
+	}
+	return smtp.SendMail(o.config.Loggers.Reporter.SmtpServer, auth, o.config.Loggers.Reporter.MailFrom,
+		o.config.Loggers.Reporter.MailTo, msg)
+}
+
+func (o *Reporter) GenerateReport(now time.Time) {
+	files, err := o.WriteReport(now)
+	if err != nil {
+		o.LogError("unable to write report: %s", err)
+	} else {
+		o.LogInfo("report written: %s", strings.Join(files, ", "))
+	}
+
+	if len(files) > 0 && len(o.config.Loggers.Reporter.SmtpServer) > 0 && len(o.config.Loggers.Reporter.MailTo) > 0 {
+		if err := o.SendMail(files); err != nil {
+			o.LogError("unable to send report by mail: %s", err)
+		}
+	}
+
+	o.ResetStats(now)
+}
+
+func (o *Reporter) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// schedule the first report
+	reportTimer := time.NewTimer(time.Until(o.NextReportTime(time.Now())))
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			o.Record(&dm)
+
+		case <-reportTimer.C:
+			now := time.Now()
+			o.GenerateReport(now)
+			reportTimer.Reset(time.Until(o.NextReportTime(now)))
+		}
+	}
+
+	reportTimer.Stop()
+
+	// the report of the current period is generated on shutdown
+	if o.queries+o.replies > 0 {
+		o.GenerateReport(time.Now())
+	}
+
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
@@ -0,0 +1,142 @@
+package loggers
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_ReporterNextReportTime(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Reporter.ReportDir = t.TempDir()
+
+	// wednesday
+	now := time.Date(2023, 4, 12, 15, 30, 0, 0, time.UTC)
+
+	g := NewReporter(cfg, logger.New(false), "test")
+	if next := g.NextReportTime(now); !next.Equal(time.Date(2023, 4, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("invalid next daily report: %s", next)
+	}
+
+	cfg.Loggers.Reporter.Period = dnsutils.REPORT_WEEKLY
+	if next := g.NextReportTime(now); !next.Equal(time.Date(2023, 4, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("invalid next weekly report: %s", next)
+	}
+}
+
+func Test_ReporterWriteReport(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Reporter.ReportDir = t.TempDir()
+
+	g := NewReporter(cfg, logger.New(false), "test")
+
+	// one query, one nxdomain reply with latency
+	dm := dnsutils.GetFakeDnsMessage()
+	g.Record(&dm)
+
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.DNS.Type = dnsutils.DnsReply
+	dm.DNS.Rcode = dnsutils.DNS_RCODE_NXDOMAIN
+	dm.DnsTap.Latency = 0.5
+	g.Record(&dm)
+
+	files, err := g.WriteReport(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("two reports expected, got %d", len(files))
+	}
+
+	patterns := map[string][]string{
+		dnsutils.MODE_CSV: {
+			"summary,queries,1",
+			"top-domains,dns.collector,2",
+			"errors,1970-01-01 00:00 NXDOMAIN,1",
+			"latency,p50,0.500000",
+			"new-domains,dns.collector,1",
+		},
+		dnsutils.MODE_HTML: {
+			"<td>Replies</td><td>1</td>",
+			"<td>1.2.3.4</td><td>2</td>",
+		},
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ext := file[strings.LastIndex(file, ".")+1:]
+		for _, pattern := range patterns[ext] {
+			if !strings.Contains(string(data), pattern) {
+				t.Errorf("%s report should contains %q, got: %s", ext, pattern, string(data))
+			}
+		}
+	}
+
+	// a known domain is not new in the next period
+	g.ResetStats(time.Now())
+	g.Record(&dm)
+	if len(g.GetReport(time.Now()).NewDomains) != 0 {
+		t.Errorf("domain should not be new in the next period")
+	}
+
+	// reports are attached to the mail
+	cfg.Loggers.Reporter.MailTo = []string{"noc@collector"}
+	mail, err := g.BuildMail("report", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(mail), "To: noc@collector") || strings.Count(string(mail), "Content-Disposition: attachment") != 2 {
+		t.Errorf("invalid mail: %s", string(mail))
+	}
+	// the headers are not concerned, the base64 alphabet has no colon
+	for _, line := range strings.Split(string(mail), "\r\n") {
+		if len(line) > 76 && !strings.Contains(line, ":") {
+			t.Errorf("mail line longer than 76 characters: %s", line)
+		}
+	}
+}
+
+func Test_ReporterKnownDomainsBounded(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Reporter.ReportDir = t.TempDir()
+	cfg.Loggers.Reporter.KnownDomains = 2
+
+	g := NewReporter(cfg, logger.New(false), "test")
+	for _, qname := range []string{"a.collector", "b.collector", "a.collector", "c.collector"} {
+		g.RecordDomain(qname)
+	}
+
+	// b is the least recently seen domain
+	if len(g.knownDomains) != 2 || g.knownDomains["b.collector"] != nil || g.knownDomains["a.collector"] == nil {
+		t.Errorf("invalid known domains: %v", g.knownDomains)
+	}
+	if len(g.newDomains) != 3 {
+		t.Errorf("3 new domains expected, got %v", g.newDomains)
+	}
+}
+
+func Test_ReporterReportOnStop(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Reporter.ReportDir = t.TempDir()
+
+	g := NewReporter(cfg, logger.New(false), "test")
+	go g.Run()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	g.Channel() <- dm
+	g.Stop()
+
+	files, err := os.ReadDir(cfg.Loggers.Reporter.ReportDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("the reports should be written on stop, got %d files", len(files))
+	}
+}
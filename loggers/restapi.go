@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/transformers"
@@ -37,8 +39,86 @@ type HitsUniq struct {
 	Suspicious     map[string]*dnsutils.Suspicious
 }
 
+// QpsCounter counts the messages per second over a sliding window,
+// with one more slot for the current second
+type QpsCounter struct {
+	counts []int
+	last   int64
+}
+
+func NewQpsCounter(window int) *QpsCounter {
+	if window < 1 {
+		window = 1
+	}
+	return &QpsCounter{counts: make([]int, window+1)}
+}
+
+// advance clears the slots of the seconds elapsed since the last update
+func (c *QpsCounter) advance(now time.Time) {
+	sec := now.Unix()
+	window := int64(len(c.counts))
+	if sec-c.last >= window {
+		for i := range c.counts {
+			c.counts[i] = 0
+		}
+	} else {
+		for t := c.last + 1; t <= sec; t++ {
+			c.counts[t%window] = 0
+		}
+	}
+	if sec > c.last {
+		c.last = sec
+	}
+}
+
+func (c *QpsCounter) Add(now time.Time) {
+	c.advance(now)
+	c.counts[now.Unix()%int64(len(c.counts))]++
+}
+
+// Series returns the number of messages per second, from the oldest, the current second is excluded
+func (c *QpsCounter) Series(now time.Time) []int {
+	c.advance(now)
+	window := int64(len(c.counts))
+	series := make([]int, 0, window-1)
+	for t := c.last - window + 1; t < c.last; t++ {
+		series = append(series, c.counts[(t%window+window)%window])
+	}
+	return series
+}
+
+// Rate returns the average of messages per second over the window
+func (c *QpsCounter) Rate(now time.Time) float64 {
+	series := c.Series(now)
+	if len(series) == 0 {
+		return 0
+	}
+	total := 0
+	for _, n := range series {
+		total += n
+	}
+	return float64(total) / float64(len(series))
+}
+
+// LatencyBuckets are the upper bounds in seconds of the latency histogram
+var LatencyBuckets = []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1}
+
+type Stats struct {
+	Since      time.Time      `json:"since"`
+	Total      int            `json:"total"`
+	Qps        float64        `json:"qps"`
+	QpsWindow  int            `json:"qps-window"`
+	QpsSeries  []int          `json:"qps-series"`
+	Rcodes     map[string]int `json:"rcodes"`
+	Operations map[string]int `json:"operations"`
+	Families   map[string]int `json:"families"`
+	Buckets    []float64      `json:"latency-buckets"`
+	Latencies  []int          `json:"latencies"`
+}
+
 type RestAPI struct {
 	done       chan bool
+	configChan chan *dnsutils.Config
 	done_api   chan bool
 	httpserver net.Listener
 	httpmux    *http.ServeMux
@@ -58,42 +138,71 @@ type RestAPI struct {
 	TopNonExistent *topmap.TopMap
 	TopServFail    *topmap.TopMap
 
+	Events *EventStore
+	Tails  *TailHub
+
+	Qps        *QpsCounter
+	Rcodes     map[string]int
+	Operations map[string]int
+	Families   map[string]int
+	Latencies  []int
+	Total      int
+	Since      time.Time
+
 	sync.RWMutex
 }
 
 func NewRestAPI(config *dnsutils.Config, logger *logger.Logger, version string, name string) *RestAPI {
 	logger.Info("[%s] restapi - enabled", name)
 	o := &RestAPI{
-		done:     make(chan bool),
-		done_api: make(chan bool),
-		config:   config,
-		channel:  make(chan dnsutils.DnsMessage, 512),
-		logger:   logger,
-		name:     name,
-
-		HitsStream: HitsStream{
-			Streams: make(map[string]SearchBy),
-		},
-		HitsUniq: HitsUniq{
-			Clients:        make(map[string]int),
-			Domains:        make(map[string]int),
-			NxDomains:      make(map[string]int),
-			SfDomains:      make(map[string]int),
-			PublicSuffixes: make(map[string]int),
-			Suspicious:     make(map[string]*dnsutils.Suspicious),
-		},
-
-		Streams: make(map[string]int),
-
-		TopQnames:      topmap.NewTopMap(config.Loggers.RestAPI.TopN),
-		TopClients:     topmap.NewTopMap(config.Loggers.RestAPI.TopN),
-		TopTLDs:        topmap.NewTopMap(config.Loggers.RestAPI.TopN),
-		TopNonExistent: topmap.NewTopMap(config.Loggers.RestAPI.TopN),
-		TopServFail:    topmap.NewTopMap(config.Loggers.RestAPI.TopN),
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		done_api:   make(chan bool),
+		config:     config,
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		name:       name,
+
+		Events: NewEventStore(config.Loggers.RestAPI.EventsMaxSize,
+			time.Duration(config.Loggers.RestAPI.EventsMaxAge)*time.Second),
+
+		Qps:   NewQpsCounter(config.Loggers.RestAPI.QpsWindow),
+		Tails: NewTailHub(),
 	}
+	o.ResetStats()
 	return o
 }
 
+// ResetStats clears the aggregated counters and the top lists,
+// called at the end of each retention window
+func (o *RestAPI) ResetStats() {
+	o.HitsStream = HitsStream{
+		Streams: make(map[string]SearchBy),
+	}
+	o.HitsUniq = HitsUniq{
+		Clients:        make(map[string]int),
+		Domains:        make(map[string]int),
+		NxDomains:      make(map[string]int),
+		SfDomains:      make(map[string]int),
+		PublicSuffixes: make(map[string]int),
+		Suspicious:     make(map[string]*dnsutils.Suspicious),
+	}
+
+	o.Streams = make(map[string]int)
+	o.Rcodes = make(map[string]int)
+	o.Operations = make(map[string]int)
+	o.Families = make(map[string]int)
+	o.Latencies = make([]int, len(LatencyBuckets)+1)
+	o.Total = 0
+	o.Since = time.Now().UTC()
+
+	o.TopQnames = topmap.NewTopMap(o.config.Loggers.RestAPI.TopN)
+	o.TopClients = topmap.NewTopMap(o.config.Loggers.RestAPI.TopN)
+	o.TopTLDs = topmap.NewTopMap(o.config.Loggers.RestAPI.TopN)
+	o.TopNonExistent = topmap.NewTopMap(o.config.Loggers.RestAPI.TopN)
+	o.TopServFail = topmap.NewTopMap(o.config.Loggers.RestAPI.TopN)
+}
+
 func (c *RestAPI) GetName() string { return c.name }
 
 func (c *RestAPI) SetLoggers(loggers []dnsutils.Worker) {}
@@ -104,6 +213,11 @@ func (o *RestAPI) ReadConfig() {
 	}
 }
 
+func (o *RestAPI) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
 func (o *RestAPI) LogInfo(msg string, v ...interface{}) {
 	o.logger.Info("["+o.name+"] rest api - "+msg, v...)
 }
@@ -119,8 +233,9 @@ func (o *RestAPI) Channel() chan dnsutils.DnsMessage {
 func (o *RestAPI) Stop() {
 	o.LogInfo("stopping...")
 
-	// stopping http server
+	// stopping http server and the live tails
 	o.httpserver.Close()
+	o.Tails.Close()
 
 	// close output channel
 	o.LogInfo("closing channel")
@@ -139,12 +254,14 @@ func (o *RestAPI) Stop() {
 
 func (o *RestAPI) BasicAuth(w http.ResponseWriter, r *http.Request) bool {
 	login, password, authOK := r.BasicAuth()
-	if !authOK {
-		return false
+	if authOK && (login == o.config.Loggers.RestAPI.BasicAuthLogin) &&
+		(password == o.config.Loggers.RestAPI.BasicAuthPwd) {
+		return true
 	}
 
-	return (login == o.config.Loggers.RestAPI.BasicAuthLogin) &&
-		(password == o.config.Loggers.RestAPI.BasicAuthPwd)
+	// ask the browser for the credentials
+	w.Header().Set("WWW-Authenticate", `Basic realm="dnscollector"`)
+	return false
 }
 
 func (s *RestAPI) GetTopTLDsHandler(w http.ResponseWriter, r *http.Request) {
@@ -420,6 +537,80 @@ func (s *RestAPI) GetSearchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseEventTime accepts a RFC3339 date or an unix timestamp
+func parseEventTime(value string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (s *RestAPI) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if s.config.Loggers.RestAPI.EventsMaxSize <= 0 {
+			http.Error(w, "{\"error\": \"Events store is disabled\"}", http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := EventFilter{
+			Qname:   query.Get("query_name"),
+			QueryIp: query.Get("query_ip"),
+			Rcode:   query.Get("rcode"),
+		}
+		if err := filter.Prepare(); err != nil {
+			http.Error(w, "{\"error\": \""+err.Error()+"\"}", http.StatusBadRequest)
+			return
+		}
+
+		// time range, the last N seconds can be selected with the since argument
+		if since := query.Get("since"); len(since) > 0 {
+			seconds, err := strconv.Atoi(since)
+			if err != nil {
+				http.Error(w, "{\"error\": \"Invalid since argument\"}", http.StatusBadRequest)
+				return
+			}
+			filter.From = time.Now().Add(-time.Duration(seconds) * time.Second)
+		}
+		if from := query.Get("from"); len(from) > 0 {
+			t, err := parseEventTime(from)
+			if err != nil {
+				http.Error(w, "{\"error\": \"Invalid from argument\"}", http.StatusBadRequest)
+				return
+			}
+			filter.From = t
+		}
+		if to := query.Get("to"); len(to) > 0 {
+			t, err := parseEventTime(to)
+			if err != nil {
+				http.Error(w, "{\"error\": \"Invalid to argument\"}", http.StatusBadRequest)
+				return
+			}
+			filter.To = t
+		}
+
+		limit := 100
+		if l := query.Get("limit"); len(l) > 0 {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil {
+				http.Error(w, "{\"error\": \"Invalid limit argument\"}", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Events.Search(filter, limit))
+	default:
+		http.Error(w, "{\"error\": \"Method not allowed\"}", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *RestAPI) GetStreamsHandler(w http.ResponseWriter, r *http.Request) {
 	s.RLock()
 	defer s.RUnlock()
@@ -439,7 +630,73 @@ func (s *RestAPI) GetStreamsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *RestAPI) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		now := time.Now()
+		stats := Stats{
+			Since:      s.Since,
+			Total:      s.Total,
+			Qps:        s.Qps.Rate(now),
+			QpsWindow:  s.config.Loggers.RestAPI.QpsWindow,
+			QpsSeries:  s.Qps.Series(now),
+			Rcodes:     s.Rcodes,
+			Operations: s.Operations,
+			Families:   s.Families,
+			Buckets:    LatencyBuckets,
+			Latencies:  s.Latencies,
+		}
+		json.NewEncoder(w).Encode(stats)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GetStatisticsHandler returns the tops of the statistics transformers running
+func (s *RestAPI) GetStatisticsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(transformers.GetStatistics())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *RestAPI) RecordDnsMessage(dm dnsutils.DnsMessage) {
+	// keep recent messages for investigation
+	s.Events.Add(dm)
+
+	// global counters
+	s.Total++
+	s.Qps.Add(time.Now())
+	s.Rcodes[dm.DNS.Rcode]++
+	s.Operations[dm.DnsTap.Operation]++
+	s.Families[dm.NetworkInfo.Family]++
+	if dm.DnsTap.Latency > 0 {
+		bucket := sort.SearchFloat64s(LatencyBuckets, dm.DnsTap.Latency)
+		if bucket < len(LatencyBuckets) && LatencyBuckets[bucket] == dm.DnsTap.Latency {
+			bucket++
+		}
+		s.Latencies[bucket]++
+	}
+
 	if _, exists := s.Streams[dm.DnsTap.Identity]; !exists {
 		s.Streams[dm.DnsTap.Identity] = 1
 	} else {
@@ -562,10 +819,17 @@ func (s *RestAPI) ListenAndServe() {
 	mux.HandleFunc("/domains/servfail/top", s.GetTopSfDomainsHandler)
 	mux.HandleFunc("/suspicious", s.GetSuspiciousHandler)
 	mux.HandleFunc("/search", s.GetSearchHandler)
+	mux.HandleFunc("/events", s.GetEventsHandler)
+	mux.HandleFunc("/stats", s.GetStatsHandler)
+	mux.HandleFunc("/statistics", s.GetStatisticsHandler)
+	mux.Handle("/tail", s.TailHandler())
+	if s.config.Loggers.RestAPI.Dashboard {
+		mux.Handle("/dashboard/", s.DashboardHandler())
+	}
 
 	var err error
 	var listener net.Listener
-	addrlisten := s.config.Loggers.RestAPI.ListenIP + ":" + strconv.Itoa(s.config.Loggers.RestAPI.ListenPort)
+	addrlisten := net.JoinHostPort(s.config.Loggers.RestAPI.ListenIP, strconv.Itoa(s.config.Loggers.RestAPI.ListenPort))
 
 	// listening with tls enabled ?
 	if s.config.Loggers.RestAPI.TlsSupport {
@@ -617,22 +881,45 @@ func (s *RestAPI) Run() {
 	// start http server
 	go s.ListenAndServe()
 
+	// reset the statistics at the end of each retention window
+	var retention <-chan time.Time
+	if s.config.Loggers.RestAPI.StatsRetention > 0 {
+		ticker := time.NewTicker(time.Duration(s.config.Loggers.RestAPI.StatsRetention) * time.Second)
+		defer ticker.Stop()
+		retention = ticker.C
+	}
+
 LOOP:
 	for {
-		dm, opened := <-s.channel
-		if !opened {
-			s.LogInfo("channel closed")
-			break LOOP
-		}
+		select {
+		case cfg := <-s.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case <-retention:
+			s.Lock()
+			s.ResetStats()
+			s.Unlock()
+
+		case dm, opened := <-s.channel:
+			if !opened {
+				s.LogInfo("channel closed")
+				break LOOP
+			}
 
-		// apply tranforms
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
-		}
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
 
-		// record the dnstap message
-		s.RecordDnsMessage(dm)
+			// record the dnstap message
+			s.Lock()
+			s.RecordDnsMessage(dm)
+			s.Unlock()
 
+			// send to the live tails
+			s.Tails.Send(&dm)
+		}
 	}
 
 	s.LogInfo("run terminated")
@@ -1,14 +1,21 @@
 package loggers
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
+	"golang.org/x/net/websocket"
 )
 
 func TestRestAPIBadBasicAuth(t *testing.T) {
@@ -154,3 +161,273 @@ func TestWebServerGet(t *testing.T) {
 		})
 	}
 }
+
+func TestRestAPIEvents(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.RestAPI.EventsMaxSize = 3
+	g := NewRestAPI(config, logger.New(false), "dev", "test")
+
+	now := time.Now()
+	for i, qname := range []string{"www.evil.com", "www.google.com", "cdn.evil.com", "evil.com"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		dm.NetworkInfo.QueryIp = fmt.Sprintf("10.2.3.%d", i)
+		dm.DnsTap.TimeSec = int(now.Unix()) - 60*i
+		g.RecordDnsMessage(dm)
+	}
+
+	// the oldest event is dropped from the store
+	if g.Events.Len() != 3 {
+		t.Fatalf("store should be bounded to 3 events, got %d", g.Events.Len())
+	}
+
+	tt := []struct {
+		name       string
+		uri        string
+		statusCode int
+		qnames     []string
+	}{
+		{
+			name:       "wildcard",
+			uri:        "/events?query_name=*.evil.com",
+			statusCode: http.StatusOK,
+			qnames:     []string{"cdn.evil.com"},
+		},
+		{
+			name:       "subnet and time range",
+			uri:        fmt.Sprintf("/events?query_ip=10.2.3.0/24&from=%d&to=%d", now.Unix()-150, now.Unix()-90),
+			statusCode: http.StatusOK,
+			qnames:     []string{"cdn.evil.com"},
+		},
+		{
+			name:       "limit",
+			uri:        "/events?since=3600&limit=1",
+			statusCode: http.StatusOK,
+			qnames:     []string{"evil.com"},
+		},
+		{
+			name:       "invalid ip",
+			uri:        "/events?query_ip=invalid",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tc.uri, strings.NewReader(""))
+			request.SetBasicAuth(config.Loggers.RestAPI.BasicAuthLogin, config.Loggers.RestAPI.BasicAuthPwd)
+			responseRecorder := httptest.NewRecorder()
+
+			g.GetEventsHandler(responseRecorder, request)
+
+			if responseRecorder.Code != tc.statusCode {
+				t.Fatalf("Want status '%d', got '%d'", tc.statusCode, responseRecorder.Code)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			var events []dnsutils.DnsMessage
+			if err := json.Unmarshal(responseRecorder.Body.Bytes(), &events); err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != len(tc.qnames) {
+				t.Fatalf("want %d events, got %d", len(tc.qnames), len(events))
+			}
+			for i := range events {
+				if events[i].DNS.Qname != tc.qnames[i] {
+					t.Errorf("want %s, got %s", tc.qnames[i], events[i].DNS.Qname)
+				}
+			}
+		})
+	}
+}
+
+func TestRestAPIQpsCounter(t *testing.T) {
+	c := NewQpsCounter(3)
+	now := time.Unix(1000, 0)
+
+	c.Add(now)
+	c.Add(now)
+	c.Add(now.Add(time.Second))
+	c.Add(now.Add(3 * time.Second))
+
+	// the current second is excluded
+	series := c.Series(now.Add(3 * time.Second))
+	if !reflect.DeepEqual(series, []int{2, 1, 0}) {
+		t.Errorf("invalid series: %v", series)
+	}
+	if rate := c.Rate(now.Add(3 * time.Second)); rate != 1 {
+		t.Errorf("invalid rate: %f", rate)
+	}
+
+	// out of the window
+	series = c.Series(now.Add(10 * time.Second))
+	if !reflect.DeepEqual(series, []int{0, 0, 0}) {
+		t.Errorf("invalid series after the window: %v", series)
+	}
+}
+
+func TestRestAPIStats(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewRestAPI(config, logger.New(false), "dev", "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	g.RecordDnsMessage(dm)
+	dm.DNS.Rcode = dnsutils.DNS_RCODE_NXDOMAIN
+	dm.DnsTap.Latency = 0.01
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+	g.RecordDnsMessage(dm)
+
+	request := httptest.NewRequest(http.MethodGet, "/stats", strings.NewReader(""))
+	request.SetBasicAuth(config.Loggers.RestAPI.BasicAuthLogin, config.Loggers.RestAPI.BasicAuthPwd)
+	responseRecorder := httptest.NewRecorder()
+	g.GetStatsHandler(responseRecorder, request)
+
+	stats := Stats{}
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 2 || stats.Rcodes["NOERROR"] != 1 || stats.Rcodes["NXDOMAIN"] != 1 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+	if stats.Operations["CLIENT_QUERY"] != 2 || len(stats.QpsSeries) != config.Loggers.RestAPI.QpsWindow {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+	if stats.Families[dnsutils.PROTO_IPV6] != 1 || len(stats.Families) != 2 {
+		t.Errorf("invalid families: %v", stats.Families)
+	}
+	if !reflect.DeepEqual(stats.Latencies, []int{0, 0, 1, 0, 0, 0, 0}) {
+		t.Errorf("invalid latency histogram: %v", stats.Latencies)
+	}
+
+	// retention window reached
+	g.ResetStats()
+	if g.Total != 0 || len(g.HitsUniq.Domains) != 0 || len(g.TopQnames.Get()) != 0 {
+		t.Errorf("stats not reset")
+	}
+}
+
+func TestRestAPIDashboard(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewRestAPI(config, logger.New(false), "dev", "test")
+	handler := g.DashboardHandler()
+
+	// the browser is asked for the credentials
+	request := httptest.NewRequest(http.MethodGet, "/dashboard/", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, request)
+	if responseRecorder.Code != http.StatusUnauthorized || responseRecorder.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("want authentication request, got %d", responseRecorder.Code)
+	}
+
+	for _, uri := range []string{"/dashboard/", "/dashboard/dashboard.js", "/dashboard/dashboard.css"} {
+		request := httptest.NewRequest(http.MethodGet, uri, nil)
+		request.SetBasicAuth(config.Loggers.RestAPI.BasicAuthLogin, config.Loggers.RestAPI.BasicAuthPwd)
+		responseRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(responseRecorder, request)
+		if responseRecorder.Code != http.StatusOK || responseRecorder.Body.Len() == 0 {
+			t.Errorf("%s: want status 200, got %d", uri, responseRecorder.Code)
+		}
+	}
+}
+
+func TestRestAPIStatistics(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewRestAPI(config, logger.New(false), "dev", "test")
+
+	// statistics transformer running
+	tconfig := dnsutils.GetFakeConfigTransformers()
+	tconfig.Statistics.Enable = true
+	tconfig.Statistics.ReportInterval = 0
+	transforms := transformers.NewTransforms(tconfig, logger.New(false), "collector", []chan dnsutils.DnsMessage{})
+	defer transforms.Reset()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	transforms.ProcessMessage(&dm)
+
+	var stats map[string]dnsutils.TransformStatistics
+	for i := 0; i < 10; i++ {
+		request := httptest.NewRequest(http.MethodGet, "/statistics", strings.NewReader(""))
+		request.SetBasicAuth(config.Loggers.RestAPI.BasicAuthLogin, config.Loggers.RestAPI.BasicAuthPwd)
+		responseRecorder := httptest.NewRecorder()
+		g.GetStatisticsHandler(responseRecorder, request)
+
+		if err := json.Unmarshal(responseRecorder.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := stats["collector"]; ok {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if stats["collector"].Total != 1 || len(stats["collector"].TopQnames) != 1 {
+		t.Errorf("invalid statistics: %+v", stats)
+	}
+}
+
+func TestRestAPITail(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewRestAPI(config, logger.New(false), "dev", "test")
+	server := httptest.NewServer(g.TailHandler())
+	defer server.Close()
+
+	// the credentials are required
+	response, err := http.Get(server.URL + "/tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want status 401, got %d", response.StatusCode)
+	}
+
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(config.Loggers.RestAPI.BasicAuthLogin+":"+config.Loggers.RestAPI.BasicAuthPwd))
+
+	// the pages of other sites are rejected
+	foreignConfig, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/tail", "http://evil.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreignConfig.Header.Set("Authorization", auth)
+	if ws, err := websocket.DialConfig(foreignConfig); err == nil {
+		ws.Close()
+		t.Errorf("foreign origin should be rejected")
+	}
+
+	wsConfig, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/tail?query_name=*.collector&rcode=nxdomain", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsConfig.Header.Set("Authorization", auth)
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for g.Tails.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// only the nxdomain message is streamed
+	dm := dnsutils.GetFakeDnsMessage()
+	g.Tails.Send(&dm)
+	dm.DNS.Rcode = dnsutils.DNS_RCODE_NXDOMAIN
+	g.Tails.Send(&dm)
+
+	var msg dnsutils.DnsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.DNS.Qname != "dns.collector" || msg.DNS.Rcode != dnsutils.DNS_RCODE_NXDOMAIN {
+		t.Errorf("invalid message streamed: %s %s", msg.DNS.Qname, msg.DNS.Rcode)
+	}
+
+	// the connections are closed with the api
+	g.Tails.Close()
+	if err := websocket.JSON.Receive(ws, &msg); err == nil {
+		t.Errorf("connection closed expected")
+	}
+}
@@ -0,0 +1,310 @@
+package loggers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+type RpzWriter struct {
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	zone       string
+	target     string
+	serial     uint32
+	domains    map[string]bool
+	modified   bool
+	notifying  sync.WaitGroup
+}
+
+func NewRpzWriter(config *dnsutils.Config, logger *logger.Logger, name string) *RpzWriter {
+	logger.Info("[%s] logger rpz - enabled", name)
+	o := &RpzWriter{
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		config:     config,
+		name:       name,
+		domains:    make(map[string]bool),
+	}
+	o.ReadConfig()
+	return o
+}
+
+func (c *RpzWriter) GetName() string { return c.name }
+
+func (c *RpzWriter) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *RpzWriter) ReadConfig() {
+	if len(o.config.Loggers.Rpz.FilePath) == 0 {
+		o.logger.Fatal("logger rpz - file path is required")
+	}
+
+	target, ok := dnsutils.RPZ_ACTIONS[o.config.Loggers.Rpz.Action]
+	if !ok {
+		o.logger.Fatal("logger rpz - invalid action: ", o.config.Loggers.Rpz.Action)
+	}
+	o.target = target
+	o.zone = dns.Fqdn(strings.ToLower(o.config.Loggers.Rpz.ZoneName))
+}
+
+func (o *RpzWriter) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (o *RpzWriter) LogInfo(msg string, v ...interface{}) {
+	o.logger.Info("["+o.name+"] logger rpz - "+msg, v...)
+}
+
+func (o *RpzWriter) LogError(msg string, v ...interface{}) {
+	o.logger.Error("["+o.name+"] logger rpz - "+msg, v...)
+}
+
+func (o *RpzWriter) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *RpzWriter) Stop() {
+	o.LogInfo("stopping...")
+
+	// close output channel
+	o.LogInfo("closing channel")
+	close(o.channel)
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+// IsFlagged returns true if the dns message has been tagged by one of the detectors:
+// a suspicious score above the threshold, a match of a threat intel feed or a tunnel
+func (o *RpzWriter) IsFlagged(dm *dnsutils.DnsMessage) bool {
+	if dm.Suspicious != nil && dm.Suspicious.Score >= o.config.Loggers.Rpz.ThresholdScore {
+		return true
+	}
+	if dm.ThreatIntel != nil && len(dm.ThreatIntel.Feed) > 0 && dm.ThreatIntel.Feed != "-" {
+		return true
+	}
+	return dm.Tunneling != nil
+}
+
+// FlaggedDomain returns the domain to add in the zone, the registered domain
+// for the tunnels and the qname otherwise
+func (o *RpzWriter) FlaggedDomain(dm *dnsutils.DnsMessage) string {
+	if dm.Tunneling != nil && len(dm.Tunneling.Domain) > 0 {
+		return dm.Tunneling.Domain
+	}
+	return dm.DNS.Qname
+}
+
+// AddDomain registers the qname in the zone, returns false if the name is invalid or already known
+func (o *RpzWriter) AddDomain(qname string) bool {
+	domain := strings.TrimSuffix(strings.ToLower(qname), ".")
+	if len(domain) == 0 || domain == "-" {
+		return false
+	}
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return false
+	}
+	if _, exists := o.domains[domain]; exists {
+		return false
+	}
+	o.domains[domain] = true
+	o.modified = true
+	return true
+}
+
+// LoadZone reloads domains and serial from an existing zone file
+func (o *RpzWriter) LoadZone() error {
+	file, err := os.Open(o.config.Loggers.Rpz.FilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	zp := dns.NewZoneParser(file, o.zone, o.config.Loggers.Rpz.FilePath)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch rr := rr.(type) {
+		case *dns.SOA:
+			o.serial = rr.Serial
+		case *dns.CNAME:
+			owner := strings.TrimSuffix(strings.ToLower(rr.Hdr.Name), "."+o.zone)
+			owner = strings.TrimPrefix(owner, "*.")
+			if owner != strings.TrimSuffix(o.zone, ".") {
+				o.domains[owner] = true
+			}
+		}
+	}
+	return zp.Err()
+}
+
+// WriteZone renders the rpz zone to a temporary file and replaces the previous one
+func (o *RpzWriter) WriteZone() error {
+	if o.serial == 0 {
+		o.serial = uint32(time.Now().Unix())
+	} else {
+		o.serial++
+	}
+
+	filePath := o.config.Loggers.Rpz.FilePath
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	ttl := o.config.Loggers.Rpz.Ttl
+	primaryNs := dns.Fqdn(o.config.Loggers.Rpz.PrimaryNs)
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(fmt.Sprintf("$ORIGIN %s\n", o.zone))
+	w.WriteString(fmt.Sprintf("$TTL %d\n", ttl))
+	w.WriteString(fmt.Sprintf("@ IN SOA %s %s %d 3600 600 86400 %d\n", primaryNs,
+		dns.Fqdn(o.config.Loggers.Rpz.Hostmaster), o.serial, ttl))
+	w.WriteString(fmt.Sprintf("@ IN NS %s\n", primaryNs))
+
+	domains := make([]string, 0, len(o.domains))
+	for domain := range o.domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		w.WriteString(fmt.Sprintf("%s CNAME %s\n", domain, o.target))
+		if o.config.Loggers.Rpz.Wildcard {
+			w.WriteString(fmt.Sprintf("*.%s CNAME %s\n", domain, o.target))
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// the temporary file is private, the zone is read by the resolver
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// Notify sends a dns NOTIFY to all secondaries for the serial
+func (o *RpzWriter) Notify(serial uint32) {
+	for _, secondary := range o.config.Loggers.Rpz.Notify {
+		m := new(dns.Msg)
+		m.SetNotify(o.zone)
+
+		c := &dns.Client{Timeout: 5 * time.Second}
+		if _, _, err := c.Exchange(m, secondary); err != nil {
+			o.LogError("notify to %s failed: %s", secondary, err)
+			continue
+		}
+		o.LogInfo("notify sent to %s for serial %d", secondary, serial)
+	}
+}
+
+func (o *RpzWriter) FlushZone() {
+	if !o.modified {
+		return
+	}
+
+	if err := o.WriteZone(); err != nil {
+		o.LogError("unable to write zone: %s", err)
+		return
+	}
+	o.modified = false
+	o.LogInfo("zone updated with %d domains, serial %d", len(o.domains), o.serial)
+
+	// the secondaries are notified in background, the messages are still consumed
+	// while a secondary is unreachable
+	if len(o.config.Loggers.Rpz.Notify) > 0 {
+		o.notifying.Add(1)
+		go func(serial uint32) {
+			defer o.notifying.Done()
+			o.Notify(serial)
+		}(o.serial)
+	}
+}
+
+func (o *RpzWriter) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// reload the previous zone if exists
+	if err := o.LoadZone(); err != nil {
+		o.LogError("unable to load zone: %s", err)
+	}
+
+	// prepare flush timer
+	flushInterval := time.Duration(o.config.Loggers.Rpz.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-o.channel:
+			if !opened {
+				o.LogInfo("channel closed")
+				break LOOP
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// only domains tagged by the detectors are added to the zone
+			if !o.IsFlagged(&dm) {
+				continue
+			}
+			o.AddDomain(o.FlaggedDomain(&dm))
+
+		case <-flushTimer.C:
+			o.FlushZone()
+			flushTimer.Reset(flushInterval)
+		}
+	}
+
+	// stop timer and write the last changes
+	flushTimer.Stop()
+	o.FlushZone()
+	o.notifying.Wait()
+
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// the job is done
+	o.done <- true
+}
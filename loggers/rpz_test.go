@@ -0,0 +1,160 @@
+package loggers
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func Test_RpzWriterRun(t *testing.T) {
+	// init logger
+	zoneFile := filepath.Join(t.TempDir(), "db.rpz")
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Rpz.FilePath = zoneFile
+	cfg.Loggers.Rpz.FlushInterval = 1
+
+	g := NewRpzWriter(cfg, logger.New(false), "test")
+	go g.Run()
+
+	// send a normal and a suspicious dns message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "www.google.com"
+	g.channel <- dm
+
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "Evil.Collector."
+	dm.Suspicious = &dnsutils.Suspicious{Score: 2.0}
+	g.channel <- dm
+
+	time.Sleep(2 * time.Second)
+	g.Stop()
+
+	data, err := os.ReadFile(zoneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := string(data)
+
+	for _, want := range []string{"$ORIGIN rpz.local.", "@ IN SOA localhost.", "evil.collector CNAME .", "*.evil.collector CNAME ."} {
+		if !strings.Contains(zone, want) {
+			t.Errorf("zone should contains %q, got: %s", want, zone)
+		}
+	}
+	if strings.Contains(zone, "google") {
+		t.Errorf("unflagged domain should not be in the zone: %s", zone)
+	}
+
+	// reload the zone and check the serial is bumped
+	g2 := NewRpzWriter(cfg, logger.New(false), "test")
+	if err := g2.LoadZone(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g2.domains["evil.collector"]; !ok || len(g2.domains) != 1 {
+		t.Errorf("unexpected domains after reload: %v", g2.domains)
+	}
+	serial := g2.serial
+	g2.AddDomain("other.collector")
+	if err := g2.WriteZone(); err != nil {
+		t.Fatal(err)
+	}
+	if g2.serial != serial+1 {
+		t.Errorf("serial not bumped, want %d got %d", serial+1, g2.serial)
+	}
+}
+
+func Test_RpzWriterIsFlagged(t *testing.T) {
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Rpz.FilePath = filepath.Join(t.TempDir(), "db.rpz")
+	g := NewRpzWriter(cfg, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.ThreatIntel = &dnsutils.TransformThreatIntel{Feed: "-", Category: "-"}
+	if g.IsFlagged(&dm) {
+		t.Errorf("message without detection should not be flagged")
+	}
+
+	dm.ThreatIntel = &dnsutils.TransformThreatIntel{Feed: "malware", Category: "c2"}
+	if !g.IsFlagged(&dm) || g.FlaggedDomain(&dm) != "dns.collector" {
+		t.Errorf("threat intel match should be flagged")
+	}
+
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "aGVsbG8.tunnel.collector"
+	dm.Tunneling = &dnsutils.TransformTunneling{Domain: "tunnel.collector"}
+	if !g.IsFlagged(&dm) || g.FlaggedDomain(&dm) != "tunnel.collector" {
+		t.Errorf("tunnel should be flagged with its registered domain")
+	}
+}
+
+func Test_RpzWriterNotify(t *testing.T) {
+	// fake secondary server
+	notified := make(chan string, 1)
+	server := &dns.Server{Addr: "127.0.0.1:5399", Net: "udp"}
+	server.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Opcode == dns.OpcodeNotify {
+			notified <- r.Question[0].Name
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+	go server.ListenAndServe()
+	defer server.Shutdown()
+	time.Sleep(500 * time.Millisecond)
+
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Rpz.FilePath = filepath.Join(t.TempDir(), "db.rpz")
+	cfg.Loggers.Rpz.Notify = []string{"127.0.0.1:5399"}
+
+	g := NewRpzWriter(cfg, logger.New(false), "test")
+	g.AddDomain("evil.collector")
+	g.FlushZone()
+
+	// the zone is readable by the resolver
+	info, err := os.Stat(cfg.Loggers.Rpz.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("invalid zone file mode: %v", info.Mode().Perm())
+	}
+
+	select {
+	case zone := <-notified:
+		if zone != "rpz.local." {
+			t.Errorf("invalid zone notified: %s", zone)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("notify not received")
+	}
+}
+
+func Test_RpzWriterNotifyUnreachable(t *testing.T) {
+	// fake secondary server which never replies
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.Rpz.FilePath = filepath.Join(t.TempDir(), "db.rpz")
+	cfg.Loggers.Rpz.Notify = []string{conn.LocalAddr().String()}
+
+	g := NewRpzWriter(cfg, logger.New(false), "test")
+	g.AddDomain("evil.collector")
+
+	// the zone is written without waiting the secondary
+	start := time.Now()
+	g.FlushZone()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("flush blocked by the notify: %v", elapsed)
+	}
+}
@@ -41,6 +41,7 @@ type ScalyrClient struct {
 	flush      *time.Ticker // Timer that allows us to flush events periodically
 
 	submissions chan []byte // Marshalled JSON to send to Scalyr
+	configChan  chan *dnsutils.Config
 
 	done          chan bool // Will be written to in Stop()
 	runnerDone    chan bool // Will be written to by the main-loop after stopping
@@ -62,6 +63,7 @@ func NewScalyrClient(config *dnsutils.Config, console *logger.Logger, name strin
 		session: uuid.NewString(),
 
 		submissions: make(chan []byte, 25),
+		configChan:  make(chan *dnsutils.Config, 1),
 
 		done:          make(chan bool),
 		runnerDone:    make(chan bool),
@@ -137,6 +139,11 @@ func (c *ScalyrClient) ReadConfig() {
 	c.httpclient = &http.Client{Transport: tr}
 }
 
+func (c *ScalyrClient) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	dnsutils.SendConfig(c.configChan, config)
+}
+
 func (c *ScalyrClient) Run() {
 	// prepare transforms
 	listChannel := []chan dnsutils.DnsMessage{}
@@ -169,6 +176,10 @@ func (c *ScalyrClient) Run() {
 LOOP:
 	for {
 		select {
+		case cfg := <-c.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
 		case dm := <-c.channel:
 			// apply tranforms
 			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
@@ -42,13 +42,14 @@ type StreamStats struct {
 }
 
 type StatsdClient struct {
-	done    chan bool
-	channel chan dnsutils.DnsMessage
-	config  *dnsutils.Config
-	logger  *logger.Logger
-	exit    chan bool
-	version string
-	name    string
+	done       chan bool
+	configChan chan *dnsutils.Config
+	channel    chan dnsutils.DnsMessage
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	exit       chan bool
+	version    string
+	name       string
 
 	Stats StreamStats
 	sync.RWMutex
@@ -58,14 +59,15 @@ func NewStatsdClient(config *dnsutils.Config, logger *logger.Logger, version str
 	logger.Info("[%s] logger to statsd - enabled", name)
 
 	s := &StatsdClient{
-		done:    make(chan bool),
-		exit:    make(chan bool),
-		channel: make(chan dnsutils.DnsMessage, 512),
-		logger:  logger,
-		config:  config,
-		version: version,
-		name:    name,
-		Stats:   StreamStats{Streams: make(map[string]*StatsPerStream)},
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		exit:       make(chan bool),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     logger,
+		config:     config,
+		version:    version,
+		name:       name,
+		Stats:      StreamStats{Streams: make(map[string]*StatsPerStream)},
 	}
 
 	// check config
@@ -84,6 +86,11 @@ func (o *StatsdClient) ReadConfig() {
 	}
 }
 
+func (o *StatsdClient) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
 func (o *StatsdClient) LogInfo(msg string, v ...interface{}) {
 	o.logger.Info("["+o.name+"] logger to statsd - "+msg, v...)
 }
@@ -236,6 +243,9 @@ func (o *StatsdClient) Run() {
 LOOP:
 	for {
 		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
 
 		case dm, opened := <-o.channel:
 			if !opened {
@@ -252,7 +262,7 @@ LOOP:
 			o.RecordDnsMessage(dm)
 
 		case <-t2.C:
-			address := o.config.Loggers.Statsd.RemoteAddress + ":" + strconv.Itoa(o.config.Loggers.Statsd.RemotePort)
+			address := net.JoinHostPort(o.config.Loggers.Statsd.RemoteAddress, strconv.Itoa(o.config.Loggers.Statsd.RemotePort))
 
 			// make the connection
 			o.LogInfo("dial to %s", address)
@@ -14,6 +14,7 @@ import (
 
 type StdOut struct {
 	done       chan bool
+	configChan chan *dnsutils.Config
 	channel    chan dnsutils.DnsMessage
 	textFormat []string
 	config     *dnsutils.Config
@@ -25,12 +26,13 @@ type StdOut struct {
 func NewStdOut(config *dnsutils.Config, console *logger.Logger, name string) *StdOut {
 	console.Info("[%s] logger stdout - enabled", name)
 	o := &StdOut{
-		done:    make(chan bool),
-		channel: make(chan dnsutils.DnsMessage, 512),
-		logger:  console,
-		config:  config,
-		stdout:  log.New(os.Stdout, "", 0),
-		name:    name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     console,
+		config:     config,
+		stdout:     log.New(os.Stdout, "", 0),
+		name:       name,
 	}
 	o.ReadConfig()
 	return o
@@ -48,6 +50,11 @@ func (c *StdOut) ReadConfig() {
 	}
 }
 
+func (c *StdOut) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	dnsutils.SendConfig(c.configChan, config)
+}
+
 func (c *StdOut) LogInfo(msg string, v ...interface{}) {
 	c.logger.Info("["+c.name+"] logger to stdout - "+msg, v...)
 }
@@ -87,33 +94,44 @@ func (o *StdOut) Run() {
 	// standard output buffer
 	buffer := new(bytes.Buffer)
 
-	for dm := range o.channel {
-		// apply tranforms
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
-		}
-
-		// fmt.Printf("Size of %T: %d bytes\n", dm, unsafe.Sizeof(dm))
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
 
-		switch o.config.Loggers.Stdout.Mode {
-		case dnsutils.MODE_TEXT:
-			o.stdout.Print(dm.String(o.textFormat,
-				o.config.Global.TextFormatDelimiter,
-				o.config.Global.TextFormatBoundary))
-
-		case dnsutils.MODE_JSON:
-			json.NewEncoder(buffer).Encode(dm)
-			o.stdout.Print(buffer.String())
-			buffer.Reset()
+		case dm, opened := <-o.channel:
+			if !opened {
+				break LOOP
+			}
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
 
-		case dnsutils.MODE_FLATJSON:
-			flat, err := dm.Flatten()
-			if err != nil {
-				o.LogError("flattening DNS message failed: %e", err)
+			// fmt.Printf("Size of %T: %d bytes\n", dm, unsafe.Sizeof(dm))
+
+			switch o.config.Loggers.Stdout.Mode {
+			case dnsutils.MODE_TEXT:
+				o.stdout.Print(dm.String(o.textFormat,
+					o.config.Global.TextFormatDelimiter,
+					o.config.Global.TextFormatBoundary))
+
+			case dnsutils.MODE_JSON:
+				json.NewEncoder(buffer).Encode(dm)
+				o.stdout.Print(buffer.String())
+				buffer.Reset()
+
+			case dnsutils.MODE_FLATJSON:
+				flat, err := dm.Flatten()
+				if err != nil {
+					o.LogError("flattening DNS message failed: %e", err)
+				}
+				json.NewEncoder(buffer).Encode(flat)
+				o.stdout.Print(buffer.String())
+				buffer.Reset()
 			}
-			json.NewEncoder(buffer).Encode(flat)
-			o.stdout.Print(buffer.String())
-			buffer.Reset()
 		}
 	}
 	o.LogInfo("run terminated")
@@ -133,3 +133,34 @@ func Test_StdoutJsonMode(t *testing.T) {
 		})
 	}
 }
+
+func Test_StdoutReloadConfig(t *testing.T) {
+	var stdout bytes.Buffer
+
+	cfg := dnsutils.GetFakeConfig()
+	g := NewStdOut(cfg, logger.New(false), "test")
+	g.SetBuffer(&stdout)
+
+	go g.Run()
+
+	// reload with a filtering on the return code
+	newCfg := dnsutils.GetFakeConfig()
+	newCfg.OutgoingTransformers.Filtering.Enable = true
+	newCfg.OutgoingTransformers.Filtering.DropRcodes = []string{"NOERROR"}
+	g.ReloadConfig(newCfg)
+
+	// the config is applied in background
+	for len(g.configChan) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the message must be dropped
+	g.channel <- dnsutils.GetFakeDnsMessage()
+
+	time.Sleep(time.Second)
+	g.Stop()
+
+	if stdout.Len() != 0 {
+		t.Errorf("message should be dropped after reload: %s", stdout.String())
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
-
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	syslog "github.com/RackSec/srslog"
 
@@ -19,6 +21,14 @@ func GetPriority(facility string) (syslog.Priority, error) {
 	facility = strings.ToUpper(facility)
 	switch facility {
 	// level
+	case "EMERG":
+		return syslog.LOG_EMERG, nil
+	case "ALERT":
+		return syslog.LOG_ALERT, nil
+	case "CRIT":
+		return syslog.LOG_CRIT, nil
+	case "ERR":
+		return syslog.LOG_ERR, nil
 	case "WARNING":
 		return syslog.LOG_WARNING, nil
 	case "NOTICE":
@@ -28,6 +38,8 @@ func GetPriority(facility string) (syslog.Priority, error) {
 	case "DEBUG":
 		return syslog.LOG_DEBUG, nil
 	// facility
+	case "USER":
+		return syslog.LOG_USER, nil
 	case "DAEMON":
 		return syslog.LOG_DAEMON, nil
 	case "LOCAL0":
@@ -51,8 +63,65 @@ func GetPriority(facility string) (syslog.Priority, error) {
 	}
 }
 
+// the structured data ids use the enterprise number reserved for documentation (RFC5612)
+const (
+	SyslogSdDns = "dns@32473"
+	SyslogSdGeo = "geo@32473"
+)
+
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// RFC5424SDFormatter provides an RFC 5424 message where the content
+// starts with the structured data elements
+func RFC5424SDFormatter(p syslog.Priority, hostname, tag, content string) string {
+	timestamp := time.Now().Format(time.RFC3339)
+	appName := os.Args[0]
+	if len(appName) > 48 {
+		appName = appName[len(appName)-48:]
+	}
+	return fmt.Sprintf("<%d>%d %s %s %s %d %s %s",
+		p, 1, timestamp, hostname, appName, os.Getpid(), tag, content)
+}
+
+// GetStructuredData returns the structured data elements of the dns message,
+// the geo element is added only if the geoip transformer is enabled
+func GetStructuredData(dm *dnsutils.DnsMessage) string {
+	var sd strings.Builder
+	writeElement := func(id string, params [][2]string) {
+		sd.WriteString("[" + id)
+		for _, param := range params {
+			sd.WriteString(" " + param[0] + "=\"" + sdParamEscaper.Replace(param[1]) + "\"")
+		}
+		sd.WriteString("]")
+	}
+
+	writeElement(SyslogSdDns, [][2]string{
+		{"identity", dm.DnsTap.Identity},
+		{"operation", dm.DnsTap.Operation},
+		{"qname", dm.DNS.Qname},
+		{"qtype", dm.DNS.Qtype},
+		{"rcode", dm.DNS.Rcode},
+		{"latency", dm.DnsTap.LatencySec},
+		{"query-ip", dm.NetworkInfo.QueryIp},
+		{"protocol", dm.NetworkInfo.Protocol},
+		{"length", strconv.Itoa(dm.DNS.Length)},
+	})
+
+	if dm.Geo != nil {
+		writeElement(SyslogSdGeo, [][2]string{
+			{"city", dm.Geo.City},
+			{"continent", dm.Geo.Continent},
+			{"country-isocode", dm.Geo.CountryIsoCode},
+			{"as-number", dm.Geo.AutonomousSystemNumber},
+			{"as-owner", dm.Geo.AutonomousSystemOrg},
+		})
+	}
+	return sd.String()
+}
+
 type Syslog struct {
 	done       chan bool
+	configChan chan *dnsutils.Config
 	channel    chan dnsutils.DnsMessage
 	config     *dnsutils.Config
 	logger     *logger.Logger
@@ -66,11 +135,12 @@ type Syslog struct {
 func NewSyslog(config *dnsutils.Config, console *logger.Logger, name string) *Syslog {
 	console.Info("[%s] logger syslog - enabled", name)
 	o := &Syslog{
-		done:    make(chan bool),
-		channel: make(chan dnsutils.DnsMessage, 512),
-		logger:  console,
-		config:  config,
-		name:    name,
+		done:       make(chan bool),
+		configChan: make(chan *dnsutils.Config, 1),
+		channel:    make(chan dnsutils.DnsMessage, 512),
+		logger:     console,
+		config:     config,
+		name:       name,
 	}
 	o.ReadConfig()
 	return o
@@ -100,6 +170,10 @@ func (c *Syslog) ReadConfig() {
 	}
 	c.facility = facility
 
+	if c.config.Loggers.Syslog.StructuredData && strings.ToLower(c.config.Loggers.Syslog.Format) != "rfc5424" {
+		c.logger.Fatal("logger syslog - structured data requires the rfc5424 format")
+	}
+
 	if len(c.config.Loggers.Syslog.TextFormat) > 0 {
 		c.textFormat = strings.Fields(c.config.Loggers.Syslog.TextFormat)
 	} else {
@@ -107,6 +181,11 @@ func (c *Syslog) ReadConfig() {
 	}
 }
 
+func (c *Syslog) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	dnsutils.SendConfig(c.configChan, config)
+}
+
 func (o *Syslog) Channel() chan dnsutils.DnsMessage {
 	return o.channel
 }
@@ -179,39 +258,54 @@ func (o *Syslog) Run() {
 	case "rfc3164":
 		syslogconn.SetFormatter(syslog.RFC3164Formatter)
 	case "rfc5424":
-		syslogconn.SetFormatter(syslog.RFC5424Formatter)
+		if o.config.Loggers.Syslog.StructuredData {
+			syslogconn.SetFormatter(RFC5424SDFormatter)
+		} else {
+			syslogconn.SetFormatter(syslog.RFC5424Formatter)
+		}
 	}
 
 	o.syslogConn = syslogconn
 
-	for dm := range o.channel {
-		// apply tranforms
-		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
-			continue
-		}
-
-		switch o.config.Loggers.Syslog.Mode {
-		case dnsutils.MODE_TEXT:
-
-			o.syslogConn.Write(dm.Bytes(o.textFormat,
-				o.config.Global.TextFormatDelimiter,
-				o.config.Global.TextFormatBoundary))
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
 
-			var delimiter bytes.Buffer
-			delimiter.WriteString("\n")
-			o.syslogConn.Write(delimiter.Bytes())
+		case dm, opened := <-o.channel:
+			if !opened {
+				break LOOP
+			}
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
 
-		case dnsutils.MODE_JSON:
-			json.NewEncoder(buffer).Encode(dm)
-			o.syslogConn.Write(buffer.Bytes())
-			buffer.Reset()
+			// structured data elements before the message
+			if o.config.Loggers.Syslog.StructuredData {
+				buffer.WriteString(GetStructuredData(&dm) + " ")
+			}
 
-		case dnsutils.MODE_FLATJSON:
-			flat, err := dm.Flatten()
-			if err != nil {
-				o.LogError("flattening DNS message failed: %e", err)
+			switch o.config.Loggers.Syslog.Mode {
+			case dnsutils.MODE_TEXT:
+				buffer.Write(dm.Bytes(o.textFormat,
+					o.config.Global.TextFormatDelimiter,
+					o.config.Global.TextFormatBoundary))
+				buffer.WriteString("\n")
+
+			case dnsutils.MODE_JSON:
+				json.NewEncoder(buffer).Encode(dm)
+
+			case dnsutils.MODE_FLATJSON:
+				flat, err := dm.Flatten()
+				if err != nil {
+					o.LogError("flattening DNS message failed: %e", err)
+				}
+				json.NewEncoder(buffer).Encode(flat)
 			}
-			json.NewEncoder(buffer).Encode(flat)
+
 			o.syslogConn.Write(buffer.Bytes())
 			buffer.Reset()
 		}
@@ -77,3 +77,53 @@ func Test_SyslogRun(t *testing.T) {
 		})
 	}
 }
+
+func Test_SyslogStructuredData(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.Syslog.Transport = dnsutils.SOCKET_TCP
+	config.Loggers.Syslog.RemoteAddress = ":4001"
+	config.Loggers.Syslog.Format = "rfc5424"
+	config.Loggers.Syslog.StructuredData = true
+	g := NewSyslog(config, logger.New(false), "test")
+
+	// fake syslog receiver
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, ":4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// start the logger
+	go g.Run()
+
+	// accept conn from logger
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// send fake dns message with geo data to logger
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.Geo = &dnsutils.DnsGeo{City: "Paris", CountryIsoCode: "FR", AutonomousSystemOrg: "\"quoted\" org"}
+	g.channel <- dm
+
+	// read data on server side
+	reader := bufio.NewReader(conn)
+	line, _, err := reader.ReadLine()
+	if err != nil {
+		t.Errorf("error to read line on syslog server: %s", err)
+	}
+
+	patterns := []string{
+		`^<30>1 `,
+		`\[dns@32473 identity="collector" operation="CLIENT_QUERY" qname="dns.collector" qtype="A" rcode="NOERROR" `,
+		`\[geo@32473 city="Paris" continent="" country-isocode="FR" as-number="" as-owner="\\"quoted\\" org"\] `,
+		` dns.collector `,
+	}
+	for _, p := range patterns {
+		if !regexp.MustCompile(p).MatchString(string(line)) {
+			t.Errorf("syslog error want %s, got: %s", p, string(line))
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package loggers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"golang.org/x/net/websocket"
+)
+
+// number of messages buffered per connection, the messages are dropped for a client too slow
+const tailBufferSize = 512
+
+type tailClient struct {
+	filter  EventFilter
+	channel chan []byte
+	dropped int
+}
+
+// TailHub sends the live messages to the websocket connections, according to their filter
+type TailHub struct {
+	sync.Mutex
+	clients map[*tailClient]bool
+	closed  bool
+}
+
+func NewTailHub() *TailHub {
+	return &TailHub{clients: make(map[*tailClient]bool)}
+}
+
+func (h *TailHub) add(c *tailClient) {
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		close(c.channel)
+		return
+	}
+	h.clients[c] = true
+}
+
+// remove unregisters the connection and returns the number of messages dropped
+func (h *TailHub) remove(c *tailClient) int {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.clients, c)
+	return c.dropped
+}
+
+// Len returns the number of connections
+func (h *TailHub) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.clients)
+}
+
+// Close terminates the connections
+func (h *TailHub) Close() {
+	h.Lock()
+	defer h.Unlock()
+	for c := range h.clients {
+		close(c.channel)
+		delete(h.clients, c)
+	}
+	h.closed = true
+}
+
+// Send sends the message to the connections matching it, the message is encoded only once
+func (h *TailHub) Send(dm *dnsutils.DnsMessage) {
+	h.Lock()
+	defer h.Unlock()
+
+	var payload []byte
+	for c := range h.clients {
+		if !c.filter.Match(dm) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(dm); err != nil {
+				return
+			}
+		}
+		select {
+		case c.channel <- payload:
+		default:
+			c.dropped++
+		}
+	}
+}
+
+// TailHandler streams the live messages in json over a websocket, the messages can be
+// filtered with the query_name, query_ip and rcode arguments of the events
+func (s *RestAPI) TailHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.BasicAuth(w, r) {
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		client := &tailClient{
+			filter: EventFilter{
+				Qname:   query.Get("query_name"),
+				QueryIp: query.Get("query_ip"),
+				Rcode:   query.Get("rcode"),
+			},
+			channel: make(chan []byte, tailBufferSize),
+		}
+		if err := client.filter.Prepare(); err != nil {
+			http.Error(w, "{\"error\": \""+err.Error()+"\"}", http.StatusBadRequest)
+			return
+		}
+
+		server := websocket.Server{
+			Handshake: CheckTailOrigin,
+			Handler:   func(ws *websocket.Conn) { s.tail(ws, client) },
+		}
+		server.ServeHTTP(w, r)
+	})
+}
+
+// CheckTailOrigin rejects the websockets opened by the pages of other sites, the browsers send the
+// credentials of the api with them. The clients without origin (websocat, scripts) are accepted
+func CheckTailOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != r.Host {
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	return nil
+}
+
+func (s *RestAPI) tail(ws *websocket.Conn, client *tailClient) {
+	peer := ws.Request().RemoteAddr
+	s.LogInfo("%s - tail started", peer)
+
+	s.Tails.add(client)
+	defer func() {
+		dropped := s.Tails.remove(client)
+		s.LogInfo("%s - tail terminated, %d messages dropped", peer, dropped)
+	}()
+
+	// the messages of the client are ignored, the connection is closed on error
+	closed := make(chan bool)
+	go func() {
+		io.Copy(io.Discard, ws)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case payload, opened := <-client.channel:
+			if !opened {
+				return
+			}
+			if err := websocket.Message.Send(ws, string(payload)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package loggers
 import (
 	"bufio"
 	"crypto/tls"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -13,10 +14,12 @@ import (
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 type TcpClient struct {
 	done               chan bool
+	configChan         chan *dnsutils.Config
 	channel            chan dnsutils.DnsMessage
 	config             *dnsutils.Config
 	logger             *logger.Logger
@@ -24,16 +27,21 @@ type TcpClient struct {
 	textFormat         []string
 	name               string
 	transportWriter    *bufio.Writer
+	transportReader    *bufio.Reader
 	transportConn      net.Conn
 	transportReady     chan bool
 	transportReconnect chan bool
 	writerReady        bool
+	spool              *DiskQueue
+	retryBuffer        []dnsutils.DnsMessage
+	retryDropped       int
 }
 
 func NewTcpClient(config *dnsutils.Config, logger *logger.Logger, name string) *TcpClient {
 	logger.Info("[%s] logger to tcp client - enabled", name)
 	s := &TcpClient{
 		done:               make(chan bool),
+		configChan:         make(chan *dnsutils.Config, 1),
 		exit:               make(chan bool),
 		channel:            make(chan dnsutils.DnsMessage, 512),
 		transportReady:     make(chan bool),
@@ -62,6 +70,35 @@ func (o *TcpClient) ReadConfig() {
 	} else {
 		o.textFormat = strings.Fields(o.config.Global.TextFormat)
 	}
+
+	switch o.config.Loggers.TcpClient.Framing {
+	case dnsutils.FRAMING_DELIMITER, dnsutils.FRAMING_LENGTH:
+	default:
+		o.logger.Fatal("logger tcp - invalid framing: ", o.config.Loggers.TcpClient.Framing)
+	}
+
+	// the datagrams are not acknowledged nor encrypted
+	if o.config.Loggers.TcpClient.Transport == dnsutils.SOCKET_UDP {
+		if o.config.Loggers.TcpClient.AckMode || o.config.Loggers.TcpClient.TlsSupport {
+			o.logger.Fatal("logger tcp - ack-mode and tls-support are not supported with the udp transport")
+		}
+	}
+
+	if o.config.Loggers.TcpClient.AckMode {
+		if len(o.config.Loggers.TcpClient.SpoolDir) == 0 {
+			o.logger.Fatal("logger tcp - spool-dir is required with ack-mode")
+		}
+		spool, err := NewDiskQueue(o.config.Loggers.TcpClient.SpoolDir, o.name, 0)
+		if err != nil {
+			o.logger.Fatal("logger tcp - unable to init spool: ", err)
+		}
+		o.spool = spool
+	}
+}
+
+func (o *TcpClient) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
 }
 
 func (o *TcpClient) LogInfo(msg string, v ...interface{}) {
@@ -100,10 +137,17 @@ func (o *TcpClient) ConnectToRemote() {
 	if len(o.config.Loggers.TcpClient.SockPath) > 0 {
 		address = o.config.Loggers.TcpClient.SockPath
 	} else {
-		address = o.config.Loggers.TcpClient.RemoteAddress + ":" + strconv.Itoa(o.config.Loggers.TcpClient.RemotePort)
+		address = net.JoinHostPort(o.config.Loggers.TcpClient.RemoteAddress, strconv.Itoa(o.config.Loggers.TcpClient.RemotePort))
 	}
 	connTimeout := time.Duration(o.config.Loggers.TcpClient.ConnectTimeout) * time.Second
 
+	// the interval between the retries is doubled after each failure
+	retryInterval := o.config.Loggers.TcpClient.RetryInterval
+	maxRetryInterval := o.config.Loggers.TcpClient.MaxRetryInterval
+	if maxRetryInterval < retryInterval {
+		maxRetryInterval = retryInterval
+	}
+
 	for {
 		if o.transportConn != nil {
 			o.transportConn.Close()
@@ -131,12 +175,17 @@ func (o *TcpClient) ConnectToRemote() {
 		// something is wrong during connection ?
 		if err != nil {
 			o.LogError("%s", err)
-			o.LogInfo("retry to connect in %d seconds", o.config.Loggers.TcpClient.RetryInterval)
-			time.Sleep(time.Duration(o.config.Loggers.TcpClient.RetryInterval) * time.Second)
+			o.LogInfo("retry to connect in %d seconds", retryInterval)
+			time.Sleep(time.Duration(retryInterval) * time.Second)
+			retryInterval *= 2
+			if retryInterval > maxRetryInterval {
+				retryInterval = maxRetryInterval
+			}
 			continue
 		}
 
 		o.transportConn = conn
+		retryInterval = o.config.Loggers.TcpClient.RetryInterval
 
 		// block until framestream is ready
 		o.transportReady <- true
@@ -146,37 +195,115 @@ func (o *TcpClient) ConnectToRemote() {
 	}
 }
 
+// Encode returns the payload of the message according to the mode, true for the text modes
+func (o *TcpClient) Encode(dm *dnsutils.DnsMessage) ([]byte, bool, error) {
+	switch o.config.Loggers.TcpClient.Mode {
+	case dnsutils.MODE_TEXT:
+		return dm.Bytes(o.textFormat, o.config.Global.TextFormatDelimiter, o.config.Global.TextFormatBoundary), true, nil
+	case dnsutils.MODE_JSON:
+		data, err := json.Marshal(dm)
+		return data, true, err
+	case dnsutils.MODE_FLATJSON:
+		flat, err := dm.Flatten()
+		if err != nil {
+			return nil, true, err
+		}
+		data, err := json.Marshal(flat)
+		return data, true, err
+	case dnsutils.MODE_MSGPACK:
+		data, err := dm.ToMsgpack()
+		return data, false, err
+	case dnsutils.MODE_CBOR:
+		data, err := dm.ToCbor()
+		return data, false, err
+	case dnsutils.MODE_PROTOBUF:
+		data, err := dm.ToProtobuf()
+		return data, false, err
+	case dnsutils.MODE_AVRO:
+		data, err := dm.ToAvro()
+		return data, false, err
+	}
+	return nil, false, fmt.Errorf("unsupported mode %s", o.config.Loggers.TcpClient.Mode)
+}
+
+func (o *TcpClient) WriteMessage(dm *dnsutils.DnsMessage) error {
+	data, text, err := o.Encode(dm)
+	if err != nil {
+		return err
+	}
+
+	// the messages are prefixed by their size on 4 bytes, in network byte order
+	if o.config.Loggers.TcpClient.Framing == dnsutils.FRAMING_LENGTH {
+		o.transportWriter.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+		o.transportWriter.Write(data)
+		return nil
+	}
+
+	switch {
+	case text:
+		o.transportWriter.Write(data)
+		// the json messages are terminated by a newline, as written by the json encoder
+		if o.config.Loggers.TcpClient.Mode != dnsutils.MODE_TEXT {
+			o.transportWriter.WriteString("\n")
+		}
+		o.transportWriter.WriteString(o.config.Loggers.TcpClient.PayloadDelimiter)
+
+	// binary modes are self-delimited, the payload delimiter is not added
+	// protobuf messages are prefixed by their size (varint), as the protobuf delimited streams
+	case o.config.Loggers.TcpClient.Mode == dnsutils.MODE_PROTOBUF:
+		o.transportWriter.Write(protowire.AppendVarint(nil, uint64(len(data))))
+		o.transportWriter.Write(data)
+
+	default:
+		o.transportWriter.Write(data)
+	}
+	return nil
+}
+
+// Retain keeps the messages until the reconnection, the oldest ones are dropped
+// when the retry buffer is full
+func (o *TcpClient) Retain(msgs []dnsutils.DnsMessage) {
+	o.retryBuffer = append(o.retryBuffer, msgs...)
+	if over := len(o.retryBuffer) - o.config.Loggers.TcpClient.RetryBufferSize; over > 0 {
+		n := copy(o.retryBuffer, o.retryBuffer[over:])
+		o.retryBuffer = o.retryBuffer[:n]
+		o.retryDropped += over
+	}
+}
+
 func (o *TcpClient) FlushBuffer(buf *[]dnsutils.DnsMessage) {
-	for _, dm := range *buf {
-		if o.config.Loggers.TcpClient.Mode == dnsutils.MODE_TEXT {
-			o.transportWriter.Write(dm.Bytes(o.textFormat,
-				o.config.Global.TextFormatDelimiter,
-				o.config.Global.TextFormatBoundary))
-			o.transportWriter.WriteString(o.config.Loggers.TcpClient.PayloadDelimiter)
+	// with acknowledgements, the buffer is written to the spool before any send
+	if o.spool != nil {
+		if _, err := o.spool.Push(*buf); err != nil {
+			o.LogError("unable to spool messages: %s", err)
 		}
+		*buf = nil
 
-		if o.config.Loggers.TcpClient.Mode == dnsutils.MODE_JSON {
-			json.NewEncoder(o.transportWriter).Encode(dm)
-			o.transportWriter.WriteString(o.config.Loggers.TcpClient.PayloadDelimiter)
+		if o.writerReady {
+			o.SendPending()
 		}
+		return
+	}
 
-		if o.config.Loggers.TcpClient.Mode == dnsutils.MODE_FLATJSON {
-			flat, err := dm.Flatten()
-			if err != nil {
-				o.LogError("flattening DNS message failed: %e", err)
-				continue
-			}
-			json.NewEncoder(o.transportWriter).Encode(flat)
-			o.transportWriter.WriteString(o.config.Loggers.TcpClient.PayloadDelimiter)
+	for i := range *buf {
+		if err := o.WriteMessage(&(*buf)[i]); err != nil {
+			o.LogError("flattening DNS message failed: %e", err)
+			continue
 		}
 
-		// flush the transport buffer
+		// flush the transport buffer, one datagram per message with udp
 		err := o.transportWriter.Flush()
 		if err != nil {
 			o.LogError("send frame error", err.Error())
 			o.writerReady = false
+
+			// the messages not sent are kept for the reconnection
+			remaining := append([]dnsutils.DnsMessage(nil), (*buf)[i:]...)
+			*buf = nil
+			o.Retain(remaining)
+
 			<-o.transportReconnect
-			break
+			return
 		}
 	}
 
@@ -184,6 +311,59 @@ func (o *TcpClient) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 	*buf = nil
 }
 
+func (o *TcpClient) SendPending() {
+	pending, err := o.spool.Pending()
+	if err != nil {
+		o.LogError("unable to list spool: %s", err)
+		return
+	}
+
+	for _, path := range pending {
+		batch, err := o.spool.Load(path)
+		if err != nil {
+			o.LogError("unable to load %s: %s", path, err)
+			continue
+		}
+
+		if err := o.SendWithAck(batch); err != nil {
+			o.LogError("delivery error, %d messages kept in spool: %s", len(batch), err)
+			o.writerReady = false
+			<-o.transportReconnect
+			return
+		}
+
+		if err := o.spool.Release(path); err != nil {
+			o.LogError("unable to release %s: %s", path, err)
+		}
+	}
+}
+
+func (o *TcpClient) SendWithAck(batch []dnsutils.DnsMessage) error {
+	sent := 0
+	for i := range batch {
+		if err := o.WriteMessage(&batch[i]); err != nil {
+			o.LogError("flattening DNS message failed: %e", err)
+			continue
+		}
+		sent++
+	}
+	if err := o.transportWriter.Flush(); err != nil {
+		return err
+	}
+
+	// wait the acknowledgements
+	ackTimeout := time.Duration(o.config.Loggers.TcpClient.AckTimeout) * time.Second
+	o.transportConn.SetReadDeadline(time.Now().Add(ackTimeout))
+	defer o.transportConn.SetReadDeadline(time.Time{})
+
+	for i := 0; i < sent; i++ {
+		if _, err := o.transportReader.ReadString('\n'); err != nil {
+			return fmt.Errorf("ack %d/%d not received: %w", i+1, sent, err)
+		}
+	}
+	return nil
+}
+
 func (o *TcpClient) Run() {
 	o.LogInfo("running in background...")
 
@@ -205,19 +385,39 @@ func (o *TcpClient) Run() {
 LOOP:
 	for {
 		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
 		case <-o.exit:
 			o.logger.Info("closing loop...")
 			break LOOP
 
 		case <-o.transportReady:
 			o.LogInfo("transport connected with success")
-			o.transportWriter = bufio.NewWriter(o.transportConn)
+			o.transportWriter = bufio.NewWriterSize(o.transportConn, 65535)
+			o.transportReader = bufio.NewReader(o.transportConn)
 			o.writerReady = true
 
+			// send the messages retained while disconnected
+			if o.retryDropped > 0 {
+				o.LogError("%d messages dropped while disconnected", o.retryDropped)
+				o.retryDropped = 0
+			}
+			if len(o.retryBuffer) > 0 {
+				o.LogInfo("sending %d messages retained", len(o.retryBuffer))
+				o.FlushBuffer(&o.retryBuffer)
+			}
+
+			// replay the messages not yet acknowledged
+			if o.spool != nil {
+				o.SendPending()
+			}
+
 		case dm := <-o.channel:
 			// drop dns message if the connection is not ready to avoid memory leak or
-			// to block the channel
-			if !o.writerReady {
+			// to block the channel, except with acknowledgements, messages are spooled
+			if !o.writerReady && o.spool == nil && o.config.Loggers.TcpClient.RetryBufferSize <= 0 {
 				continue
 			}
 
@@ -226,6 +426,12 @@ LOOP:
 				continue
 			}
 
+			// keep the message in the retry buffer until the reconnection
+			if !o.writerReady && o.spool == nil {
+				o.Retain([]dnsutils.DnsMessage{dm})
+				continue
+			}
+
 			// append dns message to buffer
 			bufferDm = append(bufferDm, dm)
 
@@ -236,13 +442,10 @@ LOOP:
 
 		// flush the buffer
 		case <-flushTimer.C:
-			if !o.writerReady {
-				fmt.Println("buffer cleared!")
+			if !o.writerReady && o.spool == nil {
+				o.Retain(bufferDm)
 				bufferDm = nil
-				continue
-			}
-
-			if len(bufferDm) > 0 {
+			} else if len(bufferDm) > 0 {
 				o.FlushBuffer(&bufferDm)
 			}
 
@@ -252,8 +455,25 @@ LOOP:
 		}
 	}
 
+	// the messages received before the stop are sent
+	dnsutils.DrainChannel(o.channel, func(dm dnsutils.DnsMessage) {
+		if subprocessors.ProcessMessage(&dm) != transformers.RETURN_DROP {
+			bufferDm = append(bufferDm, dm)
+		}
+	})
+	if o.writerReady && o.spool == nil && len(bufferDm) > 0 {
+		o.FlushBuffer(&bufferDm)
+	}
+
 	o.LogInfo("run terminated")
 
+	// keep the buffered messages for the next start
+	if o.spool != nil && len(bufferDm) > 0 {
+		if _, err := o.spool.Push(bufferDm); err != nil {
+			o.LogError("unable to spool messages: %s", err)
+		}
+	}
+
 	// cleanup transformers
 	subprocessors.Reset()
 
@@ -2,8 +2,12 @@ package loggers
 
 import (
 	"bufio"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -79,3 +83,211 @@ func Test_TcpClientRun(t *testing.T) {
 		})
 	}
 }
+
+func Test_TcpClientAckMode(t *testing.T) {
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.TcpClient.RemotePort = 9998
+	cfg.Loggers.TcpClient.FlushInterval = 1
+	cfg.Loggers.TcpClient.BufferSize = 0
+	cfg.Loggers.TcpClient.PayloadDelimiter = ""
+	cfg.Loggers.TcpClient.AckMode = true
+	cfg.Loggers.TcpClient.SpoolDir = t.TempDir()
+
+	// a batch left by a previous run
+	spool, err := NewDiskQueue(cfg.Loggers.TcpClient.SpoolDir, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spool.Push([]dnsutils.DnsMessage{dnsutils.GetFakeDnsMessage()}); err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewTcpClient(cfg, logger.New(false), "test")
+
+	// fake receiver acknowledging each message
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, ":9998")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// start the logger
+	go g.Run()
+
+	// accept conn from logger
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the spooled message is replayed on connect
+	reader := bufio.NewReader(conn)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ok\n"))
+
+	// send fake dns message to logger
+	g.channel <- dnsutils.GetFakeDnsMessage()
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ok\n"))
+
+	// the spool is empty once all messages are acknowledged
+	time.Sleep(500 * time.Millisecond)
+	files, _ := filepath.Glob(filepath.Join(cfg.Loggers.TcpClient.SpoolDir, "*"))
+	if len(files) != 0 {
+		t.Errorf("spool should be empty, got %v", files)
+	}
+}
+
+func Test_TcpClientLengthPrefixed(t *testing.T) {
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.TcpClient.RemotePort = 9997
+	cfg.Loggers.TcpClient.FlushInterval = 1
+	cfg.Loggers.TcpClient.BufferSize = 0
+	cfg.Loggers.TcpClient.Framing = dnsutils.FRAMING_LENGTH
+
+	g := NewTcpClient(cfg, logger.New(false), "test")
+
+	// fake receiver
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, ":9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// start the logger
+	go g.Run()
+
+	// accept conn from logger
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// send fake dns message to logger
+	g.channel <- dnsutils.GetFakeDnsMessage()
+
+	// the json message is prefixed by its size
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	dm := dnsutils.DnsMessage{}
+	if err := json.Unmarshal(data, &dm); err != nil {
+		t.Fatal(err)
+	}
+	if dm.DNS.Qname != "dns.collector" {
+		t.Errorf("invalid qname: %s", dm.DNS.Qname)
+	}
+}
+
+func Test_TcpClientUdp(t *testing.T) {
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.TcpClient.Transport = dnsutils.SOCKET_UDP
+	cfg.Loggers.TcpClient.RemotePort = 9996
+	cfg.Loggers.TcpClient.FlushInterval = 1
+	cfg.Loggers.TcpClient.BufferSize = 0
+
+	// fake receiver
+	fakeRcvr, err := net.ListenPacket(dnsutils.SOCKET_UDP, "127.0.0.1:9996")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	g := NewTcpClient(cfg, logger.New(false), "test")
+
+	// start the logger and wait the connection
+	go g.Run()
+	time.Sleep(time.Second)
+
+	// one datagram per message
+	g.channel <- dnsutils.GetFakeDnsMessage()
+	g.channel <- dnsutils.GetFakeDnsMessage()
+
+	buf := make([]byte, 65535)
+	for i := 0; i < 2; i++ {
+		fakeRcvr.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := fakeRcvr.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm := dnsutils.DnsMessage{}
+		if err := json.Unmarshal(buf[:n], &dm); err != nil {
+			t.Fatalf("invalid datagram %s: %v", buf[:n], err)
+		}
+	}
+}
+
+func Test_TcpClientRetryBuffer(t *testing.T) {
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.TcpClient.RetryBufferSize = 2
+
+	g := NewTcpClient(cfg, logger.New(false), "test")
+
+	// the oldest messages are dropped when the retry buffer is full
+	for _, qname := range []string{"a.collector", "b.collector", "c.collector"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		g.Retain([]dnsutils.DnsMessage{dm})
+	}
+	if len(g.retryBuffer) != 2 || g.retryDropped != 1 {
+		t.Fatalf("invalid retry buffer: %d messages, %d dropped", len(g.retryBuffer), g.retryDropped)
+	}
+	if g.retryBuffer[0].DNS.Qname != "b.collector" || g.retryBuffer[1].DNS.Qname != "c.collector" {
+		t.Errorf("unexpected messages retained: %s, %s", g.retryBuffer[0].DNS.Qname, g.retryBuffer[1].DNS.Qname)
+	}
+}
+
+func Test_TcpClientFlushOnStop(t *testing.T) {
+	// fake receiver
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// init logger, the messages are kept in the buffer until the stop
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.TcpClient.RemotePort = fakeRcvr.Addr().(*net.TCPAddr).Port
+	cfg.Loggers.TcpClient.FlushInterval = 60
+	cfg.Loggers.TcpClient.BufferSize = 100
+	cfg.Loggers.TcpClient.Mode = dnsutils.MODE_JSON
+
+	g := NewTcpClient(cfg, logger.New(false), "test")
+	go g.Run()
+
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		g.Channel() <- dnsutils.GetFakeDnsMessage()
+	}
+	g.Stop()
+
+	// the buffered and pending messages are sent before the end
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Fatalf("message %d not received: %v", i+1, err)
+		}
+	}
+}
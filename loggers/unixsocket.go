@@ -0,0 +1,359 @@
+package loggers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	framestream "github.com/farsightsec/golang-framestream"
+)
+
+// UnixSocket writes the dns messages to a local unix socket, or to a named pipe on Windows,
+// to be consumed by a sidecar agent without network port, the connection is opened again
+// when the reader restarts
+type UnixSocket struct {
+	done               chan bool
+	configChan         chan *dnsutils.Config
+	channel            chan dnsutils.DnsMessage
+	config             *dnsutils.Config
+	logger             *logger.Logger
+	exit               chan bool
+	textFormat         []string
+	name               string
+	transportConn      io.WriteCloser
+	transportWriter    *bufio.Writer
+	dnstapWriter       *framestream.Encoder
+	transportReady     chan bool
+	transportReconnect chan bool
+	writerReady        bool
+	dropped            int
+}
+
+func NewUnixSocket(config *dnsutils.Config, logger *logger.Logger, name string) *UnixSocket {
+	logger.Info("[%s] logger unix socket - enabled", name)
+	o := &UnixSocket{
+		done:               make(chan bool),
+		configChan:         make(chan *dnsutils.Config, 1),
+		exit:               make(chan bool),
+		channel:            make(chan dnsutils.DnsMessage, 512),
+		transportReady:     make(chan bool),
+		transportReconnect: make(chan bool),
+		logger:             logger,
+		config:             config,
+		name:               name,
+	}
+	o.ReadConfig()
+	return o
+}
+
+func (c *UnixSocket) GetName() string { return c.name }
+
+func (c *UnixSocket) SetLoggers(loggers []dnsutils.Worker) {}
+
+func (o *UnixSocket) ReadConfig() {
+	cfg := o.config.Loggers.UnixSocket
+	if len(cfg.SockPath) == 0 {
+		o.logger.Fatal("logger unix socket - sock-path is required")
+	}
+
+	switch cfg.Mode {
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON, dnsutils.MODE_DNSTAP:
+	default:
+		o.logger.Fatal("logger unix socket - invalid mode: ", cfg.Mode)
+	}
+
+	switch cfg.Framing {
+	case dnsutils.FRAMING_DELIMITER, dnsutils.FRAMING_LENGTH:
+	default:
+		o.logger.Fatal("logger unix socket - invalid framing: ", cfg.Framing)
+	}
+
+	if len(cfg.TextFormat) > 0 {
+		o.textFormat = strings.Fields(cfg.TextFormat)
+	} else {
+		o.textFormat = strings.Fields(o.config.Global.TextFormat)
+	}
+}
+
+func (o *UnixSocket) ReloadConfig(config *dnsutils.Config) {
+	o.LogInfo("reload configuration...")
+	dnsutils.SendConfig(o.configChan, config)
+}
+
+func (o *UnixSocket) LogInfo(msg string, v ...interface{}) {
+	o.logger.Info("["+o.name+"] logger unix socket - "+msg, v...)
+}
+
+func (o *UnixSocket) LogError(msg string, v ...interface{}) {
+	o.logger.Error("["+o.name+"] logger unix socket - "+msg, v...)
+}
+
+func (o *UnixSocket) Channel() chan dnsutils.DnsMessage {
+	return o.channel
+}
+
+func (o *UnixSocket) Stop() {
+	o.LogInfo("stopping...")
+
+	// exit to close properly
+	o.exit <- true
+
+	// read done channel and block until run is terminated
+	<-o.done
+	close(o.done)
+}
+
+func (o *UnixSocket) Disconnect() {
+	if o.transportConn == nil {
+		return
+	}
+	// the dnstap stream is terminated by the stop frame
+	if o.writerReady && o.dnstapWriter != nil {
+		o.dnstapWriter.Close()
+	}
+	o.LogInfo("closing connection")
+	o.transportConn.Close()
+}
+
+func (o *UnixSocket) ConnectToRemote() {
+	path := o.config.Loggers.UnixSocket.SockPath
+	connTimeout := time.Duration(o.config.Loggers.UnixSocket.ConnectTimeout) * time.Second
+
+	// the interval between the retries is doubled after each failure
+	retryInterval := o.config.Loggers.UnixSocket.RetryInterval
+	maxRetryInterval := o.config.Loggers.UnixSocket.MaxRetryInterval
+	if maxRetryInterval < retryInterval {
+		maxRetryInterval = retryInterval
+	}
+
+	for {
+		if o.transportConn != nil {
+			o.transportConn.Close()
+			o.transportConn = nil
+		}
+
+		// make the connection
+		o.LogInfo("connecting to %s", path)
+		conn, err := DialLocalSocket(path, connTimeout)
+
+		// something is wrong during connection ?
+		if err != nil {
+			o.LogError("%s", err)
+			o.LogInfo("retry to connect in %d seconds", retryInterval)
+			time.Sleep(time.Duration(retryInterval) * time.Second)
+			retryInterval *= 2
+			if retryInterval > maxRetryInterval {
+				retryInterval = maxRetryInterval
+			}
+			continue
+		}
+
+		o.transportConn = conn
+		retryInterval = o.config.Loggers.UnixSocket.RetryInterval
+
+		// block until the writer is ready
+		o.transportReady <- true
+
+		// block until an error occured, need to reconnect
+		o.transportReconnect <- true
+	}
+}
+
+// InitWriter prepares the writer of the new connection, the dnstap stream is started
+// with the content type (unidirectional frame stream)
+func (o *UnixSocket) InitWriter() error {
+	if o.config.Loggers.UnixSocket.Mode != dnsutils.MODE_DNSTAP {
+		o.transportWriter = bufio.NewWriterSize(o.transportConn, 65535)
+		return nil
+	}
+
+	fsOptions := &framestream.EncoderOptions{ContentType: []byte("protobuf:dnstap.Dnstap"), Bidirectional: false}
+	writer, err := framestream.NewEncoder(o.transportConn, fsOptions)
+	if err != nil {
+		return err
+	}
+	o.dnstapWriter = writer
+	return nil
+}
+
+// Encode returns the payload of the message according to the mode
+func (o *UnixSocket) Encode(dm *dnsutils.DnsMessage) ([]byte, error) {
+	switch o.config.Loggers.UnixSocket.Mode {
+	case dnsutils.MODE_TEXT:
+		return dm.Bytes(o.textFormat, o.config.Global.TextFormatDelimiter, o.config.Global.TextFormatBoundary), nil
+	case dnsutils.MODE_JSON:
+		return json.Marshal(dm)
+	case dnsutils.MODE_FLATJSON:
+		flat, err := dm.Flatten()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(flat)
+	case dnsutils.MODE_DNSTAP:
+		return dm.ToDnstap()
+	}
+	return nil, fmt.Errorf("unsupported mode %s", o.config.Loggers.UnixSocket.Mode)
+}
+
+func (o *UnixSocket) WriteMessage(dm *dnsutils.DnsMessage) error {
+	data, err := o.Encode(dm)
+	if err != nil {
+		return err
+	}
+
+	// the dnstap messages are framed by the frame stream
+	if o.dnstapWriter != nil {
+		_, err := o.dnstapWriter.Write(data)
+		return err
+	}
+
+	// the messages are prefixed by their size on 4 bytes, in network byte order
+	if o.config.Loggers.UnixSocket.Framing == dnsutils.FRAMING_LENGTH {
+		o.transportWriter.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+		o.transportWriter.Write(data)
+		return nil
+	}
+
+	delimiter := o.config.Loggers.UnixSocket.PayloadDelimiter
+	o.transportWriter.Write(data)
+	o.transportWriter.WriteString(delimiter)
+	// the json messages are always terminated by a newline, as written by the json encoder
+	if o.config.Loggers.UnixSocket.Mode != dnsutils.MODE_TEXT && !strings.HasSuffix(delimiter, "\n") {
+		o.transportWriter.WriteString("\n")
+	}
+	return nil
+}
+
+func (o *UnixSocket) FlushBuffer(buf *[]dnsutils.DnsMessage) {
+	for i := range *buf {
+		if err := o.WriteMessage(&(*buf)[i]); err != nil {
+			o.LogError("encoding DNS message failed: %s", err)
+		}
+	}
+
+	var err error
+	if o.dnstapWriter != nil {
+		err = o.dnstapWriter.Flush()
+	} else {
+		err = o.transportWriter.Flush()
+	}
+	if err != nil {
+		o.LogError("send frame error: %s", err)
+		o.writerReady = false
+		o.dropped += len(*buf)
+		*buf = nil
+
+		<-o.transportReconnect
+		return
+	}
+
+	// reset buffer
+	*buf = nil
+}
+
+func (o *UnixSocket) Run() {
+	o.LogInfo("running in background...")
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DnsMessage{}
+	listChannel = append(listChannel, o.channel)
+	subprocessors := transformers.NewTransforms(&o.config.OutgoingTransformers, o.logger, o.name, listChannel)
+
+	// init buffer
+	bufferDm := []dnsutils.DnsMessage{}
+
+	// init flust timer for buffer
+	flushInterval := time.Duration(o.config.Loggers.UnixSocket.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+	// init remote conn
+	go o.ConnectToRemote()
+
+LOOP:
+	for {
+		select {
+		case cfg := <-o.configChan:
+			// reload the transformers, the messages in the channel are kept
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case <-o.exit:
+			o.logger.Info("closing loop...")
+			break LOOP
+
+		case <-o.transportReady:
+			o.dnstapWriter = nil
+			if err := o.InitWriter(); err != nil {
+				o.LogError("unable to start the stream: %s", err)
+				<-o.transportReconnect
+				continue
+			}
+			o.LogInfo("connected with success")
+			o.writerReady = true
+
+			if o.dropped > 0 {
+				o.LogError("%d messages dropped while disconnected", o.dropped)
+				o.dropped = 0
+			}
+
+		case dm := <-o.channel:
+			// drop dns message if the connection is not ready to avoid memory leak or
+			// to block the channel
+			if !o.writerReady {
+				o.dropped++
+				continue
+			}
+
+			// apply tranforms
+			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// append dns message to buffer
+			bufferDm = append(bufferDm, dm)
+
+			// buffer is full ?
+			if len(bufferDm) >= o.config.Loggers.UnixSocket.BufferSize {
+				o.FlushBuffer(&bufferDm)
+			}
+
+		// flush the buffer
+		case <-flushTimer.C:
+			if !o.writerReady {
+				o.dropped += len(bufferDm)
+				bufferDm = nil
+			} else if len(bufferDm) > 0 {
+				o.FlushBuffer(&bufferDm)
+			}
+
+			// restart timer
+			flushTimer.Reset(flushInterval)
+		}
+	}
+
+	// the messages received before the stop are sent
+	dnsutils.DrainChannel(o.channel, func(dm dnsutils.DnsMessage) {
+		if subprocessors.ProcessMessage(&dm) != transformers.RETURN_DROP {
+			bufferDm = append(bufferDm, dm)
+		}
+	})
+	if o.writerReady && len(bufferDm) > 0 {
+		o.FlushBuffer(&bufferDm)
+	}
+
+	o.LogInfo("run terminated")
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	// closing the connection if exist
+	o.Disconnect()
+
+	o.done <- true
+}
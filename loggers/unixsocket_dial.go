@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package loggers
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+// DialLocalSocket connects to the unix socket
+func DialLocalSocket(path string, timeout time.Duration) (io.WriteCloser, error) {
+	return net.DialTimeout(dnsutils.SOCKET_UNIX, path, timeout)
+}
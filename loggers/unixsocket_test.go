@@ -0,0 +1,244 @@
+package loggers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnstap-protobuf"
+	"github.com/dmachard/go-logger"
+	framestream "github.com/farsightsec/golang-framestream"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_UnixSocketRun(t *testing.T) {
+	testcases := []struct {
+		mode    string
+		framing string
+		pattern string
+	}{
+		{
+			mode:    dnsutils.MODE_TEXT,
+			framing: dnsutils.FRAMING_DELIMITER,
+			pattern: " dns.collector ",
+		},
+		{
+			mode:    dnsutils.MODE_JSON,
+			framing: dnsutils.FRAMING_DELIMITER,
+			pattern: "\"qname\":\"dns.collector\"",
+		},
+		{
+			mode:    dnsutils.MODE_FLATJSON,
+			framing: dnsutils.FRAMING_LENGTH,
+			pattern: "^{.*\"dns.qname\":\"dns.collector\".*}$",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.mode, func(t *testing.T) {
+			sockPath := filepath.Join(t.TempDir(), "dnscollector.sock")
+
+			// init logger
+			cfg := dnsutils.GetFakeConfig()
+			cfg.Loggers.UnixSocket.SockPath = sockPath
+			cfg.Loggers.UnixSocket.BufferSize = 0
+			cfg.Loggers.UnixSocket.Mode = tc.mode
+			cfg.Loggers.UnixSocket.Framing = tc.framing
+
+			g := NewUnixSocket(cfg, logger.New(false), "test")
+
+			// fake receiver
+			fakeRcvr, err := net.Listen(dnsutils.SOCKET_UNIX, sockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fakeRcvr.Close()
+
+			// start the logger
+			go g.Run()
+
+			// accept conn from logger
+			conn, err := fakeRcvr.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// wait connection on logger
+			time.Sleep(time.Second)
+
+			// send fake dns message to logger
+			g.Channel() <- dnsutils.GetFakeDnsMessage()
+
+			// read data on server side
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+			var data []byte
+			if tc.framing == dnsutils.FRAMING_LENGTH {
+				size := make([]byte, 4)
+				if _, err := io.ReadFull(reader, size); err != nil {
+					t.Fatal(err)
+				}
+				data = make([]byte, binary.BigEndian.Uint32(size))
+				_, err = io.ReadFull(reader, data)
+			} else {
+				data, err = reader.ReadBytes('\n')
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pattern := regexp.MustCompile(tc.pattern)
+			if !pattern.Match(data) {
+				t.Errorf("message not matching: %s", data)
+			}
+		})
+	}
+}
+
+func Test_UnixSocketJsonDelimiter(t *testing.T) {
+	testcases := []struct {
+		delimiter string
+		want      string
+	}{
+		{delimiter: "\n", want: "}\n{"},
+		{delimiter: "\r\n", want: "}\r\n{"},
+		{delimiter: "", want: "}\n{"},
+		{delimiter: ";", want: "};\n{"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.delimiter, func(t *testing.T) {
+			cfg := dnsutils.GetFakeConfig()
+			cfg.Loggers.UnixSocket.SockPath = "/tmp/test.sock"
+			cfg.Loggers.UnixSocket.Mode = dnsutils.MODE_JSON
+			cfg.Loggers.UnixSocket.PayloadDelimiter = tc.delimiter
+
+			g := NewUnixSocket(cfg, logger.New(false), "test")
+
+			var buf bytes.Buffer
+			g.transportWriter = bufio.NewWriter(&buf)
+			for i := 0; i < 2; i++ {
+				dm := dnsutils.GetFakeDnsMessage()
+				if err := g.WriteMessage(&dm); err != nil {
+					t.Fatal(err)
+				}
+			}
+			g.transportWriter.Flush()
+
+			// the records are separated by the delimiter only, without empty line
+			if !strings.Contains(buf.String(), tc.want) || strings.Count(buf.String(), "\n") != 2 {
+				t.Errorf("unexpected records: %q", buf.String())
+			}
+		})
+	}
+}
+
+func Test_UnixSocketDnstap(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "dnscollector.sock")
+
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.UnixSocket.SockPath = sockPath
+	cfg.Loggers.UnixSocket.BufferSize = 0
+	cfg.Loggers.UnixSocket.Mode = dnsutils.MODE_DNSTAP
+
+	g := NewUnixSocket(cfg, logger.New(false), "test")
+
+	// fake dnstap receiver
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_UNIX, sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	go g.Run()
+
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// the stream is started with the dnstap content type
+	decoder, err := framestream.NewDecoder(conn, &framestream.DecoderOptions{ContentType: []byte("protobuf:dnstap.Dnstap")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Channel() <- dnsutils.GetFakeDnsMessage()
+
+	frame, err := decoder.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := &dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, dt); err != nil {
+		t.Fatal(err)
+	}
+	if string(dt.GetIdentity()) != "collector" {
+		t.Errorf("invalid dnstap identity: %s", dt.GetIdentity())
+	}
+}
+
+func Test_UnixSocketReconnect(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "dnscollector.sock")
+
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.UnixSocket.SockPath = sockPath
+	cfg.Loggers.UnixSocket.BufferSize = 0
+	cfg.Loggers.UnixSocket.RetryInterval = 1
+
+	g := NewUnixSocket(cfg, logger.New(false), "test")
+
+	// start the logger before the receiver
+	go g.Run()
+	time.Sleep(500 * time.Millisecond)
+
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_UNIX, sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// the receiver is restarted after the first connection
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	conn.Close()
+
+	// the first writes fail and trigger the reconnection
+	deadline := time.Now().Add(10 * time.Second)
+	fakeRcvr.(*net.UnixListener).SetDeadline(deadline)
+	go func() {
+		for time.Now().Before(deadline) {
+			g.Channel() <- dnsutils.GetFakeDnsMessage()
+			time.Sleep(200 * time.Millisecond)
+		}
+	}()
+
+	conn, err = fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(deadline)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile("\"qname\":\"dns.collector\"").MatchString(line) {
+		t.Errorf("message not matching after reconnection: %s", line)
+	}
+}
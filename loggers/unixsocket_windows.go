@@ -0,0 +1,53 @@
+//go:build windows
+// +build windows
+
+package loggers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"golang.org/x/sys/windows"
+)
+
+// interval between two attempts to open a busy named pipe
+const pipeBusyRetryInterval = 10 * time.Millisecond
+
+// DialLocalSocket opens the named pipe if the path starts with \\.\pipe\, the unix
+// sockets are supported since Windows 10
+func DialLocalSocket(path string, timeout time.Duration) (io.WriteCloser, error) {
+	if strings.HasPrefix(strings.ToLower(path), `\\.\pipe\`) {
+		return DialPipe(path, timeout)
+	}
+	return net.DialTimeout(dnsutils.SOCKET_UNIX, path, timeout)
+}
+
+// DialPipe opens the named pipe for writing, the opening is retried while all the
+// instances of the pipe are busy, until the timeout. There is no timeout if zero, as with net.Dial
+func DialPipe(path string, timeout time.Duration) (io.WriteCloser, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		h, err := windows.CreateFile(name, windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+		if err == nil {
+			return os.NewFile(uintptr(h), path), nil
+		}
+		if !errors.Is(err, windows.ERROR_PIPE_BUSY) {
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("open %s: timeout, the pipe is busy", path)
+		}
+		time.Sleep(pipeBusyRetryInterval)
+	}
+}
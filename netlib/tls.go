@@ -0,0 +1,20 @@
+package netlib
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// LoadCertPool returns a pool with the certificates authorities of the pem file
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	caCert, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("no certificate found in " + caFile)
+	}
+	return pool, nil
+}
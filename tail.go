@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// colors of the messages according to the rcode
+const (
+	colorReset  = "\033[0m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+)
+
+type tailOptions struct {
+	url        string
+	user       string
+	password   string
+	token      string
+	insecure   bool
+	queryName  string
+	queryIp    string
+	rcode      string
+	expression string
+	format     string
+	json       bool
+	noColor    bool
+}
+
+// tailPrinter prints the messages matching the filters, the websocket filters are applied by
+// the rest api and the expression by the grpc server, the other ones are applied locally
+type tailPrinter struct {
+	out    io.Writer
+	opts   tailOptions
+	filter *loggers.EventFilter
+	expr   *dnsutils.Expression
+	format []string
+	color  bool
+}
+
+func (p *tailPrinter) Print(dm *dnsutils.DnsMessage) {
+	if p.filter != nil && !p.filter.Match(dm) {
+		return
+	}
+	if p.expr != nil && !p.expr.Match(dm) {
+		return
+	}
+
+	var line string
+	if p.opts.json {
+		data, _ := json.Marshal(dm)
+		line = string(data)
+	} else {
+		line = dm.String(p.format, " ", "\"")
+	}
+
+	if !p.color || dm.DNS.Type != dnsutils.DnsReply {
+		fmt.Fprintln(p.out, line)
+		return
+	}
+	color := colorRed
+	switch dm.DNS.Rcode {
+	case dnsutils.DNS_RCODE_NOERROR:
+		color = colorGreen
+	case dnsutils.DNS_RCODE_NXDOMAIN:
+		color = colorYellow
+	}
+	fmt.Fprintln(p.out, color+line+colorReset)
+}
+
+func tailWebsocket(ctx context.Context, opts tailOptions, printer *tailPrinter) error {
+	u, err := url.Parse(opts.url)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	for key, value := range map[string]string{"query_name": opts.queryName, "query_ip": opts.queryIp, "rcode": opts.rcode} {
+		if len(value) > 0 {
+			query.Set(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	origin := "http://" + u.Host
+	if u.Scheme == "wss" {
+		origin = "https://" + u.Host
+	}
+	wsConfig, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return err
+	}
+	wsConfig.TlsConfig = &tls.Config{InsecureSkipVerify: opts.insecure}
+	if len(opts.user) > 0 {
+		wsConfig.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(opts.user+":"+opts.password)))
+	}
+
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	for {
+		var dm dnsutils.DnsMessage
+		if err := websocket.JSON.Receive(ws, &dm); err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		printer.Print(&dm)
+	}
+}
+
+func tailGrpc(ctx context.Context, opts tailOptions, printer *tailPrinter) error {
+	u, err := url.Parse(opts.url)
+	if err != nil {
+		return err
+	}
+
+	creds := insecure.NewCredentials()
+	if u.Scheme == "grpcs" {
+		creds = credentials.NewTLS(&tls.Config{InsecureSkipVerify: opts.insecure})
+	}
+	conn, err := grpc.DialContext(ctx, u.Host, grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(loggers.GrpcCodec{})))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if len(opts.token) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+opts.token)
+	}
+	stream, err := loggers.GrpcSubscribe(ctx, conn, opts.expression)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var payload []byte
+		if err := stream.RecvMsg(&payload); err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		dm, err := dnsutils.FromProtobuf(payload)
+		if err != nil {
+			return err
+		}
+		printer.Print(&dm)
+	}
+}
+
+// runTail connects to the live stream of a running instance and prints the messages
+func runTail(args []string) int {
+	opts := tailOptions{}
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.StringVar(&opts.url, "url", "ws://127.0.0.1:8080/tail", "websocket url of the rest api (ws|wss) or address of the grpc server (grpc|grpcs)")
+	fs.StringVar(&opts.user, "user", "", "login of the rest api")
+	fs.StringVar(&opts.password, "password", "", "password of the rest api")
+	fs.StringVar(&opts.token, "token", "", "token of the grpc server")
+	fs.BoolVar(&opts.insecure, "insecure", false, "skip the verification of the server certificate")
+	fs.StringVar(&opts.queryName, "query-name", "", "query name to follow, *.example.com matches all subdomains")
+	fs.StringVar(&opts.queryIp, "query-ip", "", "query ip or subnet to follow")
+	fs.StringVar(&opts.rcode, "rcode", "", "return code to follow")
+	fs.StringVar(&opts.expression, "filter", "", "filtering expression")
+	fs.StringVar(&opts.format, "format", "", "output text format, default to the global text format")
+	fs.BoolVar(&opts.json, "json", false, "print the messages in json")
+	fs.BoolVar(&opts.noColor, "no-color", false, "disable the colors")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	printer := &tailPrinter{out: os.Stdout, opts: opts}
+
+	// text format
+	config := &dnsutils.Config{}
+	config.SetDefault()
+	if len(opts.format) == 0 {
+		opts.format = config.Global.TextFormat
+	}
+	printer.format = strings.Fields(opts.format)
+
+	// colors only on a terminal
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		printer.color = !opts.noColor
+	}
+
+	scheme := strings.SplitN(opts.url, "://", 2)[0]
+	isGrpc := scheme == "grpc" || scheme == "grpcs"
+	if !isGrpc && scheme != "ws" && scheme != "wss" {
+		fmt.Fprintf(os.Stderr, "tail: unsupported url %s\n", opts.url)
+		return 2
+	}
+
+	// the filters not supported by the server are applied locally
+	if isGrpc {
+		filter := &loggers.EventFilter{Qname: opts.queryName, QueryIp: opts.queryIp, Rcode: opts.rcode}
+		if err := filter.Prepare(); err != nil {
+			fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+			return 2
+		}
+		printer.filter = filter
+	} else if len(opts.expression) > 0 {
+		expr, err := dnsutils.ParseExpression(opts.expression)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+			return 2
+		}
+		printer.expr = expr
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	if isGrpc {
+		err = tailGrpc(ctx, opts, printer)
+	} else {
+		err = tailWebsocket(ctx, opts, printer)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package transformers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+const (
+	ALERT_UNANSWERED_BURST = "unanswered-burst"
+	ALERT_SERVFAIL_BURST   = "servfail-burst"
+)
+
+// alerting processor, counts the unanswered queries and the SERVFAIL responses per domain
+// and per client during a window, an alert is sent once per window when a threshold is crossed
+type AlertingProcessor struct {
+	sync.Mutex
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	outChannels []chan dnsutils.DnsMessage
+	counters    map[string]int
+	stop        chan bool
+}
+
+func NewAlertingSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *AlertingProcessor {
+	s := AlertingProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		outChannels: outChannels,
+		counters:    make(map[string]int),
+		stop:        make(chan bool),
+	}
+	return &s
+}
+
+// groupKey returns the domain or the client of the message
+func (s *AlertingProcessor) groupKey(dm *dnsutils.DnsMessage, groupBy string) string {
+	if groupBy == dnsutils.ALERT_GROUP_BY_CLIENT {
+		return dm.NetworkInfo.QueryIp
+	}
+	qname := strings.ToLower(strings.TrimSuffix(dm.DNS.Qname, "."))
+	domain, err := dnsutils.GetEffectiveTLDPlusOne(qname)
+	if err != nil {
+		return qname
+	}
+	return domain
+}
+
+// Record counts the unanswered query or the SERVFAIL response, the alerts of
+// the thresholds crossed are sent
+func (s *AlertingProcessor) Record(dm *dnsutils.DnsMessage) {
+	// alert already sent
+	if dm.Alert != nil {
+		return
+	}
+
+	var name string
+	var threshold int
+	switch {
+	case dm.DNS.Rcode == dnsutils.DNS_RCODE_TIMEOUT:
+		name, threshold = ALERT_UNANSWERED_BURST, s.config.Alerting.UnansweredThreshold
+	case dm.DNS.Rcode == dnsutils.DNS_RCODE_SERVFAIL && dm.DNS.Type == dnsutils.DnsReply:
+		name, threshold = ALERT_SERVFAIL_BURST, s.config.Alerting.ServfailThreshold
+	default:
+		return
+	}
+	if threshold <= 0 {
+		return
+	}
+
+	alerts := []dnsutils.DnsMessage{}
+	s.Lock()
+	for _, groupBy := range s.config.Alerting.GroupBy {
+		key := s.groupKey(dm, groupBy)
+		counter := name + "+" + groupBy + "+" + key
+		s.counters[counter]++
+
+		// only one alert per window
+		if s.counters[counter] != threshold {
+			continue
+		}
+		alert := *dm
+		alert.DnsTap.Operation = dnsutils.OPERATION_ALERT
+		alert.Alert = &dnsutils.TransformAlert{
+			Name:      name,
+			GroupBy:   groupBy,
+			Key:       key,
+			Threshold: threshold,
+			Window:    s.config.Alerting.Window,
+		}
+		alerts = append(alerts, alert)
+	}
+	s.Unlock()
+
+	for _, alert := range alerts {
+		s.logger.Info("["+s.name+"] subprocessor alerting - %s of %s %s", alert.Alert.Name, alert.Alert.GroupBy, alert.Alert.Key)
+		for i := range s.outChannels {
+			s.outChannels[i] <- alert
+		}
+	}
+}
+
+// Reset starts a new window
+func (s *AlertingProcessor) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.counters = make(map[string]int)
+}
+
+func (s *AlertingProcessor) Run() {
+	ticker := time.NewTicker(time.Duration(s.config.Alerting.Window) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Reset()
+		}
+	}
+}
+
+func (s *AlertingProcessor) Stop() {
+	s.stop <- true
+}
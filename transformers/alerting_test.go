@@ -0,0 +1,92 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestAlerting_ServfailBurst(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Alerting.Enable = true
+	config.Alerting.ServfailThreshold = 3
+	config.Alerting.GroupBy = []string{dnsutils.ALERT_GROUP_BY_DOMAIN}
+
+	// init subproccesor
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	alerting := NewAlertingSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+
+	// the queries and the other rcodes are not counted
+	query := dnsutils.GetFakeDnsMessage()
+	query.DNS.Rcode = dnsutils.DNS_RCODE_SERVFAIL
+	alerting.Record(&query)
+
+	for i := 0; i < 5; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Type = dnsutils.DnsReply
+		dm.DNS.Qname = "www.example.com"
+		dm.DNS.Rcode = dnsutils.DNS_RCODE_SERVFAIL
+		alerting.Record(&dm)
+	}
+
+	if len(outChan) != 1 {
+		t.Fatalf("one alert expected, got %d", len(outChan))
+	}
+	alert := <-outChan
+	if alert.DnsTap.Operation != dnsutils.OPERATION_ALERT || alert.Alert.Name != ALERT_SERVFAIL_BURST || alert.Alert.Key != "example.com" {
+		t.Errorf("invalid alert: %s %+v", alert.DnsTap.Operation, alert.Alert)
+	}
+
+	// new window
+	alerting.Reset()
+	for i := 0; i < 3; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Type = dnsutils.DnsReply
+		dm.DNS.Rcode = dnsutils.DNS_RCODE_SERVFAIL
+		alerting.Record(&dm)
+	}
+	if len(outChan) != 1 {
+		t.Errorf("one alert expected in the new window, got %d", len(outChan))
+	}
+}
+
+func TestAlerting_UnansweredBurst(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.UnansweredQueries = true
+	config.Latency.QueriesTimeout = 1
+	config.Alerting.Enable = true
+	config.Alerting.UnansweredThreshold = 2
+	config.Alerting.GroupBy = []string{dnsutils.ALERT_GROUP_BY_CLIENT}
+
+	// the unanswered queries of the latency transformer are counted
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	transforms := NewTransforms(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+	defer transforms.Reset()
+
+	for _, port := range []string{"53000", "53001"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.QueryPort = port
+		transforms.ProcessMessage(&dm)
+	}
+
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case dm := <-outChan:
+			if dm.Alert == nil {
+				continue
+			}
+			if dm.Alert.Name != ALERT_UNANSWERED_BURST || dm.Alert.Key != dm.NetworkInfo.QueryIp {
+				t.Errorf("invalid alert: %+v", dm.Alert)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("no alert")
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package transformers
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+const ALERT_RATE_ANOMALY = "rate-anomaly"
+
+// learned rate of a client or a domain
+type RateBaseline struct {
+	Baseline float64 `json:"baseline"`
+	Samples  int     `json:"samples"`
+	qps      float64
+	count    int
+	dm       dnsutils.DnsMessage
+}
+
+// state of the baselines saved on disk, loaded at startup
+type baselineState struct {
+	Saved     string                   `json:"saved"`
+	Baselines map[string]*RateBaseline `json:"baselines"`
+}
+
+// baselineStore holds the baselines of a worker, shared by the instances of the transformer
+// (one per connection and per decoding worker) to be learned and saved by a single routine
+type baselineStore struct {
+	sync.Mutex
+	baselines map[string]*RateBaseline
+	instances []*BaselineProcessor
+	skipped   int
+	stop      chan bool
+}
+
+var (
+	baselineStoresLock sync.Mutex
+	baselineStores     = make(map[string]*baselineStore)
+)
+
+// baseline processor, learns the rate of each client and domain with an exponentially
+// weighted moving average of the rates of the intervals, a rate higher than x-times the
+// baseline is an anomaly. The messages are annotated with the rates of the last interval
+// and an alert record is sent for each anomaly.
+type BaselineProcessor struct {
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	outChannels []chan dnsutils.DnsMessage
+	alpha       float64
+	store       *baselineStore
+}
+
+func NewBaselineSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *BaselineProcessor {
+	// smoothing factor of the average on the number of intervals of the horizon
+	alpha := 1.0
+	if config.Baseline.Interval > 0 && config.Baseline.Horizon > config.Baseline.Interval {
+		alpha = 2 / (float64(config.Baseline.Horizon)/float64(config.Baseline.Interval) + 1)
+	}
+
+	s := BaselineProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		outChannels: outChannels,
+		alpha:       alpha,
+		store:       &baselineStore{baselines: make(map[string]*RateBaseline), stop: make(chan bool)},
+	}
+	return &s
+}
+
+func (s *BaselineProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor baseline - "+msg, v...)
+}
+
+func (s *BaselineProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor baseline - "+msg, v...)
+}
+
+// groupKey returns the domain or the client of the message
+func (s *BaselineProcessor) groupKey(dm *dnsutils.DnsMessage, groupBy string) string {
+	if groupBy == dnsutils.ALERT_GROUP_BY_CLIENT {
+		return dm.NetworkInfo.QueryIp
+	}
+	qname := strings.ToLower(strings.TrimSuffix(dm.DNS.Qname, "."))
+	domain, err := dnsutils.GetEffectiveTLDPlusOne(qname)
+	if err != nil {
+		return qname
+	}
+	return domain
+}
+
+// ratio returns the rate of the last interval divided by the baseline, zero during the learning
+func (s *BaselineProcessor) ratio(b *RateBaseline) float64 {
+	if b.Samples < s.config.Baseline.MinSamples || b.Baseline <= 0 {
+		return 0
+	}
+	return math.Round(b.qps/b.Baseline*100) / 100
+}
+
+// isAnomaly returns true if the rate of the last interval deviates from the baseline
+func (s *BaselineProcessor) isAnomaly(b *RateBaseline) bool {
+	return b.qps >= s.config.Baseline.MinQps && s.ratio(b) >= s.config.Baseline.Threshold
+}
+
+// Record counts the message for its client and its domain, the message is annotated
+// with their rates of the last interval if enabled
+func (s *BaselineProcessor) Record(dm *dnsutils.DnsMessage) {
+	// the records of the transformers are not counted
+	if dm.Alert != nil || dm.Statistics != nil {
+		return
+	}
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	var annotation *dnsutils.TransformBaseline
+	if s.config.Baseline.Annotate {
+		annotation = &dnsutils.TransformBaseline{}
+	}
+
+	for _, groupBy := range s.config.Baseline.GroupBy {
+		key := s.groupKey(dm, groupBy)
+		if key == "-" || len(key) == 0 {
+			continue
+		}
+		b, exists := s.store.baselines[groupBy+"+"+key]
+		if !exists {
+			// no new client or domain when the capacity is reached, room is made
+			// at the end of the interval
+			if len(s.store.baselines) >= s.config.Baseline.Capacity {
+				s.store.skipped++
+				continue
+			}
+			b = &RateBaseline{}
+			s.store.baselines[groupBy+"+"+key] = b
+		}
+		b.count++
+		if s.config.Baseline.Alerts {
+			b.dm = *dm
+		}
+
+		if annotation == nil {
+			continue
+		}
+		if groupBy == dnsutils.ALERT_GROUP_BY_CLIENT {
+			annotation.ClientQps, annotation.ClientBaseline, annotation.ClientRatio = b.qps, b.Baseline, s.ratio(b)
+		} else {
+			annotation.DomainQps, annotation.DomainBaseline, annotation.DomainRatio = b.qps, b.Baseline, s.ratio(b)
+		}
+		if s.isAnomaly(b) {
+			annotation.Anomaly = true
+		}
+	}
+
+	if annotation != nil {
+		dm.Baseline = annotation
+	}
+}
+
+// Flush closes the interval, the rates are compared to the baselines before being
+// learned and an alert is sent for each anomaly
+func (s *BaselineProcessor) Flush() {
+	interval := float64(s.config.Baseline.Interval)
+	alerts := []dnsutils.DnsMessage{}
+
+	s.store.Lock()
+	for key, b := range s.store.baselines {
+		b.qps = math.Round(float64(b.count)/interval*1000) / 1000
+
+		if s.config.Baseline.Alerts && b.count > 0 && s.isAnomaly(b) {
+			groupBy, value, _ := strings.Cut(key, "+")
+			alert := b.dm
+			alert.DnsTap.Operation = dnsutils.OPERATION_ALERT
+			alert.Alert = &dnsutils.TransformAlert{
+				Name:      ALERT_RATE_ANOMALY,
+				GroupBy:   groupBy,
+				Key:       value,
+				Threshold: int(math.Ceil(b.Baseline * s.config.Baseline.Threshold * interval)),
+				Window:    s.config.Baseline.Interval,
+			}
+			alert.Baseline = &dnsutils.TransformBaseline{Anomaly: true}
+			if groupBy == dnsutils.ALERT_GROUP_BY_CLIENT {
+				alert.Baseline.ClientQps, alert.Baseline.ClientBaseline, alert.Baseline.ClientRatio = b.qps, b.Baseline, s.ratio(b)
+			} else {
+				alert.Baseline.DomainQps, alert.Baseline.DomainBaseline, alert.Baseline.DomainRatio = b.qps, b.Baseline, s.ratio(b)
+			}
+			alerts = append(alerts, alert)
+		}
+
+		// learn the rate of the interval
+		if b.Samples == 0 {
+			b.Baseline = b.qps
+		} else {
+			b.Baseline = s.alpha*b.qps + (1-s.alpha)*b.Baseline
+		}
+		b.Samples++
+		b.count = 0
+		b.dm = dnsutils.DnsMessage{}
+
+		// forget the clients and the domains without traffic for a long time
+		if b.Baseline < 0.0001 && b.qps == 0 {
+			delete(s.store.baselines, key)
+		}
+	}
+	s.evict()
+
+	// the alerts are sent by an instance still attached, the channels of the
+	// connections already closed are not read anymore
+	outChannels := s.outChannels
+	if len(s.store.instances) > 0 {
+		outChannels = s.store.instances[0].outChannels
+	}
+	s.store.Unlock()
+
+	for _, alert := range alerts {
+		s.LogInfo("%s of %s %s", alert.Alert.Name, alert.Alert.GroupBy, alert.Alert.Key)
+		for i := range outChannels {
+			outChannels[i] <- alert
+		}
+	}
+}
+
+// evict forgets the clients and the domains with the lowest baselines when new ones have been
+// skipped because the capacity is reached, a tenth of the capacity is freed for the new ones
+func (s *BaselineProcessor) evict() {
+	if s.store.skipped == 0 {
+		return
+	}
+	s.store.skipped = 0
+
+	count := len(s.store.baselines) - s.config.Baseline.Capacity*9/10
+	if count <= 0 {
+		return
+	}
+	keys := make([]string, 0, len(s.store.baselines))
+	for key := range s.store.baselines {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.store.baselines[keys[i]].Baseline < s.store.baselines[keys[j]].Baseline
+	})
+	for _, key := range keys[:count] {
+		delete(s.store.baselines, key)
+	}
+	s.LogInfo("capacity reached, %d clients and domains with the lowest rates forgotten", count)
+}
+
+// LoadState restores the baselines saved by a previous run, nothing is done without state file
+func (s *BaselineProcessor) LoadState() error {
+	if len(s.config.Baseline.StateFile) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(s.config.Baseline.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	state := baselineState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	s.store.Lock()
+	defer s.store.Unlock()
+	for key, b := range state.Baselines {
+		if b == nil || len(s.store.baselines) >= s.config.Baseline.Capacity {
+			continue
+		}
+		s.store.baselines[key] = b
+	}
+	s.LogInfo("%d baselines loaded from %s", len(s.store.baselines), s.config.Baseline.StateFile)
+	return nil
+}
+
+// SaveState writes the baselines in the state file, the file is replaced when complete
+func (s *BaselineProcessor) SaveState() error {
+	if len(s.config.Baseline.StateFile) == 0 {
+		return nil
+	}
+
+	s.store.Lock()
+	data, err := json.Marshal(baselineState{
+		Saved:     time.Now().UTC().Format(time.RFC3339),
+		Baselines: s.store.baselines,
+	})
+	s.store.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// the temporary file is unique, the state file can be shared by several workers
+	path := s.config.Baseline.StateFile
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Start attaches the instance to the store of the worker, the first instance loads the
+// state and runs the learning, the next ones only count their messages
+func (s *BaselineProcessor) Start() {
+	baselineStoresLock.Lock()
+	defer baselineStoresLock.Unlock()
+
+	if store, ok := baselineStores[s.name]; ok {
+		s.store = store
+	} else {
+		baselineStores[s.name] = s.store
+	}
+	s.store.Lock()
+	s.store.instances = append(s.store.instances, s)
+	first := len(s.store.instances) == 1
+	s.store.Unlock()
+	if !first {
+		return
+	}
+
+	if err := s.LoadState(); err != nil {
+		s.LogError("unable to load the state: %v", err)
+	}
+	go s.Run()
+}
+
+func (s *BaselineProcessor) Run() {
+	ticker := time.NewTicker(time.Duration(s.config.Baseline.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.store.stop:
+			if err := s.SaveState(); err != nil {
+				s.LogError("unable to save the state: %v", err)
+			}
+			s.store.stop <- true
+			return
+		case <-ticker.C:
+			s.Flush()
+			if err := s.SaveState(); err != nil {
+				s.LogError("unable to save the state: %v", err)
+			}
+		}
+	}
+}
+
+// Stop detaches the instance, the last one stops the learning and waits the state to be saved
+func (s *BaselineProcessor) Stop() {
+	baselineStoresLock.Lock()
+	defer baselineStoresLock.Unlock()
+
+	s.store.Lock()
+	for i, instance := range s.store.instances {
+		if instance == s {
+			s.store.instances = append(s.store.instances[:i], s.store.instances[i+1:]...)
+			break
+		}
+	}
+	remaining := len(s.store.instances)
+	s.store.Unlock()
+	if remaining > 0 {
+		return
+	}
+	if baselineStores[s.name] == s.store {
+		delete(baselineStores, s.name)
+	}
+	s.store.stop <- true
+	<-s.store.stop
+}
@@ -0,0 +1,196 @@
+package transformers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func recordBaseline(s *BaselineProcessor, qname string, n int) {
+	for i := 0; i < n; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		s.Record(&dm)
+	}
+}
+
+func TestBaseline_RateAnomaly(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Baseline.Enable = true
+	config.Baseline.Interval = 1
+	config.Baseline.Horizon = 10
+	config.Baseline.MinSamples = 3
+	config.Baseline.GroupBy = []string{dnsutils.ALERT_GROUP_BY_DOMAIN}
+
+	// init subproccesor
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	baseline := NewBaselineSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+
+	// learning, no alert even if the rate changes
+	for i := 0; i < 3; i++ {
+		recordBaseline(baseline, "www.example.com", 10*(i+1))
+		baseline.Flush()
+	}
+	if len(outChan) != 0 {
+		t.Fatalf("no alert expected during the learning, got %d", len(outChan))
+	}
+
+	// normal rate
+	recordBaseline(baseline, "www.example.com", 20)
+	baseline.Flush()
+	if len(outChan) != 0 {
+		t.Fatalf("no alert expected, got %d", len(outChan))
+	}
+
+	// rate higher than 5 times the baseline
+	recordBaseline(baseline, "www.example.com", 200)
+	baseline.Flush()
+	if len(outChan) != 1 {
+		t.Fatalf("one alert expected, got %d", len(outChan))
+	}
+	alert := <-outChan
+	if alert.DnsTap.Operation != dnsutils.OPERATION_ALERT || alert.Alert.Name != ALERT_RATE_ANOMALY || alert.Alert.Key != "example.com" {
+		t.Errorf("invalid alert: %s %+v", alert.DnsTap.Operation, alert.Alert)
+	}
+	if alert.Baseline == nil || !alert.Baseline.Anomaly || alert.Baseline.DomainQps != 200 || alert.Baseline.DomainRatio < 5 {
+		t.Errorf("invalid baseline: %+v", alert.Baseline)
+	}
+}
+
+func TestBaseline_Annotate(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Baseline.Enable = true
+	config.Baseline.Interval = 1
+	config.Baseline.MinSamples = 1
+	config.Baseline.Alerts = false
+	config.Baseline.Annotate = true
+
+	// init subproccesor
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	baseline := NewBaselineSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+
+	recordBaseline(baseline, "www.example.com", 2)
+	baseline.Flush()
+	recordBaseline(baseline, "www.example.com", 20)
+	baseline.Flush()
+
+	// the message is annotated with the rates of the last interval
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "www.example.com"
+	baseline.Record(&dm)
+	if dm.Baseline == nil || !dm.Baseline.Anomaly || dm.Baseline.ClientQps != 20 || dm.Baseline.DomainRatio != 10 {
+		t.Errorf("invalid annotation: %+v", dm.Baseline)
+	}
+	if len(outChan) != 0 {
+		t.Errorf("alerts are disabled, got %d", len(outChan))
+	}
+}
+
+func TestBaseline_State(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Baseline.Enable = true
+	config.Baseline.Interval = 1
+	config.Baseline.StateFile = filepath.Join(t.TempDir(), "baseline.json")
+
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	baseline := NewBaselineSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+
+	// nothing to load at the first start
+	if err := baseline.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	recordBaseline(baseline, "www.example.com", 10)
+	baseline.Flush()
+	if err := baseline.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the baselines are restored after a restart
+	restarted := NewBaselineSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+	if err := restarted.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	b, ok := restarted.store.baselines[dnsutils.ALERT_GROUP_BY_DOMAIN+"+example.com"]
+	if !ok || b.Baseline != 10 || b.Samples != 1 {
+		t.Errorf("baseline not restored: %+v", b)
+	}
+	if _, ok := restarted.store.baselines[dnsutils.ALERT_GROUP_BY_CLIENT+"+1.2.3.4"]; !ok {
+		t.Errorf("client baseline not restored")
+	}
+}
+
+func TestBaseline_SharedStore(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Baseline.Enable = true
+	config.Baseline.Interval = 60
+	config.Baseline.StateFile = filepath.Join(t.TempDir(), "baseline.json")
+
+	// two instances of the same worker, for two connections
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	first := NewBaselineSubprocessor(config, logger.New(false), "shared", []chan dnsutils.DnsMessage{outChan})
+	second := NewBaselineSubprocessor(config, logger.New(false), "shared", []chan dnsutils.DnsMessage{outChan})
+	first.Start()
+	second.Start()
+
+	recordBaseline(first, "www.example.com", 10)
+	recordBaseline(second, "www.example.com", 20)
+	first.Flush()
+
+	b, ok := second.store.baselines[dnsutils.ALERT_GROUP_BY_DOMAIN+"+example.com"]
+	if first.store != second.store || !ok || b.Samples != 1 || b.qps != 0.5 {
+		t.Errorf("baselines not shared: %+v", b)
+	}
+
+	// the state is saved when the last instance is stopped
+	first.Stop()
+	if _, err := os.Stat(config.Baseline.StateFile); err == nil {
+		t.Errorf("state saved before the last instance stopped")
+	}
+	second.Stop()
+	if _, err := os.Stat(config.Baseline.StateFile); err != nil {
+		t.Errorf("state not saved: %v", err)
+	}
+}
+
+func TestBaseline_Capacity(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Baseline.Enable = true
+	config.Baseline.Interval = 1
+	config.Baseline.Capacity = 10
+	config.Baseline.GroupBy = []string{dnsutils.ALERT_GROUP_BY_DOMAIN}
+
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	baseline := NewBaselineSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+
+	// the capacity is reached, the busiest domain has the highest rate
+	recordBaseline(baseline, "busy.com", 100)
+	for i := 0; i < 9; i++ {
+		recordBaseline(baseline, fmt.Sprintf("idle%d.com", i), 1)
+	}
+	recordBaseline(baseline, "new.com", 1)
+	if _, ok := baseline.store.baselines[dnsutils.ALERT_GROUP_BY_DOMAIN+"+new.com"]; ok {
+		t.Fatalf("no new domain expected when the capacity is reached")
+	}
+
+	// the lowest rates are forgotten at the end of the interval, the new domain is learned after
+	baseline.Flush()
+	if len(baseline.store.baselines) != 9 {
+		t.Errorf("one domain should be forgotten, got %d", len(baseline.store.baselines))
+	}
+	if _, ok := baseline.store.baselines[dnsutils.ALERT_GROUP_BY_DOMAIN+"+busy.com"]; !ok {
+		t.Errorf("the busiest domain should be kept")
+	}
+	recordBaseline(baseline, "new.com", 1)
+	if _, ok := baseline.store.baselines[dnsutils.ALERT_GROUP_BY_DOMAIN+"+new.com"]; !ok {
+		t.Errorf("the new domain should be learned")
+	}
+}
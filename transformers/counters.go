@@ -0,0 +1,45 @@
+package transformers
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// messages dropped by the transformers, by name of collector or logger then by transformer,
+// the counters are shared by the instances of a worker and are monotonic
+var (
+	droppedMutex    sync.Mutex
+	droppedRegistry = make(map[string]map[string]*uint64)
+)
+
+// droppedCounter returns the counter of the messages dropped by the transformer of the worker
+func droppedCounter(name string, transform string) *uint64 {
+	droppedMutex.Lock()
+	defer droppedMutex.Unlock()
+
+	counters, ok := droppedRegistry[name]
+	if !ok {
+		counters = make(map[string]*uint64)
+		droppedRegistry[name] = counters
+	}
+	if _, ok := counters[transform]; !ok {
+		counters[transform] = new(uint64)
+	}
+	return counters[transform]
+}
+
+// GetDroppedStats returns the number of messages dropped by the transformers,
+// by name of collector or logger then by transformer
+func GetDroppedStats() map[string]map[string]uint64 {
+	droppedMutex.Lock()
+	defer droppedMutex.Unlock()
+
+	stats := make(map[string]map[string]uint64)
+	for name, counters := range droppedRegistry {
+		stats[name] = make(map[string]uint64)
+		for transform, counter := range counters {
+			stats[name][transform] = atomic.LoadUint64(counter)
+		}
+	}
+	return stats
+}
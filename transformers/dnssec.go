@@ -0,0 +1,288 @@
+package transformers
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+const (
+	// max number of questions waiting for their validation, the next ones are not checked
+	dnssecQueueSize = 1024
+	// the validation errors are kept shortly, the resolver is retried after
+	dnssecErrorTtl = 10 * time.Second
+)
+
+// extended dns errors (RFC 8914) returned by a validating resolver when the validation fails
+var dnssecFailureCodes = map[uint16]bool{
+	dns.ExtendedErrorCodeUnsupportedDNSKEYAlgorithm: true,
+	dns.ExtendedErrorCodeUnsupportedDSDigestType:    true,
+	dns.ExtendedErrorCodeDNSBogus:                   true,
+	dns.ExtendedErrorCodeSignatureExpired:           true,
+	dns.ExtendedErrorCodeSignatureNotYetValid:       true,
+	dns.ExtendedErrorCodeDNSKEYMissing:              true,
+	dns.ExtendedErrorCodeRRSIGsMissing:              true,
+	dns.ExtendedErrorCodeNoZoneKeyBitSet:            true,
+	dns.ExtendedErrorCodeNSECMissing:                true,
+}
+
+// result of the validation of a question, kept until the expiration. The records
+// are set only when the validating resolver has replied successfully
+type DnssecCacheEntry struct {
+	status        string
+	authenticated bool
+	records       map[string]bool
+	expire        time.Time
+}
+
+// dnssec check processor, re-validates a sample of the questions with a validating resolver
+// and compares the observed answers with the validated ones. The validations are done in
+// background, the replies are checked once the result of their question is cached
+type DnssecCheckProcessor struct {
+	sync.Mutex
+	config  *dnsutils.ConfigTransformers
+	logger  *logger.Logger
+	name    string
+	client  *dns.Client
+	cache   map[string]DnssecCacheEntry
+	pending map[string]bool
+	checks  chan [2]string
+	stop    chan bool
+}
+
+func NewDnssecCheckSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *DnssecCheckProcessor {
+	s := DnssecCheckProcessor{
+		config:  config,
+		logger:  logger,
+		name:    name,
+		client:  &dns.Client{Timeout: time.Duration(config.DnssecCheck.Timeout) * time.Second},
+		cache:   make(map[string]DnssecCacheEntry),
+		pending: make(map[string]bool),
+		checks:  make(chan [2]string, dnssecQueueSize),
+		stop:    make(chan bool),
+	}
+	return &s
+}
+
+func (s *DnssecCheckProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor dnssec - "+msg, v...)
+}
+
+func (s *DnssecCheckProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
+	dm.Dnssec = &dnsutils.TransformDnssec{
+		Status:        "-",
+		Authenticated: false,
+	}
+}
+
+// IsCandidate returns true if the message is a successful reply with answers to check
+func (s *DnssecCheckProcessor) IsCandidate(dm *dnsutils.DnsMessage) bool {
+	if dm.DNS.Type != dnsutils.DnsReply || dm.DNS.MalformedPacket || dm.DNS.Rcode != dnsutils.DNS_RCODE_NOERROR {
+		return false
+	}
+	if _, ok := dns.StringToType[dm.DNS.Qtype]; !ok {
+		return false
+	}
+	return len(dm.DNS.DnsRRs.Answers) > 0
+}
+
+// exchange sends the question to the validating resolver, with the dnssec ok flag
+func (s *DnssecCheckProcessor) exchange(qname string, qtype string, checkingDisabled bool) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(qname), dns.StringToType[qtype])
+	m.SetEdns0(4096, true)
+	m.AuthenticatedData = true
+	m.CheckingDisabled = checkingDisabled
+
+	r, _, err := s.client.Exchange(m, s.config.DnssecCheck.Resolver)
+	return r, err
+}
+
+// IsValidationFailure returns true if the servfail is caused by the dnssec validation, from
+// the extended dns error of the reply or, without it, if the resolution succeeds without validation
+func (s *DnssecCheckProcessor) IsValidationFailure(qname string, qtype string, r *dns.Msg) (bool, error) {
+	if opt := r.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ede, ok := o.(*dns.EDNS0_EDE); ok {
+				return dnssecFailureCodes[ede.InfoCode], nil
+			}
+		}
+	}
+
+	r, err := s.exchange(qname, qtype, true)
+	if err != nil {
+		return false, err
+	}
+	return r.Rcode == dns.RcodeSuccess, nil
+}
+
+// Validate queries the validating resolver and returns the result of the validation,
+// a servfail is bogus only when the validation is the cause, the other errors are indeterminate
+func (s *DnssecCheckProcessor) Validate(qname string, qtype string) (DnssecCacheEntry, error) {
+	entry := DnssecCacheEntry{}
+
+	r, err := s.exchange(qname, qtype, false)
+	if err != nil {
+		return entry, err
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		entry.status = dnsutils.DNSSEC_INDETERMINATE
+		if r.Rcode == dns.RcodeServerFailure {
+			bogus, err := s.IsValidationFailure(qname, qtype, r)
+			if err != nil {
+				return entry, err
+			}
+			if bogus {
+				entry.status = dnsutils.DNSSEC_BOGUS
+			}
+		}
+		return entry, nil
+	}
+
+	// decode the answers with the same parser as the observed traffic
+	payload, err := r.Pack()
+	if err != nil {
+		return entry, err
+	}
+	header, err := dnsutils.DecodeDns(payload)
+	if err != nil {
+		return entry, err
+	}
+	_, _, offset, err := dnsutils.DecodeQuestion(header.Qdcount, payload)
+	if err != nil {
+		return entry, err
+	}
+	answers, _, err := dnsutils.DecodeAnswer(header.Ancount, offset, payload)
+	if err != nil {
+		return entry, err
+	}
+
+	entry.status = dnsutils.DNSSEC_MATCH
+	entry.authenticated = r.AuthenticatedData
+	entry.records = make(map[string]bool)
+	for _, rr := range answers {
+		entry.records[rr.Rdatatype+"/"+rr.Rdata] = true
+	}
+	return entry, nil
+}
+
+// store adds the result to the cache, the expired entries are removed when the cache
+// is full and the cache is cleared if there is still no room
+func (s *DnssecCheckProcessor) store(key string, entry DnssecCacheEntry) {
+	if len(s.cache) >= s.config.DnssecCheck.CacheSize {
+		now := time.Now()
+		for k, v := range s.cache {
+			if now.After(v.expire) {
+				delete(s.cache, k)
+			}
+		}
+		if len(s.cache) >= s.config.DnssecCheck.CacheSize {
+			s.cache = make(map[string]DnssecCacheEntry)
+		}
+	}
+	s.cache[key] = entry
+}
+
+// Check validates the question with the resolver and caches the result,
+// the errors are cached shortly to not flood an unreachable resolver
+func (s *DnssecCheckProcessor) Check(qname string, qtype string) {
+	entry, err := s.Validate(qname, qtype)
+	if err != nil {
+		s.LogError("validation error %v", err)
+		entry.status = dnsutils.DNSSEC_ERROR
+		entry.expire = time.Now().Add(dnssecErrorTtl)
+	} else {
+		entry.expire = time.Now().Add(time.Duration(s.config.DnssecCheck.CacheTtl) * time.Second)
+	}
+
+	key := qname + "/" + qtype
+	s.Lock()
+	defer s.Unlock()
+	delete(s.pending, key)
+	s.store(key, entry)
+}
+
+// Run validates the questions queued with a pool of workers, until the stop
+func (s *DnssecCheckProcessor) Run() {
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.DnssecCheck.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q := range s.checks {
+				s.Check(q[0], q[1])
+			}
+		}()
+	}
+
+	<-s.stop
+	close(s.checks)
+	wg.Wait()
+	s.stop <- true
+}
+
+// Stop waits the validations in progress
+func (s *DnssecCheckProcessor) Stop() {
+	s.stop <- true
+	<-s.stop
+}
+
+// lookup returns the cached result of the question, on a miss the question is queued
+// for a validation in background according to the sample rate and false is returned
+func (s *DnssecCheckProcessor) lookup(qname string, qtype string) (DnssecCacheEntry, bool) {
+	key := qname + "/" + qtype
+
+	s.Lock()
+	defer s.Unlock()
+
+	if entry, ok := s.cache[key]; ok && time.Now().Before(entry.expire) {
+		return entry, true
+	}
+	if s.pending[key] || rand.Float64() >= s.config.DnssecCheck.SampleRate {
+		return DnssecCacheEntry{}, false
+	}
+
+	select {
+	case s.checks <- [2]string{qname, qtype}:
+		s.pending[key] = true
+	default:
+	}
+	return DnssecCacheEntry{}, false
+}
+
+// CheckAnswers records whether the observed answers match the validated ones,
+// every observed record of the queried type must be present in the validated answers
+func (s *DnssecCheckProcessor) CheckAnswers(dm *dnsutils.DnsMessage) {
+	if dm.Dnssec == nil {
+		s.InitDnsMessage(dm)
+	}
+
+	if !s.IsCandidate(dm) {
+		return
+	}
+
+	entry, ok := s.lookup(dm.DNS.Qname, dm.DNS.Qtype)
+	if !ok {
+		return
+	}
+	dm.Dnssec.Status = entry.status
+	dm.Dnssec.Authenticated = entry.authenticated
+	if entry.status != dnsutils.DNSSEC_MATCH {
+		return
+	}
+
+	for _, rr := range dm.DNS.DnsRRs.Answers {
+		if rr.Rdatatype != dm.DNS.Qtype {
+			continue
+		}
+		if !entry.records[rr.Rdatatype+"/"+rr.Rdata] {
+			dm.Dnssec.Status = dnsutils.DNSSEC_MISMATCH
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func TestDnssecCheck_CheckAnswers(t *testing.T) {
+	// fake validating resolver
+	server := &dns.Server{Addr: "127.0.0.1:5398", Net: "udp"}
+	server.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch r.Question[0].Name {
+		case "bogus.collector.":
+			m.Rcode = dns.RcodeServerFailure
+			m.SetEdns0(4096, true)
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeSignatureExpired})
+		case "expired.collector.":
+			// servfail without extended error, resolved when the validation is disabled
+			if !r.CheckingDisabled {
+				m.Rcode = dns.RcodeServerFailure
+				break
+			}
+			rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 10.0.0.1")
+			m.Answer = append(m.Answer, rr)
+		case "broken.collector.":
+			m.Rcode = dns.RcodeServerFailure
+		case "nx.collector.":
+			m.Rcode = dns.RcodeNameError
+		default:
+			m.AuthenticatedData = true
+			rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 10.0.0.1")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})
+	go server.ListenAndServe()
+	defer server.Shutdown()
+	time.Sleep(500 * time.Millisecond)
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.DnssecCheck.Enable = true
+	config.DnssecCheck.Resolver = "127.0.0.1:5398"
+	config.DnssecCheck.SampleRate = 1.0
+
+	// init subproccesor
+	dnssec := NewDnssecCheckSubprocessor(config, logger.New(false), "test")
+	go dnssec.Run()
+	defer dnssec.Stop()
+
+	testcases := []struct {
+		name   string
+		qname  string
+		rdata  string
+		status string
+	}{
+		{name: "match", qname: "dns.collector", rdata: "10.0.0.1", status: dnsutils.DNSSEC_MATCH},
+		{name: "mismatch", qname: "poisoned.collector", rdata: "10.6.6.6", status: dnsutils.DNSSEC_MISMATCH},
+		{name: "bogus", qname: "bogus.collector", rdata: "10.0.0.1", status: dnsutils.DNSSEC_BOGUS},
+		{name: "bogus without ede", qname: "expired.collector", rdata: "10.0.0.1", status: dnsutils.DNSSEC_BOGUS},
+		{name: "servfail without dnssec cause", qname: "broken.collector", rdata: "10.0.0.1", status: dnsutils.DNSSEC_INDETERMINATE},
+		{name: "nxdomain", qname: "nx.collector", rdata: "10.0.0.1", status: dnsutils.DNSSEC_INDETERMINATE},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Type = dnsutils.DnsReply
+			dm.DNS.Qname = tc.qname
+			dm.DNS.DnsRRs.Answers = []dnsutils.DnsAnswer{{Name: tc.qname, Rdatatype: "A", Rdata: tc.rdata}}
+
+			// the first reply is not checked, the question is validated in background
+			dnssec.CheckAnswers(&dm)
+			if dm.Dnssec.Status != "-" {
+				t.Errorf("reply should not be checked before the validation, got %s", dm.Dnssec.Status)
+			}
+
+			for i := 0; i < 20 && dm.Dnssec.Status == "-"; i++ {
+				time.Sleep(100 * time.Millisecond)
+				dnssec.CheckAnswers(&dm)
+			}
+			if dm.Dnssec.Status != tc.status {
+				t.Errorf("want status %s, got %s", tc.status, dm.Dnssec.Status)
+			}
+		})
+	}
+
+	// queries are not checked
+	dm := dnsutils.GetFakeDnsMessage()
+	dnssec.CheckAnswers(&dm)
+	if dm.Dnssec.Status != "-" {
+		t.Errorf("query should not be checked, got %s", dm.Dnssec.Status)
+	}
+}
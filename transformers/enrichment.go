@@ -0,0 +1,247 @@
+package transformers
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+// max number of ips waiting for their reverse lookup, the next ones are not resolved
+const enrichmentQueueSize = 1024
+
+// ptr name of a client ip, kept until the expiration
+type PtrCacheEntry struct {
+	name   string
+	expire time.Time
+}
+
+// network of the user map with its label
+type NetworkLabel struct {
+	network *net.IPNet
+	label   string
+}
+
+// enrichment processor, resolves the client ips to their ptr names and tags the clients
+// with the label of their network. The reverse lookups are done in background, the name
+// is added to the messages of the client once resolved
+type EnrichmentProcessor struct {
+	sync.Mutex
+	config   *dnsutils.ConfigTransformers
+	logger   *logger.Logger
+	name     string
+	client   *dns.Client
+	cache    map[string]PtrCacheEntry
+	pending  map[string]bool
+	lookups  chan string
+	networks []NetworkLabel
+	tokens   float64
+	last     time.Time
+	stop     chan bool
+}
+
+func NewEnrichmentSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *EnrichmentProcessor {
+	s := EnrichmentProcessor{
+		config:  config,
+		logger:  logger,
+		name:    name,
+		client:  &dns.Client{Timeout: time.Duration(config.Enrichment.Timeout) * time.Second},
+		cache:   make(map[string]PtrCacheEntry),
+		pending: make(map[string]bool),
+		lookups: make(chan string, enrichmentQueueSize),
+		tokens:  float64(config.Enrichment.MaxLookups),
+		last:    time.Now(),
+		stop:    make(chan bool),
+	}
+	if config.Enrichment.Enable {
+		s.LoadNetworks()
+	}
+	return &s
+}
+
+func (s *EnrichmentProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor enrichment - "+msg, v...)
+}
+
+func (s *EnrichmentProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor enrichment - "+msg, v...)
+}
+
+// LoadNetworks parses the networks of the config, the most specific ones first
+func (s *EnrichmentProcessor) LoadNetworks() {
+	s.networks = []NetworkLabel{}
+	for cidr, label := range s.config.Enrichment.Networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			s.LogError("invalid network %s: %v", cidr, err)
+			continue
+		}
+		s.networks = append(s.networks, NetworkLabel{network: network, label: label})
+	}
+	sort.Slice(s.networks, func(i, j int) bool {
+		oi, _ := s.networks[i].network.Mask.Size()
+		oj, _ := s.networks[j].network.Mask.Size()
+		return oi > oj
+	})
+	if len(s.networks) > 0 {
+		s.LogInfo("%d networks loaded", len(s.networks))
+	}
+}
+
+func (s *EnrichmentProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
+	if s.config.Enrichment.ReverseDns {
+		dm.NetworkInfo.QueryPtr = "-"
+	}
+	if len(s.networks) > 0 {
+		dm.NetworkInfo.QueryLabel = "-"
+	}
+}
+
+// GetLabel returns the label of the most specific network containing the ip
+func (s *EnrichmentProcessor) GetLabel(ip string) (string, bool) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", false
+	}
+	for _, n := range s.networks {
+		if n.network.Contains(addr) {
+			return n.label, true
+		}
+	}
+	return "", false
+}
+
+// allow consumes a token of the lookups rate limit, the bucket holds one second at most
+func (s *EnrichmentProcessor) allow() bool {
+	limit := float64(s.config.Enrichment.MaxLookups)
+	if limit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * limit
+	if s.tokens > limit {
+		s.tokens = limit
+	}
+	s.last = now
+
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+// store adds the name to the cache for the ttl, the expired entries are removed when the cache
+// is full and the cache is cleared if there is still no room
+func (s *EnrichmentProcessor) store(ip string, name string, ttl int) {
+	if len(s.cache) >= s.config.Enrichment.CacheSize {
+		now := time.Now()
+		for k, v := range s.cache {
+			if now.After(v.expire) {
+				delete(s.cache, k)
+			}
+		}
+		if len(s.cache) >= s.config.Enrichment.CacheSize {
+			s.cache = make(map[string]PtrCacheEntry)
+		}
+	}
+	s.cache[ip] = PtrCacheEntry{name: name, expire: time.Now().Add(time.Duration(ttl) * time.Second)}
+}
+
+// Resolve returns the ptr name of the ip from the cache, on a miss the ip is queued for a reverse
+// lookup in background and false is returned, the ip is not queued when the rate limit is reached
+func (s *EnrichmentProcessor) Resolve(ip string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if entry, ok := s.cache[ip]; ok && time.Now().Before(entry.expire) {
+		return entry.name, true
+	}
+	if s.pending[ip] || !s.allow() {
+		return "", false
+	}
+
+	select {
+	case s.lookups <- ip:
+		s.pending[ip] = true
+	default:
+	}
+	return "", false
+}
+
+// Lookup resolves the ip with the resolver, the ips without name or in error are cached
+// with the negative ttl
+func (s *EnrichmentProcessor) Lookup(ip string) {
+	name := "-"
+	ttl := s.config.Enrichment.NegativeTtl
+
+	if reverse, err := dns.ReverseAddr(ip); err == nil {
+		m := new(dns.Msg)
+		m.SetQuestion(reverse, dns.TypePTR)
+		r, _, err := s.client.Exchange(m, s.config.Enrichment.Resolver)
+		if err != nil {
+			s.LogError("reverse lookup error %v", err)
+		} else if r.Rcode == dns.RcodeSuccess {
+			for _, rr := range r.Answer {
+				if ptr, ok := rr.(*dns.PTR); ok {
+					name = strings.TrimSuffix(ptr.Ptr, ".")
+					ttl = s.config.Enrichment.CacheTtl
+					break
+				}
+			}
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	delete(s.pending, ip)
+	s.store(ip, name, ttl)
+}
+
+// Run resolves the ips queued with a pool of workers, until the stop
+func (s *EnrichmentProcessor) Run() {
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.Enrichment.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range s.lookups {
+				s.Lookup(ip)
+			}
+		}()
+	}
+
+	<-s.stop
+	close(s.lookups)
+	wg.Wait()
+	s.stop <- true
+}
+
+// Stop waits the lookups in progress
+func (s *EnrichmentProcessor) Stop() {
+	s.stop <- true
+	<-s.stop
+}
+
+// Enrich sets the ptr name and the network label of the query ip
+func (s *EnrichmentProcessor) Enrich(dm *dnsutils.DnsMessage) {
+	if s.config.Enrichment.ReverseDns {
+		dm.NetworkInfo.QueryPtr = "-"
+		if name, ok := s.Resolve(dm.NetworkInfo.QueryIp); ok {
+			dm.NetworkInfo.QueryPtr = name
+		}
+	}
+
+	if len(s.networks) > 0 {
+		dm.NetworkInfo.QueryLabel = "-"
+		if label, ok := s.GetLabel(dm.NetworkInfo.QueryIp); ok {
+			dm.NetworkInfo.QueryLabel = label
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package transformers
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func TestEnrichment_NetworkLabels(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Enrichment.Enable = true
+	config.Enrichment.Networks = map[string]string{
+		"10.0.0.0/8":     "office",
+		"10.10.0.0/16":   "vpn",
+		"2001:db8::/32":  "guest-wifi",
+		"invalid/subnet": "invalid",
+	}
+
+	// init subproccesor
+	enrich := NewEnrichmentSubprocessor(config, logger.New(false), "test")
+
+	testcases := []struct {
+		ip    string
+		label string
+	}{
+		{ip: "10.1.2.3", label: "office"},
+		{ip: "10.10.2.3", label: "vpn"},
+		{ip: "2001:db8::1", label: "guest-wifi"},
+		{ip: "192.168.1.1", label: "-"},
+		{ip: "-", label: "-"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.ip, func(t *testing.T) {
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.NetworkInfo.QueryIp = tc.ip
+			enrich.Enrich(&dm)
+
+			if dm.NetworkInfo.QueryLabel != tc.label {
+				t.Errorf("want label %s, got %s", tc.label, dm.NetworkInfo.QueryLabel)
+			}
+			if dm.NetworkInfo.QueryPtr != "" {
+				t.Errorf("reverse dns should be disabled, got %s", dm.NetworkInfo.QueryPtr)
+			}
+		})
+	}
+}
+
+func TestEnrichment_ReverseDns(t *testing.T) {
+	// fake resolver
+	var queries atomic.Int32
+	server := &dns.Server{Addr: "127.0.0.1:5399", Net: "udp"}
+	server.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch r.Question[0].Name {
+		case "4.3.2.1.in-addr.arpa.":
+			rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN PTR host.collector.")
+			m.Answer = append(m.Answer, rr)
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	go server.ListenAndServe()
+	defer server.Shutdown()
+	time.Sleep(500 * time.Millisecond)
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Enrichment.Enable = true
+	config.Enrichment.ReverseDns = true
+	config.Enrichment.Resolver = "127.0.0.1:5399"
+	config.Enrichment.MaxLookups = 2
+
+	// init subproccesor
+	enrich := NewEnrichmentSubprocessor(config, logger.New(false), "test")
+	go enrich.Run()
+	defer enrich.Stop()
+
+	// the first messages are not delayed by the lookups, the third ip is not
+	// resolved because of the rate limit
+	for _, ip := range []string{"1.2.3.4", "5.6.7.8", "9.9.9.9"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.QueryIp = ip
+		enrich.Enrich(&dm)
+		if dm.NetworkInfo.QueryPtr != "-" {
+			t.Errorf("want ptr - before the lookup, got %s", dm.NetworkInfo.QueryPtr)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	// resolved, the next messages of the client have the name
+	dm := dnsutils.GetFakeDnsMessage()
+	enrich.Enrich(&dm)
+	if dm.NetworkInfo.QueryPtr != "host.collector" {
+		t.Errorf("want ptr host.collector, got %s", dm.NetworkInfo.QueryPtr)
+	}
+
+	// not found, cached with the negative ttl
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "5.6.7.8"
+	enrich.Enrich(&dm)
+	if dm.NetworkInfo.QueryPtr != "-" {
+		t.Errorf("want ptr -, got %s", dm.NetworkInfo.QueryPtr)
+	}
+	if entry := enrich.cache["5.6.7.8"]; time.Until(entry.expire) > time.Duration(config.Enrichment.NegativeTtl)*time.Second {
+		t.Errorf("negative ttl expected: %v", entry.expire)
+	}
+
+	if queries.Load() != 2 {
+		t.Errorf("want 2 queries to the resolver, got %d", queries.Load())
+	}
+}
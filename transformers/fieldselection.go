@@ -0,0 +1,33 @@
+package transformers
+
+import (
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+type FieldSelectionProcessor struct {
+	config    *dnsutils.ConfigTransformers
+	selection *dnsutils.FieldSelection
+}
+
+func NewFieldSelectionSubprocessor(config *dnsutils.ConfigTransformers) FieldSelectionProcessor {
+	s := FieldSelectionProcessor{
+		config: config,
+		selection: &dnsutils.FieldSelection{
+			Keep: config.FieldSelection.KeepFields,
+			Drop: config.FieldSelection.DropFields,
+		},
+	}
+
+	return s
+}
+
+// IsEnabled returns true if the selection is enabled or if fields to keep or to drop are provided
+func (s *FieldSelectionProcessor) IsEnabled() bool {
+	return s.config.FieldSelection.Enable || len(s.selection.Keep) > 0 || len(s.selection.Drop) > 0
+}
+
+// Select attaches the field selection to the message, the keys are removed
+// when the message is flattened by the logger
+func (s *FieldSelectionProcessor) Select(dm *dnsutils.DnsMessage) {
+	dm.Fields = s.selection
+}
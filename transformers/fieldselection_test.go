@@ -0,0 +1,78 @@
+package transformers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+func TestFieldSelectionKeep(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.FieldSelection.Enable = true
+	config.FieldSelection.KeepFields = []string{"dns.qname", "dns.rcode", "network"}
+
+	// init the processor
+	fields := NewFieldSelectionSubprocessor(config)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	fields.Select(&dm)
+
+	flat, err := dm.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flat["dns.qname"] != "dns.collector" || flat["network.query-ip"] != "1.2.3.4" {
+		t.Errorf("kept fields are missing: %v", flat)
+	}
+	if _, ok := flat["dns.qtype"]; ok {
+		t.Errorf("dns.qtype should be removed: %v", flat)
+	}
+	if _, ok := flat["dnstap.identity"]; ok {
+		t.Errorf("dnstap.identity should be removed: %v", flat)
+	}
+}
+
+func TestFieldSelectionDrop(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.FieldSelection.Enable = true
+	config.FieldSelection.KeepFields = []string{"dns"}
+	config.FieldSelection.DropFields = []string{"dns.resource-records", "dns.qtype"}
+
+	// init the processor
+	fields := NewFieldSelectionSubprocessor(config)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, dnsutils.DnsAnswer{Name: "dns.collector", Rdata: "1.2.3.4"})
+	fields.Select(&dm)
+
+	flat, err := dm.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := flat["dns.qname"]; !ok {
+		t.Errorf("dns.qname should be kept: %v", flat)
+	}
+	for key := range flat {
+		if key == "dns.qtype" || key == "network.query-ip" || strings.HasPrefix(key, "dns.resource-records") {
+			t.Errorf("%s should be removed", key)
+		}
+	}
+}
+
+func TestFieldSelectionEnabled(t *testing.T) {
+	// no enable flag, the fields provided are enough
+	config := dnsutils.GetFakeConfigTransformers()
+	config.FieldSelection.KeepFields = []string{"dns.qname"}
+	if fields := NewFieldSelectionSubprocessor(config); !fields.IsEnabled() {
+		t.Errorf("selection should be enabled with keep-fields")
+	}
+
+	// nothing to select
+	config = dnsutils.GetFakeConfigTransformers()
+	if fields := NewFieldSelectionSubprocessor(config); fields.IsEnabled() {
+		t.Errorf("selection should be disabled without fields")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
@@ -19,20 +21,31 @@ type FilteringProcessor struct {
 	dropDomains          bool
 	keepDomains          bool
 	mapRcodes            map[string]bool
+	mapKeepRcodes        map[string]bool
 	ipsetDrop            *netaddr.IPSet
 	ipsetKeep            *netaddr.IPSet
 	listFqdns            map[string]bool
 	listDomainsRegex     map[string]*regexp.Regexp
 	listKeepFqdns        map[string]bool
 	listKeepDomainsRegex map[string]*regexp.Regexp
+	listWildcards        map[string]bool
+	listKeepWildcards    map[string]bool
 	fileWatcher          *fsnotify.Watcher
 	name                 string
 	downsample           int
 	downsampleCount      int
 	activeFilters        []func(dm *dnsutils.DnsMessage) bool
+	enrichedFilters      []func(dm *dnsutils.DnsMessage) bool
+	dropAsns             []string
+	keepAsns             []string
+	asnMatchOn           map[string]bool
+	dropExpression       *dnsutils.Expression
+	keepExpression       *dnsutils.Expression
+	modTimes             map[string]time.Time
+	lastCheck            time.Time
 }
 
-func NewFilteringProcessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) FilteringProcessor {
+func NewFilteringProcessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *FilteringProcessor {
 	// creates a new file watcher
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -41,27 +54,100 @@ func NewFilteringProcessor(config *dnsutils.ConfigTransformers, logger *logger.L
 	defer watcher.Close()
 
 	d := FilteringProcessor{
-		config:               config,
-		logger:               logger,
-		mapRcodes:            make(map[string]bool),
-		ipsetDrop:            &netaddr.IPSet{},
-		ipsetKeep:            &netaddr.IPSet{},
-		listFqdns:            make(map[string]bool),
-		listDomainsRegex:     make(map[string]*regexp.Regexp),
-		listKeepFqdns:        make(map[string]bool),
-		listKeepDomainsRegex: make(map[string]*regexp.Regexp),
-		fileWatcher:          watcher,
-		name:                 name,
+		config:      config,
+		logger:      logger,
+		fileWatcher: watcher,
+		name:        name,
+		modTimes:    make(map[string]time.Time),
+		lastCheck:   time.Now(),
 	}
 
-	d.LoadRcodes()
-	d.LoadDomainsList()
-	d.LoadQueryIpList()
-
-	d.LoadActiveFilters()
+	d.Load()
 
 	//go d.Run()
-	return d
+	return &d
+}
+
+// Load loads the lists and prepares the filters, the previous lists are replaced
+func (p *FilteringProcessor) Load() {
+	p.mapRcodes = make(map[string]bool)
+	p.mapKeepRcodes = make(map[string]bool)
+	p.ipsetDrop = &netaddr.IPSet{}
+	p.ipsetKeep = &netaddr.IPSet{}
+	p.listFqdns = make(map[string]bool)
+	p.listDomainsRegex = make(map[string]*regexp.Regexp)
+	p.listKeepFqdns = make(map[string]bool)
+	p.listKeepDomainsRegex = make(map[string]*regexp.Regexp)
+	p.listWildcards = make(map[string]bool)
+	p.listKeepWildcards = make(map[string]bool)
+	p.asnMatchOn = make(map[string]bool)
+	p.dropAsns = nil
+	p.keepAsns = nil
+	p.activeFilters = nil
+	p.enrichedFilters = nil
+
+	p.LoadRcodes()
+	p.LoadDomainsList()
+	p.LoadQueryIpList()
+
+	p.LoadActiveFilters()
+}
+
+// listFiles returns the list files of the config
+func (p *FilteringProcessor) listFiles() []string {
+	files := []string{}
+	for _, f := range []string{p.config.Filtering.DropFqdnFile, p.config.Filtering.DropDomainFile,
+		p.config.Filtering.KeepFqdnFile, p.config.Filtering.KeepDomainFile,
+		p.config.Filtering.DropQueryIpFile, p.config.Filtering.KeepQueryIpFile} {
+		if len(f) > 0 {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// CheckUpdates reloads the lists if one of the files has been updated,
+// the files are checked every reload interval
+func (p *FilteringProcessor) CheckUpdates() {
+	interval := time.Duration(p.config.Filtering.ReloadInterval) * time.Second
+	if interval <= 0 || time.Since(p.lastCheck) < interval {
+		return
+	}
+	p.lastCheck = time.Now()
+
+	for _, f := range p.listFiles() {
+		info, err := os.Stat(f)
+		if err != nil || info.ModTime().Equal(p.modTimes[f]) {
+			continue
+		}
+		p.LogInfo("%s updated, reloading the lists", f)
+		p.Load()
+		return
+	}
+}
+
+// readListFile returns the entries of a list file, the empty lines and the comments are ignored
+func (p *FilteringProcessor) readListFile(fname string) ([]string, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil {
+		p.modTimes[fname] = info.ModTime()
+	}
+
+	entries := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if len(entry) == 0 || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
 }
 
 func (p *FilteringProcessor) LoadActiveFilters() {
@@ -79,11 +165,15 @@ func (p *FilteringProcessor) LoadActiveFilters() {
 		p.activeFilters = append(p.activeFilters, p.rCodeFilter)
 	}
 
+	if len(p.mapKeepRcodes) > 0 {
+		p.activeFilters = append(p.activeFilters, p.keepRcodeFilter)
+	}
+
 	if len(p.config.Filtering.KeepQueryIpFile) > 0 || len(p.config.Filtering.DropQueryIpFile) > 0 {
 		p.activeFilters = append(p.activeFilters, p.ipFilter)
 	}
 
-	if len(p.listFqdns) > 0 {
+	if len(p.listFqdns) > 0 || len(p.listWildcards) > 0 {
 		p.activeFilters = append(p.activeFilters, p.dropFqdnFilter)
 	}
 
@@ -91,7 +181,7 @@ func (p *FilteringProcessor) LoadActiveFilters() {
 		p.activeFilters = append(p.activeFilters, p.dropDomainRegexFilter)
 	}
 
-	if len(p.listKeepFqdns) > 0 {
+	if len(p.listKeepFqdns) > 0 || len(p.listKeepWildcards) > 0 {
 		p.activeFilters = append(p.activeFilters, p.keepFqdnFilter)
 	}
 
@@ -105,31 +195,64 @@ func (p *FilteringProcessor) LoadActiveFilters() {
 		p.downsampleCount = 0
 		p.activeFilters = append(p.activeFilters, p.downsampleFilter)
 	}
+
+	// filters evaluated after the geoip enrichment
+	for _, v := range p.config.Filtering.AsnMatchOn {
+		p.asnMatchOn[v] = true
+	}
+	for _, v := range p.config.Filtering.DropAsns {
+		p.dropAsns = append(p.dropAsns, normalizeAsn(v))
+	}
+	for _, v := range p.config.Filtering.KeepAsns {
+		p.keepAsns = append(p.keepAsns, normalizeAsn(v))
+	}
+	if len(p.dropAsns) > 0 {
+		p.enrichedFilters = append(p.enrichedFilters, p.dropAsnFilter)
+	}
+	if len(p.keepAsns) > 0 {
+		p.enrichedFilters = append(p.enrichedFilters, p.keepAsnFilter)
+	}
+
+	// the expressions can use all the fields, they are evaluated after the transformers
+	if len(p.config.Filtering.DropExpression) > 0 {
+		expr, err := dnsutils.ParseExpression(p.config.Filtering.DropExpression)
+		if err != nil {
+			p.LogError("invalid drop expression: %v", err)
+		} else {
+			p.dropExpression = expr
+			p.enrichedFilters = append(p.enrichedFilters, p.dropExpressionFilter)
+		}
+	}
+	if len(p.config.Filtering.KeepExpression) > 0 {
+		expr, err := dnsutils.ParseExpression(p.config.Filtering.KeepExpression)
+		if err != nil {
+			p.LogError("invalid keep expression: %v", err)
+		} else {
+			p.keepExpression = expr
+			p.enrichedFilters = append(p.enrichedFilters, p.keepExpressionFilter)
+		}
+	}
 }
 
 func (p *FilteringProcessor) LoadRcodes() {
 	for _, v := range p.config.Filtering.DropRcodes {
 		p.mapRcodes[v] = true
 	}
+	for _, v := range p.config.Filtering.KeepRcodes {
+		p.mapKeepRcodes[v] = true
+	}
 }
 
 func (p *FilteringProcessor) loadQueryIpList(fname string, drop bool) (uint64, error) {
-	file, err := os.Open(fname)
+	entries, err := p.readListFile(fname)
 	if err != nil {
 		return 0, err
 	}
 
-	// register the file to watch
-	/*if err := p.fileWatcher.Add(fname); err != nil {
-		p.LogError("unable to watch ip file: ", err)
-	}*/
-
-	scanner := bufio.NewScanner(file)
 	var read uint64
 	var ipsetbuilder netaddr.IPSetBuilder
-	for scanner.Scan() {
+	for _, ipOrPrefix := range entries {
 		read++
-		ipOrPrefix := strings.ToLower(scanner.Text())
 		prefix, err := netaddr.ParseIPPrefix(ipOrPrefix)
 		if err != nil {
 			ip, err := netaddr.ParseIP(ipOrPrefix)
@@ -169,78 +292,72 @@ func (p *FilteringProcessor) LoadQueryIpList() {
 	}
 }
 
+// loadFqdnList loads the exact names and the wildcards (*.example.com) of a fqdn file
+func (p *FilteringProcessor) loadFqdnList(fname string, fqdns map[string]bool, wildcards map[string]bool) error {
+	entries, err := p.readListFile(fname)
+	if err != nil {
+		return err
+	}
+	for _, fqdn := range entries {
+		if strings.HasPrefix(fqdn, "*.") {
+			wildcards[fqdn[1:]] = true
+			continue
+		}
+		fqdns[fqdn] = true
+	}
+	return nil
+}
+
+// loadRegexList loads the regular expressions of a domain file
+func (p *FilteringProcessor) loadRegexList(fname string, domains map[string]*regexp.Regexp) error {
+	entries, err := p.readListFile(fname)
+	if err != nil {
+		return err
+	}
+	for _, domain := range entries {
+		regex, err := regexp.Compile(domain)
+		if err != nil {
+			p.LogError("invalid regex %s in %s: %v", domain, fname, err)
+			continue
+		}
+		domains[domain] = regex
+	}
+	return nil
+}
+
 func (p *FilteringProcessor) LoadDomainsList() {
 	if len(p.config.Filtering.DropFqdnFile) > 0 {
-		file, err := os.Open(p.config.Filtering.DropFqdnFile)
-		if err != nil {
+		if err := p.loadFqdnList(p.config.Filtering.DropFqdnFile, p.listFqdns, p.listWildcards); err != nil {
 			p.LogError("unable to open fqdn file: ", err)
-			p.dropDomains = true
 		} else {
-
-			// register the file to watch
-			/*if err := p.fileWatcher.Add(p.config.Subprocessors.Filtering.DropFqdnFile); err != nil {
-				p.LogError("unable to watch fqdn file: ", err)
-			}*/
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				fqdn := strings.ToLower(scanner.Text())
-				p.listFqdns[fqdn] = true
-			}
-			p.LogInfo("loaded with %d fqdn to the drop list", len(p.listFqdns))
-			p.dropDomains = true
+			p.LogInfo("loaded with %d fqdn and %d wildcards to the drop list", len(p.listFqdns), len(p.listWildcards))
 		}
-
+		p.dropDomains = true
 	}
 
 	if len(p.config.Filtering.DropDomainFile) > 0 {
-		file, err := os.Open(p.config.Filtering.DropDomainFile)
-		if err != nil {
+		if err := p.loadRegexList(p.config.Filtering.DropDomainFile, p.listDomainsRegex); err != nil {
 			p.LogError("unable to open regex list file: ", err)
-			p.dropDomains = true
 		} else {
-			// register the file to watch
-			/*if err := p.fileWatcher.Add(p.config.Subprocessors.Filtering.DropDomainFile); err != nil {
-				p.LogError("unable to watch domain file: ", err)
-			}*/
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				domain := strings.ToLower(scanner.Text())
-				p.listDomainsRegex[domain] = regexp.MustCompile(domain)
-			}
 			p.LogInfo("loaded with %d domains to the drop list", len(p.listDomainsRegex))
-			p.dropDomains = true
 		}
+		p.dropDomains = true
 	}
 
+	p.keepDomains = false
 	if len(p.config.Filtering.KeepFqdnFile) > 0 {
-		file, err := os.Open(p.config.Filtering.KeepFqdnFile)
-		if err != nil {
+		if err := p.loadFqdnList(p.config.Filtering.KeepFqdnFile, p.listKeepFqdns, p.listKeepWildcards); err != nil {
 			p.LogError("unable to open KeepFqdnFile file: ", err)
-			p.keepDomains = false
 		} else {
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				keepDomain := strings.ToLower(scanner.Text())
-				p.listKeepFqdns[keepDomain] = true
-			}
-			p.LogInfo("loaded with %d fqdns to the keep list", len(p.listKeepFqdns))
+			p.LogInfo("loaded with %d fqdns and %d wildcards to the keep list", len(p.listKeepFqdns), len(p.listKeepWildcards))
 			p.keepDomains = true
 		}
 	}
 
 	if len(p.config.Filtering.KeepDomainFile) > 0 {
-		file, err := os.Open(p.config.Filtering.KeepDomainFile)
-		if err != nil {
+		if err := p.loadRegexList(p.config.Filtering.KeepDomainFile, p.listKeepDomainsRegex); err != nil {
 			p.LogError("unable to open KeepDomainFile file: ", err)
-			p.keepDomains = false
 		} else {
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				keepDomain := strings.ToLower(scanner.Text())
-				p.listKeepDomainsRegex[keepDomain] = regexp.MustCompile(keepDomain)
-			}
 			p.LogInfo("loaded with %d domains to the keep list", len(p.listKeepDomainsRegex))
 			p.keepDomains = true
 		}
@@ -285,6 +402,12 @@ func (p *FilteringProcessor) rCodeFilter(dm *dnsutils.DnsMessage) bool {
 	return false
 }
 
+func (p *FilteringProcessor) keepRcodeFilter(dm *dnsutils.DnsMessage) bool {
+	// drop all rcodes except the kept ones
+	_, ok := p.mapKeepRcodes[dm.DNS.Rcode]
+	return !ok
+}
+
 func (p *FilteringProcessor) ipFilter(dm *dnsutils.DnsMessage) bool {
 	ip, _ := netaddr.ParseIP(dm.NetworkInfo.QueryIp)
 	if p.ipsetKeep.Contains(ip) {
@@ -296,13 +419,28 @@ func (p *FilteringProcessor) ipFilter(dm *dnsutils.DnsMessage) bool {
 	return false
 }
 
-func (p *FilteringProcessor) dropFqdnFilter(dm *dnsutils.DnsMessage) bool {
-	if _, ok := p.listFqdns[dm.DNS.Qname]; ok {
+// matchFqdn returns true if the qname is in the names or is a subdomain of one of the wildcards
+func matchFqdn(qname string, fqdns map[string]bool, wildcards map[string]bool) bool {
+	if fqdns[qname] {
 		return true
 	}
+	for i := strings.Index(qname, "."); i >= 0 && len(wildcards) > 0; {
+		if wildcards[qname[i:]] {
+			return true
+		}
+		next := strings.Index(qname[i+1:], ".")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
 	return false
 }
 
+func (p *FilteringProcessor) dropFqdnFilter(dm *dnsutils.DnsMessage) bool {
+	return matchFqdn(dm.DNS.Qname, p.listFqdns, p.listWildcards)
+}
+
 func (p *FilteringProcessor) dropDomainRegexFilter(dm *dnsutils.DnsMessage) bool {
 	// partial fqdn with regexp
 	for _, d := range p.listDomainsRegex {
@@ -314,10 +452,7 @@ func (p *FilteringProcessor) dropDomainRegexFilter(dm *dnsutils.DnsMessage) bool
 }
 
 func (p *FilteringProcessor) keepFqdnFilter(dm *dnsutils.DnsMessage) bool {
-	if _, ok := p.listKeepFqdns[dm.DNS.Qname]; ok {
-		return false
-	}
-	return true
+	return !matchFqdn(dm.DNS.Qname, p.listKeepFqdns, p.listKeepWildcards)
 }
 
 func (p *FilteringProcessor) keepDomainRegexFilter(dm *dnsutils.DnsMessage) bool {
@@ -342,7 +477,83 @@ func (p *FilteringProcessor) downsampleFilter(dm *dnsutils.DnsMessage) bool {
 	return true
 }
 
+// matchAsn returns true if one of the autonomous systems of the message matches the list,
+// an entry is an AS number (with or without the AS prefix) or a part of the AS organization
+// normalizeAsn returns the number of an asn written as AS64496, the organization
+// names are lowercased, including those starting with "as" like ASUSTeK
+func normalizeAsn(v string) string {
+	if len(v) > 2 && strings.EqualFold(v[:2], "AS") {
+		if _, err := strconv.ParseUint(v[2:], 10, 32); err == nil {
+			return v[2:]
+		}
+	}
+	return strings.ToLower(v)
+}
+
+func (p *FilteringProcessor) matchAsn(dm *dnsutils.DnsMessage, asns []string) bool {
+	if dm.Geo == nil {
+		return false
+	}
+
+	numbers, orgs := []string{}, []string{}
+	if p.asnMatchOn[dnsutils.ASN_MATCH_CLIENT] {
+		numbers = append(numbers, dm.Geo.AutonomousSystemNumber)
+		orgs = append(orgs, dm.Geo.AutonomousSystemOrg)
+	}
+	if p.asnMatchOn[dnsutils.ASN_MATCH_SERVER] {
+		numbers = append(numbers, dm.Geo.ServerAsNumber)
+		orgs = append(orgs, dm.Geo.ServerAsOrg)
+	}
+	if p.asnMatchOn[dnsutils.ASN_MATCH_ANSWER] {
+		numbers = append(numbers, dm.Geo.AnswerAsNumbers...)
+		orgs = append(orgs, dm.Geo.AnswerAsOrgs...)
+	}
+
+	for _, asn := range asns {
+		for _, number := range numbers {
+			if number == asn {
+				return true
+			}
+		}
+		for _, org := range orgs {
+			if len(org) > 0 && org != "-" && strings.Contains(strings.ToLower(org), asn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *FilteringProcessor) dropAsnFilter(dm *dnsutils.DnsMessage) bool {
+	return p.matchAsn(dm, p.dropAsns)
+}
+
+func (p *FilteringProcessor) keepAsnFilter(dm *dnsutils.DnsMessage) bool {
+	return !p.matchAsn(dm, p.keepAsns)
+}
+
+func (p *FilteringProcessor) dropExpressionFilter(dm *dnsutils.DnsMessage) bool {
+	return p.dropExpression.Match(dm)
+}
+
+func (p *FilteringProcessor) keepExpressionFilter(dm *dnsutils.DnsMessage) bool {
+	return !p.keepExpression.Match(dm)
+}
+
+// CheckIfDropEnriched applies the filters on the fields populated by the transformers
+func (p *FilteringProcessor) CheckIfDropEnriched(dm *dnsutils.DnsMessage) bool {
+	for _, fn := range p.enrichedFilters {
+		if fn(dm) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *FilteringProcessor) CheckIfDrop(dm *dnsutils.DnsMessage) bool {
+	// reload the lists updated on disk
+	p.CheckUpdates()
+
 	if len(p.activeFilters) == 0 {
 		return false
 	}
@@ -1,7 +1,10 @@
 package transformers
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
@@ -62,6 +65,25 @@ func TestFilteringByRcodeEmpty(t *testing.T) {
 	}
 }
 
+func TestFilteringKeepRcodes(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.KeepRcodes = []string{"NXDOMAIN"}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns query should be dropped")
+	}
+
+	dm.DNS.Rcode = "NXDOMAIN"
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped!")
+	}
+}
+
 func TestFilteringByQueryIp(t *testing.T) {
 	// config
 	config := dnsutils.GetFakeConfigTransformers()
@@ -288,3 +310,155 @@ func TestFilteringMultipleFilters(t *testing.T) {
 		t.Errorf("dns query should be dropped!")
 	}
 }
+
+func TestFilteringByAsn(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.DropAsns = []string{"AS64496", "bulletproof", "ASUSTeK"}
+	config.Filtering.AsnMatchOn = []string{dnsutils.ASN_MATCH_ANSWER}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.Geo = &dnsutils.DnsGeo{AutonomousSystemNumber: "64496", AutonomousSystemOrg: "-"}
+	if filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should not be dropped, client asn is not matched")
+	}
+
+	dm.Geo.AnswerAsNumbers = []string{"64500", "64496"}
+	if !filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should be dropped by answer asn number")
+	}
+
+	dm.Geo.AnswerAsNumbers = []string{"64500"}
+	dm.Geo.AnswerAsOrgs = []string{"BulletProof Hosting Ltd"}
+	if !filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should be dropped by answer asn organization")
+	}
+
+	// the organization names starting with "as" are kept as is
+	dm.Geo.AnswerAsOrgs = []string{"ASUSTeK Computer Inc."}
+	if !filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should be dropped by answer asn organization starting with AS")
+	}
+
+	// keep mode
+	config.Filtering.DropAsns = []string{}
+	config.Filtering.KeepAsns = []string{"64500"}
+	filtering = NewFilteringProcessor(config, logger.New(false), "test")
+	if filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should be kept")
+	}
+	dm.Geo = nil
+	if !filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query without geoip should be dropped")
+	}
+}
+
+func TestFilteringByExpression(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.DropExpression = `rcode == "NXDOMAIN" && qtype == "TXT" && !qname endswith ".corp.example"`
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Rcode = "NXDOMAIN"
+	dm.DNS.Qtype = "TXT"
+	if !filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should be dropped")
+	}
+
+	dm.DNS.Qname = "host.corp.example"
+	if filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should not be dropped")
+	}
+
+	// keep mode
+	config.Filtering.DropExpression = ""
+	config.Filtering.KeepExpression = `geoip.country-isocode == "FR" || length > 512`
+	filtering = NewFilteringProcessor(config, logger.New(false), "test")
+
+	dm = dnsutils.GetFakeDnsMessage()
+	if !filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should be dropped")
+	}
+	dm.Geo = &dnsutils.DnsGeo{CountryIsoCode: "FR"}
+	if filtering.CheckIfDropEnriched(&dm) {
+		t.Errorf("dns query should be kept")
+	}
+}
+
+func TestFilteringByWildcard(t *testing.T) {
+	listFile := filepath.Join(t.TempDir(), "watchlist.txt")
+	if err := os.WriteFile(listFile, []byte("# watchlist\n*.example.com\nwww.github.com\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.KeepFqdnFile = listFile
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test")
+
+	testcases := []struct {
+		qname string
+		drop  bool
+	}{
+		{qname: "www.example.com", drop: false},
+		{qname: "a.b.example.com", drop: false},
+		{qname: "example.com", drop: true},
+		{qname: "www.example.com.evil", drop: true},
+		{qname: "notexample.com", drop: true},
+		{qname: "www.github.com", drop: false},
+		{qname: "api.github.com", drop: true},
+	}
+
+	for _, tc := range testcases {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = tc.qname
+		if filtering.CheckIfDrop(&dm) != tc.drop {
+			t.Errorf("%s: want drop %v", tc.qname, tc.drop)
+		}
+	}
+}
+
+func TestFilteringReloadLists(t *testing.T) {
+	listFile := filepath.Join(t.TempDir(), "drop.txt")
+	if err := os.WriteFile(listFile, []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.DropFqdnFile = listFile
+	config.Filtering.ReloadInterval = 1
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = TEST_URL1
+	if filtering.CheckIfDrop(&dm) {
+		t.Errorf("dns query should not be dropped!")
+	}
+
+	// update the list
+	if err := os.WriteFile(listFile, []byte(TEST_URL1+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(listFile, time.Now(), time.Now().Add(time.Minute))
+
+	// not checked before the interval
+	if filtering.CheckIfDrop(&dm) {
+		t.Errorf("dns query should not be dropped before the reload interval")
+	}
+
+	filtering.lastCheck = time.Now().Add(-time.Minute)
+	if !filtering.CheckIfDrop(&dm) {
+		t.Errorf("dns query should be dropped after the reload")
+	}
+}
@@ -2,7 +2,9 @@ package transformers
 
 import (
 	"net"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
@@ -21,6 +23,8 @@ type MaxminddbRecord struct {
 	} `maxminddb:"city"`
 	AutonomousSystemNumber       int    `maxminddb:"autonomous_system_number"`
 	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+	Isp                          string `maxminddb:"isp"`
+	ConnectionType               string `maxminddb:"connection_type"`
 }
 
 type GeoRecord struct {
@@ -29,21 +33,28 @@ type GeoRecord struct {
 	City           string
 	ASN            string
 	ASO            string
+	ISP            string
+	ConnectionType string
 }
 
 type GeoIpProcessor struct {
-	config    *dnsutils.ConfigTransformers
-	logger    *logger.Logger
-	dbCountry *maxminddb.Reader
-	dbCity    *maxminddb.Reader
-	dbAsn     *maxminddb.Reader
-	enabled   bool
+	config     *dnsutils.ConfigTransformers
+	logger     *logger.Logger
+	dbCountry  *maxminddb.Reader
+	dbCity     *maxminddb.Reader
+	dbAsn      *maxminddb.Reader
+	dbIsp      *maxminddb.Reader
+	dbConnType *maxminddb.Reader
+	enabled    bool
+	modTimes   map[string]time.Time
+	lastCheck  time.Time
 }
 
 func NewDnsGeoIpProcessor(config *dnsutils.ConfigTransformers, logger *logger.Logger) GeoIpProcessor {
 	d := GeoIpProcessor{
-		config: config,
-		logger: logger,
+		config:   config,
+		logger:   logger,
+		modTimes: make(map[string]time.Time),
 	}
 
 	return d
@@ -65,39 +76,91 @@ func (p *GeoIpProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
 		AutonomousSystemNumber: "-",
 		AutonomousSystemOrg:    "-",
 	}
+	if p.config.GeoIP.LookupResponseIp {
+		dm.Geo.ServerCountryIsoCode = "-"
+		dm.Geo.ServerAsNumber = "-"
+		dm.Geo.ServerAsOrg = "-"
+	}
+	if len(p.config.GeoIP.DbIspFile) > 0 {
+		dm.Geo.Isp = "-"
+	}
+	if len(p.config.GeoIP.DbConnectionTypeFile) > 0 {
+		dm.Geo.ConnectionType = "-"
+	}
+}
+
+// openDb opens the database and keeps its modification time to detect the updates
+func (p *GeoIpProcessor) openDb(file string) (*maxminddb.Reader, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	db, err := maxminddb.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	p.modTimes[file] = info.ModTime()
+	return db, nil
 }
 
 func (p *GeoIpProcessor) Open() (err error) {
-	if len(p.config.GeoIP.DbCountryFile) > 0 {
-		p.dbCountry, err = maxminddb.Open(p.config.GeoIP.DbCountryFile)
+	dbs := []struct {
+		name string
+		file string
+		db   **maxminddb.Reader
+	}{
+		{name: "country", file: p.config.GeoIP.DbCountryFile, db: &p.dbCountry},
+		{name: "city", file: p.config.GeoIP.DbCityFile, db: &p.dbCity},
+		{name: "asn", file: p.config.GeoIP.DbAsnFile, db: &p.dbAsn},
+		{name: "isp", file: p.config.GeoIP.DbIspFile, db: &p.dbIsp},
+		{name: "connection type", file: p.config.GeoIP.DbConnectionTypeFile, db: &p.dbConnType},
+	}
+	for _, d := range dbs {
+		if len(d.file) == 0 {
+			continue
+		}
+		*d.db, err = p.openDb(d.file)
 		if err != nil {
 			p.enabled = false
 			return
 		}
 		p.enabled = true
-		p.LogInfo("country database loaded (%d records)", p.dbCountry.Metadata.NodeCount)
+		p.LogInfo("%s database loaded (%d records)", d.name, (*d.db).Metadata.NodeCount)
 	}
+	p.lastCheck = time.Now()
+	return nil
+}
 
-	if len(p.config.GeoIP.DbCityFile) > 0 {
-		p.dbCity, err = maxminddb.Open(p.config.GeoIP.DbCityFile)
-		if err != nil {
-			p.enabled = false
-			return
-		}
-		p.enabled = true
-		p.LogInfo("city database loaded (%d records)", p.dbCity.Metadata.NodeCount)
+// CheckUpdates reloads the databases updated on disk, checked at most once per reload interval.
+// The current database is kept if the new one can not be loaded.
+func (p *GeoIpProcessor) CheckUpdates() {
+	interval := time.Duration(p.config.GeoIP.ReloadInterval) * time.Second
+	if interval <= 0 || time.Since(p.lastCheck) < interval {
+		return
 	}
+	p.lastCheck = time.Now()
+
+	dbs := []**maxminddb.Reader{&p.dbCountry, &p.dbCity, &p.dbAsn, &p.dbIsp, &p.dbConnType}
+	files := []string{p.config.GeoIP.DbCountryFile, p.config.GeoIP.DbCityFile, p.config.GeoIP.DbAsnFile,
+		p.config.GeoIP.DbIspFile, p.config.GeoIP.DbConnectionTypeFile}
+	for i, file := range files {
+		if len(file) == 0 || *dbs[i] == nil {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || info.ModTime().Equal(p.modTimes[file]) {
+			continue
+		}
 
-	if len(p.config.GeoIP.DbAsnFile) > 0 {
-		p.dbAsn, err = maxminddb.Open(p.config.GeoIP.DbAsnFile)
+		db, err := p.openDb(file)
 		if err != nil {
-			p.enabled = false
-			return
+			p.LogError("unable to reload the database %s: %v", file, err)
+			continue
 		}
-		p.enabled = true
-		p.LogInfo("asn database loaded (%d records)", p.dbAsn.Metadata.NodeCount)
+		(*dbs[i]).Close()
+		*dbs[i] = db
+		p.LogInfo("database %s reloaded (%d records)", file, db.Metadata.NodeCount)
 	}
-	return nil
 }
 
 func (p *GeoIpProcessor) IsEnabled() bool {
@@ -114,6 +177,12 @@ func (p *GeoIpProcessor) Close() {
 	if p.dbAsn != nil {
 		p.dbAsn.Close()
 	}
+	if p.dbIsp != nil {
+		p.dbIsp.Close()
+	}
+	if p.dbConnType != nil {
+		p.dbConnType.Close()
+	}
 }
 
 func (p *GeoIpProcessor) Lookup(ip string) (GeoRecord, error) {
@@ -122,7 +191,9 @@ func (p *GeoIpProcessor) Lookup(ip string) (GeoRecord, error) {
 		CountryISOCode: "-",
 		City:           "-",
 		ASN:            "-",
-		ASO:            "-"}
+		ASO:            "-",
+		ISP:            "-",
+		ConnectionType: "-"}
 
 	if p.dbAsn != nil {
 		err := p.dbAsn.Lookup(net.ParseIP(ip), &record)
@@ -153,5 +224,30 @@ func (p *GeoIpProcessor) Lookup(ip string) (GeoRecord, error) {
 		}
 	}
 
+	if p.dbIsp != nil {
+		err := p.dbIsp.Lookup(net.ParseIP(ip), &record)
+		if err != nil {
+			return rec, err
+		}
+		if len(record.Isp) > 0 {
+			rec.ISP = record.Isp
+		}
+		// the isp database contains also the autonomous system
+		if p.dbAsn == nil && record.AutonomousSystemNumber > 0 {
+			rec.ASN = strconv.Itoa(record.AutonomousSystemNumber)
+			rec.ASO = record.AutonomousSystemOrganization
+		}
+	}
+
+	if p.dbConnType != nil {
+		err := p.dbConnType.Lookup(net.ParseIP(ip), &record)
+		if err != nil {
+			return rec, err
+		}
+		if len(record.ConnectionType) > 0 {
+			rec.ConnectionType = record.ConnectionType
+		}
+	}
+
 	return rec, nil
 }
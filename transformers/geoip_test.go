@@ -1,7 +1,10 @@
 package transformers
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
@@ -62,3 +65,57 @@ func TestGeoIP_LookupAsn(t *testing.T) {
 		t.Errorf("asn organisation invalid want: XX got: %s", geoInfo.ASO)
 	}
 }
+
+func TestGeoIP_ReloadOnUpdate(t *testing.T) {
+	// copy the asn database in a temp file
+	dbFile := filepath.Join(t.TempDir(), "geoip.mmdb")
+	data, err := os.ReadFile("../testsdata/GeoLite2-ASN.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dbFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.DbAsnFile = dbFile
+	config.GeoIP.ReloadInterval = 1
+
+	// init the processor
+	geoip := NewDnsGeoIpProcessor(config, logger.New(false))
+	if err := geoip.Open(); err != nil {
+		t.Fatalf("geoip init failed: %v", err)
+	}
+	defer geoip.Close()
+
+	if geoip.dbAsn.Metadata.DatabaseType != "GeoLite2-ASN" {
+		t.Fatalf("unexpected database type: %s", geoip.dbAsn.Metadata.DatabaseType)
+	}
+
+	// an invalid database is ignored
+	if err := os.WriteFile(dbFile, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(dbFile, time.Now(), time.Now().Add(time.Minute))
+	geoip.lastCheck = time.Now().Add(-time.Minute)
+	geoip.CheckUpdates()
+	if geoip.dbAsn.Metadata.DatabaseType != "GeoLite2-ASN" {
+		t.Errorf("the current database should be kept")
+	}
+
+	// update the database file
+	data, err = os.ReadFile("../testsdata/GeoLite2-Country.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dbFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(dbFile, time.Now(), time.Now().Add(2*time.Minute))
+	geoip.lastCheck = time.Now().Add(-time.Minute)
+	geoip.CheckUpdates()
+
+	if geoip.dbAsn.Metadata.DatabaseType != "GeoLite2-Country" {
+		t.Errorf("database not reloaded, got type: %s", geoip.dbAsn.Metadata.DatabaseType)
+	}
+}
@@ -0,0 +1,264 @@
+package transformers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// fields of the message used as key of the lookups
+const (
+	HOOK_KEY_QUERYIP  = "query-ip"
+	HOOK_KEY_QNAME    = "qname"
+	HOOK_KEY_IDENTITY = "identity"
+)
+
+// fields returned for a key, kept until the expiration
+type HookCacheEntry struct {
+	extra  map[string]interface{}
+	expire time.Time
+}
+
+// hook processor, looks up the keys of the messages with an external http endpoint or
+// executable and merges the fields returned in the extra map of the messages.
+// The lookups are done in background by batches, the messages are not delayed
+// unless max-wait is configured.
+type HookProcessor struct {
+	sync.Mutex
+	config  *dnsutils.ConfigTransformers
+	logger  *logger.Logger
+	name    string
+	client  *http.Client
+	cache   map[string]HookCacheEntry
+	pending map[string]chan bool
+	queue   chan string
+	slots   chan bool
+	wg      sync.WaitGroup
+	stop    chan bool
+}
+
+func NewHookSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *HookProcessor {
+	s := HookProcessor{
+		config:  config,
+		logger:  logger,
+		name:    name,
+		client:  &http.Client{Timeout: time.Duration(config.Hook.Timeout) * time.Second},
+		cache:   make(map[string]HookCacheEntry),
+		pending: make(map[string]chan bool),
+		queue:   make(chan string, 4*config.Hook.BatchSize),
+		slots:   make(chan bool, config.Hook.MaxConcurrency),
+		stop:    make(chan bool),
+	}
+	return &s
+}
+
+func (s *HookProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor hook - "+msg, v...)
+}
+
+func (s *HookProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor hook - "+msg, v...)
+}
+
+// Key returns the key of the lookup, empty if the field is not set
+func (s *HookProcessor) Key(dm *dnsutils.DnsMessage) string {
+	var key string
+	switch s.config.Hook.Key {
+	case HOOK_KEY_QUERYIP:
+		key = dm.NetworkInfo.QueryIp
+	case HOOK_KEY_QNAME:
+		key = dm.DNS.Qname
+	case HOOK_KEY_IDENTITY:
+		key = dm.DnsTap.Identity
+	}
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// Lookup sends the keys in a json array to the endpoint or to the stdin of the executable,
+// a json object is expected with the fields to add per key
+func (s *HookProcessor) Lookup(keys []string) (map[string]map[string]interface{}, error) {
+	body, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if len(s.config.Hook.Url) > 0 {
+		resp, err := s.client.Post(s.config.Hook.Url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		results := make(map[string]map[string]interface{})
+		return results, json.NewDecoder(resp.Body).Decode(&results)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.Hook.Timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.config.Hook.Command[0], s.config.Hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	if data, err = cmd.Output(); err != nil {
+		return nil, err
+	}
+	results := make(map[string]map[string]interface{})
+	return results, json.Unmarshal(data, &results)
+}
+
+// store adds the fields to the cache, the expired entries are removed when the cache is full
+// and the cache is cleared if there is still no room
+func (s *HookProcessor) store(key string, extra map[string]interface{}) {
+	if len(s.cache) >= s.config.Hook.CacheSize {
+		now := time.Now()
+		for k, v := range s.cache {
+			if now.After(v.expire) {
+				delete(s.cache, k)
+			}
+		}
+		if len(s.cache) >= s.config.Hook.CacheSize {
+			s.cache = make(map[string]HookCacheEntry)
+		}
+	}
+	s.cache[key] = HookCacheEntry{extra: extra, expire: time.Now().Add(time.Duration(s.config.Hook.CacheTtl) * time.Second)}
+}
+
+// cached returns the fields of the key if not expired
+func (s *HookProcessor) cached(key string) (map[string]interface{}, bool) {
+	s.Lock()
+	defer s.Unlock()
+	entry, ok := s.cache[key]
+	if !ok || time.Now().After(entry.expire) {
+		return nil, false
+	}
+	return entry.extra, true
+}
+
+func mergeExtra(dm *dnsutils.DnsMessage, extra map[string]interface{}) {
+	if len(extra) == 0 {
+		return
+	}
+	// the map of the message can be shared with the copies sent to the other loggers,
+	// the fields are merged in a new one
+	merged := make(map[string]interface{}, len(dm.Extra)+len(extra))
+	for k, v := range dm.Extra {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	dm.Extra = merged
+}
+
+// Enrich merges the fields of the key from the cache, on a miss the key is queued for the
+// next batch and the message waits for the lookup during max-wait milliseconds at most
+func (s *HookProcessor) Enrich(dm *dnsutils.DnsMessage) {
+	key := s.Key(dm)
+	if len(key) == 0 {
+		return
+	}
+	if extra, ok := s.cached(key); ok {
+		mergeExtra(dm, extra)
+		return
+	}
+
+	s.Lock()
+	ready, ok := s.pending[key]
+	if !ok {
+		select {
+		case s.queue <- key:
+			ready = make(chan bool)
+			s.pending[key] = ready
+		default:
+			// the queue is full, the key will be queued again by the next messages
+			s.Unlock()
+			return
+		}
+	}
+	s.Unlock()
+
+	if s.config.Hook.MaxWait <= 0 {
+		return
+	}
+	timer := time.NewTimer(time.Duration(s.config.Hook.MaxWait) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		if extra, ok := s.cached(key); ok {
+			mergeExtra(dm, extra)
+		}
+	case <-timer.C:
+	}
+}
+
+// flush looks up the keys of the batch in background, the number of lookups in progress
+// is limited by max-concurrency
+func (s *HookProcessor) flush(keys []string) {
+	s.slots <- true
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.slots
+			s.wg.Done()
+		}()
+
+		results, err := s.Lookup(keys)
+		if err != nil {
+			s.LogError("lookup error: %v", err)
+		}
+
+		s.Lock()
+		defer s.Unlock()
+		for _, key := range keys {
+			// on error the keys are not cached, they are looked up again with the next messages
+			if err == nil {
+				s.store(key, results[key])
+			}
+			if ready, ok := s.pending[key]; ok {
+				close(ready)
+				delete(s.pending, key)
+			}
+		}
+	}()
+}
+
+func (s *HookProcessor) Run() {
+	ticker := time.NewTicker(time.Duration(s.config.Hook.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := []string{}
+	for {
+		select {
+		case <-s.stop:
+			s.wg.Wait()
+			return
+		case key := <-s.queue:
+			batch = append(batch, key)
+			if len(batch) >= s.config.Hook.BatchSize {
+				s.flush(batch)
+				batch = []string{}
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.flush(batch)
+				batch = []string{}
+			}
+		}
+	}
+}
+
+func (s *HookProcessor) Stop() {
+	s.stop <- true
+}
@@ -0,0 +1,153 @@
+package transformers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestHook_Http(t *testing.T) {
+	// fake inventory, the keys are received in a json array
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		keys := []string{}
+		json.NewDecoder(r.Body).Decode(&keys)
+
+		results := make(map[string]map[string]interface{})
+		for _, key := range keys {
+			if key == "1.2.3.4" {
+				results[key] = map[string]interface{}{"owner": "it", "asset": "laptop-42"}
+			}
+		}
+		json.NewEncoder(w).Encode(results)
+	}))
+	defer server.Close()
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Hook.Enable = true
+	config.Hook.Url = server.URL
+	config.Hook.FlushInterval = 10
+	config.Hook.MaxWait = 2000
+
+	// init subproccesor
+	hook := NewHookSubprocessor(config, logger.New(false), "test")
+	go hook.Run()
+	defer hook.Stop()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	hook.Enrich(&dm)
+	if dm.Extra["owner"] != "it" || dm.Extra["asset"] != "laptop-42" {
+		t.Errorf("invalid extra fields: %v", dm.Extra)
+	}
+
+	// the unknown keys are cached too
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "10.0.0.1"
+	hook.Enrich(&dm)
+	if dm.Extra != nil {
+		t.Errorf("no extra fields expected: %v", dm.Extra)
+	}
+
+	// from the cache
+	for _, ip := range []string{"1.2.3.4", "10.0.0.1"} {
+		dm = dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.QueryIp = ip
+		hook.Enrich(&dm)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("want 2 requests, got %d", requests.Load())
+	}
+}
+
+func TestHook_Batch(t *testing.T) {
+	// the lookups are sent in a single batch
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		keys := []string{}
+		json.NewDecoder(r.Body).Decode(&keys)
+
+		results := make(map[string]map[string]interface{})
+		for _, key := range keys {
+			results[key] = map[string]interface{}{"site": "paris"}
+		}
+		json.NewEncoder(w).Encode(results)
+	}))
+	defer server.Close()
+
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Hook.Enable = true
+	config.Hook.Url = server.URL
+	config.Hook.Key = HOOK_KEY_QNAME
+	config.Hook.FlushInterval = 50
+
+	hook := NewHookSubprocessor(config, logger.New(false), "test")
+	go hook.Run()
+	defer hook.Stop()
+
+	// without max-wait, the messages are not enriched until the lookup is done
+	qnames := []string{"a.collector", "b.collector", "c.collector"}
+	for _, qname := range qnames {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		hook.Enrich(&dm)
+		if dm.Extra != nil {
+			t.Errorf("no extra fields expected: %v", dm.Extra)
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	for _, qname := range qnames {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		hook.Enrich(&dm)
+		if dm.Extra["site"] != "paris" {
+			t.Errorf("invalid extra fields for %s: %v", qname, dm.Extra)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("want 1 request, got %d", requests.Load())
+	}
+}
+
+func TestHook_Exec(t *testing.T) {
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Hook.Enable = true
+	config.Hook.Command = []string{"sh", "-c", `cat > /dev/null; echo '{"1.2.3.4": {"owner": "it"}}'`}
+	config.Hook.FlushInterval = 10
+	config.Hook.MaxWait = 2000
+
+	hook := NewHookSubprocessor(config, logger.New(false), "test")
+	go hook.Run()
+	defer hook.Stop()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	hook.Enrich(&dm)
+	if dm.Extra["owner"] != "it" {
+		t.Errorf("invalid extra fields: %v", dm.Extra)
+	}
+}
+
+func TestHook_MergeExtraCopy(t *testing.T) {
+	// the map is shared by two copies of the message
+	shared := map[string]interface{}{"site": "paris"}
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.Extra = shared
+	other := dm
+
+	mergeExtra(&dm, map[string]interface{}{"owner": "it"})
+	if dm.Extra["site"] != "paris" || dm.Extra["owner"] != "it" {
+		t.Errorf("invalid extra fields: %v", dm.Extra)
+	}
+	if _, ok := other.Extra["owner"]; ok || len(shared) != 1 {
+		t.Errorf("shared extra fields modified: %v", other.Extra)
+	}
+}
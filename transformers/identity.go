@@ -0,0 +1,69 @@
+package transformers
+
+import (
+	"regexp"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// identity processor, rewrites the identity of the messages so all the resolvers
+// of a fleet are reported with consistent labels
+type IdentityProcessor struct {
+	config *dnsutils.ConfigTransformers
+	logger *logger.Logger
+	name   string
+	regex  *regexp.Regexp
+}
+
+func NewIdentitySubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *IdentityProcessor {
+	s := IdentityProcessor{
+		config: config,
+		logger: logger,
+		name:   name,
+	}
+
+	if config.Identity.Enable && len(config.Identity.Regex) > 0 {
+		regex, err := regexp.Compile(config.Identity.Regex)
+		if err != nil {
+			s.LogError("invalid regex %s: %v", config.Identity.Regex, err)
+		} else {
+			s.regex = regex
+		}
+	}
+	return &s
+}
+
+func (s *IdentityProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor identity - "+msg, v...)
+}
+
+// Relabel rewrites the identity: the fallback is used when the identity is missing,
+// then the identity is replaced by its mapping or by the first group extracted by the regex
+func (s *IdentityProcessor) Relabel(dm *dnsutils.DnsMessage) {
+	identity := dm.DnsTap.Identity
+	if len(identity) == 0 || identity == "-" {
+		switch s.config.Identity.Fallback {
+		case dnsutils.IDENTITY_FALLBACK_COLLECTOR:
+			identity = s.name
+		case dnsutils.IDENTITY_FALLBACK_RESPONSE_IP:
+			identity = dm.NetworkInfo.ResponseIp
+		}
+	}
+
+	if label, ok := s.config.Identity.Mapping[identity]; ok {
+		dm.DnsTap.Identity = label
+		return
+	}
+
+	if s.regex != nil {
+		if match := s.regex.FindStringSubmatch(identity); match != nil {
+			if len(match) > 1 {
+				identity = match[1]
+			} else {
+				identity = match[0]
+			}
+		}
+	}
+	dm.DnsTap.Identity = identity
+}
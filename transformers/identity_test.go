@@ -0,0 +1,51 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestIdentity_Relabel(t *testing.T) {
+	testcases := []struct {
+		name     string
+		identity string
+		mapping  map[string]string
+		regex    string
+		fallback string
+		want     string
+	}{
+		{name: "mapping", identity: "ns1.example.com", mapping: map[string]string{"ns1.example.com": "paris-01"}, want: "paris-01"},
+		{name: "unmapped", identity: "ns2.example.com", mapping: map[string]string{"ns1.example.com": "paris-01"}, want: "ns2.example.com"},
+		{name: "regex group", identity: "resolver-par-02", regex: `^resolver-([a-z]+)-`, want: "par"},
+		{name: "regex match", identity: "resolver-par-02", regex: `[a-z]+-[0-9]+$`, want: "par-02"},
+		{name: "regex no match", identity: "dns1", regex: `^resolver-([a-z]+)-`, want: "dns1"},
+		{name: "fallback collector", identity: "-", fallback: dnsutils.IDENTITY_FALLBACK_COLLECTOR, want: "test"},
+		{name: "fallback response ip", identity: "", fallback: dnsutils.IDENTITY_FALLBACK_RESPONSE_IP, want: "4.3.2.1"},
+		{name: "fallback mapped", identity: "-", fallback: dnsutils.IDENTITY_FALLBACK_RESPONSE_IP, mapping: map[string]string{"4.3.2.1": "lyon-01"}, want: "lyon-01"},
+		{name: "no fallback", identity: "-", want: "-"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := dnsutils.GetFakeConfigTransformers()
+			config.Identity.Enable = true
+			config.Identity.Regex = tc.regex
+			config.Identity.Fallback = tc.fallback
+			if tc.mapping != nil {
+				config.Identity.Mapping = tc.mapping
+			}
+
+			identity := NewIdentitySubprocessor(config, logger.New(false), "test")
+
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DnsTap.Identity = tc.identity
+			identity.Relabel(&dm)
+
+			if dm.DnsTap.Identity != tc.want {
+				t.Errorf("want identity %s, got %s", tc.want, dm.DnsTap.Identity)
+			}
+		})
+	}
+}
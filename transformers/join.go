@@ -0,0 +1,127 @@
+package transformers
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// join processor, keeps the queries until their responses to copy the query
+// context on the responses sent without the question section
+type JoinProcessor struct {
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	queries     *QueriesCache[dnsutils.DnsMessage]
+	outChannels []chan dnsutils.DnsMessage
+	stop        chan bool
+}
+
+func NewJoinSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *JoinProcessor {
+	s := JoinProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		queries:     NewQueriesCache[dnsutils.DnsMessage](config.Join.CacheSize, time.Duration(config.Join.QueriesTimeout)*time.Second),
+		outChannels: outChannels,
+		stop:        make(chan bool),
+	}
+	return &s
+}
+
+// Key returns the hash of the query ip, the query port and the dns id, false
+// if the message can not be joined
+func (s *JoinProcessor) Key(dm *dnsutils.DnsMessage) (uint64, bool) {
+	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
+	if len(dm.NetworkInfo.QueryIp) == 0 || queryport == 0 || dm.DNS.Length < dnsutils.DnsLen {
+		return 0, false
+	}
+
+	hash_data := []string{dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.Id)}
+
+	hashfnv := fnv.New64a()
+	hashfnv.Write([]byte(strings.Join(hash_data[:], "+")))
+	return hashfnv.Sum64(), true
+}
+
+// Join stores the queries and enriches the responses with their query, returns
+// true if the query must be dropped because it is merged with its response
+func (s *JoinProcessor) Join(dm *dnsutils.DnsMessage) bool {
+	// already joined, the unanswered queries are sent again in merge mode
+	if dm.Join != nil {
+		return false
+	}
+	dm.Join = &dnsutils.TransformJoin{QueryTimestamp: "-"}
+
+	key, ok := s.Key(dm)
+	if !ok {
+		return false
+	}
+
+	if dm.DNS.Type == dnsutils.DnsQuery {
+		s.queries.Set(key, *dm)
+		return s.config.Join.MergeRecords
+	}
+
+	query, ok := s.queries.Pop(key)
+	if !ok {
+		return false
+	}
+
+	dm.Join.Matched = true
+	dm.Join.QueryTimestamp = query.DnsTap.TimestampRFC3339
+	dm.Join.QueryLength = query.DNS.Length
+
+	// the question section is missing or truncated in the response
+	if dm.DNS.Qname == "-" || len(dm.DNS.Qname) == 0 {
+		dm.DNS.Qname = query.DNS.Qname
+		dm.DNS.Qtype = query.DNS.Qtype
+		dm.Join.Restored = true
+	}
+
+	if dm.DnsTap.Latency == 0 {
+		dm.DnsTap.Latency = dm.DnsTap.Timestamp - query.DnsTap.Timestamp
+	}
+	return false
+}
+
+// Sweep removes the expired queries, in merge mode the queries without response
+// are emitted with the TIMEOUT rcode
+func (s *JoinProcessor) Sweep(now time.Time) {
+	for _, dm := range s.queries.Expire(now) {
+		if !s.config.Join.MergeRecords {
+			continue
+		}
+		dm.DNS.Rcode = "TIMEOUT"
+		for i := range s.outChannels {
+			s.outChannels[i] <- dm
+		}
+	}
+}
+
+// Flush emits the queries still waiting for their response, in merge mode
+func (s *JoinProcessor) Flush() {
+	s.Sweep(time.Now().Add(s.queries.ttl))
+}
+
+func (s *JoinProcessor) Run() {
+	ticker := time.NewTicker(queriesCacheSweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.Sweep(now)
+		}
+	}
+}
+
+func (s *JoinProcessor) Stop() {
+	s.stop <- true
+}
@@ -0,0 +1,133 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestJoin_RestoreQuestion(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Join.Enable = true
+
+	outChans := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 1)}
+	join := NewJoinSubprocessor(config, logger.New(false), "test", outChans)
+
+	// the query is kept and sent
+	query := dnsutils.GetFakeDnsMessage()
+	query.DNS.Id = 42
+	query.DNS.Length = 32
+	query.DnsTap.TimestampRFC3339 = "2023-10-30T10:00:00Z"
+	if join.Join(&query) {
+		t.Errorf("query should not be dropped")
+	}
+
+	// response without question section
+	reply := dnsutils.GetFakeDnsMessage()
+	reply.DNS.Type = dnsutils.DnsReply
+	reply.DNS.Id = 42
+	reply.DNS.Length = 12
+	reply.DNS.Qname = "-"
+	reply.DNS.Qtype = "-"
+	join.Join(&reply)
+
+	if reply.DNS.Qname != "dns.collector" || reply.DNS.Qtype != "A" {
+		t.Errorf("question not restored: %s %s", reply.DNS.Qname, reply.DNS.Qtype)
+	}
+	if !reply.Join.Matched || !reply.Join.Restored {
+		t.Errorf("invalid join: %+v", reply.Join)
+	}
+	if reply.Join.QueryTimestamp != "2023-10-30T10:00:00Z" || reply.Join.QueryLength != 32 {
+		t.Errorf("invalid query context: %+v", reply.Join)
+	}
+
+	// the query has been consumed
+	other := dnsutils.GetFakeDnsMessage()
+	other.DNS.Type = dnsutils.DnsReply
+	other.DNS.Id = 42
+	other.DNS.Length = 12
+	join.Join(&other)
+	if other.Join.Matched {
+		t.Errorf("query already joined")
+	}
+}
+
+func TestJoin_MergeRecords(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Join.Enable = true
+	config.Join.MergeRecords = true
+	config.Join.QueriesTimeout = 1
+
+	outChan := make(chan dnsutils.DnsMessage, 1)
+	join := NewJoinSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+	go join.Run()
+	defer join.Stop()
+
+	// the queries are held until the response
+	query := dnsutils.GetFakeDnsMessage()
+	query.DNS.Id = 1
+	query.DNS.Length = 32
+	if !join.Join(&query) {
+		t.Errorf("query should be merged with the response")
+	}
+
+	reply := dnsutils.GetFakeDnsMessage()
+	reply.DNS.Type = dnsutils.DnsReply
+	reply.DNS.Id = 1
+	reply.DNS.Length = 48
+	if join.Join(&reply) {
+		t.Errorf("reply should not be dropped")
+	}
+	if !reply.Join.Matched || reply.Join.Restored {
+		t.Errorf("invalid join: %+v", reply.Join)
+	}
+
+	// query without response, emitted after the timeout
+	unanswered := dnsutils.GetFakeDnsMessage()
+	unanswered.DNS.Id = 2
+	unanswered.DNS.Length = 32
+	join.Join(&unanswered)
+
+	select {
+	case dm := <-outChan:
+		if dm.DNS.Id != 2 || dm.DNS.Rcode != "TIMEOUT" {
+			t.Errorf("unanswered query expected: %d %s", dm.DNS.Id, dm.DNS.Rcode)
+		}
+		// not stored again when the message comes back
+		if join.Join(&dm) {
+			t.Errorf("unanswered query should not be held again")
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("no unanswered query emitted")
+	}
+}
+
+func TestJoin_FlushPendingQueries(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Join.Enable = true
+	config.Join.MergeRecords = true
+
+	outChan := make(chan dnsutils.DnsMessage, 1)
+	join := NewJoinSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+
+	query := dnsutils.GetFakeDnsMessage()
+	query.DNS.Id = 3
+	query.DNS.Length = 32
+	join.Join(&query)
+
+	// the query waiting for its response is emitted on flush, before the timeout
+	join.Flush()
+	select {
+	case dm := <-outChan:
+		if dm.DNS.Id != 3 || dm.DNS.Rcode != "TIMEOUT" {
+			t.Errorf("pending query expected: %d %s", dm.DNS.Id, dm.DNS.Rcode)
+		}
+	default:
+		t.Errorf("no pending query emitted on flush")
+	}
+}
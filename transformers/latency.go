@@ -1,94 +1,238 @@
 package transformers
 
 import (
+	"container/list"
 	"hash/fnv"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
 )
 
-// queries map
-type MapQueries struct {
-	sync.RWMutex
-	ttl      time.Duration
-	kv       map[uint64]dnsutils.DnsMessage
-	channels []chan dnsutils.DnsMessage
+const (
+	// number of shards of the queries caches, each shard has its own lock
+	queriesCacheShards = 16
+	// interval between the removals of the expired queries
+	queriesCacheSweep = 250 * time.Millisecond
+)
+
+// latency processors of a worker, one per connection and per decoding worker, the counters of
+// the processors stopped are kept to be monotonic
+type latencyWorker struct {
+	processors map[*LatencyProcessor]bool
+	stopped    map[string]QueriesCacheStats
 }
 
-func NewMapQueries(ttl time.Duration, channels []chan dnsutils.DnsMessage) MapQueries {
-	return MapQueries{
+// latency processors, by name of collector or logger
+var (
+	latencyMutex    sync.Mutex
+	latencyRegistry = make(map[string]*latencyWorker)
+)
+
+// GetLatencyStats returns the counters of the queries caches of the latency processors summed
+// by name of collector or logger, then by cache (latency or unanswered)
+func GetLatencyStats() map[string]map[string]QueriesCacheStats {
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+
+	stats := make(map[string]map[string]QueriesCacheStats)
+	for name, w := range latencyRegistry {
+		caches := make(map[string]QueriesCacheStats)
+		for cache, counters := range w.stopped {
+			caches[cache] = counters
+		}
+		for s := range w.processors {
+			for cache, counters := range s.Stats() {
+				caches[cache] = caches[cache].Add(counters)
+			}
+		}
+		stats[name] = caches
+	}
+	return stats
+}
+
+// QueriesCacheStats are the counters of a queries cache, the hits and the misses are
+// the replies matched or not with a query
+type QueriesCacheStats struct {
+	Entries   int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Expired   uint64
+}
+
+// Add returns the sum of the counters
+func (c QueriesCacheStats) Add(other QueriesCacheStats) QueriesCacheStats {
+	return QueriesCacheStats{
+		Entries:   c.Entries + other.Entries,
+		Hits:      c.Hits + other.Hits,
+		Misses:    c.Misses + other.Misses,
+		Evictions: c.Evictions + other.Evictions,
+		Expired:   c.Expired + other.Expired,
+	}
+}
+
+type queriesCacheEntry[T any] struct {
+	key    uint64
+	value  T
+	expire time.Time
+}
+
+// shard of the cache, the most recent queries are in front of the list
+type queriesCacheShard[T any] struct {
+	sync.Mutex
+	lru     *list.List
+	entries map[uint64]*list.Element
+}
+
+// QueriesCache keeps the queries until their reply or their expiration, the cache is divided
+// in shards with a bounded size: the least recent query of a full shard is evicted
+type QueriesCache[T any] struct {
+	ttl       time.Duration
+	capacity  int
+	shards    []*queriesCacheShard[T]
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	expired   atomic.Uint64
+}
+
+func NewQueriesCache[T any](size int, ttl time.Duration) *QueriesCache[T] {
+	capacity := (size + queriesCacheShards - 1) / queriesCacheShards
+	if capacity < 1 {
+		capacity = 1
+	}
+	c := &QueriesCache[T]{
 		ttl:      ttl,
-		kv:       make(map[uint64]dnsutils.DnsMessage),
-		channels: channels,
+		capacity: capacity,
+		shards:   make([]*queriesCacheShard[T], queriesCacheShards),
+	}
+	for i := range c.shards {
+		c.shards[i] = &queriesCacheShard[T]{lru: list.New(), entries: make(map[uint64]*list.Element)}
 	}
+	return c
 }
 
-func (mp *MapQueries) Exists(key uint64) (ok bool) {
-	mp.RLock()
-	defer mp.RUnlock()
-	_, ok = mp.kv[key]
-	return ok
+func (c *QueriesCache[T]) shard(key uint64) *queriesCacheShard[T] {
+	return c.shards[key%queriesCacheShards]
 }
 
-func (mp *MapQueries) Set(key uint64, dm dnsutils.DnsMessage) {
-	mp.Lock()
-	defer mp.Unlock()
-	mp.kv[key] = dm
-	time.AfterFunc(mp.ttl, func() {
-		if mp.Exists(key) {
-			dm.DNS.Rcode = "TIMEOUT"
-			for i := range mp.channels {
-				mp.channels[i] <- dm
-			}
-		}
-		mp.Delete(key)
-	})
+// Set adds the query to the cache or refreshes it
+func (c *QueriesCache[T]) Set(key uint64, value T) {
+	sh := c.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+
+	expire := time.Now().Add(c.ttl)
+	if elem, ok := sh.entries[key]; ok {
+		entry := elem.Value.(*queriesCacheEntry[T])
+		entry.value = value
+		entry.expire = expire
+		sh.lru.MoveToFront(elem)
+		return
+	}
+
+	if sh.lru.Len() >= c.capacity {
+		oldest := sh.lru.Back()
+		sh.lru.Remove(oldest)
+		delete(sh.entries, oldest.Value.(*queriesCacheEntry[T]).key)
+		c.evictions.Add(1)
+	}
+	sh.entries[key] = sh.lru.PushFront(&queriesCacheEntry[T]{key: key, value: value, expire: expire})
 }
 
-func (mp *MapQueries) Delete(key uint64) {
-	mp.Lock()
-	defer mp.Unlock()
-	delete(mp.kv, key)
+// Get returns the query if it is not expired
+func (c *QueriesCache[T]) Get(key uint64) (value T, ok bool) {
+	sh := c.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+
+	elem, ok := sh.entries[key]
+	if !ok {
+		return value, false
+	}
+	entry := elem.Value.(*queriesCacheEntry[T])
+	if time.Now().After(entry.expire) {
+		return value, false
+	}
+	return entry.value, true
 }
 
-// hash queries map
-type HashQueries struct {
-	sync.RWMutex
-	ttl time.Duration
-	kv  map[uint64]float64
+// Pop removes and returns the query matching a reply, the hits and misses are counted
+func (c *QueriesCache[T]) Pop(key uint64) (value T, ok bool) {
+	sh := c.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+
+	elem, ok := sh.entries[key]
+	// the expired query is kept, it is removed by the next expiration
+	if !ok || time.Now().After(elem.Value.(*queriesCacheEntry[T]).expire) {
+		c.misses.Add(1)
+		return value, false
+	}
+
+	sh.lru.Remove(elem)
+	delete(sh.entries, key)
+	c.hits.Add(1)
+	return elem.Value.(*queriesCacheEntry[T]).value, true
 }
 
-func NewHashQueries(ttl time.Duration) HashQueries {
-	return HashQueries{
-		ttl: ttl,
-		kv:  make(map[uint64]float64),
+// Delete removes the query without counting a hit
+func (c *QueriesCache[T]) Delete(key uint64) {
+	sh := c.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+
+	if elem, ok := sh.entries[key]; ok {
+		sh.lru.Remove(elem)
+		delete(sh.entries, key)
 	}
 }
 
-func (mp *HashQueries) Get(key uint64) (value float64, ok bool) {
-	mp.RLock()
-	defer mp.RUnlock()
-	result, ok := mp.kv[key]
-	return result, ok
+// Expire removes the queries expired at the time provided and returns them,
+// the oldest queries are at the back of the shards
+func (c *QueriesCache[T]) Expire(now time.Time) []T {
+	var values []T
+	for _, sh := range c.shards {
+		sh.Lock()
+		for elem := sh.lru.Back(); elem != nil; elem = sh.lru.Back() {
+			entry := elem.Value.(*queriesCacheEntry[T])
+			if !now.After(entry.expire) {
+				break
+			}
+			sh.lru.Remove(elem)
+			delete(sh.entries, entry.key)
+			values = append(values, entry.value)
+		}
+		sh.Unlock()
+	}
+	c.expired.Add(uint64(len(values)))
+	return values
 }
 
-func (mp *HashQueries) Set(key uint64, value float64) {
-	mp.Lock()
-	defer mp.Unlock()
-	mp.kv[key] = value
-	time.AfterFunc(mp.ttl, func() {
-		mp.Delete(key)
-	})
+// Len returns the number of queries in the cache
+func (c *QueriesCache[T]) Len() int {
+	n := 0
+	for _, sh := range c.shards {
+		sh.Lock()
+		n += sh.lru.Len()
+		sh.Unlock()
+	}
+	return n
 }
 
-func (mp *HashQueries) Delete(key uint64) {
-	mp.Lock()
-	defer mp.Unlock()
-	delete(mp.kv, key)
+func (c *QueriesCache[T]) Stats() QueriesCacheStats {
+	return QueriesCacheStats{
+		Entries:   c.Len(),
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Expired:   c.expired.Load(),
+	}
 }
 
 // latency processor
@@ -96,64 +240,129 @@ type LatencyProcessor struct {
 	config      *dnsutils.ConfigTransformers
 	logger      *logger.Logger
 	name        string
-	hashQueries HashQueries
-	mapQueries  MapQueries
+	hashQueries *QueriesCache[float64]
+	mapQueries  *QueriesCache[dnsutils.DnsMessage]
 	outChannels []chan dnsutils.DnsMessage
+	unanswered  []func(dm *dnsutils.DnsMessage)
+	stop        chan bool
 }
 
 func NewLatencySubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *LatencyProcessor {
+	ttl := time.Duration(config.Latency.QueriesTimeout) * time.Second
 	s := LatencyProcessor{
 		config:      config,
 		logger:      logger,
 		name:        name,
 		outChannels: outChannels,
+		hashQueries: NewQueriesCache[float64](config.Latency.CacheSize, ttl),
+		mapQueries:  NewQueriesCache[dnsutils.DnsMessage](config.Latency.CacheSize, ttl),
+		stop:        make(chan bool),
 	}
+	return &s
+}
 
-	s.hashQueries = NewHashQueries(time.Duration(config.Latency.QueriesTimeout) * time.Second)
-	s.mapQueries = NewMapQueries(time.Duration(config.Latency.QueriesTimeout)*time.Second, outChannels)
+// OnUnanswered adds a function called with each unanswered query, before Run
+func (s *LatencyProcessor) OnUnanswered(fn func(dm *dnsutils.DnsMessage)) {
+	s.unanswered = append(s.unanswered, fn)
+}
 
-	return &s
+// Stats returns the counters of the caches enabled
+func (s *LatencyProcessor) Stats() map[string]QueriesCacheStats {
+	stats := make(map[string]QueriesCacheStats)
+	if s.config.Latency.MeasureLatency {
+		stats["latency"] = s.hashQueries.Stats()
+	}
+	if s.config.Latency.UnansweredQueries {
+		stats["unanswered"] = s.mapQueries.Stats()
+	}
+	return stats
 }
 
-func (s *LatencyProcessor) MeasureLatency(dm *dnsutils.DnsMessage) {
+// queryKey returns the key of the query, false is returned when the message can't be matched
+func (s *LatencyProcessor) queryKey(dm *dnsutils.DnsMessage) (uint64, bool) {
 	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
-	if len(dm.NetworkInfo.QueryIp) > 0 && queryport > 0 && !dm.DNS.MalformedPacket {
-		// compute the hash of the query
-		hash_data := []string{dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.Id)}
-
-		hashfnv := fnv.New64a()
-		hashfnv.Write([]byte(strings.Join(hash_data[:], "+")))
-
-		if dm.DNS.Type == dnsutils.DnsQuery {
-			s.hashQueries.Set(hashfnv.Sum64(), dm.DnsTap.Timestamp)
-		} else {
-			key := hashfnv.Sum64()
-			value, ok := s.hashQueries.Get(key)
-			if ok {
-				s.hashQueries.Delete(key)
-				dm.DnsTap.Latency = dm.DnsTap.Timestamp - value
-			}
-		}
+	if len(dm.NetworkInfo.QueryIp) == 0 || queryport <= 0 || dm.DNS.MalformedPacket {
+		return 0, false
+	}
+
+	hash_data := []string{dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.Id), dm.DNS.Qname}
+	hashfnv := fnv.New64a()
+	hashfnv.Write([]byte(strings.Join(hash_data[:], "+")))
+	return hashfnv.Sum64(), true
+}
+
+func (s *LatencyProcessor) MeasureLatency(dm *dnsutils.DnsMessage) {
+	key, ok := s.queryKey(dm)
+	if !ok {
+		return
+	}
+
+	if dm.DNS.Type == dnsutils.DnsQuery {
+		s.hashQueries.Set(key, dm.DnsTap.Timestamp)
+	} else if value, ok := s.hashQueries.Pop(key); ok {
+		dm.DnsTap.Latency = dm.DnsTap.Timestamp - value
 	}
 }
 
 func (s *LatencyProcessor) DetectEvictedTimeout(dm *dnsutils.DnsMessage) {
+	key, ok := s.queryKey(dm)
+	if !ok {
+		return
+	}
 
-	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
-	if len(dm.NetworkInfo.QueryIp) > 0 && queryport > 0 && !dm.DNS.MalformedPacket {
-		// compute the hash of the query
-		hash_data := []string{dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.Id)}
-
-		hashfnv := fnv.New64a()
-		hashfnv.Write([]byte(strings.Join(hash_data[:], "+")))
-		key := hashfnv.Sum64()
-
-		if dm.DNS.Type == dnsutils.DnsQuery {
-			s.mapQueries.Set(key, *dm)
-		} else {
-			if s.mapQueries.Exists(key) {
-				s.mapQueries.Delete(key)
+	if dm.DNS.Type == dnsutils.DnsQuery {
+		s.mapQueries.Set(key, *dm)
+	} else {
+		s.mapQueries.Pop(key)
+	}
+}
+
+// Sweep removes the expired queries, the unanswered ones are sent with the TIMEOUT rcode
+func (s *LatencyProcessor) Sweep(now time.Time) {
+	s.hashQueries.Expire(now)
+
+	for _, dm := range s.mapQueries.Expire(now) {
+		dm.DNS.Rcode = dnsutils.DNS_RCODE_TIMEOUT
+		for _, fn := range s.unanswered {
+			fn(&dm)
+		}
+		for i := range s.outChannels {
+			s.outChannels[i] <- dm
+		}
+	}
+}
+
+func (s *LatencyProcessor) Run() {
+	latencyMutex.Lock()
+	w, ok := latencyRegistry[s.name]
+	if !ok {
+		w = &latencyWorker{processors: make(map[*LatencyProcessor]bool), stopped: make(map[string]QueriesCacheStats)}
+		latencyRegistry[s.name] = w
+	}
+	w.processors[s] = true
+	latencyMutex.Unlock()
+
+	ticker := time.NewTicker(queriesCacheSweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			// the counters are kept, the queries of the caches are not
+			latencyMutex.Lock()
+			delete(w.processors, s)
+			for cache, counters := range s.Stats() {
+				counters.Entries = 0
+				w.stopped[cache] = w.stopped[cache].Add(counters)
 			}
+			latencyMutex.Unlock()
+			return
+		case now := <-ticker.C:
+			s.Sweep(now)
 		}
 	}
 }
+
+func (s *LatencyProcessor) Stop() {
+	s.stop <- true
+}
@@ -5,11 +5,14 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
 )
 
-func Test_HashQueries(t *testing.T) {
+func Test_QueriesCache(t *testing.T) {
 	// init map
-	mapttl := NewHashQueries(2 * time.Second)
+	mapttl := NewQueriesCache[float64](100000, 2*time.Second)
 
 	// Set a new key/value
 	mapttl.Set(uint64(1), float64(0))
@@ -21,9 +24,9 @@ func Test_HashQueries(t *testing.T) {
 	}
 }
 
-func Test_HashQueries_Expire(t *testing.T) {
+func Test_QueriesCache_Expire(t *testing.T) {
 	// ini map
-	mapttl := NewHashQueries(1 * time.Second)
+	mapttl := NewQueriesCache[float64](100000, 1*time.Second)
 
 	// Set a new key/value
 	mapttl.Set(uint64(1), float64(0))
@@ -38,8 +41,8 @@ func Test_HashQueries_Expire(t *testing.T) {
 	}
 }
 
-func Benchmark_HashQueries_Set(b *testing.B) {
-	mapexpire := NewHashQueries(10 * time.Second)
+func Benchmark_QueriesCache_Set(b *testing.B) {
+	mapexpire := NewQueriesCache[float64](100000, 10*time.Second)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -47,8 +50,8 @@ func Benchmark_HashQueries_Set(b *testing.B) {
 	}
 }
 
-func Benchmark_HashQueries_Delete(b *testing.B) {
-	mapexpire := NewHashQueries(60 * time.Second)
+func Benchmark_QueriesCache_Delete(b *testing.B) {
+	mapexpire := NewQueriesCache[float64](100000, 60*time.Second)
 
 	for i := 0; i < b.N; i++ {
 		mapexpire.Set(uint64(i), float64(i))
@@ -61,8 +64,8 @@ func Benchmark_HashQueries_Delete(b *testing.B) {
 	}
 }
 
-func Benchmark_HashQueries_Get(b *testing.B) {
-	mapexpire := NewHashQueries(60 * time.Second)
+func Benchmark_QueriesCache_Get(b *testing.B) {
+	mapexpire := NewQueriesCache[float64](100000, 60*time.Second)
 
 	for i := 0; i < b.N; i++ {
 		mapexpire.Set(uint64(i), float64(i))
@@ -79,8 +82,8 @@ func Benchmark_HashQueries_Get(b *testing.B) {
 	}
 }
 
-func Benchmark_HashQueries_ConcurrentGet(b *testing.B) {
-	mapexpire := NewHashQueries(60 * time.Second)
+func Benchmark_QueriesCache_ConcurrentGet(b *testing.B) {
+	mapexpire := NewQueriesCache[float64](100000, 60*time.Second)
 	for i := 0; i < b.N; i++ {
 		mapexpire.Set(uint64(i), float64(i))
 	}
@@ -103,3 +106,166 @@ func Benchmark_HashQueries_ConcurrentGet(b *testing.B) {
 
 	wg.Wait()
 }
+
+func Test_QueriesCache_Evictions(t *testing.T) {
+	cache := NewQueriesCache[float64](queriesCacheShards, 10*time.Second)
+
+	// one query per shard at most, the oldest one is evicted
+	cache.Set(uint64(1), float64(1))
+	cache.Set(uint64(1+queriesCacheShards), float64(2))
+
+	if _, ok := cache.Get(uint64(1)); ok {
+		t.Errorf("the oldest query should be evicted")
+	}
+	if value, ok := cache.Get(uint64(1 + queriesCacheShards)); !ok || value != 2 {
+		t.Errorf("the recent query should be kept")
+	}
+
+	stats := cache.Stats()
+	if stats.Evictions != 1 || stats.Entries != 1 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+}
+
+func Test_QueriesCache_Pop(t *testing.T) {
+	cache := NewQueriesCache[float64](100, 10*time.Second)
+	cache.Set(uint64(1), float64(1))
+
+	if _, ok := cache.Pop(uint64(1)); !ok {
+		t.Errorf("the query should be matched")
+	}
+	if _, ok := cache.Pop(uint64(1)); ok {
+		t.Errorf("the query should be removed")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 0 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+}
+
+func Test_QueriesCache_ExpireOldest(t *testing.T) {
+	cache := NewQueriesCache[float64](100, time.Second)
+	cache.Set(uint64(1), float64(1))
+	cache.Set(uint64(2), float64(2))
+
+	if values := cache.Expire(time.Now()); len(values) != 0 {
+		t.Errorf("no query should be expired: %v", values)
+	}
+	if values := cache.Expire(time.Now().Add(2 * time.Second)); len(values) != 2 {
+		t.Errorf("the queries should be expired: %v", values)
+	}
+	if stats := cache.Stats(); stats.Expired != 2 || stats.Entries != 0 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+}
+
+func TestLatency_MeasureLatency(t *testing.T) {
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.MeasureLatency = true
+
+	latency := NewLatencySubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{})
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryPort = "53000"
+	dm.DnsTap.Timestamp = 10
+	latency.MeasureLatency(&dm)
+
+	// reply with another qname and the same id
+	other := dm
+	other.DNS.Type = dnsutils.DnsReply
+	other.DNS.Qname = "other.example"
+	other.DnsTap.Timestamp = 10.5
+	latency.MeasureLatency(&other)
+	if other.DnsTap.Latency != 0 {
+		t.Errorf("the reply of another qname should not be matched")
+	}
+
+	reply := dm
+	reply.DNS.Type = dnsutils.DnsReply
+	reply.DnsTap.Timestamp = 10.5
+	latency.MeasureLatency(&reply)
+	if reply.DnsTap.Latency != 0.5 {
+		t.Errorf("invalid latency: %v", reply.DnsTap.Latency)
+	}
+
+	stats := latency.Stats()["latency"]
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+}
+
+func TestLatency_UnansweredQueries(t *testing.T) {
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.UnansweredQueries = true
+	config.Latency.QueriesTimeout = 1
+
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	latency := NewLatencySubprocessor(config, logger.New(false), "unanswered", []chan dnsutils.DnsMessage{outChan})
+	go latency.Run()
+	defer latency.Stop()
+
+	// one query answered, one without reply
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryPort = "53000"
+	latency.DetectEvictedTimeout(&dm)
+
+	reply := dm
+	reply.DNS.Type = dnsutils.DnsReply
+	latency.DetectEvictedTimeout(&reply)
+
+	unanswered := dnsutils.GetFakeDnsMessage()
+	unanswered.NetworkInfo.QueryPort = "53001"
+	latency.DetectEvictedTimeout(&unanswered)
+
+	select {
+	case dm := <-outChan:
+		if dm.DNS.Rcode != "TIMEOUT" || dm.NetworkInfo.QueryPort != "53001" {
+			t.Errorf("invalid unanswered query: %s %s", dm.DNS.Rcode, dm.NetworkInfo.QueryPort)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no unanswered query")
+	}
+
+	if stats := GetLatencyStats()["unanswered"]["unanswered"]; stats.Expired != 1 || stats.Hits != 1 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+}
+
+func TestLatency_StatsMonotonic(t *testing.T) {
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.MeasureLatency = true
+
+	// two processors of the same worker, for two connections
+	outChans := []chan dnsutils.DnsMessage{}
+	first := NewLatencySubprocessor(config, logger.New(false), "monotonic", outChans)
+	second := NewLatencySubprocessor(config, logger.New(false), "monotonic", outChans)
+	go first.Run()
+	go second.Run()
+	defer second.Stop()
+
+	for _, latency := range []*LatencyProcessor{first, second} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.QueryPort = "53000"
+		latency.MeasureLatency(&dm)
+		reply := dm
+		reply.DNS.Type = dnsutils.DnsReply
+		latency.MeasureLatency(&reply)
+	}
+
+	// wait the processors to be registered
+	time.Sleep(100 * time.Millisecond)
+	if stats := GetLatencyStats()["monotonic"]["latency"]; stats.Hits != 2 {
+		t.Errorf("counters not summed: %+v", stats)
+	}
+
+	// the counters of the closed connection are kept
+	first.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if stats := GetLatencyStats()["monotonic"]["latency"]; stats.Hits != 2 {
+		t.Errorf("counters not monotonic: %+v", stats)
+	}
+}
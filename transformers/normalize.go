@@ -5,7 +5,6 @@ import (
 	"strings"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
-	publicsuffixlist "golang.org/x/net/publicsuffix"
 )
 
 var (
@@ -33,8 +32,10 @@ var (
 	}
 
 	IPversion = map[string]string{
-		"INET6": "6",
-		"INET":  "4",
+		"INET6":             "6",
+		"INET":              "4",
+		dnsutils.PROTO_IPV6: "6",
+		dnsutils.PROTO_IPV4: "4",
 	}
 
 	Rcodes = map[string]string{
@@ -88,6 +89,14 @@ func (p *NormalizeProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
 	}
 }
 
+// GetSubdomainDepth returns the number of labels before the registrable domain
+func (s *NormalizeProcessor) GetSubdomainDepth(qname string) int {
+	qname = strings.ToLower(qname)
+	qname = strings.TrimSuffix(qname, ".")
+
+	return dnsutils.GetSubdomainDepth(qname)
+}
+
 func (s *NormalizeProcessor) QuietText(dm *dnsutils.DnsMessage) {
 	if v, found := DnstapMessage[dm.DnsTap.Operation]; found {
 		dm.DnsTap.Operation = v
@@ -111,7 +120,7 @@ func (s *NormalizeProcessor) GetEffectiveTld(qname string) (string, error) {
 	qname = strings.TrimSuffix(qname, ".")
 
 	// search
-	etld, icann := publicsuffixlist.PublicSuffix(qname)
+	etld, icann := dnsutils.GetPublicSuffix(qname)
 	if icann {
 		return etld, nil
 	}
@@ -124,5 +133,5 @@ func (s *NormalizeProcessor) GetEffectiveTldPlusOne(qname string) (string, error
 	qname = strings.ToLower(qname)
 	qname = strings.TrimSuffix(qname, ".")
 
-	return publicsuffixlist.EffectiveTLDPlusOne(qname)
+	return dnsutils.GetEffectiveTLDPlusOne(qname)
 }
@@ -32,8 +32,13 @@ func TestNormalize_QuietText(t *testing.T) {
 	norm := NewNormalizeSubprocessor(config)
 
 	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
 	norm.QuietText(&dm)
 
+	if dm.NetworkInfo.Family != "6" {
+		t.Errorf("6 expected: %s", dm.NetworkInfo.Family)
+	}
+
 	if dm.DnsTap.Operation != "CQ" {
 		t.Errorf("CQ expected: %s", dm.DnsTap.Operation)
 	}
@@ -127,3 +132,49 @@ func TestNormalize_AddTldPlusOne(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalize_AddSubdomainDepth(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Normalize.Enable = true
+	config.Normalize.AddSubdomainDepth = true
+
+	// init the processor
+	psl := NewNormalizeSubprocessor(config)
+
+	tt := []struct {
+		name  string
+		qname string
+		want  int
+	}{
+		{
+			name:  "registrable domain",
+			qname: "amazon.co.uk",
+			want:  0,
+		},
+		{
+			name:  "one subdomain",
+			qname: "books.amazon.co.uk",
+			want:  1,
+		},
+		{
+			name:  "insensitive with dot trailing",
+			qname: "a.b.c.Example.COM.",
+			want:  3,
+		},
+		{
+			name:  "public suffix",
+			qname: "co.uk",
+			want:  0,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			depth := psl.GetSubdomainDepth(tc.qname)
+			if depth != tc.want {
+				t.Errorf("Bad subdomain depth, got: %d, expected: %d", depth, tc.want)
+			}
+		})
+	}
+}
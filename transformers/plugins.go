@@ -0,0 +1,124 @@
+package transformers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func init() {
+	dnsutils.RegisterSubprocessor("exec", NewExecSubprocessor)
+}
+
+type ExecConfig struct {
+	Command []string `yaml:"command"`
+}
+
+// ExecProcessor sends the messages in json, one per line, to the stdin of an external process.
+// The process replies with one line per message: the message updated in json or an empty line
+// to drop it.
+type ExecProcessor struct {
+	sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	logger *logger.Logger
+	name   string
+}
+
+func NewExecSubprocessor(params map[string]interface{}, logger *logger.Logger, name string,
+	outChannels []chan dnsutils.DnsMessage) (dnsutils.Subprocessor, error) {
+	config := ExecConfig{}
+	if err := dnsutils.DecodePluginParams(params, &config); err != nil {
+		return nil, err
+	}
+	if len(config.Command) == 0 {
+		return nil, errors.New("exec: command is required")
+	}
+
+	cmd := exec.Command(config.Command[0], config.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s := &ExecProcessor{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		logger: logger,
+		name:   name,
+	}
+	s.LogInfo("process %s started", config.Command[0])
+	return s, nil
+}
+
+func (s *ExecProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor exec - "+msg, v...)
+}
+
+func (s *ExecProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor exec - "+msg, v...)
+}
+
+func (s *ExecProcessor) Process(dm *dnsutils.DnsMessage) int {
+	data, err := json.Marshal(dm)
+	if err != nil {
+		s.LogError("json encoding error: %v", err)
+		return RETURN_ERROR
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, err := s.stdin.Write(append(data, '\n')); err != nil {
+		s.LogError("write error: %v", err)
+		return RETURN_ERROR
+	}
+	line, err := s.stdout.ReadBytes('\n')
+	if err != nil {
+		s.LogError("read error: %v", err)
+		return RETURN_ERROR
+	}
+
+	if len(line) == 1 {
+		return RETURN_DROP
+	}
+	updated := dnsutils.DnsMessage{}
+	if err := json.Unmarshal(line, &updated); err != nil {
+		s.LogError("json decoding error: %v", err)
+		return RETURN_ERROR
+	}
+	*dm = updated
+	return RETURN_SUCCESS
+}
+
+// Stop closes the stdin of the process and waits for its end
+func (s *ExecProcessor) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	s.stdin.Close()
+	if err := s.cmd.Wait(); err != nil {
+		s.LogError("process terminated: %v", err)
+	}
+}
+
+// pluginTransform returns the transform of a subprocessor provided by a plugin
+func pluginTransform(s dnsutils.Subprocessor) func(dm *dnsutils.DnsMessage) int {
+	return func(dm *dnsutils.DnsMessage) int {
+		return s.Process(dm)
+	}
+}
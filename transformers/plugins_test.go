@@ -0,0 +1,78 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+type upperSubprocessor struct {
+	stopped bool
+}
+
+func (s *upperSubprocessor) Process(dm *dnsutils.DnsMessage) int {
+	dm.DNS.Qname = "PLUGIN." + dm.DNS.Qname
+	return RETURN_SUCCESS
+}
+
+func (s *upperSubprocessor) Stop() { s.stopped = true }
+
+func TestPlugins_Subprocessor(t *testing.T) {
+	sub := &upperSubprocessor{}
+	dnsutils.RegisterSubprocessor("test-prefix", func(params map[string]interface{}, logger *logger.Logger, name string,
+		outChannels []chan dnsutils.DnsMessage) (dnsutils.Subprocessor, error) {
+		return sub, nil
+	})
+
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Plugins = []dnsutils.ConfigPlugin{{Enable: true, Name: "test-prefix"}}
+
+	subprocessors := NewTransforms(config, logger.New(false), "test", []chan dnsutils.DnsMessage{})
+
+	dm := dnsutils.GetFakeDnsMessage()
+	if subprocessors.ProcessMessage(&dm) != RETURN_SUCCESS {
+		t.Errorf("return code is not success")
+	}
+	if dm.DNS.Qname != "PLUGIN.dns.collector" {
+		t.Errorf("invalid qname: %s", dm.DNS.Qname)
+	}
+
+	subprocessors.Reset()
+	if !sub.stopped {
+		t.Errorf("subprocessor not stopped")
+	}
+}
+
+func TestPlugins_Exec(t *testing.T) {
+	// the messages are returned as is
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Plugins = []dnsutils.ConfigPlugin{{Enable: true, Name: "exec",
+		Params: map[string]interface{}{"command": []string{"cat"}}}}
+
+	subprocessors := NewTransforms(config, logger.New(false), "test", []chan dnsutils.DnsMessage{})
+	defer subprocessors.Reset()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	if subprocessors.ProcessMessage(&dm) != RETURN_SUCCESS {
+		t.Errorf("return code is not success")
+	}
+	if dm.DNS.Qname != "dns.collector" {
+		t.Errorf("invalid qname: %s", dm.DNS.Qname)
+	}
+}
+
+func TestPlugins_ExecDrop(t *testing.T) {
+	// the process replies with an empty line
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Plugins = []dnsutils.ConfigPlugin{{Enable: true, Name: "exec",
+		Params: map[string]interface{}{"command": []string{"sh", "-c", "while read line; do echo; done"}}}}
+
+	subprocessors := NewTransforms(config, logger.New(false), "test", []chan dnsutils.DnsMessage{})
+	defer subprocessors.Reset()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	if subprocessors.ProcessMessage(&dm) != RETURN_DROP {
+		t.Errorf("return code is not drop")
+	}
+}
@@ -0,0 +1,181 @@
+package transformers
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// the tenants without message during this delay are removed, their buckets are full again
+const quotaTenantIdle = time.Minute
+
+// token buckets of a tenant, refilled every second
+type TenantQuota struct {
+	messages float64
+	bytes    float64
+	last     time.Time
+	dropped  int
+}
+
+// quota processor, limits the processing rate of each tenant
+type QuotaProcessor struct {
+	sync.Mutex
+	config  *dnsutils.ConfigTransformers
+	logger  *logger.Logger
+	name    string
+	tenants map[string]*TenantQuota
+	dropped *uint64
+	stop    chan bool
+}
+
+func NewQuotaSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *QuotaProcessor {
+	s := QuotaProcessor{
+		config:  config,
+		logger:  logger,
+		name:    name,
+		tenants: make(map[string]*TenantQuota),
+		dropped: droppedCounter(name, "quota"),
+		stop:    make(chan bool),
+	}
+	return &s
+}
+
+func (s *QuotaProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor quota - "+msg, v...)
+}
+
+// GetTenant returns the tenant of the dns message according to the tenant-by setting
+func (s *QuotaProcessor) GetTenant(dm *dnsutils.DnsMessage) string {
+	switch s.config.Quota.TenantBy {
+	case dnsutils.TENANT_BY_TAG:
+		if dm.PowerDns != nil && len(dm.PowerDns.Tags) > 0 {
+			return dm.PowerDns.Tags[0]
+		}
+		return "-"
+	case dnsutils.TENANT_BY_CLIENT:
+		return dm.NetworkInfo.QueryIp
+	default:
+		return dm.DnsTap.Identity
+	}
+}
+
+// refill adds the tokens earned since the last message, the buckets hold one second at most
+func refill(tokens float64, max int, elapsed float64) float64 {
+	tokens += elapsed * float64(max)
+	if tokens > float64(max) {
+		tokens = float64(max)
+	}
+	return tokens
+}
+
+// OverQuota returns true if the tenant of the message has exceeded its quota,
+// each tenant has its own buckets so a noisy one can't starve the others
+func (s *QuotaProcessor) OverQuota(dm *dnsutils.DnsMessage) bool {
+	maxMessages := s.config.Quota.MaxMessagesPerSec
+	maxBytes := s.config.Quota.MaxBytesPerSec
+	now := time.Now()
+	tenant := s.GetTenant(dm)
+
+	s.Lock()
+	defer s.Unlock()
+
+	q, exists := s.tenants[tenant]
+	if !exists {
+		q = &TenantQuota{messages: float64(maxMessages), bytes: float64(maxBytes), last: now}
+		s.tenants[tenant] = q
+	}
+
+	elapsed := now.Sub(q.last).Seconds()
+	q.last = now
+	q.messages = refill(q.messages, maxMessages, elapsed)
+	q.bytes = refill(q.bytes, maxBytes, elapsed)
+
+	if (maxMessages > 0 && q.messages < 1) || (maxBytes > 0 && q.bytes < float64(dm.DNS.Length)) {
+		q.dropped++
+		atomic.AddUint64(s.dropped, 1)
+		return true
+	}
+
+	q.messages--
+	q.bytes -= float64(dm.DNS.Length)
+	return false
+}
+
+// GetDropped returns the number of dropped messages per tenant and resets the counters
+func (s *QuotaProcessor) GetDropped() map[string]int {
+	s.Lock()
+	defer s.Unlock()
+
+	dropped := make(map[string]int)
+	for tenant, q := range s.tenants {
+		if q.dropped > 0 {
+			dropped[tenant] = q.dropped
+			q.dropped = 0
+		}
+	}
+	return dropped
+}
+
+// ExpireTenants removes the tenants idle since the delay provided, the tenants with
+// drops not yet reported are kept until the next report
+func (s *QuotaProcessor) ExpireTenants(idle time.Duration) {
+	now := time.Now()
+	reported := s.config.Quota.ReportInterval > 0
+
+	s.Lock()
+	defer s.Unlock()
+
+	for tenant, q := range s.tenants {
+		if reported && q.dropped > 0 {
+			continue
+		}
+		if now.Sub(q.last) > idle {
+			delete(s.tenants, tenant)
+		}
+	}
+}
+
+// Report logs the dropped messages per tenant since the last report
+func (s *QuotaProcessor) Report() {
+	dropped := s.GetDropped()
+	tenants := make([]string, 0, len(dropped))
+	for tenant := range dropped {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	for _, tenant := range tenants {
+		s.LogInfo("tenant %s over quota, %d messages dropped", tenant, dropped[tenant])
+	}
+}
+
+func (s *QuotaProcessor) Run() {
+	expire := time.NewTicker(quotaTenantIdle)
+	defer expire.Stop()
+
+	// drop counters are not logged if the interval is zero, they are still exported
+	var reports <-chan time.Time
+	if s.config.Quota.ReportInterval > 0 {
+		ticker := time.NewTicker(time.Duration(s.config.Quota.ReportInterval) * time.Second)
+		defer ticker.Stop()
+		reports = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-reports:
+			s.Report()
+		case <-expire.C:
+			s.ExpireTenants(quotaTenantIdle)
+		}
+	}
+}
+
+func (s *QuotaProcessor) Stop() {
+	s.stop <- true
+}
@@ -0,0 +1,112 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestQuota_MaxMessagesPerSec(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Quota.Enable = true
+	config.Quota.MaxMessagesPerSec = 10
+
+	// init subproccesor
+	quota := NewQuotaSubprocessor(config, logger.New(false), "test")
+
+	// a noisy tenant exceeds its quota
+	dropped := 0
+	for i := 0; i < 20; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DnsTap.Identity = "noisy"
+		if quota.OverQuota(&dm) {
+			dropped++
+		}
+	}
+	if dropped != 10 {
+		t.Errorf("10 messages should be dropped, got %d", dropped)
+	}
+
+	// other tenants are not impacted
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DnsTap.Identity = "quiet"
+	if quota.OverQuota(&dm) {
+		t.Errorf("quiet tenant should not be over quota")
+	}
+
+	// drops are counted per tenant
+	counters := quota.GetDropped()
+	if len(counters) != 1 || counters["noisy"] != 10 {
+		t.Errorf("invalid drop counters: %v", counters)
+	}
+	if len(quota.GetDropped()) != 0 {
+		t.Errorf("drop counters should be reset")
+	}
+}
+
+func TestQuota_MaxBytesPerSec(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Quota.Enable = true
+	config.Quota.TenantBy = dnsutils.TENANT_BY_CLIENT
+	config.Quota.MaxBytesPerSec = 100
+
+	// init subproccesor
+	quota := NewQuotaSubprocessor(config, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Length = 60
+	if quota.OverQuota(&dm) {
+		t.Errorf("first message should be accepted")
+	}
+	if !quota.OverQuota(&dm) {
+		t.Errorf("second message should exceed the bytes quota")
+	}
+}
+
+func TestQuota_DroppedStats(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Quota.Enable = true
+	config.Quota.MaxMessagesPerSec = 1
+
+	// the counter is shared by the instances of the worker
+	for i := 0; i < 2; i++ {
+		quota := NewQuotaSubprocessor(config, logger.New(false), "quota-stats")
+		dm := dnsutils.GetFakeDnsMessage()
+		quota.OverQuota(&dm)
+		quota.OverQuota(&dm)
+	}
+
+	if dropped := GetDroppedStats()["quota-stats"]["quota"]; dropped != 2 {
+		t.Errorf("2 messages dropped expected, got %d", dropped)
+	}
+}
+
+func TestQuota_ExpireTenants(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Quota.Enable = true
+	config.Quota.TenantBy = dnsutils.TENANT_BY_CLIENT
+	config.Quota.MaxMessagesPerSec = 10
+
+	// init subproccesor
+	quota := NewQuotaSubprocessor(config, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	quota.OverQuota(&dm)
+
+	quota.ExpireTenants(time.Minute)
+	if len(quota.tenants) != 1 {
+		t.Errorf("active tenant should be kept")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	quota.ExpireTenants(10 * time.Millisecond)
+	if len(quota.tenants) != 0 {
+		t.Errorf("idle tenant should be removed")
+	}
+}
@@ -0,0 +1,138 @@
+package transformers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+type RepeatedMessage struct {
+	dm         dnsutils.DnsMessage
+	occurences int
+}
+
+// reducer processor, collapses runs of identical messages into periodic summaries
+type ReducerProcessor struct {
+	sync.Mutex
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	outChannels []chan dnsutils.DnsMessage
+	interval    time.Duration
+	repeated    map[string]*RepeatedMessage
+	stop        chan bool
+}
+
+func NewReducerSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *ReducerProcessor {
+	s := ReducerProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		outChannels: outChannels,
+		interval:    time.Duration(config.Reducer.WatchInterval) * time.Second,
+		repeated:    make(map[string]*RepeatedMessage),
+		stop:        make(chan bool),
+	}
+	return &s
+}
+
+func (s *ReducerProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
+	dm.Reducer = &dnsutils.TransformReducer{
+		Occurences: 0,
+		Interval:   0,
+	}
+}
+
+// GetKey returns the identity of a message: client, qname, qtype and rcode
+func (s *ReducerProcessor) GetKey(dm *dnsutils.DnsMessage) string {
+	return strings.Join([]string{dm.DnsTap.Identity, dm.DNS.Type, dm.NetworkInfo.QueryIp,
+		dm.DNS.Qname, dm.DNS.Qtype, dm.DNS.Rcode}, "+")
+}
+
+// RepetitiveTrafficDetector returns true if the message is a repetition
+// of a message already seen in the current interval and must be dropped
+func (s *ReducerProcessor) RepetitiveTrafficDetector(dm *dnsutils.DnsMessage) bool {
+	if dm.Reducer == nil {
+		s.InitDnsMessage(dm)
+	}
+
+	// summary or message already reduced, nothing to do
+	if dm.Reducer.Occurences > 0 {
+		return false
+	}
+
+	key := s.GetKey(dm)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if rep, exists := s.repeated[key]; exists {
+		rep.occurences++
+		rep.dm = *dm
+		return true
+	}
+
+	// coalesce mode, the first occurence is also held until the end of the interval
+	if s.config.Reducer.Coalesce {
+		s.repeated[key] = &RepeatedMessage{dm: *dm, occurences: 1}
+		return true
+	}
+
+	// first occurence, the message is forwarded as is
+	dm.Reducer.Occurences = 1
+	s.repeated[key] = &RepeatedMessage{}
+	return false
+}
+
+// Flush sends a summary for each message repeated during the interval,
+// messages without repetition are forgotten. In coalesce mode, one message
+// is sent for each key seen during the interval and the run always ends
+func (s *ReducerProcessor) Flush() {
+	s.Lock()
+	summaries := []dnsutils.DnsMessage{}
+	for key, rep := range s.repeated {
+		if rep.occurences == 0 {
+			delete(s.repeated, key)
+			continue
+		}
+
+		dm := rep.dm
+		dm.Reducer = &dnsutils.TransformReducer{
+			Occurences: rep.occurences,
+			Interval:   int(s.interval.Seconds()),
+		}
+		summaries = append(summaries, dm)
+		rep.occurences = 0
+
+		if s.config.Reducer.Coalesce {
+			delete(s.repeated, key)
+		}
+	}
+	s.Unlock()
+
+	for _, dm := range summaries {
+		for i := range s.outChannels {
+			s.outChannels[i] <- dm
+		}
+	}
+}
+
+func (s *ReducerProcessor) Run() {
+	ticker := time.NewTicker(s.interval)
+	for {
+		select {
+		case <-s.stop:
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+func (s *ReducerProcessor) Stop() {
+	s.stop <- true
+}
@@ -0,0 +1,111 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestReducer_RepetitiveTrafficDetector(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 10)}
+
+	// init subproccesor
+	reducer := NewReducerSubprocessor(config, log, "test", outChans)
+
+	// the first message is forwarded, the next ones are dropped
+	for i := 0; i < 5; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dropped := reducer.RepetitiveTrafficDetector(&dm)
+		if i == 0 && (dropped || dm.Reducer.Occurences != 1) {
+			t.Fatalf("first message should be forwarded")
+		}
+		if i > 0 && !dropped {
+			t.Fatalf("repeated message should be dropped")
+		}
+	}
+
+	// another qtype is not a repetition
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qtype = "AAAA"
+	if reducer.RepetitiveTrafficDetector(&dm) {
+		t.Errorf("message with another qtype should be forwarded")
+	}
+
+	// a summary is sent at the end of the interval
+	reducer.Flush()
+	if len(outChans[0]) != 1 {
+		t.Fatalf("one summary expected, got %d", len(outChans[0]))
+	}
+	summary := <-outChans[0]
+	if summary.Reducer.Occurences != 4 || summary.Reducer.Interval != config.Reducer.WatchInterval {
+		t.Errorf("invalid summary: %+v", summary.Reducer)
+	}
+
+	// the summary is not reduced again
+	if reducer.RepetitiveTrafficDetector(&summary) {
+		t.Errorf("summary should not be dropped")
+	}
+
+	// without repetition the run is ended, the next message is forwarded
+	reducer.Flush()
+	reducer.Flush()
+	dm = dnsutils.GetFakeDnsMessage()
+	if reducer.RepetitiveTrafficDetector(&dm) || len(outChans[0]) != 0 {
+		t.Errorf("message should be forwarded after the end of the run")
+	}
+}
+
+func TestReducer_Coalesce(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.Coalesce = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 10)}
+
+	// init subproccesor
+	reducer := NewReducerSubprocessor(config, log, "test", outChans)
+
+	// all messages are held, including the first one
+	for i := 0; i < 5; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		if !reducer.RepetitiveTrafficDetector(&dm) {
+			t.Fatalf("message should be held")
+		}
+	}
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qtype = "AAAA"
+	if !reducer.RepetitiveTrafficDetector(&dm) {
+		t.Fatalf("message should be held")
+	}
+
+	// one message per key is sent at the end of the interval
+	reducer.Flush()
+	if len(outChans[0]) != 2 {
+		t.Fatalf("two messages expected, got %d", len(outChans[0]))
+	}
+	for i := 0; i < 2; i++ {
+		summary := <-outChans[0]
+		if summary.DNS.Qtype == "A" && summary.Reducer.Occurences != 5 {
+			t.Errorf("invalid summary for A: %+v", summary.Reducer)
+		}
+		if summary.DNS.Qtype == "AAAA" && summary.Reducer.Occurences != 1 {
+			t.Errorf("invalid summary for AAAA: %+v", summary.Reducer)
+		}
+	}
+
+	// the next interval starts from scratch
+	reducer.Flush()
+	if len(outChans[0]) != 0 {
+		t.Errorf("no message expected, got %d", len(outChans[0]))
+	}
+}
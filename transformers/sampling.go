@@ -0,0 +1,114 @@
+package transformers
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// sampling processor, keeps a random sample of the traffic and caps the
+// number of messages per client
+type SamplingProcessor struct {
+	sync.Mutex
+	config        *dnsutils.ConfigTransformers
+	logger        *logger.Logger
+	name          string
+	random        *rand.Rand
+	clients       map[string]int
+	windowStart   time.Time
+	sampledOut    int
+	overRateLimit int
+	sampledTotal  *uint64
+	limitedTotal  *uint64
+	stop          chan bool
+}
+
+func NewSamplingSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *SamplingProcessor {
+	s := SamplingProcessor{
+		config:  config,
+		logger:  logger,
+		name:    name,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		clients: make(map[string]int),
+		stop:    make(chan bool),
+	}
+	s.sampledTotal = droppedCounter(name, "sampling")
+	s.limitedTotal = droppedCounter(name, "sampling-client-limit")
+	return &s
+}
+
+func (s *SamplingProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor sampling - "+msg, v...)
+}
+
+// Drop returns true if the message is not part of the sample. The per client
+// counters are kept for the current second only, so the memory stays bounded
+// whatever the number of clients
+func (s *SamplingProcessor) Drop(dm *dnsutils.DnsMessage) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	// keep 1 out of rate messages, randomly to avoid any bias with periodic traffic
+	if s.config.Sampling.Rate > 1 && s.random.Intn(s.config.Sampling.Rate) != 0 {
+		s.sampledOut++
+		atomic.AddUint64(s.sampledTotal, 1)
+		return true
+	}
+
+	if s.config.Sampling.MaxPerClient > 0 {
+		now := time.Now()
+		if now.Sub(s.windowStart) >= time.Second {
+			s.clients = make(map[string]int)
+			s.windowStart = now
+		}
+
+		if s.clients[dm.NetworkInfo.QueryIp] >= s.config.Sampling.MaxPerClient {
+			s.overRateLimit++
+			atomic.AddUint64(s.limitedTotal, 1)
+			return true
+		}
+		s.clients[dm.NetworkInfo.QueryIp]++
+	}
+	return false
+}
+
+// GetDropped returns the number of messages dropped by the sampling and by the
+// per client rate limit, and resets the counters
+func (s *SamplingProcessor) GetDropped() (int, int) {
+	s.Lock()
+	defer s.Unlock()
+
+	sampledOut, overRateLimit := s.sampledOut, s.overRateLimit
+	s.sampledOut, s.overRateLimit = 0, 0
+	return sampledOut, overRateLimit
+}
+
+func (s *SamplingProcessor) Run() {
+	// drop counters are not reported
+	if s.config.Sampling.ReportInterval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.Sampling.ReportInterval) * time.Second)
+	for {
+		select {
+		case <-s.stop:
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			sampledOut, overRateLimit := s.GetDropped()
+			if sampledOut > 0 || overRateLimit > 0 {
+				s.LogInfo("%d messages sampled out, %d messages over the client rate limit", sampledOut, overRateLimit)
+			}
+		}
+	}
+}
+
+func (s *SamplingProcessor) Stop() {
+	s.stop <- true
+}
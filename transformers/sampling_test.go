@@ -0,0 +1,93 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestSampling_Rate(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Sampling.Enable = true
+	config.Sampling.Rate = 10
+
+	// init subproccesor
+	sampling := NewSamplingSubprocessor(config, logger.New(false), "test")
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		if !sampling.Drop(&dm) {
+			kept++
+		}
+	}
+
+	// around 1 out of 10 messages are kept
+	if kept < 800 || kept > 1200 {
+		t.Errorf("around 1000 messages should be kept, got %d", kept)
+	}
+
+	sampledOut, overRateLimit := sampling.GetDropped()
+	if sampledOut != 10000-kept || overRateLimit != 0 {
+		t.Errorf("invalid drop counters: %d %d", sampledOut, overRateLimit)
+	}
+}
+
+func TestSampling_MaxPerClient(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Sampling.Enable = true
+	config.Sampling.MaxPerClient = 5
+
+	// init subproccesor
+	sampling := NewSamplingSubprocessor(config, logger.New(false), "test")
+
+	// a noisy client is capped
+	dropped := 0
+	for i := 0; i < 20; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.QueryIp = "10.0.0.1"
+		if sampling.Drop(&dm) {
+			dropped++
+		}
+	}
+	if dropped != 15 {
+		t.Errorf("15 messages should be dropped, got %d", dropped)
+	}
+
+	// other clients are not impacted
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "10.0.0.2"
+	if sampling.Drop(&dm) {
+		t.Errorf("quiet client should not be dropped")
+	}
+
+	sampledOut, overRateLimit := sampling.GetDropped()
+	if sampledOut != 0 || overRateLimit != 15 {
+		t.Errorf("invalid drop counters: %d %d", sampledOut, overRateLimit)
+	}
+}
+
+func TestSampling_DroppedStats(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Sampling.Enable = true
+	config.Sampling.MaxPerClient = 1
+
+	// init subproccesor
+	sampling := NewSamplingSubprocessor(config, logger.New(false), "sampling-stats")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	for i := 0; i < 3; i++ {
+		sampling.Drop(&dm)
+	}
+
+	// the exported counters are not reset by the reports
+	sampling.GetDropped()
+	stats := GetDroppedStats()["sampling-stats"]
+	if stats["sampling-client-limit"] != 2 || stats["sampling"] != 0 {
+		t.Errorf("invalid dropped stats: %v", stats)
+	}
+}
@@ -0,0 +1,235 @@
+package transformers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// script processor, calls the process function of a lua script for each message. The message
+// is provided as a table with the fields of the json format, the changes of the table are applied
+// to the message and the message is dropped if the function returns false. The script can send
+// derived messages with the emit function.
+type ScriptProcessor struct {
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	outChannels []chan dnsutils.DnsMessage
+	state       *lua.LState
+	process     lua.LValue
+	arrayMeta   *lua.LTable
+	current     *dnsutils.DnsMessage
+}
+
+func NewScriptSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *ScriptProcessor {
+	s := ScriptProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		outChannels: outChannels,
+	}
+	return &s
+}
+
+func (s *ScriptProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor script - "+msg, v...)
+}
+
+func (s *ScriptProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor script - "+msg, v...)
+}
+
+// Load runs the script in a new lua state, with the base, table, string and math libraries only
+func (s *ScriptProcessor) Load() error {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		if err := L.CallByParam(lua.P{Fn: L.NewFunction(lib.fn), NRet: 0}, lua.LString(lib.name)); err != nil {
+			L.Close()
+			return err
+		}
+	}
+	L.SetGlobal("emit", L.NewFunction(s.luaEmit))
+	L.SetGlobal("log", L.NewFunction(s.luaLog))
+
+	var err error
+	if len(s.config.Script.ScriptFile) > 0 {
+		err = L.DoFile(s.config.Script.ScriptFile)
+	} else {
+		err = L.DoString(s.config.Script.Code)
+	}
+	if err != nil {
+		L.Close()
+		return err
+	}
+
+	process := L.GetGlobal("process")
+	if process.Type() != lua.LTFunction {
+		L.Close()
+		return errors.New("process function is missing")
+	}
+
+	s.state = L
+	s.process = process
+	s.arrayMeta = L.NewTable()
+	return nil
+}
+
+// toLua converts a value decoded from json to a lua value, the arrays are tagged to be
+// converted back to arrays even if empty
+func (s *ScriptProcessor) toLua(v interface{}) lua.LValue {
+	switch value := v.(type) {
+	case string:
+		return lua.LString(value)
+	case float64:
+		return lua.LNumber(value)
+	case bool:
+		return lua.LBool(value)
+	case []interface{}:
+		t := s.state.CreateTable(len(value), 0)
+		for _, item := range value {
+			t.Append(s.toLua(item))
+		}
+		s.state.SetMetatable(t, s.arrayMeta)
+		return t
+	case map[string]interface{}:
+		t := s.state.CreateTable(0, len(value))
+		for k, item := range value {
+			t.RawSetString(k, s.toLua(item))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// fromLua converts a lua value to a value encodable in json, the tables with integer keys
+// only are converted to arrays
+func (s *ScriptProcessor) fromLua(v lua.LValue) interface{} {
+	switch value := v.(type) {
+	case lua.LString:
+		return string(value)
+	case lua.LNumber:
+		return float64(value)
+	case lua.LBool:
+		return bool(value)
+	case *lua.LTable:
+		if s.state.GetMetatable(value) == s.arrayMeta || (value.MaxN() > 0 && value.MaxN() == countKeys(value)) {
+			array := make([]interface{}, 0, value.MaxN())
+			for i := 1; i <= value.MaxN(); i++ {
+				array = append(array, s.fromLua(value.RawGetInt(i)))
+			}
+			return array
+		}
+		object := make(map[string]interface{})
+		value.ForEach(func(k, item lua.LValue) {
+			object[k.String()] = s.fromLua(item)
+		})
+		return object
+	}
+	return nil
+}
+
+func countKeys(t *lua.LTable) int {
+	n := 0
+	t.ForEach(func(lua.LValue, lua.LValue) { n++ })
+	return n
+}
+
+// decode builds a message from a table, the fields not available in json are copied from
+// the message provided
+func (s *ScriptProcessor) decode(t *lua.LTable, from *dnsutils.DnsMessage) (dnsutils.DnsMessage, error) {
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+
+	data, err := json.Marshal(s.fromLua(t))
+	if err != nil {
+		return dm, err
+	}
+	if err := json.Unmarshal(data, &dm); err != nil {
+		return dm, err
+	}
+
+	dm.DNS.Type = from.DNS.Type
+	dm.DNS.Id = from.DNS.Id
+	dm.DNS.Payload = from.DNS.Payload
+	dm.DnsTap.Timestamp = from.DnsTap.Timestamp
+	dm.DnsTap.TimeSec = from.DnsTap.TimeSec
+	dm.DnsTap.TimeNsec = from.DnsTap.TimeNsec
+	dm.DnsTap.Latency = from.DnsTap.Latency
+	dm.DnsTap.Payload = from.DnsTap.Payload
+	dm.Fields = from.Fields
+	return dm, nil
+}
+
+// emit(table) sends a message built from the table, with the SCRIPT operation
+func (s *ScriptProcessor) luaEmit(L *lua.LState) int {
+	event, err := s.decode(L.CheckTable(1), s.current)
+	if err != nil {
+		L.RaiseError("emit: %v", err)
+		return 0
+	}
+	event.DnsTap.Operation = dnsutils.OPERATION_SCRIPT
+	for i := range s.outChannels {
+		s.outChannels[i] <- event
+	}
+	return 0
+}
+
+// log(message) writes the message in the logs of the collector
+func (s *ScriptProcessor) luaLog(L *lua.LState) int {
+	s.LogInfo("%s", L.CheckString(1))
+	return 0
+}
+
+// Process calls the process function with the message, returns true if the message must be dropped.
+// The messages emitted by the script are not processed again.
+func (s *ScriptProcessor) Process(dm *dnsutils.DnsMessage) (bool, error) {
+	if s.state == nil || dm.DnsTap.Operation == dnsutils.OPERATION_SCRIPT {
+		return false, nil
+	}
+
+	var msg map[string]interface{}
+	data, err := json.Marshal(dm)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return false, err
+	}
+
+	s.current = dm
+	t := s.toLua(msg).(*lua.LTable)
+	if err := s.state.CallByParam(lua.P{Fn: s.process, NRet: 1, Protect: true}, t); err != nil {
+		return false, err
+	}
+	ret := s.state.Get(-1)
+	s.state.Pop(1)
+	if ret == lua.LFalse {
+		return true, nil
+	}
+
+	updated, err := s.decode(t, dm)
+	if err != nil {
+		return false, fmt.Errorf("invalid message: %w", err)
+	}
+	*dm = updated
+	return false, nil
+}
+
+func (s *ScriptProcessor) Close() {
+	if s.state != nil {
+		s.state.Close()
+		s.state = nil
+	}
+}
@@ -0,0 +1,130 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestScript_Modify(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Script.Enable = true
+	config.Script.Code = `
+function process(dm)
+  dm.dns.qname = string.upper(dm.dns.qname)
+  dm.extra = { site = "paris" }
+end`
+
+	// init subproccesor
+	script := NewScriptSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{})
+	if err := script.Load(); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	defer script.Close()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Type = dnsutils.DnsReply
+	dm.DNS.DnsRRs.Answers = []dnsutils.DnsAnswer{{Name: "dns.collector", Rdatatype: "A", Rdata: "1.2.3.4"}}
+	drop, err := script.Process(&dm)
+	if err != nil || drop {
+		t.Fatalf("unexpected result: %v %v", drop, err)
+	}
+	if dm.DNS.Qname != "DNS.COLLECTOR" {
+		t.Errorf("invalid qname: %s", dm.DNS.Qname)
+	}
+	if dm.Extra["site"] != "paris" {
+		t.Errorf("invalid extra fields: %v", dm.Extra)
+	}
+
+	// the fields not available in the table are kept
+	if dm.DNS.Type != dnsutils.DnsReply || len(dm.DNS.DnsRRs.Answers) != 1 || len(dm.DNS.DnsRRs.Nameservers) != 0 {
+		t.Errorf("invalid message: %v", dm.DNS)
+	}
+}
+
+func TestScript_Drop(t *testing.T) {
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Script.Enable = true
+	config.Script.Code = `
+function process(dm)
+  return dm.network["query-ip"] ~= "1.2.3.4"
+end`
+
+	script := NewScriptSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{})
+	if err := script.Load(); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	defer script.Close()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	if drop, _ := script.Process(&dm); !drop {
+		t.Errorf("message should be dropped")
+	}
+
+	dm.NetworkInfo.QueryIp = "10.0.0.1"
+	if drop, _ := script.Process(&dm); drop {
+		t.Errorf("message should be kept")
+	}
+}
+
+func TestScript_Emit(t *testing.T) {
+	outChan := make(chan dnsutils.DnsMessage, 10)
+
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Script.Enable = true
+	config.Script.Code = `
+function process(dm)
+  if dm.dns.rcode == "NXDOMAIN" then
+    emit({ dns = { qname = dm.dns.qname, rcode = "NXDOMAIN" }, extra = { reason = "typo" } })
+  end
+end`
+
+	script := NewScriptSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+	if err := script.Load(); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	defer script.Close()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Rcode = dnsutils.DNS_RCODE_NXDOMAIN
+	script.Process(&dm)
+
+	if len(outChan) != 1 {
+		t.Fatalf("one message expected")
+	}
+	event := <-outChan
+	if event.DnsTap.Operation != dnsutils.OPERATION_SCRIPT || event.DNS.Qname != "dns.collector" || event.Extra["reason"] != "typo" {
+		t.Errorf("invalid event: %v", event)
+	}
+
+	// the events are not processed again
+	script.Process(&event)
+	if len(outChan) != 0 {
+		t.Errorf("no message expected")
+	}
+}
+
+func TestScript_Errors(t *testing.T) {
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Script.Enable = true
+
+	// process function missing
+	config.Script.Code = `x = 1`
+	script := NewScriptSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{})
+	if err := script.Load(); err == nil {
+		t.Errorf("load error expected")
+	}
+
+	// runtime error
+	config.Script.Code = `function process(dm) return dm.unknown.field end`
+	if err := script.Load(); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	defer script.Close()
+	dm := dnsutils.GetFakeDnsMessage()
+	if _, err := script.Process(&dm); err == nil {
+		t.Errorf("runtime error expected")
+	}
+}
@@ -0,0 +1,241 @@
+package transformers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// number of slots of the sliding window
+const statisticsSlots = 10
+
+// statisticsStore holds the tops of a worker, shared by the instances of the transformer
+// (one per connection and per decoding worker) to be counted and reported once
+type statisticsStore struct {
+	sync.Mutex
+	qnames    *dnsutils.SlidingTopK
+	clients   *dnsutils.SlidingTopK
+	tlds      *dnsutils.SlidingTopK
+	domains   *dnsutils.SlidingTopK
+	rcodes    *dnsutils.SlidingTopK
+	instances []*StatisticsProcessor
+	started   time.Time
+	stop      chan bool
+}
+
+// statisticsKey identifies the tops of a worker, the instances of a worker share the config of
+// its transformers. A new config on reload starts new tops, with its own capacity and window
+type statisticsKey struct {
+	name   string
+	config *dnsutils.ConfigTransformers
+}
+
+// statistics running, by name of collector or logger and config
+var (
+	statisticsMutex    sync.Mutex
+	statisticsRegistry = make(map[statisticsKey]*statisticsStore)
+)
+
+// GetStatistics returns the statistics of the current window of each worker running,
+// the most recent tops are returned during a reload
+func GetStatistics() map[string]dnsutils.TransformStatistics {
+	statisticsMutex.Lock()
+	instances := make(map[string]*StatisticsProcessor)
+	started := make(map[string]time.Time)
+	for key, store := range statisticsRegistry {
+		store.Lock()
+		if len(store.instances) > 0 && !store.started.Before(started[key.name]) {
+			instances[key.name] = store.instances[0]
+			started[key.name] = store.started
+		}
+		store.Unlock()
+	}
+	statisticsMutex.Unlock()
+
+	stats := make(map[string]dnsutils.TransformStatistics)
+	for name, instance := range instances {
+		stats[name] = instance.Snapshot()
+	}
+	return stats
+}
+
+// statistics processor, keeps the top qnames, clients, tlds, registrable domains and rcodes of a sliding window
+// with a bounded memory and sends a report record periodically
+type StatisticsProcessor struct {
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	outChannels []chan dnsutils.DnsMessage
+	store       *statisticsStore
+}
+
+func NewStatisticsSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *StatisticsProcessor {
+	window := time.Duration(config.Statistics.Window) * time.Second
+	s := StatisticsProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		outChannels: outChannels,
+		store: &statisticsStore{
+			qnames:  dnsutils.NewSlidingTopK(config.Statistics.Capacity, window, statisticsSlots),
+			clients: dnsutils.NewSlidingTopK(config.Statistics.Capacity, window, statisticsSlots),
+			tlds:    dnsutils.NewSlidingTopK(config.Statistics.Capacity, window, statisticsSlots),
+			domains: dnsutils.NewSlidingTopK(config.Statistics.Capacity, window, statisticsSlots),
+			rcodes:  dnsutils.NewSlidingTopK(config.Statistics.Capacity, window, statisticsSlots),
+			stop:    make(chan bool),
+		},
+	}
+	return &s
+}
+
+// Record counts the message in the current window
+func (s *StatisticsProcessor) Record(dm *dnsutils.DnsMessage) {
+	// report already sent
+	if dm.Statistics != nil {
+		return
+	}
+
+	qname := strings.ToLower(strings.TrimSuffix(dm.DNS.Qname, "."))
+	tld, _ := dnsutils.GetPublicSuffix(qname)
+	domain, err := dnsutils.GetEffectiveTLDPlusOne(qname)
+	if err != nil {
+		domain = qname
+	}
+	now := time.Now()
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	s.store.qnames.Add(qname, 1, now)
+	s.store.clients.Add(dm.NetworkInfo.QueryIp, 1, now)
+	s.store.tlds.Add(tld, 1, now)
+	s.store.domains.Add(domain, 1, now)
+	s.store.rcodes.Add(dm.DNS.Rcode, 1, now)
+}
+
+// Snapshot returns the tops of the current window
+func (s *StatisticsProcessor) Snapshot() dnsutils.TransformStatistics {
+	now := time.Now()
+	topK := s.config.Statistics.TopK
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	// the rcodes are few, all of them are kept so the total is exact
+	rcodes := s.store.rcodes.Top(-1, now)
+	total := 0
+	for _, item := range rcodes {
+		total += item.Hit
+	}
+	if len(rcodes) > topK {
+		rcodes = rcodes[:topK]
+	}
+
+	return dnsutils.TransformStatistics{
+		Window:     s.config.Statistics.Window,
+		Total:      total,
+		TopQnames:  s.store.qnames.Top(topK, now),
+		TopClients: s.store.clients.Top(topK, now),
+		TopTlds:    s.store.tlds.Top(topK, now),
+		TopDomains: s.store.domains.Top(topK, now),
+		TopRcodes:  rcodes,
+	}
+}
+
+// Report sends a record with the statistics of the current window
+func (s *StatisticsProcessor) Report() {
+	stats := s.Snapshot()
+
+	now := time.Now()
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+	dm.DnsTap.Identity = s.name
+	dm.DnsTap.Operation = dnsutils.OPERATION_STATISTICS
+	dm.DnsTap.TimeSec = int(now.Unix())
+	dm.DnsTap.TimeNsec = now.Nanosecond()
+	dm.DnsTap.Timestamp = float64(now.UnixNano()) / 1e9
+	dm.DnsTap.TimestampRFC3339 = now.UTC().Format(time.RFC3339Nano)
+	dm.Statistics = &stats
+
+	// the report is sent by an instance still attached, the channels of the
+	// connections already closed are not read anymore
+	outChannels := s.outChannels
+	s.store.Lock()
+	if len(s.store.instances) > 0 {
+		outChannels = s.store.instances[0].outChannels
+	}
+	s.store.Unlock()
+
+	for i := range outChannels {
+		outChannels[i] <- dm
+	}
+}
+
+// Start attaches the instance to the tops of the worker, the first instance sends the reports
+func (s *StatisticsProcessor) Start() {
+	statisticsMutex.Lock()
+	defer statisticsMutex.Unlock()
+
+	key := statisticsKey{name: s.name, config: s.config}
+	if store, ok := statisticsRegistry[key]; ok {
+		s.store = store
+	} else {
+		s.store.started = time.Now()
+		statisticsRegistry[key] = s.store
+	}
+	s.store.Lock()
+	s.store.instances = append(s.store.instances, s)
+	first := len(s.store.instances) == 1
+	s.store.Unlock()
+	if first {
+		go s.Run()
+	}
+}
+
+func (s *StatisticsProcessor) Run() {
+	// no report record if the interval is zero
+	var reports <-chan time.Time
+	if s.config.Statistics.ReportInterval > 0 {
+		ticker := time.NewTicker(time.Duration(s.config.Statistics.ReportInterval) * time.Second)
+		defer ticker.Stop()
+		reports = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.store.stop:
+			return
+		case <-reports:
+			s.Report()
+		}
+	}
+}
+
+// Stop detaches the instance, the reports are stopped with the last one. The report in
+// progress is not waited, it can be blocked by a full channel
+func (s *StatisticsProcessor) Stop() {
+	statisticsMutex.Lock()
+	s.store.Lock()
+	for i, instance := range s.store.instances {
+		if instance == s {
+			s.store.instances = append(s.store.instances[:i], s.store.instances[i+1:]...)
+			break
+		}
+	}
+	remaining := len(s.store.instances)
+	s.store.Unlock()
+	if remaining > 0 {
+		statisticsMutex.Unlock()
+		return
+	}
+	key := statisticsKey{name: s.name, config: s.config}
+	if statisticsRegistry[key] == s.store {
+		delete(statisticsRegistry, key)
+	}
+	statisticsMutex.Unlock()
+
+	close(s.store.stop)
+}
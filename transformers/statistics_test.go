@@ -0,0 +1,175 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestStatistics_Snapshot(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Statistics.Enable = true
+	config.Statistics.TopK = 2
+
+	// init subproccesor
+	outChans := []chan dnsutils.DnsMessage{}
+	stats := NewStatisticsSubprocessor(config, logger.New(false), "test", outChans)
+
+	for _, qname := range []string{"www.google.com", "www.google.com", "www.google.com", "dns.collector", "github.com"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		stats.Record(&dm)
+	}
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Rcode = dnsutils.DNS_RCODE_NXDOMAIN
+	stats.Record(&dm)
+
+	snapshot := stats.Snapshot()
+	if snapshot.Total != 6 || snapshot.Window != config.Statistics.Window {
+		t.Errorf("invalid total: %+v", snapshot)
+	}
+	if len(snapshot.TopQnames) != 2 || snapshot.TopQnames[0].Name != "www.google.com" || snapshot.TopQnames[0].Hit != 3 {
+		t.Errorf("invalid top qnames: %+v", snapshot.TopQnames)
+	}
+	if len(snapshot.TopTlds) != 2 || snapshot.TopTlds[0].Name != "com" || snapshot.TopTlds[0].Hit != 4 {
+		t.Errorf("invalid top tlds: %+v", snapshot.TopTlds)
+	}
+	if len(snapshot.TopDomains) != 2 || snapshot.TopDomains[0].Name != "google.com" || snapshot.TopDomains[0].Hit != 3 {
+		t.Errorf("invalid top domains: %+v", snapshot.TopDomains)
+	}
+	if len(snapshot.TopClients) != 1 || snapshot.TopClients[0].Hit != 6 {
+		t.Errorf("invalid top clients: %+v", snapshot.TopClients)
+	}
+	if len(snapshot.TopRcodes) != 2 || snapshot.TopRcodes[1].Name != dnsutils.DNS_RCODE_NXDOMAIN {
+		t.Errorf("invalid top rcodes: %+v", snapshot.TopRcodes)
+	}
+}
+
+func TestStatistics_Report(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Statistics.Enable = true
+	config.Statistics.ReportInterval = 1
+
+	// init subproccesor
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	stats := NewStatisticsSubprocessor(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan})
+	stats.Start()
+	defer stats.Stop()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	stats.Record(&dm)
+
+	select {
+	case report := <-outChan:
+		if report.DnsTap.Operation != dnsutils.OPERATION_STATISTICS || report.Statistics == nil || report.Statistics.Total != 1 {
+			t.Errorf("invalid report: %+v", report)
+		}
+
+		// the report is not counted
+		stats.Record(&report)
+		if stats.Snapshot().Total != 1 {
+			t.Errorf("report should not be counted")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no report received")
+	}
+
+	// registered for the api
+	if _, ok := GetStatistics()["test"]; !ok {
+		t.Errorf("statistics not registered")
+	}
+}
+
+func TestStatistics_SharedInstances(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Statistics.Enable = true
+
+	// two instances of the same worker, for two connections
+	outChans := []chan dnsutils.DnsMessage{}
+	first := NewStatisticsSubprocessor(config, logger.New(false), "shared", outChans)
+	second := NewStatisticsSubprocessor(config, logger.New(false), "shared", outChans)
+	first.Start()
+	second.Start()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	first.Record(&dm)
+	dm = dnsutils.GetFakeDnsMessage()
+	second.Record(&dm)
+
+	// counted together
+	if stats := GetStatistics()["shared"]; stats.Total != 2 {
+		t.Errorf("statistics not aggregated: %+v", stats)
+	}
+
+	// the statistics are kept until the last instance is stopped
+	first.Stop()
+	if stats, ok := GetStatistics()["shared"]; !ok || stats.Total != 2 {
+		t.Errorf("statistics removed before the last instance: %+v", stats)
+	}
+	second.Stop()
+	if _, ok := GetStatistics()["shared"]; ok {
+		t.Errorf("statistics not removed")
+	}
+}
+
+func TestStatistics_StopWithBlockedReport(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Statistics.Enable = true
+
+	// the report is blocked by a full channel
+	outChan := make(chan dnsutils.DnsMessage)
+	stats := NewStatisticsSubprocessor(config, logger.New(false), "blocked", []chan dnsutils.DnsMessage{outChan})
+	stats.Start()
+	go stats.Report()
+
+	stopped := make(chan bool)
+	go func() {
+		stats.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("stop blocked by the report")
+	}
+
+	// the other workers are not blocked
+	GetStatistics()
+	<-outChan
+}
+
+func TestStatistics_ReloadConfig(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Statistics.Enable = true
+
+	outChans := []chan dnsutils.DnsMessage{}
+	running := NewStatisticsSubprocessor(config, logger.New(false), "reload", outChans)
+	running.Start()
+	dm := dnsutils.GetFakeDnsMessage()
+	running.Record(&dm)
+
+	// the instances started with the new config have their own tops
+	newConfig := dnsutils.GetFakeConfigTransformers()
+	newConfig.Statistics.Enable = true
+	newConfig.Statistics.Window = 60
+	reloaded := NewStatisticsSubprocessor(newConfig, logger.New(false), "reload", outChans)
+	reloaded.Start()
+	if reloaded.store == running.store {
+		t.Fatalf("tops shared with the previous config")
+	}
+
+	// the most recent tops are returned
+	if stats := GetStatistics()["reload"]; stats.Window != 60 || stats.Total != 0 {
+		t.Errorf("unexpected statistics: %+v", stats)
+	}
+
+	running.Stop()
+	reloaded.Stop()
+}
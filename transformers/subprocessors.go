@@ -12,16 +12,33 @@ var (
 )
 
 type Transforms struct {
-	config *dnsutils.ConfigTransformers
-	logger *logger.Logger
-	name   string
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	outChannels []chan dnsutils.DnsMessage
 
 	SuspiciousTransform  SuspiciousTransform
 	GeoipTransform       GeoIpProcessor
-	FilteringTransform   FilteringProcessor
+	FilteringTransform   *FilteringProcessor
 	UserPrivacyTransform UserPrivacyProcessor
 	NormalizeTransform   NormalizeProcessor
 	LatencyTransform     *LatencyProcessor
+	ReducerTransform     *ReducerProcessor
+	QuotaTransform       *QuotaProcessor
+	SamplingTransform    *SamplingProcessor
+	ThreatIntelTransform *ThreatIntelProcessor
+	TunnelingTransform   *TunnelingProcessor
+	DnssecCheckTransform *DnssecCheckProcessor
+	FieldsTransform      FieldSelectionProcessor
+	JoinTransform        *JoinProcessor
+	EnrichmentTransform  *EnrichmentProcessor
+	HookTransform        *HookProcessor
+	ScriptTransform      *ScriptProcessor
+	IdentityTransform    *IdentityProcessor
+	StatisticsTransform  *StatisticsProcessor
+	AlertingTransform    *AlertingProcessor
+	BaselineTransform    *BaselineProcessor
+	PluginTransforms     []dnsutils.Subprocessor
 
 	activeTransforms []func(dm *dnsutils.DnsMessage) int
 }
@@ -29,9 +46,10 @@ type Transforms struct {
 func NewTransforms(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) Transforms {
 
 	d := Transforms{
-		config: config,
-		logger: logger,
-		name:   name,
+		config:      config,
+		logger:      logger,
+		name:        name,
+		outChannels: outChannels,
 
 		SuspiciousTransform:  NewSuspiciousSubprocessor(config, logger, name),
 		GeoipTransform:       NewDnsGeoIpProcessor(config, logger),
@@ -39,6 +57,21 @@ func NewTransforms(config *dnsutils.ConfigTransformers, logger *logger.Logger, n
 		UserPrivacyTransform: NewUserPrivacySubprocessor(config),
 		NormalizeTransform:   NewNormalizeSubprocessor(config),
 		LatencyTransform:     NewLatencySubprocessor(config, logger, name, outChannels),
+		ReducerTransform:     NewReducerSubprocessor(config, logger, name, outChannels),
+		QuotaTransform:       NewQuotaSubprocessor(config, logger, name),
+		SamplingTransform:    NewSamplingSubprocessor(config, logger, name),
+		ThreatIntelTransform: NewThreatIntelSubprocessor(config, logger, name),
+		TunnelingTransform:   NewTunnelingSubprocessor(config, logger, name, outChannels),
+		DnssecCheckTransform: NewDnssecCheckSubprocessor(config, logger, name),
+		FieldsTransform:      NewFieldSelectionSubprocessor(config),
+		JoinTransform:        NewJoinSubprocessor(config, logger, name, outChannels),
+		EnrichmentTransform:  NewEnrichmentSubprocessor(config, logger, name),
+		HookTransform:        NewHookSubprocessor(config, logger, name),
+		ScriptTransform:      NewScriptSubprocessor(config, logger, name, outChannels),
+		IdentityTransform:    NewIdentitySubprocessor(config, logger, name),
+		StatisticsTransform:  NewStatisticsSubprocessor(config, logger, name, outChannels),
+		AlertingTransform:    NewAlertingSubprocessor(config, logger, name, outChannels),
+		BaselineTransform:    NewBaselineSubprocessor(config, logger, name, outChannels),
 	}
 
 	d.Prepare()
@@ -46,6 +79,32 @@ func NewTransforms(config *dnsutils.ConfigTransformers, logger *logger.Logger, n
 }
 
 func (p *Transforms) Prepare() error {
+	// the identity is rewritten first, the quotas and the outputs use the new one
+	if p.config.Identity.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.identityTransform)
+		p.LogInfo("[identity] enabled")
+	}
+
+	// quotas are checked before spending time on enrichment
+	if p.config.Quota.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.quotaTransform)
+		go p.QuotaTransform.Run()
+		p.LogInfo("[quota] enabled")
+	}
+
+	if p.config.Sampling.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.samplingTransform)
+		go p.SamplingTransform.Run()
+		p.LogInfo("[sampling] enabled")
+	}
+
+	// responses are completed with their query before any other processing
+	if p.config.Join.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.joinTransform)
+		go p.JoinTransform.Run()
+		p.LogInfo("[join] enabled")
+	}
+
 	if p.config.Normalize.Enable {
 		if p.config.Normalize.QnameLowerCase {
 			p.activeTransforms = append(p.activeTransforms, p.lowercaseQname)
@@ -65,6 +124,17 @@ func (p *Transforms) Prepare() error {
 			p.activeTransforms = append(p.activeTransforms, p.GetEffectiveTldPlusOne)
 			p.LogInfo("[normalize: add tld+1] enabled")
 		}
+		if p.config.Normalize.AddSubdomainDepth {
+			p.activeTransforms = append(p.activeTransforms, p.GetSubdomainDepth)
+			p.LogInfo("[normalize: add subdomain depth] enabled")
+		}
+	}
+
+	// feeds are matched before the qname is minimized by the user privacy
+	if p.config.ThreatIntel.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.threatIntelTransform)
+		go p.ThreatIntelTransform.Run()
+		p.LogInfo("[threat intel] enabled")
 	}
 
 	if p.config.GeoIP.Enable {
@@ -76,6 +146,21 @@ func (p *Transforms) Prepare() error {
 		}
 	}
 
+	// the client ips are enriched before being anonymized by the user privacy
+	if p.config.Enrichment.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.enrichmentTransform)
+		if p.config.Enrichment.ReverseDns {
+			go p.EnrichmentTransform.Run()
+		}
+		p.LogInfo("[enrichment] enabled")
+	}
+
+	if p.config.Hook.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.hookTransform)
+		go p.HookTransform.Run()
+		p.LogInfo("[hook] enabled")
+	}
+
 	if p.config.UserPrivacy.Enable {
 		// Apply user privacy on qname and query ip
 		if p.config.UserPrivacy.AnonymizeIP {
@@ -88,6 +173,20 @@ func (p *Transforms) Prepare() error {
 			p.LogInfo("[user privacy: minimaze Qname] enabled")
 		}
 
+		if p.config.UserPrivacy.PseudonymizeIP {
+			if len(p.config.UserPrivacy.PseudonymizeKey) == 0 {
+				p.LogError("[user privacy: pseudonymize IP] key is required")
+			} else {
+				p.activeTransforms = append(p.activeTransforms, p.pseudonymizeIP)
+				p.LogInfo("[user privacy: pseudonymize IP] enabled")
+			}
+		}
+
+		if p.config.UserPrivacy.HashQname {
+			p.activeTransforms = append(p.activeTransforms, p.hashQname)
+			p.LogInfo("[user privacy: hash Qname] enabled")
+		}
+
 		if p.config.UserPrivacy.HashIP {
 			p.activeTransforms = append(p.activeTransforms, p.hashIP)
 			p.LogInfo("[user privacy: hash IP] enabled")
@@ -103,6 +202,12 @@ func (p *Transforms) Prepare() error {
 		p.LogInfo("[filtering] enabled")
 	}
 
+	if p.config.TunnelingDetector.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.tunnelingDetector)
+		go p.TunnelingTransform.Run()
+		p.LogInfo("[tunneling detector] enabled")
+	}
+
 	if p.config.Latency.Enable {
 		if p.config.Latency.MeasureLatency {
 			p.activeTransforms = append(p.activeTransforms, p.measureLatency)
@@ -112,7 +217,77 @@ func (p *Transforms) Prepare() error {
 			p.activeTransforms = append(p.activeTransforms, p.detectEvictedTimeout)
 			p.LogInfo("[latency: unanswered queries] enabled")
 		}
+		// the unanswered queries are sent to the outputs without the next transformers
+		if p.config.Alerting.Enable {
+			p.LatencyTransform.OnUnanswered(p.AlertingTransform.Record)
+		}
+		go p.LatencyTransform.Run()
+	}
+
+	if p.config.DnssecCheck.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.dnssecCheckTransform)
+		go p.DnssecCheckTransform.Run()
+		p.LogInfo("[dnssec check] enabled")
+	}
+
+	if p.config.Reducer.Enable {
+		if p.config.Reducer.RepetitiveTrafficDetector {
+			p.activeTransforms = append(p.activeTransforms, p.repetitiveTrafficDetector)
+			go p.ReducerTransform.Run()
+			p.LogInfo("[reducer: repetitive traffic detector] enabled")
+		}
+	}
 
+	if p.config.Statistics.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.statisticsTransform)
+		p.StatisticsTransform.Start()
+		p.LogInfo("[statistics] enabled")
+	}
+
+	if p.config.Alerting.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.alertingTransform)
+		go p.AlertingTransform.Run()
+		p.LogInfo("[alerting] enabled")
+	}
+
+	if p.config.Baseline.Enable {
+		p.activeTransforms = append(p.activeTransforms, p.baselineTransform)
+		p.BaselineTransform.Start()
+		p.LogInfo("[baseline] enabled")
+	}
+
+	if p.config.Script.Enable {
+		if err := p.ScriptTransform.Load(); err != nil {
+			p.LogError("[script] load error %v", err)
+		} else {
+			p.activeTransforms = append(p.activeTransforms, p.scriptTransform)
+			p.LogInfo("[script] enabled")
+		}
+	}
+
+	// the subprocessors of the plugins, in the order of the config
+	for _, plugin := range p.config.Plugins {
+		if !plugin.Enable {
+			continue
+		}
+		factory, ok := dnsutils.GetSubprocessorPlugin(plugin.Name)
+		if !ok {
+			p.LogError("[plugin %s] unknown plugin", plugin.Name)
+			continue
+		}
+		s, err := factory(plugin.Params, p.logger, p.name, p.outChannels)
+		if err != nil {
+			p.LogError("[plugin %s] %v", plugin.Name, err)
+			continue
+		}
+		p.PluginTransforms = append(p.PluginTransforms, s)
+		p.activeTransforms = append(p.activeTransforms, pluginTransform(s))
+		p.LogInfo("[plugin %s] enabled", plugin.Name)
+	}
+
+	if p.FieldsTransform.IsEnabled() {
+		p.activeTransforms = append(p.activeTransforms, p.selectFields)
+		p.LogInfo("[field selection] enabled")
 	}
 
 	return nil
@@ -126,16 +301,93 @@ func (p *Transforms) InitDnsMessageFormat(dm *dnsutils.DnsMessage) {
 		p.SuspiciousTransform.InitDnsMessage(dm)
 	}
 	if p.config.Normalize.Enable {
-		if p.config.Normalize.AddTld || p.config.Normalize.AddTldPlusOne {
+		if p.config.Normalize.AddTld || p.config.Normalize.AddTldPlusOne || p.config.Normalize.AddSubdomainDepth {
 			p.NormalizeTransform.InitDnsMessage(dm)
 		}
 	}
+	if p.config.Reducer.Enable {
+		p.ReducerTransform.InitDnsMessage(dm)
+	}
+	if p.config.DnssecCheck.Enable {
+		p.DnssecCheckTransform.InitDnsMessage(dm)
+	}
+	if p.config.ThreatIntel.Enable {
+		p.ThreatIntelTransform.InitDnsMessage(dm)
+	}
+	if p.config.Enrichment.Enable {
+		p.EnrichmentTransform.InitDnsMessage(dm)
+	}
+}
+
+// Flush sends the messages held by the subprocessors to the next workers: the summaries of the
+// reducer and the queries waiting for their response with the join. It must be called while
+// the next workers are reading their channels, before to reset the subprocessors
+func (p *Transforms) Flush() {
+	if p.config.Reducer.Enable && p.config.Reducer.RepetitiveTrafficDetector {
+		p.ReducerTransform.Flush()
+	}
+	if p.config.Join.Enable {
+		p.JoinTransform.Flush()
+	}
 }
 
 func (p *Transforms) Reset() {
 	if p.config.GeoIP.Enable {
 		p.GeoipTransform.Close()
 	}
+	if p.config.Reducer.Enable && p.config.Reducer.RepetitiveTrafficDetector {
+		p.ReducerTransform.Stop()
+	}
+	if p.config.Quota.Enable {
+		p.QuotaTransform.Stop()
+	}
+	if p.config.Sampling.Enable {
+		p.SamplingTransform.Stop()
+	}
+	if p.config.ThreatIntel.Enable {
+		p.ThreatIntelTransform.Stop()
+	}
+	if p.config.TunnelingDetector.Enable {
+		p.TunnelingTransform.Stop()
+	}
+	if p.config.Statistics.Enable {
+		p.StatisticsTransform.Stop()
+	}
+	if p.config.Latency.Enable {
+		p.LatencyTransform.Stop()
+	}
+	if p.config.Join.Enable {
+		p.JoinTransform.Stop()
+	}
+	if p.config.Alerting.Enable {
+		p.AlertingTransform.Stop()
+	}
+	if p.config.Baseline.Enable {
+		p.BaselineTransform.Stop()
+	}
+	if p.config.Hook.Enable {
+		p.HookTransform.Stop()
+	}
+	if p.config.Enrichment.Enable && p.config.Enrichment.ReverseDns {
+		p.EnrichmentTransform.Stop()
+	}
+	if p.config.DnssecCheck.Enable {
+		p.DnssecCheckTransform.Stop()
+	}
+	if p.config.Script.Enable {
+		p.ScriptTransform.Close()
+	}
+	for _, s := range p.PluginTransforms {
+		s.Stop()
+	}
+}
+
+// ReloadConfig stops the current subprocessors and prepares new ones with the config provided,
+// the states of the previous ones (latency, reducer, quota...) are not kept
+func (p *Transforms) ReloadConfig(config *dnsutils.ConfigTransformers) {
+	p.LogInfo("reloading transformers...")
+	p.Reset()
+	*p = NewTransforms(config, p.logger, p.name, p.outChannels)
 }
 
 func (p *Transforms) LogInfo(msg string, v ...interface{}) {
@@ -153,6 +405,9 @@ func (p *Transforms) suspiciousTransform(dm *dnsutils.DnsMessage) int {
 }
 
 func (p *Transforms) geoipTransform(dm *dnsutils.DnsMessage) int {
+	// reload the databases updated on disk
+	p.GeoipTransform.CheckUpdates()
+
 	geoInfo, err := p.GeoipTransform.Lookup(dm.NetworkInfo.QueryIp)
 	if err != nil {
 		p.LogError("geoip lookup error %v", err)
@@ -164,6 +419,40 @@ func (p *Transforms) geoipTransform(dm *dnsutils.DnsMessage) int {
 	dm.Geo.City = geoInfo.City
 	dm.Geo.AutonomousSystemNumber = geoInfo.ASN
 	dm.Geo.AutonomousSystemOrg = geoInfo.ASO
+	if len(p.config.GeoIP.DbIspFile) > 0 {
+		dm.Geo.Isp = geoInfo.ISP
+	}
+	if len(p.config.GeoIP.DbConnectionTypeFile) > 0 {
+		dm.Geo.ConnectionType = geoInfo.ConnectionType
+	}
+
+	// enrich the server which answered
+	if p.config.GeoIP.LookupResponseIp && dm.NetworkInfo.ResponseIp != "-" {
+		serverInfo, err := p.GeoipTransform.Lookup(dm.NetworkInfo.ResponseIp)
+		if err != nil {
+			p.LogError("geoip lookup error %v", err)
+			return RETURN_ERROR
+		}
+		dm.Geo.ServerCountryIsoCode = serverInfo.CountryISOCode
+		dm.Geo.ServerAsNumber = serverInfo.ASN
+		dm.Geo.ServerAsOrg = serverInfo.ASO
+	}
+
+	// enrich the ip addresses returned in answers
+	if p.config.GeoIP.LookupAnswerIps {
+		for _, rr := range dm.DNS.DnsRRs.Answers {
+			if rr.Rdatatype != "A" && rr.Rdatatype != "AAAA" {
+				continue
+			}
+			answerInfo, err := p.GeoipTransform.Lookup(rr.Rdata)
+			if err != nil {
+				p.LogError("geoip lookup error %v", err)
+				return RETURN_ERROR
+			}
+			dm.Geo.AnswerAsNumbers = append(dm.Geo.AnswerAsNumbers, answerInfo.ASN)
+			dm.Geo.AnswerAsOrgs = append(dm.Geo.AnswerAsOrgs, answerInfo.ASO)
+		}
+	}
 
 	return RETURN_SUCCESS
 }
@@ -183,12 +472,22 @@ func (p *Transforms) GetEffectiveTldPlusOne(dm *dnsutils.DnsMessage) int {
 	return RETURN_SUCCESS
 }
 
+func (p *Transforms) GetSubdomainDepth(dm *dnsutils.DnsMessage) int {
+	dm.PublicSuffix.QnameSubdomainDepth = p.NormalizeTransform.GetSubdomainDepth(dm.DNS.Qname)
+	return RETURN_SUCCESS
+}
+
 func (p *Transforms) anonymizeIP(dm *dnsutils.DnsMessage) int {
 	dm.NetworkInfo.QueryIp = p.UserPrivacyTransform.AnonymizeIP(dm.NetworkInfo.QueryIp)
 
 	return RETURN_SUCCESS
 }
 
+func (p *Transforms) pseudonymizeIP(dm *dnsutils.DnsMessage) int {
+	dm.NetworkInfo.QueryIp = p.UserPrivacyTransform.PseudonymizeIP(dm.NetworkInfo.QueryIp)
+	return RETURN_SUCCESS
+}
+
 func (p *Transforms) hashIP(dm *dnsutils.DnsMessage) int {
 	dm.NetworkInfo.QueryIp = p.UserPrivacyTransform.HashIP(dm.NetworkInfo.QueryIp)
 	dm.NetworkInfo.ResponseIp = p.UserPrivacyTransform.HashIP(dm.NetworkInfo.ResponseIp)
@@ -205,12 +504,115 @@ func (p *Transforms) detectEvictedTimeout(dm *dnsutils.DnsMessage) int {
 	return RETURN_SUCCESS
 }
 
+func (p *Transforms) repetitiveTrafficDetector(dm *dnsutils.DnsMessage) int {
+	if p.ReducerTransform.RepetitiveTrafficDetector(dm) {
+		return RETURN_DROP
+	}
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) quotaTransform(dm *dnsutils.DnsMessage) int {
+	if p.QuotaTransform.OverQuota(dm) {
+		return RETURN_DROP
+	}
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) samplingTransform(dm *dnsutils.DnsMessage) int {
+	if p.SamplingTransform.Drop(dm) {
+		return RETURN_DROP
+	}
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) threatIntelTransform(dm *dnsutils.DnsMessage) int {
+	if !p.ThreatIntelTransform.CheckQname(dm) && p.config.ThreatIntel.DropUnmatched {
+		return RETURN_DROP
+	}
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) tunnelingDetector(dm *dnsutils.DnsMessage) int {
+	if dm.Tunneling != nil {
+		return RETURN_SUCCESS
+	}
+	p.TunnelingTransform.Record(dm)
+	if p.config.TunnelingDetector.AlertsOnly {
+		return RETURN_DROP
+	}
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) dnssecCheckTransform(dm *dnsutils.DnsMessage) int {
+	p.DnssecCheckTransform.CheckAnswers(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) enrichmentTransform(dm *dnsutils.DnsMessage) int {
+	p.EnrichmentTransform.Enrich(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) hookTransform(dm *dnsutils.DnsMessage) int {
+	p.HookTransform.Enrich(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) scriptTransform(dm *dnsutils.DnsMessage) int {
+	drop, err := p.ScriptTransform.Process(dm)
+	if err != nil {
+		p.LogError("[script] %v", err)
+		return RETURN_ERROR
+	}
+	if drop {
+		return RETURN_DROP
+	}
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) identityTransform(dm *dnsutils.DnsMessage) int {
+	p.IdentityTransform.Relabel(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) statisticsTransform(dm *dnsutils.DnsMessage) int {
+	p.StatisticsTransform.Record(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) alertingTransform(dm *dnsutils.DnsMessage) int {
+	p.AlertingTransform.Record(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) baselineTransform(dm *dnsutils.DnsMessage) int {
+	p.BaselineTransform.Record(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) joinTransform(dm *dnsutils.DnsMessage) int {
+	if p.JoinTransform.Join(dm) {
+		return RETURN_DROP
+	}
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) selectFields(dm *dnsutils.DnsMessage) int {
+	p.FieldsTransform.Select(dm)
+	return RETURN_SUCCESS
+}
+
 func (p *Transforms) minimazeQname(dm *dnsutils.DnsMessage) int {
 	dm.DNS.Qname = p.UserPrivacyTransform.MinimazeQname(dm.DNS.Qname)
 
 	return RETURN_SUCCESS
 }
 
+func (p *Transforms) hashQname(dm *dnsutils.DnsMessage) int {
+	dm.DNS.Qname = p.UserPrivacyTransform.HashQname(dm.DNS.Qname)
+	return RETURN_SUCCESS
+}
+
 func (p *Transforms) lowercaseQname(dm *dnsutils.DnsMessage) int {
 	dm.DNS.Qname = p.NormalizeTransform.Lowercase(dm.DNS.Qname)
 
@@ -237,5 +639,10 @@ func (p *Transforms) ProcessMessage(dm *dnsutils.DnsMessage) int {
 		}
 	}
 
+	// filtering on enriched fields
+	if p.FilteringTransform.CheckIfDropEnriched(dm) {
+		return RETURN_DROP
+	}
+
 	return RETURN_SUCCESS
 }
@@ -104,6 +104,72 @@ func TestTransformsGeoIPLookupAsn(t *testing.T) {
 	}
 }
 
+func TestTransformsGeoIPLookupResponseIp(t *testing.T) {
+	// enable geoip on the response ip
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.Enable = true
+	config.GeoIP.DbAsnFile = "../testsdata/GeoLite2-ASN.mmdb"
+	config.GeoIP.DbCountryFile = "../testsdata/GeoLite2-Country.mmdb"
+	config.GeoIP.LookupResponseIp = true
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels)
+
+	// create test message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.ResponseIp = "83.112.146.176"
+
+	// init dns message with additional part
+	subprocessors.InitDnsMessageFormat(&dm)
+
+	// apply subprocessors
+	return_code := subprocessors.ProcessMessage(&dm)
+
+	if dm.Geo.ServerAsOrg != "Orange" {
+		t.Errorf("server asn organisation invalid want: Orange got: %s", dm.Geo.ServerAsOrg)
+	}
+	if dm.Geo.ServerCountryIsoCode != "FR" {
+		t.Errorf("server country invalid want: FR got: %s", dm.Geo.ServerCountryIsoCode)
+	}
+
+	if return_code != RETURN_SUCCESS {
+		t.Errorf("Return code is %v and not RETURN_SUCCESS (%v)", return_code, RETURN_SUCCESS)
+	}
+}
+
+func TestTransformsGeoIPDropAnswerAsn(t *testing.T) {
+	// enable geoip on answers and drop orange
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.Enable = true
+	config.GeoIP.DbAsnFile = "../testsdata/GeoLite2-ASN.mmdb"
+	config.GeoIP.LookupAnswerIps = true
+	config.Filtering.DropAsns = []string{"orange"}
+	config.Filtering.AsnMatchOn = []string{dnsutils.ASN_MATCH_ANSWER}
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels)
+
+	// create test message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.DnsRRs.Answers = []dnsutils.DnsAnswer{{Name: dm.DNS.Qname, Rdatatype: "A", Rdata: "83.112.146.176"}}
+
+	// init dns message with additional part
+	subprocessors.InitDnsMessageFormat(&dm)
+
+	// apply subprocessors
+	return_code := subprocessors.ProcessMessage(&dm)
+
+	if len(dm.Geo.AnswerAsOrgs) != 1 || dm.Geo.AnswerAsOrgs[0] != "Orange" {
+		t.Errorf("answer asn organisation invalid want: Orange got: %v", dm.Geo.AnswerAsOrgs)
+	}
+
+	if return_code != RETURN_DROP {
+		t.Errorf("Return code is %v and not RETURN_DROP (%v)", return_code, RETURN_DROP)
+	}
+}
+
 func TestTransformsReduceQname(t *testing.T) {
 	// enable feature
 	config := dnsutils.GetFakeConfigTransformers()
@@ -309,3 +375,54 @@ func TestTransformAndFilter(t *testing.T) {
 		t.Errorf("Ipv6 anonymization failed, got %s", dm.NetworkInfo.QueryIp)
 	}
 }
+
+func TestTransformsReloadConfig(t *testing.T) {
+	// no transformers enabled
+	config := dnsutils.GetFakeConfigTransformers()
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels)
+
+	// reload with the lowercase normalization
+	newConfig := dnsutils.GetFakeConfigTransformers()
+	newConfig.Normalize.Enable = true
+	newConfig.Normalize.QnameLowerCase = true
+	subprocessors.ReloadConfig(newConfig)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = CAPS_ADDRESS
+	subprocessors.ProcessMessage(&dm)
+	if dm.DNS.Qname != NORM_ADDRESS {
+		t.Errorf("qname should be normalized after reload: %s", dm.DNS.Qname)
+	}
+}
+
+func TestTransformsFlushReducer(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.Coalesce = true
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 10)}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels)
+
+	// the messages are held until the end of the interval
+	for i := 0; i < 3; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		subprocessors.InitDnsMessageFormat(&dm)
+		if subprocessors.ProcessMessage(&dm) != RETURN_DROP {
+			t.Fatalf("message should be held")
+		}
+	}
+
+	// the held messages are sent before the stop
+	subprocessors.Flush()
+	subprocessors.Reset()
+	if len(channels[0]) != 1 {
+		t.Fatalf("one message expected on stop, got %d", len(channels[0]))
+	}
+	if dm := <-channels[0]; dm.Reducer.Occurences != 3 {
+		t.Errorf("invalid summary: %+v", dm.Reducer)
+	}
+}
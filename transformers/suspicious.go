@@ -1,6 +1,7 @@
 package transformers
 
 import (
+	"math"
 	"strings"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -55,9 +56,46 @@ func (p *SuspiciousTransform) InitDnsMessage(dm *dnsutils.DnsMessage) {
 		UnallowedChars:        false,
 		UncommonQtypes:        false,
 		ExcessiveNumberLabels: false,
+		HighEntropy:           false,
+		Dga:                   false,
+		Tunneling:             false,
 	}
 }
 
+// QnameFeatures returns the shannon entropy in bits per character, the consonant ratio
+// and the digit ratio of the qname, computed without the public suffix and the dots
+func QnameFeatures(qname string) (float64, float64, float64) {
+	name := strings.ToLower(qname)
+	if suffix, _ := dnsutils.GetPublicSuffix(name); len(suffix) < len(name) {
+		name = strings.TrimSuffix(name, "."+suffix)
+	}
+	name = strings.ReplaceAll(name, ".", "")
+	if len(name) == 0 {
+		return 0, 0, 0
+	}
+
+	freqs := make(map[rune]int)
+	consonants, digits := 0, 0
+	for _, c := range name {
+		freqs[c]++
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'z' && !strings.ContainsRune("aeiouy", c):
+			consonants++
+		}
+	}
+
+	entropy := 0.0
+	for _, n := range freqs {
+		p := float64(n) / float64(len(name))
+		entropy -= p * math.Log2(p)
+	}
+
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	return round(entropy), round(float64(consonants) / float64(len(name))), round(float64(digits) / float64(len(name)))
+}
+
 func (p *SuspiciousTransform) CheckIfSuspicious(dm *dnsutils.DnsMessage) {
 
 	if dm.Suspicious == nil {
@@ -103,4 +141,26 @@ func (p *SuspiciousTransform) CheckIfSuspicious(dm *dnsutils.DnsMessage) {
 			break
 		}
 	}
+
+	// random looking names, the entropy of a short name is always low
+	// because it can't exceed log2 of the length
+	dm.Suspicious.Entropy, dm.Suspicious.ConsonantRatio, dm.Suspicious.DigitRatio = QnameFeatures(dm.DNS.Qname)
+	if dm.Suspicious.Entropy <= p.config.Suspicious.ThresholdEntropy {
+		return
+	}
+	dm.Suspicious.Score += 1.0
+	dm.Suspicious.HighEntropy = true
+
+	// generated names, unpronounceable or full of digits
+	if dm.Suspicious.ConsonantRatio > p.config.Suspicious.ThresholdConsonantRatio ||
+		dm.Suspicious.DigitRatio > p.config.Suspicious.ThresholdDigitRatio {
+		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Dga = true
+	}
+
+	// data encoded in long names
+	if dm.Suspicious.LongDomain || dm.Suspicious.ExcessiveNumberLabels {
+		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Tunneling = true
+	}
 }
@@ -165,3 +165,73 @@ func TestSuspiciousUnallowedChars(t *testing.T) {
 		t.Errorf("suspicious unallowed chars flag should be equal to true")
 	}
 }
+
+func TestSuspiciousQnameFeatures(t *testing.T) {
+	testcases := []struct {
+		qname     string
+		entropy   float64
+		consonant float64
+		digit     float64
+	}{
+		{qname: "aaaa.com", entropy: 0, consonant: 0, digit: 0},
+		{qname: "ab12.co.uk", entropy: 2, consonant: 0.25, digit: 0.5},
+		{qname: "com", entropy: 1.58, consonant: 0.67, digit: 0},
+	}
+
+	for _, tc := range testcases {
+		entropy, consonant, digit := QnameFeatures(tc.qname)
+		if entropy != tc.entropy || consonant != tc.consonant || digit != tc.digit {
+			t.Errorf("%s: want %v %v %v, got %v %v %v", tc.qname,
+				tc.entropy, tc.consonant, tc.digit, entropy, consonant, digit)
+		}
+	}
+}
+
+func TestSuspiciousDga(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test")
+
+	testcases := []struct {
+		qname       string
+		highEntropy bool
+		dga         bool
+	}{
+		{qname: "www.google.com", highEntropy: false, dga: false},
+		{qname: "xjkqzvbtrwpl.com", highEntropy: true, dga: true},
+		{qname: "q7x2k9m4z8v1.net", highEntropy: true, dga: true},
+	}
+
+	for _, tc := range testcases {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = tc.qname
+		suspicious.InitDnsMessage(&dm)
+		suspicious.CheckIfSuspicious(&dm)
+
+		if dm.Suspicious.HighEntropy != tc.highEntropy || dm.Suspicious.Dga != tc.dga || dm.Suspicious.Tunneling {
+			t.Errorf("%s: invalid flags %+v", tc.qname, dm.Suspicious)
+		}
+	}
+}
+
+func TestSuspiciousTunneling(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+	config.Suspicious.ThresholdQnameLen = 50
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "mzxw6ytboi2dgnbvgy3tqojqgezdgnbvgy3tqojqmfrggzdfmztwq.t.example.com"
+	suspicious.InitDnsMessage(&dm)
+	suspicious.CheckIfSuspicious(&dm)
+
+	if !dm.Suspicious.LongDomain || !dm.Suspicious.HighEntropy || !dm.Suspicious.Tunneling {
+		t.Errorf("tunneling should be detected: %+v", dm.Suspicious)
+	}
+}
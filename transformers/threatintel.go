@@ -0,0 +1,205 @@
+package transformers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// threat intel processor, matches the qname against domain blocklists and IOC feeds
+type ThreatIntelProcessor struct {
+	sync.RWMutex
+	config  *dnsutils.ConfigTransformers
+	logger  *logger.Logger
+	name    string
+	client  *http.Client
+	feeds   map[string]map[string]bool
+	loading sync.Mutex
+	stop    chan bool
+}
+
+func NewThreatIntelSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string) *ThreatIntelProcessor {
+	s := ThreatIntelProcessor{
+		config: config,
+		logger: logger,
+		name:   name,
+		client: &http.Client{Timeout: 30 * time.Second},
+		feeds:  make(map[string]map[string]bool),
+		stop:   make(chan bool),
+	}
+	return &s
+}
+
+func (s *ThreatIntelProcessor) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info("["+s.name+"] subprocessor threat intel - "+msg, v...)
+}
+
+func (s *ThreatIntelProcessor) LogError(msg string, v ...interface{}) {
+	s.logger.Error("["+s.name+"] subprocessor threat intel - "+msg, v...)
+}
+
+func (s *ThreatIntelProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
+	dm.ThreatIntel = &dnsutils.TransformThreatIntel{
+		Feed:     "-",
+		Category: "-",
+	}
+}
+
+// ParseFeed reads the domains of a feed, one domain per line or hosts file lines,
+// empty lines and comments are ignored
+func ParseFeed(r io.Reader, format string) (map[string]bool, error) {
+	domains := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch format {
+		case dnsutils.THREAT_FEED_HOSTS:
+			// the first field is the address, the next ones are the hostnames
+			for _, host := range fields[1:] {
+				if host != "localhost" && host != "localhost.localdomain" {
+					domains[strings.TrimSuffix(strings.ToLower(host), ".")] = true
+				}
+			}
+		case dnsutils.THREAT_FEED_DOMAINS:
+			domains[strings.TrimSuffix(strings.ToLower(fields[0]), ".")] = true
+		default:
+			return nil, fmt.Errorf("invalid feed format: %s", format)
+		}
+	}
+	return domains, scanner.Err()
+}
+
+// LoadFeed reads a feed from a local file or a remote url
+func (s *ThreatIntelProcessor) LoadFeed(feed dnsutils.ConfigThreatFeed) (map[string]bool, error) {
+	if strings.HasPrefix(feed.Source, "http://") || strings.HasPrefix(feed.Source, "https://") {
+		resp, err := s.client.Get(feed.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected http status: %s", resp.Status)
+		}
+		return ParseFeed(resp.Body, feed.Format)
+	}
+
+	file, err := os.Open(feed.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseFeed(file, feed.Format)
+}
+
+// LoadFeeds (re)loads all feeds, a feed which can't be loaded keeps its previous domains.
+// The domains of a feed are replaced only once the new ones are fully loaded
+func (s *ThreatIntelProcessor) LoadFeeds() {
+	s.loading.Lock()
+	defer s.loading.Unlock()
+	s.load()
+}
+
+func (s *ThreatIntelProcessor) load() {
+	for _, feed := range s.config.ThreatIntel.Feeds {
+		domains, err := s.LoadFeed(feed)
+		if err != nil {
+			s.LogError("unable to load the feed %s: %v", feed.Name, err)
+			continue
+		}
+
+		s.Lock()
+		s.feeds[feed.Name] = domains
+		s.Unlock()
+		s.LogInfo("feed %s loaded with %d domains", feed.Name, len(domains))
+	}
+}
+
+// Match returns the first feed containing the qname or one of its parent domains
+func (s *ThreatIntelProcessor) Match(qname string) (dnsutils.ConfigThreatFeed, bool) {
+	qname = strings.TrimSuffix(strings.ToLower(qname), ".")
+
+	s.RLock()
+	defer s.RUnlock()
+
+	for {
+		for _, feed := range s.config.ThreatIntel.Feeds {
+			if s.feeds[feed.Name][qname] {
+				return feed, true
+			}
+		}
+
+		i := strings.Index(qname, ".")
+		if i < 0 {
+			return dnsutils.ConfigThreatFeed{}, false
+		}
+		qname = qname[i+1:]
+	}
+}
+
+// CheckQname tags the message with the feed and category of the qname, returns true on match
+func (s *ThreatIntelProcessor) CheckQname(dm *dnsutils.DnsMessage) bool {
+	if dm.ThreatIntel == nil {
+		s.InitDnsMessage(dm)
+	}
+
+	feed, matched := s.Match(dm.DNS.Qname)
+	if matched {
+		dm.ThreatIntel.Feed = feed.Name
+		dm.ThreatIntel.Category = feed.Category
+	}
+	return matched
+}
+
+// Run loads the feeds in background, the messages are not matched until the feeds are
+// loaded, and refreshes them periodically. A refresh is skipped while a load is in progress
+func (s *ThreatIntelProcessor) Run() {
+	go s.LoadFeeds()
+
+	// feeds are not refreshed
+	if s.config.ThreatIntel.RefreshInterval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.ThreatIntel.RefreshInterval) * time.Second)
+	for {
+		select {
+		case <-s.stop:
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			go s.refresh()
+		}
+	}
+}
+
+// refresh reloads the feeds, unless the previous load is still in progress
+func (s *ThreatIntelProcessor) refresh() {
+	if !s.loading.TryLock() {
+		s.LogInfo("feeds still loading, refresh skipped")
+		return
+	}
+	defer s.loading.Unlock()
+	s.load()
+}
+
+func (s *ThreatIntelProcessor) Stop() {
+	s.stop <- true
+}
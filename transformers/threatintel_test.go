@@ -0,0 +1,127 @@
+package transformers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestThreatIntel_LocalFeeds(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ThreatIntel.Enable = true
+	config.ThreatIntel.Feeds = []dnsutils.ConfigThreatFeed{
+		{Name: "c2", Category: "malware", Source: "../testsdata/threatintel_domains.txt", Format: dnsutils.THREAT_FEED_DOMAINS},
+		{Name: "ads", Category: "advertising", Source: "../testsdata/threatintel_hosts.txt", Format: dnsutils.THREAT_FEED_HOSTS},
+	}
+
+	// init subproccesor
+	threatintel := NewThreatIntelSubprocessor(config, logger.New(false), "test")
+	threatintel.LoadFeeds()
+
+	testcases := []struct {
+		qname    string
+		feed     string
+		category string
+	}{
+		{qname: "c2.example.com", feed: "c2", category: "malware"},
+		{qname: "www.malware.example.net", feed: "c2", category: "malware"},
+		{qname: "TRACKER.example.org.", feed: "ads", category: "advertising"},
+		{qname: "localhost", feed: "-", category: "-"},
+		{qname: "example.com", feed: "-", category: "-"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.qname, func(t *testing.T) {
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Qname = tc.qname
+
+			matched := threatintel.CheckQname(&dm)
+			if matched != (tc.feed != "-") {
+				t.Errorf("unexpected match result: %v", matched)
+			}
+			if dm.ThreatIntel.Feed != tc.feed || dm.ThreatIntel.Category != tc.category {
+				t.Errorf("want %s/%s, got %s/%s", tc.feed, tc.category, dm.ThreatIntel.Feed, dm.ThreatIntel.Category)
+			}
+		})
+	}
+}
+
+func TestThreatIntel_RemoteFeed(t *testing.T) {
+	// fake feed server
+	domains := "evil.example.com\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(domains))
+	}))
+	defer srv.Close()
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ThreatIntel.Enable = true
+	config.ThreatIntel.Feeds = []dnsutils.ConfigThreatFeed{
+		{Name: "ioc", Category: "c2", Source: srv.URL, Format: dnsutils.THREAT_FEED_DOMAINS},
+	}
+
+	// init subproccesor
+	threatintel := NewThreatIntelSubprocessor(config, logger.New(false), "test")
+	threatintel.LoadFeeds()
+
+	if _, matched := threatintel.Match("evil.example.com"); !matched {
+		t.Errorf("evil.example.com should match")
+	}
+
+	// the feed is refreshed
+	domains = "other.example.com\n"
+	threatintel.LoadFeeds()
+	if _, matched := threatintel.Match("evil.example.com"); matched {
+		t.Errorf("evil.example.com should not match after refresh")
+	}
+
+	// the previous domains are kept on error
+	srv.Close()
+	threatintel.LoadFeeds()
+	if _, matched := threatintel.Match("other.example.com"); !matched {
+		t.Errorf("other.example.com should still match")
+	}
+}
+
+func TestThreatIntel_LoadInBackground(t *testing.T) {
+	// fake feed server, slow to reply
+	release := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("evil.example.com\n"))
+	}))
+	defer srv.Close()
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ThreatIntel.Enable = true
+	config.ThreatIntel.RefreshInterval = 0
+	config.ThreatIntel.Feeds = []dnsutils.ConfigThreatFeed{
+		{Name: "ioc", Category: "c2", Source: srv.URL, Format: dnsutils.THREAT_FEED_DOMAINS},
+	}
+
+	// the feeds are loading, the messages are not matched yet
+	threatintel := NewThreatIntelSubprocessor(config, logger.New(false), "test")
+	go threatintel.Run()
+	defer threatintel.Stop()
+
+	if _, matched := threatintel.Match("evil.example.com"); matched {
+		t.Errorf("evil.example.com should not match before the feed is loaded")
+	}
+
+	// the feed is loaded
+	close(release)
+	for i := 0; i < 50; i++ {
+		if _, matched := threatintel.Match("evil.example.com"); matched {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("evil.example.com should match once the feed is loaded")
+}
@@ -0,0 +1,147 @@
+package transformers
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+// traffic of a client to a domain during the current interval
+type ClientVolumetrics struct {
+	dm         dnsutils.DnsMessage
+	domain     string
+	messages   int
+	txtNull    int
+	length     int
+	subdomains map[string]bool
+}
+
+// tunneling detector, tracks the traffic of each client per registered domain
+// and sends an alert when the volumetrics of the interval look like a tunnel
+type TunnelingProcessor struct {
+	sync.Mutex
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	outChannels []chan dnsutils.DnsMessage
+	interval    time.Duration
+	clients     map[string]*ClientVolumetrics
+	stop        chan bool
+}
+
+func NewTunnelingSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage) *TunnelingProcessor {
+	s := TunnelingProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		outChannels: outChannels,
+		interval:    time.Duration(config.TunnelingDetector.WatchInterval) * time.Second,
+		clients:     make(map[string]*ClientVolumetrics),
+		stop:        make(chan bool),
+	}
+	return &s
+}
+
+// Record adds the message to the volumetrics of its client and domain
+func (s *TunnelingProcessor) Record(dm *dnsutils.DnsMessage) {
+	// alert already sent
+	if dm.Tunneling != nil {
+		return
+	}
+
+	qname := strings.ToLower(strings.TrimSuffix(dm.DNS.Qname, "."))
+	domain, err := dnsutils.GetEffectiveTLDPlusOne(qname)
+	if err != nil {
+		domain = qname
+	}
+	key := dm.NetworkInfo.QueryIp + "+" + domain
+
+	s.Lock()
+	defer s.Unlock()
+
+	c, exists := s.clients[key]
+	if !exists {
+		c = &ClientVolumetrics{domain: domain, subdomains: make(map[string]bool)}
+		s.clients[key] = c
+	}
+
+	c.dm = *dm
+	c.messages++
+	c.length += dm.DNS.Length
+	if dm.DNS.Qtype == "TXT" || dm.DNS.Qtype == "NULL" {
+		c.txtNull++
+	}
+
+	// no need to remember more subdomains than the threshold
+	if len(c.subdomains) <= s.config.TunnelingDetector.ThresholdUniqSubdomains {
+		c.subdomains[strings.TrimSuffix(qname, domain)] = true
+	}
+}
+
+// IsTunnel returns true if one of the enabled thresholds is crossed
+func (s *TunnelingProcessor) IsTunnel(c *ClientVolumetrics) bool {
+	cfg := s.config.TunnelingDetector
+	if c.messages < cfg.MinMessages {
+		return false
+	}
+	if cfg.ThresholdUniqSubdomains > 0 && len(c.subdomains) >= cfg.ThresholdUniqSubdomains {
+		return true
+	}
+	if cfg.ThresholdTxtNullRatio > 0 && float64(c.txtNull)/float64(c.messages) >= cfg.ThresholdTxtNullRatio {
+		return true
+	}
+	if cfg.ThresholdAvgLength > 0 && c.length/c.messages >= cfg.ThresholdAvgLength {
+		return true
+	}
+	return false
+}
+
+// Flush sends an alert for each client and domain detected as a tunnel
+// during the interval, then starts a new interval
+func (s *TunnelingProcessor) Flush() {
+	s.Lock()
+	alerts := []dnsutils.DnsMessage{}
+	for key, c := range s.clients {
+		if s.IsTunnel(c) {
+			dm := c.dm
+			dm.Tunneling = &dnsutils.TransformTunneling{
+				Domain:         c.domain,
+				Messages:       c.messages,
+				UniqSubdomains: len(c.subdomains),
+				TxtNullRatio:   math.Round(float64(c.txtNull)/float64(c.messages)*100) / 100,
+				AvgLength:      c.length / c.messages,
+				Interval:       int(s.interval.Seconds()),
+			}
+			alerts = append(alerts, dm)
+		}
+		delete(s.clients, key)
+	}
+	s.Unlock()
+
+	for _, dm := range alerts {
+		for i := range s.outChannels {
+			s.outChannels[i] <- dm
+		}
+	}
+}
+
+func (s *TunnelingProcessor) Run() {
+	ticker := time.NewTicker(s.interval)
+	for {
+		select {
+		case <-s.stop:
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+func (s *TunnelingProcessor) Stop() {
+	s.stop <- true
+}
@@ -0,0 +1,113 @@
+package transformers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestTunneling_UniqSubdomains(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.TunnelingDetector.Enable = true
+	config.TunnelingDetector.MinMessages = 10
+	config.TunnelingDetector.ThresholdUniqSubdomains = 20
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 10)}
+
+	// init subproccesor
+	tunneling := NewTunnelingSubprocessor(config, log, "test", outChans)
+
+	// a client sends data encoded in the subdomains
+	for i := 0; i < 50; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = fmt.Sprintf("%x.t.example.com", i*7919)
+		tunneling.Record(&dm)
+	}
+
+	// a normal client
+	for i := 0; i < 50; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.NetworkInfo.QueryIp = "10.0.0.2"
+		dm.DNS.Qname = "www.example.com"
+		tunneling.Record(&dm)
+	}
+
+	tunneling.Flush()
+	if len(outChans[0]) != 1 {
+		t.Fatalf("one alert expected, got %d", len(outChans[0]))
+	}
+	alert := <-outChans[0]
+	if alert.NetworkInfo.QueryIp != "1.2.3.4" || alert.Tunneling.Domain != "example.com" ||
+		alert.Tunneling.Messages != 50 || alert.Tunneling.UniqSubdomains != 21 {
+		t.Errorf("invalid alert: %s %+v", alert.NetworkInfo.QueryIp, alert.Tunneling)
+	}
+
+	// the alert is not recorded again and a new interval starts
+	tunneling.Record(&alert)
+	tunneling.Flush()
+	if len(outChans[0]) != 0 {
+		t.Errorf("no alert expected, got %d", len(outChans[0]))
+	}
+}
+
+func TestTunneling_TxtNullRatio(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.TunnelingDetector.Enable = true
+	config.TunnelingDetector.MinMessages = 10
+	config.TunnelingDetector.ThresholdTxtNullRatio = 0.5
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 10)}
+
+	// init subproccesor
+	tunneling := NewTunnelingSubprocessor(config, log, "test", outChans)
+
+	for i := 0; i < 20; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = "tunnel.example.com"
+		if i%4 != 0 {
+			dm.DNS.Qtype = "TXT"
+		}
+		tunneling.Record(&dm)
+	}
+
+	tunneling.Flush()
+	if len(outChans[0]) != 1 {
+		t.Fatalf("one alert expected, got %d", len(outChans[0]))
+	}
+	alert := <-outChans[0]
+	if alert.Tunneling.TxtNullRatio != 0.75 {
+		t.Errorf("invalid alert: %+v", alert.Tunneling)
+	}
+}
+
+func TestTunneling_MinMessages(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.TunnelingDetector.Enable = true
+	config.TunnelingDetector.MinMessages = 50
+	config.TunnelingDetector.ThresholdAvgLength = 100
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 10)}
+
+	// init subproccesor
+	tunneling := NewTunnelingSubprocessor(config, log, "test", outChans)
+
+	// large messages but not enough traffic
+	for i := 0; i < 10; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Length = 500
+		tunneling.Record(&dm)
+	}
+
+	tunneling.Flush()
+	if len(outChans[0]) != 0 {
+		t.Errorf("no alert expected, got %d", len(outChans[0]))
+	}
+}
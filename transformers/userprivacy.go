@@ -1,54 +1,98 @@
 package transformers
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"strings"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
-	"golang.org/x/net/publicsuffix"
-)
-
-var (
-	defaultIPv4Mask = net.IPv4Mask(255, 255, 0, 0)                                                       // /24
-	defaultIPv6Mask = net.IPMask{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0} // /64
 )
 
 type UserPrivacyProcessor struct {
 	config *dnsutils.ConfigTransformers
 	v4Mask net.IPMask
 	v6Mask net.IPMask
+	cipher cipher.Block
+	pad    [aes.BlockSize]byte
 }
 
 func NewUserPrivacySubprocessor(config *dnsutils.ConfigTransformers) UserPrivacyProcessor {
 	s := UserPrivacyProcessor{
 		config: config,
-		v4Mask: defaultIPv4Mask,
-		v6Mask: defaultIPv6Mask,
+		v4Mask: net.CIDRMask(config.UserPrivacy.AnonymizeV4Bits, 32),
+		v6Mask: net.CIDRMask(config.UserPrivacy.AnonymizeV6Bits, 128),
+	}
+
+	// the prefix lengths are checked with the config, the defaults are used if invalid
+	if s.v4Mask == nil {
+		s.v4Mask = net.CIDRMask(16, 32)
+	}
+	if s.v6Mask == nil {
+		s.v6Mask = net.CIDRMask(64, 128)
+	}
+
+	if config.UserPrivacy.PseudonymizeIP && len(config.UserPrivacy.PseudonymizeKey) > 0 {
+		key := s.pseudonymizeKey()
+		s.cipher, _ = aes.NewCipher(key[:aes.BlockSize])
+		s.cipher.Encrypt(s.pad[:], key[aes.BlockSize:])
 	}
 
 	return s
 }
 
+// pseudonymizeKey returns the 32 bytes key of crypto-pan, the key is provided in hexadecimal
+// or derived from a passphrase
+func (s *UserPrivacyProcessor) pseudonymizeKey() []byte {
+	key, err := hex.DecodeString(s.config.UserPrivacy.PseudonymizeKey)
+	if err == nil && len(key) == 32 {
+		return key
+	}
+	sum := sha256.Sum256([]byte(s.config.UserPrivacy.PseudonymizeKey))
+	return sum[:]
+}
+
+// IsExempt returns true if the qname is one of the exempted domains or one of their subdomains,
+// the names are compared case-insensitively
+func (s *UserPrivacyProcessor) IsExempt(qname string) bool {
+	qname = strings.ToLower(strings.TrimSuffix(qname, "."))
+	for _, domain := range s.config.UserPrivacy.ExemptDomains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if qname == domain || strings.HasSuffix(qname, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *UserPrivacyProcessor) MinimazeQname(qname string) string {
-	if etpo, err := publicsuffix.EffectiveTLDPlusOne(qname); err == nil {
+	if s.IsExempt(qname) {
+		return qname
+	}
+
+	if etpo, err := dnsutils.GetEffectiveTLDPlusOne(qname); err == nil {
 		return etpo
 	}
 
 	return qname
 }
 
+// AnonymizeIP keeps the network part of the ip, /16 by default for ipv4 and /64 for ipv6,
+// the ipv4-mapped ipv6 addresses are masked as ipv4
 func (s *UserPrivacyProcessor) AnonymizeIP(ip string) string {
 	ipaddr := net.ParseIP(ip)
-	isipv4 := strings.LastIndex(ip, ".")
-
-	// ipv4, /16 mask
-	if isipv4 != -1 {
-		return ipaddr.Mask(s.v4Mask).String()
+	if ipaddr == nil {
+		return ip
 	}
 
-	// ipv6, /64 mask
+	if ipv4 := ipaddr.To4(); ipv4 != nil {
+		return ipv4.Mask(s.v4Mask).String()
+	}
 	return ipaddr.Mask(s.v6Mask).String()
 }
 
@@ -57,3 +101,60 @@ func (s *UserPrivacyProcessor) HashIP(ip string) string {
 	hash.Write([]byte(ip))
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
+
+// PseudonymizeIP replaces the ip with the prefix-preserving pseudonymization of crypto-pan,
+// the same ip is always replaced by the same address with the same key and two addresses
+// sharing a prefix keep a common prefix of the same length
+func (s *UserPrivacyProcessor) PseudonymizeIP(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil || s.cipher == nil {
+		return ip
+	}
+	orig := addr.To4()
+	if orig == nil {
+		orig = addr.To16()
+	}
+
+	result := make(net.IP, len(orig))
+	input := make([]byte, aes.BlockSize)
+	output := make([]byte, aes.BlockSize)
+	for i := 0; i < len(orig)*8; i++ {
+		// the first i bits of the address followed by the bits of the pad
+		copy(input, s.pad[:])
+		copy(input, orig[:i/8])
+		if r := i % 8; r > 0 {
+			mask := byte(0xff << (8 - r))
+			input[i/8] = (orig[i/8] & mask) | (s.pad[i/8] &^ mask)
+		}
+		s.cipher.Encrypt(output, input)
+
+		// the bit i is flipped according to the first bit of the encrypted block
+		bit := (output[0] >> 7) ^ ((orig[i/8] >> (7 - i%8)) & 1)
+		result[i/8] |= bit << (7 - i%8)
+	}
+	return result.String()
+}
+
+// HashQname replaces the labels below the public suffix plus the configured depth with their salted
+// hash, www.mail.example.com is replaced by <hash>.<hash>.example.com with a depth of 1.
+// The qname is lowercased, the same label is always replaced by the same hash with the same salt.
+func (s *UserPrivacyProcessor) HashQname(qname string) string {
+	if s.IsExempt(qname) {
+		return qname
+	}
+	qname = strings.ToLower(qname)
+
+	suffix, _ := dnsutils.GetPublicSuffix(qname)
+	labels := strings.Split(qname, ".")
+	kept := strings.Count(suffix, ".") + 1 + s.config.UserPrivacy.HashQnameDepth
+	if len(suffix) == 0 || kept >= len(labels) {
+		return qname
+	}
+
+	for i := 0; i < len(labels)-kept; i++ {
+		mac := hmac.New(sha256.New, []byte(s.config.UserPrivacy.HashQnameSalt))
+		mac.Write([]byte(labels[i]))
+		labels[i] = hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return strings.Join(labels, ".")
+}
@@ -1,6 +1,7 @@
 package transformers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -67,3 +68,143 @@ func TestAnonymizeIPv6(t *testing.T) {
 		t.Errorf("Ipv6 anonymization failed, got %s", ret)
 	}
 }
+
+func TestAnonymizeIPPrefixes(t *testing.T) {
+	// ipv4 /24 and ipv6 /48
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.AnonymizeV4Bits = 24
+	config.UserPrivacy.AnonymizeV6Bits = 48
+
+	userPrivacy := NewUserPrivacySubprocessor(config)
+
+	testcases := map[string]string{
+		"192.168.1.2":                   "192.168.1.0",
+		"::ffff:192.168.1.2":            "192.168.1.0",
+		"2001:db8:85a3:8d3:1319:8a2e::": "2001:db8:85a3::",
+		"-":                             "-",
+	}
+	for ip, want := range testcases {
+		if ret := userPrivacy.AnonymizeIP(ip); ret != want {
+			t.Errorf("%s anonymization failed, want %s, got %s", ip, want, ret)
+		}
+	}
+}
+
+func TestPseudonymizeIP(t *testing.T) {
+	// enable feature with the key of the crypto-pan reference implementation
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.PseudonymizeIP = true
+	config.UserPrivacy.PseudonymizeKey = "1522178d33a4cf80130a5b1649907d10d8988f837979652762574c2d2a842202"
+
+	// init the processor
+	userPrivacy := NewUserPrivacySubprocessor(config)
+
+	testcases := map[string]string{
+		"128.11.68.132":   "135.242.180.132",
+		"129.118.74.4":    "134.136.186.123",
+		"130.132.252.244": "133.68.164.234",
+		"141.223.7.43":    "141.167.8.160",
+	}
+	for ip, want := range testcases {
+		if ret := userPrivacy.PseudonymizeIP(ip); ret != want {
+			t.Errorf("%s pseudonymization failed, want %s got %s", ip, want, ret)
+		}
+	}
+
+	// the prefix is preserved for ipv6
+	ip1 := userPrivacy.PseudonymizeIP("2001:db8:1::1")
+	ip2 := userPrivacy.PseudonymizeIP("2001:db8:1::2")
+	if ip1 == "2001:db8:1::1" || ip1 == ip2 {
+		t.Errorf("Ipv6 pseudonymization failed, got %s and %s", ip1, ip2)
+	}
+	if ip1[:len(ip1)-2] != ip2[:len(ip2)-2] {
+		t.Errorf("Ipv6 prefix not preserved, got %s and %s", ip1, ip2)
+	}
+
+	// the result depends on the key
+	config.UserPrivacy.PseudonymizeKey = "my secret passphrase"
+	userPrivacy = NewUserPrivacySubprocessor(config)
+	if ret := userPrivacy.PseudonymizeIP("128.11.68.132"); ret == "135.242.180.132" || ret == "128.11.68.132" {
+		t.Errorf("pseudonymization with passphrase failed, got %s", ret)
+	}
+
+	// invalid ip
+	if ret := userPrivacy.PseudonymizeIP("-"); ret != "-" {
+		t.Errorf("invalid ip should be kept, got %s", ret)
+	}
+}
+
+func TestHashQname(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.HashQname = true
+	config.UserPrivacy.HashQnameSalt = "salt"
+	config.UserPrivacy.ExemptDomains = []string{"corp.example"}
+
+	// init the processor
+	userPrivacy := NewUserPrivacySubprocessor(config)
+
+	// the public suffix and one label are kept
+	ret := userPrivacy.HashQname("www.mail.google.co.uk")
+	labels := strings.Split(ret, ".")
+	if len(labels) != 5 || strings.Join(labels[2:], ".") != "google.co.uk" {
+		t.Errorf("Qname hashing failed, got %s", ret)
+	}
+	if labels[0] == "www" || labels[1] == "mail" || len(labels[0]) != 16 {
+		t.Errorf("labels not hashed, got %s", ret)
+	}
+
+	// same label, same hash
+	if ret2 := userPrivacy.HashQname("www.google.co.uk"); ret2 != labels[0]+".google.co.uk" {
+		t.Errorf("Qname hashing not consistent, got %s and %s", ret, ret2)
+	}
+
+	// the case is ignored
+	if ret2 := userPrivacy.HashQname("WWW.Google.co.uk"); ret2 != labels[0]+".google.co.uk" {
+		t.Errorf("Qname hashing should ignore the case, got %s", ret2)
+	}
+
+	// nothing to hash
+	for _, qname := range []string{"google.com", "com", "localhost", "host.corp.example", "Host.CORP.example"} {
+		if ret := userPrivacy.HashQname(qname); ret != qname {
+			t.Errorf("Qname %s should not be hashed, got %s", qname, ret)
+		}
+	}
+
+	// the hash depends on the salt
+	config.UserPrivacy.HashQnameSalt = "other"
+	userPrivacy = NewUserPrivacySubprocessor(config)
+	if ret2 := userPrivacy.HashQname("www.google.co.uk"); ret2 == labels[0]+".google.co.uk" {
+		t.Errorf("Qname hashing should depend on the salt, got %s", ret2)
+	}
+
+	// depth
+	config.UserPrivacy.HashQnameDepth = 2
+	userPrivacy = NewUserPrivacySubprocessor(config)
+	ret = userPrivacy.HashQname("www.mail.google.com")
+	if !strings.HasSuffix(ret, ".mail.google.com") || strings.HasPrefix(ret, "www.") {
+		t.Errorf("Qname hashing with depth 2 failed, got %s", ret)
+	}
+}
+
+func TestReduceQnameExempt(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.MinimazeQname = true
+	config.UserPrivacy.ExemptDomains = []string{"corp.example"}
+
+	// init the processor
+	userPrivacy := NewUserPrivacySubprocessor(config)
+
+	if ret := userPrivacy.MinimazeQname("host.corp.example"); ret != "host.corp.example" {
+		t.Errorf("exempted Qname should not be minimized, got %s", ret)
+	}
+	if ret := userPrivacy.MinimazeQname("www.google.com"); ret != "google.com" {
+		t.Errorf("Qname minimization failed, got %s", ret)
+	}
+}